@@ -0,0 +1,296 @@
+package backends
+
+import (
+	"bytes"
+	"sort"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+type ACLDecision uint8
+
+const (
+	// ACLInherit defers to the longest rule with a shorter prefix, or to
+	// the default of the policy.
+	ACLInherit ACLDecision = iota
+	ACLAllow
+	ACLDeny
+)
+
+// ACLRule decides whether keys starting with Prefix may be read and
+// written.
+type ACLRule struct {
+	Prefix []byte
+	Read   ACLDecision
+	Write  ACLDecision
+}
+
+// ACLPolicy decides which keys may be read and written through an ACLDB.
+// The decision for a key is that of the longest rule prefixing it which
+// doesn't inherit it, or the default if none.
+type ACLPolicy struct {
+	Rules []ACLRule
+	// DefaultAllow allows what no rule decides, which is denied otherwise.
+	DefaultAllow bool
+}
+
+type aclOp int
+
+const (
+	aclRead aclOp = iota
+	aclWrite
+)
+
+// aclNode is a node of the prefix trie of a compiled policy.
+type aclNode struct {
+	children map[byte]*aclNode
+	// rule ending at this node, if any
+	rule *ACLRule
+}
+
+type compiledACL struct {
+	root         aclNode
+	defaultAllow bool
+	// keys at which decisions may change: the prefixes of the rules and
+	// their ends, sorted
+	boundaries [][]byte
+}
+
+func compileACL(policy ACLPolicy) *compiledACL {
+	acl := &compiledACL{defaultAllow: policy.DefaultAllow}
+	for i := range policy.Rules {
+		rule := policy.Rules[i]
+		rule.Prefix = append([]byte{}, rule.Prefix...)
+		node := &acl.root
+		for _, b := range rule.Prefix {
+			if node.children == nil {
+				node.children = map[byte]*aclNode{}
+			}
+			child, ok := node.children[b]
+			if !ok {
+				child = &aclNode{}
+				node.children[b] = child
+			}
+			node = child
+		}
+		// later rules for the same prefix override earlier ones
+		if node.rule != nil {
+			if rule.Read == ACLInherit {
+				rule.Read = node.rule.Read
+			}
+			if rule.Write == ACLInherit {
+				rule.Write = node.rule.Write
+			}
+		}
+		node.rule = &rule
+		acl.boundaries = append(acl.boundaries, rule.Prefix)
+		if end := prefixEnd(rule.Prefix); end != nil {
+			acl.boundaries = append(acl.boundaries, end)
+		}
+	}
+	sort.Slice(acl.boundaries, func(i, j int) bool {
+		return bytes.Compare(acl.boundaries[i], acl.boundaries[j]) < 0
+	})
+	return acl
+}
+
+func (r *ACLRule) decision(op aclOp) ACLDecision {
+	if op == aclWrite {
+		return r.Write
+	}
+	return r.Read
+}
+
+// check returns whether op is allowed on key, and the rule deciding it if
+// any.
+func (acl *compiledACL) check(op aclOp, key []byte) (bool, *ACLRule) {
+	var deciding *ACLRule
+	node := &acl.root
+	for i := 0; ; i++ {
+		if node.rule != nil && node.rule.decision(op) != ACLInherit {
+			deciding = node.rule
+		}
+		if i == len(key) {
+			break
+		}
+		if node = node.children[key[i]]; node == nil {
+			break
+		}
+	}
+	if deciding == nil {
+		return acl.defaultAllow, nil
+	}
+	return deciding.decision(op) == ACLAllow, deciding
+}
+
+// checkRange returns whether op is allowed on every key of [start, end),
+// and otherwise the rule denying it, if any. Decisions only change at rule
+// boundaries, so checking start and the boundaries within the range is
+// enough.
+func (acl *compiledACL) checkRange(op aclOp, start, end []byte) (bool, []byte, *ACLRule) {
+	if allowed, rule := acl.check(op, start); !allowed {
+		return false, start, rule
+	}
+	i := sort.Search(len(acl.boundaries), func(i int) bool {
+		return bytes.Compare(acl.boundaries[i], start) > 0
+	})
+	for ; i < len(acl.boundaries); i++ {
+		boundary := acl.boundaries[i]
+		if end != nil && bytes.Compare(boundary, end) >= 0 {
+			break
+		}
+		if allowed, rule := acl.check(op, boundary); !allowed {
+			return false, boundary, rule
+		}
+	}
+	return true, nil, nil
+}
+
+// ACLDB wraps a DB, enforcing an ACLPolicy on every operation. Iterators
+// are only created over ranges whose keys may all be read.
+type ACLDB struct {
+	dbm.DB
+	acl *compiledACL
+}
+
+var _ dbm.DB = (*ACLDB)(nil)
+
+func NewACLDB(db dbm.DB, policy ACLPolicy) *ACLDB {
+	return &ACLDB{DB: db, acl: compileACL(policy)}
+}
+
+// Unwrap implements Unwrapper.
+func (db *ACLDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+func (db *ACLDB) check(op aclOp, name string, key []byte) error {
+	if allowed, rule := db.acl.check(op, key); !allowed {
+		return newErrACLViolation(name, key, rule)
+	}
+	return nil
+}
+
+func newErrACLViolation(name string, key []byte, rule *ACLRule) error {
+	err := &ErrACLViolation{op: name, key: append([]byte{}, key...)}
+	if rule != nil {
+		err.rule, err.hasRule = rule.Prefix, true
+	}
+	return err
+}
+
+// Get implements DB.
+func (db *ACLDB) Get(key []byte) ([]byte, error) {
+	if err := db.check(aclRead, "Get", key); err != nil {
+		return nil, err
+	}
+	return db.DB.Get(key)
+}
+
+// Has implements DB.
+func (db *ACLDB) Has(key []byte) (bool, error) {
+	if err := db.check(aclRead, "Has", key); err != nil {
+		return false, err
+	}
+	return db.DB.Has(key)
+}
+
+// Set implements DB.
+func (db *ACLDB) Set(key []byte, value []byte) error {
+	if err := db.check(aclWrite, "Set", key); err != nil {
+		return err
+	}
+	return db.DB.Set(key, value)
+}
+
+// SetSync implements DB.
+func (db *ACLDB) SetSync(key []byte, value []byte) error {
+	if err := db.check(aclWrite, "SetSync", key); err != nil {
+		return err
+	}
+	return db.DB.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (db *ACLDB) Delete(key []byte) error {
+	if err := db.check(aclWrite, "Delete", key); err != nil {
+		return err
+	}
+	return db.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (db *ACLDB) DeleteSync(key []byte) error {
+	if err := db.check(aclWrite, "DeleteSync", key); err != nil {
+		return err
+	}
+	return db.DB.DeleteSync(key)
+}
+
+func (db *ACLDB) checkIterator(name string, start, end []byte) error {
+	if start == nil {
+		start = []byte{}
+	}
+	if allowed, key, rule := db.acl.checkRange(aclRead, start, end); !allowed {
+		return newErrACLViolation(name, key, rule)
+	}
+	return nil
+}
+
+// Iterator implements DB.
+func (db *ACLDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	if err := db.checkIterator("Iterator", start, end); err != nil {
+		return nil, err
+	}
+	return db.DB.Iterator(start, end)
+}
+
+// ReverseIterator implements DB.
+func (db *ACLDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	if err := db.checkIterator("ReverseIterator", start, end); err != nil {
+		return nil, err
+	}
+	return db.DB.ReverseIterator(start, end)
+}
+
+// NewBatch implements DB. Denied writes fail when added to the batch, and
+// make writing the batch fail too, so that it is never partially applied.
+func (db *ACLDB) NewBatch() dbm.Batch {
+	return &aclBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type aclBatch struct {
+	dbm.Batch
+	db  *ACLDB
+	err error
+}
+
+func (b *aclBatch) Set(key, value []byte) error {
+	if err := b.db.check(aclWrite, "Batch.Set", key); err != nil {
+		b.err = err
+		return err
+	}
+	return b.Batch.Set(key, value)
+}
+
+func (b *aclBatch) Delete(key []byte) error {
+	if err := b.db.check(aclWrite, "Batch.Delete", key); err != nil {
+		b.err = err
+		return err
+	}
+	return b.Batch.Delete(key)
+}
+
+func (b *aclBatch) Write() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.Batch.Write()
+}
+
+func (b *aclBatch) WriteSync() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.Batch.WriteSync()
+}
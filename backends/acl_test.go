@@ -0,0 +1,137 @@
+package backends
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// indexer may read everything but consensus state, and only write its own
+// keys
+var indexerPolicy = ACLPolicy{
+	Rules: []ACLRule{
+		{Prefix: []byte(""), Read: ACLAllow},
+		{Prefix: []byte("consensus/"), Read: ACLDeny},
+		{Prefix: []byte("consensus/public/"), Read: ACLAllow},
+		{Prefix: []byte("txindex/"), Write: ACLAllow},
+	},
+}
+
+// requireACLViolation checks that err denies op by the rule on prefix rule,
+// or by default if empty.
+func requireACLViolation(t *testing.T, err error, op string, rule string) {
+	violation := &ErrACLViolation{}
+	require.ErrorAs(t, err, &violation)
+	require.Equal(t, op, violation.Op())
+	prefix, ok := violation.Rule()
+	require.Equal(t, rule != "", ok)
+	require.Equal(t, rule, string(prefix))
+}
+
+func TestACLDB(t *testing.T) {
+	inner := dbm.NewMemDB()
+	require.Nil(t, inner.Set([]byte("consensus/state"), []byte("1")))
+	db := NewACLDB(inner, indexerPolicy)
+
+	require.Nil(t, db.Set([]byte("txindex/a"), []byte("tx")))
+	requireACLViolation(t, db.Set([]byte("consensus/state"), []byte("2")), "Set", "")
+	requireACLViolation(t, db.Delete([]byte("txindex")), "Delete", "")
+
+	_, err := db.Get([]byte("consensus/state"))
+	requireACLViolation(t, err, "Get", "consensus/")
+	_, err = db.Has([]byte("consensus/public/key"))
+	require.Nil(t, err)
+	value, err := db.Get([]byte("txindex/a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("tx"), value)
+
+	value, err = inner.Get([]byte("consensus/state"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), value)
+}
+
+func TestACLDBDefaultAllow(t *testing.T) {
+	db := NewACLDB(dbm.NewMemDB(), ACLPolicy{
+		Rules:        []ACLRule{{Prefix: []byte("consensus/"), Write: ACLDeny}},
+		DefaultAllow: true,
+	})
+	require.Nil(t, db.Set([]byte("a"), []byte("1")))
+	requireACLViolation(t, db.Set([]byte("consensus/a"), []byte("1")), "Set", "consensus/")
+	_, err := db.Get([]byte("consensus/a"))
+	require.Nil(t, err)
+}
+
+func TestACLDBIteratorRanges(t *testing.T) {
+	db := NewACLDB(dbm.NewMemDB(), indexerPolicy)
+	for _, tc := range []struct {
+		start, end string
+		// key at which the range is denied, if any
+		deniedAt string
+		rule     string
+	}{
+		{"a", "b", "", ""},
+		{"txindex/", "txindex0", "", ""},
+		// ends right where consensus state starts
+		{"b", "consensus/", "", ""},
+		{"b", "consensus/a", "consensus/", "consensus/"},
+		{"consensus/public/", "consensus/public0", "", ""},
+		{"consensus/public/z", "consensus/q", "consensus/public0", "consensus/"},
+		{"consensus/z", "d", "consensus/z", "consensus/"},
+		// starts right where consensus state ends
+		{"consensus0", "d", "", ""},
+		{"", "", "consensus/", "consensus/"},
+	} {
+		var start, end []byte
+		if tc.start != "" {
+			start = []byte(tc.start)
+		}
+		if tc.end != "" {
+			end = []byte(tc.end)
+		}
+		for _, reverse := range []bool{false, true} {
+			var iter dbm.Iterator
+			var err error
+			if reverse {
+				iter, err = db.ReverseIterator(start, end)
+			} else {
+				iter, err = db.Iterator(start, end)
+			}
+			if tc.deniedAt == "" {
+				require.Nil(t, err, "%q-%q", tc.start, tc.end)
+				require.Nil(t, iter.Close())
+				continue
+			}
+			violation := &ErrACLViolation{}
+			require.ErrorAs(t, err, &violation, "%q-%q", tc.start, tc.end)
+			require.Equal(t, tc.deniedAt, string(violation.key))
+			rule, _ := violation.Rule()
+			require.Equal(t, tc.rule, string(rule))
+		}
+	}
+}
+
+func TestACLDBBatch(t *testing.T) {
+	inner := dbm.NewMemDB()
+	db := NewACLDB(inner, indexerPolicy)
+
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("txindex/a"), []byte("1")))
+	requireACLViolation(t, batch.Delete([]byte("consensus/state")), "Batch.Delete", "")
+	require.Nil(t, batch.Set([]byte("txindex/b"), []byte("2")))
+	// the whole batch is rejected
+	require.ErrorAs(t, batch.Write(), new(*ErrACLViolation))
+	require.ErrorAs(t, batch.WriteSync(), new(*ErrACLViolation))
+	require.Nil(t, batch.Close())
+	has, err := inner.Has([]byte("txindex/a"))
+	require.Nil(t, err)
+	require.False(t, has)
+
+	batch = db.NewBatch()
+	require.Nil(t, batch.Set([]byte("txindex/a"), []byte("1")))
+	require.Nil(t, batch.Write())
+	require.Nil(t, batch.Close())
+	has, err = inner.Has([]byte("txindex/a"))
+	require.Nil(t, err)
+	require.True(t, has)
+}
@@ -1,9 +1,12 @@
 package backends
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"sort"
+	"strconv"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	dbm "github.com/tendermint/tm-db"
@@ -18,19 +21,31 @@ const (
 type IndexEntry struct {
 	keyPrefix string
 	txId      []byte
+	info      IndexEntryInfo
+	// nil unless the payload has a key filter
+	keyFilterTxId []byte
 }
 
 func NewIndexEntryFromBytes(bz []byte) IndexEntry {
 	keyPrefix := string(bz[:IndexKeyPrefixLen])
-	return IndexEntry{
+	entry := IndexEntry{
 		keyPrefix: keyPrefix,
-		txId:      bz[IndexKeyPrefixLen:],
+		txId:      bz[IndexKeyPrefixLen:IndexEntryLen],
 	}
+	if len(bz) >= IndexEntryWithInfoLen {
+		entry.info = parseIndexEntryInfo(bz[IndexEntryLen:])
+	}
+	if len(bz) == IndexEntryWithKeyFilterLen {
+		if keyFilterTxId := bz[IndexEntryWithInfoLen:]; len(bytes.TrimRight(keyFilterTxId, "\x00")) > 0 {
+			entry.keyFilterTxId = keyFilterTxId
+		}
+	}
+	return entry
 }
 
 // A read-only backend that stores data on Arweave. Each key being
-// queried needs to be prefixed with 8 bytes indicating the version
-// to query for, from an uint64 encoded in big endian format.
+// queried needs to be prefixed with the version to query for, by
+// default an uint64 encoded in big endian format (see VersionCodec).
 // A query is processed in 3 steps:
 // 1. Get the Arweave transaction ID which stores the queried
 //    version's index from a local leveldb
@@ -42,20 +57,96 @@ func NewIndexEntryFromBytes(bz []byte) IndexEntry {
 // To use an iterator, both `start` and `end` need to have to same
 // version prefix.
 type ArweaveDB struct {
-	txDataByIdGetter  func([]byte) ([]byte, error)
-	versionTxIdGetter func([]byte) ([]byte, error)
+	txDataByIdGetter  Getter
+	versionTxIdGetter Getter
 	closer            func() error
-}
-
-var _ dbm.DB = (*ArweaveDB)(nil)
+	client            *Client
+	indexPath         string
+
+	resolveValueRefs bool
+	retractions      *retractions
+	iteratorBudget   *iteratorBudget
+	maxDecompressed  int64
+	payloadBounds    *payloadBoundsCache
+	versionMap       *versionMap
+	gatewayPool      *GatewayPool
+	downloadBudget   *DownloadBudget
+	rateLimiter      *rateLimiter
+	readOptions      ReadOptions
+	strict           bool
+	versionCodec     VersionCodec
+	readStats        *readStats
+	signer           AttestationSigner
+	indexValidation  *indexValidation
+	logger           Logger
+	streamingMinSize uint64
+	versionProbes    *versionProbes
+	indexCache       *indexCache
+	decodedCache     *decodedCache
+	decodePool       *decodePool
+	fetchPool        *fetchPool
+	checkpointTrust  *checkpointTrust
+	tracing          *tracing
+	writer           *ArweaveWriter
+	// index a consistent reader is pinned to
+	pinned        *pinnedIndex
+	latestVersion *latestVersion
+	// how long reads wait for versions beyond the latest one
+	futureVersions *futureVersions
+	// expected digests of transaction data, if verified
+	digests DigestResolver
+	// publisher of the indices, if verified
+	ownership *ownership
+	// cache of transaction data, for its statistics
+	txCache *PersistentTxCache
+	metrics MetricsCollector
+
+	// txDataSource and versionSource fetch with a context, below the
+	// getter middlewares txDataMiddleware and versionMiddleware, for views
+	// bound to a context
+	txDataSource      ContextGetter
+	txDataMiddleware  boundMiddleware
+	versionSource     ContextGetter
+	versionMiddleware boundMiddleware
+	// context a view is bound to
+	ctx context.Context
+	// cancelled by Close, shared with views
+	lifecycle *lifecycle
+	// set while NewArweaveDBFromOptions applies options
+	build *arweaveBuild
+	// counters of Stats, shared with views
+	opStats *opStats
+	// trace ID of the operation a view is made for
+	traceID string
+}
+
+// ReadOptions tune the reads made through a view returned by
+// WithReadOptions.
+type ReadOptions struct {
+	// BudgetExempt lets fetches through once the download budget is
+	// exhausted.
+	BudgetExempt bool
+	// Revalidate makes fetches served from a conditional cache check with
+	// the gateway that cached data is still current, see
+	// WithConditionalCache.
+	Revalidate bool
+	// Preference routes reads of a TieredDB between its tiers, overriding
+	// its default one unless PreferDefault. ArweaveDBs ignore it.
+	Preference ReadPreference
+}
+
+var (
+	_ dbm.DB        = (*ArweaveDB)(nil)
+	_ ReadAccounter = (*ArweaveDB)(nil)
+)
 
-func NewArweaveDB(indexDBFullPath string, arweaveNodeURL string) (*ArweaveDB, error) {
+func NewArweaveDB(indexDBFullPath string, arweaveNodeURL string, opts ...ArweaveOption) (*ArweaveDB, error) {
 	indexDB, err := leveldb.OpenFile(indexDBFullPath, nil)
 	if err != nil {
 		return nil, err
 	}
 	arweaveClient := NewClient(arweaveNodeURL)
-	return &ArweaveDB{
+	db := &ArweaveDB{
 		txDataByIdGetter: func(txId []byte) ([]byte, error) {
 			return arweaveClient.DownloadChunkData(string(txId))
 		},
@@ -65,27 +156,133 @@ func NewArweaveDB(indexDBFullPath string, arweaveNodeURL string) (*ArweaveDB, er
 		closer: func() error {
 			return indexDB.Close()
 		},
-	}, nil
+		client:        arweaveClient,
+		indexPath:     indexDBFullPath,
+		versionProbes: newVersionProbes(DefaultVersionProbeNegativeTTL),
+		latestVersion: &latestVersion{},
+		lifecycle:     newLifecycle(),
+		opStats:       newOpStats(),
+	}
+	db.txDataSource = func(ctx context.Context, txId []byte) ([]byte, error) {
+		return arweaveClient.DownloadChunkDataContext(ctx, string(txId))
+	}
+	db.versionSource = IgnoringContext(db.versionTxIdGetter)
+	db.bindSources()
+	for _, opt := range opts {
+		opt(db)
+	}
+	emitEvent(EventOpened, "arweave", indexDBFullPath, "")
+	return db, nil
+}
+
+// NewArweaveDBWithGetters returns an ArweaveDB fetching transaction data and
+// the index transaction IDs of versions with the given getters, e.g. to read
+// fixtures or mirrors instead of Arweave. Getters report absent entries with
+// an ErrKeyNotFound.
+//
+// Deprecated: Use NewArweaveDBFromOptions with WithTxDataGetter and
+// WithVersionResolver, which validates the options.
+func NewArweaveDBWithGetters(txDataByIdGetter Getter, versionTxIdGetter Getter, opts ...ArweaveOption) *ArweaveDB {
+	db := newBaseArweaveDB()
+	db.txDataByIdGetter, db.versionTxIdGetter = txDataByIdGetter, versionTxIdGetter
+	db.txDataSource, db.versionSource = IgnoringContext(txDataByIdGetter), IgnoringContext(versionTxIdGetter)
+	db.bindSources()
+	for _, opt := range opts {
+		opt(db)
+	}
+	return db
+}
+
+// newBaseArweaveDB returns an ArweaveDB without getters, closing nothing.
+func newBaseArweaveDB() *ArweaveDB {
+	return &ArweaveDB{
+		closer:        func() error { return nil },
+		versionProbes: newVersionProbes(DefaultVersionProbeNegativeTTL),
+		latestVersion: &latestVersion{},
+		lifecycle:     newLifecycle(),
+		opStats:       newOpStats(),
+	}
 }
 
 func NewEmptyArweaveDB() *ArweaveDB {
-	return &ArweaveDB{}
+	return &ArweaveDB{lifecycle: newLifecycle(), opStats: newOpStats()}
 }
 
-// Get implements DB.
+// WithReadOptions returns a view of db reading with opts. The view shares
+// the state of db and must not be closed.
+func (db *ArweaveDB) WithReadOptions(opts ReadOptions) *ArweaveDB {
+	view := *db
+	view.readOptions = opts
+	if opts.Revalidate != db.readOptions.Revalidate {
+		view.bindContext(view.context())
+	}
+	return &view
+}
+
+// Get implements DB. Keys shorter than a version, or whose version is all
+// 0xFF bytes, read the latest version, see GetLatestVersion.
 func (db *ArweaveDB) Get(key []byte) ([]byte, error) {
-	txIds, err := db.getArweaveTxIds(key)
+	view, done := db.startOp("get")
+	value, err := view.get(key)
+	return value, done(err)
+}
+
+func (db *ArweaveDB) get(key []byte) ([]byte, error) {
+	if err := db.closedErr(); err != nil {
+		return nil, err
+	}
+	version, key, err := db.resolveKey(key)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := db.getArweaveEntries(version, key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := db.getKeyByEntries(version, key, entries)
 	if err != nil {
 		return nil, err
 	}
-	return db.getKeyByTxIds(key, txIds)
+	return db.resolveValue(value)
+}
+
+// GetWithBytesRead implements ReadAccounter. Bytes read are those of the
+// index, payloads and referenced values fetched from Arweave.
+func (db *ArweaveDB) GetWithBytesRead(key []byte) ([]byte, uint64, error) {
+	view, done := db.startOp("get")
+	bytesRead := uint64(0)
+	counted := *view
+	counted.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		data, err := view.txDataByIdGetter(txId)
+		bytesRead += uint64(len(data))
+		return data, err
+	}
+	value, err := counted.get(key)
+	return value, bytesRead, done(err)
 }
 
-// Has implements DB.
+// Has implements DB. See Get for keys without a version.
 func (db *ArweaveDB) Has(key []byte) (bool, error) {
-	txIds, err := db.getArweaveTxIds(key)
+	view, done := db.startOp("has")
+	has, err := view.has(key)
+	return has, done(err)
+}
+
+func (db *ArweaveDB) has(key []byte) (bool, error) {
+	if err := db.closedErr(); err != nil {
+		return false, err
+	}
+	version, key, err := db.resolveKey(key)
+	if err != nil {
+		return false, err
+	}
+	entries, err := db.getArweaveEntries(version, key)
 	if err == nil {
-		_, err = db.getKeyByTxIds(key, txIds)
+		// payloads ruled out by their key filter aren't fetched
+		if entries = db.filterEntriesByKey(key, entries); len(entries) == 0 {
+			return false, nil
+		}
+		_, err = db.getKeyByEntries(version, key, entries)
 	}
 	if err != nil {
 		if _, ok := err.(*ErrKeyNotFound); ok {
@@ -116,9 +313,26 @@ func (db *ArweaveDB) DeleteSync(key []byte) error {
 	panic("Arweave backend is read-only")
 }
 
-// Close implements DB.
+// Close implements DB. Reads in flight, including those of views and
+// iterators, fail with ErrDBClosed without waiting for their fetches, as do
+// later ones. Closing again returns the result of the first Close.
 func (db *ArweaveDB) Close() error {
-	return db.closer()
+	if db.lifecycle == nil {
+		return db.close()
+	}
+	db.lifecycle.once.Do(func() {
+		db.lifecycle.cancel()
+		db.lifecycle.err = db.close()
+	})
+	return db.lifecycle.err
+}
+
+func (db *ArweaveDB) close() error {
+	if err := db.closer(); err != nil {
+		return err
+	}
+	emitEvent(EventClosed, "arweave", db.indexPath, "")
+	return nil
 }
 
 // Print implements DB.
@@ -129,42 +343,108 @@ func (db *ArweaveDB) Print() error {
 // Stats implements DB.
 func (db *ArweaveDB) Stats() map[string]string {
 	stats := make(map[string]string)
+	if db.iteratorBudget != nil {
+		stats["iterator_budget"] = strconv.FormatInt(db.iteratorBudget.limit, 10)
+		stats["iterator_budget_used"] = strconv.FormatInt(db.iteratorBudget.usage(), 10)
+	}
+	if db.gatewayPool != nil {
+		db.gatewayPool.stats(stats)
+	}
+	if db.downloadBudget != nil {
+		db.downloadBudget.stats(stats)
+	}
+	db.payloadCacheStats(stats)
+	db.addOpStats(stats)
 	return stats
 }
 
-// NewBatch implements DB.
-func (db *ArweaveDB) NewBatch() dbm.Batch {
-	panic("Arweave backend is read-only")
-}
-
-// Iterator implements DB.
+// IteratorOptions tune the iterators returned by IteratorWithOptions.
+type IteratorOptions struct {
+	Reverse bool
+	// InclusiveEnd includes end in the range of the iterator.
+	InclusiveEnd bool
+	// KeysOnly makes Value return nil without decoding values.
+	KeysOnly bool
+	// Gaps decides how payloads failing to load are handled, failing the
+	// iterator by default.
+	Gaps GapPolicy
+	// GapRetries is how GapRetryThenSkip retries payloads, defaulting to
+	// DefaultFetchRetryPolicy.
+	GapRetries FetchRetryPolicy
+}
+
+// Iterator implements DB. Either start or end may be nil, in which case the
+// range is unbounded on that side within the version of the other one. See
+// Get for bounds without a version.
 func (db *ArweaveDB) Iterator(start, end []byte) (dbm.Iterator, error) {
-	return newArweaveDBIterator(start, end, db, false)
+	return db.IteratorWithOptions(start, end, IteratorOptions{})
 }
 
-// ReverseIterator implements DB.
+// ReverseIterator implements DB. See Iterator for nil bounds.
 func (db *ArweaveDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
-	return newArweaveDBIterator(start, end, db, true)
+	return db.IteratorWithOptions(start, end, IteratorOptions{Reverse: true})
+}
+
+// IteratorWithOptions returns an iterator like Iterator or ReverseIterator,
+// tuned by opts.
+func (db *ArweaveDB) IteratorWithOptions(start, end []byte, opts IteratorOptions) (dbm.Iterator, error) {
+	view, done := db.startOp("iterator")
+	iter, err := newArweaveDBIterator(start, end, view, opts)
+	if err != nil {
+		return nil, done(err)
+	}
+	done(nil)
+	db.collector().OnIteratorCreated()
+	return iter, nil
 }
 
-func (db *ArweaveDB) getKeyByTxIds(key []byte, txIds [][]byte) ([]byte, error) {
-	key = key[8:]
-	for _, txId := range txIds {
-		keyvalues, err := db.getTxDataAsMap(txId)
+func (db *ArweaveDB) getKeyByEntries(version uint64, key []byte, entries []IndexEntry) (string, error) {
+	var value string
+	var foundIn []string
+	for _, entry := range entries {
+		raw, ok, err := db.getEntryValue(entry, string(key))
 		if err != nil {
-			return nil, err
+			return "", withPayloadContext(err, version, entry)
 		}
-		if val, ok := keyvalues[string(key)]; ok {
-			return []byte(val.(string)), nil
+		if !ok {
+			continue
+		}
+		if len(foundIn) == 0 {
+			if value, err = db.decodeValue(string(key), entry.txId, raw); err != nil {
+				return "", err
+			}
+			db.recordServed(entry, string(key), value)
+		}
+		foundIn = append(foundIn, string(entry.txId))
+		// only strict mode looks for duplicates
+		if !db.strict {
+			break
 		}
 	}
-	return nil, &ErrKeyNotFound{string(key)}
+	if len(foundIn) > 1 {
+		return "", &ErrDuplicateKey{key: string(key), txIds: foundIn}
+	}
+	if len(foundIn) == 0 {
+		return "", newKeyNotFoundInVersion(key, version, entries)
+	}
+	return value, nil
 }
 
-func (db *ArweaveDB) getTxDataAsMap(txId []byte) (map[string]interface{}, error) {
-	txData, err := db.txDataByIdGetter(txId)
-	if err != nil {
-		return nil, err
+// withPayloadContext adds version and the tx ID of entry to err if it is
+// the ErrKeyNotFound of a getter missing the payload of entry.
+func withPayloadContext(err error, version uint64, entry IndexEntry) error {
+	notFound := &ErrKeyNotFound{}
+	if !errors.As(err, &notFound) || notFound.hasVersion {
+		return err
+	}
+	return &ErrKeyNotFound{key: notFound.key, version: version, hasVersion: true, txIds: []string{string(entry.txId)}}
+}
+
+// decodePayload decodes a JSON or binary payload, telling them apart by
+// their first byte.
+func decodePayload(txData []byte) (map[string]interface{}, error) {
+	if isBinaryPayload(txData) {
+		return decodeBinaryPayload(txData)
 	}
 	keyvalues := map[string]interface{}{}
 	if err := json.Unmarshal(txData, &keyvalues); err != nil {
@@ -173,175 +453,398 @@ func (db *ArweaveDB) getTxDataAsMap(txId []byte) (map[string]interface{}, error)
 	return keyvalues, nil
 }
 
-// First 8 bytes of key is version in big endian.
 // Since we take a constant sized (128 bytes) prefix as range in
 // the index, it's possible for some hot prefixes to have multiple
 // entries in the index, so we need to be able to return multiple
-// Tx IDs here.
-func (db *ArweaveDB) getArweaveTxIds(key []byte) ([][]byte, error) {
-	version := key[:8]
+// entries here.
+func (db *ArweaveDB) getArweaveEntries(version uint64, key []byte) ([]IndexEntry, error) {
 	index, err := db.getIndex(version)
 	if err != nil {
 		return nil, err
 	}
-	keyString := string(key[8:])
-	entries := getIndexEntries(keyString, index)
-	res := [][]byte{}
-	for _, entry := range entries {
-		res = append(res, entry.txId)
+	return lookupIndexEntries(string(key), index), nil
+}
+
+// getIndex returns the parsed index of version, from the index cache if
+// the DB has one.
+func (db *ArweaveDB) getIndex(version uint64) ([]IndexEntry, error) {
+	if db.pinned != nil {
+		return db.pinned.index(version)
+	}
+	if db.indexCache == nil {
+		return db.loadIndex(version)
 	}
-	return res, nil
+	loaded := false
+	entries, err := db.indexCache.get(version, func() ([]IndexEntry, error) {
+		loaded = true
+		return db.loadIndex(version)
+	})
+	db.recordCacheLookup(CacheIndex, !loaded)
+	return entries, err
 }
 
-func (db *ArweaveDB) getIndex(version []byte) ([]byte, error) {
-	indexTxId, err := db.versionTxIdGetter(version)
+func (db *ArweaveDB) loadIndex(version uint64) ([]IndexEntry, error) {
+	indexTxId, index, err := db.fetchIndex(version)
 	if err != nil {
 		return nil, err
 	}
-	return db.txDataByIdGetter(indexTxId)
+	if index, err = db.validatedIndex(version, indexTxId, index); err != nil {
+		return nil, err
+	}
+	return parseIndex(index), nil
 }
 
-// TODO: change to binary search
-func getIndexEntries(keyString string, index []byte) []IndexEntry {
-	res := []IndexEntry{}
-	for i := 0; i < len(index); i += IndexEntryLen {
-		indexEntry := NewIndexEntryFromBytes(index[i : i+IndexEntryLen])
-		if len(res) > 0 {
-			if res[0].keyPrefix == indexEntry.keyPrefix {
-				res = append(res, indexEntry)
-			} else {
-				break
-			}
-		} else if keyString <= indexEntry.keyPrefix {
-			res = append(res, indexEntry)
-		}
+// fetchIndex returns the index of version as published, along with its tx
+// ID.
+func (db *ArweaveDB) fetchIndex(version uint64) ([]byte, []byte, error) {
+	indexTxId, err := db.getIndexTxId(version)
+	if err != nil && isVersionNotFound(err) {
+		indexTxId, err = db.resolveFutureVersion(version, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := db.verifyIndexOwner(indexTxId); err != nil {
+		return nil, nil, err
+	}
+	index, err := db.fetchTxData(FetchIndex, indexTxId)
+	if err == nil {
+		index, err = decompressIndex(indexTxId, index, db.decompressionLimit())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateIndexHeader(index); err != nil {
+		return nil, nil, err
+	}
+	if err := db.checkIndex(version, index); err != nil {
+		return nil, nil, err
+	}
+	return indexTxId, index, nil
+}
+
+// parseIndex returns the entries of an index with a valid header.
+func parseIndex(index []byte) []IndexEntry {
+	entries, entryLen := splitIndex(index)
+	res := make([]IndexEntry, 0, len(entries)/entryLen)
+	for i := 0; i < len(entries); i += entryLen {
+		res = append(res, NewIndexEntryFromBytes(entries[i:i+entryLen]))
 	}
 	return res
 }
 
-func getIndexEntriesForRange(keyStart string, keyEnd string, index []byte) []IndexEntry {
-	res := []IndexEntry{}
-	reachedEnd := false
-	for i := 0; i < len(index); i += IndexEntryLen {
-		indexEntry := NewIndexEntryFromBytes(index[i : i+IndexEntryLen])
-		if reachedEnd {
-			if res[len(res)-1].keyPrefix == indexEntry.keyPrefix {
-				res = append(res, indexEntry)
-			} else {
-				break
-			}
-		} else if keyStart <= indexEntry.keyPrefix {
-			res = append(res, indexEntry)
-		}
-		if keyEnd <= indexEntry.keyPrefix {
-			reachedEnd = true
-		}
+func getIndexEntries(keyString string, index []byte) []IndexEntry {
+	i, j := indexEntriesBounds(keyString, rawIndexPrefixes(index))
+	return parseIndexEntries(index, i, j)
+}
+
+// lookupIndexEntries returns the entries which may hold keyString: the first
+// one whose prefix is not before that of the key, along with the following
+// ones of the same prefix.
+func lookupIndexEntries(keyString string, entries []IndexEntry) []IndexEntry {
+	i, j := indexEntriesBounds(keyString, parsedIndexPrefixes(entries))
+	return append([]IndexEntry{}, entries[i:j]...)
+}
+
+// getIndexEntriesForRange returns the entries which may hold keys from
+// keyStart to keyEnd, included or not, up to the last one if keyEnd is nil.
+// Prefixes being padded, keys shorter than IndexKeyPrefixLen sort before the
+// prefix made of them, and so do their payloads.
+func getIndexEntriesForRange(keyStart string, keyEnd []byte, index []byte) []IndexEntry {
+	i, j := indexRangeBounds(keyStart, keyEnd, rawIndexPrefixes(index))
+	return parseIndexEntries(index, i, j)
+}
+
+func lookupIndexEntriesForRange(keyStart string, keyEnd []byte, entries []IndexEntry) []IndexEntry {
+	i, j := indexRangeBounds(keyStart, keyEnd, parsedIndexPrefixes(entries))
+	return append([]IndexEntry{}, entries[i:j]...)
+}
+
+// indexPrefixes gives access to the key prefixes of the entries of an index,
+// in ascending order, without parsing them.
+type indexPrefixes struct {
+	len      int
+	prefixAt func(i int) string
+}
+
+func parsedIndexPrefixes(entries []IndexEntry) indexPrefixes {
+	return indexPrefixes{len: len(entries), prefixAt: func(i int) string {
+		return entries[i].keyPrefix
+	}}
+}
+
+// rawIndexPrefixes returns the prefixes of an index with a valid header, as
+// published.
+func rawIndexPrefixes(index []byte) indexPrefixes {
+	entries, entryLen := splitIndex(index)
+	return indexPrefixes{len: len(entries) / entryLen, prefixAt: func(i int) string {
+		return string(entries[i*entryLen : i*entryLen+IndexKeyPrefixLen])
+	}}
+}
+
+// parseIndexEntries parses the entries from i to j, excluded, of an index
+// with a valid header.
+func parseIndexEntries(index []byte, i, j int) []IndexEntry {
+	entries, entryLen := splitIndex(index)
+	res := make([]IndexEntry, 0, j-i)
+	for ; i < j; i++ {
+		res = append(res, NewIndexEntryFromBytes(entries[i*entryLen:(i+1)*entryLen]))
 	}
 	return res
 }
 
+// search returns the position of the first prefix not before prefix.
+func (p indexPrefixes) search(prefix string) int {
+	return sort.Search(p.len, func(i int) bool {
+		return p.prefixAt(i) >= prefix
+	})
+}
+
+// endOfRun returns the position following the last entry of the same
+// prefix as the one at i, as payloads of a prefix may be split across
+// several transactions.
+func (p indexPrefixes) endOfRun(i int) int {
+	if i >= p.len {
+		return p.len
+	}
+	prefix := p.prefixAt(i)
+	return i + sort.Search(p.len-i, func(j int) bool {
+		return p.prefixAt(i+j) > prefix
+	})
+}
+
+// indexEntriesBounds returns the bounds of the entries which may hold
+// keyString.
+func indexEntriesBounds(keyString string, prefixes indexPrefixes) (int, int) {
+	i := prefixes.search(truncateKeyPrefix(keyString))
+	return i, prefixes.endOfRun(i)
+}
+
+// indexRangeBounds returns the bounds of the entries which may hold keys
+// from keyStart to keyEnd.
+func indexRangeBounds(keyStart string, keyEnd []byte, prefixes indexPrefixes) (int, int) {
+	i := prefixes.search(truncateKeyPrefix(keyStart))
+	if keyEnd == nil {
+		return i, prefixes.len
+	}
+	j := prefixes.endOfRun(prefixes.search(truncateKeyPrefix(string(keyEnd))))
+	if j < i {
+		j = i
+	}
+	return i, j
+}
+
 type arweaveDBIterator struct {
 	db      *ArweaveDB
 	reverse bool
+	version uint64
 
-	start []byte
-	end   []byte
+	// unversioned bounds, end being nil if unbounded
+	start        []byte
+	end          []byte
+	inclusiveEnd bool
+	keysOnly     bool
 
-	txIds             [][]byte
+	entries           []IndexEntry
 	currentTxData     map[string]interface{}
 	currentSortedKeys []string
 	currentKeyIdx     int
 	txIdx             int
 
+	// keys of the payloads loaded for the current index key prefix, in
+	// strict mode
+	groupPrefix string
+	groupKeys   map[string]string
+
+	// position of the last key accounted in the read statistics
+	servedTx  int
+	servedKey int
+
 	finished bool
+	err      error
+	guard    iteratorGuard
+	// payloads read ahead, in iteration order
+	loads   []*payloadLoad
+	fetches *fetchGroup
+	// payloads skipped according to gapPolicy
+	gapPolicy  GapPolicy
+	gapRetries FetchRetryPolicy
+	gaps       []GapInfo
+	// bytes accounted against the DB's iterator budget
+	buffered int64
 }
 
 var _ dbm.Iterator = (*arweaveDBIterator)(nil)
 
-func newArweaveDBIterator(start []byte, end []byte, db *ArweaveDB, reverse bool) (*arweaveDBIterator, error) {
-	if string(start[:8]) != string(end[:8]) {
-		return nil, errors.New("Start and end must be of the same version")
+func newArweaveDBIterator(start []byte, end []byte, db *ArweaveDB, opts IteratorOptions) (iter *arweaveDBIterator, err error) {
+	if err := db.closedErr(); err != nil {
+		return nil, err
 	}
-	version := start[:8]
-	index, err := db.getIndex(version)
+	version, start, end, err := db.splitRange(start, end)
 	if err != nil {
 		return nil, err
 	}
-	start, end = start[8:], end[8:]
-	entries := getIndexEntriesForRange(string(start), string(end), index)
-	txIds := [][]byte{}
-	for _, entry := range entries {
-		txIds = append(txIds, entry.txId)
+	reverse := opts.Reverse
+	if db.iteratorBudget != nil {
+		if err := db.iteratorBudget.admit(); err != nil {
+			return nil, err
+		}
+	}
+	index, err := db.getIndex(version)
+	if err != nil {
+		return nil, err
 	}
+	entries := lookupIndexEntriesForRange(string(start), end, index)
 	txIdx := 0
 	if reverse {
-		txIdx = len(txIds) - 1
+		txIdx = len(entries) - 1
+	}
+	created := &arweaveDBIterator{
+		db:           db,
+		reverse:      reverse,
+		version:      version,
+		start:        start,
+		end:          end,
+		inclusiveEnd: opts.InclusiveEnd,
+		keysOnly:     opts.KeysOnly,
+		gapPolicy:    opts.Gaps,
+		gapRetries:   opts.GapRetries,
+		entries:      entries,
+		txIdx:        txIdx,
+		servedTx:     -1,
 	}
-	iter := &arweaveDBIterator{
-		db:      db,
-		reverse: reverse,
-		start:   start,
-		end:     end,
-		txIds:   txIds,
-		txIdx:   txIdx,
+	if created.gapRetries.MaxAttempts == 0 {
+		created.gapRetries = DefaultFetchRetryPolicy
 	}
+	iter = created
+	// release the buffered bytes if construction fails, including by panic
+	defer func() {
+		if err != nil {
+			created.Close()
+			iter = nil
+		} else if r := recover(); r != nil {
+			created.Close()
+			panic(r)
+		}
+	}()
 	if err := iter.loadTx(); err != nil {
 		return nil, err
 	}
 	if reverse {
-		for string(iter.Key()) >= string(end) {
+		for iter.Valid() && !iter.beforeEnd(iter.currentKey()) {
 			iter.Next()
 		}
 	} else {
-		for string(iter.Key()) < string(start) {
+		for iter.Valid() && iter.currentKey() < string(start) {
 			iter.Next()
 		}
 	}
+	if iter.err != nil {
+		return nil, iter.err
+	}
 	return iter, nil
 }
 
+// splitRange returns the version and the unversioned bounds of the range
+// from start to end. A nil bound takes the version of the other one, and
+// is returned as the empty start or the nil end.
+func (db *ArweaveDB) splitRange(start, end []byte) (version uint64, unversionedStart, unversionedEnd []byte, err error) {
+	if start == nil && end == nil {
+		return 0, nil, nil, errors.New("Start or end must carry a version")
+	}
+	if start != nil {
+		if version, unversionedStart, err = db.resolveKey(start); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if end != nil {
+		endVersion, unversionedEnd, err := db.resolveKey(end)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if start == nil {
+			version, unversionedStart = endVersion, []byte{}
+		} else if version != endVersion {
+			return 0, nil, nil, errors.New("Start and end must be of the same version")
+		}
+		// keep an empty end distinct from an unbounded one
+		return version, unversionedStart, append([]byte{}, unversionedEnd...), nil
+	}
+	return version, unversionedStart, nil, nil
+}
+
+// beforeEnd returns whether key is within the end bound of the iterator.
+func (itr *arweaveDBIterator) beforeEnd(key string) bool {
+	if itr.end == nil {
+		return true
+	}
+	if itr.inclusiveEnd {
+		return key <= string(itr.end)
+	}
+	return key < string(itr.end)
+}
+
+func (itr *arweaveDBIterator) currentKey() string {
+	return itr.currentSortedKeys[itr.currentKeyIdx]
+}
+
 func (itr *arweaveDBIterator) loadTx() error {
 	if itr.finished {
 		return nil
 	}
-	if itr.txIdx >= len(itr.txIds) || itr.txIdx < 0 {
+	if itr.txIdx >= len(itr.entries) || itr.txIdx < 0 {
 		itr.finished = true
 		return nil
 	}
-	data, err := itr.db.getTxDataAsMap(itr.txIds[itr.txIdx])
+	entry := itr.entries[itr.txIdx]
+	if itr.db.isKnownEmpty(entry, itr.start, itr.end, itr.inclusiveEnd) {
+		itr.advanceTx()
+		return itr.loadTx()
+	}
+	data, sortedKeys, skipped, err := itr.loadPayloadOrSkip()
 	if err != nil {
-		return err
+		return withPayloadContext(err, itr.version, entry)
 	}
-	if len(data) == 0 {
-		if itr.reverse {
-			itr.txIdx--
-		} else {
-			itr.txIdx++
+	if skipped {
+		itr.advanceTx()
+		return itr.loadTx()
+	}
+	if itr.db.strict {
+		if err := itr.checkDuplicateKeys(entry, data); err != nil {
+			return err
 		}
+	}
+	if len(data) == 0 {
+		itr.db.recordPayloadBounds(entry.txId, nil)
+		itr.advanceTx()
 		return itr.loadTx()
 	}
 	itr.currentTxData = data
-	itr.currentSortedKeys = make([]string, len(itr.currentTxData))
-	i := 0
-	for k := range itr.currentTxData {
-		itr.currentSortedKeys[i] = k
-		i++
-	}
-	sort.Strings(itr.currentSortedKeys)
+	itr.setBuffered(payloadSize(data))
+	itr.currentSortedKeys = sortedKeys
+	itr.db.recordPayloadBounds(entry.txId, itr.currentSortedKeys)
 	if itr.reverse {
 		itr.currentKeyIdx = len(itr.currentSortedKeys) - 1
-		if string(itr.Key()) < string(itr.start) {
+		if itr.currentKey() < string(itr.start) {
 			itr.finished = true
 		}
 	} else {
 		itr.currentKeyIdx = 0
-		if string(itr.Key()) >= string(itr.end) {
+		if !itr.beforeEnd(itr.currentKey()) {
 			itr.finished = true
 		}
 	}
 	return nil
 }
 
+func (itr *arweaveDBIterator) advanceTx() {
+	if itr.reverse {
+		itr.txIdx--
+	} else {
+		itr.txIdx++
+	}
+}
+
 // Domain implements Iterator.
 func (itr *arweaveDBIterator) Domain() ([]byte, []byte) {
 	return []byte{}, []byte{}
@@ -349,63 +852,96 @@ func (itr *arweaveDBIterator) Domain() ([]byte, []byte) {
 
 // Valid implements Iterator.
 func (itr *arweaveDBIterator) Valid() bool {
-	return !itr.finished
+	return itr.guard.valid(!itr.finished)
 }
 
 // Key implements Iterator.
 func (itr *arweaveDBIterator) Key() []byte {
-	if itr.finished {
-		return nil
-	}
+	itr.guard.assertValid(!itr.finished)
 	return []byte(itr.currentSortedKeys[itr.currentKeyIdx])
 }
 
-// Value implements Iterator.
+// Value implements Iterator. Failures to decode or resolve the value
+// invalidate the iterator like those of Next, nil being returned.
 func (itr *arweaveDBIterator) Value() []byte {
-	if itr.finished {
+	itr.guard.assertValid(!itr.finished)
+	if itr.keysOnly {
+		return nil
+	}
+	key := itr.currentSortedKeys[itr.currentKeyIdx]
+	raw, err := itr.db.decodeValue(key, itr.entries[itr.txIdx].txId, itr.currentTxData[key])
+	if err != nil {
+		itr.fail(err)
+		return nil
+	}
+	itr.recordServed(key, raw)
+	value, err := itr.db.resolveValue(raw)
+	if err != nil {
+		itr.fail(err)
 		return nil
 	}
-	return []byte(itr.currentTxData[itr.currentSortedKeys[itr.currentKeyIdx]].(string))
+	return value
 }
 
-// Next implements Iterator.
+// Next implements Iterator. Payloads are only fetched once reached, and
+// failures to fetch or decode them, including because of a cancelled
+// context or the DB being closed, invalidate the iterator and are reported
+// by Error.
 func (itr *arweaveDBIterator) Next() {
-	if !itr.Valid() {
+	itr.guard.assertValid(!itr.finished)
+	if err := itr.db.closedErr(); err != nil {
+		itr.fail(err)
 		return
 	}
+	if err := itr.next(); err != nil {
+		itr.fail(err)
+	}
+}
+
+// fail stops the iterator, reporting the first error through Error.
+func (itr *arweaveDBIterator) fail(err error) {
+	if itr.err == nil {
+		itr.err = err
+	}
+	itr.finished = true
+}
+
+func (itr *arweaveDBIterator) next() error {
+	if !itr.Valid() {
+		return nil
+	}
 	if itr.reverse {
 		if itr.currentKeyIdx > 0 {
 			itr.currentKeyIdx--
-			if string(itr.Key()) < string(itr.start) {
+			if itr.currentKey() < string(itr.start) {
 				itr.finished = true
 			}
-		} else {
-			itr.txIdx--
-			if err := itr.loadTx(); err != nil {
-				panic(err)
-			}
+			return nil
 		}
 	} else {
 		if itr.currentKeyIdx < len(itr.currentSortedKeys)-1 {
 			itr.currentKeyIdx++
-			if string(itr.Key()) >= string(itr.end) {
+			if !itr.beforeEnd(itr.currentKey()) {
 				itr.finished = true
 			}
-		} else {
-			itr.txIdx++
-			if err := itr.loadTx(); err != nil {
-				panic(err)
-			}
+			return nil
 		}
 	}
+	itr.advanceTx()
+	return itr.loadTx()
 }
 
 // Error implements Iterator.
 func (itr *arweaveDBIterator) Error() error {
-	return nil
+	return itr.err
 }
 
 // Close implements Iterator.
 func (itr *arweaveDBIterator) Close() error {
+	if !itr.guard.close() {
+		return nil
+	}
+	itr.releaseLoads()
+	itr.setBuffered(0)
 	return nil
 }
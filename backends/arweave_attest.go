@@ -0,0 +1,231 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Ed25519AttestationScheme is the scheme of attestations signed by the
+// signers returned by NewEd25519AttestationSigner.
+const Ed25519AttestationScheme = "ed25519"
+
+// AttestationSigner signs the statements of attestations.
+type AttestationSigner interface {
+	// Scheme names the signature scheme, for verifiers to pick it.
+	Scheme() string
+	PublicKey() []byte
+	Sign(statement []byte) ([]byte, error)
+}
+
+// attestationVerifiers verify signatures by scheme.
+var attestationVerifiers = map[string]func(publicKey, statement, signature []byte) bool{
+	Ed25519AttestationScheme: func(publicKey, statement, signature []byte) bool {
+		return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, statement, signature)
+	},
+}
+
+type ed25519AttestationSigner struct {
+	key ed25519.PrivateKey
+}
+
+// NewEd25519AttestationSigner returns a signer signing with key.
+func NewEd25519AttestationSigner(key ed25519.PrivateKey) AttestationSigner {
+	return ed25519AttestationSigner{key: key}
+}
+
+func (s ed25519AttestationSigner) Scheme() string {
+	return Ed25519AttestationScheme
+}
+
+func (s ed25519AttestationSigner) PublicKey() []byte {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+func (s ed25519AttestationSigner) Sign(statement []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, statement), nil
+}
+
+// WithAttestationSigner makes AttestRead sign attestations with signer.
+func WithAttestationSigner(signer AttestationSigner) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if signer == nil {
+			db.invalidOption(errors.New("WithAttestationSigner is given a nil signer"))
+		}
+		db.signer = signer
+	}
+}
+
+// Attestation is a signed statement that a value was read from a payload
+// transaction, pointed to by the index transaction of a version.
+type Attestation struct {
+	Version uint64 `json:"version"`
+	Key     []byte `json:"key"`
+	// ValueHash is the SHA-256 digest of the value as stored in the
+	// payload, i.e. before resolving value references
+	ValueHash []byte `json:"value_hash"`
+	TxId      string `json:"tx_id"`
+	IndexTxId string `json:"index_tx_id"`
+	// Gateway is the URL of the gateway which served the payload, if known
+	Gateway   string    `json:"gateway,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	Scheme    string `json:"scheme"`
+	PublicKey []byte `json:"public_key"`
+	// Signature signs the statement of the attestation
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Statement returns what the signature of the attestation signs: its JSON
+// encoding without the signature.
+func (a Attestation) Statement() ([]byte, error) {
+	a.Signature = nil
+	return json.Marshal(a)
+}
+
+// AttestRead reads key at the given version like Get, and returns a signed
+// attestation of where its value was read from. The payload is fetched
+// again even if cached by middlewares, through the gateway pool if any.
+func (db *ArweaveDB) AttestRead(version uint64, key []byte) (Attestation, error) {
+	if db.signer == nil {
+		return Attestation{}, errors.New("no attestation signer configured")
+	}
+	indexTxId, err := db.getIndexTxId(version)
+	if err != nil {
+		return Attestation{}, err
+	}
+	index, err := db.getIndex(version)
+	if err != nil {
+		return Attestation{}, err
+	}
+	entries := lookupIndexEntries(string(key), index)
+	for _, entry := range entries {
+		payload, gateway, err := db.fetchAttested(entry.txId)
+		if err == nil {
+			payload, err = decompressPayload(entry, payload, db.decompressionLimit())
+		}
+		if err != nil {
+			return Attestation{}, err
+		}
+		keyvalues, err := decodePayload(payload)
+		if err != nil {
+			return Attestation{}, err
+		}
+		raw, ok := keyvalues[string(key)]
+		if !ok {
+			continue
+		}
+		value, err := db.decodeValue(string(key), entry.txId, raw)
+		if err != nil {
+			return Attestation{}, err
+		}
+		digest := sha256.Sum256([]byte(value))
+		att := Attestation{
+			Version:   version,
+			Key:       append([]byte{}, key...),
+			ValueHash: digest[:],
+			TxId:      string(entry.txId),
+			IndexTxId: string(indexTxId),
+			Gateway:   gateway,
+			Timestamp: time.Now().UTC(),
+			Scheme:    db.signer.Scheme(),
+			PublicKey: db.signer.PublicKey(),
+		}
+		statement, err := att.Statement()
+		if err != nil {
+			return Attestation{}, err
+		}
+		if att.Signature, err = db.signer.Sign(statement); err != nil {
+			return Attestation{}, err
+		}
+		return att, nil
+	}
+	return Attestation{}, newKeyNotFoundInVersion(key, version, entries)
+}
+
+// fetchAttested fetches the data of a transaction bypassing middlewares,
+// and returns the URL of the gateway which served it, if known.
+func (db *ArweaveDB) fetchAttested(txId []byte) ([]byte, string, error) {
+	if db.gatewayPool == nil {
+		data, err := db.fetchTxData(FetchData, txId)
+		if db.client != nil {
+			return data, db.client.URL(), err
+		}
+		return data, "", err
+	}
+	var gateway string
+	data, err := db.fetchTxDataWith(FetchData, func(txId []byte) ([]byte, error) {
+		data, url, err := db.gatewayPool.fetch(txId)
+		gateway = url
+		return data, err
+	}, txId)
+	return data, gateway, err
+}
+
+// VerifyAttestation checks the signature of att, then fetches its index and
+// payload transactions with fetch to check that the index points to the
+// payload for its key, and that the payload holds a value with its hash.
+// Whether the public key of att is trusted, and whether its index
+// transaction is that of its version, is up to the caller.
+func VerifyAttestation(att Attestation, fetch Getter) error {
+	verify, ok := attestationVerifiers[att.Scheme]
+	if !ok {
+		return &ErrInvalidAttestation{reason: fmt.Sprintf("unknown scheme %q", att.Scheme)}
+	}
+	statement, err := att.Statement()
+	if err != nil {
+		return err
+	}
+	if !verify(att.PublicKey, statement, att.Signature) {
+		return &ErrInvalidAttestation{reason: "bad signature"}
+	}
+
+	index, err := fetch([]byte(att.IndexTxId))
+	if err == nil {
+		index, err = decompressIndex([]byte(att.IndexTxId), index, DefaultMaxDecompressedSize)
+	}
+	if err != nil {
+		return err
+	}
+	if err := validateIndexHeader(index); err != nil {
+		return err
+	}
+	var pointing *IndexEntry
+	for _, entry := range getIndexEntries(string(att.Key), index) {
+		if string(entry.txId) == att.TxId {
+			entry := entry
+			pointing = &entry
+		}
+	}
+	if pointing == nil {
+		return &ErrInvalidAttestation{reason: fmt.Sprintf("index %s doesn't point to %s for the key", att.IndexTxId, att.TxId)}
+	}
+
+	payload, err := fetch([]byte(att.TxId))
+	if err == nil {
+		payload, err = decompressPayload(*pointing, payload, DefaultMaxDecompressedSize)
+	}
+	if err != nil {
+		return err
+	}
+	keyvalues, err := decodePayload(payload)
+	if err != nil {
+		return err
+	}
+	raw, ok := keyvalues[string(att.Key)]
+	if !ok {
+		return &ErrInvalidAttestation{reason: fmt.Sprintf("payload %s doesn't hold the key", att.TxId)}
+	}
+	value, err := NewEmptyArweaveDB().decodeValue(string(att.Key), []byte(att.TxId), raw)
+	if err != nil {
+		return err
+	}
+	if digest := sha256.Sum256([]byte(value)); !bytes.Equal(digest[:], att.ValueHash) {
+		return &ErrInvalidAttestation{reason: "value hash mismatch"}
+	}
+	return nil
+}
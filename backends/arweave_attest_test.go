@@ -0,0 +1,92 @@
+package backends_test
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+func newTestSigner(t *testing.T) backends.AttestationSigner {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	return backends.NewEd25519AttestationSigner(key)
+}
+
+func TestAttestRead(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().
+		Keys("a", "b", "c", "d").ChunkedIndex(2).
+		Version(1).Keys("a", "e").
+		Build()
+	require.Nil(t, err)
+	signer := newTestSigner(t)
+	db := archive.NewDB(backends.WithAttestationSigner(signer))
+
+	att, err := db.AttestRead(1, []byte("e"))
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), att.Version)
+	require.Equal(t, []byte("e"), att.Key)
+	require.Equal(t, signer.PublicKey(), att.PublicKey)
+	require.Nil(t, backends.VerifyAttestation(att, archive.TxDataGetter()))
+
+	for _, key := range []string{"a", "c"} {
+		att, err := db.AttestRead(0, []byte(key))
+		require.Nil(t, err)
+		require.Nil(t, backends.VerifyAttestation(att, archive.TxDataGetter()))
+	}
+
+	_, err = db.AttestRead(0, []byte("e"))
+	require.ErrorAs(t, err, new(*backends.ErrKeyNotFound))
+	_, err = archive.NewDB().AttestRead(0, []byte("a"))
+	require.NotNil(t, err)
+}
+
+func TestVerifyAttestationDetectsTampering(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().Keys("a", "b", "c", "d").ChunkedIndex(2).Build()
+	require.Nil(t, err)
+	signer := newTestSigner(t)
+	db := archive.NewDB(backends.WithAttestationSigner(signer))
+	att, err := db.AttestRead(0, []byte("a"))
+	require.Nil(t, err)
+	other, err := db.AttestRead(0, []byte("c"))
+	require.Nil(t, err)
+	require.NotEqual(t, att.TxId, other.TxId)
+
+	resign := func(att backends.Attestation) backends.Attestation {
+		statement, err := att.Statement()
+		require.Nil(t, err)
+		att.Signature, err = signer.Sign(statement)
+		require.Nil(t, err)
+		return att
+	}
+	for name, tamper := range map[string]func(att backends.Attestation) backends.Attestation{
+		"value hash": func(att backends.Attestation) backends.Attestation {
+			att.ValueHash = append([]byte{}, att.ValueHash...)
+			att.ValueHash[0] ^= 1
+			return att
+		},
+		"resigned value hash": func(att backends.Attestation) backends.Attestation {
+			att.ValueHash = other.ValueHash
+			return resign(att)
+		},
+		"resigned payload": func(att backends.Attestation) backends.Attestation {
+			att.TxId = other.TxId
+			return resign(att)
+		},
+		"signer": func(att backends.Attestation) backends.Attestation {
+			att.PublicKey = newTestSigner(t).PublicKey()
+			return att
+		},
+		"scheme": func(att backends.Attestation) backends.Attestation {
+			att.Scheme = "rsa"
+			return att
+		},
+	} {
+		err := backends.VerifyAttestation(tamper(att), archive.TxDataGetter())
+		require.ErrorAs(t, err, new(*backends.ErrInvalidAttestation), name)
+	}
+	require.Nil(t, backends.VerifyAttestation(att, archive.TxDataGetter()))
+}
@@ -0,0 +1,93 @@
+package backends
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// RequestDecorator customizes a request before it is sent to a gateway, e.g.
+// to authenticate it. A non-nil error fails the request.
+type RequestDecorator func(*http.Request) error
+
+// ChainDecorators returns a RequestDecorator invoking the given decorators
+// in order.
+func ChainDecorators(decorators ...RequestDecorator) RequestDecorator {
+	return func(req *http.Request) error {
+		for _, decorate := range decorators {
+			if err := decorate(req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// StaticHeaders returns a RequestDecorator setting the given headers, e.g.
+// an API key.
+func StaticHeaders(headers map[string]string) RequestDecorator {
+	copied := make(map[string]string, len(headers))
+	for name, value := range headers {
+		copied[name] = value
+	}
+	return func(req *http.Request) error {
+		for name, value := range copied {
+			req.Header.Set(name, value)
+		}
+		return nil
+	}
+}
+
+// HMACSigner returns a RequestDecorator setting header to the signature of
+// the request as computed by SignRequest. The Date header is set to the
+// current time unless already present.
+func HMACSigner(header string, secret []byte) RequestDecorator {
+	return func(req *http.Request) error {
+		date := req.Header.Get("Date")
+		if date == "" {
+			date = time.Now().UTC().Format(http.TimeFormat)
+			req.Header.Set("Date", date)
+		}
+		req.Header.Set(header, SignRequest(secret, req.Method, req.URL.Path, date))
+		return nil
+	}
+}
+
+// SignRequest returns the hex-encoded HMAC-SHA256 of the method, path and
+// date, separated by newlines.
+func SignRequest(secret []byte, method, path, date string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + path + "\n" + date))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WithRequestDecorator sets the decorator invoked on every request sent to
+// the gateways of the ArweaveDB being constructed.
+func WithRequestDecorator(decorate RequestDecorator) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if db.tracing != nil {
+			decorate = ChainDecorators(decorate, TraceHeader(DefaultTraceHeader))
+		}
+		for _, client := range db.httpClients() {
+			client.SetRequestDecorator(decorate)
+		}
+	}
+}
+
+// httpClients returns the HTTP clients the DB fetches with.
+func (db *ArweaveDB) httpClients() []*Client {
+	clients := []*Client{}
+	if db.client != nil {
+		clients = append(clients, db.client)
+	}
+	if db.gatewayPool != nil {
+		for _, state := range db.gatewayPool.gateways {
+			if client, ok := state.gateway.(*Client); ok {
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients
+}
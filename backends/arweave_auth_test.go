@@ -0,0 +1,121 @@
+package backends
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newGatewayServer serves a single 5 byte transaction "tx" after checking
+// each request with authorize. The first failures requests fail with a 500.
+func newGatewayServer(authorize func(*http.Request) bool, failures int32) (*httptest.Server, *int32) {
+	requests := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(requests, 1)
+		if !authorize(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if n <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		switch r.URL.Path {
+		case "/tx/tx/offset":
+			fmt.Fprint(w, `{"size": "5", "offset": "104"}`)
+		case "/chunk/100":
+			fmt.Fprintf(w, `{"chunk": "%s"}`, base64.RawURLEncoding.EncodeToString([]byte("hello")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server, requests
+}
+
+func TestStaticHeaders(t *testing.T) {
+	server, _ := newGatewayServer(func(r *http.Request) bool {
+		return r.Header.Get("X-Api-Key") == "secret"
+	}, 0)
+	defer server.Close()
+
+	_, err := NewClient(server.URL).DownloadChunkData("tx")
+	require.NotNil(t, err)
+
+	client, err := NewGatewayClient(GatewayConfig{
+		URL:      server.URL,
+		Decorate: StaticHeaders(map[string]string{"X-Api-Key": "secret"}),
+	})
+	require.Nil(t, err)
+	data, err := client.DownloadChunkData("tx")
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+}
+
+func TestHMACSigner(t *testing.T) {
+	secret := []byte("shared secret")
+	server, requests := newGatewayServer(func(r *http.Request) bool {
+		date := r.Header.Get("Date")
+		return date != "" && r.Header.Get("X-Signature") == SignRequest(secret, r.Method, r.URL.Path, date)
+	}, 0)
+	defer server.Close()
+
+	client, err := NewGatewayClient(GatewayConfig{URL: server.URL, Decorate: HMACSigner("X-Signature", []byte("wrong"))})
+	require.Nil(t, err)
+	_, err = client.DownloadChunkData("tx")
+	require.NotNil(t, err)
+
+	client.SetRequestDecorator(HMACSigner("X-Signature", secret))
+	data, err := client.DownloadChunkData("tx")
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+	require.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestRequestDecoratorError(t *testing.T) {
+	server, requests := newGatewayServer(func(*http.Request) bool { return true }, 0)
+	defer server.Close()
+	cause := errors.New("no credentials")
+	client, err := NewGatewayClient(GatewayConfig{
+		URL: server.URL,
+		Decorate: ChainDecorators(
+			StaticHeaders(map[string]string{"X-Api-Key": "secret"}),
+			func(*http.Request) error { return cause },
+		),
+	})
+	require.Nil(t, err)
+	_, err = client.DownloadChunkData("tx")
+	require.True(t, errors.Is(err, cause))
+	require.True(t, errors.As(err, new(*ErrRequestDecoration)))
+	require.Equal(t, int32(0), atomic.LoadInt32(requests))
+}
+
+func TestRequestDecoratorAppliedOnRetries(t *testing.T) {
+	decorated := new(int32)
+	server, requests := newGatewayServer(func(r *http.Request) bool {
+		return r.Header.Get("X-Api-Key") == "secret"
+	}, 2)
+	defer server.Close()
+
+	db, err := NewArweaveDB(filepath.Join(t.TempDir(), "index"), server.URL,
+		WithGetterMiddleware(retryingMiddleware(3)),
+		WithRequestDecorator(func(req *http.Request) error {
+			atomic.AddInt32(decorated, 1)
+			return StaticHeaders(map[string]string{"X-Api-Key": "secret"})(req)
+		}),
+	)
+	require.Nil(t, err)
+	defer db.Close()
+	data, err := db.txDataByIdGetter([]byte("tx"))
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+	// two failed attempts, then the offset and chunk requests
+	require.Equal(t, int32(4), atomic.LoadInt32(requests))
+	require.Equal(t, atomic.LoadInt32(requests), atomic.LoadInt32(decorated))
+}
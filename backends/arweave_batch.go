@@ -0,0 +1,117 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// WithWriter makes the batches of the ArweaveDB being constructed write to
+// w, see NewBatch. Without a writer, ArweaveDB batches fail with
+// ErrReadOnly.
+func WithWriter(w *ArweaveWriter) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if w == nil {
+			db.invalidOption(errors.New("WithWriter is given a nil writer"))
+		}
+		db.writer = w
+	}
+}
+
+// NewBatch implements DB. Batches buffer their writes in the writer of db,
+// see WithWriter, and must only hold keys of a single version. WriteSync
+// also flushes the version, publishing it.
+func (db *ArweaveDB) NewBatch() dbm.Batch {
+	if db.writer == nil {
+		return readOnlyBatch{err: ErrReadOnly}
+	}
+	return &arweaveBatch{db: db, ops: []batchOp{}}
+}
+
+type arweaveBatch struct {
+	db *ArweaveDB
+	// versioned keys, nil once written or closed
+	ops []batchOp
+}
+
+// Set implements Batch.
+func (b *arweaveBatch) Set(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return b.add(key, value)
+}
+
+// Delete implements Batch.
+func (b *arweaveBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	return b.add(key, nil)
+}
+
+func (b *arweaveBatch) add(key, value []byte) error {
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	op := batchOp{key: append([]byte{}, key...)}
+	if value != nil {
+		op.value = append([]byte{}, value...)
+	}
+	b.ops = append(b.ops, op)
+	return nil
+}
+
+// Write implements Batch.
+func (b *arweaveBatch) Write() error {
+	_, err := b.write()
+	return err
+}
+
+// WriteSync implements Batch.
+func (b *arweaveBatch) WriteSync() error {
+	version, err := b.write()
+	if err != nil || version == nil {
+		return err
+	}
+	_, err = b.db.writer.FlushVersion(*version)
+	return err
+}
+
+// write applies the ops to the writer in order, so that the last write of
+// a key wins, returning their version unless the batch was empty.
+func (b *arweaveBatch) write() (*uint64, error) {
+	if b.ops == nil {
+		return nil, errBatchClosed
+	}
+	if len(b.ops) == 0 {
+		return nil, b.Close()
+	}
+	var version uint64
+	ops := make([]batchOp, len(b.ops))
+	for i, op := range b.ops {
+		opVersion, key, err := b.db.splitKey(op.key)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && opVersion != version {
+			return nil, fmt.Errorf("batch mixes keys of versions %d and %d", version, opVersion)
+		}
+		version = opVersion
+		ops[i] = batchOp{key: key, value: op.value}
+	}
+	if err := b.db.writer.apply(version, ops); err != nil {
+		return nil, err
+	}
+	return &version, b.Close()
+}
+
+// Close implements Batch.
+func (b *arweaveBatch) Close() error {
+	b.ops = nil
+	return nil
+}
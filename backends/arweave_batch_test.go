@@ -0,0 +1,104 @@
+package backends
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newBatchTestDB() (*ArweaveDB, *ArweaveWriter, *mockUploads) {
+	uploads := &mockUploads{failIn: -1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{})
+	db := NewMockArweaveDB(nil, nil, nil)
+	WithWriter(w)(db)
+	return db, w, uploads
+}
+
+func TestArweaveBatchWriteSync(t *testing.T) {
+	db, w, uploads := newBatchTestDB()
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set(versionedKey(1, "a"), []byte("1")))
+	require.Nil(t, batch.Set(versionedKey(1, "b"), []byte("2")))
+	require.Nil(t, batch.Set(versionedKey(1, "a"), []byte("3")))
+	require.Nil(t, batch.Set(versionedKey(1, "c"), []byte("4")))
+	require.Nil(t, batch.Delete(versionedKey(1, "c")))
+	require.Nil(t, batch.WriteSync())
+	require.Empty(t, w.PendingVersions())
+
+	read := uploads.db()
+	for key, expected := range map[string]string{"a": "3", "b": "2"} {
+		value, err := read.Get(versionedKey(1, key))
+		require.Nil(t, err)
+		require.Equal(t, expected, string(value))
+	}
+	has, err := read.Has(versionedKey(1, "c"))
+	require.Nil(t, err)
+	require.False(t, has)
+}
+
+func TestArweaveBatchWrite(t *testing.T) {
+	db, w, uploads := newBatchTestDB()
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}} {
+		batch := db.NewBatch()
+		require.Nil(t, batch.Set(versionedKey(0, kv[0]), []byte(kv[1])))
+		require.Nil(t, batch.Write())
+	}
+	require.Equal(t, []uint64{0}, w.PendingVersions())
+	require.Empty(t, uploads.txData)
+
+	_, err := w.FlushVersion(0)
+	require.Nil(t, err)
+	value, err := uploads.db().Get(versionedKey(0, "b"))
+	require.Nil(t, err)
+	require.Equal(t, "2", string(value))
+}
+
+func TestArweaveBatchEmpty(t *testing.T) {
+	db, w, uploads := newBatchTestDB()
+	require.Nil(t, db.NewBatch().Write())
+	require.Nil(t, db.NewBatch().WriteSync())
+	require.Empty(t, w.PendingVersions())
+	require.Empty(t, uploads.indices)
+}
+
+func TestArweaveBatchMixedVersions(t *testing.T) {
+	db, w, _ := newBatchTestDB()
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set(versionedKey(1, "a"), []byte("1")))
+	require.Nil(t, batch.Set(versionedKey(2, "a"), []byte("1")))
+	err := batch.Write()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "versions 1 and 2")
+	require.Empty(t, w.PendingVersions())
+
+	batch = db.NewBatch()
+	require.Nil(t, batch.Set([]byte{1}, []byte("1")))
+	require.Error(t, batch.Write())
+}
+
+func TestArweaveBatchClosed(t *testing.T) {
+	db, w, _ := newBatchTestDB()
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set(versionedKey(0, "a"), []byte("1")))
+	require.Nil(t, batch.Close())
+	require.Equal(t, errBatchClosed, batch.Set(versionedKey(0, "a"), []byte("1")))
+	require.Equal(t, errBatchClosed, batch.Delete(versionedKey(0, "a")))
+	require.Equal(t, errBatchClosed, batch.Write())
+	require.Equal(t, errBatchClosed, batch.WriteSync())
+	require.Nil(t, batch.Close())
+	require.Empty(t, w.PendingVersions())
+
+	batch = db.NewBatch()
+	require.Nil(t, batch.Set(versionedKey(0, "a"), []byte("1")))
+	require.Nil(t, batch.Write())
+	require.Equal(t, errBatchClosed, batch.Write())
+	require.Equal(t, errKeyEmpty, db.NewBatch().Set(nil, []byte("1")))
+	require.Equal(t, errValueNil, db.NewBatch().Set(versionedKey(0, "a"), nil))
+}
+
+func TestArweaveBatchWithoutWriter(t *testing.T) {
+	batch := NewMockArweaveDB(nil, nil, nil).NewBatch()
+	require.Equal(t, ErrReadOnly, batch.Set(versionedKey(0, "a"), []byte("1")))
+	require.Equal(t, ErrReadOnly, batch.Write())
+	require.Nil(t, batch.Close())
+}
@@ -0,0 +1,122 @@
+package backends
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// BinaryPayloadTag is the first byte of binary payloads, which JSON
+// payloads can't start with. It is followed by the key-value pairs of the
+// payload in ascending key order, each key and value being prefixed by its
+// length as an unsigned varint, so that keys and values can be any bytes.
+const BinaryPayloadTag byte = 0x01
+
+// isBinaryPayload returns whether txData is a binary payload rather than a
+// JSON one.
+func isBinaryPayload(txData []byte) bool {
+	return len(txData) > 0 && txData[0] == BinaryPayloadTag
+}
+
+// encodeBinaryPayload returns the binary payload of kvs.
+func encodeBinaryPayload(kvs map[string]string) []byte {
+	keys := make([]string, 0, len(kvs))
+	size := 1
+	for key, value := range kvs {
+		keys = append(keys, key)
+		size += binaryPairLen(key, value)
+	}
+	sort.Strings(keys)
+	payload := make([]byte, 1, size)
+	payload[0] = BinaryPayloadTag
+	for _, key := range keys {
+		payload = appendBinaryString(payload, key)
+		payload = appendBinaryString(payload, kvs[key])
+	}
+	return payload
+}
+
+func appendBinaryString(bz []byte, s string) []byte {
+	var n [binary.MaxVarintLen64]byte
+	bz = append(bz, n[:binary.PutUvarint(n[:], uint64(len(s)))]...)
+	return append(bz, s...)
+}
+
+// binaryPairLen returns the length of a key-value pair in a binary payload.
+func binaryPairLen(key, value string) int {
+	var n [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(n[:], uint64(len(key))) + len(key) + binary.PutUvarint(n[:], uint64(len(value))) + len(value)
+}
+
+// scanBinaryPayload calls fn with the key-value pairs of a binary payload
+// and the offset of their value, until fn returns false.
+func scanBinaryPayload(txData []byte, fn func(key, value []byte, valueOffset int) bool) error {
+	pos := 1
+	next := func() ([]byte, int, error) {
+		n, read := binary.Uvarint(txData[pos:])
+		if read <= 0 || n > uint64(len(txData)-pos-read) {
+			return nil, 0, fmt.Errorf("binary payload truncated at offset %d", pos)
+		}
+		start := pos + read
+		pos = start + int(n)
+		return txData[start:pos], start, nil
+	}
+	for pos < len(txData) {
+		key, _, err := next()
+		if err != nil {
+			return err
+		}
+		value, offset, err := next()
+		if err != nil {
+			return err
+		}
+		if !fn(key, value, offset) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// decodeBinaryPayload decodes a binary payload like decodePayload does a
+// JSON one, values being strings. The last occurrence of a duplicate key
+// wins.
+func decodeBinaryPayload(txData []byte) (map[string]interface{}, error) {
+	keyvalues := map[string]interface{}{}
+	err := scanBinaryPayload(txData, func(key, value []byte, _ int) bool {
+		keyvalues[string(key)] = string(value)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keyvalues, nil
+}
+
+// extractBinaryValue is extractPayloadValue for binary payloads.
+func extractBinaryValue(txData []byte, key string) (interface{}, int, error) {
+	var raw interface{}
+	found := 0
+	err := scanBinaryPayload(txData, func(k, value []byte, _ int) bool {
+		if string(k) == key {
+			raw = string(value)
+			found++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return raw, found, nil
+}
+
+// locateBinaryValue is locateJSONValue for binary payloads.
+func locateBinaryValue(payload []byte, key string) (int, int) {
+	offset, length := -1, -1
+	_ = scanBinaryPayload(payload, func(k, value []byte, valueOffset int) bool {
+		if string(k) == key {
+			offset, length = valueOffset, len(value)
+		}
+		return true
+	})
+	return offset, length
+}
@@ -0,0 +1,99 @@
+package backends
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// binaryTestKVs are keys and values JSON payloads can't hold.
+var binaryTestKVs = map[string]string{
+	"\x00":         "\x00\x00",
+	"\x00key":      "\xff",
+	"\xc3\x28":     "\xc3\x28\xa0\xa1",
+	"plain":        "value",
+	"\xff\xfe\xfd": "",
+}
+
+func binaryTestPayload() []byte {
+	keys, values := []string{}, []string{}
+	for key, value := range binaryTestKVs {
+		keys, values = append(keys, key), append(values, value)
+	}
+	return mockTxDataBinary(keys, values)
+}
+
+func TestBinaryPayloadAlongsideJSON(t *testing.T) {
+	txData := [][]byte{
+		mockTxData([]string{"a", "b"}, []string{"1", "2"}),
+		mockTxDataBinary([]string{"c", "d"}, []string{"\x00", "\xff"}),
+	}
+	db := NewMockArweaveDB([][]byte{mockIndex([]string{"b", "d"}, []int{0, 1})}, txData, []int{0, 1})
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	require.Equal(t, []KVPair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: []byte{0}},
+		{Key: []byte("d"), Value: []byte{0xff}},
+	}, collectPairs(t, iter))
+
+	raw, err := db.GetRaw(0, []byte("d"))
+	require.Nil(t, err)
+	require.Empty(t, raw.JSONPath)
+	require.Equal(t, []byte{0xff}, raw.Payload[raw.ValueOffset:raw.ValueOffset+raw.ValueLen])
+}
+
+func TestBinaryPayloadTruncated(t *testing.T) {
+	payload := binaryTestPayload()
+	for _, truncated := range [][]byte{payload[:len(payload)-1], {BinaryPayloadTag, 0x05, 'a'}, {BinaryPayloadTag, 0xff}} {
+		_, err := decodePayload(truncated)
+		require.Error(t, err)
+	}
+	kvs, err := decodePayload([]byte{BinaryPayloadTag})
+	require.Nil(t, err)
+	require.Empty(t, kvs)
+}
+
+func TestChunkBinaryPayloads(t *testing.T) {
+	kvs := map[string][]byte{}
+	for key, value := range binaryTestKVs {
+		kvs[key] = []byte(value)
+	}
+	_, err := ChunkPolicy{}.Chunk(kvs)
+	require.Error(t, err)
+
+	chunks, err := ChunkPolicy{Codec: CodecBinary, TargetPayloadBytes: 16}.Chunk(kvs)
+	require.Nil(t, err)
+	require.Greater(t, len(chunks), 1)
+	decoded := map[string][]byte{}
+	for _, chunk := range chunks {
+		require.Equal(t, CodecBinary, chunk.Info.Codec)
+		require.Equal(t, uint64(len(chunk.Payload)), chunk.Info.PayloadSize)
+		payload, err := decodePayload(chunk.Payload)
+		require.Nil(t, err)
+		for key, value := range payload {
+			decoded[key] = []byte(value.(string))
+		}
+	}
+	require.Equal(t, kvs, decoded)
+}
+
+func TestArweaveWriterBinaryPayloads(t *testing.T) {
+	uploads := &mockUploads{failIn: -1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{Codec: CodecBinary, MaxKeysPerPayload: 2})
+	for key, value := range binaryTestKVs {
+		require.Nil(t, w.Set(0, []byte(key), []byte(value)))
+	}
+	_, err := w.FlushVersion(0)
+	require.Nil(t, err)
+
+	db := uploads.db()
+	WithStrictMode()(db)
+	for key, expected := range binaryTestKVs {
+		value, err := db.Get(versionedKey(0, key))
+		require.Nil(t, err)
+		require.Equal(t, []byte(expected), value, "%X", key)
+	}
+	require.Error(t, NewArweaveWriter(uploads.upload, ChunkPolicy{}).Set(0, []byte("\xff"), []byte("1")))
+}
@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var ErrIteratorBudgetExceeded = errors.New("iterator memory budget exceeded")
+
+// iteratorBudget accounts for the bytes buffered by all iterators of an
+// ArweaveDB. Once the budget is exceeded, new iterators are refused while
+// existing ones keep going.
+type iteratorBudget struct {
+	limit int64
+	used  int64
+}
+
+// WithIteratorBudget limits the bytes buffered by all iterators of the
+// ArweaveDB, in terms of decoded payload keys and values. Iterators reading
+// ahead only do so for payloads whose size, as given by their index entry,
+// fits in the budget, or while it isn't exceeded for entries without
+// metadata.
+func WithIteratorBudget(bytes int64) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if bytes <= 0 {
+			db.invalidOption(fmt.Errorf("WithIteratorBudget needs a positive number of bytes, got %d", bytes))
+		}
+		db.iteratorBudget = &iteratorBudget{limit: bytes}
+	}
+}
+
+func (b *iteratorBudget) admit() error {
+	if atomic.LoadInt64(&b.used) >= b.limit {
+		return ErrIteratorBudgetExceeded
+	}
+	return nil
+}
+
+// reserve accounts n bytes against the budget if they fit in it.
+func (b *iteratorBudget) reserve(n int64) bool {
+	for {
+		used := atomic.LoadInt64(&b.used)
+		if used+n > b.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.used, used, used+n) {
+			return true
+		}
+	}
+}
+
+func (b *iteratorBudget) add(delta int64) {
+	atomic.AddInt64(&b.used, delta)
+}
+
+func (b *iteratorBudget) usage() int64 {
+	return atomic.LoadInt64(&b.used)
+}
+
+func payloadSize(data map[string]interface{}) int64 {
+	size := int64(0)
+	for k, v := range data {
+		size += int64(len(k))
+		if s, ok := v.(string); ok {
+			size += int64(len(s))
+		}
+	}
+	return size
+}
+
+// setBuffered updates the bytes accounted to the iterator.
+func (itr *arweaveDBIterator) setBuffered(size int64) {
+	if itr.db.iteratorBudget == nil {
+		return
+	}
+	itr.db.iteratorBudget.add(size - itr.buffered)
+	itr.buffered = size
+}
@@ -0,0 +1,82 @@
+package backends
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestIteratorBudget(t *testing.T) {
+	index := mockIndex([]string{"ab", "cd"}, []int{0, 1})
+	txData := [][]byte{
+		// 2 + 8 bytes
+		mockTxData([]string{"aa"}, []string{"12345678"}),
+		// 2 * (2 + 3) bytes
+		mockTxData([]string{"cc", "cd"}, []string{"123", "456"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	WithIteratorBudget(100)(mockDB)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	start, end := append(v0Bz, []byte("a")...), append(v0Bz, []byte("d")...)
+
+	const n = 20
+	iters := make([]dbm.Iterator, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			iters[i], errs[i] = mockDB.Iterator(start, end)
+		}(i)
+	}
+	wg.Wait()
+	opened := []dbm.Iterator{}
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			require.Equal(t, ErrIteratorBudgetExceeded, errs[i])
+		} else {
+			opened = append(opened, iters[i])
+		}
+	}
+	// concurrent admissions may let a few extra iterators in
+	require.GreaterOrEqual(t, len(opened), 10)
+	require.Equal(t, int64(10*len(opened)), mockDB.iteratorBudget.usage())
+	require.Equal(t, "100", mockDB.Stats()["iterator_budget"])
+	_, err := mockDB.Iterator(start, end)
+	require.Equal(t, ErrIteratorBudgetExceeded, err)
+
+	// existing iterators keep going, updating their usage
+	require.Equal(t, "aa", string(opened[0].Key()))
+	opened[0].Next()
+	require.Equal(t, "cc", string(opened[0].Key()))
+	require.Equal(t, int64(10*len(opened)), mockDB.iteratorBudget.usage())
+
+	for _, iter := range opened {
+		require.Nil(t, iter.Close())
+		require.Nil(t, iter.Close())
+	}
+	require.Equal(t, int64(0), mockDB.iteratorBudget.usage())
+	require.Equal(t, "0", mockDB.Stats()["iterator_budget_used"])
+
+	iter, err := mockDB.Iterator(start, end)
+	require.Nil(t, err)
+	require.Nil(t, iter.Close())
+}
+
+func TestIteratorBudgetReleasedOnFailure(t *testing.T) {
+	index := mockIndex([]string{"ab", "cd"}, []int{0, 1})
+	// the second tx is missing, which makes skipping to the start key fail
+	txData := [][]byte{mockTxData([]string{"aa"}, []string{"v"})}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0})
+	WithIteratorBudget(100)(mockDB)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	_, err := mockDB.Iterator(append(v0Bz, []byte("ab")...), append(v0Bz, []byte("d")...))
+	require.NotNil(t, err)
+	require.Equal(t, int64(0), mockDB.iteratorBudget.usage())
+}
@@ -0,0 +1,201 @@
+package backends
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Checkpoint is a signed snapshot of the index tx IDs of versions, and
+// optionally of their indices, from which new nodes bootstrap their caches
+// instead of resolving and fetching them. See ExportCheckpoint.
+type Checkpoint struct {
+	// Height is the highest version covered.
+	Height    uint64              `json:"height"`
+	Versions  []CheckpointVersion `json:"versions"`
+	CreatedAt time.Time           `json:"created_at"`
+
+	Scheme    string `json:"scheme"`
+	PublicKey []byte `json:"public_key"`
+	// Signature signs the statement of the checkpoint
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// CheckpointVersion is a version covered by a checkpoint.
+type CheckpointVersion struct {
+	Version   uint64 `json:"version"`
+	IndexTxId string `json:"index_tx_id"`
+	// Index is the index as published, if included
+	Index []byte `json:"index,omitempty"`
+}
+
+// Statement returns what the signature of the checkpoint signs: its JSON
+// encoding without the signature.
+func (c Checkpoint) Statement() ([]byte, error) {
+	c.Signature = nil
+	return json.Marshal(c)
+}
+
+type checkpointTrust struct {
+	publicKey []byte
+	watermark uint64
+}
+
+// WithCheckpointTrust makes BootstrapFromCheckpoint accept checkpoints
+// signed by publicKey, with an ed25519 key, and covering versions up to at
+// least watermark.
+func WithCheckpointTrust(publicKey []byte, watermark uint64) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if len(publicKey) != ed25519.PublicKeySize {
+			db.invalidOption(fmt.Errorf("WithCheckpointTrust needs a %d-byte ed25519 public key, got %d bytes", ed25519.PublicKeySize, len(publicKey)))
+		}
+		db.checkpointTrust = &checkpointTrust{publicKey: append([]byte{}, publicKey...), watermark: watermark}
+	}
+}
+
+// ExportCheckpoint writes a checkpoint of the given versions signed by
+// signer to w, including their indices if withIndices is set. Versions are
+// resolved and their indices fetched like for reads.
+func (db *ArweaveDB) ExportCheckpoint(w io.Writer, versions []uint64, signer AttestationSigner, withIndices bool) error {
+	if len(versions) == 0 {
+		return errors.New("no version to checkpoint")
+	}
+	checkpoint := Checkpoint{
+		CreatedAt: time.Now().UTC(),
+		Scheme:    signer.Scheme(),
+		PublicKey: signer.PublicKey(),
+	}
+	for _, version := range versions {
+		entry := CheckpointVersion{Version: version}
+		if withIndices {
+			indexTxId, index, err := db.fetchIndex(version)
+			if err != nil {
+				return fmt.Errorf("version %d: %w", version, err)
+			}
+			entry.IndexTxId, entry.Index = string(indexTxId), index
+		} else {
+			indexTxId, err := db.getIndexTxId(version)
+			if err != nil {
+				return fmt.Errorf("version %d: %w", version, err)
+			}
+			entry.IndexTxId = string(indexTxId)
+		}
+		checkpoint.Versions = append(checkpoint.Versions, entry)
+		if version > checkpoint.Height {
+			checkpoint.Height = version
+		}
+	}
+	sort.Slice(checkpoint.Versions, func(i, j int) bool {
+		return checkpoint.Versions[i].Version < checkpoint.Versions[j].Version
+	})
+	statement, err := checkpoint.Statement()
+	if err != nil {
+		return err
+	}
+	if checkpoint.Signature, err = signer.Sign(statement); err != nil {
+		return err
+	}
+	bz, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bz)
+	return err
+}
+
+// BootstrapFromCheckpoint loads the checkpoint at path, verifies that it is
+// trusted as configured by WithCheckpointTrust, and seeds the version map
+// cache with its versions, creating an in-memory one unless enabled, and
+// the index cache with its indices, if enabled. Untrusted checkpoints fail
+// with ErrUntrustedCheckpoint, and checkpoints below the watermark with
+// ErrStaleCheckpoint, without seeding anything. It must be called before
+// db is used concurrently.
+func (db *ArweaveDB) BootstrapFromCheckpoint(path string) error {
+	bz, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	checkpoint := Checkpoint{}
+	if err := json.Unmarshal(bz, &checkpoint); err != nil {
+		return fmt.Errorf("parsing checkpoint %s: %w", path, err)
+	}
+	if err := db.verifyCheckpoint(checkpoint); err != nil {
+		return err
+	}
+	indices := make(map[uint64][]IndexEntry, len(checkpoint.Versions))
+	for _, entry := range checkpoint.Versions {
+		if entry.Index == nil || db.indexCache == nil {
+			continue
+		}
+		index := entry.Index
+		if err := validateIndexHeader(index); err != nil {
+			return fmt.Errorf("index of version %d: %w", entry.Version, err)
+		}
+		if err := db.checkIndex(entry.Version, index); err != nil {
+			return err
+		}
+		if index, err = db.validatedIndex(entry.Version, []byte(entry.IndexTxId), index); err != nil {
+			return err
+		}
+		indices[entry.Version] = parseIndex(index)
+	}
+
+	if db.versionMap == nil {
+		db.versionMap = &versionMap{store: metadataDB(dbm.NewMemDB()), txIds: map[uint64]string{}}
+	}
+	for _, entry := range checkpoint.Versions {
+		if err := db.versionMap.put(entry.Version, entry.IndexTxId); err != nil {
+			return err
+		}
+		if db.versionProbes != nil {
+			db.versionProbes.put(entry.Version, true)
+		}
+		if entries, ok := indices[entry.Version]; ok {
+			db.indexCache.put(entry.Version, entries)
+		} else if db.indexCache != nil {
+			db.indexCache.invalidate(entry.Version)
+		}
+	}
+	return nil
+}
+
+func (db *ArweaveDB) verifyCheckpoint(checkpoint Checkpoint) error {
+	trust := db.checkpointTrust
+	if trust == nil {
+		return &ErrUntrustedCheckpoint{reason: "no checkpoint trust configured"}
+	}
+	if string(checkpoint.PublicKey) != string(trust.publicKey) {
+		return &ErrUntrustedCheckpoint{reason: "signed by an untrusted key"}
+	}
+	verify, ok := attestationVerifiers[checkpoint.Scheme]
+	if !ok {
+		return &ErrUntrustedCheckpoint{reason: fmt.Sprintf("unknown scheme %q", checkpoint.Scheme)}
+	}
+	statement, err := checkpoint.Statement()
+	if err != nil {
+		return err
+	}
+	if !verify(checkpoint.PublicKey, statement, checkpoint.Signature) {
+		return &ErrUntrustedCheckpoint{reason: "invalid signature"}
+	}
+	height := uint64(0)
+	for _, entry := range checkpoint.Versions {
+		if entry.Version > height {
+			height = entry.Version
+		}
+	}
+	if height != checkpoint.Height {
+		return &ErrUntrustedCheckpoint{reason: fmt.Sprintf("height %d doesn't match its versions", checkpoint.Height)}
+	}
+	if checkpoint.Height < trust.watermark {
+		return &ErrStaleCheckpoint{height: checkpoint.Height, watermark: trust.watermark}
+	}
+	return nil
+}
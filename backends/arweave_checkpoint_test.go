@@ -0,0 +1,128 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newCheckpointTestDB returns a DB of 2 versions counting the fetches of
+// its getters.
+func newCheckpointTestDB(versionFetches, txDataFetches *int, opts ...ArweaveOption) *ArweaveDB {
+	indices := [][]byte{mockIndex([]string{"ab"}, []int{0}), mockIndex([]string{"ab"}, []int{1})}
+	txData := [][]byte{
+		mockTxData([]string{"aa"}, []string{"1"}),
+		mockTxData([]string{"aa"}, []string{"2"}),
+	}
+	mockDB := NewMockArweaveDB(indices, txData, []int{0, 1})
+	return NewArweaveDBWithGetters(
+		func(txId []byte) ([]byte, error) {
+			*txDataFetches++
+			return mockDB.txDataByIdGetter(txId)
+		},
+		func(version []byte) ([]byte, error) {
+			*versionFetches++
+			return mockDB.versionTxIdGetter(version)
+		},
+		opts...,
+	)
+}
+
+// writeCheckpoint exports a checkpoint of versions signed by key to a file.
+func writeCheckpoint(t *testing.T, key ed25519.PrivateKey, versions []uint64, withIndices bool) string {
+	var versionFetches, txDataFetches int
+	buf := &bytes.Buffer{}
+	db := newCheckpointTestDB(&versionFetches, &txDataFetches)
+	require.Nil(t, db.ExportCheckpoint(buf, versions, NewEd25519AttestationSigner(key), withIndices))
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	require.Nil(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+func newCheckpointKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	return public, private
+}
+
+func TestBootstrapFromCheckpoint(t *testing.T) {
+	public, private := newCheckpointKey(t)
+	path := writeCheckpoint(t, private, []uint64{1, 0}, true)
+
+	var versionFetches, txDataFetches int
+	db := newCheckpointTestDB(&versionFetches, &txDataFetches, WithCheckpointTrust(public, 1), WithIndexCache(4))
+	require.Nil(t, db.BootstrapFromCheckpoint(path))
+	for version, expected := range []string{"1", "2"} {
+		value, err := db.Get(versionedKey(uint64(version), "aa"))
+		require.Nil(t, err)
+		require.Equal(t, expected, string(value))
+		exists, err := db.HasVersion(uint64(version))
+		require.Nil(t, err)
+		require.True(t, exists)
+	}
+	require.Equal(t, 0, versionFetches)
+	// the payloads only
+	require.Equal(t, 2, txDataFetches)
+}
+
+func TestBootstrapFromPartialCheckpoint(t *testing.T) {
+	public, private := newCheckpointKey(t)
+
+	// versions outside of the checkpoint are resolved
+	var versionFetches, txDataFetches int
+	db := newCheckpointTestDB(&versionFetches, &txDataFetches, WithCheckpointTrust(public, 0), WithIndexCache(4))
+	require.Nil(t, db.BootstrapFromCheckpoint(writeCheckpoint(t, private, []uint64{0}, true)))
+	for version := uint64(0); version < 2; version++ {
+		_, err := db.Get(versionedKey(version, "aa"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, 1, versionFetches)
+	// the payload of version 0, and the index and payload of version 1
+	require.Equal(t, 3, txDataFetches)
+
+	// indices left out of the checkpoint are fetched
+	versionFetches, txDataFetches = 0, 0
+	db = newCheckpointTestDB(&versionFetches, &txDataFetches, WithCheckpointTrust(public, 0))
+	require.Nil(t, db.BootstrapFromCheckpoint(writeCheckpoint(t, private, []uint64{0, 1}, false)))
+	_, err := db.Get(versionedKey(1, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, 0, versionFetches)
+	require.Equal(t, 2, txDataFetches)
+}
+
+func TestBootstrapFromUntrustedCheckpoint(t *testing.T) {
+	public, private := newCheckpointKey(t)
+	path := writeCheckpoint(t, private, []uint64{0, 1}, true)
+	bootstrap := func(opts ...ArweaveOption) error {
+		var versionFetches, txDataFetches int
+		db := newCheckpointTestDB(&versionFetches, &txDataFetches, opts...)
+		err := db.BootstrapFromCheckpoint(path)
+		require.Nil(t, db.versionMap)
+		return err
+	}
+
+	require.ErrorAs(t, bootstrap(), new(*ErrUntrustedCheckpoint))
+	other, _ := newCheckpointKey(t)
+	require.ErrorAs(t, bootstrap(WithCheckpointTrust(other, 0)), new(*ErrUntrustedCheckpoint))
+	stale := &ErrStaleCheckpoint{}
+	require.ErrorAs(t, bootstrap(WithCheckpointTrust(public, 2)), &stale)
+	require.Equal(t, "Checkpoint at height 1 is older than watermark 2", stale.Error())
+
+	// tampered mappings fail verification
+	bz, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	checkpoint := Checkpoint{}
+	require.Nil(t, json.Unmarshal(bz, &checkpoint))
+	checkpoint.Versions[0].IndexTxId = checkpoint.Versions[1].IndexTxId
+	bz, err = json.Marshal(checkpoint)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(path, bz, 0644))
+	untrusted := &ErrUntrustedCheckpoint{}
+	require.ErrorAs(t, bootstrap(WithCheckpointTrust(public, 0)), &untrusted)
+	require.Equal(t, "Untrusted checkpoint: invalid signature", untrusted.Error())
+}
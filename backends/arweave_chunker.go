@@ -0,0 +1,224 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChunkPolicy splits the key-value pairs of a version into transaction
+// payloads. Zero limits are unlimited. A single pair exceeding
+// TargetPayloadBytes gets a payload of its own.
+type ChunkPolicy struct {
+	TargetPayloadBytes int
+	MaxKeysPerPayload  int
+	// Codec encodes the payloads, CodecJSON or CodecBinary, defaulting to
+	// CodecJSON.
+	Codec PayloadCodec
+	// KeyFilterBitsPerKey makes Chunk build a key filter of each payload
+	// with that many bits per key, so that Has can rule out payloads
+	// without fetching them. 10 bits per key give about 1% of false
+	// positives. Zero builds none.
+	KeyFilterBitsPerKey int
+	// Compression compresses the payloads once split, as declared by their
+	// index entries. ArweaveWriter compresses indices with it too.
+	Compression TxCompression
+}
+
+// PayloadChunk is a transaction payload along with the index entry which
+// will point to it once published.
+type PayloadChunk struct {
+	// the IndexKeyPrefixLen prefix of the largest key of the payload
+	KeyPrefix []byte
+	Payload   []byte
+	Info      IndexEntryInfo
+	// KeyFilter is the key filter of the payload, nil unless built by the
+	// policy.
+	KeyFilter []byte
+}
+
+// Chunk splits kvs into payloads, in ascending key order. Since index
+// entries can only tell keys apart by their IndexKeyPrefixLen prefix, padded
+// with zeroes, keys sharing such a prefix and not fitting in one payload are split into
+// payloads with the same KeyPrefix, which readers look up together. The
+// result only depends on kvs, so that the same input always produces the
+// same payloads and index. JSON payloads can't hold keys or values which
+// aren't valid UTF-8, unlike binary ones.
+func (p ChunkPolicy) Chunk(kvs map[string][]byte) ([]PayloadChunk, error) {
+	codec := p.Codec
+	if codec == CodecUnknown {
+		codec = CodecJSON
+	}
+	if codec != CodecJSON && codec != CodecBinary {
+		return nil, fmt.Errorf("unsupported payload codec %d", codec)
+	}
+	binaryPayloads := codec == CodecBinary
+	keys := make([]string, 0, len(kvs))
+	for key, value := range kvs {
+		if !binaryPayloads && (!utf8.ValidString(key) || !utf8.Valid(value)) {
+			return nil, fmt.Errorf("key %X or its value is not valid UTF-8", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// size of an empty payload, i.e. of the enclosing braces or the tag, and
+	// of the separator between pairs
+	emptySize, separatorSize := 2, 1
+	if binaryPayloads {
+		emptySize, separatorSize = 1, 0
+	}
+	chunks := []PayloadChunk{}
+	current := map[string]string{}
+	// size of the payload encoding current
+	size := emptySize
+	// whether current continues the prefix group the previous chunk ends with
+	continuesGroup := false
+	flush := func(lastKey string) {
+		var payload []byte
+		if binaryPayloads {
+			payload = encodeBinaryPayload(current)
+		} else {
+			var err error
+			if payload, err = json.Marshal(current); err != nil {
+				panic(err)
+			}
+		}
+		chunk := PayloadChunk{
+			KeyPrefix: []byte(truncateKeyPrefix(lastKey)),
+			Payload:   payload,
+			Info: IndexEntryInfo{
+				PayloadSize: uint64(len(payload)),
+				KeyCount:    uint32(len(current)),
+				Codec:       codec,
+			},
+		}
+		if p.KeyFilterBitsPerKey > 0 {
+			chunkKeys := make([]string, 0, len(current))
+			for key := range current {
+				chunkKeys = append(chunkKeys, key)
+			}
+			chunk.KeyFilter = buildKeyFilter(chunkKeys, p.KeyFilterBitsPerKey)
+		}
+		chunks = append(chunks, chunk)
+		current, size = map[string]string{}, emptySize
+	}
+	for i, key := range keys {
+		value := string(kvs[key])
+		var kvSize int
+		if binaryPayloads {
+			kvSize = binaryPairLen(key, value)
+		} else {
+			kvSize = jsonStringLen(key) + 1 + jsonStringLen(value)
+		}
+		if len(current) > 0 {
+			kvSize += separatorSize
+			sameGroup := indexedKeyPrefix(key) == indexedKeyPrefix(keys[i-1])
+			full := p.MaxKeysPerPayload > 0 && len(current) >= p.MaxKeysPerPayload
+			full = full || (p.TargetPayloadBytes > 0 && size+kvSize > p.TargetPayloadBytes)
+			// readers only look up the entries of the first matching prefix,
+			// so a split group must not continue into an entry with a larger
+			// prefix
+			if full || (continuesGroup && !sameGroup) {
+				flush(keys[i-1])
+				kvSize -= separatorSize
+				continuesGroup = sameGroup
+			}
+		}
+		current[key] = value
+		size += kvSize
+	}
+	if len(current) > 0 {
+		flush(keys[len(keys)-1])
+	}
+	if p.Compression != CompressionNone {
+		for i := range chunks {
+			compressed, err := CompressTx(chunks[i].Payload, p.Compression)
+			if err != nil {
+				return nil, err
+			}
+			chunks[i].Payload = compressed
+			chunks[i].Info.PayloadSize = uint64(len(compressed))
+			chunks[i].Info.Compression = p.Compression
+		}
+	}
+	return chunks, nil
+}
+
+func truncateKeyPrefix(key string) string {
+	if len(key) > IndexKeyPrefixLen {
+		return key[:IndexKeyPrefixLen]
+	}
+	return key
+}
+
+// indexedKeyPrefix returns what index entries tell key apart by: its
+// IndexKeyPrefixLen prefix, padded with zeroes in the index, so that keys
+// only differing by trailing zeroes, e.g. "a" and "a\x00", can't be told
+// apart.
+func indexedKeyPrefix(key string) string {
+	return strings.TrimRight(truncateKeyPrefix(key), "\x00")
+}
+
+func jsonStringLen(s string) int {
+	bz, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	return len(bz)
+}
+
+// BuildIndex returns the IndexFormatV1 index of chunks, once published as
+// the transactions with the given IDs.
+func BuildIndex(chunks []PayloadChunk, txIds [][]byte) ([]byte, error) {
+	return buildIndex(chunks, txIds, nil)
+}
+
+// BuildIndexWithKeyFilters returns the IndexFormatV2 index of chunks, once
+// published as the transactions with the given IDs, their key filters being
+// published as the transactions with the IDs of keyFilterTxIds, nil for
+// chunks without key filter.
+func BuildIndexWithKeyFilters(chunks []PayloadChunk, txIds, keyFilterTxIds [][]byte) ([]byte, error) {
+	if len(chunks) != len(keyFilterTxIds) {
+		return nil, fmt.Errorf("%d chunks but %d key filter tx IDs", len(chunks), len(keyFilterTxIds))
+	}
+	return buildIndex(chunks, txIds, keyFilterTxIds)
+}
+
+// buildIndex returns the IndexFormatV2 index of chunks if keyFilterTxIds is
+// set, the IndexFormatV1 one otherwise.
+func buildIndex(chunks []PayloadChunk, txIds, keyFilterTxIds [][]byte) ([]byte, error) {
+	if len(chunks) != len(txIds) {
+		return nil, fmt.Errorf("%d chunks but %d tx IDs", len(chunks), len(txIds))
+	}
+	format, entryLen := IndexFormatV1, IndexEntryWithInfoLen
+	if keyFilterTxIds != nil {
+		format, entryLen = IndexFormatV2, IndexEntryWithKeyFilterLen
+	}
+	index := make([]byte, 0, IndexHeaderLen+len(chunks)*entryLen)
+	index = append(append(index, indexHeaderMagic...), format)
+	for i, chunk := range chunks {
+		if len(txIds[i]) != Sha256Base64Len {
+			return nil, fmt.Errorf("tx ID %q is not %d bytes long", txIds[i], Sha256Base64Len)
+		}
+		if keyFilterTxIds != nil && keyFilterTxIds[i] != nil && len(keyFilterTxIds[i]) != Sha256Base64Len {
+			return nil, fmt.Errorf("key filter tx ID %q is not %d bytes long", keyFilterTxIds[i], Sha256Base64Len)
+		}
+		if i > 0 && string(chunk.KeyPrefix) < string(chunks[i-1].KeyPrefix) {
+			return nil, fmt.Errorf("chunk %d is out of order", i)
+		}
+		prefix := make([]byte, IndexKeyPrefixLen)
+		copy(prefix, chunk.KeyPrefix)
+		index = append(index, prefix...)
+		index = append(index, txIds[i]...)
+		index = append(index, encodeIndexEntryInfo(chunk.Info)...)
+		if keyFilterTxIds != nil {
+			keyFilterTxId := make([]byte, Sha256Base64Len)
+			copy(keyFilterTxId, keyFilterTxIds[i])
+			index = append(index, keyFilterTxId...)
+		}
+	}
+	return index, nil
+}
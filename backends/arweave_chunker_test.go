@@ -0,0 +1,152 @@
+package backends
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randomKVs(r *rand.Rand) map[string][]byte {
+	kvs := map[string][]byte{}
+	longPrefix := strings.Repeat("p", IndexKeyPrefixLen)
+	for i := r.Intn(200); i > 0; i-- {
+		key := make([]byte, 1+r.Intn(16))
+		for j := range key {
+			key[j] = byte(' ' + r.Intn(95))
+		}
+		if r.Intn(10) == 0 {
+			// keys sharing their IndexKeyPrefixLen prefix
+			key = append([]byte(longPrefix), key...)
+		}
+		value := make([]byte, r.Intn(64))
+		for j := range value {
+			value[j] = byte('a' + r.Intn(26))
+		}
+		kvs[string(key)] = value
+	}
+	return kvs
+}
+
+func TestChunkPolicyProperties(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		kvs := randomKVs(r)
+		policy := ChunkPolicy{TargetPayloadBytes: 64 + r.Intn(1024), MaxKeysPerPayload: r.Intn(10)}
+		chunks, err := policy.Chunk(kvs)
+		require.Nil(t, err)
+
+		// the same input, built in another order, produces the same chunks
+		shuffled := map[string][]byte{}
+		keys := []string{}
+		for key := range kvs {
+			keys = append(keys, key)
+		}
+		r.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+		for _, key := range keys {
+			shuffled[key] = kvs[key]
+		}
+		reordered, err := policy.Chunk(shuffled)
+		require.Nil(t, err)
+		require.Equal(t, chunks, reordered)
+
+		seen := map[string]int{}
+		for i, chunk := range chunks {
+			payload := map[string]string{}
+			require.Nil(t, json.Unmarshal(chunk.Payload, &payload))
+			require.Equal(t, IndexEntryInfo{PayloadSize: uint64(len(chunk.Payload)), KeyCount: uint32(len(payload)), Codec: CodecJSON}, chunk.Info)
+			if policy.MaxKeysPerPayload > 0 {
+				require.LessOrEqual(t, len(payload), policy.MaxKeysPerPayload)
+			}
+			if len(payload) > 1 {
+				require.LessOrEqual(t, len(chunk.Payload), policy.TargetPayloadBytes)
+			}
+			if i > 0 {
+				require.LessOrEqual(t, string(chunks[i-1].KeyPrefix), string(chunk.KeyPrefix))
+			}
+			for key := range payload {
+				seen[key]++
+				require.LessOrEqual(t, truncateKeyPrefix(key), string(chunk.KeyPrefix))
+			}
+		}
+		require.Equal(t, len(kvs), len(seen))
+		for key := range kvs {
+			require.Equal(t, 1, seen[key])
+		}
+		requireReadBack(t, chunks, kvs)
+	}
+}
+
+// requireReadBack checks that every key of kvs is found in chunks by
+// ArweaveDB, including keys sharing their IndexKeyPrefixLen prefix.
+func requireReadBack(t *testing.T, chunks []PayloadChunk, kvs map[string][]byte) {
+	txData, txIds, indices := [][]byte{}, [][]byte{}, []int{}
+	for i, chunk := range chunks {
+		txData = append(txData, chunk.Payload)
+		txIds = append(txIds, []byte(intToBase64Sha256(i)))
+		indices = append(indices, i)
+	}
+	index, err := BuildIndex(chunks, txIds)
+	require.Nil(t, err)
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, indices)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	for key, expected := range kvs {
+		value, err := mockDB.Get(append(v0Bz, []byte(key)...))
+		require.Nil(t, err)
+		require.Equal(t, string(expected), string(value))
+	}
+}
+
+func TestChunkedIndexReadBack(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	kvs := map[string][]byte{}
+	for i := 0; i < 500; i++ {
+		kvs[string(rune('a'+r.Intn(26)))+string(rune('a'+r.Intn(26)))+string(rune('a'+i%26))] = []byte{byte('a' + r.Intn(26))}
+	}
+	chunks, err := ChunkPolicy{TargetPayloadBytes: 256}.Chunk(kvs)
+	require.Nil(t, err)
+	require.Greater(t, len(chunks), 1)
+
+	txData := [][]byte{}
+	txIds := [][]byte{}
+	indices := []int{}
+	for i, chunk := range chunks {
+		txData = append(txData, chunk.Payload)
+		txIds = append(txIds, []byte(intToBase64Sha256(i)))
+		indices = append(indices, i)
+	}
+	index, err := BuildIndex(chunks, txIds)
+	require.Nil(t, err)
+	rechunked, err := ChunkPolicy{TargetPayloadBytes: 256}.Chunk(kvs)
+	require.Nil(t, err)
+	rebuilt, err := BuildIndex(rechunked, txIds)
+	require.Nil(t, err)
+	require.Equal(t, index, rebuilt)
+
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, indices)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	for key, expected := range kvs {
+		value, err := mockDB.Get(append(v0Bz, []byte(key)...))
+		require.Nil(t, err)
+		require.Equal(t, expected, value)
+	}
+	count := 0
+	iter, err := mockDB.Iterator(append(v0Bz, []byte("a")...), append(v0Bz, []byte("{")...))
+	require.Nil(t, err)
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	require.Equal(t, len(kvs), count)
+
+	_, err = BuildIndex(chunks, txIds[1:])
+	require.NotNil(t, err)
+	_, err = BuildIndex([]PayloadChunk{chunks[1], chunks[0]}, txIds[:2])
+	require.NotNil(t, err)
+	_, err = ChunkPolicy{}.Chunk(map[string][]byte{"k": {0xff}})
+	require.NotNil(t, err)
+}
@@ -1,9 +1,9 @@
 package backends
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -23,36 +23,72 @@ type TransactionChunk struct {
 	TxPath   string `json:"tx_path"`
 }
 
+var (
+	_ ContextGateway  = (*Client)(nil)
+	_ MetadataGateway = (*Client)(nil)
+)
+
 type Client struct {
-	client *http.Client
-	url    string
+	client   *http.Client
+	url      string
+	decorate RequestDecorator
+}
+
+// GatewayConfig describes how to talk to an Arweave gateway.
+type GatewayConfig struct {
+	URL      string
+	ProxyURL string
+	// Decorate, if set, is invoked on every request sent to the gateway,
+	// including retries.
+	Decorate RequestDecorator
 }
 
 func NewClient(nodeUrl string, proxyUrl ...string) *Client {
-	httpClient := http.DefaultClient
+	cfg := GatewayConfig{URL: nodeUrl}
 	// if exist proxy url
 	if len(proxyUrl) > 0 {
-		pUrl := proxyUrl[0]
-		proxyUrl, err := url.Parse(pUrl)
+		cfg.ProxyURL = proxyUrl[0]
+	}
+	client, err := NewGatewayClient(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func NewGatewayClient(cfg GatewayConfig) (*Client, error) {
+	httpClient := http.DefaultClient
+	if cfg.ProxyURL != "" {
+		proxyUrl, err := url.Parse(cfg.ProxyURL)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		tr := &http.Transport{Proxy: http.ProxyURL(proxyUrl)}
 		httpClient = &http.Client{Transport: tr}
 	}
+	return &Client{client: httpClient, url: cfg.URL, decorate: cfg.Decorate}, nil
+}
+
+// URL returns the URL of the gateway.
+func (c *Client) URL() string {
+	return c.url
+}
 
-	return &Client{client: httpClient, url: nodeUrl}
+// SetRequestDecorator replaces the decorator invoked on every request. It
+// must be called before the client is used concurrently.
+func (c *Client) SetRequestDecorator(decorate RequestDecorator) {
+	c.decorate = decorate
 }
 
-func (c *Client) getTransactionOffset(id string) (*TransactionOffset, error) {
+func (c *Client) getTransactionOffset(ctx context.Context, id string) (*TransactionOffset, error) {
 	_path := fmt.Sprintf("tx/%s/offset", id)
-	body, statusCode, err := c.httpGet(_path)
-	if statusCode != 200 {
-		return nil, errors.New("not found tx offset")
-	}
+	body, statusCode, err := c.httpGet(ctx, _path)
 	if err != nil {
 		return nil, err
 	}
+	if statusCode != 200 {
+		return nil, &ErrGatewayStatus{what: "not found tx offset", statusCode: statusCode}
+	}
 	txOffset := &TransactionOffset{}
 	if err := json.Unmarshal(body, txOffset); err != nil {
 		return nil, err
@@ -60,7 +96,30 @@ func (c *Client) getTransactionOffset(id string) (*TransactionOffset, error) {
 	return txOffset, nil
 }
 
-func (c *Client) httpGet(_path string) (body []byte, statusCode int, err error) {
+// TransactionMetadataContext fetches the metadata of the transaction id.
+func (c *Client) TransactionMetadataContext(ctx context.Context, id string) (*TxMetadata, error) {
+	body, statusCode, err := c.httpGet(ctx, fmt.Sprintf("tx/%s", id))
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != 200 {
+		return nil, &ErrGatewayStatus{what: "not found tx", statusCode: statusCode}
+	}
+	metadata := &TxMetadata{}
+	if err := json.Unmarshal(body, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func (c *Client) httpGet(ctx context.Context, _path string) (body []byte, statusCode int, err error) {
+	body, statusCode, _, err = c.httpGetWithHeader(ctx, _path, nil)
+	return
+}
+
+// httpGetWithHeader is httpGet sending the given request headers, and
+// returning those of the response.
+func (c *Client) httpGetWithHeader(ctx context.Context, _path string, header http.Header) (body []byte, statusCode int, respHeader http.Header, err error) {
 	u, err := url.Parse(c.url)
 	if err != nil {
 		return
@@ -68,22 +127,45 @@ func (c *Client) httpGet(_path string) (body []byte, statusCode int, err error)
 
 	u.Path = path.Join(u.Path, _path)
 
-	resp, err := c.client.Get(u.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.decorate != nil {
+		if decorateErr := c.decorate(req); decorateErr != nil {
+			err = &ErrRequestDecoration{url: u.String(), traceID: TraceIDFromContext(ctx), err: decorateErr}
+			return
+		}
+	}
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
-	statusCode = resp.StatusCode
+	statusCode, respHeader = resp.StatusCode, resp.Header
 	body, err = ioutil.ReadAll(resp.Body)
 	return
 }
 
 func (c *Client) DownloadChunkData(id string) ([]byte, error) {
-	offsetResponse, err := c.getTransactionOffset(id)
+	return c.DownloadChunkDataContext(context.Background(), id)
+}
+
+// DownloadChunkDataContext implements ContextGateway.
+func (c *Client) DownloadChunkDataContext(ctx context.Context, id string) ([]byte, error) {
+	offsetResponse, err := c.getTransactionOffset(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	return c.downloadChunks(ctx, offsetResponse)
+}
+
+// downloadChunks downloads the chunks of the transaction at offsetResponse.
+func (c *Client) downloadChunks(ctx context.Context, offsetResponse *TransactionOffset) ([]byte, error) {
 	size, err := strconv.ParseInt(offsetResponse.Size, 10, 64)
 	if err != nil {
 		return nil, err
@@ -95,7 +177,7 @@ func (c *Client) DownloadChunkData(id string) ([]byte, error) {
 	startOffset := endOffset - size + 1
 	data := make([]byte, 0, size)
 	for i := 0; int64(i)+startOffset < endOffset; {
-		chunkData, err := c.getChunkData(int64(i) + startOffset)
+		chunkData, err := c.getChunkData(ctx, int64(i)+startOffset)
 		if err != nil {
 			return nil, err
 		}
@@ -105,23 +187,23 @@ func (c *Client) DownloadChunkData(id string) ([]byte, error) {
 	return data, nil
 }
 
-func (c *Client) getChunkData(offset int64) ([]byte, error) {
-	chunk, err := c.getChunk(offset)
+func (c *Client) getChunkData(ctx context.Context, offset int64) ([]byte, error) {
+	chunk, err := c.getChunk(ctx, offset)
 	if err != nil {
 		return nil, err
 	}
 	return base64.RawURLEncoding.DecodeString(chunk.Chunk)
 }
 
-func (c *Client) getChunk(offset int64) (*TransactionChunk, error) {
+func (c *Client) getChunk(ctx context.Context, offset int64) (*TransactionChunk, error) {
 	_path := "chunk/" + strconv.FormatInt(offset, 10)
-	body, statusCode, err := c.httpGet(_path)
-	if statusCode != 200 {
-		return nil, errors.New("not found chunk data")
-	}
+	body, statusCode, err := c.httpGet(ctx, _path)
 	if err != nil {
 		return nil, err
 	}
+	if statusCode != 200 {
+		return nil, &ErrGatewayStatus{what: "not found chunk data", statusCode: statusCode}
+	}
 	txChunk := &TransactionChunk{}
 	if err := json.Unmarshal(body, txChunk); err != nil {
 		return nil, err
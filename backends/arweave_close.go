@@ -0,0 +1,101 @@
+package backends
+
+import (
+	"context"
+	"sync"
+)
+
+// lifecycle is shared by a DB and its views, so that closing the DB cancels
+// the work of all of them.
+type lifecycle struct {
+	// ctx is cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	// error of the first Close
+	err error
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: closedContext{ctx}, cancel: cancel}
+}
+
+// closedContext fails with ErrDBClosed once done, as do the contexts derived
+// from it, so that reads waiting on them report why they stopped.
+type closedContext struct {
+	context.Context
+}
+
+func (ctx closedContext) Err() error {
+	if ctx.Context.Err() != nil {
+		return ErrDBClosed
+	}
+	return nil
+}
+
+// closedErr returns ErrDBClosed once the DB is closed.
+func (db *ArweaveDB) closedErr() error {
+	if db.lifecycle == nil || db.lifecycle.ctx.Err() == nil {
+		return nil
+	}
+	return ErrDBClosed
+}
+
+// getterResult is what a getter returned, or the value it panicked with.
+type getterResult struct {
+	data     []byte
+	err      error
+	panicked bool
+	panicVal interface{}
+}
+
+// callGetter calls getter with key unless the DB is closed. Failures of
+// getters interrupted by Close are reported as ErrDBClosed. The getter runs
+// on the goroutine of the caller: only its sources, below the caches and
+// middlewares, are abandoned on Close, see lifecycle.fetch.
+func (db *ArweaveDB) callGetter(getter Getter, key []byte) ([]byte, error) {
+	if err := db.closedErr(); err != nil {
+		return nil, err
+	}
+	defer db.trackInFlight()()
+	data, err := getter(key)
+	if err != nil && db.closedErr() != nil {
+		return nil, ErrDBClosed
+	}
+	return data, err
+}
+
+// fetch calls source with key and ctx, cancelled once the DB is closed as
+// well, returning ErrDBClosed as soon as it is without waiting for sources
+// which don't honor contexts. Their result is dropped once they return.
+// Panics of source are propagated to the caller.
+func (lc *lifecycle) fetch(ctx context.Context, source ContextGetter, key []byte) ([]byte, error) {
+	if lc == nil {
+		return source(ctx, key)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	res := make(chan getterResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				res <- getterResult{panicked: true, panicVal: r}
+			}
+		}()
+		data, err := source(ctx, key)
+		res <- getterResult{data: data, err: err}
+	}()
+	select {
+	case r := <-res:
+		if r.panicked {
+			panic(r.panicVal)
+		}
+		if r.err != nil && lc.ctx.Err() != nil {
+			return nil, ErrDBClosed
+		}
+		return r.data, r.err
+	case <-lc.ctx.Done():
+		return nil, ErrDBClosed
+	}
+}
@@ -0,0 +1,160 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blockingGetter returns a getter signalling started on its first call and
+// blocking until release is closed.
+func blockingGetter(getter Getter, started chan<- struct{}, release <-chan struct{}) Getter {
+	first := make(chan struct{}, 1)
+	first <- struct{}{}
+	return func(key []byte) ([]byte, error) {
+		select {
+		case <-first:
+			close(started)
+		default:
+		}
+		<-release
+		return getter(key)
+	}
+}
+
+func TestCloseUnblocksSlowGet(t *testing.T) {
+	mockDB := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	db := NewArweaveDBWithGetters(blockingGetter(mockDB.txDataByIdGetter, started, release), mockDB.versionTxIdGetter)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := db.Get(versionedKey(0, "a"))
+		errs <- err
+	}()
+	<-started
+	go db.Close()
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, ErrDBClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Get still blocked after Close")
+	}
+
+	_, err := db.Get(versionedKey(0, "a"))
+	require.ErrorIs(t, err, ErrDBClosed)
+	_, err = db.Has(versionedKey(0, "a"))
+	require.ErrorIs(t, err, ErrDBClosed)
+	_, err = db.Iterator(versionedKey(0, "a"), versionedKey(0, "b"))
+	require.ErrorIs(t, err, ErrDBClosed)
+}
+
+func TestCloseCancelsContextGetters(t *testing.T) {
+	mockDB := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	started := make(chan struct{})
+	db := NewArweaveDBWithContextGetters(func(ctx context.Context, txId []byte) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, IgnoringContext(mockDB.versionTxIdGetter))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := db.Get(versionedKey(0, "a"))
+		errs <- err
+	}()
+	<-started
+	require.NoError(t, db.Close())
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, ErrDBClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Get still blocked after Close")
+	}
+}
+
+func TestCloseInvalidatesIterators(t *testing.T) {
+	db := NewMockArweaveDB(
+		[][]byte{mockIndex([]string{"b", "d"}, []int{0, 1})},
+		[][]byte{mockTxData([]string{"a", "b"}, []string{"1", "2"}), mockTxData([]string{"c", "d"}, []string{"3", "4"})},
+		[]int{0, 1},
+	)
+	iter, err := db.Iterator(versionedKey(0, "a"), versionedKey(0, "z"))
+	require.NoError(t, err)
+	require.True(t, iter.Valid())
+
+	require.NoError(t, db.Close())
+	iter.Next()
+	requireInvalid(t, iter)
+	require.ErrorIs(t, iter.Error(), ErrDBClosed)
+	require.NoError(t, iter.Close())
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	closeErr := errors.New("index DB failure")
+	closed := 0
+	db := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	db.closer = func() error {
+		closed++
+		return closeErr
+	}
+
+	require.ErrorIs(t, db.Close(), closeErr)
+	require.ErrorIs(t, db.Close(), closeErr)
+	require.Equal(t, 1, closed)
+	_, err := db.Get(versionedKey(0, "a"))
+	require.ErrorIs(t, err, ErrDBClosed)
+}
+
+func TestCloseDetachesOnlySources(t *testing.T) {
+	// onTestGoroutine tells whether it is called by the goroutine running the
+	// test rather than one started by the DB
+	onTestGoroutine := func() bool {
+		buf := make([]byte, 64<<10)
+		return bytes.Contains(buf[:runtime.Stack(buf, false)], []byte(".TestCloseDetachesOnlySources("))
+	}
+	mockDB := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	var sourceCalls, cacheCalls, detachedCacheCalls, inlineSourceCalls int
+	source := func(txId []byte) ([]byte, error) {
+		sourceCalls++
+		if onTestGoroutine() {
+			inlineSourceCalls++
+		}
+		return mockDB.txDataByIdGetter(txId)
+	}
+	cache := map[string][]byte{}
+	db := NewArweaveDBWithGetters(source, mockDB.versionTxIdGetter, WithGetterMiddleware(func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			cacheCalls++
+			if !onTestGoroutine() {
+				detachedCacheCalls++
+			}
+			if data, ok := cache[string(key)]; ok {
+				return data, nil
+			}
+			data, err := next(key)
+			if err == nil {
+				cache[string(key)] = data
+			}
+			return data, err
+		}
+	}))
+
+	for i := 0; i < 3; i++ {
+		db.ClearDecodedPayloadCache()
+		value, err := db.Get(versionedKey(0, "a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("1"), value)
+	}
+	// the tx data cache is also hit by the version getter and index fetches
+	require.Greater(t, cacheCalls, 3)
+	require.Equal(t, 0, detachedCacheCalls)
+	require.Equal(t, 2, sourceCalls)
+	require.Equal(t, 0, inlineSourceCalls)
+}
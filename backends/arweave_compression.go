@@ -0,0 +1,188 @@
+package backends
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TxCompression is the compression of the data of a transaction. Payloads
+// declare theirs in their index entry, see IndexEntryInfo, and indices in
+// their header, so that uncompressed data is never mistaken for compressed
+// data whatever it starts with.
+type TxCompression uint8
+
+const (
+	CompressionNone TxCompression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+func (c TxCompression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("compression %d", uint8(c))
+}
+
+// DefaultMaxDecompressedSize is the number of bytes transaction data may
+// decompress to unless set by WithMaxDecompressedSize.
+const DefaultMaxDecompressedSize int64 = 256 << 20
+
+// ErrDecompressedTooLarge is wrapped by the ErrCorruptCompression of data
+// decompressing to more bytes than allowed.
+var ErrDecompressedTooLarge = errors.New("decompressed data exceeds the size limit")
+
+// Index tx IDs returned by version getters may be compressed with gzip or
+// zstd too, which ArweaveDB tells by their magic bytes: none of them is a
+// base64 character, so that valid tx IDs can't start with them.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// WithMaxDecompressedSize limits the bytes compressed transaction data may
+// decompress to, so that a malicious or corrupt transaction can't exhaust
+// memory. Data decompressing to more fails with an ErrCorruptCompression
+// wrapping ErrDecompressedTooLarge. Defaults to DefaultMaxDecompressedSize.
+func WithMaxDecompressedSize(bytes int64) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if bytes <= 0 {
+			db.invalidOption(fmt.Errorf("WithMaxDecompressedSize needs a positive size, got %d", bytes))
+			return
+		}
+		db.maxDecompressed = bytes
+	}
+}
+
+// decompressionLimit returns the bytes transaction data may decompress to.
+func (db *ArweaveDB) decompressionLimit() int64 {
+	if db.maxDecompressed > 0 {
+		return db.maxDecompressed
+	}
+	return DefaultMaxDecompressedSize
+}
+
+// CompressTx returns data compressed with compression, e.g. a payload whose
+// index entry declares it.
+func CompressTx(data []byte, compression TxCompression) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		buf := bytes.Buffer{}
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		return ZstdCompressor.Compress(data), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %d", compression)
+}
+
+// decompress returns data decompressed with compression, failing if it
+// decompresses to more than limit bytes. what describes data in errors.
+func decompress(data []byte, compression TxCompression, limit int64, what string) ([]byte, error) {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case CompressionZstd:
+		var decoder *zstd.Decoder
+		if decoder, err = zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1)); err == nil {
+			defer decoder.Close()
+			r = decoder
+		}
+	default:
+		err = fmt.Errorf("unknown compression %d", compression)
+	}
+	var decompressed []byte
+	if err == nil {
+		decompressed, err = ioutil.ReadAll(io.LimitReader(r, limit+1))
+	}
+	if err == nil && int64(len(decompressed)) > limit {
+		err = fmt.Errorf("%w: more than %d bytes", ErrDecompressedTooLarge, limit)
+	}
+	if err != nil {
+		return nil, &ErrCorruptCompression{what: what, format: compression.String(), err: err}
+	}
+	return decompressed, nil
+}
+
+// decompressPayload returns the payload of entry decompressed as declared
+// by the entry.
+func decompressPayload(entry IndexEntry, data []byte, limit int64) ([]byte, error) {
+	return decompress(data, entry.info.Compression, limit, fmt.Sprintf("Transaction %s", entry.txId))
+}
+
+// decompressIndex returns the index of txId with its entries decompressed
+// if its header declares them compressed, and its header telling they
+// aren't. Headerless legacy indices are never compressed.
+func decompressIndex(txId, index []byte, limit int64) ([]byte, error) {
+	if len(index) < IndexHeaderLen || string(index[:len(indexHeaderMagic)]) != indexHeaderMagic {
+		return index, nil
+	}
+	header := index[len(indexHeaderMagic)]
+	compression := TxCompression(header >> indexCompressionShift)
+	if compression == CompressionNone {
+		return index, nil
+	}
+	entries, err := decompress(index[IndexHeaderLen:], compression, limit, fmt.Sprintf("Index %s", txId))
+	if err != nil {
+		return nil, err
+	}
+	decompressed := make([]byte, 0, IndexHeaderLen+len(entries))
+	decompressed = append(append(decompressed, indexHeaderMagic...), header&indexFormatMask)
+	return append(decompressed, entries...), nil
+}
+
+// CompressIndex returns index, which must have a header, with its entries
+// compressed with compression, as declared in its header.
+func CompressIndex(index []byte, compression TxCompression) ([]byte, error) {
+	if indexFormat(index) == LegacyIndexFormat {
+		return nil, errors.New("legacy indices can't be compressed")
+	}
+	if compression == CompressionNone {
+		return index, nil
+	}
+	entries, err := CompressTx(index[IndexHeaderLen:], compression)
+	if err != nil {
+		return nil, err
+	}
+	compressed := make([]byte, 0, IndexHeaderLen+len(entries))
+	compressed = append(append(compressed, indexHeaderMagic...), index[len(indexHeaderMagic)]|byte(compression)<<indexCompressionShift)
+	return append(compressed, entries...), nil
+}
+
+// decompressTxId returns the index tx ID returned by the version getter
+// for version decompressed if it is compressed, see gzipMagic.
+func decompressTxId(txId []byte, version uint64, limit int64) ([]byte, error) {
+	compression := CompressionNone
+	switch {
+	case bytes.HasPrefix(txId, gzipMagic):
+		compression = CompressionGzip
+	case bytes.HasPrefix(txId, zstdMagic):
+		compression = CompressionZstd
+	}
+	return decompress(txId, compression, limit, fmt.Sprintf("Index tx ID of version %d", version))
+}
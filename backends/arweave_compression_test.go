@@ -0,0 +1,150 @@
+package backends
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(data []byte) []byte {
+	compressed, err := CompressTx(data, CompressionGzip)
+	if err != nil {
+		panic(err)
+	}
+	return compressed
+}
+
+func compressedIndex(index []byte, compression TxCompression) []byte {
+	compressed, err := CompressIndex(index, compression)
+	if err != nil {
+		panic(err)
+	}
+	return compressed
+}
+
+func TestCompressedTxData(t *testing.T) {
+	infos := []IndexEntryInfo{
+		{Codec: CodecJSON, Compression: CompressionGzip},
+		{Codec: CodecBinary, Compression: CompressionZstd},
+		// written without metadata
+		{},
+	}
+	indices := [][]byte{
+		compressedIndex(mockIndexV1([]string{"b", "d", "f"}, []int{0, 1, 2}, infos), CompressionGzip),
+		compressedIndex(mockIndexV1([]string{"f"}, []int{2}, infos[2:]), CompressionZstd),
+	}
+	txData := [][]byte{
+		gzipped(mockTxData([]string{"a", "b"}, []string{"1", "2"})),
+		ZstdCompressor.Compress(mockTxDataBinary([]string{"c", "d"}, []string{"\x1f\x8b", "4"})),
+		mockTxData([]string{"e", "f"}, []string{"5", "6"}),
+	}
+	db := NewMockArweaveDB(indices, txData, []int{0, 1, 2})
+	// index tx IDs may be compressed too
+	versions := db.versionTxIdGetter
+	db.versionTxIdGetter = func(version []byte) ([]byte, error) {
+		txId, err := versions(version)
+		if err != nil || version[7] == 0 {
+			return txId, err
+		}
+		return gzipped(txId), nil
+	}
+
+	pairs := []KVPair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: []byte("\x1f\x8b")},
+		{Key: []byte("d"), Value: []byte("4")},
+		{Key: []byte("e"), Value: []byte("5")},
+		{Key: []byte("f"), Value: []byte("6")},
+	}
+	for version, expected := range [][]KVPair{pairs, pairs[4:]} {
+		version := uint64(version)
+		iter, err := db.Iterator(versionedKey(version, ""), nil)
+		require.Nil(t, err)
+		require.Equal(t, expected, collectPairs(t, iter))
+		for _, pair := range expected {
+			value, err := db.Get(versionedKey(version, string(pair.Key)))
+			require.Nil(t, err)
+			require.Equal(t, pair.Value, value)
+		}
+	}
+
+	desc, err := db.DescribeIndex(0)
+	require.Nil(t, err)
+	require.Equal(t, IndexFormatV1, desc.Format)
+	require.Equal(t, CompressionZstd, desc.Entries[1].Info.Compression)
+}
+
+func TestUncompressedDataStartingWithMagic(t *testing.T) {
+	// compression is declared, so that a legacy index whose first key
+	// prefix looks like a gzip stream is read as is
+	index := mockIndex([]string{"\x1f\x8b", "z"}, []int{0, 1})
+	txData := [][]byte{
+		mockTxData([]string{"\x1fa"}, []string{"1"}),
+		mockTxData([]string{"a"}, []string{"2"}),
+	}
+	db := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	value, err := db.Get(versionedKey(0, "\x1fa"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), value)
+	value, err = db.Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("2"), value)
+}
+
+func TestCorruptCompressedTxData(t *testing.T) {
+	payload := gzipped(mockTxData([]string{"a"}, []string{"1"}))
+	zstdPayload := ZstdCompressor.Compress(mockTxData([]string{"a"}, []string{"1"}))
+	for format, tc := range map[string]struct {
+		compression TxCompression
+		corrupt     []byte
+	}{
+		"gzip": {CompressionGzip, append(append([]byte{}, payload[:10]...), "garbage"...)},
+		"zstd": {CompressionZstd, zstdPayload[:len(zstdPayload)-2]},
+	} {
+		index := mockIndexV1([]string{"b"}, []int{0}, []IndexEntryInfo{{Codec: CodecJSON, Compression: tc.compression}})
+		db := NewMockArweaveDB([][]byte{index}, [][]byte{tc.corrupt}, []int{0})
+		_, err := db.Get(versionedKey(0, "a"))
+		corruptErr := &ErrCorruptCompression{}
+		require.True(t, errors.As(err, &corruptErr), "%s: %v", format, err)
+		require.Equal(t, format, corruptErr.Format())
+		require.Contains(t, err.Error(), intToBase64Sha256(0))
+
+		iter, err := db.Iterator(versionedKey(0, ""), nil)
+		if err == nil {
+			require.False(t, iter.Valid())
+			err = iter.Error()
+			iter.Close()
+		}
+		require.True(t, errors.As(err, new(*ErrCorruptCompression)), "%s: %v", format, err)
+	}
+}
+
+func TestDecompressedSizeLimit(t *testing.T) {
+	value := string(bytes.Repeat([]byte("0"), 1000))
+	payload := mockTxData([]string{"a"}, []string{value})
+	for name, compression := range map[string]TxCompression{"gzip": CompressionGzip, "zstd": CompressionZstd} {
+		compressed, err := CompressTx(payload, compression)
+		require.Nil(t, err)
+		index := mockIndexV1([]string{"b"}, []int{0}, []IndexEntryInfo{{Codec: CodecJSON, Compression: compression}})
+		db := NewMockArweaveDB([][]byte{index}, [][]byte{compressed}, []int{0})
+
+		got, err := db.Get(versionedKey(0, "a"))
+		require.Nil(t, err, name)
+		require.Equal(t, value, string(got))
+
+		WithMaxDecompressedSize(int64(len(payload) - 1))(db)
+		_, err = db.Get(versionedKey(0, "a"))
+		require.ErrorIs(t, err, ErrDecompressedTooLarge, name)
+		require.ErrorAs(t, err, new(*ErrCorruptCompression), name)
+	}
+
+	// indices are limited too
+	index := compressedIndex(mockIndexV1([]string{"b", "c"}, []int{0, 1}, []IndexEntryInfo{{}, {}}), CompressionGzip)
+	db := NewMockArweaveDB([][]byte{index}, nil, []int{0, 1})
+	WithMaxDecompressedSize(IndexEntryWithInfoLen)(db)
+	_, err := db.Get(versionedKey(0, "a"))
+	require.ErrorIs(t, err, ErrDecompressedTooLarge)
+}
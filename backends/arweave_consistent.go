@@ -0,0 +1,109 @@
+package backends
+
+import (
+	"fmt"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Reader reads the keys of a single version of an ArweaveDB, all from the
+// same index. Keys are unversioned.
+type Reader interface {
+	Get(key []byte) ([]byte, error)
+	Has(key []byte) (bool, error)
+	Iterator(start, end []byte) (dbm.Iterator, error)
+	ReverseIterator(start, end []byte) (dbm.Iterator, error)
+	// Token returns the transaction ID of the index read, for responses to
+	// state which index they were read from.
+	Token() string
+}
+
+// pinnedIndex is the index of a version resolved once for all the reads
+// of a consistent reader.
+type pinnedIndex struct {
+	version uint64
+	txId    []byte
+	entries []IndexEntry
+}
+
+func (p *pinnedIndex) index(version uint64) ([]IndexEntry, error) {
+	if version != p.version {
+		return nil, fmt.Errorf("reader of version %d can't read version %d", p.version, version)
+	}
+	return p.entries, nil
+}
+
+func (p *pinnedIndex) indexTxId(version uint64) ([]byte, error) {
+	if version != p.version {
+		return nil, fmt.Errorf("reader of version %d can't read version %d", p.version, version)
+	}
+	return p.txId, nil
+}
+
+type consistentReader struct {
+	// view of the DB pinned to the index
+	db *ArweaveDB
+	// encoded version
+	prefix []byte
+	token  string
+}
+
+// ConsistentReader resolves the index of version once, and returns a
+// reader only reading from that index, whatever retraction records,
+// version mappings or cached indices are refreshed meanwhile. The reader
+// shares the state of db, which must not be closed while it is in use.
+func (db *ArweaveDB) ConsistentReader(version uint64) (Reader, error) {
+	prefix, err := db.codec().Encode(version)
+	if err != nil {
+		return nil, err
+	}
+	indexTxId, index, err := db.fetchIndex(version)
+	if err != nil {
+		return nil, err
+	}
+	if index, err = db.validatedIndex(version, indexTxId, index); err != nil {
+		return nil, err
+	}
+	view := *db
+	view.pinned = &pinnedIndex{version: version, txId: indexTxId, entries: parseIndex(index)}
+	return &consistentReader{db: &view, prefix: prefix, token: string(indexTxId)}, nil
+}
+
+func (r *consistentReader) key(key []byte) []byte {
+	if key == nil {
+		return nil
+	}
+	return append(append([]byte{}, r.prefix...), key...)
+}
+
+// Get implements Reader.
+func (r *consistentReader) Get(key []byte) ([]byte, error) {
+	return r.db.Get(r.key(key))
+}
+
+// Has implements Reader.
+func (r *consistentReader) Has(key []byte) (bool, error) {
+	return r.db.Has(r.key(key))
+}
+
+// Iterator implements Reader. A nil start iterates from the first key of
+// the version, and a nil end up to its last key.
+func (r *consistentReader) Iterator(start, end []byte) (dbm.Iterator, error) {
+	if start == nil {
+		start = []byte{}
+	}
+	return r.db.Iterator(r.key(start), r.key(end))
+}
+
+// ReverseIterator implements Reader. See Iterator.
+func (r *consistentReader) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	if start == nil {
+		start = []byte{}
+	}
+	return r.db.ReverseIterator(r.key(start), r.key(end))
+}
+
+// Token implements Reader.
+func (r *consistentReader) Token() string {
+	return r.token
+}
@@ -0,0 +1,129 @@
+package backends_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+// newRetractableDB returns a DB whose version 0 reads aa=bad, unless the
+// retraction record returned by record is refreshed, switching it to the
+// index of version 1, which reads aa=good.
+func newRetractableDB(t *testing.T, record func(archive *arweavetest.Archive) []byte) (*backends.ArweaveDB, *arweavetest.Archive) {
+	db, archive, err := arweavetest.NewArchiveBuilder().
+		AddVersion(0).AddTx("ab", map[string][]byte{"aa": []byte("bad"), "ab": []byte("1")}).
+		AddVersion(1).AddTx("ab", map[string][]byte{"aa": []byte("good"), "ab": []byte("1")}).
+		BuildDB(backends.WithIndexCache(4))
+	require.Nil(t, err)
+	backends.WithRetractions(func() ([]byte, string, error) {
+		return record(archive), "publisher", nil
+	}, func(string) error { return nil })(db)
+	return db, archive
+}
+
+// retractedRecord is a retraction record replacing the index of version 0
+// by that of version 1.
+func retractedRecord(archive *arweavetest.Archive) []byte {
+	return []byte(fmt.Sprintf(`{"versions": {"0": "%s"}}`, archive.IndexTxIds[1]))
+}
+
+func requireReads(t *testing.T, reader backends.Reader, expected string) {
+	value, err := reader.Get([]byte("aa"))
+	require.Nil(t, err)
+	require.Equal(t, expected, string(value))
+	has, err := reader.Has([]byte("ab"))
+	require.Nil(t, err)
+	require.True(t, has)
+	iter, err := reader.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"aa=" + expected, "ab=1"}, collectKV(t, iter))
+}
+
+func TestConsistentReader(t *testing.T) {
+	var mtx sync.Mutex
+	retracted := false
+	db, archive := newRetractableDB(t, func(archive *arweavetest.Archive) []byte {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if retracted {
+			return retractedRecord(archive)
+		}
+		return []byte(`{"versions": {}}`)
+	})
+
+	reader, err := db.ConsistentReader(0)
+	require.Nil(t, err)
+	require.Equal(t, archive.IndexTxIds[0], reader.Token())
+	requireReads(t, reader, "bad")
+
+	mtx.Lock()
+	retracted = true
+	mtx.Unlock()
+	require.Nil(t, db.RefreshRetractions())
+	value, err := db.Get(arweavetest.Key(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, "good", string(value))
+	requireReads(t, reader, "bad")
+
+	retractedReader, err := db.ConsistentReader(0)
+	require.Nil(t, err)
+	require.Equal(t, archive.IndexTxIds[1], retractedReader.Token())
+	requireReads(t, retractedReader, "good")
+
+	iter, err := reader.ReverseIterator([]byte("ab"), nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"ab=1"}, collectKV(t, iter))
+}
+
+func TestConsistentReaderRacingRefresh(t *testing.T) {
+	var mtx sync.Mutex
+	refreshes := 0
+	db, archive := newRetractableDB(t, func(archive *arweavetest.Archive) []byte {
+		mtx.Lock()
+		defer mtx.Unlock()
+		refreshes++
+		if refreshes%2 == 1 {
+			return retractedRecord(archive)
+		}
+		return []byte(`{"versions": {}}`)
+	})
+	reader, err := db.ConsistentReader(0)
+	require.Nil(t, err)
+
+	done := make(chan struct{})
+	refreshed := make(chan error)
+	go func() {
+		for {
+			select {
+			case <-done:
+				close(refreshed)
+				return
+			default:
+			}
+			if err := db.RefreshRetractions(); err != nil {
+				refreshed <- err
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		requireReads(t, reader, "bad")
+		require.Equal(t, archive.IndexTxIds[0], reader.Token())
+	}
+	close(done)
+	require.Nil(t, <-refreshed)
+}
+
+func TestConsistentReaderErrors(t *testing.T) {
+	db, _, err := arweavetest.NewArchiveBuilder().BuildDB()
+	require.Nil(t, err)
+	_, err = db.ConsistentReader(0)
+	require.Error(t, err)
+	backends.WithVersionCodec(backends.BigEndian32VersionCodec)(db)
+	_, err = db.ConsistentReader(1 << 40)
+	require.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package backends
+
+import (
+	"context"
+	"time"
+)
+
+// ContextGetter is a Getter honoring the cancellation and deadline of a
+// context.
+type ContextGetter func(ctx context.Context, key []byte) ([]byte, error)
+
+// IgnoringContext adapts getter to a ContextGetter, which can't be
+// interrupted but isn't called once the context is done.
+func IgnoringContext(getter Getter) ContextGetter {
+	return func(ctx context.Context, key []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return getter(key)
+	}
+}
+
+// Getter adapts g to a Getter fetching with the background context.
+func (g ContextGetter) Getter() Getter {
+	return func(key []byte) ([]byte, error) {
+		return g(context.Background(), key)
+	}
+}
+
+// NewArweaveDBWithContextGetters is NewArweaveDBWithGetters with getters
+// honoring the context of views returned by WithContext.
+//
+// Deprecated: Use NewArweaveDBFromOptions with WithTxDataGetter and
+// WithVersionResolver, which validates the options.
+func NewArweaveDBWithContextGetters(txDataByIdGetter ContextGetter, versionTxIdGetter ContextGetter, opts ...ArweaveOption) *ArweaveDB {
+	db := NewArweaveDBWithGetters(txDataByIdGetter.Getter(), versionTxIdGetter.Getter())
+	db.txDataSource, db.versionSource = txDataByIdGetter, versionTxIdGetter
+	db.bindSources()
+	for _, opt := range opts {
+		opt(db)
+	}
+	return db
+}
+
+// WithContext returns a view of db whose reads fetch with ctx, so that they
+// fail with the error of ctx once it is done, including mid-fetch if the
+// getters of db honor contexts. Iterators of the view stop when ctx is done
+// and report its error through Error. The view shares the state of db and
+// must not be closed.
+func (db *ArweaveDB) WithContext(ctx context.Context) *ArweaveDB {
+	view := *db
+	view.bindContext(ctx)
+	return &view
+}
+
+// context returns the context the DB is bound to.
+func (db *ArweaveDB) context() context.Context {
+	if db.ctx == nil {
+		if db.lifecycle != nil {
+			return db.lifecycle.ctx
+		}
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// bindContext binds a view to ctx, rebuilding its getters on top of their
+// sources.
+func (db *ArweaveDB) bindContext(ctx context.Context) {
+	db.ctx = ctx
+	if db.readOptions.Revalidate {
+		ctx = ContextWithRevalidation(ctx)
+	}
+	db.txDataByIdGetter = db.bindGetter(ctx, db.txDataSource, db.txDataMiddleware, db.txDataByIdGetter)
+	db.versionTxIdGetter = db.bindGetter(ctx, db.versionSource, db.versionMiddleware, db.versionTxIdGetter)
+}
+
+// bindSources binds the getters of a DB being constructed to its sources.
+func (db *ArweaveDB) bindSources() {
+	db.txDataByIdGetter = db.bindGetter(db.context(), db.txDataSource, nil, nil)
+	db.versionTxIdGetter = db.bindGetter(db.context(), db.versionSource, nil, nil)
+}
+
+// bindGetter returns the getter fetching with ctx from source through mw,
+// or fallback checking ctx beforehand if there is no source. Only the calls
+// to source are abandoned once db is closed, see lifecycle.fetch.
+func (db *ArweaveDB) bindGetter(ctx context.Context, source ContextGetter, mw boundMiddleware, fallback Getter) Getter {
+	if source == nil {
+		return func(key []byte) ([]byte, error) {
+			return IgnoringContext(fallback)(ctx, key)
+		}
+	}
+	lc := db.lifecycle
+	bind := func(ctx context.Context) Getter {
+		return func(key []byte) ([]byte, error) {
+			return lc.fetch(ctx, source, key)
+		}
+	}
+	if mw == nil {
+		return bind(ctx)
+	}
+	return mw(ctx, bind(ctx), bind)
+}
+
+// detachedContext carries the values of a context, e.g. its trace ID,
+// without its deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
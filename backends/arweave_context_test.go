@@ -0,0 +1,130 @@
+package backends
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// contextGetterCalls records the keys a ContextGetter is called with.
+type contextGetterCalls struct {
+	mtx  sync.Mutex
+	keys []string
+}
+
+func (c *contextGetterCalls) wrap(getter Getter, onCall func(key []byte)) ContextGetter {
+	return func(ctx context.Context, key []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.mtx.Lock()
+		c.keys = append(c.keys, string(key))
+		c.mtx.Unlock()
+		if onCall != nil {
+			onCall(key)
+		}
+		return getter(key)
+	}
+}
+
+func (c *contextGetterCalls) count() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.keys)
+}
+
+// newContextTestDB returns a DB over payloads of one key each, all under
+// the same index key prefix so that iterators have to load them in turn,
+// calling onCall on every tx data fetch.
+func newContextTestDB(keys []string, calls *contextGetterCalls, onCall func(key []byte)) *ArweaveDB {
+	prefixes, indices, txData := []string{}, []int{}, [][]byte{}
+	for i, key := range keys {
+		prefixes = append(prefixes, "z")
+		indices = append(indices, i)
+		txData = append(txData, mockTxData([]string{key}, []string{key}))
+	}
+	mockDB := NewMockArweaveDB([][]byte{mockIndex(prefixes, indices)}, txData, indices)
+	return NewArweaveDBWithContextGetters(calls.wrap(mockDB.txDataByIdGetter, onCall), IgnoringContext(mockDB.versionTxIdGetter))
+}
+
+func TestWithContextCancelledIteratorBuild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := &contextGetterCalls{}
+	db := newContextTestDB([]string{"a", "b", "c", "d"}, calls, func(key []byte) {
+		if string(key) == intToBase64Sha256(1) {
+			cancel()
+		}
+	})
+	// skipping to "d" loads the payloads of "a" to "c" first
+	_, err := db.WithContext(ctx).Iterator(versionedKey(0, "d"), versionedKey(0, "e"))
+	require.ErrorIs(t, err, context.Canceled)
+	// the index and the first two payloads, and nothing once cancelled
+	require.Equal(t, 3, calls.count())
+
+	iter, err := db.Iterator(versionedKey(0, "d"), versionedKey(0, "e"))
+	require.Nil(t, err)
+	require.Equal(t, "d", string(iter.Key()))
+	require.Nil(t, iter.Close())
+}
+
+func TestWithContextCancelledIteration(t *testing.T) {
+	calls := &contextGetterCalls{}
+	db := newContextTestDB([]string{"a", "b", "c", "d"}, calls, nil)
+	for _, reverse := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		iter, err := db.WithContext(ctx).IteratorWithOptions(versionedKey(0, "a"), versionedKey(0, "e"), IteratorOptions{Reverse: reverse})
+		require.Nil(t, err)
+		require.True(t, iter.Valid())
+		iter.Next()
+		require.True(t, iter.Valid())
+		cancel()
+		fetched := calls.count()
+		iter.Next()
+		require.False(t, iter.Valid())
+		require.ErrorIs(t, iter.Error(), context.Canceled)
+		require.Equal(t, fetched, calls.count())
+		require.Nil(t, iter.Close())
+	}
+}
+
+func TestWithContextDeadlineMidFetch(t *testing.T) {
+	mockDB := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	// the index is served, the payload hangs until the context is done
+	blocking := func(ctx context.Context, txId []byte) ([]byte, error) {
+		if string(txId) == intToBase64Sha256(0) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return mockDB.txDataByIdGetter(txId)
+	}
+	db := NewArweaveDBWithContextGetters(blocking, IgnoringContext(mockDB.versionTxIdGetter), WithTracing(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := db.WithContext(ctx).Get(versionedKey(0, "a"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = db.WithContext(ctx).Has(versionedKey(0, "a"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWithContextCancelledBeforeRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	versionCalls := 0
+	mockDB := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	db := NewArweaveDBWithGetters(mockDB.txDataByIdGetter, func(version []byte) ([]byte, error) {
+		versionCalls++
+		return mockDB.versionTxIdGetter(version)
+	}, WithGetterMiddleware(retryingMiddleware(3)))
+	_, err := db.WithContext(ctx).Get(versionedKey(0, "a"))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 0, versionCalls)
+
+	value, err := db.Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+	require.Equal(t, 1, versionCalls)
+}
@@ -0,0 +1,161 @@
+package backends
+
+import (
+	"errors"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// VersionedIterator iterates over the keys of several versions, its keys
+// being unversioned like those of ArweaveDB iterators.
+type VersionedIterator interface {
+	dbm.Iterator
+	// Version returns the version of the current key.
+	Version() uint64
+}
+
+// IteratorAcrossVersions returns an iterator over the unversioned keys from
+// startKey to endKey, excluded, of every version from startVersion to
+// endVersion, included, in ascending order of versions then keys. Nil keys
+// are unbounded. Versions missing from the archive are skipped, each of
+// them still costing a probe of the version getter, see HasVersion. The
+// iterator of a version is only created once the previous version is
+// iterated over, failures leaving the iterator invalid with the error.
+func (db *ArweaveDB) IteratorAcrossVersions(startVersion, endVersion uint64, startKey, endKey []byte) (VersionedIterator, error) {
+	if startVersion > endVersion {
+		return nil, errors.New("start version is after end version")
+	}
+	iter := &crossVersionIterator{
+		db:       db,
+		version:  startVersion,
+		last:     endVersion,
+		startKey: startKey,
+		endKey:   endKey,
+	}
+	if err := iter.seek(); err != nil {
+		return nil, err
+	}
+	return iter, nil
+}
+
+type crossVersionIterator struct {
+	db *ArweaveDB
+	// version of the current iterator, if any, and last version to iterate
+	version, last    uint64
+	startKey, endKey []byte
+
+	// iterator of version, nil once all the versions are iterated over
+	current dbm.Iterator
+	err     error
+	guard   iteratorGuard
+}
+
+var _ VersionedIterator = (*crossVersionIterator)(nil)
+
+// seek positions the iterator on the first key from version on.
+func (it *crossVersionIterator) seek() error {
+	for {
+		iter, err := it.versionIterator(it.version)
+		if err != nil {
+			return err
+		}
+		if iter != nil && iter.Valid() {
+			it.current = iter
+			return nil
+		}
+		if iter != nil {
+			err := iter.Error()
+			iter.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if it.version == it.last {
+			return nil
+		}
+		it.version++
+	}
+}
+
+// versionIterator returns the iterator of version, nil if it is missing.
+func (it *crossVersionIterator) versionIterator(version uint64) (dbm.Iterator, error) {
+	exists, err := it.db.HasVersion(version)
+	if err != nil || !exists {
+		return nil, err
+	}
+	prefix, err := it.db.codec().Encode(version)
+	if err != nil {
+		return nil, err
+	}
+	start := append(append([]byte{}, prefix...), it.startKey...)
+	var end []byte
+	if it.endKey != nil {
+		end = append(append([]byte{}, prefix...), it.endKey...)
+	}
+	return it.db.Iterator(start, end)
+}
+
+// Domain implements Iterator, returning the unversioned key bounds.
+func (it *crossVersionIterator) Domain() ([]byte, []byte) {
+	return it.startKey, it.endKey
+}
+
+// Valid implements Iterator.
+func (it *crossVersionIterator) Valid() bool {
+	return it.guard.valid(it.current != nil && it.err == nil)
+}
+
+func (it *crossVersionIterator) assertValid() {
+	it.guard.assertValid(it.current != nil && it.err == nil)
+}
+
+// Next implements Iterator, moving on to the next version once the keys of
+// the current one are iterated over.
+func (it *crossVersionIterator) Next() {
+	it.assertValid()
+	it.current.Next()
+	if it.current.Valid() {
+		return
+	}
+	err := it.current.Error()
+	it.current.Close()
+	it.current = nil
+	if err == nil && it.version < it.last {
+		it.version++
+		err = it.seek()
+	}
+	it.err = err
+}
+
+// Key implements Iterator.
+func (it *crossVersionIterator) Key() []byte {
+	it.assertValid()
+	return it.current.Key()
+}
+
+// Value implements Iterator.
+func (it *crossVersionIterator) Value() []byte {
+	it.assertValid()
+	return it.current.Value()
+}
+
+// Version implements VersionedIterator.
+func (it *crossVersionIterator) Version() uint64 {
+	it.assertValid()
+	return it.version
+}
+
+// Error implements Iterator.
+func (it *crossVersionIterator) Error() error {
+	return it.err
+}
+
+// Close implements Iterator.
+func (it *crossVersionIterator) Close() error {
+	if !it.guard.close() || it.current == nil {
+		return nil
+	}
+	err := it.current.Close()
+	it.current = nil
+	return err
+}
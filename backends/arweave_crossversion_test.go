@@ -0,0 +1,107 @@
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// newCrossVersionTestDB returns a DB with the versions of TestIterator as
+// versions 0 and 2, version 1 missing.
+func newCrossVersionTestDB() *ArweaveDB {
+	indices := [][]byte{
+		mockIndex([]string{"aa", "cd", "ce"}, []int{0, 1, 2}),
+		nil,
+		mockIndex([]string{"ac", "ce"}, []int{3, 4}),
+	}
+	txData := [][]byte{
+		mockTxData([]string{"aa"}, []string{"v1"}),
+		mockTxData([]string{"cc", "cd"}, []string{"v2", "v3"}),
+		mockTxData([]string{"ce"}, []string{"v4"}),
+		mockTxData([]string{"ac"}, []string{"v5"}),
+		mockTxData([]string{"cc", "ce"}, []string{"v6", "v7"}),
+	}
+	db := NewMockArweaveDB(indices, txData, []int{0, 1, 2, 3, 4})
+	getVersionTxId := db.versionTxIdGetter
+	db.versionTxIdGetter = func(version []byte) ([]byte, error) {
+		if binary.BigEndian.Uint64(version) == 1 {
+			return nil, NewErrKeyNotFound(version)
+		}
+		return getVersionTxId(version)
+	}
+	return db
+}
+
+func collectVersioned(t *testing.T, iter VersionedIterator) []string {
+	kvs := []string{}
+	for ; iter.Valid(); iter.Next() {
+		kvs = append(kvs, fmt.Sprintf("%d/%s=%s", iter.Version(), iter.Key(), iter.Value()))
+	}
+	require.Nil(t, iter.Error())
+	require.Nil(t, iter.Close())
+	return kvs
+}
+
+func TestIteratorAcrossVersions(t *testing.T) {
+	db := newCrossVersionTestDB()
+	for _, tc := range []struct {
+		startVersion, endVersion uint64
+		startKey, endKey         string
+		expected                 []string
+	}{
+		{0, 3, "", "", []string{"0/aa=v1", "0/cc=v2", "0/cd=v3", "0/ce=v4", "2/ac=v5", "2/cc=v6", "2/ce=v7"}},
+		{0, 2, "ab", "cd", []string{"0/cc=v2", "2/ac=v5", "2/cc=v6"}},
+		{0, 0, "cc", "", []string{"0/cc=v2", "0/cd=v3", "0/ce=v4"}},
+		{1, 2, "", "cd", []string{"2/ac=v5", "2/cc=v6"}},
+		// missing versions only
+		{1, 1, "", "", []string{}},
+		{3, 10, "", "", []string{}},
+		// ranges empty in some versions
+		{0, 2, "ab", "ac", []string{}},
+		{0, 2, "a", "ab", []string{"0/aa=v1"}},
+		{0, 2, "ab", "b", []string{"2/ac=v5"}},
+	} {
+		var endKey []byte
+		if tc.endKey != "" {
+			endKey = []byte(tc.endKey)
+		}
+		iter, err := db.IteratorAcrossVersions(tc.startVersion, tc.endVersion, []byte(tc.startKey), endKey)
+		require.Nil(t, err)
+		require.Equal(t, tc.expected, collectVersioned(t, iter), "%+v", tc)
+	}
+
+	_, err := db.IteratorAcrossVersions(2, 1, nil, nil)
+	require.Error(t, err)
+
+	requireIteratorContract(t, 7, func() (dbm.Iterator, error) { return db.IteratorAcrossVersions(0, 2, nil, nil) })
+}
+
+func TestIteratorAcrossVersionsFailures(t *testing.T) {
+	// failures creating the iterator of a later version stop the iterator
+	db := newCrossVersionTestDB()
+	ApplyMiddleware(db, func(next Getter) Getter {
+		return func(txId []byte) ([]byte, error) {
+			if string(txId) == intToBase64Sha256(3) {
+				return nil, errors.New("unavailable")
+			}
+			return next(txId)
+		}
+	})
+	iter, err := db.IteratorAcrossVersions(0, 2, nil, nil)
+	require.Nil(t, err)
+	count := 0
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	require.Equal(t, 4, count)
+	require.EqualError(t, iter.Error(), "unavailable")
+	require.Nil(t, iter.Close())
+
+	// failures of the first one fail the call
+	_, err = db.IteratorAcrossVersions(2, 2, nil, nil)
+	require.EqualError(t, err, "unavailable")
+}
@@ -0,0 +1,242 @@
+package backends
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// decodePool bounds the payloads decoded at once by the iterators of an
+// ArweaveDB reading ahead.
+type decodePool struct {
+	workers int
+	sem     chan struct{}
+	// bounds fetches unless WithFetchConcurrency does
+	fetches *fetchPool
+}
+
+// WithDecodeWorkers makes iterators read up to workers payloads ahead of
+// the one they are at, fetching them concurrently and decoding them on a
+// pool of workers shared by all the iterators of the DB, so that decoded
+// payloads are ready by the time they are reached. Payloads read ahead are
+// accounted against the iterator budget once decoded, and iterators don't
+// read further ahead while it is exceeded. Unless set by
+// WithFetchConcurrency, payloads are fetched DefaultFetchConcurrency at
+// once. 0 workers means decoding inline, without reading ahead unless
+// WithFetchConcurrency does.
+func WithDecodeWorkers(workers int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if workers < 0 {
+			db.invalidOption(fmt.Errorf("WithDecodeWorkers needs a non-negative number of workers, got %d", workers))
+		}
+		if workers <= 0 {
+			db.decodePool = nil
+			return
+		}
+		db.decodePool = &decodePool{
+			workers: workers,
+			sem:     make(chan struct{}, workers),
+			fetches: newFetchPool(DefaultFetchConcurrency),
+		}
+	}
+}
+
+// payloadLoad is a payload read ahead by an iterator.
+type payloadLoad struct {
+	txIdx int
+	// closed once loaded
+	done       chan struct{}
+	data       map[string]interface{}
+	sortedKeys []string
+	size       int64
+	err        error
+
+	mtx sync.Mutex
+	// bytes accounted against the iterator budget: the payload size of the
+	// entry reserved when starting the load, then the size of the decoded
+	// payload
+	held int64
+	// whether the iterator gave up the load, in which case nothing is
+	// accounted
+	released bool
+}
+
+// sortedPayloadKeys returns the keys of a decoded payload in ascending
+// order.
+func sortedPayloadKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// startLoad fetches and decodes the payload of the entry at txIdx in the
+// background, once a fetch slot is available. reserved is the number of
+// bytes already accounted for it against the iterator budget.
+func (itr *arweaveDBIterator) startLoad(txIdx int, reserved int64) *payloadLoad {
+	if itr.fetches == nil {
+		itr.fetches = newFetchGroup()
+	}
+	group, seq := itr.fetches, itr.fetches.next
+	group.next++
+	load := &payloadLoad{txIdx: txIdx, done: make(chan struct{}), held: reserved}
+	db, entry := itr.db, itr.entries[txIdx]
+	go func() {
+		defer close(load.done)
+		payload, put := db.lookupDecodedPayload(entry)
+		if payload != nil {
+			load.data, load.sortedKeys, load.size = payload.data, payload.sortedKeys, payloadSize(payload.data)
+			load.account(db.iteratorBudget)
+			return
+		}
+		if load.err = group.acquire(db.fetchSlots(), seq); load.err != nil {
+			return
+		}
+		txData, err := db.fetchEntryTxData(entry)
+		<-db.fetchSlots()
+		if err != nil {
+			load.err = err
+			group.stop(seq)
+			return
+		}
+		if db.decodePool != nil {
+			db.decodePool.sem <- struct{}{}
+		}
+		load.data, load.err = decodePayload(txData)
+		if load.err == nil {
+			load.sortedKeys = sortedPayloadKeys(load.data)
+			load.size = payloadSize(load.data)
+			if put != nil {
+				put(load.data, load.sortedKeys)
+			}
+		}
+		if db.decodePool != nil {
+			<-db.decodePool.sem
+		}
+		if load.err != nil {
+			group.stop(seq)
+			return
+		}
+		load.account(db.iteratorBudget)
+	}()
+	return load
+}
+
+// account accounts the size of a loaded payload against budget in place of
+// what the load holds, unless the load was given up.
+func (load *payloadLoad) account(budget *iteratorBudget) {
+	load.mtx.Lock()
+	defer load.mtx.Unlock()
+	if !load.released && budget != nil {
+		budget.add(load.size - load.held)
+		load.held = load.size
+	}
+}
+
+// release gives up the accounting of a load, done or not.
+func (load *payloadLoad) release(budget *iteratorBudget) {
+	load.mtx.Lock()
+	defer load.mtx.Unlock()
+	load.released = true
+	if load.held != 0 {
+		budget.add(-load.held)
+		load.held = 0
+	}
+}
+
+// readAhead starts loading the payloads following the one at txIdx, up to
+// the read ahead depth, unless a load failed or the iterator budget doesn't
+// allow it: the payload size of entries with metadata is reserved against
+// the budget, which must have room for it, while entries without are read
+// ahead as long as the budget isn't exceeded.
+func (itr *arweaveDBIterator) readAhead() {
+	for len(itr.loads) < itr.db.readAheadDepth() && (itr.fetches == nil || !itr.fetches.isStopped()) {
+		next := itr.txIdx + 1
+		if itr.reverse {
+			next = itr.txIdx - 1
+		}
+		if n := len(itr.loads); n > 0 {
+			next = itr.loads[n-1].txIdx + 1
+			if itr.reverse {
+				next = itr.loads[n-1].txIdx - 1
+			}
+		}
+		for next >= 0 && next < len(itr.entries) && itr.db.isKnownEmpty(itr.entries[next], itr.start, itr.end, itr.inclusiveEnd) {
+			if itr.reverse {
+				next--
+			} else {
+				next++
+			}
+		}
+		if next < 0 || next >= len(itr.entries) {
+			return
+		}
+		reserved := int64(0)
+		if budget := itr.db.iteratorBudget; budget != nil {
+			if size := int64(itr.entries[next].info.PayloadSize); size > 0 {
+				if !budget.reserve(size) {
+					return
+				}
+				reserved = size
+			} else if budget.admit() != nil {
+				return
+			}
+		}
+		itr.loads = append(itr.loads, itr.startLoad(next, reserved))
+	}
+}
+
+// loadPayload returns the decoded payload of the entry at txIdx and its
+// sorted keys, waiting for it if it is read ahead.
+func (itr *arweaveDBIterator) loadPayload() (map[string]interface{}, []string, error) {
+	if !itr.db.readsAhead() {
+		return itr.loadPayloadInline()
+	}
+	var load *payloadLoad
+	// loads of entries skipped as known empty are dropped
+	for len(itr.loads) > 0 && load == nil {
+		head := itr.loads[0]
+		itr.loads = itr.loads[1:]
+		if head.txIdx == itr.txIdx {
+			load = head
+		} else {
+			head.release(itr.db.iteratorBudget)
+		}
+	}
+	if load == nil {
+		// the first payload is positioned on before reading ahead, so that
+		// iterators, reverse ones included, return after fetching the payload
+		// at either end of the range only
+		first := itr.fetches == nil
+		load = itr.startLoad(itr.txIdx, 0)
+		if first {
+			<-load.done
+		}
+	}
+	itr.readAhead()
+	<-load.done
+	load.release(itr.db.iteratorBudget)
+	if load.err == errFetchCanceled {
+		// canceled by the failure of a load since skipped as known empty
+		return itr.loadPayloadInline()
+	}
+	return load.data, load.sortedKeys, load.err
+}
+
+func (itr *arweaveDBIterator) loadPayloadInline() (map[string]interface{}, []string, error) {
+	return itr.db.loadEntryPayload(itr.entries[itr.txIdx], true)
+}
+
+// releaseLoads gives up the payloads read ahead, canceling those not yet
+// fetched.
+func (itr *arweaveDBIterator) releaseLoads() {
+	if itr.fetches != nil {
+		itr.fetches.stop(-1)
+	}
+	for _, load := range itr.loads {
+		load.release(itr.db.iteratorBudget)
+	}
+	itr.loads = nil
+}
@@ -0,0 +1,227 @@
+package backends
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newDecodeTestDB returns a DB over payloads payloads of keysPerPayload
+// keys with values of valueLen bytes.
+func newDecodeTestDB(payloads, keysPerPayload, valueLen int, opts ...ArweaveOption) *ArweaveDB {
+	prefixes, indices, txData := []string{}, []int{}, [][]byte{}
+	value := string(make([]byte, valueLen))
+	for p := 0; p < payloads; p++ {
+		keys, values := []string{}, []string{}
+		for k := 0; k < keysPerPayload; k++ {
+			keys = append(keys, fmt.Sprintf("%04d/%06d", p, k))
+			values = append(values, value)
+		}
+		prefixes = append(prefixes, fmt.Sprintf("%04d/~", p))
+		indices = append(indices, p)
+		txData = append(txData, mockTxData(keys, values))
+	}
+	mockDB := NewMockArweaveDB([][]byte{mockIndex(prefixes, indices)}, txData, indices)
+	for _, opt := range opts {
+		opt(mockDB)
+	}
+	return mockDB
+}
+
+func scanDecoded(t *testing.T, db *ArweaveDB, reverse bool) []string {
+	iter, err := db.IteratorWithOptions(versionedKey(0, "0001/000010"), versionedKey(0, "0009/000005"), IteratorOptions{Reverse: reverse})
+	require.Nil(t, err)
+	defer iter.Close()
+	keys := []string{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+		require.Len(t, iter.Value(), 3)
+	}
+	require.Nil(t, iter.Error())
+	return keys
+}
+
+func TestDecodeWorkersPreserveOrder(t *testing.T) {
+	inline := newDecodeTestDB(12, 20, 3)
+	for _, reverse := range []bool{false, true} {
+		expected := scanDecoded(t, inline, reverse)
+		require.Len(t, expected, 10+7*20+5)
+		for _, workers := range []int{1, 3, 16} {
+			pooled := newDecodeTestDB(12, 20, 3, WithDecodeWorkers(workers), WithEmptyPayloadSkipping(4))
+			for i := 0; i < 2; i++ {
+				require.Equal(t, expected, scanDecoded(t, pooled, reverse), "workers %d reverse %t", workers, reverse)
+			}
+		}
+	}
+}
+
+func TestReverseIteratorFetchesLastPayloadFirst(t *testing.T) {
+	for _, opts := range [][]ArweaveOption{nil, {WithDecodeWorkers(2)}, {WithFetchConcurrency(4)}} {
+		for _, width := range []int{1, 2, 5, 9} {
+			db := newDecodeTestDB(10, 4, 1, append(opts, WithIndexCache(1))...)
+			// fetch the index beforehand
+			_, err := db.Get(versionedKey(0, "0000/000000"))
+			require.Nil(t, err)
+			var mtx sync.Mutex
+			fetched := []string{}
+			getter := db.txDataByIdGetter
+			db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+				data, err := getter(txId)
+				mtx.Lock()
+				fetched = append(fetched, string(txId))
+				mtx.Unlock()
+				return data, err
+			}
+
+			iter, err := db.ReverseIterator(versionedKey(0, "0000/000002"), versionedKey(0, fmt.Sprintf("%04d/000002", width)))
+			require.Nil(t, err)
+			mtx.Lock()
+			require.Equal(t, []string{intToBase64Sha256(width)}, fetched, "width %d", width)
+			mtx.Unlock()
+			require.Equal(t, fmt.Sprintf("%04d/000001", width), string(iter.Key()))
+			keys := 0
+			for ; iter.Valid(); iter.Next() {
+				keys++
+			}
+			require.Nil(t, iter.Error())
+			require.Nil(t, iter.Close())
+			require.Equal(t, 4*width, keys, "width %d", width)
+		}
+	}
+}
+
+// waitForLoads waits for the payloads read ahead by iter to be loaded.
+func waitForLoads(iter *arweaveDBIterator) {
+	for _, load := range iter.loads {
+		<-load.done
+	}
+}
+
+func TestDecodeWorkersBudget(t *testing.T) {
+	// 10 keys of 11 + 10 bytes per payload
+	db := newDecodeTestDB(8, 10, 10, WithDecodeWorkers(4), WithIteratorBudget(2000))
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	arweaveIter := iter.(*arweaveDBIterator)
+	waitForLoads(arweaveIter)
+	// the current payload and the 4 read ahead
+	require.Len(t, arweaveIter.loads, 4)
+	require.Equal(t, int64(5*210), db.iteratorBudget.usage())
+
+	// reaching a payload read ahead reads one more
+	for i := 0; i < 10; i++ {
+		iter.Next()
+	}
+	waitForLoads(arweaveIter)
+	require.Len(t, arweaveIter.loads, 4)
+	require.Equal(t, int64(5*210), db.iteratorBudget.usage())
+	require.Nil(t, iter.Close())
+	require.Equal(t, int64(0), db.iteratorBudget.usage())
+
+	// iterators don't read further ahead once the budget is exceeded
+	db = newDecodeTestDB(8, 10, 10, WithDecodeWorkers(4), WithIteratorBudget(400))
+	iter, err = db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	arweaveIter = iter.(*arweaveDBIterator)
+	waitForLoads(arweaveIter)
+	for i := 0; i < 10; i++ {
+		iter.Next()
+	}
+	require.Len(t, arweaveIter.loads, 3)
+	count := 10
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	require.Equal(t, 80, count)
+	require.Nil(t, iter.Close())
+	require.Equal(t, int64(0), db.iteratorBudget.usage())
+}
+
+func TestDecodeWorkersBudgetWithPayloadSizes(t *testing.T) {
+	// payloads of 10 keys of 11 + 10 bytes, i.e. 271 bytes of JSON, only
+	// odd entries having metadata
+	prefixes, indices, infos, txData := []string{}, []int{}, []IndexEntryInfo{}, [][]byte{}
+	for p := 0; p < 8; p++ {
+		keys, values := []string{}, []string{}
+		for k := 0; k < 10; k++ {
+			keys = append(keys, fmt.Sprintf("%04d/%06d", p, k))
+			values = append(values, "vvvvvvvvvv")
+		}
+		payload := mockTxData(keys, values)
+		info := IndexEntryInfo{}
+		if p%2 == 1 {
+			info = IndexEntryInfo{PayloadSize: uint64(len(payload)), KeyCount: 10, Codec: CodecJSON}
+		}
+		prefixes = append(prefixes, fmt.Sprintf("%04d/~", p))
+		indices = append(indices, p)
+		infos = append(infos, info)
+		txData = append(txData, payload)
+	}
+	require.Len(t, txData[0], 271)
+	db := NewMockArweaveDB([][]byte{mockIndexV1(prefixes, indices, infos)}, txData, indices)
+	WithDecodeWorkers(4)(db)
+	WithIteratorBudget(650)(db)
+
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	arweaveIter := iter.(*arweaveDBIterator)
+	// the size of payload 1 is reserved next to the 210 bytes of payload 0,
+	// payload 2 has no metadata and is read ahead while the budget isn't
+	// exceeded, payload 3 doesn't fit
+	require.Len(t, arweaveIter.loads, 2)
+	waitForLoads(arweaveIter)
+	// reservations are replaced by the size of decoded payloads
+	require.Equal(t, int64(3*210), db.iteratorBudget.usage())
+
+	count := 0
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	require.Nil(t, iter.Error())
+	require.Equal(t, 80, count)
+	require.Nil(t, iter.Close())
+	require.Equal(t, int64(0), db.iteratorBudget.usage())
+}
+
+func TestDecodeWorkersErrors(t *testing.T) {
+	db := newDecodeTestDB(4, 2, 1, WithDecodeWorkers(4))
+	getter := db.txDataByIdGetter
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		if string(txId) == intToBase64Sha256(2) {
+			return nil, &ErrKeyNotFound{}
+		}
+		return getter(txId)
+	}
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	defer iter.Close()
+	// the failed payload is only reported once reached
+	for i := 0; i < 3; i++ {
+		iter.Next()
+	}
+	require.Equal(t, "0001/000001", string(iter.Key()))
+	iter.Next()
+	require.False(t, iter.Valid())
+	require.ErrorAs(t, iter.Error(), new(*ErrKeyNotFound))
+}
+
+func BenchmarkDecodeWorkers(b *testing.B) {
+	// 32 payloads of 2000 keys, about 100 KB each
+	for _, workers := range []int{0, 1, 2, 4, runtime.NumCPU()} {
+		db := newDecodeTestDB(32, 2000, 32, WithDecodeWorkers(workers))
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				iter, err := db.Iterator(versionedKey(0, ""), nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for ; iter.Valid(); iter.Next() {
+				}
+				iter.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package backends
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// decodedEntryOverhead approximates the memory a decoded payload takes per
+// key on top of the bytes of the key and its value: its map entry, the
+// interface holding the value, and its header in the sorted keys.
+const decodedEntryOverhead = 64
+
+// CacheStats counts the lookups of a cache.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// HitRate returns the fraction of lookups served by the cache, 0 without
+// lookups.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s CacheStats) stats(stats map[string]string, prefix string) {
+	stats[prefix+"_hits"] = strconv.FormatInt(s.Hits, 10)
+	stats[prefix+"_misses"] = strconv.FormatInt(s.Misses, 10)
+	stats[prefix+"_hit_rate"] = strconv.FormatFloat(s.HitRate(), 'f', 3, 64)
+}
+
+// decodedPayload is a payload as decoded by decodePayload, along with its
+// keys in ascending order.
+type decodedPayload struct {
+	txId       string
+	data       map[string]interface{}
+	sortedKeys []string
+	size       int64
+}
+
+// decodedCache keeps the most recently used decoded payloads within a
+// budget of decoded bytes, so that hot payloads aren't decoded again on
+// every read. Payloads are only admitted once read twice within the
+// admission window.
+type decodedCache struct {
+	maxBytes int64
+	window   time.Duration
+	now      func() time.Time
+
+	mtx      sync.Mutex
+	payloads map[string]*list.Element
+	// payloads, most recently used first
+	lru  *list.List
+	size int64
+	// when payloads not cached were last read, swept of those read before
+	// the window once sweepAt are tracked
+	seen    map[string]time.Time
+	sweepAt int
+	stats   CacheStats
+	// bumped by clear, so that payloads decoded before aren't cached
+	generation uint64
+}
+
+// WithDecodedPayloadCache makes the DB keep decoded payloads up to
+// maxBytes of decoded size, evicting the least recently used ones, so that
+// Get, Has and iterators reading hot payloads skip decoding them, unlike
+// with a cache of transaction data such as WithPersistentCache. Payloads
+// are only cached once read twice within admissionWindow, so that one-shot
+// scans don't evict hot payloads. Reads with ReadOptions.Revalidate bypass
+// the cache.
+func WithDecodedPayloadCache(maxBytes int64, admissionWindow time.Duration) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if maxBytes <= 0 {
+			db.invalidOption(fmt.Errorf("WithDecodedPayloadCache needs a positive size, got %d", maxBytes))
+		}
+		if admissionWindow < 0 {
+			db.invalidOption(fmt.Errorf("WithDecodedPayloadCache needs a non-negative admission window, got %s", admissionWindow))
+		}
+		db.decodedCache = &decodedCache{
+			maxBytes: maxBytes,
+			window:   admissionWindow,
+			now:      time.Now,
+			payloads: map[string]*list.Element{},
+			lru:      list.New(),
+			seen:     map[string]time.Time{},
+		}
+	}
+}
+
+// decodedPayloadSize returns the approximate memory taken by a decoded
+// payload.
+func decodedPayloadSize(data map[string]interface{}) int64 {
+	return payloadSize(data) + int64(len(data))*decodedEntryOverhead
+}
+
+// get returns the cached payload of txId, if any. Misses return whether the
+// payload is to be cached once decoded, along with the generation to pass
+// to put.
+func (c *decodedCache) get(txId []byte) (*decodedPayload, bool, bool, uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, ok := c.payloads[string(txId)]; ok {
+		c.stats.Hits++
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*decodedPayload), true, false, 0
+	}
+	c.stats.Misses++
+	now := c.now()
+	last, seen := c.seen[string(txId)]
+	admit := seen && now.Sub(last) <= c.window
+	if admit {
+		delete(c.seen, string(txId))
+	} else {
+		c.seen[string(txId)] = now
+		c.sweep(now)
+	}
+	return nil, false, admit, c.generation
+}
+
+// peek returns the cached payload of txId, if any, without it counting as a
+// read towards its admission.
+func (c *decodedCache) peek(txId []byte) (*decodedPayload, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem, ok := c.payloads[string(txId)]
+	if !ok {
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*decodedPayload), true
+}
+
+// sweep forgets the payloads read before the admission window once
+// sweepAt are tracked. It must be called with mtx held.
+func (c *decodedCache) sweep(now time.Time) {
+	if len(c.seen) < c.sweepAt {
+		return
+	}
+	for txId, last := range c.seen {
+		if now.Sub(last) > c.window {
+			delete(c.seen, txId)
+		}
+	}
+	c.sweepAt = 2*len(c.seen) + 64
+}
+
+// put caches payload unless the cache was cleared since generation, then
+// evicts the least recently used payloads above the budget. Payloads
+// larger than the budget aren't cached.
+func (c *decodedCache) put(payload *decodedPayload, generation uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if generation != c.generation || payload.size > c.maxBytes {
+		return
+	}
+	if _, ok := c.payloads[payload.txId]; ok {
+		return
+	}
+	c.payloads[payload.txId] = c.lru.PushFront(payload)
+	c.size += payload.size
+	for c.size > c.maxBytes {
+		evicted := c.lru.Remove(c.lru.Back()).(*decodedPayload)
+		delete(c.payloads, evicted.txId)
+		c.size -= evicted.size
+	}
+}
+
+func (c *decodedCache) clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.payloads = map[string]*list.Element{}
+	c.lru.Init()
+	c.size = 0
+	c.seen = map[string]time.Time{}
+	c.sweepAt = 0
+	c.generation++
+}
+
+func (c *decodedCache) statsSnapshot() (CacheStats, int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.stats, c.size
+}
+
+// lookupDecodedPayload returns the cached decoded payload of entry, if the
+// DB has a decoded payload cache. Misses return a function caching the
+// payload once decoded, nil unless it is admitted.
+func (db *ArweaveDB) lookupDecodedPayload(entry IndexEntry) (*decodedPayload, func(map[string]interface{}, []string)) {
+	cache := db.decodedCache
+	if cache == nil || db.readOptions.Revalidate {
+		return nil, nil
+	}
+	payload, ok, admit, generation := cache.get(entry.txId)
+	db.recordCacheLookup(CacheDecoded, ok)
+	if ok || !admit {
+		return payload, nil
+	}
+	return nil, func(data map[string]interface{}, sortedKeys []string) {
+		cache.put(&decodedPayload{
+			txId:       string(entry.txId),
+			data:       data,
+			sortedKeys: sortedKeys,
+			size:       decodedPayloadSize(data),
+		}, generation)
+	}
+}
+
+// loadEntryPayload returns the decoded payload of entry, from the decoded
+// payload cache if the DB has one, caching it if admitted. The keys of the
+// payload are sorted if sorted is set or the payload is cached.
+func (db *ArweaveDB) loadEntryPayload(entry IndexEntry, sorted bool) (map[string]interface{}, []string, error) {
+	payload, put := db.lookupDecodedPayload(entry)
+	if payload != nil {
+		return payload.data, payload.sortedKeys, nil
+	}
+	txData, err := db.fetchEntryTxData(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := decodePayload(txData)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sortedKeys []string
+	if sorted || put != nil {
+		sortedKeys = sortedPayloadKeys(data)
+	}
+	if put != nil {
+		put(data, sortedKeys)
+	}
+	return data, sortedKeys, nil
+}
+
+// cachedEntryPayload returns the decoded payload of entry if it is in the
+// decoded payload cache.
+func (db *ArweaveDB) cachedEntryPayload(entry IndexEntry) (map[string]interface{}, bool) {
+	if db.decodedCache == nil || db.readOptions.Revalidate {
+		return nil, false
+	}
+	payload, ok := db.decodedCache.peek(entry.txId)
+	if !ok {
+		return nil, false
+	}
+	db.recordCacheLookup(CacheDecoded, true)
+	return payload.data, true
+}
+
+// ClearDecodedPayloadCache drops the cached decoded payloads.
+func (db *ArweaveDB) ClearDecodedPayloadCache() {
+	if db.decodedCache != nil {
+		db.decodedCache.clear()
+	}
+}
+
+// payloadCacheStats adds the statistics of the caches of transaction data
+// and decoded payloads to stats.
+func (db *ArweaveDB) payloadCacheStats(stats map[string]string) {
+	if db.txCache != nil {
+		db.txCache.Stats().stats(stats, "raw_cache")
+	}
+	if db.decodedCache != nil {
+		cacheStats, size := db.decodedCache.statsSnapshot()
+		cacheStats.stats(stats, "decoded_cache")
+		stats["decoded_cache_bytes"] = strconv.FormatInt(size, 10)
+	}
+}
@@ -0,0 +1,160 @@
+package backends
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// newDecodedCacheTestDB returns a DB of payloads of 10 keys of 100 bytes
+// values, each taking 1750 decoded bytes, with a decoded payload cache of
+// maxBytes, counting its fetches of transaction data.
+func newDecodedCacheTestDB(payloads int, maxBytes int64, calls *int) (*ArweaveDB, *fakeClock) {
+	db := newDecodeTestDB(payloads, 10, 100, WithIndexCache(1), WithDecodedPayloadCache(maxBytes, time.Minute))
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	db.decodedCache.now = clock.Now
+	countingTxDataGetter(db, calls)
+	return db, clock
+}
+
+func getPayloadKey(t *testing.T, db *ArweaveDB, payload int) {
+	value, err := db.Get(versionedKey(0, fmt.Sprintf("%04d/%06d", payload, 3)))
+	require.Nil(t, err)
+	require.Len(t, value, 100)
+}
+
+func TestDecodedPayloadCacheAdmission(t *testing.T) {
+	calls := 0
+	db, clock := newDecodedCacheTestDB(2, 1<<20, &calls)
+	getPayloadKey(t, db, 0)
+	require.Equal(t, 2, calls)
+	// read once more within the window, the payload is admitted
+	clock.Advance(30 * time.Second)
+	getPayloadKey(t, db, 0)
+	require.Equal(t, 3, calls)
+	for i := 0; i < 3; i++ {
+		getPayloadKey(t, db, 0)
+		has, err := db.Has(versionedKey(0, "0000/000009"))
+		require.Nil(t, err)
+		require.True(t, has)
+	}
+	require.Equal(t, 3, calls)
+
+	// reads further apart than the window aren't
+	getPayloadKey(t, db, 1)
+	clock.Advance(2 * time.Minute)
+	getPayloadKey(t, db, 1)
+	getPayloadKey(t, db, 1)
+	require.Equal(t, 6, calls)
+	getPayloadKey(t, db, 1)
+	require.Equal(t, 6, calls)
+
+	stats := db.Stats()
+	require.Equal(t, "7", stats["decoded_cache_hits"])
+	require.Equal(t, "5", stats["decoded_cache_misses"])
+	require.Equal(t, "0.583", stats["decoded_cache_hit_rate"])
+	require.Equal(t, "3500", stats["decoded_cache_bytes"])
+	require.NotContains(t, stats, "raw_cache_hits")
+
+	// revalidating reads bypass the cache
+	getPayloadKey(t, db.WithReadOptions(ReadOptions{Revalidate: true}), 1)
+	require.Equal(t, 7, calls)
+	db.ClearDecodedPayloadCache()
+	getPayloadKey(t, db, 1)
+	require.Equal(t, 8, calls)
+}
+
+func TestDecodedPayloadCacheBudget(t *testing.T) {
+	calls := 0
+	db, _ := newDecodedCacheTestDB(4, 4000, &calls)
+	for _, payload := range []int{0, 0, 1, 1, 2, 2} {
+		getPayloadKey(t, db, payload)
+	}
+	require.Equal(t, int64(3500), db.decodedCache.size)
+	require.Len(t, db.decodedCache.payloads, 2)
+	calls = 0
+	getPayloadKey(t, db, 1)
+	getPayloadKey(t, db, 2)
+	require.Equal(t, 0, calls)
+	getPayloadKey(t, db, 0)
+	require.Equal(t, 1, calls)
+
+	// payloads larger than the budget are never cached
+	db, _ = newDecodedCacheTestDB(1, 1000, &calls)
+	for i := 0; i < 3; i++ {
+		getPayloadKey(t, db, 0)
+	}
+	require.Empty(t, db.decodedCache.payloads)
+	require.Equal(t, int64(0), db.decodedCache.size)
+}
+
+func TestDecodedPayloadCacheIterators(t *testing.T) {
+	for _, opts := range [][]ArweaveOption{nil, {WithDecodeWorkers(2)}, {WithStreamingGets(1)}} {
+		calls := 0
+		db, _ := newDecodedCacheTestDB(4, 1<<20, &calls)
+		for _, opt := range opts {
+			opt(db)
+		}
+		scan := func() []string {
+			iter, err := db.Iterator(versionedKey(0, "0001/"), versionedKey(0, "0003/"))
+			require.Nil(t, err)
+			defer iter.Close()
+			keys := []string{}
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+				require.Len(t, iter.Value(), 100)
+			}
+			require.Nil(t, iter.Error())
+			return keys
+		}
+		expected := scan()
+		require.Len(t, expected, 20)
+		require.Equal(t, expected, scan())
+		fetched := calls
+		require.Equal(t, expected, scan())
+		getPayloadKey(t, db, 1)
+		require.Equal(t, fetched, calls)
+	}
+}
+
+func TestPayloadCacheStats(t *testing.T) {
+	base := newDecodeTestDB(2, 10, 100)
+	cache, err := NewPersistentTxCache(dbm.NewMemDB(), 0)
+	require.Nil(t, err)
+	db := NewArweaveDBWithGetters(base.txDataByIdGetter, base.versionTxIdGetter,
+		WithPersistentCache(cache), WithIndexCache(1), WithDecodedPayloadCache(1<<20, time.Minute))
+	for i := 0; i < 4; i++ {
+		getPayloadKey(t, db, 0)
+	}
+	stats := db.Stats()
+	// the index and the payload missed, then the payload hit once before
+	// being admitted
+	require.Equal(t, CacheStats{Hits: 1, Misses: 2}, cache.Stats())
+	require.Equal(t, "1", stats["raw_cache_hits"])
+	require.Equal(t, "2", stats["raw_cache_misses"])
+	require.Equal(t, "2", stats["decoded_cache_hits"])
+	require.Equal(t, "2", stats["decoded_cache_misses"])
+	require.Equal(t, 0.5, CacheStats{Hits: 2, Misses: 2}.HitRate())
+	require.Equal(t, 0.0, CacheStats{}.HitRate())
+}
+
+func BenchmarkHotKeyGet(b *testing.B) {
+	for name, opts := range map[string][]ArweaveOption{
+		"uncached": nil,
+		"cached":   {WithDecodedPayloadCache(64<<20, time.Minute)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			db, key := newLargePayloadDB(b, 50000, append(opts, WithIndexCache(1))...)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Get(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package backends
+
+import (
+	"sort"
+	"strings"
+)
+
+// VersionDiff is the difference between the indices of two versions, by
+// index key prefix, in ascending order of prefixes.
+type VersionDiff struct {
+	FromVersion uint64 `json:"from_version"`
+	ToVersion   uint64 `json:"to_version"`
+	// Added are the prefixes only indexed by the to version
+	Added []PrefixDiff `json:"added"`
+	// Removed are the prefixes only indexed by the from version
+	Removed []PrefixDiff `json:"removed"`
+	// Republished are the prefixes whose entries point to other payload
+	// transactions in the to version
+	Republished []PrefixDiff `json:"republished"`
+	// Unchanged counts the prefixes inherited by the to version, whose
+	// entries point to the same payload transactions in both versions
+	Unchanged int `json:"unchanged"`
+}
+
+// PrefixDiff describes the entries of an index key prefix in both versions
+// of a VersionDiff.
+type PrefixDiff struct {
+	// Prefix is the key prefix, without padding
+	Prefix []byte `json:"prefix"`
+	// FromEntries and ToEntries count the entries of the prefix in each
+	// version, more than one if its keys are split across several
+	// payloads
+	FromEntries int `json:"from_entries"`
+	ToEntries   int `json:"to_entries"`
+	// FromTxIds and ToTxIds are the payload transactions of the entries,
+	// in index order
+	FromTxIds []string `json:"from_tx_ids,omitempty"`
+	ToTxIds   []string `json:"to_tx_ids,omitempty"`
+}
+
+// DiffVersions returns which index key prefixes changed from fromVersion to
+// toVersion, from their indices only, without fetching payloads. Indices
+// are read through the index cache if the DB has one. Versions share the
+// payloads they inherit, so prefixes whose entries point to the same
+// transactions in both versions are unchanged, even though their payloads
+// may be fetched from either version.
+func (db *ArweaveDB) DiffVersions(fromVersion, toVersion uint64) (VersionDiff, error) {
+	from, err := db.getIndex(fromVersion)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	to, err := db.getIndex(toVersion)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	fromTxIds, toTxIds := txIdsByPrefix(from), txIdsByPrefix(to)
+	prefixes := make([]string, 0, len(fromTxIds)+len(toTxIds))
+	for prefix := range fromTxIds {
+		prefixes = append(prefixes, prefix)
+	}
+	for prefix := range toTxIds {
+		if _, ok := fromTxIds[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	diff := VersionDiff{
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Added:       []PrefixDiff{},
+		Removed:     []PrefixDiff{},
+		Republished: []PrefixDiff{},
+	}
+	for _, prefix := range prefixes {
+		prefixDiff := PrefixDiff{
+			Prefix:      []byte(strings.TrimRight(prefix, "\x00")),
+			FromEntries: len(fromTxIds[prefix]),
+			ToEntries:   len(toTxIds[prefix]),
+			FromTxIds:   fromTxIds[prefix],
+			ToTxIds:     toTxIds[prefix],
+		}
+		switch {
+		case prefixDiff.FromEntries == 0:
+			diff.Added = append(diff.Added, prefixDiff)
+		case prefixDiff.ToEntries == 0:
+			diff.Removed = append(diff.Removed, prefixDiff)
+		case !equalTxIds(prefixDiff.FromTxIds, prefixDiff.ToTxIds):
+			diff.Republished = append(diff.Republished, prefixDiff)
+		default:
+			diff.Unchanged++
+		}
+	}
+	return diff, nil
+}
+
+// txIdsByPrefix returns the payload transactions of the entries of each key
+// prefix, in index order.
+func txIdsByPrefix(entries []IndexEntry) map[string][]string {
+	res := map[string][]string{}
+	for _, entry := range entries {
+		res[entry.keyPrefix] = append(res[entry.keyPrefix], string(entry.txId))
+	}
+	return res
+}
+
+func equalTxIds(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package backends_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+func TestDiffVersions(t *testing.T) {
+	// keys sharing an index key prefix, split across two payloads
+	p, q := strings.Repeat("p", backends.IndexKeyPrefixLen), strings.Repeat("q", backends.IndexKeyPrefixLen)
+	archive, err := arweavetest.NewArchiveBuilder().
+		Version(1).KV("a1", "a").KV("a2", "a").KV("b1", "b").KV("b2", "b").KV("c1", "c").KV("c2", "c").
+		Prefix(p).KV("1", "p").KV("2", "p").KV("3", "p").KV("4", "p").
+		Prefix(q).KV("1", "q").KV("2", "q").KV("3", "q").KV("4", "q").
+		Version(2).KV("a1", "a").KV("a2", "a").KV("b1", "b").KV("b2", "changed").KV("d1", "d").KV("d2", "d").
+		Prefix(p).KV("1", "p").KV("2", "p").KV("3", "p").KV("4", "changed").
+		Prefix(q).KV("1", "q").KV("2", "q").KV("3", "q").KV("4", "q").
+		ChunkedIndex(2).
+		Build()
+	require.Nil(t, err)
+	db := archive.NewDB()
+
+	// tx IDs of the entries of each version by prefix
+	txIds := map[uint64]map[string][]string{}
+	for _, version := range archive.Versions() {
+		desc, err := db.DescribeIndex(version)
+		require.Nil(t, err)
+		txIds[version] = map[string][]string{}
+		for _, entry := range desc.Entries {
+			prefix := strings.TrimRight(string(entry.KeyPrefix), "\x00")
+			txIds[version][prefix] = append(txIds[version][prefix], entry.TxId)
+		}
+	}
+	require.Len(t, txIds[1][p], 2)
+	require.Equal(t, txIds[1]["a2"], txIds[2]["a2"])
+	require.Equal(t, txIds[1][q], txIds[2][q])
+
+	diff, err := db.DiffVersions(1, 2)
+	require.Nil(t, err)
+	require.Equal(t, backends.VersionDiff{
+		FromVersion: 1,
+		ToVersion:   2,
+		Added: []backends.PrefixDiff{
+			{Prefix: []byte("d2"), ToEntries: 1, ToTxIds: txIds[2]["d2"]},
+		},
+		Removed: []backends.PrefixDiff{
+			{Prefix: []byte("c2"), FromEntries: 1, FromTxIds: txIds[1]["c2"]},
+		},
+		Republished: []backends.PrefixDiff{
+			{Prefix: []byte("b2"), FromEntries: 1, ToEntries: 1, FromTxIds: txIds[1]["b2"], ToTxIds: txIds[2]["b2"]},
+			{Prefix: []byte(p), FromEntries: 2, ToEntries: 2, FromTxIds: txIds[1][p], ToTxIds: txIds[2][p]},
+		},
+		// a2 and q, inherited
+		Unchanged: 2,
+	}, diff)
+
+	bz, err := json.Marshal(diff)
+	require.Nil(t, err)
+	decoded := backends.VersionDiff{}
+	require.Nil(t, json.Unmarshal(bz, &decoded))
+	require.Equal(t, diff, decoded)
+
+	// diffs are symmetric
+	reverse, err := db.DiffVersions(2, 1)
+	require.Nil(t, err)
+	require.Equal(t, diff.Added[0].Prefix, reverse.Removed[0].Prefix)
+	require.Equal(t, diff.Removed[0].Prefix, reverse.Added[0].Prefix)
+	require.Len(t, reverse.Republished, 2)
+
+	same, err := db.DiffVersions(1, 1)
+	require.Nil(t, err)
+	require.Empty(t, same.Added)
+	require.Empty(t, same.Removed)
+	require.Empty(t, same.Republished)
+	require.Equal(t, 5, same.Unchanged)
+
+	_, err = db.DiffVersions(1, 3)
+	require.ErrorIs(t, err, backends.ErrNotFound)
+}
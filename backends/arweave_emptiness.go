@@ -0,0 +1,100 @@
+package backends
+
+import (
+	"fmt"
+	"sync"
+)
+
+// payloadBounds are the smallest and largest keys of a payload.
+type payloadBounds struct {
+	empty    bool
+	min, max string
+}
+
+// hasKeyIn returns whether the payload may have a key from start to end,
+// included if inclusiveEnd, or from start on if end is nil.
+func (b payloadBounds) hasKeyIn(start, end []byte, inclusiveEnd bool) bool {
+	if b.empty || b.max < string(start) {
+		return false
+	}
+	return end == nil || b.min < string(end) || (inclusiveEnd && b.min == string(end))
+}
+
+// payloadBoundsCache remembers the key bounds of payloads fetched by
+// iterators, so that later scans can skip fetching payloads which have no
+// key in their range. Payloads are immutable, but since middlewares may
+// change what the getters return, the cache is reset whenever they change.
+type payloadBoundsCache struct {
+	mtx      sync.Mutex
+	capacity int
+	bounds   map[string]payloadBounds
+	// insertion order, for FIFO eviction
+	txIds []string
+}
+
+// WithEmptyPayloadSkipping makes iterators remember the key bounds of up to
+// capacity payloads and skip fetching those known to have no key in range.
+func WithEmptyPayloadSkipping(capacity int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if capacity <= 0 {
+			db.invalidOption(fmt.Errorf("WithEmptyPayloadSkipping needs a positive capacity, got %d", capacity))
+		}
+		db.payloadBounds = &payloadBoundsCache{
+			capacity: capacity,
+			bounds:   map[string]payloadBounds{},
+		}
+	}
+}
+
+func (c *payloadBoundsCache) get(txId []byte) (payloadBounds, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	bounds, ok := c.bounds[string(txId)]
+	return bounds, ok
+}
+
+func (c *payloadBoundsCache) put(txId []byte, bounds payloadBounds) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.bounds[string(txId)]; ok || c.capacity <= 0 {
+		return
+	}
+	if len(c.txIds) == c.capacity {
+		delete(c.bounds, c.txIds[0])
+		c.txIds = c.txIds[1:]
+	}
+	c.bounds[string(txId)] = bounds
+	c.txIds = append(c.txIds, string(txId))
+}
+
+func (c *payloadBoundsCache) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.bounds = map[string]payloadBounds{}
+	c.txIds = nil
+}
+
+// isKnownEmpty returns whether the payload of entry is known to have no key
+// in [start, end), either from the entry's metadata or from an earlier
+// fetch.
+func (db *ArweaveDB) isKnownEmpty(entry IndexEntry, start, end []byte, inclusiveEnd bool) bool {
+	if entry.info.PayloadSize > 0 && entry.info.KeyCount == 0 {
+		return true
+	}
+	if db.payloadBounds == nil {
+		return false
+	}
+	bounds, ok := db.payloadBounds.get(entry.txId)
+	return ok && !bounds.hasKeyIn(start, end, inclusiveEnd)
+}
+
+func (db *ArweaveDB) recordPayloadBounds(txId []byte, sortedKeys []string) {
+	if db.payloadBounds == nil {
+		return
+	}
+	if len(sortedKeys) == 0 {
+		db.payloadBounds.put(txId, payloadBounds{empty: true})
+		return
+	}
+	db.payloadBounds.put(txId, payloadBounds{min: sortedKeys[0], max: sortedKeys[len(sortedKeys)-1]})
+}
@@ -0,0 +1,155 @@
+package backends
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func scanKeys(t require.TestingT, db *ArweaveDB, start, end string) []string {
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	iter, err := db.Iterator(append(v0Bz, []byte(start)...), append(v0Bz, []byte(end)...))
+	require.Nil(t, err)
+	defer iter.Close()
+	keys := []string{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	return keys
+}
+
+func countFetches(db *ArweaveDB) map[string]int {
+	fetched := map[string]int{}
+	ApplyMiddleware(db, func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			fetched[string(key)]++
+			return next(key)
+		}
+	})
+	return fetched
+}
+
+func newSparseMockDB() *ArweaveDB {
+	index := mockIndex([]string{"z", "z", "z"}, []int{0, 1, 2})
+	txData := [][]byte{
+		mockTxData([]string{"a1", "a2"}, []string{"v", "v"}),
+		mockTxData([]string{"m1"}, []string{"v"}),
+		mockTxData([]string{"y1"}, []string{"v"}),
+	}
+	return NewMockArweaveDB([][]byte{index}, txData, []int{0, 1, 2})
+}
+
+func TestEmptyPayloadSkipping(t *testing.T) {
+	mockDB := newSparseMockDB()
+	WithEmptyPayloadSkipping(10)(mockDB)
+	fetched := countFetches(mockDB)
+
+	require.Equal(t, []string{"m1"}, scanKeys(t, mockDB, "m0", "m9"))
+	require.Equal(t, 1, fetched[intToBase64Sha256(0)])
+	require.Equal(t, 1, fetched[intToBase64Sha256(1)])
+
+	require.Equal(t, []string{"m1"}, scanKeys(t, mockDB, "m0", "m9"))
+	require.Equal(t, 1, fetched[intToBase64Sha256(0)])
+	require.Equal(t, 2, fetched[intToBase64Sha256(1)])
+	require.Equal(t, 1, fetched[intToBase64Sha256(2)])
+
+	// a wider range still sees every key
+	require.Equal(t, []string{"a1", "a2", "m1", "y1"}, scanKeys(t, mockDB, "a", "z"))
+}
+
+func TestEmptyPayloadSkippingReverse(t *testing.T) {
+	mockDB := newSparseMockDB()
+	WithEmptyPayloadSkipping(10)(mockDB)
+	fetched := countFetches(mockDB)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	for i := 0; i < 2; i++ {
+		iter, err := mockDB.ReverseIterator(append(v0Bz, []byte("m0")...), append(v0Bz, []byte("m9")...))
+		require.Nil(t, err)
+		require.Equal(t, "m1", string(iter.Key()))
+		iter.Next()
+		require.False(t, iter.Valid())
+	}
+	require.Equal(t, 1, fetched[intToBase64Sha256(2)])
+}
+
+func TestEmptyPayloadSkippingResetOnMiddlewareChange(t *testing.T) {
+	mockDB := newSparseMockDB()
+	WithEmptyPayloadSkipping(10)(mockDB)
+	require.Equal(t, []string{"m1"}, scanKeys(t, mockDB, "m0", "m9"))
+
+	// the last payload now has a key in range
+	ApplyMiddleware(mockDB, func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			if string(key) == intToBase64Sha256(2) {
+				return mockTxData([]string{"m5"}, []string{"v"}), nil
+			}
+			return next(key)
+		}
+	})
+	require.Equal(t, []string{"m1", "m5"}, scanKeys(t, mockDB, "m0", "m9"))
+}
+
+func TestEmptyPayloadSkippingEviction(t *testing.T) {
+	mockDB := newSparseMockDB()
+	WithEmptyPayloadSkipping(1)(mockDB)
+	fetched := countFetches(mockDB)
+	scanKeys(t, mockDB, "m0", "m9")
+	scanKeys(t, mockDB, "m0", "m9")
+	// every fetch evicts the bounds of the previous payload
+	require.Equal(t, 2, fetched[intToBase64Sha256(0)])
+	require.Equal(t, 2, fetched[intToBase64Sha256(2)])
+	require.Equal(t, 1, len(mockDB.payloadBounds.txIds))
+}
+
+func TestEmptyPayloadSkippingFromMetadata(t *testing.T) {
+	index := mockIndexV1([]string{"z", "z"}, []int{0, 1}, []IndexEntryInfo{
+		{PayloadSize: 2, KeyCount: 0, Codec: CodecJSON},
+		{},
+	})
+	txData := [][]byte{
+		mockTxData([]string{}, []string{}),
+		mockTxData([]string{"m1"}, []string{"v"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	fetched := countFetches(mockDB)
+	require.Equal(t, []string{"m1"}, scanKeys(t, mockDB, "m0", "m9"))
+	require.Equal(t, 0, fetched[intToBase64Sha256(0)])
+}
+
+// 90% of the entries covering the scanned range have no key in it.
+func benchmarkSparseScan(b *testing.B, skipping bool) {
+	prefixes, indices, txData := []string{}, []int{}, [][]byte{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("m%03d", i)
+		prefixes = append(prefixes, key)
+		indices = append(indices, i)
+		if i%10 == 0 {
+			txData = append(txData, mockTxData([]string{key}, []string{"v"}))
+		} else {
+			txData = append(txData, mockTxData([]string{}, []string{}))
+		}
+	}
+	mockDB := NewMockArweaveDB([][]byte{mockIndex(prefixes, indices)}, txData, indices)
+	if skipping {
+		WithEmptyPayloadSkipping(1000)(mockDB)
+	}
+	fetches := 0
+	ApplyMiddleware(mockDB, countingMiddleware(&fetches))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		require.Equal(b, 10, len(scanKeys(b, mockDB, "m", "n")))
+	}
+	b.ReportMetric(float64(fetches)/float64(b.N), "fetches/op")
+}
+
+func BenchmarkSparseScan(b *testing.B) {
+	benchmarkSparseScan(b, false)
+}
+
+func BenchmarkSparseScanWithEmptyPayloadSkipping(b *testing.B) {
+	benchmarkSparseScan(b, true)
+}
@@ -0,0 +1,296 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// exportProgressKey is where ExportPrefix checkpoints its progress in the DB
+// it exports to.
+var exportProgressKey = mustReserveSubspace("arweave_export").Key([]byte("progress"))
+
+const defaultExportBatchSize = 1000
+
+// ExportPrefixOptions tune ExportPrefix.
+type ExportPrefixOptions struct {
+	// Concurrency bounds the payloads fetched at once, defaults to 1.
+	Concurrency int
+	// BatchSize is the number of keys written per batch, defaults to 1000.
+	BatchSize int
+	// Gaps decides how payloads failing to load are handled, failing the
+	// export by default.
+	Gaps GapPolicy
+	// GapRetries is how GapRetryThenSkip retries payloads, defaulting to
+	// DefaultFetchRetryPolicy.
+	GapRetries FetchRetryPolicy
+	// Progress, if set, is called once each payload is exported or skipped.
+	Progress func(ExportProgress)
+}
+
+// ExportProgress describes how far an export is.
+type ExportProgress struct {
+	// PayloadsDone counts the payloads exported or skipped so far, those of
+	// earlier interrupted exports included, out of PayloadsTotal.
+	PayloadsDone  int
+	PayloadsTotal int
+	// KeysWritten and BytesDownloaded are those of this call.
+	KeysWritten     int
+	BytesDownloaded uint64
+}
+
+// ExportReport describes what ExportPrefix did.
+type ExportReport struct {
+	Version uint64
+	Prefix  []byte
+	// Payloads is the number of payloads which may hold keys of the prefix.
+	Payloads int
+	// ResumedPayloads is the number of them exported by earlier interrupted
+	// exports, and not fetched again.
+	ResumedPayloads int
+	KeysWritten     int
+	// BytesDownloaded counts the index, payloads and referenced values
+	// fetched.
+	BytesDownloaded uint64
+	// Gaps are the payloads skipped according to ExportPrefixOptions.Gaps,
+	// in index order. Those of earlier interrupted exports aren't reported
+	// again.
+	Gaps    []GapInfo
+	Elapsed time.Duration
+}
+
+type exportCheckpoint struct {
+	Version uint64 `json:"version"`
+	Prefix  []byte `json:"prefix"`
+	// index of the next payload to export among those of the prefix
+	NextPayload int `json:"next_payload"`
+}
+
+// ExportPrefix writes the keys of version starting with prefix to dst,
+// without their version, e.g. to materialize the state of a module at a
+// height locally. Payloads are fetched through the rate limiter and the
+// download budget of db, up to opts.Concurrency at once, and their keys
+// written in index order in batches of opts.BatchSize. A checkpoint is
+// written to the reserved namespace of dst along with the last keys of
+// each payload, so that an export interrupted by a failure or ctx resumes
+// with the payload it stopped at when called again with the same version
+// and prefix, and is removed once the export completes. Exporting another
+// version or prefix to a DB holding a checkpoint fails until the
+// interrupted export is resumed to completion. Archived keys falling in the
+// reserved namespace fail the export.
+func (db *ArweaveDB) ExportPrefix(ctx context.Context, version uint64, prefix []byte, dst dbm.DB, opts ExportPrefixOptions) (report ExportReport, err error) {
+	start := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	view := db.WithContext(ctx)
+	var (
+		mtx        sync.Mutex
+		downloaded uint64
+	)
+	fetch := view.txDataByIdGetter
+	view.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		data, err := fetch(txId)
+		mtx.Lock()
+		downloaded += uint64(len(data))
+		mtx.Unlock()
+		return data, err
+	}
+	bytesDownloaded := func() uint64 {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return downloaded
+	}
+	defer func() {
+		report.BytesDownloaded = bytesDownloaded()
+		report.Elapsed = time.Since(start)
+	}()
+
+	report = ExportReport{Version: version, Prefix: prefix, Gaps: []GapInfo{}}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultExportBatchSize
+	}
+	if opts.GapRetries.MaxAttempts == 0 {
+		opts.GapRetries = DefaultFetchRetryPolicy
+	}
+	next, err := loadExportCheckpoint(dst, version, prefix)
+	if err != nil {
+		return report, err
+	}
+	index, err := view.getIndex(version)
+	if err != nil {
+		return report, err
+	}
+	entries := lookupIndexEntriesForRange(string(prefix), prefixEnd(prefix), index)
+	if next > len(entries) {
+		next = 0
+	}
+	report.Payloads, report.ResumedPayloads = len(entries), next
+
+	exporter := &prefixExporter{
+		ctx:     ctx,
+		db:      view,
+		version: version,
+		prefix:  prefix,
+		entries: entries,
+		opts:    opts,
+		sem:     make(chan struct{}, opts.Concurrency),
+	}
+	loads := []*exportLoad{}
+	for idx := next; idx < len(entries); idx++ {
+		for launched := idx + len(loads); len(loads) < opts.Concurrency && launched < len(entries); launched++ {
+			loads = append(loads, exporter.startLoad(launched))
+		}
+		load := loads[0]
+		loads = loads[1:]
+		select {
+		case <-load.done:
+		case <-ctx.Done():
+			return report, ctx.Err()
+		}
+		if load.err != nil {
+			return report, load.err
+		}
+		if load.gap != nil {
+			report.Gaps = append(report.Gaps, *load.gap)
+		}
+		keys, err := exporter.write(dst, idx, load)
+		report.KeysWritten += keys
+		if err != nil {
+			return report, err
+		}
+		if opts.Progress != nil {
+			opts.Progress(ExportProgress{
+				PayloadsDone:    idx + 1,
+				PayloadsTotal:   len(entries),
+				KeysWritten:     report.KeysWritten,
+				BytesDownloaded: bytesDownloaded(),
+			})
+		}
+	}
+	return report, metadataDB(dst).DeleteSync(exportProgressKey)
+}
+
+// loadExportCheckpoint returns the index of the next payload to export
+// among those of prefix, 0 without a checkpoint.
+func loadExportCheckpoint(dst dbm.DB, version uint64, prefix []byte) (int, error) {
+	bz, err := dst.Get(exportProgressKey)
+	if err != nil || bz == nil {
+		return 0, err
+	}
+	checkpoint := exportCheckpoint{}
+	if err := json.Unmarshal(bz, &checkpoint); err != nil {
+		return 0, fmt.Errorf("malformed export checkpoint: %w", err)
+	}
+	if checkpoint.Version != version || !bytes.Equal(checkpoint.Prefix, prefix) {
+		return 0, fmt.Errorf("destination holds the checkpoint of the interrupted export of prefix %x of version %d", checkpoint.Prefix, checkpoint.Version)
+	}
+	return checkpoint.NextPayload, nil
+}
+
+type prefixExporter struct {
+	ctx     context.Context
+	db      *ArweaveDB
+	version uint64
+	prefix  []byte
+	entries []IndexEntry
+	opts    ExportPrefixOptions
+	// bounds the payloads fetched at once
+	sem chan struct{}
+}
+
+// exportLoad is the payload of an entry loaded in the background, or the
+// gap it is skipped as.
+type exportLoad struct {
+	data       map[string]interface{}
+	sortedKeys []string
+	gap        *GapInfo
+	err        error
+	done       chan struct{}
+}
+
+// startLoad loads the payload of the entry at idx in the background, once a
+// fetch slot is available.
+func (e *prefixExporter) startLoad(idx int) *exportLoad {
+	load := &exportLoad{done: make(chan struct{})}
+	go func() {
+		defer close(load.done)
+		select {
+		case e.sem <- struct{}{}:
+		case <-e.ctx.Done():
+			load.err = e.ctx.Err()
+			return
+		}
+		defer func() { <-e.sem }()
+		entry := e.entries[idx]
+		reload := func() (map[string]interface{}, []string, error) {
+			return e.db.loadEntryPayload(entry, true)
+		}
+		load.data, load.sortedKeys, load.err = reload()
+		if load.err == nil || e.opts.Gaps == GapFailFast || !skippable(load.err) {
+			load.err = withPayloadContext(load.err, e.version, entry)
+			return
+		}
+		load.data, load.sortedKeys, load.gap, load.err = e.db.retryOrSkip(e.opts.Gaps, e.opts.GapRetries, e.version, e.entries, idx, load.err, reload)
+		load.err = withPayloadContext(load.err, e.version, entry)
+	}()
+	return load
+}
+
+// write writes the keys of the payload at idx starting with the prefix,
+// checkpointing the export past it along with the last of them, and returns
+// the number of keys written.
+func (e *prefixExporter) write(dst dbm.DB, idx int, load *exportLoad) (written int, err error) {
+	// dst may guard the reserved namespace the checkpoint is written to,
+	// keys being validated here instead
+	batch := metadataDB(dst).NewBatch()
+	defer func() { batch.Close() }()
+	pending := 0
+	for _, key := range load.sortedKeys {
+		if !strings.HasPrefix(key, string(e.prefix)) {
+			continue
+		}
+		if err := ValidateUserKey([]byte(key)); err != nil {
+			return written, err
+		}
+		raw, err := e.db.decodeValue(key, e.entries[idx].txId, load.data[key])
+		if err != nil {
+			return written, err
+		}
+		value, err := e.db.resolveValue(raw)
+		if err != nil {
+			return written, err
+		}
+		if err := batch.Set([]byte(key), value); err != nil {
+			return written, err
+		}
+		if pending++; pending == e.opts.BatchSize {
+			if err := batch.Write(); err != nil {
+				return written, err
+			}
+			written += pending
+			pending = 0
+			batch.Close()
+			batch = metadataDB(dst).NewBatch()
+		}
+	}
+	bz, err := json.Marshal(exportCheckpoint{Version: e.version, Prefix: e.prefix, NextPayload: idx + 1})
+	if err != nil {
+		return written, err
+	}
+	if err := batch.Set(exportProgressKey, bz); err != nil {
+		return written, err
+	}
+	if err := batch.WriteSync(); err != nil {
+		return written, err
+	}
+	return written + pending, nil
+}
@@ -0,0 +1,154 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// newExportTestDB returns a DB whose version 0 spreads the keys of prefix
+// "b" over its first two payloads, calling fetched on every payload fetch
+// with the index of the payload, failing the fetch if it returns an error.
+// Calls of fetched are serialized.
+func newExportTestDB(fetched func(txIdx int) error) *ArweaveDB {
+	db := NewMockArweaveDB(
+		[][]byte{mockIndex([]string{"b2", "c1", "d1"}, []int{0, 1, 2})},
+		[][]byte{
+			mockTxData([]string{"a1", "b1", "b2"}, []string{"1", "2", "3"}),
+			mockTxData([]string{"b3", "c1"}, []string{"4", "5"}),
+			mockTxData([]string{"d1"}, []string{"6"}),
+		},
+		[]int{0, 1, 2},
+	)
+	getter := db.txDataByIdGetter
+	mtx := sync.Mutex{}
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		for i := 0; i < 3; i++ {
+			if string(txId) == intToBase64Sha256(i) {
+				mtx.Lock()
+				err := fetched(i)
+				mtx.Unlock()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return getter(txId)
+	}
+	return db
+}
+
+func requireExported(t *testing.T, dst dbm.DB, kvs map[string]string) {
+	t.Helper()
+	iter, err := dst.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+	exported := map[string]string{}
+	for ; iter.Valid(); iter.Next() {
+		exported[string(iter.Key())] = string(iter.Value())
+	}
+	require.Equal(t, kvs, exported)
+}
+
+func TestExportPrefix(t *testing.T) {
+	fetches := map[int]int{}
+	db := newExportTestDB(func(txIdx int) error {
+		fetches[txIdx]++
+		return nil
+	})
+	dst := dbm.NewMemDB()
+	progress := []ExportProgress{}
+
+	report, err := db.ExportPrefix(context.Background(), 0, []byte("b"), dst, ExportPrefixOptions{
+		Concurrency: 2,
+		BatchSize:   1,
+		Progress: func(p ExportProgress) {
+			progress = append(progress, p)
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Payloads)
+	require.Equal(t, 0, report.ResumedPayloads)
+	require.Equal(t, 3, report.KeysWritten)
+	require.Empty(t, report.Gaps)
+	require.NotZero(t, report.BytesDownloaded)
+	require.Equal(t, map[int]int{0: 1, 1: 1}, fetches)
+	require.Len(t, progress, 2)
+	require.Equal(t, ExportProgress{PayloadsDone: 1, PayloadsTotal: 2, KeysWritten: 2, BytesDownloaded: progress[0].BytesDownloaded}, progress[0])
+	require.Equal(t, 2, progress[1].PayloadsDone)
+	require.Equal(t, 3, progress[1].KeysWritten)
+	// the checkpoint is removed once the export completes
+	requireExported(t, dst, map[string]string{"b1": "2", "b2": "3", "b3": "4"})
+}
+
+func TestExportPrefixResumes(t *testing.T) {
+	fetches := map[int]int{}
+	failing := true
+	db := newExportTestDB(func(txIdx int) error {
+		fetches[txIdx]++
+		if txIdx == 1 && failing {
+			return errors.New("gateway unavailable")
+		}
+		return nil
+	})
+	dst := dbm.NewMemDB()
+
+	report, err := db.ExportPrefix(context.Background(), 0, []byte("b"), dst, ExportPrefixOptions{})
+	require.Error(t, err)
+	require.Equal(t, 2, report.KeysWritten)
+	has, err := dst.Has(exportProgressKey)
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// other exports fail until the interrupted one completes
+	_, err = db.ExportPrefix(context.Background(), 0, []byte("c"), dst, ExportPrefixOptions{})
+	require.Error(t, err)
+
+	failing = false
+	report, err = db.ExportPrefix(context.Background(), 0, []byte("b"), dst, ExportPrefixOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ResumedPayloads)
+	require.Equal(t, 1, report.KeysWritten)
+	require.Equal(t, map[int]int{0: 1, 1: 2}, fetches)
+	requireExported(t, dst, map[string]string{"b1": "2", "b2": "3", "b3": "4"})
+}
+
+func TestExportPrefixGaps(t *testing.T) {
+	db := newExportTestDB(func(txIdx int) error {
+		if txIdx == 0 {
+			return errors.New("gateway unavailable")
+		}
+		return nil
+	})
+	dst := dbm.NewMemDB()
+
+	report, err := db.ExportPrefix(context.Background(), 0, []byte("b"), dst, ExportPrefixOptions{Gaps: GapSkipWithReport})
+	require.NoError(t, err)
+	require.Len(t, report.Gaps, 1)
+	require.Equal(t, intToBase64Sha256(0), report.Gaps[0].TxId)
+	require.Equal(t, 1, report.KeysWritten)
+	requireExported(t, dst, map[string]string{"b3": "4"})
+}
+
+func TestExportPrefixCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db := newExportTestDB(func(txIdx int) error {
+		if txIdx == 1 {
+			cancel()
+		}
+		return nil
+	})
+	dst := dbm.NewMemDB()
+
+	_, err := db.ExportPrefix(ctx, 0, []byte("b"), dst, ExportPrefixOptions{Gaps: GapSkipWithReport})
+	require.ErrorIs(t, err, context.Canceled)
+
+	report, err := db.ExportPrefix(context.Background(), 0, []byte("b"), dst, ExportPrefixOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ResumedPayloads)
+	requireExported(t, dst, map[string]string{"b1": "2", "b2": "3", "b3": "4"})
+}
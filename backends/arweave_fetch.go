@@ -0,0 +1,133 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultFetchConcurrency bounds the payloads fetched at once by the
+// iterators of an ArweaveDB reading ahead, unless set by
+// WithFetchConcurrency.
+const DefaultFetchConcurrency = 8
+
+// errFetchCanceled fails the loads following a failed one.
+var errFetchCanceled = errors.New("fetch canceled after an earlier failure")
+
+// fetchPool bounds the payloads fetched at once by the iterators of an
+// ArweaveDB reading ahead.
+type fetchPool struct {
+	limit int
+	sem   chan struct{}
+}
+
+func newFetchPool(limit int) *fetchPool {
+	return &fetchPool{limit: limit, sem: make(chan struct{}, limit)}
+}
+
+// WithFetchConcurrency makes iterators read up to limit payloads ahead of
+// the one they are at, fetching them concurrently so that ranges over many
+// payloads don't take a round trip each. The limit is shared by all the
+// iterators of the DB so as not to stampede the gateway. Keys are iterated
+// in the same order, and payloads fetched the same number of times, as
+// without reading ahead. A failed fetch cancels those of the following
+// payloads, and is reported once reached. A limit of 0 fetches payloads as
+// they are reached, unless decode workers read ahead, see
+// WithDecodeWorkers.
+func WithFetchConcurrency(limit int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if limit < 0 {
+			db.invalidOption(fmt.Errorf("WithFetchConcurrency needs a non-negative limit, got %d", limit))
+		}
+		if limit <= 0 {
+			db.fetchPool = nil
+			return
+		}
+		db.fetchPool = newFetchPool(limit)
+	}
+}
+
+// readsAhead returns whether iterators read payloads ahead.
+func (db *ArweaveDB) readsAhead() bool {
+	return db.fetchPool != nil || db.decodePool != nil
+}
+
+// readAheadDepth returns how many payloads iterators read ahead.
+func (db *ArweaveDB) readAheadDepth() int {
+	if db.fetchPool != nil {
+		return db.fetchPool.limit
+	}
+	return db.decodePool.workers
+}
+
+// fetchSlots returns the semaphore bounding the payloads fetched at once.
+func (db *ArweaveDB) fetchSlots() chan struct{} {
+	if db.fetchPool != nil {
+		return db.fetchPool.sem
+	}
+	return db.decodePool.fetches.sem
+}
+
+// fetchGroup tracks the loads of an iterator so that a failed one cancels
+// the loads following it in iteration order, while those before it, which
+// are reached first, still complete.
+type fetchGroup struct {
+	// sequence number of the next load, only used by the iterator
+	next int
+
+	mtx sync.Mutex
+	// whether loads past stoppedAt are canceled
+	stopped   bool
+	stoppedAt int
+	// closed once stopped, waking loads waiting for a fetch slot
+	done chan struct{}
+}
+
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{done: make(chan struct{})}
+}
+
+// stop cancels the loads following the one numbered seq, -1 canceling all
+// of them.
+func (g *fetchGroup) stop(seq int) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if !g.stopped {
+		g.stopped, g.stoppedAt = true, seq
+		close(g.done)
+	} else if seq < g.stoppedAt {
+		g.stoppedAt = seq
+	}
+}
+
+// isStopped returns whether a load failed, or the group was closed.
+func (g *fetchGroup) isStopped() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.stopped
+}
+
+// canceled returns whether the load numbered seq is canceled.
+func (g *fetchGroup) canceled(seq int) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.stopped && seq > g.stoppedAt
+}
+
+// acquire waits for one of slots for the load numbered seq, failing with
+// errFetchCanceled if it is canceled meanwhile.
+func (g *fetchGroup) acquire(slots chan struct{}, seq int) error {
+	select {
+	case slots <- struct{}{}:
+	case <-g.done:
+		if g.canceled(seq) {
+			return errFetchCanceled
+		}
+		slots <- struct{}{}
+	}
+	if g.canceled(seq) {
+		<-slots
+		return errFetchCanceled
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package backends
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowFetches makes the payload fetches of db take latency, counting them
+// per tx ID along with the most fetches in flight at once.
+func slowFetches(db *ArweaveDB, latency time.Duration) (fetched map[string]int, maxInFlight *int32) {
+	fetched, maxInFlight = map[string]int{}, new(int32)
+	var mtx sync.Mutex
+	inFlight := int32(0)
+	getter := db.txDataByIdGetter
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for max := atomic.LoadInt32(maxInFlight); n > max && !atomic.CompareAndSwapInt32(maxInFlight, max, n); max = atomic.LoadInt32(maxInFlight) {
+		}
+		mtx.Lock()
+		fetched[string(txId)]++
+		mtx.Unlock()
+		time.Sleep(latency)
+		return getter(txId)
+	}
+	return fetched, maxInFlight
+}
+
+func TestFetchConcurrencyPreservesOrderAndFetches(t *testing.T) {
+	for _, reverse := range []bool{false, true} {
+		sequential := newDecodeTestDB(12, 20, 3)
+		sequentialFetched, _ := slowFetches(sequential, 0)
+		expected := scanDecoded(t, sequential, reverse)
+		require.Len(t, expected, 10+7*20+5)
+		for _, limit := range []int{1, 3, 8} {
+			parallel := newDecodeTestDB(12, 20, 3, WithFetchConcurrency(limit))
+			fetched, maxInFlight := slowFetches(parallel, time.Millisecond)
+			require.Equal(t, expected, scanDecoded(t, parallel, reverse), "limit %d reverse %t", limit, reverse)
+			require.Equal(t, sequentialFetched, fetched, "limit %d reverse %t", limit, reverse)
+			require.LessOrEqual(t, *maxInFlight, int32(limit))
+		}
+	}
+}
+
+func TestFetchConcurrencySharedLimit(t *testing.T) {
+	// the index is fetched once, beforehand
+	db := newDecodeTestDB(20, 2, 1, WithFetchConcurrency(3), WithIndexCache(1))
+	_, err := db.Get(versionedKey(0, "0000/000000"))
+	require.Nil(t, err)
+	_, maxInFlight := slowFetches(db, 2*time.Millisecond)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			iter, err := db.Iterator(versionedKey(0, ""), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer iter.Close()
+			count := 0
+			for ; iter.Valid(); iter.Next() {
+				count++
+			}
+			if count != 40 || iter.Error() != nil {
+				t.Errorf("iterated %d keys, error %v", count, iter.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(3), *maxInFlight)
+	require.Len(t, db.fetchPool.sem, 0)
+}
+
+func TestFetchConcurrencyErrors(t *testing.T) {
+	db := newDecodeTestDB(30, 2, 1, WithFetchConcurrency(4))
+	fetched, _ := slowFetches(db, time.Millisecond)
+	getter := db.txDataByIdGetter
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		if string(txId) == intToBase64Sha256(5) {
+			return nil, &ErrKeyNotFound{}
+		}
+		return getter(txId)
+	}
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	count := 0
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	// the keys of the payloads before the failed one, and its error
+	require.Equal(t, 10, count)
+	require.ErrorAs(t, iter.Error(), new(*ErrKeyNotFound))
+	waitForLoads(iter.(*arweaveDBIterator))
+	require.Nil(t, iter.Close())
+	// payloads past those read ahead when the fetch failed aren't fetched
+	require.Less(t, len(fetched), 5+4)
+	require.Len(t, db.fetchPool.sem, 0)
+}
+
+func TestFetchGroupCancelsFollowingLoads(t *testing.T) {
+	slots := make(chan struct{}, 1)
+	slots <- struct{}{}
+	g := newFetchGroup()
+	errs := make(chan error, 1)
+	go func() { errs <- g.acquire(slots, 2) }()
+	g.stop(1)
+	require.Equal(t, errFetchCanceled, <-errs)
+
+	// loads before the failed one still wait for a slot
+	go func() { errs <- g.acquire(slots, 0) }()
+	<-slots
+	require.Nil(t, <-errs)
+	require.Len(t, slots, 1)
+
+	// closing cancels all of them
+	<-slots
+	g.stop(-1)
+	require.Equal(t, errFetchCanceled, g.acquire(slots, 0))
+	require.Len(t, slots, 0)
+}
+
+func BenchmarkFetchConcurrency(b *testing.B) {
+	// 50 payloads fetched with a millisecond of latency each
+	for _, limit := range []int{0, 2, 8, 32} {
+		db := newDecodeTestDB(50, 100, 8, WithFetchConcurrency(limit))
+		fetched, _ := slowFetches(db, time.Millisecond)
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			for k := range fetched {
+				delete(fetched, k)
+			}
+			for i := 0; i < b.N; i++ {
+				iter, err := db.Iterator(versionedKey(0, ""), nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for ; iter.Valid(); iter.Next() {
+				}
+				iter.Close()
+			}
+			// the index and every payload are fetched once per scan
+			if len(fetched) != 51 || fetched[intToBase64Sha256(0)] != b.N {
+				b.Fatalf("fetched %v", fetched)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package backends_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+// fixtureCodecs are the version codecs the fixture tests are run with.
+var fixtureCodecs = []backends.VersionCodec{backends.BigEndian64VersionCodec, backends.BigEndian32VersionCodec}
+
+func forEachCodec(t *testing.T, test func(t *testing.T, codec backends.VersionCodec)) {
+	for _, codec := range fixtureCodecs {
+		codec := codec
+		t.Run(codec.Name(), func(t *testing.T) { test(t, codec) })
+	}
+}
+
+func newTestArchive(t *testing.T, codec backends.VersionCodec) *arweavetest.Archive {
+	archive, err := arweavetest.NewArchiveBuilder().VersionCodec(codec).
+		Version(0).KV("aa", "v1").KV("cc", "v2").KV("cd", "v3").KV("ce", "v4").
+		Version(1).KV("ac", "v5").KV("cc", "v6").KV("ce", "v7").
+		ChunkedIndex(1).
+		Build()
+	require.Nil(t, err)
+	return archive
+}
+
+func TestGet(t *testing.T) {
+	forEachCodec(t, func(t *testing.T, codec backends.VersionCodec) {
+		archive := newTestArchive(t, codec)
+		db := archive.NewDB()
+		for _, version := range archive.Versions() {
+			for key, expected := range archive.Expected[version] {
+				value, err := db.Get(archive.Key(version, key))
+				require.Nil(t, err)
+				require.Equal(t, expected, value)
+				has, err := db.Has(archive.Key(version, key))
+				require.Nil(t, err)
+				require.True(t, has)
+			}
+		}
+
+		_, err := db.Get(archive.Key(0, "ac"))
+		require.ErrorIs(t, err, backends.ErrNotFound)
+		notFound := &backends.ErrKeyNotFound{}
+		require.ErrorAs(t, err, &notFound)
+		require.Equal(t, []byte("ac"), notFound.Key())
+		version, ok := notFound.Version()
+		require.True(t, ok)
+		require.Equal(t, uint64(0), version)
+		require.Len(t, notFound.TxIds(), 1)
+	})
+}
+
+func TestIterator(t *testing.T) {
+	forEachCodec(t, func(t *testing.T, codec backends.VersionCodec) {
+		archive := newTestArchive(t, codec)
+		db := archive.NewDB()
+		tester := func(start string, end string, keys []string, vals []string) {
+			iterator, err := db.Iterator(archive.Key(0, start), archive.Key(0, end))
+			require.Nil(t, err)
+			reviterator, err := db.ReverseIterator(archive.Key(0, start), archive.Key(0, end))
+			require.Nil(t, err)
+			for i, key := range keys {
+				require.Equal(t, key, string(iterator.Key()))
+				require.Equal(t, vals[i], string(iterator.Value()))
+				iterator.Next()
+				require.Equal(t, keys[len(keys)-1-i], string(reviterator.Key()))
+				require.Equal(t, vals[len(keys)-1-i], string(reviterator.Value()))
+				reviterator.Next()
+			}
+			require.False(t, iterator.Valid())
+			require.False(t, reviterator.Valid())
+		}
+		tester("a", "cc", []string{"aa"}, []string{"v1"})
+		tester("aa", "cd", []string{"aa", "cc"}, []string{"v1", "v2"})
+		tester("aa", "ce", []string{"aa", "cc", "cd"}, []string{"v1", "v2", "v3"})
+		tester("aa", "cea", []string{"aa", "cc", "cd", "ce"}, []string{"v1", "v2", "v3", "v4"})
+
+		_, err := db.Iterator(archive.Key(0, "a"), archive.Key(1, "z"))
+		require.NotNil(t, err)
+	})
+}
+
+func collectKV(t *testing.T, iter dbm.Iterator) []string {
+	kvs := []string{}
+	for ; iter.Valid(); iter.Next() {
+		kvs = append(kvs, string(iter.Key())+"="+string(iter.Value()))
+	}
+	require.Nil(t, iter.Error())
+	require.Nil(t, iter.Close())
+	return kvs
+}
+
+func TestIteratorBoundsMatchMemDB(t *testing.T) {
+	long := strings.Repeat("z", backends.IndexKeyPrefixLen+10)
+	forEachCodec(t, func(t *testing.T, codec backends.VersionCodec) {
+		archive := newTestArchive(t, codec)
+		db := archive.NewDB()
+		mem := dbm.NewMemDB()
+		for key, value := range archive.Expected[0] {
+			require.Nil(t, mem.Set([]byte(key), value))
+		}
+		// nil bounds are unbounded, and so is the empty start of Arweave
+		// iterators, which only carries the version
+		arweaveBound := func(bound *string) []byte {
+			if bound == nil {
+				return nil
+			}
+			return archive.Key(0, *bound)
+		}
+		memBound := func(bound *string) []byte {
+			if bound == nil || *bound == "" {
+				return nil
+			}
+			return []byte(*bound)
+		}
+		str := func(s string) *string { return &s }
+		for _, bounds := range [][2]*string{
+			{str(""), nil}, {str("a"), nil}, {str("ab"), nil}, {str("cd"), nil}, {str("z"), nil}, {str(long), nil},
+			{nil, str("a")}, {nil, str("cd")}, {nil, str("z")}, {nil, str(long)},
+			{str("a"), str("b")}, {str("c"), str("d")}, {str("b"), str("c")}, {str("cc"), str(long)}, {str("cd"), str("cd")},
+		} {
+			for _, inclusive := range []bool{false, true} {
+				memEnd := memBound(bounds[1])
+				if inclusive && memEnd != nil {
+					memEnd = append(memEnd, 0)
+				}
+				for _, reverse := range []bool{false, true} {
+					var memIter dbm.Iterator
+					var err error
+					if reverse {
+						memIter, err = mem.ReverseIterator(memBound(bounds[0]), memEnd)
+					} else {
+						memIter, err = mem.Iterator(memBound(bounds[0]), memEnd)
+					}
+					require.Nil(t, err)
+					iter, err := db.IteratorWithOptions(arweaveBound(bounds[0]), arweaveBound(bounds[1]),
+						backends.IteratorOptions{Reverse: reverse, InclusiveEnd: inclusive})
+					require.Nil(t, err)
+					require.Equal(t, collectKV(t, memIter), collectKV(t, iter), "bounds %q, inclusive %v, reverse %v", bounds, inclusive, reverse)
+				}
+			}
+		}
+
+		_, err := db.Iterator(nil, nil)
+		require.NotNil(t, err)
+	})
+}
+
+func TestFixtureIndexMetadata(t *testing.T) {
+	for name, legacy := range map[string]bool{"v1": false, "legacy": true} {
+		forEachCodec(t, func(t *testing.T, codec backends.VersionCodec) {
+			t.Run(name, func(t *testing.T) {
+				builder := arweavetest.NewArchiveBuilder().VersionCodec(codec).Prefix("k").Keys("1", "2", "3", "4", "5").ChunkedIndex(2)
+				if legacy {
+					builder.LegacyIndex()
+				}
+				archive, err := builder.Build()
+				require.Nil(t, err)
+				desc, err := archive.NewDB().DescribeIndex(0)
+				require.Nil(t, err)
+				require.Equal(t, 3, len(desc.Entries))
+				keyCount := uint32(0)
+				for _, entry := range desc.Entries {
+					if legacy {
+						require.Equal(t, backends.IndexEntryInfo{}, entry.Info)
+						continue
+					}
+					require.Equal(t, backends.CodecJSON, entry.Info.Codec)
+					require.Equal(t, uint64(len(archive.TxData[entry.TxId])), entry.Info.PayloadSize)
+					keyCount += entry.Info.KeyCount
+				}
+				if legacy {
+					require.Equal(t, backends.LegacyIndexFormat, desc.Format)
+				} else {
+					require.Equal(t, backends.IndexFormatV1, desc.Format)
+					require.Equal(t, uint32(5), keyCount)
+				}
+			})
+		})
+	}
+}
+
+func TestFixtureSharedKeyPrefix(t *testing.T) {
+	forEachCodec(t, testFixtureSharedKeyPrefix)
+}
+
+func testFixtureSharedKeyPrefix(t *testing.T, codec backends.VersionCodec) {
+	// keys only differing past the indexed prefix are split across payloads
+	// with the same index entry prefix
+	long := strings.Repeat("x", backends.IndexKeyPrefixLen)
+	archive, err := arweavetest.NewArchiveBuilder().VersionCodec(codec).
+		Keys("a").Prefix(long).Keys("1", "2", "3").Prefix("").Keys("y").
+		ChunkedIndex(2).
+		Build()
+	require.Nil(t, err)
+	db := archive.NewDB()
+	desc, err := db.DescribeIndex(0)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(desc.Entries))
+	require.Equal(t, desc.Entries[0].KeyPrefix, desc.Entries[1].KeyPrefix)
+
+	for key, expected := range archive.Expected[0] {
+		value, err := db.Get(archive.Key(0, key))
+		require.Nil(t, err)
+		require.Equal(t, expected, value)
+	}
+	iter, err := db.Iterator(archive.Key(0, "a"), archive.Key(0, "z"))
+	require.Nil(t, err)
+	keys := []string{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.Nil(t, iter.Error())
+	require.Equal(t, []string{"a", long + "1", long + "2", long + "3", "y"}, keys)
+}
+
+// fixtureBinaryKVs are keys and values JSON payloads can't hold.
+var fixtureBinaryKVs = map[string]string{
+	"\x00":         "\x00\x00",
+	"\x00key":      "\xff",
+	"\xc3\x28":     "\xc3\x28\xa0\xa1",
+	"plain":        "value",
+	"\xff\xfe\xfd": "",
+}
+
+func TestFixturePayloadCodecs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		codec       backends.PayloadCodec
+		compression backends.TxCompression
+		legacy      bool
+	}{
+		"json gzip":     {codec: backends.CodecJSON, compression: backends.CompressionGzip},
+		"json zstd":     {codec: backends.CodecJSON, compression: backends.CompressionZstd},
+		"binary":        {codec: backends.CodecBinary},
+		"binary legacy": {codec: backends.CodecBinary, legacy: true},
+		"binary gzip":   {codec: backends.CodecBinary, compression: backends.CompressionGzip},
+		"binary zstd":   {codec: backends.CodecBinary, compression: backends.CompressionZstd},
+	} {
+		t.Run(name, func(t *testing.T) {
+			builder := arweavetest.NewArchiveBuilder().Codec(tc.codec).Compress(tc.compression).ChunkedIndex(2)
+			for key, value := range fixtureBinaryKVs {
+				if tc.codec == backends.CodecBinary || key == "plain" {
+					builder.KV(key, value)
+				}
+			}
+			builder.Keys("a", "b", "c")
+			if tc.legacy {
+				builder.LegacyIndex()
+			}
+			archive, err := builder.Build()
+			require.Nil(t, err)
+			expected := []string{}
+			for key, value := range archive.Expected[0] {
+				expected = append(expected, key+"="+string(value))
+			}
+			sort.Strings(expected)
+
+			options := [][]backends.ArweaveOption{nil, {backends.WithStreamingGets(1)}}
+			if !tc.legacy {
+				options = append(options, []backends.ArweaveOption{backends.WithStrictMode()})
+			}
+			for _, opts := range options {
+				db := archive.NewDB(opts...)
+				for key, value := range archive.Expected[0] {
+					got, err := db.Get(arweavetest.Key(0, key))
+					require.Nil(t, err)
+					require.Equal(t, value, got, "%X", key)
+					has, err := db.Has(arweavetest.Key(0, key))
+					require.Nil(t, err)
+					require.True(t, has)
+				}
+				has, err := db.Has(arweavetest.Key(0, "\x01"))
+				require.Nil(t, err)
+				require.False(t, has)
+
+				iter, err := db.Iterator(arweavetest.Key(0, ""), nil)
+				require.Nil(t, err)
+				require.Equal(t, expected, collectKV(t, iter))
+				iter, err = db.ReverseIterator(arweavetest.Key(0, ""), nil)
+				require.Nil(t, err)
+				reversed := collectKV(t, iter)
+				sort.Strings(reversed)
+				require.Equal(t, expected, reversed)
+			}
+
+			if !tc.legacy {
+				desc, err := archive.NewDB().DescribeIndex(0)
+				require.Nil(t, err)
+				for _, entry := range desc.Entries {
+					require.Equal(t, tc.codec, entry.Info.Codec)
+					require.Equal(t, tc.compression, entry.Info.Compression)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package backends
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultFutureVersionPollInterval is how often reads of versions beyond
+// the latest archived one check for them during their grace period by
+// default.
+const DefaultFutureVersionPollInterval = 250 * time.Millisecond
+
+// futureVersions tells how long reads wait for versions beyond the latest
+// archived one.
+type futureVersions struct {
+	grace        time.Duration
+	pollInterval time.Duration
+}
+
+// WithFutureVersionGrace makes reads of versions beyond the latest archived
+// one, e.g. routed to this node before the archive caught up, wait up to
+// grace for the version to be archived, checking every pollInterval, before
+// failing with ErrVersionNotYetArchived. Unless already cached, the latest
+// version is probed for on such reads, see GetLatestVersion. A zero
+// pollInterval defaults to DefaultFutureVersionPollInterval.
+func WithFutureVersionGrace(grace, pollInterval time.Duration) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if grace < 0 {
+			db.invalidOption(fmt.Errorf("WithFutureVersionGrace needs a non-negative grace period, got %s", grace))
+		}
+		if pollInterval < 0 {
+			db.invalidOption(fmt.Errorf("WithFutureVersionGrace needs a non-negative poll interval, got %s", pollInterval))
+		}
+		if pollInterval <= 0 {
+			pollInterval = DefaultFutureVersionPollInterval
+		}
+		db.futureVersions = &futureVersions{grace: grace, pollInterval: pollInterval}
+	}
+}
+
+// resolveFutureVersion is called with notFound, the error of resolving
+// version, if it isn't archived. Versions beyond the latest archived one
+// are waited for during the grace period, and fail with
+// ErrVersionNotYetArchived if they still aren't archived by then. Other
+// versions fail with notFound. The latest version is the one cached by
+// GetLatestVersion, only probed for here with a grace period, so that reads
+// of missing versions don't otherwise probe the version getter.
+func (db *ArweaveDB) resolveFutureVersion(version uint64, notFound error) ([]byte, error) {
+	latest, ok := db.cachedLatestVersion()
+	if !ok && db.futureVersions != nil {
+		var err error
+		if latest, err = db.GetLatestVersion(); err == nil {
+			ok = true
+		}
+	}
+	if !ok || version <= latest {
+		return nil, notFound
+	}
+	if db.futureVersions == nil || db.futureVersions.grace <= 0 {
+		return nil, &ErrVersionNotYetArchived{requested: version, latest: latest}
+	}
+	timer := time.NewTimer(db.futureVersions.grace)
+	defer timer.Stop()
+	ticker := time.NewTicker(db.futureVersions.pollInterval)
+	defer ticker.Stop()
+	ctx := db.context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			if refreshed, err := db.RefreshLatestVersion(); err == nil {
+				latest = refreshed
+			}
+			return nil, &ErrVersionNotYetArchived{requested: version, latest: latest}
+		case <-ticker.C:
+		}
+		txId, err := db.getIndexTxId(version)
+		if err != nil && !isVersionNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			db.advanceLatestVersion(version)
+			return txId, nil
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newFutureTestDB returns a DB whose versions, resolved by versions, all
+// hold a=1.
+func newFutureTestDB(versions *sparseVersionGetter, opts ...ArweaveOption) *ArweaveDB {
+	txData := func(txId []byte) ([]byte, error) {
+		if string(txId) == intToBase64Sha256(1000) {
+			return mockTxData([]string{"a"}, []string{"1"}), nil
+		}
+		return mockIndex([]string{"z"}, []int{1000}), nil
+	}
+	return NewArweaveDBWithGetters(txData, versions.get, opts...)
+}
+
+func (g *sparseVersionGetter) advance(versions uint64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.versions = versions
+}
+
+func TestVersionNotYetArchived(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 3, gaps: map[uint64]bool{1: true}}
+	db := newFutureTestDB(versions)
+
+	// without a known latest version, missing versions are just missing
+	_, err := db.Get(versionedKey(5, "a"))
+	require.True(t, errors.As(err, new(*ErrKeyNotFound)))
+	require.Equal(t, 1, versions.callCount())
+
+	latest, err := db.GetLatestVersion()
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), latest)
+	_, err = db.Get(versionedKey(5, "a"))
+	notYet := &ErrVersionNotYetArchived{}
+	require.True(t, errors.As(err, &notYet))
+	require.Equal(t, uint64(5), notYet.Requested())
+	require.Equal(t, uint64(2), notYet.Latest())
+	require.True(t, IsTransientFetchError(err))
+	_, err = db.Has(versionedKey(3, "a"))
+	require.True(t, errors.As(err, new(*ErrVersionNotYetArchived)))
+
+	// gaps below the latest version aren't about to be archived
+	_, err = db.Get(versionedKey(1, "a"))
+	require.True(t, errors.As(err, new(*ErrKeyNotFound)))
+}
+
+func TestFutureVersionGrace(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 3}
+	db := newFutureTestDB(versions, WithFutureVersionGrace(5*time.Second, time.Millisecond))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		versions.advance(6)
+	}()
+	value, err := db.Get(versionedKey(5, "a"))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+	latest, err := db.GetLatestVersion()
+	require.Nil(t, err)
+	require.Equal(t, uint64(5), latest)
+}
+
+func TestFutureVersionGraceTimeout(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 3}
+	db := newFutureTestDB(versions, WithFutureVersionGrace(50*time.Millisecond, time.Millisecond))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		versions.advance(5)
+	}()
+	start := time.Now()
+	_, err := db.Get(versionedKey(5, "a"))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	notYet := &ErrVersionNotYetArchived{}
+	require.True(t, errors.As(err, &notYet))
+	// the latest version is refreshed once the grace period is over
+	require.Equal(t, uint64(4), notYet.Latest())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.WithContext(ctx).Get(versionedKey(6, "a"))
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestHasVersionFutureTTL(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 3, gaps: map[uint64]bool{1: true}}
+	db := newFutureTestDB(versions, WithVersionProbeCache(time.Minute))
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	db.versionProbes.now = clock.Now
+	_, err := db.GetLatestVersion()
+	require.Nil(t, err)
+
+	for _, version := range []uint64{1, 3} {
+		exists, err := db.HasVersion(version)
+		require.Nil(t, err)
+		require.False(t, exists)
+	}
+	versions.advance(4)
+	versions.mtx.Lock()
+	versions.gaps = nil
+	versions.mtx.Unlock()
+
+	// versions beyond the latest one are probed again sooner
+	clock.Advance(DefaultVersionProbeFutureTTL)
+	for version, expected := range map[uint64]bool{1: false, 3: true} {
+		exists, err := db.HasVersion(version)
+		require.Nil(t, err)
+		require.Equal(t, expected, exists, "version %d", version)
+	}
+}
@@ -0,0 +1,117 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// GapPolicy decides how the iterators of an ArweaveDB handle payloads
+// failing to be fetched or decoded.
+type GapPolicy uint8
+
+const (
+	// GapFailFast invalidates the iterator, reporting the failure through
+	// Error. It is the default, suiting reads which must not miss keys.
+	GapFailFast GapPolicy = iota
+	// GapSkipWithReport skips the payload, reporting it as a gap through
+	// GapReporter, e.g. for exports which had rather be complete but for
+	// a few payloads to repair.
+	GapSkipWithReport
+	// GapRetryThenSkip fetches the payload again according to
+	// IteratorOptions.GapRetries before skipping it like
+	// GapSkipWithReport.
+	GapRetryThenSkip
+)
+
+// GapInfo describes a payload skipped by an iterator.
+type GapInfo struct {
+	Version uint64
+	TxId    string
+	// The keys of the payload sort after the key prefix AfterPrefix,
+	// unless it is the first payload iterated over, up to the key prefix
+	// ThroughPrefix included. Prefixes are IndexKeyPrefixLen bytes long at
+	// most.
+	AfterPrefix   []byte
+	ThroughPrefix []byte
+	// Attempts is the number of times the payload was loaded.
+	Attempts int
+	Err      error
+}
+
+// GapReporter is implemented by iterators skipping the payloads they fail
+// to load, see GapSkipWithReport.
+type GapReporter interface {
+	// Gaps returns the payloads skipped so far, in iteration order.
+	Gaps() []GapInfo
+}
+
+var _ GapReporter = (*arweaveDBIterator)(nil)
+
+// Gaps implements GapReporter.
+func (itr *arweaveDBIterator) Gaps() []GapInfo {
+	return append([]GapInfo{}, itr.gaps...)
+}
+
+// skippable returns whether a failure to load a payload may be skipped as a
+// gap. Cancellations, including by Close, stop iterators whatever the
+// policy.
+func skippable(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrDBClosed)
+}
+
+// loadPayloadOrSkip returns the payload at txIdx like loadPayload, and
+// whether it was skipped as a gap according to the gap policy.
+func (itr *arweaveDBIterator) loadPayloadOrSkip() (map[string]interface{}, []string, bool, error) {
+	data, sortedKeys, err := itr.loadPayload()
+	if err == nil || itr.gapPolicy == GapFailFast || !skippable(err) {
+		return data, sortedKeys, false, err
+	}
+	data, sortedKeys, gap, err := itr.db.retryOrSkip(itr.gapPolicy, itr.gapRetries, itr.version, itr.entries, itr.txIdx, err, itr.loadPayloadInline)
+	if gap != nil {
+		itr.gaps = append(itr.gaps, *gap)
+		return nil, nil, true, nil
+	}
+	return data, sortedKeys, false, err
+}
+
+// retryOrSkip handles the failure err to load the payload of entries[idx]
+// according to policy, reloading it with load as retries allow. It returns
+// the reloaded payload, or the gap the payload is skipped as, unless err
+// can't be skipped.
+func (db *ArweaveDB) retryOrSkip(
+	policy GapPolicy, retries FetchRetryPolicy, version uint64, entries []IndexEntry, idx int, err error,
+	load func() (map[string]interface{}, []string, error),
+) (map[string]interface{}, []string, *GapInfo, error) {
+	attempts := 1
+	if policy == GapRetryThenSkip {
+		for attempts < retries.MaxAttempts && retries.retryable(err) {
+			if delay := retries.delay(attempts, rand.Float64); delay > 0 {
+				time.Sleep(delay)
+			}
+			attempts++
+			data, sortedKeys, loadErr := load()
+			if loadErr == nil {
+				return data, sortedKeys, nil, nil
+			}
+			if err = loadErr; !skippable(err) {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	entry := entries[idx]
+	gap := &GapInfo{
+		Version:       version,
+		TxId:          string(entry.txId),
+		ThroughPrefix: []byte(strings.TrimRight(entry.keyPrefix, "\x00")),
+		Attempts:      attempts,
+		Err:           err,
+	}
+	if idx > 0 {
+		gap.AfterPrefix = []byte(strings.TrimRight(entries[idx-1].keyPrefix, "\x00"))
+	}
+	db.logf("skipping payload %s of version %d after %d attempts: %v", gap.TxId, gap.Version, attempts, err)
+	return nil, nil, gap, nil
+}
@@ -0,0 +1,131 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newGapsTestDB returns a DB over 10 payloads of 5 keys each, whose
+// payloads failing fails the given number of times.
+func newGapsTestDB(failing map[int]int, opts ...ArweaveOption) *ArweaveDB {
+	db := newDecodeTestDB(10, 5, 1, append(opts, WithLogger(&recordingLogger{}))...)
+	getter := db.txDataByIdGetter
+	failures := map[string]int{}
+	for payload, n := range failing {
+		failures[intToBase64Sha256(payload)] = n
+	}
+	var mtx sync.Mutex
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if failures[string(txId)] > 0 {
+			failures[string(txId)]--
+			return nil, &ErrGatewayStatus{what: "not found chunk data", statusCode: 502}
+		}
+		return getter(txId)
+	}
+	return db
+}
+
+func scanWithGaps(t *testing.T, db *ArweaveDB, opts IteratorOptions) ([]string, []GapInfo, error) {
+	iter, err := db.IteratorWithOptions(versionedKey(0, ""), nil, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+	keys := []string{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	return keys, iter.(GapReporter).Gaps(), iter.Error()
+}
+
+func payloadKeys(payloads ...int) []string {
+	keys := []string{}
+	for _, p := range payloads {
+		for k := 0; k < 5; k++ {
+			keys = append(keys, fmt.Sprintf("%04d/%06d", p, k))
+		}
+	}
+	return keys
+}
+
+func TestGapsFailFastByDefault(t *testing.T) {
+	keys, gaps, err := scanWithGaps(t, newGapsTestDB(map[int]int{2: 1}), IteratorOptions{})
+	require.Equal(t, payloadKeys(0, 1), keys)
+	require.Empty(t, gaps)
+	require.ErrorAs(t, err, new(*ErrGatewayStatus))
+}
+
+func TestGapsSkipWithReport(t *testing.T) {
+	for _, opts := range [][]ArweaveOption{nil, {WithFetchConcurrency(4)}, {WithDecodeWorkers(2)}} {
+		db := newGapsTestDB(map[int]int{0: 100, 2: 100, 7: 100}, opts...)
+		keys, gaps, err := scanWithGaps(t, db, IteratorOptions{Gaps: GapSkipWithReport})
+		require.Nil(t, err)
+		require.Equal(t, payloadKeys(1, 3, 4, 5, 6, 8, 9), keys)
+		require.Len(t, gaps, 3)
+		for i, payload := range []int{0, 2, 7} {
+			require.Equal(t, uint64(0), gaps[i].Version)
+			require.Equal(t, intToBase64Sha256(payload), gaps[i].TxId)
+			require.Equal(t, fmt.Sprintf("%04d/~", payload), string(gaps[i].ThroughPrefix))
+			require.Equal(t, 1, gaps[i].Attempts)
+			require.ErrorAs(t, gaps[i].Err, new(*ErrGatewayStatus))
+		}
+		require.Nil(t, gaps[0].AfterPrefix)
+		require.Equal(t, "0001/~", string(gaps[1].AfterPrefix))
+		require.Equal(t, "0006/~", string(gaps[2].AfterPrefix))
+
+		// reverse iterators report gaps in their order
+		db = newGapsTestDB(map[int]int{2: 100, 7: 100}, opts...)
+		keys, gaps, err = scanWithGaps(t, db, IteratorOptions{Gaps: GapSkipWithReport, Reverse: true})
+		require.Nil(t, err)
+		require.Len(t, keys, 40)
+		require.Equal(t, intToBase64Sha256(7), gaps[0].TxId)
+		require.Equal(t, intToBase64Sha256(2), gaps[1].TxId)
+	}
+}
+
+func TestGapsRetryThenSkip(t *testing.T) {
+	retries := FetchRetryPolicy{MaxAttempts: 3}
+	db := newGapsTestDB(map[int]int{2: 2, 7: 100})
+	keys, gaps, err := scanWithGaps(t, db, IteratorOptions{Gaps: GapRetryThenSkip, GapRetries: retries})
+	require.Nil(t, err)
+	// payload 2 made it on its third attempt
+	require.Equal(t, payloadKeys(0, 1, 2, 3, 4, 5, 6, 8, 9), keys)
+	require.Len(t, gaps, 1)
+	require.Equal(t, intToBase64Sha256(7), gaps[0].TxId)
+	require.Equal(t, 3, gaps[0].Attempts)
+
+	// permanent failures aren't retried
+	db = newGapsTestDB(nil)
+	getter := db.txDataByIdGetter
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		if string(txId) == intToBase64Sha256(4) {
+			return nil, &ErrGatewayStatus{what: "not found tx offset", statusCode: 404}
+		}
+		return getter(txId)
+	}
+	_, gaps, err = scanWithGaps(t, db, IteratorOptions{Gaps: GapRetryThenSkip, GapRetries: retries})
+	require.Nil(t, err)
+	require.Len(t, gaps, 1)
+	require.Equal(t, 1, gaps[0].Attempts)
+}
+
+func TestGapsDontSkipCancellations(t *testing.T) {
+	db := newGapsTestDB(nil)
+	getter := db.txDataByIdGetter
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		if string(txId) == intToBase64Sha256(3) {
+			return nil, context.Canceled
+		}
+		return getter(txId)
+	}
+	keys, gaps, err := scanWithGaps(t, db, IteratorOptions{Gaps: GapSkipWithReport})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, payloadKeys(0, 1, 2), keys)
+	require.Empty(t, gaps)
+}
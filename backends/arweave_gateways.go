@@ -0,0 +1,430 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+type GatewayPolicy int
+
+const (
+	// GatewayPriority tries gateways in the order they were given.
+	GatewayPriority GatewayPolicy = iota
+	// GatewayRoundRobin starts with the next gateway on every request.
+	GatewayRoundRobin
+	// GatewayLowestScore tries gateways by increasing score, i.e. favors
+	// those which have lately been fast and reliable.
+	GatewayLowestScore
+)
+
+const (
+	defaultGatewaySmoothing        = 0.2
+	defaultGatewayFailurePenalty   = time.Second
+	defaultGatewayScoreHalfLife    = time.Minute
+	defaultGatewayCooldownFailures = 3
+	defaultGatewayCooldown         = 30 * time.Second
+)
+
+// Gateway fetches transaction data from an Arweave gateway. Client
+// implements it.
+type Gateway interface {
+	URL() string
+	DownloadChunkData(id string) ([]byte, error)
+}
+
+// ContextGateway is implemented by gateways sending requests with a
+// context, such as those carrying trace IDs.
+type ContextGateway interface {
+	Gateway
+	DownloadChunkDataContext(ctx context.Context, id string) ([]byte, error)
+}
+
+// VersionGateway is implemented by gateways able to resolve the index
+// transaction ID of versions. ArweaveDBs fetching through a GatewayPool with
+// such gateways resolve versions through them too, failing over the same
+// way.
+type VersionGateway interface {
+	Gateway
+	ResolveVersionContext(ctx context.Context, version []byte) ([]byte, error)
+}
+
+type GatewayPoolOption func(*GatewayPool)
+
+// WithGatewayPolicy sets the order in which gateways are tried. Defaults to
+// GatewayPriority.
+func WithGatewayPolicy(policy GatewayPolicy) GatewayPoolOption {
+	return func(p *GatewayPool) {
+		p.policy = policy
+	}
+}
+
+// WithGatewaySmoothing sets the weight, between 0 and 1, of the latest
+// request in the moving averages of latencies and error rates. Defaults
+// to 0.2.
+func WithGatewaySmoothing(alpha float64) GatewayPoolOption {
+	return func(p *GatewayPool) {
+		p.alpha = alpha
+	}
+}
+
+// WithGatewayFailurePenalty sets the latency a gateway failing every
+// request is scored as slower by. Defaults to 1s.
+func WithGatewayFailurePenalty(penalty time.Duration) GatewayPoolOption {
+	return func(p *GatewayPool) {
+		p.failurePenalty = penalty
+	}
+}
+
+// WithGatewayScoreHalfLife sets how long it takes for the score of a
+// gateway receiving no request to halve, so that gateways which used to be
+// slow eventually get tried again. Defaults to 1 minute.
+func WithGatewayScoreHalfLife(halfLife time.Duration) GatewayPoolOption {
+	return func(p *GatewayPool) {
+		p.halfLife = halfLife
+	}
+}
+
+// WithGatewayCooldown deprioritizes gateways failing failures requests in a
+// row for cooldown: they are tried after every other gateway, whatever the
+// policy, until the cooldown ends or they serve a request. 0 failures
+// disables cooldowns. Defaults to 3 failures and 30s.
+func WithGatewayCooldown(failures int, cooldown time.Duration) GatewayPoolOption {
+	return func(p *GatewayPool) {
+		p.cooldownFailures = failures
+		p.cooldown = cooldown
+	}
+}
+
+// GatewayPool spreads fetches over several gateways, falling back to the
+// next gateway in policy order when one fails. Every request updates
+// exponentially weighted moving averages of the latency and error rate of
+// its gateway, from which gateways are scored.
+//
+// Gateways reporting a transaction as missing may be lagging behind, so
+// absence is only reported once confirmed by a second gateway, or if there
+// is no other gateway to ask.
+type GatewayPool struct {
+	policy           GatewayPolicy
+	alpha            float64
+	failurePenalty   time.Duration
+	halfLife         time.Duration
+	cooldownFailures int
+	cooldown         time.Duration
+	now              func() time.Time
+
+	mtx      sync.Mutex
+	gateways []*gatewayState
+	next     int
+}
+
+type gatewayState struct {
+	gateway Gateway
+	// moving averages, in seconds and in failures per request
+	latency   float64
+	errorRate float64
+	requests  uint64
+	updated   time.Time
+	// failed requests since the last successful one
+	failures      int
+	cooldownUntil time.Time
+}
+
+// GatewayScore is the state of a gateway as seen by a GatewayPool.
+type GatewayScore struct {
+	URL       string
+	Latency   time.Duration
+	ErrorRate float64
+	Requests  uint64
+	// Score is Latency plus ErrorRate times the failure penalty, decayed
+	// since the last request; lower is better and 0 for unused gateways.
+	Score time.Duration
+	// CooldownUntil is when the gateway stops being deprioritized, zero if
+	// it isn't.
+	CooldownUntil time.Time
+}
+
+func NewGatewayPool(gateways []Gateway, opts ...GatewayPoolOption) *GatewayPool {
+	p := &GatewayPool{
+		alpha:          defaultGatewaySmoothing,
+		failurePenalty: defaultGatewayFailurePenalty,
+		halfLife:       defaultGatewayScoreHalfLife,
+		now:            time.Now,
+
+		cooldownFailures: defaultGatewayCooldownFailures,
+		cooldown:         defaultGatewayCooldown,
+	}
+	for _, gateway := range gateways {
+		p.gateways = append(p.gateways, &gatewayState{gateway: gateway})
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithGatewayPool makes ArweaveDB fetch transaction data through pool, and
+// resolve versions through it too if some of its gateways are
+// VersionGateways.
+func WithGatewayPool(pool *GatewayPool) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if pool == nil {
+			db.invalidOption(errors.New("WithGatewayPool is given a nil pool"))
+			return
+		}
+		if db.build != nil && db.build.middlewares {
+			db.invalidOption(errors.New("WithGatewayPool drops the getter middlewares applied by the options before it, which must follow it"))
+		}
+		db.setTxDataSource("WithGatewayPool")
+		db.gatewayPool = pool
+		db.client = nil
+		db.txDataSource = func(ctx context.Context, txId []byte) ([]byte, error) {
+			data, _, err := pool.fetchContext(ctx, txId)
+			return data, err
+		}
+		db.txDataMiddleware = nil
+		if db.rateLimiter != nil {
+			db.txDataMiddleware = db.rateLimiter.middleware()
+		}
+		db.txDataByIdGetter = db.bindGetter(db.context(), db.txDataSource, db.txDataMiddleware, nil)
+		if pool.resolvesVersions() {
+			db.setVersionSource("WithGatewayPool")
+			db.versionSource = func(ctx context.Context, version []byte) ([]byte, error) {
+				txId, _, err := pool.resolveVersionContext(ctx, version)
+				return txId, err
+			}
+			db.versionMiddleware = nil
+			if db.rateLimiter != nil {
+				db.versionMiddleware = db.rateLimiter.middleware()
+			}
+			db.versionTxIdGetter = db.bindGetter(db.context(), db.versionSource, db.versionMiddleware, nil)
+		}
+	}
+}
+
+// NewArweaveDBWithGateways is NewArweaveDB fetching transaction data from
+// HTTP clients of the given gateways, through a GatewayPool trying them in
+// the given order.
+func NewArweaveDBWithGateways(indexDBFullPath string, gateways []GatewayConfig, opts ...ArweaveOption) (*ArweaveDB, error) {
+	if len(gateways) == 0 {
+		return nil, errNoGateway
+	}
+	clients := make([]Gateway, 0, len(gateways))
+	for _, cfg := range gateways {
+		client, err := NewGatewayClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("gateway %s: %w", cfg.URL, err)
+		}
+		clients = append(clients, client)
+	}
+	return NewArweaveDB(indexDBFullPath, gateways[0].URL, append([]ArweaveOption{WithGatewayPool(NewGatewayPool(clients))}, opts...)...)
+}
+
+var errNoGateway = errors.New("no gateway configured")
+
+// Get fetches the data of the transaction txId from the first gateway in
+// policy order able to serve it, returning the last error if none is.
+func (p *GatewayPool) Get(txId []byte) ([]byte, error) {
+	data, _, err := p.fetch(txId)
+	return data, err
+}
+
+// fetch is like Get, also returning the URL of the gateway which served the
+// data.
+func (p *GatewayPool) fetch(txId []byte) ([]byte, string, error) {
+	return p.fetchContext(context.Background(), txId)
+}
+
+// fetchContext is like fetch, passing ctx to the gateways which are
+// ContextGateways.
+func (p *GatewayPool) fetchContext(ctx context.Context, txId []byte) ([]byte, string, error) {
+	return p.failover(ctx, p.Order(), func(gateway Gateway) ([]byte, error) {
+		if contextGateway, ok := gateway.(ContextGateway); ok {
+			return contextGateway.DownloadChunkDataContext(ctx, string(txId))
+		}
+		return gateway.DownloadChunkData(string(txId))
+	})
+}
+
+// ResolveVersion resolves the index transaction ID of version through the
+// VersionGateways of the pool, like Get.
+func (p *GatewayPool) ResolveVersion(version []byte) ([]byte, error) {
+	txId, _, err := p.resolveVersionContext(context.Background(), version)
+	return txId, err
+}
+
+func (p *GatewayPool) resolveVersionContext(ctx context.Context, version []byte) ([]byte, string, error) {
+	gateways := []Gateway{}
+	for _, gateway := range p.Order() {
+		if _, ok := gateway.(VersionGateway); ok {
+			gateways = append(gateways, gateway)
+		}
+	}
+	return p.failover(ctx, gateways, func(gateway Gateway) ([]byte, error) {
+		return gateway.(VersionGateway).ResolveVersionContext(ctx, version)
+	})
+}
+
+func (p *GatewayPool) resolvesVersions() bool {
+	for _, state := range p.gateways {
+		if _, ok := state.gateway.(VersionGateway); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// failover requests gateways in order until one serves the request, or two
+// report it as missing. Absence reported by a single gateway is returned if
+// no other one could confirm it, unless another one failed, whose error is
+// returned instead so that the request is retried. Requests are abandoned
+// once ctx is done.
+func (p *GatewayPool) failover(ctx context.Context, gateways []Gateway, request func(Gateway) ([]byte, error)) ([]byte, string, error) {
+	var missing, failed error
+	for _, gateway := range gateways {
+		start := p.now()
+		data, err := request(gateway)
+		notFound := err != nil && isGatewayNotFound(err)
+		// gateways reporting absence are up, if maybe lagging
+		p.record(gateway, p.now().Sub(start), err == nil || notFound)
+		switch {
+		case err == nil:
+			return data, gateway.URL(), nil
+		case notFound && missing != nil:
+			return nil, "", missing
+		case notFound:
+			missing = err
+		case ctx.Err() != nil:
+			return nil, "", err
+		default:
+			failed = err
+		}
+	}
+	if failed != nil {
+		return nil, "", failed
+	}
+	if missing != nil {
+		return nil, "", missing
+	}
+	return nil, "", errNoGateway
+}
+
+// isGatewayNotFound returns whether err reports the requested transaction
+// or version as missing.
+func isGatewayNotFound(err error) bool {
+	status := &ErrGatewayStatus{}
+	return isVersionNotFound(err) || (errors.As(err, &status) && status.StatusCode() == 404)
+}
+
+// Order returns the gateways in the order the next request would try them.
+func (p *GatewayPool) Order() []Gateway {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	states := append([]*gatewayState{}, p.gateways...)
+	switch p.policy {
+	case GatewayRoundRobin:
+		if len(states) > 0 {
+			first := p.next % len(states)
+			states = append(states[first:], states[:first]...)
+			p.next++
+		}
+	case GatewayLowestScore:
+		now := p.now()
+		scores := make(map[*gatewayState]float64, len(states))
+		for _, state := range states {
+			scores[state] = p.score(state, now)
+		}
+		sort.SliceStable(states, func(i, j int) bool { return scores[states[i]] < scores[states[j]] })
+	}
+	// gateways cooling down are only tried last
+	now := p.now()
+	sort.SliceStable(states, func(i, j int) bool {
+		return !now.Before(states[i].cooldownUntil) && now.Before(states[j].cooldownUntil)
+	})
+	gateways := make([]Gateway, len(states))
+	for i, state := range states {
+		gateways[i] = state.gateway
+	}
+	return gateways
+}
+
+func (p *GatewayPool) record(gateway Gateway, latency time.Duration, ok bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, state := range p.gateways {
+		if state.gateway != gateway {
+			continue
+		}
+		failure := 1.0
+		if ok {
+			failure = 0
+		}
+		now := p.now()
+		if state.requests == 0 {
+			state.latency, state.errorRate = latency.Seconds(), failure
+		} else {
+			// decay first, so that a gateway probed after a long pause isn't
+			// judged on stale requests
+			decay := p.decay(state, now)
+			state.latency = p.alpha*latency.Seconds() + (1-p.alpha)*state.latency*decay
+			state.errorRate = p.alpha*failure + (1-p.alpha)*state.errorRate*decay
+		}
+		state.requests++
+		state.updated = now
+		if ok {
+			state.failures = 0
+			state.cooldownUntil = time.Time{}
+		} else if state.failures++; p.cooldownFailures > 0 && state.failures >= p.cooldownFailures {
+			state.cooldownUntil = now.Add(p.cooldown)
+		}
+		return
+	}
+}
+
+func (p *GatewayPool) decay(state *gatewayState, now time.Time) float64 {
+	if p.halfLife <= 0 || state.requests == 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(now.Sub(state.updated))/float64(p.halfLife))
+}
+
+func (p *GatewayPool) score(state *gatewayState, now time.Time) float64 {
+	return (state.latency + state.errorRate*p.failurePenalty.Seconds()) * p.decay(state, now)
+}
+
+// Scores returns the current state of every gateway, in configuration
+// order.
+func (p *GatewayPool) Scores() []GatewayScore {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	now := p.now()
+	scores := make([]GatewayScore, len(p.gateways))
+	for i, state := range p.gateways {
+		scores[i] = GatewayScore{
+			URL:       state.gateway.URL(),
+			Latency:   time.Duration(state.latency * float64(time.Second)),
+			ErrorRate: state.errorRate,
+			Requests:  state.requests,
+			Score:     time.Duration(p.score(state, now) * float64(time.Second)),
+		}
+		if now.Before(state.cooldownUntil) {
+			scores[i].CooldownUntil = state.cooldownUntil
+		}
+	}
+	return scores
+}
+
+func (p *GatewayPool) stats(stats map[string]string) {
+	for _, score := range p.Scores() {
+		stats["gateway_score."+score.URL] = score.Score.String()
+		stats["gateway_latency."+score.URL] = score.Latency.String()
+		stats["gateway_error_rate."+score.URL] = fmt.Sprintf("%.3f", score.ErrorRate)
+		if !score.CooldownUntil.IsZero() {
+			stats["gateway_cooldown_until."+score.URL] = score.CooldownUntil.UTC().Format(time.RFC3339)
+		}
+	}
+}
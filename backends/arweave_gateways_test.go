@@ -0,0 +1,333 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubGateway advances clock by its scripted latency on every request.
+type stubGateway struct {
+	url      string
+	clock    *fakeClock
+	latency  time.Duration
+	fail     bool
+	requests int
+}
+
+func (g *stubGateway) URL() string {
+	return g.url
+}
+
+func (g *stubGateway) DownloadChunkData(id string) ([]byte, error) {
+	g.requests++
+	g.clock.Advance(g.latency)
+	if g.fail {
+		return nil, errors.New("gateway unavailable")
+	}
+	return []byte(g.url + "/" + id), nil
+}
+
+func newStubGateways(clock *fakeClock, latencies ...time.Duration) ([]*stubGateway, []Gateway) {
+	stubs, gateways := []*stubGateway{}, []Gateway{}
+	for i, latency := range latencies {
+		stub := &stubGateway{url: string(rune('a' + i)), clock: clock, latency: latency}
+		stubs = append(stubs, stub)
+		gateways = append(gateways, stub)
+	}
+	return stubs, gateways
+}
+
+func newTestGatewayPool(clock *fakeClock, gateways []Gateway, opts ...GatewayPoolOption) *GatewayPool {
+	pool := NewGatewayPool(gateways, opts...)
+	pool.now = clock.Now
+	return pool
+}
+
+func TestGatewayPriority(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	stubs, gateways := newStubGateways(clock, 100*time.Millisecond, 10*time.Millisecond)
+	pool := newTestGatewayPool(clock, gateways)
+	for i := 0; i < 10; i++ {
+		data, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+		require.Equal(t, "a/tx", string(data))
+	}
+	require.Equal(t, 0, stubs[1].requests)
+
+	// falls back on failures
+	stubs[0].fail = true
+	data, err := pool.Get([]byte("tx"))
+	require.Nil(t, err)
+	require.Equal(t, "b/tx", string(data))
+
+	stubs[1].fail = true
+	_, err = pool.Get([]byte("tx"))
+	require.NotNil(t, err)
+}
+
+func TestGatewayRoundRobin(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	stubs, gateways := newStubGateways(clock, 0, 0, 0)
+	pool := newTestGatewayPool(clock, gateways, WithGatewayPolicy(GatewayRoundRobin))
+	for i := 0; i < 9; i++ {
+		_, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+	}
+	for _, stub := range stubs {
+		require.Equal(t, 3, stub.requests)
+	}
+}
+
+func TestGatewayLowestScoreShiftsToFaster(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	stubs, gateways := newStubGateways(clock, 50*time.Millisecond, 200*time.Millisecond)
+	pool := newTestGatewayPool(clock, gateways, WithGatewayPolicy(GatewayLowestScore))
+	// unused gateways are probed first
+	for i := 0; i < 2; i++ {
+		_, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, 1, stubs[0].requests)
+	require.Equal(t, 1, stubs[1].requests)
+
+	// the first gateway slows down; traffic shifts within a few requests
+	stubs[0].latency = time.Second
+	shiftedAfter := -1
+	for i := 0; i < 20; i++ {
+		before := stubs[1].requests
+		_, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+		if stubs[1].requests > before && shiftedAfter < 0 {
+			shiftedAfter = i
+		}
+	}
+	require.True(t, shiftedAfter >= 0 && shiftedAfter <= 5, "shifted after %d requests", shiftedAfter)
+	// and stays on the faster gateway
+	requests := stubs[0].requests
+	for i := 0; i < 10; i++ {
+		_, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, requests, stubs[0].requests)
+}
+
+func TestGatewayLowestScoreErrors(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	stubs, gateways := newStubGateways(clock, 10*time.Millisecond, 100*time.Millisecond)
+	pool := newTestGatewayPool(clock, gateways, WithGatewayPolicy(GatewayLowestScore))
+	stubs[0].fail = true
+	for i := 0; i < 5; i++ {
+		data, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+		require.Equal(t, "b/tx", string(data))
+	}
+	// the failing gateway is no longer tried first
+	require.True(t, stubs[0].requests < 5)
+	scores := pool.Scores()
+	require.True(t, scores[0].ErrorRate > 0)
+	require.Equal(t, 0.0, scores[1].ErrorRate)
+	require.True(t, scores[0].Score > scores[1].Score)
+}
+
+func TestGatewayScoreDecay(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	stubs, gateways := newStubGateways(clock, time.Second, 100*time.Millisecond)
+	pool := newTestGatewayPool(clock, gateways, WithGatewayPolicy(GatewayLowestScore), WithGatewayScoreHalfLife(time.Minute))
+	for i := 0; i < 10; i++ {
+		_, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+	}
+	requests := stubs[0].requests
+	require.Equal(t, 1, requests)
+
+	// the slow gateway's score decays faster than the busy one's, until it
+	// gets probed again
+	stubs[0].latency = 10 * time.Millisecond
+	clock.Advance(10 * time.Minute)
+	for i := 0; i < 10; i++ {
+		_, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+		clock.Advance(time.Minute)
+	}
+	require.True(t, stubs[0].requests > requests)
+}
+
+func TestGatewayPoolStats(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	_, gateways := newStubGateways(clock, 100*time.Millisecond)
+	pool := newTestGatewayPool(clock, gateways)
+	db := NewArweaveDBWithGetters(nil, nil, WithGatewayPool(pool))
+	data, err := db.txDataByIdGetter([]byte("tx"))
+	require.Nil(t, err)
+	require.Equal(t, "a/tx", string(data))
+	stats := db.Stats()
+	require.Equal(t, "100ms", stats["gateway_score.a"])
+	require.Equal(t, "100ms", stats["gateway_latency.a"])
+	require.Equal(t, "0.000", stats["gateway_error_rate.a"])
+}
+
+// archiveGateway serves the transactions and versions of a mock DB, unless
+// it is down or lagging behind, in which case it reports them as missing.
+type archiveGateway struct {
+	url      string
+	db       *ArweaveDB
+	down     bool
+	lagging  bool
+	requests int
+}
+
+func (g *archiveGateway) URL() string {
+	return g.url
+}
+
+func (g *archiveGateway) serve(getter Getter, key []byte) ([]byte, error) {
+	g.requests++
+	if g.down {
+		return nil, &ErrGatewayStatus{what: "not found chunk data", statusCode: 502}
+	}
+	if g.lagging {
+		return nil, &ErrGatewayStatus{what: "not found chunk data", statusCode: 404}
+	}
+	return getter(key)
+}
+
+func (g *archiveGateway) DownloadChunkData(id string) ([]byte, error) {
+	return g.serve(g.db.txDataByIdGetter, []byte(id))
+}
+
+func (g *archiveGateway) ResolveVersionContext(ctx context.Context, version []byte) ([]byte, error) {
+	return g.serve(g.db.versionTxIdGetter, version)
+}
+
+func newArchiveGateways(n int) []*archiveGateway {
+	index := mockIndex([]string{"ab"}, []int{0})
+	txData := mockTxData([]string{"aa", "ab"}, []string{"1", "2"})
+	mockDB := NewMockArweaveDB([][]byte{index}, [][]byte{txData}, []int{0})
+	gateways := []*archiveGateway{}
+	for i := 0; i < n; i++ {
+		gateways = append(gateways, &archiveGateway{url: string(rune('a' + i)), db: mockDB})
+	}
+	return gateways
+}
+
+func newArchiveGatewayPool(gateways []*archiveGateway, opts ...GatewayPoolOption) *GatewayPool {
+	pool := []Gateway{}
+	for _, gateway := range gateways {
+		pool = append(pool, gateway)
+	}
+	return NewGatewayPool(pool, opts...)
+}
+
+func TestGatewayFailover(t *testing.T) {
+	gateways := newArchiveGateways(3)
+	db := NewArweaveDBWithGetters(nil, nil, WithGatewayPool(newArchiveGatewayPool(gateways, WithGatewayCooldown(0, 0))))
+	get := func() (string, error) {
+		value, err := db.Get(versionedKey(0, "ab"))
+		return string(value), err
+	}
+
+	// both version lookups and tx data fetches fail over
+	gateways[0].down = true
+	value, err := get()
+	require.Nil(t, err)
+	require.Equal(t, "2", value)
+	require.Equal(t, 3, gateways[0].requests)
+	require.Equal(t, 3, gateways[1].requests)
+
+	// missing transactions are confirmed by a second gateway
+	gateways[0].down, gateways[0].lagging = false, true
+	value, err = get()
+	require.Nil(t, err)
+	require.Equal(t, "2", value)
+	require.Equal(t, 6, gateways[0].requests)
+	require.Equal(t, 6, gateways[1].requests)
+
+	gateways[1].lagging = true
+	_, err = get()
+	status := &ErrGatewayStatus{}
+	require.ErrorAs(t, err, &status)
+	require.Equal(t, 404, status.StatusCode())
+	require.Equal(t, 0, gateways[2].requests)
+
+	// unconfirmed absence is reported as the failure preventing its
+	// confirmation
+	gateways[1].lagging, gateways[1].down = false, true
+	gateways[2].down = true
+	_, err = get()
+	require.ErrorAs(t, err, &status)
+	require.Equal(t, 502, status.StatusCode())
+}
+
+func TestGatewayFailoverSingleGateway(t *testing.T) {
+	gateways := newArchiveGateways(1)
+	pool := newArchiveGatewayPool(gateways)
+	_, err := pool.Get([]byte("missing"))
+	require.ErrorAs(t, err, new(*ErrKeyNotFound))
+	_, err = pool.ResolveVersion(versionedKey(7, "")[:8])
+	require.ErrorAs(t, err, new(*ErrKeyNotFound))
+	require.Equal(t, 2, gateways[0].requests)
+
+	_, err = NewGatewayPool(nil).Get([]byte("tx"))
+	require.Equal(t, errNoGateway, err)
+}
+
+func TestGatewayCooldown(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	stubs, gateways := newStubGateways(clock, 10*time.Millisecond, 10*time.Millisecond)
+	pool := newTestGatewayPool(clock, gateways, WithGatewayCooldown(2, time.Minute))
+	stubs[0].fail = true
+	for i := 0; i < 5; i++ {
+		data, err := pool.Get([]byte("tx"))
+		require.Nil(t, err)
+		require.Equal(t, "b/tx", string(data))
+	}
+	// the failing gateway is no longer tried once cooling down
+	require.Equal(t, 2, stubs[0].requests)
+	// since the second failure, 30ms in
+	cooldownUntil := time.Unix(60, int64(30*time.Millisecond))
+	require.Equal(t, cooldownUntil, pool.Scores()[0].CooldownUntil)
+	require.Equal(t, cooldownUntil.UTC().Format(time.RFC3339), NewArweaveDBWithGetters(nil, nil, WithGatewayPool(pool)).Stats()["gateway_cooldown_until.a"])
+
+	// cooling down gateways are still tried as a last resort
+	stubs[1].fail = true
+	_, err := pool.Get([]byte("tx"))
+	require.NotNil(t, err)
+	require.Equal(t, 3, stubs[0].requests)
+
+	// and first again once the cooldown ends
+	stubs[0].fail, stubs[1].fail = false, false
+	clock.Advance(time.Minute)
+	data, err := pool.Get([]byte("tx"))
+	require.Nil(t, err)
+	require.Equal(t, "a/tx", string(data))
+	require.True(t, pool.Scores()[0].CooldownUntil.IsZero())
+}
+
+func TestNewArweaveDBWithGateways(t *testing.T) {
+	authorize := func(r *http.Request) bool { return r.Header.Get("X-Api-Key") == "secret" }
+	down, downRequests := newGatewayServer(authorize, 1000)
+	defer down.Close()
+	up, upRequests := newGatewayServer(authorize, 0)
+	defer up.Close()
+	db, err := NewArweaveDBWithGateways(filepath.Join(t.TempDir(), "index"),
+		[]GatewayConfig{{URL: down.URL}, {URL: up.URL}},
+		WithRequestDecorator(StaticHeaders(map[string]string{"X-Api-Key": "secret"})),
+	)
+	require.Nil(t, err)
+	defer db.Close()
+	data, err := db.txDataByIdGetter([]byte("tx"))
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+	require.Equal(t, int32(1), *downRequests)
+	// the offset and the chunk
+	require.Equal(t, int32(2), *upRequests)
+
+	_, err = NewArweaveDBWithGateways(filepath.Join(t.TempDir(), "index"), nil)
+	require.Equal(t, errNoGateway, err)
+}
@@ -0,0 +1,214 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultGraphQLAppNameTag = "App-Name"
+	DefaultGraphQLHeightTag  = "Block-Height"
+	defaultGraphQLPageSize   = 100
+)
+
+// graphQLIndexQuery lists the transactions tagged with the app name and
+// height, most recent first.
+const graphQLIndexQuery = `query($tags: [TagFilter!], $owners: [String!], $first: Int, $after: String) {
+  transactions(tags: $tags, owners: $owners, first: $first, after: $after, sort: HEIGHT_DESC) {
+    pageInfo { hasNextPage }
+    edges { cursor node { id block { height } } }
+  }
+}`
+
+// GraphQLVersionResolverConfig configures a GraphQLVersionResolver.
+type GraphQLVersionResolverConfig struct {
+	// Endpoint is the URL of the GraphQL endpoint of a gateway, e.g.
+	// https://arweave.net/graphql.
+	Endpoint string
+	// AppName is the value of the app name tag of the index transactions.
+	AppName string
+	// AppNameTag and HeightTag name the tags of the index transactions
+	// holding the app name and the height they index, defaulting to
+	// DefaultGraphQLAppNameTag and DefaultGraphQLHeightTag.
+	AppNameTag string
+	HeightTag  string
+	// Owners, if set, only lets through index transactions published by
+	// these addresses.
+	Owners []string
+	// PageSize is the number of transactions queried per page, defaulting
+	// to 100.
+	PageSize int
+	// Codec decodes the versions the resolver is queried with, defaulting
+	// to BigEndian64VersionCodec. It must be the codec of the ArweaveDB.
+	Codec VersionCodec
+	// Client sends the queries, defaulting to http.DefaultClient.
+	Client *http.Client
+	// Decorate, if set, is invoked on every query sent to the endpoint.
+	Decorate RequestDecorator
+}
+
+// GraphQLVersionResolver resolves the index transaction IDs of versions by
+// querying a GraphQL gateway for the transactions tagged with the app name
+// and the height of the version, rather than a mapping maintained apart.
+// Its Getter and Resolve methods are version getters, for
+// NewArweaveDBWithGetters and NewArweaveDBWithContextGetters respectively.
+type GraphQLVersionResolver struct {
+	cfg GraphQLVersionResolverConfig
+}
+
+// NewGraphQLVersionResolver returns a resolver configured with cfg.
+func NewGraphQLVersionResolver(cfg GraphQLVersionResolverConfig) (*GraphQLVersionResolver, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("GraphQL endpoint is not set")
+	}
+	if cfg.AppName == "" {
+		return nil, errors.New("app name is not set")
+	}
+	if cfg.AppNameTag == "" {
+		cfg.AppNameTag = DefaultGraphQLAppNameTag
+	}
+	if cfg.HeightTag == "" {
+		cfg.HeightTag = DefaultGraphQLHeightTag
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = defaultGraphQLPageSize
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = BigEndian64VersionCodec
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &GraphQLVersionResolver{cfg: cfg}, nil
+}
+
+// Getter returns the resolver as a version getter fetching with the
+// background context.
+func (r *GraphQLVersionResolver) Getter() Getter {
+	return ContextGetter(r.Resolve).Getter()
+}
+
+// Resolve is a ContextGetter returning the index transaction ID of the
+// encoded version. Of the transactions matching the tags and owners, the
+// one mined in the most recent block wins, across all the pages of the
+// results, as the index of a version may be published again. Pending
+// transactions are ignored, and versions without mined ones are reported
+// with an ErrKeyNotFound.
+func (r *GraphQLVersionResolver) Resolve(ctx context.Context, versionBz []byte) ([]byte, error) {
+	version, n, err := r.cfg.Codec.Decode(versionBz)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(versionBz) {
+		return nil, fmt.Errorf("malformed version %x", versionBz)
+	}
+	variables := map[string]interface{}{
+		"tags": []map[string]interface{}{
+			{"name": r.cfg.AppNameTag, "values": []string{r.cfg.AppName}},
+			{"name": r.cfg.HeightTag, "values": []string{strconv.FormatUint(version, 10)}},
+		},
+		"first": r.cfg.PageSize,
+	}
+	if len(r.cfg.Owners) > 0 {
+		variables["owners"] = r.cfg.Owners
+	}
+	var (
+		txId   string
+		height int64 = -1
+	)
+	for {
+		page, err := r.query(ctx, variables)
+		if err != nil {
+			return nil, err
+		}
+		cursor := ""
+		for _, edge := range page.Edges {
+			cursor = edge.Cursor
+			if edge.Node.Block != nil && edge.Node.Block.Height > height {
+				txId, height = edge.Node.ID, edge.Node.Block.Height
+			}
+		}
+		if !page.PageInfo.HasNextPage || cursor == "" {
+			break
+		}
+		variables["after"] = cursor
+	}
+	if txId == "" {
+		return nil, NewErrKeyNotFound(versionBz)
+	}
+	return []byte(txId), nil
+}
+
+type graphQLTransactions struct {
+	PageInfo struct {
+		HasNextPage bool `json:"hasNextPage"`
+	} `json:"pageInfo"`
+	Edges []struct {
+		Cursor string `json:"cursor"`
+		Node   struct {
+			ID    string `json:"id"`
+			Block *struct {
+				Height int64 `json:"height"`
+			} `json:"block"`
+		} `json:"node"`
+	} `json:"edges"`
+}
+
+// query sends the index query with variables, returning a page of results.
+func (r *GraphQLVersionResolver) query(ctx context.Context, variables map[string]interface{}) (*graphQLTransactions, error) {
+	body, err := json.Marshal(map[string]interface{}{"query": graphQLIndexQuery, "variables": variables})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.cfg.Decorate != nil {
+		if err := r.cfg.Decorate(req); err != nil {
+			return nil, &ErrRequestDecoration{url: r.cfg.Endpoint, traceID: TraceIDFromContext(ctx), err: err}
+		}
+	}
+	resp, err := r.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, &ErrGatewayStatus{what: "GraphQL query failed", statusCode: resp.StatusCode}
+	}
+	res := struct {
+		Data struct {
+			Transactions *graphQLTransactions `json:"transactions"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}{}
+	if err := json.Unmarshal(respBody, &res); err != nil {
+		return nil, fmt.Errorf("malformed GraphQL response: %w", err)
+	}
+	if len(res.Errors) > 0 {
+		messages := make([]string, len(res.Errors))
+		for i, e := range res.Errors {
+			messages[i] = e.Message
+		}
+		return nil, fmt.Errorf("GraphQL query failed: %s", strings.Join(messages, "; "))
+	}
+	if res.Data.Transactions == nil {
+		return nil, errors.New("malformed GraphQL response: no transactions")
+	}
+	return res.Data.Transactions, nil
+}
@@ -0,0 +1,147 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type graphQLRequest struct {
+	Query     string `json:"query"`
+	Variables struct {
+		Tags []struct {
+			Name   string   `json:"name"`
+			Values []string `json:"values"`
+		} `json:"tags"`
+		Owners []string `json:"owners"`
+		First  int      `json:"first"`
+		After  string   `json:"after"`
+	} `json:"variables"`
+}
+
+// graphQLEdge returns an edge of transaction id, pending if height is
+// negative.
+func graphQLEdge(id string, height int) string {
+	block := "null"
+	if height >= 0 {
+		block = fmt.Sprintf(`{"height": %d}`, height)
+	}
+	return fmt.Sprintf(`{"cursor": "after-%s", "node": {"id": %q, "block": %s}}`, id, id, block)
+}
+
+// newGraphQLServer serves the pages of transactions by cursor, the first
+// one under the empty cursor, recording the requests.
+func newGraphQLServer(t *testing.T, pages map[string]string, requests *[]graphQLRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		req := graphQLRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		*requests = append(*requests, req)
+		page, ok := pages[req.Variables.After]
+		if !ok {
+			page = `{"pageInfo": {"hasNextPage": false}, "edges": []}`
+		}
+		fmt.Fprintf(w, `{"data": {"transactions": %s}}`, page)
+	}))
+}
+
+func TestGraphQLVersionResolver(t *testing.T) {
+	requests := []graphQLRequest{}
+	server := newGraphQLServer(t, map[string]string{
+		"": fmt.Sprintf(`{"pageInfo": {"hasNextPage": true}, "edges": [%s, %s]}`,
+			graphQLEdge("pending", -1), graphQLEdge("republished", 12)),
+		"after-republished": fmt.Sprintf(`{"pageInfo": {"hasNextPage": false}, "edges": [%s]}`,
+			graphQLEdge("first", 10)),
+	}, &requests)
+	defer server.Close()
+	resolver, err := NewGraphQLVersionResolver(GraphQLVersionResolverConfig{
+		Endpoint: server.URL,
+		AppName:  "sei-archive",
+		Owners:   []string{"publisher"},
+		PageSize: 2,
+	})
+	require.NoError(t, err)
+
+	txId, err := resolver.Getter()(versionedKey(7, ""))
+	require.NoError(t, err)
+	require.Equal(t, "republished", string(txId))
+	require.Len(t, requests, 2)
+	req := requests[0]
+	require.Len(t, req.Variables.Tags, 2)
+	require.Equal(t, DefaultGraphQLAppNameTag, req.Variables.Tags[0].Name)
+	require.Equal(t, []string{"sei-archive"}, req.Variables.Tags[0].Values)
+	require.Equal(t, DefaultGraphQLHeightTag, req.Variables.Tags[1].Name)
+	require.Equal(t, []string{"7"}, req.Variables.Tags[1].Values)
+	require.Equal(t, []string{"publisher"}, req.Variables.Owners)
+	require.Equal(t, 2, req.Variables.First)
+	require.Equal(t, "after-republished", requests[1].Variables.After)
+}
+
+func TestGraphQLVersionResolverNotFound(t *testing.T) {
+	requests := []graphQLRequest{}
+	server := newGraphQLServer(t, map[string]string{
+		"": fmt.Sprintf(`{"pageInfo": {"hasNextPage": false}, "edges": [%s]}`, graphQLEdge("pending", -1)),
+	}, &requests)
+	defer server.Close()
+	resolver, err := NewGraphQLVersionResolver(GraphQLVersionResolverConfig{Endpoint: server.URL, AppName: "sei-archive"})
+	require.NoError(t, err)
+
+	_, err = resolver.Getter()(versionedKey(7, ""))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.False(t, IsTransientFetchError(err))
+}
+
+func TestGraphQLVersionResolverFailures(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status    int
+		body      string
+		transient bool
+	}{
+		"unavailable":    {status: 503, transient: true},
+		"query error":    {status: 200, body: `{"errors": [{"message": "unknown argument"}]}`, transient: true},
+		"malformed body": {status: 200, body: `{"data": {}}`, transient: true},
+		"bad request":    {status: 400},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			}))
+			defer server.Close()
+			resolver, err := NewGraphQLVersionResolver(GraphQLVersionResolverConfig{Endpoint: server.URL, AppName: "sei-archive"})
+			require.NoError(t, err)
+
+			_, err = resolver.Getter()(versionedKey(7, ""))
+			require.Error(t, err)
+			require.NotErrorIs(t, err, ErrNotFound)
+			require.Equal(t, tc.transient, IsTransientFetchError(err))
+		})
+	}
+}
+
+func TestGraphQLVersionResolverGetter(t *testing.T) {
+	mockDB := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	requests := []graphQLRequest{}
+	server := newGraphQLServer(t, map[string]string{
+		"": fmt.Sprintf(`{"pageInfo": {"hasNextPage": false}, "edges": [%s]}`, graphQLEdge(intToBase64Sha256(1), 3)),
+	}, &requests)
+	defer server.Close()
+	resolver, err := NewGraphQLVersionResolver(GraphQLVersionResolverConfig{Endpoint: server.URL, AppName: "sei-archive"})
+	require.NoError(t, err)
+
+	db := NewArweaveDBWithContextGetters(IgnoringContext(mockDB.txDataByIdGetter), resolver.Resolve)
+	value, err := db.Get(versionedKey(0, "a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+}
+
+func TestNewGraphQLVersionResolverValidation(t *testing.T) {
+	_, err := NewGraphQLVersionResolver(GraphQLVersionResolverConfig{AppName: "sei-archive"})
+	require.Error(t, err)
+	_, err = NewGraphQLVersionResolver(GraphQLVersionResolverConfig{Endpoint: "http://localhost/graphql"})
+	require.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package backends
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Indices may start with a header made of indexHeaderMagic followed by a
+// one byte format version, whose upper bits hold the TxCompression of the
+// entries following the header. Headerless indices are legacy ones, made of
+// IndexEntryLen sized entries without metadata.
+const (
+	indexHeaderMagic      = "\xffARWIDX"
+	IndexHeaderLen        = len(indexHeaderMagic) + 1
+	indexCompressionShift = 4
+	indexFormatMask       = 1<<indexCompressionShift - 1
+
+	LegacyIndexFormat uint8 = 0
+	// entries are followed by payload byte size (8 bytes), key count
+	// (4 bytes) and codec id (1 byte), all big endian, the upper bits of
+	// the codec id holding the TxCompression of the payload
+	IndexFormatV1 uint8 = 1
+	// entries are followed by the same info as in IndexFormatV1, then the tx
+	// ID of the key filter of the payload, all zeroes if it has none
+	IndexFormatV2 uint8 = 2
+
+	IndexEntryInfoLen          = 8 + 4 + 1
+	IndexEntryWithInfoLen      = IndexEntryLen + IndexEntryInfoLen
+	IndexEntryWithKeyFilterLen = IndexEntryWithInfoLen + Sha256Base64Len
+)
+
+type PayloadCodec uint8
+
+const (
+	CodecUnknown PayloadCodec = iota
+	CodecJSON
+	// payloads starting with BinaryPayloadTag
+	CodecBinary
+)
+
+// IndexEntryInfo describes the payload an index entry points to. Zero
+// values mean unknown, e.g. for legacy entries, except for Compression,
+// whose zero value means uncompressed.
+type IndexEntryInfo struct {
+	// size of the payload transaction, compressed if it is
+	PayloadSize uint64
+	KeyCount    uint32
+	Codec       PayloadCodec
+	Compression TxCompression
+}
+
+func parseIndexEntryInfo(bz []byte) IndexEntryInfo {
+	return IndexEntryInfo{
+		PayloadSize: binary.BigEndian.Uint64(bz[:8]),
+		KeyCount:    binary.BigEndian.Uint32(bz[8:12]),
+		Codec:       PayloadCodec(bz[12] & indexFormatMask),
+		Compression: TxCompression(bz[12] >> indexCompressionShift),
+	}
+}
+
+func encodeIndexEntryInfo(info IndexEntryInfo) []byte {
+	bz := make([]byte, IndexEntryInfoLen)
+	binary.BigEndian.PutUint64(bz[:8], info.PayloadSize)
+	binary.BigEndian.PutUint32(bz[8:12], info.KeyCount)
+	bz[12] = byte(info.Codec) | byte(info.Compression)<<indexCompressionShift
+	return bz
+}
+
+func indexFormat(index []byte) uint8 {
+	if len(index) < IndexHeaderLen || string(index[:len(indexHeaderMagic)]) != indexHeaderMagic {
+		return LegacyIndexFormat
+	}
+	return index[len(indexHeaderMagic)]
+}
+
+func validateIndexHeader(index []byte) error {
+	switch indexFormat(index) {
+	case LegacyIndexFormat:
+		if rest := len(index) % IndexEntryLen; rest != 0 {
+			return &ErrMalformedIndex{offset: len(index) - rest, reason: fmt.Sprintf("truncated to %d bytes out of %d", rest, IndexEntryLen)}
+		}
+	case IndexFormatV1:
+		if rest := (len(index) - IndexHeaderLen) % IndexEntryWithInfoLen; rest != 0 {
+			return &ErrMalformedIndex{offset: len(index) - rest, reason: fmt.Sprintf("truncated to %d bytes out of %d", rest, IndexEntryWithInfoLen)}
+		}
+	case IndexFormatV2:
+		if rest := (len(index) - IndexHeaderLen) % IndexEntryWithKeyFilterLen; rest != 0 {
+			return &ErrMalformedIndex{offset: len(index) - rest, reason: fmt.Sprintf("truncated to %d bytes out of %d", rest, IndexEntryWithKeyFilterLen)}
+		}
+	default:
+		return fmt.Errorf("unsupported index format %d", indexFormat(index))
+	}
+	return nil
+}
+
+// splitIndex returns the entries of a validated index and their length.
+func splitIndex(index []byte) ([]byte, int) {
+	switch indexFormat(index) {
+	case IndexFormatV1:
+		return index[IndexHeaderLen:], IndexEntryWithInfoLen
+	case IndexFormatV2:
+		return index[IndexHeaderLen:], IndexEntryWithKeyFilterLen
+	}
+	return index, IndexEntryLen
+}
+
+type IndexDescription struct {
+	Format  uint8
+	Entries []IndexEntryDescription
+	// Validation is the ErrMalformedIndex of the first invalid entry, if
+	// any, whether or not the DB validates indices
+	Validation error
+}
+
+type IndexEntryDescription struct {
+	KeyPrefix []byte
+	TxId      string
+	Info      IndexEntryInfo
+	// KeyFilterTxId is the tx ID of the key filter of the payload, empty
+	// if it has none.
+	KeyFilterTxId string
+}
+
+// DescribeIndex returns the entries of the index of the given version, in
+// published order.
+func (db *ArweaveDB) DescribeIndex(version uint64) (IndexDescription, error) {
+	_, index, err := db.fetchIndex(version)
+	if err != nil {
+		return IndexDescription{}, err
+	}
+	desc := IndexDescription{Format: indexFormat(index), Validation: validateIndexEntries(index)}
+	entries, entryLen := splitIndex(index)
+	for i := 0; i < len(entries); i += entryLen {
+		desc.Entries = append(desc.Entries, describeIndexEntry(NewIndexEntryFromBytes(entries[i:i+entryLen])))
+	}
+	return desc, nil
+}
+
+func describeIndexEntry(entry IndexEntry) IndexEntryDescription {
+	return IndexEntryDescription{
+		KeyPrefix:     []byte(entry.keyPrefix),
+		TxId:          string(entry.txId),
+		Info:          entry.info,
+		KeyFilterTxId: string(entry.keyFilterTxId),
+	}
+}
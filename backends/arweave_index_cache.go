@@ -0,0 +1,141 @@
+package backends
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// indexCache keeps the parsed indices of the most recently used versions,
+// so that reads of a version don't resolve, fetch and parse its index
+// again. Concurrent loads of the index of a version are made once.
+type indexCache struct {
+	maxVersions int
+
+	mtx     sync.Mutex
+	indices map[uint64]*list.Element
+	// versions, most recently used first
+	lru *list.List
+	// bumped by clear, so that loads started before aren't cached
+	generation uint64
+}
+
+type cachedIndex struct {
+	version uint64
+	// closed once the index is loaded
+	loaded  chan struct{}
+	entries []IndexEntry
+	err     error
+}
+
+// WithIndexCache makes the DB keep the parsed indices of up to maxVersions
+// versions, evicting the least recently used one. Retraction records
+// refreshed by RefreshRetractions and versions invalidated by
+// InvalidateVersion evict the indices they concern. Cached indices are
+// served without checking the version map cache for staleness.
+func WithIndexCache(maxVersions int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if maxVersions <= 0 {
+			db.invalidOption(fmt.Errorf("WithIndexCache needs a positive number of versions, got %d", maxVersions))
+		}
+		db.indexCache = &indexCache{
+			maxVersions: maxVersions,
+			indices:     map[uint64]*list.Element{},
+			lru:         list.New(),
+		}
+	}
+}
+
+// get returns the index of version, loading it with load unless it is
+// cached or being loaded.
+func (c *indexCache) get(version uint64, load func() ([]IndexEntry, error)) ([]IndexEntry, error) {
+	c.mtx.Lock()
+	if elem, ok := c.indices[version]; ok {
+		c.lru.MoveToFront(elem)
+		cached := elem.Value.(*cachedIndex)
+		c.mtx.Unlock()
+		<-cached.loaded
+		if cached.err != nil {
+			// the loader's error, others load again
+			return c.get(version, load)
+		}
+		return cached.entries, nil
+	}
+	cached := &cachedIndex{version: version, loaded: make(chan struct{})}
+	elem := c.lru.PushFront(cached)
+	c.indices[version] = elem
+	generation := c.generation
+	c.evict()
+	c.mtx.Unlock()
+
+	cached.entries, cached.err = load()
+	c.mtx.Lock()
+	if cached.err != nil || generation != c.generation {
+		c.remove(elem)
+	}
+	c.mtx.Unlock()
+	close(cached.loaded)
+	return cached.entries, cached.err
+}
+
+// len returns the number of versions whose index is cached or loading.
+func (c *indexCache) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lru.Len()
+}
+
+// evict evicts the least recently used versions above the capacity. It
+// must be called with mtx held.
+func (c *indexCache) evict() {
+	for c.lru.Len() > c.maxVersions {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes elem if it is still cached. It must be called with mtx
+// held.
+func (c *indexCache) remove(elem *list.Element) {
+	version := elem.Value.(*cachedIndex).version
+	if c.indices[version] != elem {
+		return
+	}
+	delete(c.indices, version)
+	c.lru.Remove(elem)
+}
+
+// put caches entries as the index of version, e.g. loaded from elsewhere.
+func (c *indexCache) put(version uint64, entries []IndexEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, ok := c.indices[version]; ok {
+		c.remove(elem)
+	}
+	cached := &cachedIndex{version: version, loaded: make(chan struct{}), entries: entries}
+	close(cached.loaded)
+	c.indices[version] = c.lru.PushFront(cached)
+	c.evict()
+}
+
+func (c *indexCache) invalidate(version uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, ok := c.indices[version]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *indexCache) clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.indices = map[uint64]*list.Element{}
+	c.lru.Init()
+	c.generation++
+}
+
+// ClearIndexCache drops the cached indices of all versions.
+func (db *ArweaveDB) ClearIndexCache() {
+	if db.indexCache != nil {
+		db.indexCache.clear()
+	}
+}
@@ -0,0 +1,119 @@
+package backends
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newIndexCacheTestDB returns a DB of versions versions each with a key
+// "a", whose index fetches are counted in indexFetches.
+func newIndexCacheTestDB(versions int, maxVersions int, indexFetches *int) *ArweaveDB {
+	indexList := [][]byte{}
+	for v := 0; v < versions; v++ {
+		indexList = append(indexList, mockIndex([]string{"z"}, []int{0}))
+	}
+	mockDB := NewMockArweaveDB(indexList, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	var mtx sync.Mutex
+	getter := mockDB.txDataByIdGetter
+	mockDB.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		if string(txId) != intToBase64Sha256(0) {
+			mtx.Lock()
+			*indexFetches++
+			mtx.Unlock()
+		}
+		return getter(txId)
+	}
+	WithIndexCache(maxVersions)(mockDB)
+	return mockDB
+}
+
+func TestIndexCacheConcurrentGets(t *testing.T) {
+	indexFetches := 0
+	versionLookups := 0
+	mockDB := newIndexCacheTestDB(1, 4, &indexFetches)
+	var mtx sync.Mutex
+	getter := mockDB.versionTxIdGetter
+	mockDB.versionTxIdGetter = func(version []byte) ([]byte, error) {
+		mtx.Lock()
+		versionLookups++
+		mtx.Unlock()
+		return getter(version)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := mockDB.Get(versionedKey(0, "a"))
+			require.Nil(t, err)
+			require.Equal(t, "1", string(value))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, versionLookups)
+	require.Equal(t, 1, indexFetches)
+
+	exists, err := mockDB.Has(versionedKey(0, "b"))
+	require.Nil(t, err)
+	require.False(t, exists)
+	iter, err := mockDB.ReverseIterator(versionedKey(0, ""), versionedKey(0, "z"))
+	require.Nil(t, err)
+	require.True(t, iter.Valid())
+	require.Nil(t, iter.Close())
+	require.Equal(t, 1, versionLookups)
+	require.Equal(t, 1, indexFetches)
+}
+
+func TestIndexCacheEviction(t *testing.T) {
+	indexFetches := 0
+	mockDB := newIndexCacheTestDB(3, 2, &indexFetches)
+	get := func(version uint64) {
+		_, err := mockDB.Get(versionedKey(version, "a"))
+		require.Nil(t, err)
+	}
+	get(0)
+	get(1)
+	get(0)
+	require.Equal(t, 2, indexFetches)
+	// evicts version 1, the least recently used
+	get(2)
+	get(0)
+	require.Equal(t, 3, indexFetches)
+	get(1)
+	require.Equal(t, 4, indexFetches)
+
+	mockDB.ClearIndexCache()
+	get(1)
+	require.Equal(t, 5, indexFetches)
+}
+
+func TestIndexCacheErrorsNotCached(t *testing.T) {
+	indexFetches := 0
+	mockDB := newIndexCacheTestDB(1, 2, &indexFetches)
+	_, err := mockDB.Get(versionedKey(1, "a"))
+	require.NotNil(t, err)
+	_, err = mockDB.Get(versionedKey(1, "a"))
+	require.NotNil(t, err)
+	require.Equal(t, 0, indexFetches)
+}
+
+func TestIndexCacheRetractions(t *testing.T) {
+	indexFetches := 0
+	mockDB := newIndexCacheTestDB(2, 2, &indexFetches)
+	record := `{"versions": {}}`
+	WithRetractions(func() ([]byte, string, error) {
+		return []byte(record), "owner", nil
+	}, func(string) error { return nil })(mockDB)
+	require.Nil(t, mockDB.RefreshRetractions())
+	_, err := mockDB.Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+
+	// version 0 now points to the index of version 1
+	record = `{"versions": {"0": "` + intToBase64Sha256(2) + `"}}`
+	require.Nil(t, mockDB.RefreshRetractions())
+	_, err = mockDB.Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, 2, indexFetches)
+}
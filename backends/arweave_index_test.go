@@ -0,0 +1,296 @@
+package backends
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mockIndexV1(prefixes []string, indices []int, infos []IndexEntryInfo) []byte {
+	index := append([]byte(indexHeaderMagic), IndexFormatV1)
+	for i, prefix := range prefixes {
+		entry := append(padZeroes(prefix), []byte(intToBase64Sha256(indices[i]))...)
+		index = append(index, append(entry, encodeIndexEntryInfo(infos[i])...)...)
+	}
+	return index
+}
+
+func TestIndexEntryInfo(t *testing.T) {
+	infos := []IndexEntryInfo{
+		{PayloadSize: 100, KeyCount: 1, Codec: CodecJSON},
+		// written without metadata
+		{},
+		{PayloadSize: 1 << 40, KeyCount: 3, Codec: CodecJSON},
+	}
+	index := mockIndexV1([]string{"ab", "cd", "cd"}, []int{0, 1, 2}, infos)
+	require.Nil(t, validateIndexHeader(index))
+
+	entries := getIndexEntries("cc", index)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, string(padZeroes("cd")), entries[0].keyPrefix)
+	require.Equal(t, intToBase64Sha256(1), string(entries[0].txId))
+	require.Equal(t, IndexEntryInfo{}, entries[0].info)
+	require.Equal(t, intToBase64Sha256(2), string(entries[1].txId))
+	require.Equal(t, infos[2], entries[1].info)
+
+	entries = getIndexEntriesForRange("a", []byte("ce"), index)
+	require.Equal(t, 3, len(entries))
+	require.Equal(t, infos[0], entries[0].info)
+
+	// legacy entries have no metadata
+	entries = getIndexEntries("cc", mockIndex([]string{"ab", "cd"}, []int{0, 1}))
+	require.Equal(t, IndexEntryInfo{}, entries[0].info)
+}
+
+func TestGetWithIndexV1(t *testing.T) {
+	indexV0 := mockIndex([]string{"ab", "cd"}, []int{0, 1})
+	indexV1 := mockIndexV1([]string{"ab", "cd"}, []int{2, 3}, []IndexEntryInfo{{}, {PayloadSize: 14, KeyCount: 1, Codec: CodecJSON}})
+	txData := [][]byte{
+		mockTxData([]string{"aa"}, []string{"v1"}),
+		mockTxData([]string{"cc"}, []string{"v2"}),
+		mockTxData([]string{"aa"}, []string{"v3"}),
+		mockTxData([]string{"cc"}, []string{"v4"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{indexV0, indexV1}, txData, []int{0, 1, 2, 3})
+	v0Bz, v1Bz := make([]byte, 8), make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	binary.BigEndian.PutUint64(v1Bz, 1)
+
+	value, err := mockDB.Get(append(v0Bz, []byte("cc")...))
+	require.Nil(t, err)
+	require.Equal(t, "v2", string(value))
+	value, err = mockDB.Get(append(v1Bz, []byte("cc")...))
+	require.Nil(t, err)
+	require.Equal(t, "v4", string(value))
+
+	desc, err := mockDB.DescribeIndex(0)
+	require.Nil(t, err)
+	require.Equal(t, LegacyIndexFormat, desc.Format)
+	require.Equal(t, 2, len(desc.Entries))
+	require.Equal(t, IndexEntryInfo{}, desc.Entries[1].Info)
+
+	desc, err = mockDB.DescribeIndex(1)
+	require.Nil(t, err)
+	require.Equal(t, IndexFormatV1, desc.Format)
+	require.Equal(t, padZeroes("cd"), desc.Entries[1].KeyPrefix)
+	require.Equal(t, intToBase64Sha256(3), desc.Entries[1].TxId)
+	require.Equal(t, IndexEntryInfo{PayloadSize: 14, KeyCount: 1, Codec: CodecJSON}, desc.Entries[1].Info)
+}
+
+func TestValidateIndexHeader(t *testing.T) {
+	require.Nil(t, validateIndexHeader([]byte{}))
+	require.NotNil(t, validateIndexHeader(mockIndex([]string{"ab"}, []int{0})[1:]))
+	index := mockIndexV1([]string{"ab"}, []int{0}, []IndexEntryInfo{{}})
+	require.NotNil(t, validateIndexHeader(index[:len(index)-1]))
+	index[len(indexHeaderMagic)] = 42
+	require.NotNil(t, validateIndexHeader(index))
+}
+
+func TestValidateIndexEntries(t *testing.T) {
+	entry := func(prefix string, txId string) []byte {
+		return append(padZeroes(prefix), append([]byte(txId), make([]byte, Sha256Base64Len-len(txId))...)...)
+	}
+	valid := entry("ab", intToBase64Sha256(0))
+	for name, tc := range map[string]struct {
+		index  []byte
+		offset int
+	}{
+		"unsorted":       {append(append(append([]byte{}, valid...), entry("cd", intToBase64Sha256(1))...), entry("bc", intToBase64Sha256(2))...), 2 * IndexEntryLen},
+		"padding prefix": {append(append([]byte{}, valid...), entry("", intToBase64Sha256(1))...), IndexEntryLen},
+		"empty tx ID":    {append(append([]byte{}, valid...), entry("cd", "")...), IndexEntryLen},
+		"short tx ID":    {append(entry("ab", intToBase64Sha256(0)[:43]), valid...), 0},
+		"v1 unsorted": {
+			mockIndexV1([]string{"ab", "cd", "cd", "bc"}, []int{0, 1, 2, 3}, make([]IndexEntryInfo, 4)),
+			IndexHeaderLen + 3*IndexEntryWithInfoLen,
+		},
+		"truncated":    {append(append([]byte{}, valid...), valid[:100]...), IndexEntryLen},
+		"v1 truncated": {mockIndexV1([]string{"ab", "cd"}, []int{0, 1}, make([]IndexEntryInfo, 2))[:IndexHeaderLen+IndexEntryWithInfoLen+10], IndexHeaderLen + IndexEntryWithInfoLen},
+	} {
+		err := validateIndexHeader(tc.index)
+		if err == nil {
+			err = validateIndexEntries(tc.index)
+		}
+		var malformed *ErrMalformedIndex
+		require.ErrorAs(t, err, &malformed, name)
+		require.Equal(t, tc.offset, malformed.Offset(), name)
+	}
+	// duplicate prefixes are fine
+	require.Nil(t, validateIndexEntries(mockIndexV1([]string{"ab", "cd", "cd"}, []int{0, 1, 2}, make([]IndexEntryInfo, 3))))
+	require.Nil(t, validateIndexEntries(mockIndex([]string{"ab", "cd", "cd"}, []int{0, 1, 2})))
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestIndexValidationModes(t *testing.T) {
+	unsorted := mockIndexV1([]string{"ef", "ab", "cd", "cd"}, []int{0, 1, 2, 3}, make([]IndexEntryInfo, 4))
+	txData := [][]byte{
+		mockTxData([]string{"ee"}, []string{"v0"}),
+		mockTxData([]string{"aa"}, []string{"v1"}),
+		mockTxData([]string{"cb"}, []string{"v2"}),
+		mockTxData([]string{"cc"}, []string{"v3"}),
+	}
+	key := func(key string) []byte {
+		return append(make([]byte, 8), []byte(key)...)
+	}
+
+	mockDB := NewMockArweaveDB([][]byte{unsorted}, txData, []int{0, 1, 2, 3})
+	WithIndexValidation(IndexValidationStrict)(mockDB)
+	_, err := mockDB.Get(key("aa"))
+	var malformed *ErrMalformedIndex
+	require.ErrorAs(t, err, &malformed)
+	require.Equal(t, IndexHeaderLen+IndexEntryWithInfoLen, malformed.Offset())
+	desc, err := mockDB.DescribeIndex(0)
+	require.Nil(t, err)
+	require.Equal(t, padZeroes("ef"), desc.Entries[0].KeyPrefix)
+	require.ErrorAs(t, desc.Validation, &malformed)
+
+	logger := &recordingLogger{}
+	mockDB = NewMockArweaveDB([][]byte{unsorted}, txData, []int{0, 1, 2, 3})
+	WithIndexValidation(IndexValidationLenient)(mockDB)
+	WithLogger(logger)(mockDB)
+	for k, v := range map[string]string{"aa": "v1", "cb": "v2", "cc": "v3", "ee": "v0"} {
+		value, err := mockDB.Get(key(k))
+		require.Nil(t, err)
+		require.Equal(t, v, string(value))
+	}
+	iter, err := mockDB.Iterator(key("a"), key("f"))
+	require.Nil(t, err)
+	keys := []string{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.Nil(t, iter.Close())
+	require.Equal(t, []string{"aa", "cb", "cc", "ee"}, keys)
+	require.Len(t, logger.lines, 1)
+	require.Contains(t, logger.lines[0], "malformed")
+
+	desc, err = NewMockArweaveDB([][]byte{mockIndex([]string{"ab"}, []int{0})}, txData, []int{0, 1, 2, 3}).DescribeIndex(0)
+	require.Nil(t, err)
+	require.Nil(t, desc.Validation)
+}
+
+// linearIndexEntries and linearIndexEntriesForRange are the scans
+// lookupIndexEntries and lookupIndexEntriesForRange replaced, kept as a
+// reference.
+func linearIndexEntries(keyString string, entries []IndexEntry) []IndexEntry {
+	res := []IndexEntry{}
+	for _, indexEntry := range entries {
+		if len(res) > 0 {
+			if res[0].keyPrefix == indexEntry.keyPrefix {
+				res = append(res, indexEntry)
+			} else {
+				break
+			}
+		} else if truncateKeyPrefix(keyString) <= indexEntry.keyPrefix {
+			res = append(res, indexEntry)
+		}
+	}
+	return res
+}
+
+func linearIndexEntriesForRange(keyStart string, keyEnd []byte, entries []IndexEntry) []IndexEntry {
+	keyStart = truncateKeyPrefix(keyStart)
+	res := []IndexEntry{}
+	reachedEnd := false
+	for _, indexEntry := range entries {
+		if reachedEnd {
+			if res[len(res)-1].keyPrefix == indexEntry.keyPrefix {
+				res = append(res, indexEntry)
+			} else {
+				break
+			}
+		} else if keyStart <= indexEntry.keyPrefix {
+			res = append(res, indexEntry)
+		}
+		if keyEnd != nil && truncateKeyPrefix(string(keyEnd)) <= indexEntry.keyPrefix {
+			reachedEnd = true
+		}
+	}
+	return res
+}
+
+func txIdsOf(entries []IndexEntry) []string {
+	txIds := []string{}
+	for _, entry := range entries {
+		txIds = append(txIds, string(entry.txId))
+	}
+	return txIds
+}
+
+func TestIndexLookupMatchesLinearScan(t *testing.T) {
+	// "cd" and "gh" are split across several transactions
+	prefixes := []string{"ab", "cd", "cd", "cd", "ef", "gh", "gh"}
+	index := mockIndex(prefixes, []int{0, 1, 2, 3, 4, 5, 6})
+	entries := parseIndex(index)
+	keys := []string{"", "a", "ab", "ab\x00", "abc", "b", "cd", "cde", "d", "ef", "g", "gh", "ghi", "h", "z"}
+	for _, key := range keys {
+		expected := txIdsOf(linearIndexEntries(key, entries))
+		require.Equal(t, expected, txIdsOf(lookupIndexEntries(key, entries)), "key %q", key)
+		require.Equal(t, expected, txIdsOf(getIndexEntries(key, index)), "key %q", key)
+		for _, end := range keys {
+			if end < key {
+				continue
+			}
+			expected := txIdsOf(linearIndexEntriesForRange(key, []byte(end), entries))
+			require.Equal(t, expected, txIdsOf(lookupIndexEntriesForRange(key, []byte(end), entries)), "range %q-%q", key, end)
+			require.Equal(t, expected, txIdsOf(getIndexEntriesForRange(key, []byte(end), index)), "range %q-%q", key, end)
+		}
+		expected = txIdsOf(linearIndexEntriesForRange(key, nil, entries))
+		require.Equal(t, expected, txIdsOf(lookupIndexEntriesForRange(key, nil, entries)), "range %q-", key)
+	}
+}
+
+func TestIndexLookupAtEdges(t *testing.T) {
+	index := mockIndex([]string{"ab", "ab", "cd", "ef", "ef"}, []int{0, 1, 2, 3, 4})
+	entries := parseIndex(index)
+
+	// the first prefix, split across two transactions
+	require.Equal(t, []string{intToBase64Sha256(0), intToBase64Sha256(1)}, txIdsOf(lookupIndexEntries("", entries)))
+	require.Equal(t, []string{intToBase64Sha256(0), intToBase64Sha256(1)}, txIdsOf(lookupIndexEntries("aa", entries)))
+	require.Equal(t, []string{intToBase64Sha256(0), intToBase64Sha256(1)}, txIdsOf(lookupIndexEntries("ab", entries)))
+	// the last prefix, split across two transactions
+	require.Equal(t, []string{intToBase64Sha256(3), intToBase64Sha256(4)}, txIdsOf(lookupIndexEntries("ee", entries)))
+	require.Equal(t, []string{intToBase64Sha256(3), intToBase64Sha256(4)}, txIdsOf(getIndexEntries("ef", index)))
+	// past the last prefix
+	require.Empty(t, lookupIndexEntries("eg", entries))
+	require.Empty(t, getIndexEntries("eg", index))
+
+	require.Equal(t, []string{intToBase64Sha256(0), intToBase64Sha256(1)}, txIdsOf(lookupIndexEntriesForRange("", []byte("ab"), entries)))
+	require.Equal(t, []string{intToBase64Sha256(3), intToBase64Sha256(4)}, txIdsOf(lookupIndexEntriesForRange("ee", []byte("ef"), entries)))
+	require.Equal(t, []string{intToBase64Sha256(3), intToBase64Sha256(4)}, txIdsOf(getIndexEntriesForRange("ee", nil, index)))
+	require.Empty(t, lookupIndexEntriesForRange("eg", []byte("z"), entries))
+	require.Empty(t, lookupIndexEntriesForRange("eg", nil, entries))
+	require.Empty(t, lookupIndexEntries("", nil))
+	require.Empty(t, lookupIndexEntriesForRange("", nil, nil))
+}
+
+func BenchmarkIndexLookup(b *testing.B) {
+	const entryCount = 1000000
+	txId := []byte(intToBase64Sha256(0))
+	entries := make([]IndexEntry, entryCount)
+	for i := range entries {
+		entries[i] = IndexEntry{keyPrefix: string(padZeroes(fmt.Sprintf("%08d", i))), txId: txId}
+	}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%08d/key", (i*7919)%entryCount)
+	}
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			linearIndexEntries(keys[i%len(keys)], entries)
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			lookupIndexEntries(keys[i%len(keys)], entries)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package backends
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Logger receives the warnings of an ArweaveDB. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger makes the DB log its warnings to logger rather than to the
+// standard logger.
+func WithLogger(logger Logger) ArweaveOption {
+	return func(db *ArweaveDB) {
+		db.logger = logger
+	}
+}
+
+func (db *ArweaveDB) logf(format string, v ...interface{}) {
+	if db.logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	db.logger.Printf(format, v...)
+}
+
+type IndexValidationMode uint8
+
+const (
+	// IndexValidationStrict fails reads of versions with a malformed index
+	// with an ErrMalformedIndex.
+	IndexValidationStrict IndexValidationMode = iota + 1
+	// IndexValidationLenient logs a warning the first time a malformed
+	// index is read, and reads its entries sorted by key prefix.
+	IndexValidationLenient
+)
+
+// indexValidation remembers the validation results of indices by tx ID,
+// indices being immutable.
+type indexValidation struct {
+	mode    IndexValidationMode
+	mtx     sync.Mutex
+	results map[string]error
+}
+
+// WithIndexValidation makes the DB check the invariants lookups rely on
+// when it reads an index: entries sorted by key prefix, key prefixes which
+// aren't only padding, and tx IDs neither empty nor shorter than
+// Sha256Base64Len.
+func WithIndexValidation(mode IndexValidationMode) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if mode != IndexValidationStrict && mode != IndexValidationLenient {
+			db.invalidOption(fmt.Errorf("WithIndexValidation is given unknown mode %d", mode))
+		}
+		db.indexValidation = &indexValidation{mode: mode, results: map[string]error{}}
+	}
+}
+
+// validateIndexEntries checks the entries of an index with a valid header,
+// returning an ErrMalformedIndex for the first invalid one.
+func validateIndexEntries(index []byte) error {
+	entries, entryLen := splitIndex(index)
+	headerLen := len(index) - len(entries)
+	var prev []byte
+	for i := 0; i < len(entries); i += entryLen {
+		prefix := entries[i : i+IndexKeyPrefixLen]
+		txId := entries[i+IndexKeyPrefixLen : i+IndexEntryLen]
+		var reason string
+		switch trimmed := bytes.TrimRight(txId, "\x00"); {
+		case len(bytes.TrimRight(prefix, "\x00")) == 0:
+			reason = "key prefix is only padding"
+		case len(trimmed) == 0:
+			reason = "empty tx ID"
+		case len(trimmed) != Sha256Base64Len:
+			reason = fmt.Sprintf("tx ID is %d bytes long, not %d", len(trimmed), Sha256Base64Len)
+		case prev != nil && bytes.Compare(prefix, prev) < 0:
+			reason = "key prefix is smaller than the previous one"
+		case entryLen == IndexEntryWithKeyFilterLen:
+			keyFilterTxId := bytes.TrimRight(entries[i+IndexEntryWithInfoLen:i+entryLen], "\x00")
+			if len(keyFilterTxId) != 0 && len(keyFilterTxId) != Sha256Base64Len {
+				reason = fmt.Sprintf("key filter tx ID is %d bytes long, not %d", len(keyFilterTxId), Sha256Base64Len)
+			}
+		}
+		if reason != "" {
+			return &ErrMalformedIndex{offset: headerLen + i, reason: reason}
+		}
+		prev = prefix
+	}
+	return nil
+}
+
+// sortedIndex returns a copy of index with its entries stably sorted by key
+// prefix.
+func sortedIndex(index []byte) []byte {
+	entries, entryLen := splitIndex(index)
+	sorted := make([][]byte, 0, len(entries)/entryLen)
+	for i := 0; i < len(entries); i += entryLen {
+		sorted = append(sorted, entries[i:i+entryLen])
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:IndexKeyPrefixLen], sorted[j][:IndexKeyPrefixLen]) < 0
+	})
+	res := append([]byte{}, index[:len(index)-len(entries)]...)
+	for _, entry := range sorted {
+		res = append(res, entry...)
+	}
+	return res
+}
+
+// validatedIndex returns index once validated according to the DB's index
+// validation mode.
+func (db *ArweaveDB) validatedIndex(version uint64, indexTxId []byte, index []byte) ([]byte, error) {
+	v := db.indexValidation
+	if v == nil {
+		return index, nil
+	}
+	v.mtx.Lock()
+	err, validated := v.results[string(indexTxId)]
+	if !validated {
+		err = validateIndexEntries(index)
+		v.results[string(indexTxId)] = err
+	}
+	v.mtx.Unlock()
+	if err == nil {
+		return index, nil
+	}
+	if v.mode == IndexValidationStrict {
+		return nil, err
+	}
+	if !validated {
+		db.logf("arweave: %sindex %s of version %d is malformed, falling back to sorting its entries: %s", db.tracePrefix(), indexTxId, version, err)
+	}
+	return sortedIndex(index), nil
+}
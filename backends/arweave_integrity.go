@@ -0,0 +1,57 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// DigestResolver returns the sha256 digest the data of txId is expected to
+// have, as fetched, i.e. compressed if it is, and whether it is known.
+type DigestResolver func(txId []byte) (digest []byte, ok bool, err error)
+
+// WithIntegrityVerification makes the ArweaveDB hash the data of every
+// transaction it fetches, indices included, and fail with
+// ErrIntegrityCheckFailed if it doesn't have the digest resolved by
+// resolve, rather than decode truncated or garbage data served by a
+// gateway. Transactions whose digest isn't known aren't checked. Data
+// failing the check is fetched once more revalidating cached data, see
+// ReadOptions.Revalidate. DBs trusting their getters don't verify anything
+// by default.
+func WithIntegrityVerification(resolve DigestResolver) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if resolve == nil {
+			db.invalidOption(errors.New("WithIntegrityVerification is given a nil resolver"))
+		}
+		db.digests = resolve
+	}
+}
+
+// verifyTxData checks the data of txId against its expected digest, if the
+// DB verifies integrity.
+func (db *ArweaveDB) verifyTxData(txId, data []byte) error {
+	if db.digests == nil {
+		return nil
+	}
+	expected, ok, err := db.digests(txId)
+	if err != nil || !ok {
+		return err
+	}
+	if actual := sha256.Sum256(data); !bytes.Equal(actual[:], expected) {
+		return &ErrIntegrityCheckFailed{txId: string(txId), expected: expected, actual: actual[:]}
+	}
+	return nil
+}
+
+// fetchVerifiedTxData is fetchTxData fetching the data again, revalidating
+// it, if it fails the integrity check.
+func (db *ArweaveDB) fetchVerifiedTxData(kind FetchKind, txId []byte) ([]byte, error) {
+	data, err := db.fetchTxDataWith(kind, db.txDataByIdGetter, txId)
+	if err == nil || db.readOptions.Revalidate || !errors.As(err, new(*ErrIntegrityCheckFailed)) {
+		return data, err
+	}
+	opts := db.readOptions
+	opts.Revalidate = true
+	view := db.WithReadOptions(opts)
+	return view.fetchTxDataWith(kind, view.txDataByIdGetter, txId)
+}
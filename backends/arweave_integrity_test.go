@@ -0,0 +1,103 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newIntegrityTestDB returns a DB whose version 0 holds a=1 and b=2, along
+// with the digests of its transactions. Fetches of the transaction flipped
+// flips the byte of the first "1" of its data while flips is positive.
+func newIntegrityTestDB(flipped string, flips int) (*ArweaveDB, map[string][]byte, *int) {
+	txData := [][]byte{mockTxData([]string{"a", "b"}, []string{"1", "2"})}
+	indices := [][]byte{mockIndex([]string{"b"}, []int{10})}
+	db := NewMockArweaveDB(indices, txData, []int{10})
+	digests := map[string][]byte{}
+	for _, txId := range []string{intToBase64Sha256(10), intToBase64Sha256(1)} {
+		data, err := db.txDataByIdGetter([]byte(txId))
+		if err != nil {
+			panic(err)
+		}
+		digest := sha256.Sum256(data)
+		digests[txId] = digest[:]
+	}
+	var mtx sync.Mutex
+	fetches := new(int)
+	getter := db.txDataByIdGetter
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		data, err := getter(txId)
+		mtx.Lock()
+		defer mtx.Unlock()
+		*fetches++
+		if string(txId) == flipped && flips > 0 {
+			flips--
+			data = append([]byte{}, data...)
+			data[bytes.IndexByte(data, '1')] ^= 1
+		}
+		return data, err
+	}
+	return db, digests, fetches
+}
+
+func resolveFrom(digests map[string][]byte) DigestResolver {
+	return func(txId []byte) ([]byte, bool, error) {
+		digest, ok := digests[string(txId)]
+		return digest, ok, nil
+	}
+}
+
+func TestIntegrityCheckFailed(t *testing.T) {
+	for _, flipped := range []string{intToBase64Sha256(10), intToBase64Sha256(1)} {
+		db, digests, _ := newIntegrityTestDB(flipped, 2)
+		WithIntegrityVerification(resolveFrom(digests))(db)
+		_, err := db.Get(versionedKey(0, "a"))
+		integrityErr := &ErrIntegrityCheckFailed{}
+		require.True(t, errors.As(err, &integrityErr), err)
+		require.Equal(t, flipped, integrityErr.TxId())
+		expected, actual := integrityErr.Digests()
+		require.Equal(t, digests[flipped], expected)
+		require.NotEqual(t, expected, actual)
+		require.Len(t, actual, sha256.Size)
+		require.True(t, IsTransientFetchError(err))
+	}
+
+	db, digests, _ := newIntegrityTestDB(intToBase64Sha256(10), 2)
+	WithIntegrityVerification(resolveFrom(digests))(db)
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	if err == nil {
+		err = iter.Error()
+		iter.Close()
+	}
+	require.True(t, errors.As(err, new(*ErrIntegrityCheckFailed)))
+}
+
+func TestIntegrityCheckRefetches(t *testing.T) {
+	db, digests, fetches := newIntegrityTestDB(intToBase64Sha256(10), 1)
+	WithIntegrityVerification(resolveFrom(digests))(db)
+	value, err := db.Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+	// the index, then the payload twice
+	require.Equal(t, 3, *fetches)
+}
+
+func TestIntegrityVerificationSkipped(t *testing.T) {
+	// without verification, the flipped byte goes unnoticed
+	db, _, _ := newIntegrityTestDB(intToBase64Sha256(10), 1)
+	value, err := db.Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, "0", string(value))
+
+	// nor are transactions of unknown digests checked
+	db, digests, _ := newIntegrityTestDB(intToBase64Sha256(10), 1)
+	delete(digests, intToBase64Sha256(10))
+	WithIntegrityVerification(resolveFrom(digests))(db)
+	value, err = db.Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, "0", string(value))
+}
@@ -0,0 +1,110 @@
+package backends
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Key filters are bloom filters of the keys of a payload, published as
+// sidecar transactions referenced by IndexFormatV2 entries, so that Has can
+// rule out a payload without fetching it. They are made of keyFilterMagic,
+// the number of hash functions (1 byte) and the bits of the filter.
+const (
+	keyFilterMagic     = "\xffARWKF"
+	keyFilterHeaderLen = len(keyFilterMagic) + 1
+	// filters get at least that many bits, so that tiny payloads don't get
+	// useless ones
+	minKeyFilterBits   = 64
+	maxKeyFilterHashes = 30
+)
+
+var errMalformedKeyFilter = errors.New("malformed key filter")
+
+// buildKeyFilter returns the key filter of keys with bitsPerKey bits per
+// key.
+func buildKeyFilter(keys []string, bitsPerKey int) []byte {
+	bits := len(keys) * bitsPerKey
+	if bits < minKeyFilterBits {
+		bits = minKeyFilterBits
+	}
+	// the number of hashes minimizing false positives is ln(2) per bit per key
+	hashes := int(math.Round(float64(bitsPerKey) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	} else if hashes > maxKeyFilterHashes {
+		hashes = maxKeyFilterHashes
+	}
+	filter := make([]byte, keyFilterHeaderLen+(bits+7)/8)
+	copy(filter, keyFilterMagic)
+	filter[len(keyFilterMagic)] = byte(hashes)
+	filterBits := filter[keyFilterHeaderLen:]
+	for _, key := range keys {
+		forEachKeyFilterBit(key, hashes, uint64(len(filterBits))*8, func(bit uint64) bool {
+			filterBits[bit/8] |= 1 << (bit % 8)
+			return true
+		})
+	}
+	return filter
+}
+
+// keyFilterMayContain returns whether the key filter may contain key, false
+// meaning that it definitely doesn't.
+func keyFilterMayContain(filter []byte, key string) (bool, error) {
+	if len(filter) <= keyFilterHeaderLen || !bytes.HasPrefix(filter, []byte(keyFilterMagic)) {
+		return false, errMalformedKeyFilter
+	}
+	hashes := int(filter[len(keyFilterMagic)])
+	if hashes == 0 || hashes > maxKeyFilterHashes {
+		return false, errMalformedKeyFilter
+	}
+	filterBits := filter[keyFilterHeaderLen:]
+	return forEachKeyFilterBit(key, hashes, uint64(len(filterBits))*8, func(bit uint64) bool {
+		return filterBits[bit/8]&(1<<(bit%8)) != 0
+	}), nil
+}
+
+// forEachKeyFilterBit calls f with the bits of key among bits, derived from
+// its sha256 digest by double hashing, until f returns false, returning
+// whether it never did.
+func forEachKeyFilterBit(key string, hashes int, bits uint64, f func(bit uint64) bool) bool {
+	digest := sha256.Sum256([]byte(key))
+	h1 := binary.BigEndian.Uint64(digest[:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16])
+	for i := 0; i < hashes; i++ {
+		if !f((h1 + uint64(i)*h2) % bits) {
+			return false
+		}
+	}
+	return true
+}
+
+// mayHoldKey returns whether the payload of entry may hold key according to
+// its key filter. Payloads without key filters, or whose filter can't be
+// fetched or is malformed, may hold any key, so that they are fetched as
+// they would be without filters.
+func (db *ArweaveDB) mayHoldKey(entry IndexEntry, key []byte) bool {
+	if entry.keyFilterTxId == nil {
+		return true
+	}
+	filter, err := db.fetchTxData(FetchKeyFilter, entry.keyFilterTxId)
+	if err != nil {
+		return true
+	}
+	mayContain, err := keyFilterMayContain(filter, string(key))
+	return mayContain || err != nil
+}
+
+// filterEntriesByKey returns the entries whose payload may hold key
+// according to their key filter.
+func (db *ArweaveDB) filterEntriesByKey(key []byte, entries []IndexEntry) []IndexEntry {
+	filtered := entries[:0:0]
+	for _, entry := range entries {
+		if db.mayHoldKey(entry, key) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,147 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyFilter(t *testing.T) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%04d", i)
+	}
+	filter := buildKeyFilter(keys, 10)
+	for _, key := range keys {
+		mayContain, err := keyFilterMayContain(filter, key)
+		require.Nil(t, err)
+		require.True(t, mayContain, key)
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		mayContain, err := keyFilterMayContain(filter, fmt.Sprintf("absent%04d", i))
+		require.Nil(t, err)
+		if mayContain {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 300)
+
+	for _, malformed := range [][]byte{nil, []byte(keyFilterMagic), append([]byte(keyFilterMagic), 0, 0xff), []byte("{\"key\":\"value\"}")} {
+		_, err := keyFilterMayContain(malformed, "key")
+		require.Equal(t, errMalformedKeyFilter, err)
+	}
+}
+
+// newKeyFilterTestDB returns a DB over a version of 30 keys in payloads of
+// 3 keys, with key filters if bitsPerKey is set, along with the tx IDs of
+// its payloads.
+func newKeyFilterTestDB(t *testing.T, bitsPerKey int) (*ArweaveDB, map[string]bool) {
+	uploads := &mockUploads{failIn: -1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{MaxKeysPerPayload: 3, KeyFilterBitsPerKey: bitsPerKey})
+	for i := 0; i < 30; i++ {
+		require.Nil(t, w.Set(0, []byte(fmt.Sprintf("key%02d", i)), []byte("v")))
+	}
+	_, err := w.FlushVersion(0)
+	require.Nil(t, err)
+	payloads := map[string]bool{}
+	for _, entry := range parseIndex(uploads.indices[0]) {
+		payloads[string(entry.txId)] = true
+		require.Equal(t, bitsPerKey > 0, entry.keyFilterTxId != nil)
+	}
+	require.Len(t, payloads, 10)
+	return uploads.db(), payloads
+}
+
+func TestHasSkipsPayloadsRuledOutByKeyFilter(t *testing.T) {
+	db, payloads := newKeyFilterTestDB(t, 10)
+	fetched := countFetches(db)
+	payloadFetches := func() int {
+		count := 0
+		for txId, n := range fetched {
+			if payloads[txId] {
+				count += n
+			}
+		}
+		return count
+	}
+
+	// keys between the present ones, each looked up in one payload
+	for _, key := range []string{"key00a", "key05a", "key17a", "key29a"} {
+		has, err := db.Has(versionedKey(0, key))
+		require.Nil(t, err)
+		require.False(t, has)
+	}
+	require.Zero(t, payloadFetches())
+
+	for i := 0; i < 30; i++ {
+		has, err := db.Has(versionedKey(0, fmt.Sprintf("key%02d", i)))
+		require.Nil(t, err)
+		require.True(t, has)
+	}
+	require.Equal(t, 30, payloadFetches())
+
+	// Get doesn't consult the filters
+	for i := 0; i < 30; i++ {
+		value, err := db.Get(versionedKey(0, fmt.Sprintf("key%02d", i)))
+		require.Nil(t, err)
+		require.Equal(t, "v", string(value))
+	}
+	_, err := db.Get(versionedKey(0, "key05a"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	desc, err := db.DescribeIndex(0)
+	require.Nil(t, err)
+	require.Equal(t, IndexFormatV2, desc.Format)
+	require.Nil(t, desc.Validation)
+	for _, entry := range desc.Entries {
+		require.Len(t, entry.KeyFilterTxId, Sha256Base64Len)
+	}
+}
+
+func TestHasWithoutKeyFilters(t *testing.T) {
+	// legacy payloads without filters are fetched
+	db, payloads := newKeyFilterTestDB(t, 0)
+	fetched := countFetches(db)
+	has, err := db.Has(versionedKey(0, "key05a"))
+	require.Nil(t, err)
+	require.False(t, has)
+	count := 0
+	for txId, n := range fetched {
+		if payloads[txId] {
+			count += n
+		}
+	}
+	require.Equal(t, 1, count)
+
+	// so are payloads whose filter can't be fetched
+	db, payloads = newKeyFilterTestDB(t, 10)
+	desc, err := db.DescribeIndex(0)
+	require.Nil(t, err)
+	keyFilters := map[string]bool{}
+	for _, entry := range desc.Entries {
+		keyFilters[entry.KeyFilterTxId] = true
+	}
+	fetched = map[string]int{}
+	ApplyMiddleware(db, func(next Getter) Getter {
+		return func(txId []byte) ([]byte, error) {
+			if keyFilters[string(txId)] {
+				return nil, errors.New("unavailable")
+			}
+			fetched[string(txId)]++
+			return next(txId)
+		}
+	})
+	has, err = db.Has(versionedKey(0, "key05a"))
+	require.Nil(t, err)
+	require.False(t, has)
+	count = 0
+	for txId, n := range fetched {
+		if payloads[txId] {
+			count += n
+		}
+	}
+	require.Equal(t, 1, count)
+}
@@ -0,0 +1,226 @@
+package backends
+
+import (
+	"bytes"
+	"math"
+	"sync"
+)
+
+// LatestVersionMaxGap is the number of consecutive missing versions
+// GetLatestVersion looks past for later versions.
+const LatestVersionMaxGap = 16
+
+// latestVersion caches the result of GetLatestVersion.
+type latestVersion struct {
+	mtx     sync.Mutex
+	version uint64
+	ok      bool
+}
+
+// GetLatestVersion returns the highest archived version, discovered by
+// probing the version getter the first time, then cached until
+// RefreshLatestVersion is called. Versions are expected to be archived in
+// order, gaps of up to LatestVersionMaxGap missing versions being looked
+// past. Without any archived version, it fails with ErrNoVersions.
+func (db *ArweaveDB) GetLatestVersion() (uint64, error) {
+	return db.getLatestVersion(0, false)
+}
+
+// GetLatestVersionNear is GetLatestVersion starting to probe at hint, a
+// version expected to be close to the latest one, e.g. the current height
+// of the chain being archived, if it isn't cached yet.
+func (db *ArweaveDB) GetLatestVersionNear(hint uint64) (uint64, error) {
+	return db.getLatestVersion(hint, false)
+}
+
+// RefreshLatestVersion probes for versions archived since the latest one
+// was cached, and caches the result.
+func (db *ArweaveDB) RefreshLatestVersion() (uint64, error) {
+	return db.getLatestVersion(0, true)
+}
+
+func (db *ArweaveDB) getLatestVersion(hint uint64, refresh bool) (uint64, error) {
+	cache := db.latestVersion
+	if cache == nil {
+		return db.findLatestVersion(hint)
+	}
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	if cache.ok {
+		if !refresh {
+			return cache.version, nil
+		}
+		// archived versions stay archived
+		hint = cache.version
+	}
+	version, err := db.findLatestVersion(hint)
+	if err != nil {
+		return 0, err
+	}
+	cache.version, cache.ok = version, true
+	return version, nil
+}
+
+// advanceLatestVersion caches version as the latest one if it is later
+// than the cached one, e.g. once found archived.
+func (db *ArweaveDB) advanceLatestVersion(version uint64) {
+	cache := db.latestVersion
+	if cache == nil {
+		return
+	}
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	if cache.ok && version > cache.version {
+		cache.version = version
+	}
+}
+
+// cachedLatestVersion returns the cached latest version, if any, without
+// probing for it.
+func (db *ArweaveDB) cachedLatestVersion() (uint64, bool) {
+	cache := db.latestVersion
+	if cache == nil {
+		return 0, false
+	}
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+	return cache.version, cache.ok
+}
+
+// probeVersion returns whether version is archived. Unlike HasVersion, it
+// doesn't trust missing versions to still be missing.
+func (db *ArweaveDB) probeVersion(version uint64) (bool, error) {
+	if db.versionProbes != nil {
+		if exists, ok := db.versionProbes.get(version); ok && exists {
+			return true, nil
+		}
+	}
+	txId, err := db.getIndexTxId(version)
+	if err != nil && !isVersionNotFound(err) {
+		return false, err
+	}
+	exists := err == nil && len(txId) > 0
+	if db.versionProbes != nil && exists {
+		// missing versions are left to HasVersion, which tells those
+		// beyond the latest one apart
+		db.versionProbes.put(version, true)
+	}
+	return exists, nil
+}
+
+// findLatestVersion finds an archived version probing exponentially further
+// below then above hint, gallops up from it to a missing version, binary
+// searches the last archived version before it, and starts over from any
+// version archived within LatestVersionMaxGap after it.
+func (db *ArweaveDB) findLatestVersion(hint uint64) (uint64, error) {
+	latest, found, err := db.findArchivedVersion(hint)
+	if err != nil || !found {
+		if err == nil {
+			err = &ErrNoVersions{}
+		}
+		return 0, err
+	}
+	for {
+		// latest is archived and missing is not
+		var missing uint64
+		for step := uint64(1); missing == 0; step *= 2 {
+			next := latest + step
+			if next < latest || step == 0 {
+				next = math.MaxUint64
+			}
+			exists, err := db.probeVersion(next)
+			if err != nil {
+				return 0, err
+			}
+			if !exists {
+				missing = next
+			} else if next == math.MaxUint64 {
+				return next, nil
+			} else {
+				latest = next
+			}
+		}
+		for missing-latest > 1 {
+			mid := latest + (missing-latest)/2
+			exists, err := db.probeVersion(mid)
+			if err != nil {
+				return 0, err
+			}
+			if exists {
+				latest = mid
+			} else {
+				missing = mid
+			}
+		}
+		next, err := db.findVersionAfterGap(missing)
+		if err != nil || next == 0 {
+			return latest, err
+		}
+		latest = next
+	}
+}
+
+// findArchivedVersion returns hint if archived, or else the first archived
+// version found at exponentially growing distances below, then above it.
+// Below hint, distances grow up to half of hint, from where the probed
+// versions are halved, so that far hints find versions close to 0 too.
+func (db *ArweaveDB) findArchivedVersion(hint uint64) (uint64, bool, error) {
+	exists, err := db.probeVersion(hint)
+	if err != nil || exists {
+		return hint, exists, err
+	}
+	// down to about half of hint, then halving towards 0
+	below := []uint64{}
+	for step := uint64(1); step <= hint/2; step *= 2 {
+		below = append(below, hint-step)
+	}
+	for version := hint / 4; version > 0; version /= 2 {
+		below = append(below, version)
+	}
+	if hint > 0 {
+		below = append(below, 0)
+	}
+	for _, version := range below {
+		if exists, err := db.probeVersion(version); err != nil || exists {
+			return version, exists, err
+		}
+	}
+	for step := uint64(1); step != 0 && hint+step > hint; step *= 2 {
+		if exists, err := db.probeVersion(hint + step); err != nil || exists {
+			return hint + step, exists, err
+		}
+	}
+	return 0, false, nil
+}
+
+// findVersionAfterGap returns the first archived version of the
+// LatestVersionMaxGap versions after missing, or 0 if there is none.
+func (db *ArweaveDB) findVersionAfterGap(missing uint64) (uint64, error) {
+	for version := missing + 1; version <= missing+LatestVersionMaxGap && version > missing; version++ {
+		exists, err := db.probeVersion(version)
+		if err != nil || exists {
+			return version, err
+		}
+	}
+	return 0, nil
+}
+
+// resolveKey is splitKey resolving keys without a version to the latest
+// version: keys shorter than the width of the version codec are
+// unversioned keys as a whole, and versions of all 0xFF bytes are stripped.
+// Codecs of varying width have no such convention.
+func (db *ArweaveDB) resolveKey(key []byte) (uint64, []byte, error) {
+	width := db.codec().Width()
+	if width == 0 {
+		return db.splitKey(key)
+	}
+	if len(key) < width {
+		version, err := db.GetLatestVersion()
+		return version, key, err
+	}
+	if bytes.Count(key[:width], []byte{0xFF}) == width {
+		version, err := db.GetLatestVersion()
+		return version, key[width:], err
+	}
+	return db.splitKey(key)
+}
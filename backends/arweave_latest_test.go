@@ -0,0 +1,154 @@
+package backends
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLatestVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		versions *sparseVersionGetter
+		latest   uint64
+	}{
+		{"single version", &sparseVersionGetter{versions: 1}, 0},
+		{"from 0", &sparseVersionGetter{versions: 1000}, 999},
+		{"from 1", &sparseVersionGetter{first: 1, versions: 1001}, 1000},
+		{"late start", &sparseVersionGetter{first: 5000, versions: 10000}, 9999},
+		{"gaps", &sparseVersionGetter{versions: 100, gaps: map[uint64]bool{64: true, 65: true, 90: true, 98: true}}, 99},
+		{"gap before the last version", &sparseVersionGetter{versions: 100, gaps: map[uint64]bool{96: true, 97: true, 98: true}}, 99},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewArweaveDBWithGetters(nil, tc.versions.get)
+			latest, err := db.GetLatestVersion()
+			require.Nil(t, err)
+			require.Equal(t, tc.latest, latest)
+			// probing is logarithmic, not linear
+			require.Less(t, tc.versions.callCount(), 100)
+		})
+	}
+}
+
+func TestGetLatestVersionLongGap(t *testing.T) {
+	gaps := map[uint64]bool{}
+	for version := uint64(10); version < 10+LatestVersionMaxGap+1; version++ {
+		gaps[version] = true
+	}
+	versions := &sparseVersionGetter{versions: 100, gaps: gaps}
+	latest, err := NewArweaveDBWithGetters(nil, versions.get).GetLatestVersion()
+	require.Nil(t, err)
+	require.Equal(t, uint64(9), latest)
+
+	// a hint past the gap finds the versions after it
+	latest, err = NewArweaveDBWithGetters(nil, versions.get).GetLatestVersionNear(50)
+	require.Nil(t, err)
+	require.Equal(t, uint64(99), latest)
+}
+
+func TestGetLatestVersionEmpty(t *testing.T) {
+	versions := &sparseVersionGetter{}
+	_, err := NewArweaveDBWithGetters(nil, versions.get).GetLatestVersion()
+	require.True(t, errors.As(err, new(*ErrNoVersions)))
+	require.LessOrEqual(t, versions.callCount(), 65)
+
+	_, err = NewArweaveDBWithGetters(nil, versions.get).GetLatestVersionNear(math.MaxUint64)
+	require.True(t, errors.As(err, new(*ErrNoVersions)))
+}
+
+func TestGetLatestVersionHint(t *testing.T) {
+	versions := &sparseVersionGetter{first: 1, versions: 1000001}
+	for _, hint := range []uint64{0, 999990, 1000000, 1000010, 1 << 40} {
+		latest, err := NewArweaveDBWithGetters(nil, versions.get).GetLatestVersionNear(hint)
+		require.Nil(t, err)
+		require.Equal(t, uint64(1000000), latest, "hint %d", hint)
+	}
+
+	versions.calls = 0
+	_, err := NewArweaveDBWithGetters(nil, versions.get).GetLatestVersionNear(999998)
+	require.Nil(t, err)
+	// a few probes around the hint, plus those looking past a gap
+	require.LessOrEqual(t, versions.callCount(), 5+LatestVersionMaxGap)
+}
+
+func TestRefreshLatestVersion(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 10}
+	db := NewArweaveDBWithGetters(nil, versions.get)
+	latest, err := db.GetLatestVersion()
+	require.Nil(t, err)
+	require.Equal(t, uint64(9), latest)
+
+	versions.mtx.Lock()
+	versions.versions = 20
+	versions.calls = 0
+	versions.mtx.Unlock()
+	latest, err = db.GetLatestVersion()
+	require.Nil(t, err)
+	require.Equal(t, uint64(9), latest)
+	require.Equal(t, 0, versions.callCount())
+
+	latest, err = db.RefreshLatestVersion()
+	require.Nil(t, err)
+	require.Equal(t, uint64(19), latest)
+	latest, err = db.GetLatestVersion()
+	require.Nil(t, err)
+	require.Equal(t, uint64(19), latest)
+
+	failing := NewArweaveDBWithGetters(nil, func([]byte) ([]byte, error) {
+		return nil, errors.New("unavailable")
+	})
+	_, err = failing.GetLatestVersion()
+	require.EqualError(t, err, "unavailable")
+}
+
+func TestVersionlessReads(t *testing.T) {
+	txData := [][]byte{
+		mockTxData([]string{"a", "b", "c"}, []string{"1", "2", "3"}),
+		mockTxData([]string{"a", "b", "c"}, []string{"4", "5", "6"}),
+	}
+	indices := [][]byte{
+		mockIndex([]string{"c"}, []int{0}),
+		mockIndex([]string{"c"}, []int{1}),
+	}
+	db := NewMockArweaveDB(indices, txData, []int{0, 1})
+	latest := func(key string) []byte { return versionedKey(math.MaxUint64, key) }
+
+	for _, key := range [][]byte{[]byte("b"), latest("b")} {
+		value, err := db.Get(key)
+		require.Nil(t, err)
+		require.Equal(t, "5", string(value))
+		has, err := db.Has(key)
+		require.Nil(t, err)
+		require.True(t, has)
+	}
+	has, err := db.Has(latest("d"))
+	require.Nil(t, err)
+	require.False(t, has)
+
+	for _, reverse := range []bool{false, true} {
+		opts := IteratorOptions{Reverse: reverse}
+		iter, err := db.IteratorWithOptions(versionedKey(1, "a"), versionedKey(1, "c"), opts)
+		require.Nil(t, err)
+		expected := collectPairs(t, iter)
+		require.Len(t, expected, 2)
+		for _, bounds := range [][2][]byte{{latest("a"), latest("c")}, {[]byte("a"), []byte("c")}, {nil, []byte("c")}} {
+			iter, err := db.IteratorWithOptions(bounds[0], bounds[1], opts)
+			require.Nil(t, err)
+			require.Equal(t, expected, collectPairs(t, iter))
+		}
+	}
+}
+
+func TestVersionlessReadsEmpty(t *testing.T) {
+	db := NewMockArweaveDB(nil, nil, nil)
+	_, err := db.Get([]byte("a"))
+	require.True(t, errors.As(err, new(*ErrNoVersions)))
+	_, err = db.Has(versionedKey(math.MaxUint64, "a"))
+	require.True(t, errors.As(err, new(*ErrNoVersions)))
+	_, err = db.Iterator([]byte("a"), nil)
+	require.True(t, errors.As(err, new(*ErrNoVersions)))
+	_, err = db.ReverseIterator(nil, versionedKey(math.MaxUint64, "a"))
+	require.True(t, errors.As(err, new(*ErrNoVersions)))
+}
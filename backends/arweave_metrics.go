@@ -0,0 +1,134 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// FetchKind tells apart what an ArweaveDB fetches.
+type FetchKind string
+
+const (
+	// FetchVersion resolves the index transaction ID of a version.
+	FetchVersion FetchKind = "version"
+	// FetchIndex fetches the index of a version.
+	FetchIndex FetchKind = "index"
+	// FetchData fetches payloads and referenced values.
+	FetchData FetchKind = "data"
+	// FetchKeyFilter fetches the key filters of payloads, see
+	// ChunkPolicy.KeyFilterBitsPerKey.
+	FetchKeyFilter FetchKind = "key_filter"
+)
+
+// Caches of an ArweaveDB reported to MetricsCollector.OnCacheHit and
+// OnCacheMiss.
+const (
+	// CacheIndex is the cache of parsed indices, see WithIndexCache.
+	CacheIndex = "index"
+	// CacheDecoded is the cache of decoded payloads, see
+	// WithDecodedPayloadCache.
+	CacheDecoded = "decoded"
+	// CacheRaw is the cache of transaction data, see WithPersistentCache.
+	CacheRaw = "raw"
+)
+
+// MetricsCollector observes the fetches, cache lookups and operations of an
+// ArweaveDB, e.g. to export them to a monitoring system. Methods are called
+// concurrently, inline with the operations observed, and should return
+// quickly. ErrorClass classifies the errors passed to them.
+type MetricsCollector interface {
+	// OnFetch is called once per fetch from the getters, with the bytes
+	// fetched and the error of the fetch, if any.
+	OnFetch(kind FetchKind, bytes int, duration time.Duration, err error)
+	OnCacheHit(cache string)
+	OnCacheMiss(cache string)
+	OnIteratorCreated()
+	// OnOperation is called once per Get, Has and iterator creation, op
+	// being "get", "has" or "iterator".
+	OnOperation(op string, duration time.Duration, err error)
+}
+
+// NopMetricsCollector is a MetricsCollector ignoring everything, used by
+// default.
+type NopMetricsCollector struct{}
+
+var _ MetricsCollector = NopMetricsCollector{}
+
+func (NopMetricsCollector) OnFetch(FetchKind, int, time.Duration, error) {}
+func (NopMetricsCollector) OnCacheHit(string)                            {}
+func (NopMetricsCollector) OnCacheMiss(string)                           {}
+func (NopMetricsCollector) OnIteratorCreated()                           {}
+func (NopMetricsCollector) OnOperation(string, time.Duration, error)     {}
+
+// WithMetricsCollector makes the DB report its fetches, cache lookups and
+// operations to collector.
+func WithMetricsCollector(collector MetricsCollector) ArweaveOption {
+	return func(db *ArweaveDB) {
+		db.metrics = collector
+	}
+}
+
+// collector returns the metrics collector of the DB.
+func (db *ArweaveDB) collector() MetricsCollector {
+	if db.metrics == nil {
+		return NopMetricsCollector{}
+	}
+	return db.metrics
+}
+
+func (db *ArweaveDB) recordCacheLookup(cache string, hit bool) {
+	if hit {
+		db.collector().OnCacheHit(cache)
+	} else {
+		db.collector().OnCacheMiss(cache)
+	}
+	if db.opStats != nil {
+		if hit {
+			atomic.AddInt64(&db.opStats.cacheHits, 1)
+		} else {
+			atomic.AddInt64(&db.opStats.cacheMisses, 1)
+		}
+	}
+}
+
+// Error classes returned by ErrorClass.
+const (
+	ErrorClassNone           = "ok"
+	ErrorClassNotFound       = "not_found"
+	ErrorClassCanceled       = "canceled"
+	ErrorClassDeadline       = "deadline_exceeded"
+	ErrorClassBudget         = "budget_exhausted"
+	ErrorClassNotYetArchived = "not_yet_archived"
+	ErrorClassGatewayStatus  = "gateway_status"
+	ErrorClassIntegrity      = "integrity"
+	ErrorClassCorrupt        = "corrupt"
+	ErrorClassOther          = "other"
+)
+
+// ErrorClass returns the class of err, ErrorClassNone for nil, to label
+// metrics with.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassDeadline
+	case errors.As(err, new(*ErrBudgetExhausted)):
+		return ErrorClassBudget
+	case errors.As(err, new(*ErrVersionNotYetArchived)):
+		return ErrorClassNotYetArchived
+	case isVersionNotFound(err), errors.As(err, new(*ErrNoVersions)):
+		return ErrorClassNotFound
+	case errors.As(err, new(*ErrGatewayStatus)):
+		return ErrorClassGatewayStatus
+	case errors.As(err, new(*ErrIntegrityCheckFailed)), errors.As(err, new(*ErrUntrustedIndex)):
+		return ErrorClassIntegrity
+	case errors.As(err, new(*ErrCorruptCompression)), errors.As(err, new(*ErrMalformedIndex)):
+		return ErrorClassCorrupt
+	}
+	return ErrorClassOther
+}
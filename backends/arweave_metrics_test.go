@@ -0,0 +1,133 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingCollector records the events reported to it, as strings.
+type recordingCollector struct {
+	mtx    sync.Mutex
+	events []string
+	bytes  map[FetchKind]int
+}
+
+func (c *recordingCollector) record(format string, args ...interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.events = append(c.events, fmt.Sprintf(format, args...))
+}
+
+func (c *recordingCollector) OnFetch(kind FetchKind, bytes int, duration time.Duration, err error) {
+	c.mtx.Lock()
+	if c.bytes == nil {
+		c.bytes = map[FetchKind]int{}
+	}
+	c.bytes[kind] += bytes
+	c.mtx.Unlock()
+	c.record("fetch %s %s", kind, ErrorClass(err))
+}
+
+func (c *recordingCollector) OnCacheHit(cache string) {
+	c.record("hit %s", cache)
+}
+
+func (c *recordingCollector) OnCacheMiss(cache string) {
+	c.record("miss %s", cache)
+}
+
+func (c *recordingCollector) OnIteratorCreated() {
+	c.record("iterator")
+}
+
+func (c *recordingCollector) OnOperation(op string, duration time.Duration, err error) {
+	c.record("op %s %s", op, ErrorClass(err))
+}
+
+func (c *recordingCollector) take() []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	events := c.events
+	c.events = nil
+	return events
+}
+
+func TestMetricsCollector(t *testing.T) {
+	collector := &recordingCollector{}
+	db := newDecodeTestDB(3, 2, 1, WithIndexCache(1), WithMetricsCollector(collector))
+
+	_, err := db.Get(versionedKey(0, "0000/000000"))
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"fetch version ok",
+		"fetch index ok",
+		"miss index",
+		"fetch data ok",
+		"op get ok",
+	}, collector.take())
+	require.Equal(t, Sha256Base64Len, collector.bytes[FetchVersion])
+	require.Greater(t, collector.bytes[FetchIndex], 0)
+	require.Greater(t, collector.bytes[FetchData], 0)
+
+	has, err := db.Has(versionedKey(0, "0001/000001"))
+	require.Nil(t, err)
+	require.True(t, has)
+	require.Equal(t, []string{"hit index", "fetch data ok", "op has ok"}, collector.take())
+
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	for ; iter.Valid(); iter.Next() {
+	}
+	require.Nil(t, iter.Error())
+	require.Nil(t, iter.Close())
+	require.Equal(t, []string{
+		"hit index",
+		"fetch data ok",
+		"op iterator ok",
+		"iterator",
+		"fetch data ok",
+		"fetch data ok",
+	}, collector.take())
+
+	_, err = db.Get(versionedKey(1, "0000/000000"))
+	require.Error(t, err)
+	require.Equal(t, []string{"fetch version not_found", "miss index", "op get not_found"}, collector.take())
+}
+
+func TestMetricsCollectorCaches(t *testing.T) {
+	collector := &recordingCollector{}
+	db := newDecodeTestDB(1, 2, 1, WithIndexCache(1), WithDecodedPayloadCache(1<<20, time.Minute), WithMetricsCollector(collector))
+	for i := 0; i < 3; i++ {
+		_, err := db.Get(versionedKey(0, "0000/000000"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, []string{
+		"fetch version ok", "fetch index ok", "miss index", "miss decoded", "fetch data ok", "op get ok",
+		// admitted once read twice
+		"hit index", "miss decoded", "fetch data ok", "op get ok",
+		"hit index", "hit decoded", "op get ok",
+	}, collector.take())
+}
+
+func TestErrorClass(t *testing.T) {
+	for err, class := range map[error]string{
+		nil:               ErrorClassNone,
+		&ErrKeyNotFound{}: ErrorClassNotFound,
+		fmt.Errorf("wrapped: %w", context.Canceled): ErrorClassCanceled,
+		context.DeadlineExceeded:                    ErrorClassDeadline,
+		&ErrBudgetExhausted{}:                       ErrorClassBudget,
+		&ErrVersionNotYetArchived{}:                 ErrorClassNotYetArchived,
+		&ErrGatewayStatus{statusCode: 429}:          ErrorClassGatewayStatus,
+		&ErrIntegrityCheckFailed{}:                  ErrorClassIntegrity,
+		&ErrCorruptCompression{}:                    ErrorClassCorrupt,
+		errors.New("boom"):                          ErrorClassOther,
+	} {
+		require.Equal(t, class, ErrorClass(err), err)
+	}
+}
@@ -0,0 +1,119 @@
+package backends
+
+import (
+	"context"
+	"errors"
+)
+
+// Getter fetches a blob from Arweave. ArweaveDB uses one Getter to fetch
+// transaction data by transaction ID, and another one to resolve the
+// transaction ID of a version's index.
+type Getter func([]byte) ([]byte, error)
+
+// GetterMiddleware decorates a Getter with additional behavior such as
+// retries, rate limiting, caching or metrics.
+//
+// Middlewares are composed with the first one being the outermost. The
+// recommended ordering, from outermost to innermost, is:
+// 1. cache        - hits never reach any of the layers below
+// 2. singleflight - concurrent misses of the same key share one fetch
+// 3. metrics      - observes logical fetches, including time spent retrying
+// 4. retry        - every attempt goes through the layers below again
+// 5. rate limit   - every attempt, including retries, consumes a token
+type GetterMiddleware func(Getter) Getter
+
+// ChainMiddleware composes the given middlewares into a single one, with
+// mws[0] being the outermost.
+func ChainMiddleware(mws ...GetterMiddleware) GetterMiddleware {
+	return func(getter Getter) Getter {
+		for i := len(mws) - 1; i >= 0; i-- {
+			getter = mws[i](getter)
+		}
+		return getter
+	}
+}
+
+// boundMiddleware is a GetterMiddleware given the context of the getter it
+// is bound for, and rebind, which returns the getter it wraps bound to
+// another context, e.g. for fetches outliving the requests they serve.
+type boundMiddleware func(ctx context.Context, next Getter, rebind func(context.Context) Getter) Getter
+
+// boundGetterMiddleware adapts mw, which fetches with the context of the
+// getter it wraps, to a boundMiddleware.
+func boundGetterMiddleware(mw GetterMiddleware) boundMiddleware {
+	return func(_ context.Context, next Getter, _ func(context.Context) Getter) Getter {
+		return mw(next)
+	}
+}
+
+// ApplyMiddleware wraps both the tx data getter and the version getter of
+// db with the given middlewares, with mws[0] being the outermost. It must be
+// called before db is used concurrently.
+func ApplyMiddleware(db *ArweaveDB, mws ...GetterMiddleware) {
+	chain := ChainMiddleware(mws...)
+	applyGetterMiddleware(db, chain, chain)
+}
+
+// applyGetterMiddleware wraps the tx data getter of db with txData and its
+// version getter with version, both being outermost.
+func applyGetterMiddleware(db *ArweaveDB, txData, version GetterMiddleware) {
+	applyBoundMiddleware(db, boundGetterMiddleware(txData), boundGetterMiddleware(version))
+}
+
+// applyBoundMiddleware is applyGetterMiddleware with bound middlewares.
+func applyBoundMiddleware(db *ArweaveDB, txData, version boundMiddleware) {
+	if db.build != nil {
+		db.build.middlewares = true
+	}
+	ctx := db.context()
+	db.txDataByIdGetter = txData(ctx, db.txDataByIdGetter, db.rebinder(db.txDataSource, db.txDataMiddleware, db.txDataByIdGetter))
+	db.versionTxIdGetter = version(ctx, db.versionTxIdGetter, db.rebinder(db.versionSource, db.versionMiddleware, db.versionTxIdGetter))
+	db.txDataMiddleware = appendMiddleware(txData, db.txDataMiddleware)
+	db.versionMiddleware = appendMiddleware(version, db.versionMiddleware)
+	if db.payloadBounds != nil {
+		db.payloadBounds.reset()
+	}
+	db.ClearIndexCache()
+	db.ClearDecodedPayloadCache()
+}
+
+// rebinder returns a function binding getter, fetching from source through
+// mw, to other contexts. See bindGetter.
+func (db *ArweaveDB) rebinder(source ContextGetter, mw boundMiddleware, getter Getter) func(context.Context) Getter {
+	return func(ctx context.Context) Getter {
+		return db.bindGetter(ctx, source, mw, getter)
+	}
+}
+
+// appendMiddleware returns inner wrapped by outer, either being nil if
+// there is none.
+func appendMiddleware(outer, inner boundMiddleware) boundMiddleware {
+	if inner == nil {
+		return outer
+	}
+	if outer == nil {
+		return inner
+	}
+	return func(ctx context.Context, next Getter, rebind func(context.Context) Getter) Getter {
+		return outer(ctx, inner(ctx, next, rebind), func(ctx context.Context) Getter {
+			return inner(ctx, rebind(ctx), rebind)
+		})
+	}
+}
+
+// ArweaveOption configures an ArweaveDB at construction time.
+type ArweaveOption func(*ArweaveDB)
+
+// WithGetterMiddleware applies the given middlewares to the getters of the
+// ArweaveDB being constructed. See ApplyMiddleware.
+func WithGetterMiddleware(mws ...GetterMiddleware) ArweaveOption {
+	return func(db *ArweaveDB) {
+		for _, mw := range mws {
+			if mw == nil {
+				db.invalidOption(errors.New("WithGetterMiddleware is given a nil middleware"))
+				return
+			}
+		}
+		ApplyMiddleware(db, mws...)
+	}
+}
@@ -0,0 +1,92 @@
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func countingMiddleware(count *int) GetterMiddleware {
+	return func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			*count++
+			return next(key)
+		}
+	}
+}
+
+func retryingMiddleware(attempts int) GetterMiddleware {
+	return func(next Getter) Getter {
+		return func(key []byte) (res []byte, err error) {
+			for i := 0; i < attempts; i++ {
+				if res, err = next(key); err == nil {
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+func failingGetter(failures int) Getter {
+	return func(key []byte) ([]byte, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("transient")
+		}
+		return key, nil
+	}
+}
+
+func TestChainMiddlewareOrdering(t *testing.T) {
+	order := []string{}
+	tracer := func(name string) GetterMiddleware {
+		return func(next Getter) Getter {
+			return func(key []byte) ([]byte, error) {
+				order = append(order, name)
+				return next(key)
+			}
+		}
+	}
+	getter := ChainMiddleware(tracer("outer"), tracer("middle"), tracer("inner"))(failingGetter(0))
+	res, err := getter([]byte("k"))
+	require.Nil(t, err)
+	require.Equal(t, "k", string(res))
+	require.Equal(t, []string{"outer", "middle", "inner"}, order)
+}
+
+func TestRetryOutsideRateLimiter(t *testing.T) {
+	tokens := 0
+	getter := ChainMiddleware(retryingMiddleware(3), countingMiddleware(&tokens))(failingGetter(2))
+	_, err := getter([]byte("k"))
+	require.Nil(t, err)
+	// every attempt consumes a token
+	require.Equal(t, 3, tokens)
+}
+
+func TestRetryInsideRateLimiter(t *testing.T) {
+	tokens := 0
+	getter := ChainMiddleware(countingMiddleware(&tokens), retryingMiddleware(3))(failingGetter(2))
+	_, err := getter([]byte("k"))
+	require.Nil(t, err)
+	// retries bypass the limiter
+	require.Equal(t, 1, tokens)
+}
+
+func TestApplyMiddleware(t *testing.T) {
+	index := mockIndex([]string{"ab"}, []int{0})
+	txData := [][]byte{mockTxData([]string{"aa"}, []string{"v1"})}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0})
+	calls := 0
+	ApplyMiddleware(mockDB, countingMiddleware(&calls))
+
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	value, err := mockDB.Get(append(v0Bz, []byte("aa")...))
+	require.Nil(t, err)
+	require.Equal(t, "v1", string(value))
+	// version lookup, index fetch and data fetch
+	require.Equal(t, 3, calls)
+}
@@ -0,0 +1,168 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// arweaveBuild records what the options given to NewArweaveDBFromOptions
+// configure, so that conflicting ones are rejected.
+type arweaveBuild struct {
+	// options setting the transaction data and version sources, in order
+	txDataSetBy  []string
+	versionSetBy []string
+	// options caching transaction data
+	txCacheSetBy []string
+	// whether getter middlewares were applied, which WithGatewayPool drops
+	middlewares bool
+	errs        []error
+}
+
+// setTxDataSource records that option sets the transaction data source of
+// the DB being built, if any.
+func (db *ArweaveDB) setTxDataSource(option string) {
+	if db.build != nil {
+		db.build.txDataSetBy = append(db.build.txDataSetBy, option)
+	}
+}
+
+// setVersionSource records that option sets the version source of the DB
+// being built, if any.
+func (db *ArweaveDB) setVersionSource(option string) {
+	if db.build != nil {
+		db.build.versionSetBy = append(db.build.versionSetBy, option)
+	}
+}
+
+// setTxCache records that option caches the transaction data of the DB
+// being built, if any.
+func (db *ArweaveDB) setTxCache(option string) {
+	if db.build != nil {
+		db.build.txCacheSetBy = append(db.build.txCacheSetBy, option)
+	}
+}
+
+// invalidOption fails the building of the DB, if any, with err.
+func (db *ArweaveDB) invalidOption(err error) {
+	if db.build != nil {
+		db.build.errs = append(db.build.errs, err)
+	}
+}
+
+// NewArweaveDBFromOptions returns an ArweaveDB configured by opts only, which
+// must set where transaction data and the index transaction IDs of versions
+// are fetched from, e.g. with WithTxDataGetter and WithVersionResolver, or
+// WithGatewayPool. Unlike the other constructors, it fails with a
+// descriptive error if a source is missing, if several options set the
+// same source, as only the last one would be used, if an option is given
+// invalid arguments, or if options conflict:
+//   - WithPersistentCache and WithConditionalCache both cache transaction
+//     data
+//   - WithGatewayPool drops the getter middlewares applied before it, e.g.
+//     by WithFetchRetries or WithGetterMiddleware, which must follow it
+//   - WithStrictMode fails the reads of malformed indices, which
+//     WithIndexValidation(IndexValidationLenient) serves
+//
+// NewArweaveDB keeps its signature, taking the path of the local index
+// database and a client, so that existing callers compile. Cache sizes,
+// fetch concurrency and retries are configured with the options shared by
+// all constructors, e.g. WithCacheSize, WithConcurrency and
+// WithRetryPolicy.
+func NewArweaveDBFromOptions(opts ...ArweaveOption) (*ArweaveDB, error) {
+	db := newBaseArweaveDB()
+	db.build = &arweaveBuild{}
+	for _, opt := range opts {
+		opt(db)
+	}
+	build := db.build
+	db.build = nil
+	errs := build.errs
+	for _, source := range []struct {
+		what, option string
+		setBy        []string
+	}{
+		{"transaction data", "WithTxDataGetter", build.txDataSetBy},
+		{"version", "WithVersionResolver", build.versionSetBy},
+	} {
+		switch {
+		case len(source.setBy) == 0:
+			errs = append(errs, fmt.Errorf("no %s source is set, see %s", source.what, source.option))
+		case len(source.setBy) > 1:
+			errs = append(errs, fmt.Errorf("%s all set the %s source", strings.Join(source.setBy, ", "), source.what))
+		}
+	}
+	if len(build.txCacheSetBy) > 1 {
+		errs = append(errs, fmt.Errorf("%s all cache transaction data", strings.Join(build.txCacheSetBy, ", ")))
+	}
+	if db.strict && db.indexValidation != nil && db.indexValidation.mode == IndexValidationLenient {
+		errs = append(errs, errors.New("WithStrictMode fails the reads of malformed indices, which WithIndexValidation(IndexValidationLenient) serves"))
+	}
+	if len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		return nil, fmt.Errorf("invalid ArweaveDB options: %s", strings.Join(messages, "; "))
+	}
+	return db, nil
+}
+
+// WithCacheSize is WithIndexCache, keeping the parsed indices of up to
+// maxVersions versions.
+func WithCacheSize(maxVersions int) ArweaveOption {
+	return WithIndexCache(maxVersions)
+}
+
+// WithConcurrency is WithFetchConcurrency, reading up to limit payloads
+// ahead of iterators.
+func WithConcurrency(limit int) ArweaveOption {
+	return WithFetchConcurrency(limit)
+}
+
+// WithRetryPolicy is WithFetchRetries, retrying failed fetches according
+// to policy.
+func WithRetryPolicy(policy FetchRetryPolicy) ArweaveOption {
+	return WithFetchRetries(policy)
+}
+
+// WithIndexKeyPrefixLen checks that indices are read with key prefixes of
+// n bytes. The length isn't configurable, being fixed by the published
+// index format, so that any n but IndexKeyPrefixLen is rejected.
+func WithIndexKeyPrefixLen(n int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if n != IndexKeyPrefixLen {
+			db.invalidOption(fmt.Errorf("WithIndexKeyPrefixLen is given %d bytes, but the index format fixes key prefixes to %d", n, IndexKeyPrefixLen))
+		}
+	}
+}
+
+// WithTxDataGetter makes the ArweaveDB fetch transaction data with getter,
+// below the middlewares applied so far. Getters not honoring contexts can
+// be adapted with IgnoringContext.
+func WithTxDataGetter(getter ContextGetter) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if getter == nil {
+			db.invalidOption(errors.New("WithTxDataGetter is given a nil getter"))
+			return
+		}
+		db.setTxDataSource("WithTxDataGetter")
+		db.txDataSource = getter
+		db.txDataByIdGetter = db.bindGetter(db.context(), getter, db.txDataMiddleware, nil)
+	}
+}
+
+// WithVersionResolver makes the ArweaveDB resolve the index transaction IDs
+// of versions with resolver, queried with encoded versions, below the
+// middlewares applied so far. See GraphQLVersionResolver.Resolve.
+func WithVersionResolver(resolver ContextGetter) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if resolver == nil {
+			db.invalidOption(errors.New("WithVersionResolver is given a nil resolver"))
+			return
+		}
+		db.setVersionSource("WithVersionResolver")
+		db.versionSource = resolver
+		db.versionTxIdGetter = db.bindGetter(db.context(), resolver, db.versionMiddleware, nil)
+	}
+}
@@ -0,0 +1,243 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func newOptionsTestSources() (ContextGetter, ContextGetter) {
+	mockDB := NewMockArweaveDB([][]byte{mockIndex([]string{"z"}, []int{0})}, [][]byte{mockTxData([]string{"a"}, []string{"1"})}, []int{0})
+	return IgnoringContext(mockDB.txDataByIdGetter), IgnoringContext(mockDB.versionTxIdGetter)
+}
+
+func TestNewArweaveDBFromOptions(t *testing.T) {
+	txData, version := newOptionsTestSources()
+	db, err := NewArweaveDBFromOptions(WithTxDataGetter(txData), WithVersionResolver(version))
+	require.NoError(t, err)
+	require.Nil(t, db.build)
+
+	value, err := db.Get(versionedKey(0, "a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+	// defaults
+	require.NotNil(t, db.versionProbes)
+	require.Nil(t, db.indexCache)
+	require.Nil(t, db.fetchPool)
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Close())
+}
+
+func TestNewArweaveDBFromOptionsApplyOptions(t *testing.T) {
+	txData, version := newOptionsTestSources()
+	calls := 0
+	counting := func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			calls++
+			return next(key)
+		}
+	}
+	// sources are set below the middlewares applied before them
+	db, err := NewArweaveDBFromOptions(
+		WithGetterMiddleware(counting),
+		WithTxDataGetter(txData),
+		WithVersionResolver(version),
+		WithIndexCache(2),
+		WithFetchConcurrency(4),
+		WithFetchRetries(FetchRetryPolicy{MaxAttempts: 2}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, db.indexCache.maxVersions)
+	require.Equal(t, 4, db.fetchPool.limit)
+
+	_, err = db.Get(versionedKey(0, "a"))
+	require.NoError(t, err)
+	// version, index and payload
+	require.Equal(t, 3, calls)
+}
+
+func TestNewArweaveDBFromOptionsGatewayPool(t *testing.T) {
+	gateways := newArchiveGateways(1)
+	db, err := NewArweaveDBFromOptions(WithGatewayPool(newArchiveGatewayPool(gateways)))
+	require.NoError(t, err)
+	value, err := db.Get(versionedKey(0, "aa"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+}
+
+func TestNewArweaveDBFromOptionsValidation(t *testing.T) {
+	txData, version := newOptionsTestSources()
+	for name, tc := range map[string]struct {
+		opts []ArweaveOption
+		err  string
+	}{
+		"no sources": {
+			err: "no transaction data source is set, see WithTxDataGetter; no version source is set, see WithVersionResolver",
+		},
+		"no version source": {
+			opts: []ArweaveOption{WithTxDataGetter(txData)},
+			err:  "no version source is set",
+		},
+		"nil getter": {
+			opts: []ArweaveOption{WithTxDataGetter(nil), WithVersionResolver(version)},
+			err:  "WithTxDataGetter is given a nil getter",
+		},
+		"conflicting sources": {
+			opts: []ArweaveOption{WithTxDataGetter(txData), WithVersionResolver(version), WithGatewayPool(newArchiveGatewayPool(newArchiveGateways(1)))},
+			err:  "WithTxDataGetter, WithGatewayPool all set the transaction data source; WithVersionResolver, WithGatewayPool all set the version source",
+		},
+		"repeated source": {
+			opts: []ArweaveOption{WithTxDataGetter(txData), WithTxDataGetter(txData), WithVersionResolver(version)},
+			err:  "WithTxDataGetter, WithTxDataGetter all set the transaction data source",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := NewArweaveDBFromOptions(tc.opts...)
+			require.Nil(t, db)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestNewArweaveDBFromOptionsConflicts(t *testing.T) {
+	txData, version := newOptionsTestSources()
+	cache, err := NewPersistentTxCache(dbm.NewMemDB(), 0)
+	require.NoError(t, err)
+	pool := func() ArweaveOption { return WithGatewayPool(newArchiveGatewayPool(newArchiveGateways(1))) }
+	for name, tc := range map[string]struct {
+		opts []ArweaveOption
+		err  string
+	}{
+		"persistent and conditional caches": {
+			opts: []ArweaveOption{WithConditionalCache(cache, NewClient("http://localhost")), WithVersionResolver(version), WithPersistentCache(cache)},
+			err:  "WithConditionalCache, WithPersistentCache all cache transaction data",
+		},
+		"retries before gateway pool": {
+			opts: []ArweaveOption{WithFetchRetries(FetchRetryPolicy{MaxAttempts: 2}), pool()},
+			err:  "WithGatewayPool drops the getter middlewares applied by the options before it, which must follow it",
+		},
+		"middleware before gateway pool": {
+			opts: []ArweaveOption{WithGetterMiddleware(SingleflightMiddleware()), pool()},
+			err:  "WithGatewayPool drops the getter middlewares applied by the options before it, which must follow it",
+		},
+		"strict mode and lenient index validation": {
+			opts: []ArweaveOption{WithTxDataGetter(txData), WithVersionResolver(version), WithIndexValidation(IndexValidationLenient), WithStrictMode()},
+			err:  "WithStrictMode fails the reads of malformed indices, which WithIndexValidation(IndexValidationLenient) serves",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := NewArweaveDBFromOptions(tc.opts...)
+			require.Nil(t, db)
+			require.EqualError(t, err, "invalid ArweaveDB options: "+tc.err)
+		})
+	}
+
+	// the same options don't conflict in another order or on their own
+	for _, opts := range [][]ArweaveOption{
+		{pool(), WithFetchRetries(FetchRetryPolicy{MaxAttempts: 2}), WithRateLimit(0, 1)},
+		{WithRateLimit(0, 1), pool()},
+		{WithTxDataGetter(txData), WithVersionResolver(version), WithPersistentCache(cache)},
+		{WithTxDataGetter(txData), WithVersionResolver(version), WithIndexValidation(IndexValidationStrict), WithStrictMode()},
+	} {
+		db, err := NewArweaveDBFromOptions(opts...)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestNewArweaveDBFromOptionsAliases(t *testing.T) {
+	txData, version := newOptionsTestSources()
+	calls := 0
+	db, err := NewArweaveDBFromOptions(
+		WithTxDataGetter(func(ctx context.Context, txId []byte) ([]byte, error) {
+			if calls++; calls == 1 {
+				return nil, errors.New("transient failure")
+			}
+			return txData(ctx, txId)
+		}),
+		WithVersionResolver(version),
+		WithCacheSize(2),
+		WithConcurrency(4),
+		WithRetryPolicy(FetchRetryPolicy{MaxAttempts: 2}),
+		WithIndexKeyPrefixLen(IndexKeyPrefixLen),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, db.indexCache.maxVersions)
+	require.Equal(t, 4, db.fetchPool.limit)
+
+	value, err := db.Get(versionedKey(0, "a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+	// the index fetch is retried once
+	require.Equal(t, 3, calls)
+}
+
+func TestDeprecatedConstructorsSkipValidation(t *testing.T) {
+	txData, version := newOptionsTestSources()
+	db := NewArweaveDBWithGetters(txData.Getter(), version.Getter(), WithIndexCache(0))
+	require.Nil(t, db.build)
+	value, err := db.Get(versionedKey(0, "a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+}
+
+func TestArweaveOptionsValidation(t *testing.T) {
+	txData, version := newOptionsTestSources()
+	cache, err := NewPersistentTxCache(dbm.NewMemDB(), 0)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		opt ArweaveOption
+		err string
+	}{
+		{WithAttestationSigner(nil), "WithAttestationSigner is given a nil signer"},
+		{WithCheckpointTrust([]byte("key"), 1), "WithCheckpointTrust needs a 32-byte ed25519 public key, got 3 bytes"},
+		{WithConditionalCache(cache, nil), "WithConditionalCache needs both a cache and a client"},
+		{WithDecodeWorkers(-1), "WithDecodeWorkers needs a non-negative number of workers, got -1"},
+		{WithDecodedPayloadCache(0, time.Second), "WithDecodedPayloadCache needs a positive size, got 0"},
+		{WithDecodedPayloadCache(1<<20, -time.Second), "WithDecodedPayloadCache needs a non-negative admission window, got -1s"},
+		{WithDownloadBudget(nil), "WithDownloadBudget is given a nil budget"},
+		{WithEmptyPayloadSkipping(0), "WithEmptyPayloadSkipping needs a positive capacity, got 0"},
+		{WithFetchConcurrency(-1), "WithFetchConcurrency needs a non-negative limit, got -1"},
+		{WithFetchRetries(FetchRetryPolicy{}), "WithFetchRetries needs at least 1 attempt, got 0"},
+		{WithFetchRetries(FetchRetryPolicy{MaxAttempts: 2, InitialDelay: -time.Second}), "WithFetchRetries needs non-negative delays, got -1s and at most 0s"},
+		{WithFetchRetries(FetchRetryPolicy{MaxAttempts: 2, Jitter: 2}), "WithFetchRetries needs a jitter between 0 and 1, got 2"},
+		{WithFutureVersionGrace(-time.Second, 0), "WithFutureVersionGrace needs a non-negative grace period, got -1s"},
+		{WithFutureVersionGrace(time.Second, -time.Second), "WithFutureVersionGrace needs a non-negative poll interval, got -1s"},
+		{WithGatewayPool(nil), "WithGatewayPool is given a nil pool"},
+		{WithGetterMiddleware(nil), "WithGetterMiddleware is given a nil middleware"},
+		{WithIndexCache(0), "WithIndexCache needs a positive number of versions, got 0"},
+		{WithCacheSize(0), "WithIndexCache needs a positive number of versions, got 0"},
+		{WithConcurrency(-1), "WithFetchConcurrency needs a non-negative limit, got -1"},
+		{WithRetryPolicy(FetchRetryPolicy{}), "WithFetchRetries needs at least 1 attempt, got 0"},
+		{WithIndexKeyPrefixLen(64), "WithIndexKeyPrefixLen is given 64 bytes, but the index format fixes key prefixes to 128"},
+		{WithIndexValidation(0), "WithIndexValidation is given unknown mode 0"},
+		{WithIntegrityVerification(nil), "WithIntegrityVerification is given a nil resolver"},
+		{WithIteratorBudget(0), "WithIteratorBudget needs a positive number of bytes, got 0"},
+		{WithMaxDecompressedSize(0), "WithMaxDecompressedSize needs a positive size, got 0"},
+		{WithOwnershipVerification(ArchiveManifest{}), "WithOwnershipVerification is given a manifest pinning no publisher"},
+		{WithOwnershipVerification(ArchiveManifest{PublisherKey: "key"}, nil), "WithOwnershipVerification is given a nil metadata source"},
+		{WithPersistentCache(nil), "WithPersistentCache is given a nil cache"},
+		{WithRateLimit(-1, 1), "WithRateLimit needs a non-negative rate, got -1"},
+		{WithRateLimit(1, 0), "WithRateLimit needs a burst of at least 1 request, got 0"},
+		{WithReadStats(-1), "WithReadStats needs a non-negative number of tracked entries, got -1"},
+		{WithReplayRecording(nil), "WithReplayRecording is given a nil recorder"},
+		{WithRetractions(nil, nil), "WithRetractions is given a nil source"},
+		{WithTracing(-1), "WithTracing needs a non-negative number of recent errors, got -1"},
+		{WithTxDataGetter(nil), "WithTxDataGetter is given a nil getter"},
+		{WithVersionCodec(nil), "WithVersionCodec is given a nil codec"},
+		{WithVersionMapCache(nil), "WithVersionMapCache is given a nil store"},
+		{WithVersionProbeCache(-time.Second), "WithVersionProbeCache needs a non-negative TTL, got -1s"},
+		{WithVersionResolver(nil), "WithVersionResolver is given a nil resolver"},
+		{WithWriter(nil), "WithWriter is given a nil writer"},
+	} {
+		t.Run(tc.err, func(t *testing.T) {
+			db, err := NewArweaveDBFromOptions(WithTxDataGetter(txData), WithVersionResolver(version), tc.opt)
+			require.Nil(t, db)
+			require.EqualError(t, err, "invalid ArweaveDB options: "+tc.err)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package backends
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ArchiveManifest pins the publisher of an archive, whose ownership of the
+// indices is verified by WithOwnershipVerification.
+type ArchiveManifest struct {
+	// PublisherAddress is the Arweave address of the publisher, i.e. the
+	// base64url encoded sha256 digest of its public key.
+	PublisherAddress string `json:"publisher_address"`
+	// PublisherKey is the base64url encoded RSA modulus of the publisher,
+	// as in the owner field of its transactions.
+	PublisherKey string `json:"publisher_key"`
+}
+
+// TxMetadata is the metadata of a transaction, as served by the tx endpoint
+// of gateways.
+type TxMetadata struct {
+	ID string `json:"id"`
+	// base64url encoded RSA modulus of the owner
+	Owner string `json:"owner"`
+	// base64url encoded signature, whose sha256 digest is the transaction
+	// ID
+	Signature string `json:"signature"`
+}
+
+// TxMetadataGetter fetches the metadata of the transaction txId.
+type TxMetadataGetter func(txId []byte) (*TxMetadata, error)
+
+// MetadataGateway is implemented by gateways serving transaction metadata.
+// Client implements it.
+type MetadataGateway interface {
+	Gateway
+	TransactionMetadataContext(ctx context.Context, id string) (*TxMetadata, error)
+}
+
+type ownership struct {
+	manifest ArchiveManifest
+	sources  []TxMetadataGetter
+
+	mtx sync.Mutex
+	// verdicts by index tx ID, nil for trusted indices
+	verdicts map[string]error
+}
+
+// WithOwnershipVerification makes the ArweaveDB verify that the indices it
+// fetches were published by the publisher pinned by manifest before trusting
+// them, rather than whatever the version getter returns. The metadata of
+// every index transaction is fetched from sources in order, by default the
+// MetadataGateways of the gateway pool or the client, and the index is
+// trusted once metadata signed by the publisher is served for it. Metadata
+// failing the check is fetched from the next source, as gateways may serve
+// fabricated metadata too, and indices are failed with ErrUntrustedIndex if
+// none verifies. Verdicts are cached per transaction, except those reached
+// with sources failing to serve the metadata.
+//
+// The verification is a light one: the owner must be the pinned publisher,
+// and the transaction ID the digest of the signature. The signature itself
+// isn't checked against the owner.
+func WithOwnershipVerification(manifest ArchiveManifest, sources ...TxMetadataGetter) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if manifest.PublisherAddress == "" && manifest.PublisherKey == "" {
+			db.invalidOption(errors.New("WithOwnershipVerification is given a manifest pinning no publisher"))
+		}
+		for _, source := range sources {
+			if source == nil {
+				db.invalidOption(errors.New("WithOwnershipVerification is given a nil metadata source"))
+				return
+			}
+		}
+		db.ownership = &ownership{
+			manifest: manifest,
+			sources:  sources,
+			verdicts: map[string]error{},
+		}
+	}
+}
+
+// metadataSources returns the sources of transaction metadata of the DB.
+func (db *ArweaveDB) metadataSources() []TxMetadataGetter {
+	if len(db.ownership.sources) > 0 {
+		return db.ownership.sources
+	}
+	ctx := db.context()
+	gatewaySource := func(gateway MetadataGateway) TxMetadataGetter {
+		return func(txId []byte) (*TxMetadata, error) {
+			return gateway.TransactionMetadataContext(ctx, string(txId))
+		}
+	}
+	sources := []TxMetadataGetter{}
+	if db.gatewayPool != nil {
+		for _, gateway := range db.gatewayPool.Order() {
+			if gateway, ok := gateway.(MetadataGateway); ok {
+				sources = append(sources, gatewaySource(gateway))
+			}
+		}
+	} else if db.client != nil {
+		sources = append(sources, gatewaySource(db.client))
+	}
+	return sources
+}
+
+// verifyIndexOwner fails with ErrUntrustedIndex unless the index txId was
+// published by the pinned publisher, if the DB verifies ownership.
+func (db *ArweaveDB) verifyIndexOwner(txId []byte) error {
+	o := db.ownership
+	if o == nil {
+		return nil
+	}
+	o.mtx.Lock()
+	verdict, ok := o.verdicts[string(txId)]
+	o.mtx.Unlock()
+	if ok {
+		return verdict
+	}
+	sources := db.metadataSources()
+	if len(sources) == 0 {
+		return &ErrUntrustedIndex{txId: string(txId), reason: "no source of transaction metadata"}
+	}
+	var err error
+	fetchFailed := false
+	for _, source := range sources {
+		var metadata *TxMetadata
+		if metadata, err = source(txId); err != nil {
+			fetchFailed = true
+			continue
+		}
+		if err = o.manifest.verify(txId, metadata); err == nil {
+			break
+		}
+	}
+	if err == nil || !fetchFailed {
+		o.mtx.Lock()
+		o.verdicts[string(txId)] = err
+		o.mtx.Unlock()
+	}
+	return err
+}
+
+// verify fails with ErrUntrustedIndex unless metadata is that of txId,
+// signed by the publisher.
+func (m ArchiveManifest) verify(txId []byte, metadata *TxMetadata) error {
+	untrusted := func(reason string) error {
+		return &ErrUntrustedIndex{txId: string(txId), owner: metadata.Owner, reason: reason}
+	}
+	if m.PublisherAddress == "" && m.PublisherKey == "" {
+		return untrusted("no publisher pinned")
+	}
+	if metadata.ID != "" && canonicalTxId([]byte(metadata.ID)) != canonicalTxId(txId) {
+		return untrusted("metadata of transaction " + metadata.ID)
+	}
+	if metadata.Owner == "" {
+		return untrusted("no owner")
+	}
+	if metadata.Signature == "" {
+		return untrusted("no signature")
+	}
+	owner, err := base64.RawURLEncoding.DecodeString(metadata.Owner)
+	if err != nil {
+		return untrusted("undecodable owner")
+	}
+	if m.PublisherKey != "" && metadata.Owner != m.PublisherKey {
+		return untrusted("owner is not the publisher")
+	}
+	if address := sha256.Sum256(owner); m.PublisherAddress != "" && base64.RawURLEncoding.EncodeToString(address[:]) != m.PublisherAddress {
+		return untrusted("owner address is not the publisher's")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(metadata.Signature)
+	if err != nil {
+		return untrusted("undecodable signature")
+	}
+	if id := sha256.Sum256(signature); base64.RawURLEncoding.EncodeToString(id[:]) != canonicalTxId(txId) {
+		return untrusted("signature is not that of the transaction")
+	}
+	return nil
+}
+
+// canonicalTxId returns txId as the unpadded base64url encoding gateways
+// use, index entries holding IDs padded to Sha256Base64Len.
+func canonicalTxId(txId []byte) string {
+	id := strings.TrimRight(string(txId), "=\x00")
+	return strings.NewReplacer("+", "-", "/", "_").Replace(id)
+}
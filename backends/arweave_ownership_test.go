@@ -0,0 +1,149 @@
+package backends
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	publisherKey    = base64.RawURLEncoding.EncodeToString([]byte("publisher modulus"))
+	impostorKey     = base64.RawURLEncoding.EncodeToString([]byte("impostor modulus"))
+	publisherAddr   = addressOf([]byte("publisher modulus"))
+	publisherPinned = ArchiveManifest{PublisherAddress: publisherAddr, PublisherKey: publisherKey}
+)
+
+func addressOf(modulus []byte) string {
+	digest := sha256.Sum256(modulus)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// signedMetadata returns the metadata of transaction intToBase64Sha256(i),
+// owned by owner: its signature is the one hashing to its ID.
+func signedMetadata(i int, owner string) *TxMetadata {
+	signature := make([]byte, 4)
+	binary.BigEndian.PutUint32(signature, uint32(i))
+	return &TxMetadata{Owner: owner, Signature: base64.RawURLEncoding.EncodeToString(signature)}
+}
+
+// metadataSource serves metadata, counting the requests.
+func metadataSource(metadata *TxMetadata, err error, calls *int) TxMetadataGetter {
+	return func([]byte) (*TxMetadata, error) {
+		*calls++
+		return metadata, err
+	}
+}
+
+func newOwnershipTestDB(sources ...TxMetadataGetter) *ArweaveDB {
+	index := mockIndex([]string{"ab"}, []int{0})
+	db := NewMockArweaveDB([][]byte{index}, [][]byte{mockTxData([]string{"aa"}, []string{"1"})}, []int{0})
+	WithOwnershipVerification(publisherPinned, sources...)(db)
+	return db
+}
+
+func TestOwnershipVerification(t *testing.T) {
+	calls := 0
+	db := newOwnershipTestDB(metadataSource(signedMetadata(1, publisherKey), nil, &calls))
+	for i := 0; i < 2; i++ {
+		value, err := db.Get(versionedKey(0, "aa"))
+		require.Nil(t, err)
+		require.Equal(t, []byte("1"), value)
+	}
+	// the verdict is cached
+	require.Equal(t, 1, calls)
+
+	// metadata of another transaction
+	db = newOwnershipTestDB(metadataSource(signedMetadata(0, publisherKey), nil, &calls))
+	_, err := db.Get(versionedKey(0, "aa"))
+	untrusted := &ErrUntrustedIndex{}
+	require.True(t, errors.As(err, &untrusted))
+	require.Equal(t, intToBase64Sha256(1), untrusted.TxId())
+	require.Contains(t, err.Error(), "signature is not that of the transaction")
+}
+
+func TestOwnershipVerificationOwnerMismatch(t *testing.T) {
+	calls := 0
+	db := newOwnershipTestDB(metadataSource(signedMetadata(1, impostorKey), nil, &calls))
+	fetched := 0
+	countingTxDataGetter(db, &fetched)
+	for i := 0; i < 2; i++ {
+		_, err := db.Get(versionedKey(0, "aa"))
+		untrusted := &ErrUntrustedIndex{}
+		require.True(t, errors.As(err, &untrusted))
+		require.Equal(t, impostorKey, untrusted.Owner())
+		require.Equal(t, ErrorClassIntegrity, ErrorClass(err))
+	}
+	// untrusted indices aren't downloaded, and the verdict is cached
+	require.Equal(t, 0, fetched)
+	require.Equal(t, 1, calls)
+
+	// pinning the address only
+	db = newOwnershipTestDB(metadataSource(signedMetadata(1, impostorKey), nil, &calls))
+	db.ownership.manifest = ArchiveManifest{PublisherAddress: publisherAddr}
+	_, err := db.Get(versionedKey(0, "aa"))
+	require.Contains(t, err.Error(), "owner address is not the publisher's")
+	db = newOwnershipTestDB(metadataSource(signedMetadata(1, publisherKey), nil, &calls))
+	db.ownership.manifest = ArchiveManifest{PublisherAddress: publisherAddr}
+	_, err = db.Get(versionedKey(0, "aa"))
+	require.Nil(t, err)
+}
+
+func TestOwnershipVerificationFallsBack(t *testing.T) {
+	forgedCalls, unsignedCalls, failingCalls, trustedCalls := 0, 0, 0, 0
+	forged := metadataSource(signedMetadata(1, impostorKey), nil, &forgedCalls)
+	unsigned := metadataSource(&TxMetadata{Owner: publisherKey}, nil, &unsignedCalls)
+	failing := metadataSource(nil, errors.New("gateway down"), &failingCalls)
+	trusted := metadataSource(signedMetadata(1, publisherKey), nil, &trustedCalls)
+
+	// the next source is asked when metadata fails the check
+	db := newOwnershipTestDB(forged, unsigned, trusted)
+	_, err := db.Get(versionedKey(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, []int{1, 1, 1}, []int{forgedCalls, unsignedCalls, trustedCalls})
+
+	// verdicts reached while a source failed aren't cached
+	db = newOwnershipTestDB(unsigned, failing)
+	for i := 0; i < 2; i++ {
+		_, err = db.Get(versionedKey(0, "aa"))
+		require.NotNil(t, err)
+	}
+	require.Equal(t, 2, failingCalls)
+	require.Equal(t, 3, unsignedCalls)
+
+	// without a signature
+	db = newOwnershipTestDB(unsigned)
+	_, err = db.Get(versionedKey(0, "aa"))
+	require.True(t, errors.As(err, new(*ErrUntrustedIndex)))
+	require.Contains(t, err.Error(), "no signature")
+}
+
+func TestOwnershipVerificationThroughGateways(t *testing.T) {
+	newMetadataServer := func(metadata *TxMetadata) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/tx/"+intToBase64Sha256(1), r.URL.Path)
+			require.Nil(t, json.NewEncoder(w).Encode(metadata))
+		}))
+	}
+	forged := newMetadataServer(signedMetadata(1, impostorKey))
+	defer forged.Close()
+	trusted := newMetadataServer(signedMetadata(1, publisherKey))
+	defer trusted.Close()
+
+	db := newOwnershipTestDB()
+	db.gatewayPool = NewGatewayPool([]Gateway{NewClient(forged.URL)})
+	_, err := db.Get(versionedKey(0, "aa"))
+	require.True(t, errors.As(err, new(*ErrUntrustedIndex)))
+
+	db = newOwnershipTestDB()
+	db.gatewayPool = NewGatewayPool([]Gateway{NewClient(forged.URL), NewClient(trusted.URL)})
+	value, err := db.Get(versionedKey(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), value)
+}
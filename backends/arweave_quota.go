@@ -0,0 +1,193 @@
+package backends
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+var downloadBudgetKey = mustReserveSubspace("arweave_download_budget").Key([]byte("usage"))
+
+// the window of a DownloadBudget is tracked with that many counters
+const downloadBudgetBuckets = 60
+
+// DownloadBudget caps the bytes downloaded from gateways over a rolling time
+// window. A fetch is admitted as long as the bytes downloaded within the
+// window are below the budget, so the budget may be exceeded by the size of
+// one transaction.
+type DownloadBudget struct {
+	maxBytes int64
+	window   time.Duration
+	store    dbm.DB
+	now      func() time.Time
+
+	mtx     sync.Mutex
+	buckets []budgetBucket
+}
+
+type budgetBucket struct {
+	Start int64 `json:"start"`
+	Bytes int64 `json:"bytes"`
+}
+
+// NewDownloadBudget returns a budget of maxBytes per window. If store is
+// set, usage is persisted to it on every fetch, on a best effort basis, and
+// loaded back from it, so that restarts don't reset the budget.
+func NewDownloadBudget(maxBytes int64, window time.Duration, store dbm.DB) (*DownloadBudget, error) {
+	b := &DownloadBudget{maxBytes: maxBytes, window: window, now: time.Now}
+	if store != nil {
+		b.store = metadataDB(store)
+		bz, err := store.Get(downloadBudgetKey)
+		if err != nil {
+			return nil, err
+		}
+		if bz != nil {
+			if err := json.Unmarshal(bz, &b.buckets); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// WithDownloadBudget fails fetches with ErrBudgetExhausted once budget is
+// exhausted, unless made through a view with ReadOptions.BudgetExempt set.
+// Exempt fetches still count against the budget.
+func WithDownloadBudget(budget *DownloadBudget) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if budget == nil {
+			db.invalidOption(errors.New("WithDownloadBudget is given a nil budget"))
+		}
+		db.downloadBudget = budget
+	}
+}
+
+// prune drops the buckets which left the window. It must be called with mtx
+// held.
+func (b *DownloadBudget) prune(now time.Time) {
+	cutoff := now.Add(-b.window).UnixNano()
+	i := 0
+	for i < len(b.buckets) && b.buckets[i].Start <= cutoff {
+		i++
+	}
+	b.buckets = b.buckets[i:]
+}
+
+func (b *DownloadBudget) used() int64 {
+	used := int64(0)
+	for _, bucket := range b.buckets {
+		used += bucket.Bytes
+	}
+	return used
+}
+
+// admit returns ErrBudgetExhausted if the budget is exhausted.
+func (b *DownloadBudget) admit() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	b.prune(now)
+	used := b.used()
+	if used < b.maxBytes {
+		return nil
+	}
+	// the budget frees up as the oldest buckets leave the window
+	resetAt := now.Add(b.window)
+	for _, bucket := range b.buckets {
+		used -= bucket.Bytes
+		if used < b.maxBytes {
+			resetAt = time.Unix(0, bucket.Start).Add(b.window)
+			break
+		}
+	}
+	return &ErrBudgetExhausted{resetAt: resetAt}
+}
+
+func (b *DownloadBudget) record(bytes int64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	b.prune(now)
+	bucketWidth := int64(b.window) / downloadBudgetBuckets
+	if n := len(b.buckets); n > 0 && now.UnixNano()-b.buckets[n-1].Start < bucketWidth {
+		b.buckets[n-1].Bytes += bytes
+	} else {
+		b.buckets = append(b.buckets, budgetBucket{Start: now.UnixNano(), Bytes: bytes})
+	}
+	if b.store == nil {
+		return
+	}
+	if bz, err := json.Marshal(b.buckets); err == nil {
+		_ = b.store.Set(downloadBudgetKey, bz)
+	}
+}
+
+// Usage returns the bytes downloaded within the window, and when the budget
+// will run out at the average rate of the window, or the zero time if
+// nothing was downloaded.
+func (b *DownloadBudget) Usage() (used int64, exhaustedAt time.Time) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	b.prune(now)
+	used = b.used()
+	if used == 0 {
+		return 0, time.Time{}
+	}
+	if used >= b.maxBytes {
+		return used, now
+	}
+	elapsed := now.Sub(time.Unix(0, b.buckets[0].Start))
+	if elapsed <= 0 {
+		elapsed = time.Duration(int64(b.window) / downloadBudgetBuckets)
+	}
+	rate := float64(used) / float64(elapsed)
+	return used, now.Add(time.Duration(float64(b.maxBytes-used) / rate))
+}
+
+func (b *DownloadBudget) stats(stats map[string]string) {
+	used, exhaustedAt := b.Usage()
+	remaining := b.maxBytes - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	stats["download_budget_used"] = strconv.FormatInt(used, 10)
+	stats["download_budget_remaining"] = strconv.FormatInt(remaining, 10)
+	if !exhaustedAt.IsZero() {
+		stats["download_budget_exhausted_at"] = exhaustedAt.UTC().Format(time.RFC3339)
+	}
+}
+
+// fetchTxData fetches the data of a transaction of kind within the
+// download budget.
+func (db *ArweaveDB) fetchTxData(kind FetchKind, txId []byte) ([]byte, error) {
+	return db.fetchVerifiedTxData(kind, txId)
+}
+
+// fetchTxDataWith is like fetchTxData, fetching with getter once. Data is
+// accounted for and verified as downloaded. It is returned as stored, i.e.
+// compressed if it is.
+func (db *ArweaveDB) fetchTxDataWith(kind FetchKind, getter Getter, txId []byte) ([]byte, error) {
+	if db.downloadBudget != nil && !db.readOptions.BudgetExempt {
+		if err := db.downloadBudget.admit(); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	data, err := db.callGetter(getter, txId)
+	if db.downloadBudget != nil && len(data) > 0 {
+		db.downloadBudget.record(int64(len(data)))
+	}
+	if err == nil {
+		err = db.verifyTxData(txId, data)
+	}
+	db.recordFetch(kind, len(data), time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,110 @@
+package backends
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func newBudgetedMockDB(t *testing.T, clock *fakeClock, maxBytes int64, store dbm.DB) (*ArweaveDB, *DownloadBudget) {
+	budget, err := NewDownloadBudget(maxBytes, time.Hour, store)
+	require.Nil(t, err)
+	budget.now = clock.Now
+	index := mockIndex([]string{"b", "d"}, []int{0, 1})
+	txData := [][]byte{
+		mockTxData([]string{"a"}, []string{"v1"}),
+		mockTxData([]string{"c"}, []string{"v2"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	WithDownloadBudget(budget)(mockDB)
+	return mockDB, budget
+}
+
+func budgetKey(key string) []byte {
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	return append(v0Bz, key...)
+}
+
+func TestDownloadBudget(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := &fakeClock{now: start}
+	// the index and one payload
+	mockDB, budget := newBudgetedMockDB(t, clock, 2*IndexEntryLen+10, nil)
+	value, err := mockDB.Get(budgetKey("a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v1"), value)
+	used, _ := budget.Usage()
+	require.Equal(t, int64(2*IndexEntryLen+len(`{"a":"v1"}`)), used)
+
+	clock.Advance(10 * time.Minute)
+	_, err = mockDB.Get(budgetKey("c"))
+	exhausted := &ErrBudgetExhausted{}
+	require.ErrorAs(t, err, &exhausted)
+	require.Equal(t, start.Add(time.Hour), exhausted.ResetAt())
+
+	// exempt reads still go through, and count
+	value, err = mockDB.WithReadOptions(ReadOptions{BudgetExempt: true}).Get(budgetKey("c"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v2"), value)
+	used, _ = budget.Usage()
+	require.Equal(t, int64(4*IndexEntryLen+2*len(`{"a":"v1"}`)), used)
+
+	// the first reads leave the window, but not the exempt ones
+	clock.Advance(55 * time.Minute)
+	used, _ = budget.Usage()
+	require.Equal(t, int64(2*IndexEntryLen+len(`{"a":"v1"}`)), used)
+	_, err = mockDB.Get(budgetKey("c"))
+	require.ErrorAs(t, err, &exhausted)
+	require.Equal(t, start.Add(70*time.Minute), exhausted.ResetAt())
+
+	clock.Advance(10 * time.Minute)
+	value, err = mockDB.Get(budgetKey("c"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v2"), value)
+}
+
+func TestDownloadBudgetPersistence(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := dbm.NewMemDB()
+	mockDB, _ := newBudgetedMockDB(t, clock, 2*IndexEntryLen+1, store)
+	_, err := mockDB.Get(budgetKey("a"))
+	require.Nil(t, err)
+
+	// restart
+	clock.Advance(time.Minute)
+	mockDB, budget := newBudgetedMockDB(t, clock, 2*IndexEntryLen+1, store)
+	used, _ := budget.Usage()
+	require.Equal(t, int64(2*IndexEntryLen+len(`{"a":"v1"}`)), used)
+	_, err = mockDB.Get(budgetKey("a"))
+	require.ErrorAs(t, err, new(*ErrBudgetExhausted))
+
+	// usage expires across restarts too
+	clock.Advance(time.Hour)
+	_, budget = newBudgetedMockDB(t, clock, 2*IndexEntryLen+1, store)
+	used, _ = budget.Usage()
+	require.Equal(t, int64(0), used)
+}
+
+func TestDownloadBudgetStats(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	mockDB, _ := newBudgetedMockDB(t, clock, 10000, nil)
+	stats := mockDB.Stats()
+	require.Equal(t, "0", stats["download_budget_used"])
+	require.Equal(t, "10000", stats["download_budget_remaining"])
+	require.Empty(t, stats["download_budget_exhausted_at"])
+
+	_, err := mockDB.Get(budgetKey("a"))
+	require.Nil(t, err)
+	clock.Advance(time.Minute)
+	used := 2*IndexEntryLen + len(`{"a":"v1"}`)
+	stats = mockDB.Stats()
+	require.Equal(t, "354", stats["download_budget_used"])
+	require.Equal(t, "9646", stats["download_budget_remaining"])
+	// 354 bytes per minute
+	projected := clock.now.Add(time.Duration(float64(10000-used) / float64(used) * float64(time.Minute)))
+	require.Equal(t, projected.UTC().Format(time.RFC3339), stats["download_budget_exhausted_at"])
+}
@@ -0,0 +1,138 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the requests an ArweaveDB makes
+// to gateways, shared by its views, Gets and iterators.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+
+	mtx sync.Mutex
+	// negative when requests wait for tokens
+	tokens   float64
+	refillAt time.Time
+}
+
+// WithRateLimit limits the transaction data and version lookups of the DB
+// to requestsPerSecond on average, letting bursts of up to burst requests
+// through, so that long iterations don't get throttled by gateways. The
+// limit is a middleware applied innermost, below the middlewares applied
+// before or after it: every attempt reaching the getters takes a token,
+// retries included, while fetches served by middlewares such as caches
+// don't. Requests waiting for a token give up once the context of the view
+// they are made through is done. A zero rate means unlimited.
+func WithRateLimit(requestsPerSecond float64, burst int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		switch {
+		case requestsPerSecond < 0 || math.IsNaN(requestsPerSecond):
+			db.invalidOption(fmt.Errorf("WithRateLimit needs a non-negative rate, got %g", requestsPerSecond))
+		case requestsPerSecond > 0 && burst < 1:
+			db.invalidOption(fmt.Errorf("WithRateLimit needs a burst of at least 1 request, got %d", burst))
+		}
+		if requestsPerSecond <= 0 || math.IsNaN(requestsPerSecond) {
+			if db.rateLimiter != nil {
+				db.rateLimiter.setRate(0, 1)
+			}
+			return
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		if db.rateLimiter != nil {
+			db.rateLimiter.setRate(requestsPerSecond, float64(burst))
+			return
+		}
+		db.rateLimiter = &rateLimiter{
+			rate:   requestsPerSecond,
+			burst:  float64(burst),
+			now:    time.Now,
+			after:  time.After,
+			tokens: float64(burst),
+		}
+		// the getters of DBs without sources, e.g. fixtures, become their
+		// sources
+		if db.txDataSource == nil {
+			db.txDataSource, db.txDataMiddleware = IgnoringContext(db.txDataByIdGetter), nil
+		}
+		if db.versionSource == nil {
+			db.versionSource, db.versionMiddleware = IgnoringContext(db.versionTxIdGetter), nil
+		}
+		limit := db.rateLimiter.middleware()
+		db.txDataMiddleware = appendMiddleware(db.txDataMiddleware, limit)
+		db.versionMiddleware = appendMiddleware(db.versionMiddleware, limit)
+		db.txDataByIdGetter = db.bindGetter(db.context(), db.txDataSource, db.txDataMiddleware, nil)
+		db.versionTxIdGetter = db.bindGetter(db.context(), db.versionSource, db.versionMiddleware, nil)
+	}
+}
+
+// setRate changes the rate and burst of l, a zero rate letting every
+// request through.
+func (l *rateLimiter) setRate(rate, burst float64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.rate, l.burst = rate, burst
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+}
+
+// middleware returns a middleware waiting for a token before each request,
+// see wait.
+func (l *rateLimiter) middleware() boundMiddleware {
+	return func(ctx context.Context, next Getter, _ func(context.Context) Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			if err := l.wait(ctx); err != nil {
+				return nil, err
+			}
+			return next(key)
+		}
+	}
+}
+
+// reserve takes a token and returns how long to wait for it.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := l.now()
+	if !l.refillAt.IsZero() {
+		l.tokens += now.Sub(l.refillAt).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.refillAt = now
+	if l.rate <= 0 {
+		return 0
+	}
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait waits for a token, giving it back if ctx is done first.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-l.after(delay):
+		return nil
+	case <-ctx.Done():
+		l.mtx.Lock()
+		l.tokens++
+		l.mtx.Unlock()
+		return ctx.Err()
+	}
+}
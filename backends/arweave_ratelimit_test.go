@@ -0,0 +1,129 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// virtualClock is a clock whose timers fire at once, advancing it.
+type virtualClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if end := c.now.Add(d); end.After(c.now) {
+		c.now = end
+	}
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func newRateLimitTestDB(rate float64, burst int) (*ArweaveDB, *virtualClock, *int) {
+	db := newDecodeTestDB(10, 2, 1, WithIndexCache(1), WithRateLimit(rate, burst))
+	clock := &virtualClock{now: time.Unix(0, 0)}
+	if db.rateLimiter != nil {
+		db.rateLimiter.now, db.rateLimiter.after = clock.Now, clock.After
+	}
+	calls := 0
+	countingTxDataGetter(db, &calls)
+	return db, clock, &calls
+}
+
+func TestRateLimit(t *testing.T) {
+	db, clock, calls := newRateLimitTestDB(5, 2)
+	start := clock.Now()
+	// a version lookup, the index and 10 payloads
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	keys := 0
+	for ; iter.Valid(); iter.Next() {
+		keys++
+	}
+	require.Nil(t, iter.Error())
+	require.Nil(t, iter.Close())
+	require.Equal(t, 20, keys)
+	require.Equal(t, 11, *calls)
+	// 2 requests let through by the burst, 10 more at 5 per second
+	require.GreaterOrEqual(t, clock.Now().Sub(start), 2*time.Second)
+
+	// tokens refill up to the burst, the index being cached
+	clock.After(time.Hour)
+	start = clock.Now()
+	for i := 0; i < 2; i++ {
+		_, err := db.Get(versionedKey(0, "0000/000000"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, time.Duration(0), clock.Now().Sub(start))
+	_, err = db.Get(versionedKey(0, "0000/000000"))
+	require.Nil(t, err)
+	require.Equal(t, 200*time.Millisecond, clock.Now().Sub(start))
+}
+
+func TestRateLimitUnlimited(t *testing.T) {
+	db, _, calls := newRateLimitTestDB(0, 2)
+	require.Nil(t, db.rateLimiter)
+	for i := 0; i < 20; i++ {
+		_, err := db.Get(versionedKey(0, "0000/000000"))
+		require.Nil(t, err)
+	}
+	require.Equal(t, 21, *calls)
+}
+
+func TestRateLimitCanceled(t *testing.T) {
+	// the version lookup takes the only token, the index waits for the
+	// next one
+	db := newDecodeTestDB(1, 2, 1, WithRateLimit(0.001, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := db.WithContext(ctx).Get(versionedKey(0, "0000/000000"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+	// the token of the canceled request is given back
+	require.InDelta(t, 0, db.rateLimiter.tokens, 0.01)
+}
+
+func TestRateLimitRetries(t *testing.T) {
+	retries := WithFetchRetries(FetchRetryPolicy{MaxAttempts: 3, Retryable: func(error) bool { return true }})
+	for name, opts := range map[string][]ArweaveOption{
+		"retries first": {retries, WithRateLimit(5, 1)},
+		"limit first":   {WithRateLimit(5, 1), retries},
+	} {
+		base := newDecodeTestDB(1, 2, 1)
+		payloadTxId := intToBase64Sha256(0)
+		attempts := 0
+		db := NewArweaveDBWithGetters(func(txId []byte) ([]byte, error) {
+			if string(txId) == payloadTxId {
+				// the first 2 attempts fail
+				if attempts++; attempts <= 2 {
+					return nil, errors.New("unavailable")
+				}
+			}
+			return base.txDataByIdGetter(txId)
+		}, base.versionTxIdGetter, opts...)
+		clock := &virtualClock{now: time.Unix(0, 0)}
+		db.rateLimiter.now, db.rateLimiter.after = clock.Now, clock.After
+
+		_, err := db.Get(versionedKey(0, "0000/000000"))
+		require.Nil(t, err, name)
+		require.Equal(t, 3, attempts, name)
+		// the version lookup takes the burst, the index and the 3 attempts
+		// at the payload wait for a token each
+		require.Equal(t, 800*time.Millisecond, clock.Now().Sub(time.Unix(0, 0)), name)
+	}
+}
@@ -0,0 +1,122 @@
+package backends
+
+import (
+	"bytes"
+	"encoding/json"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// ValueProvenance tells where a value was read from.
+type ValueProvenance struct {
+	Version uint64
+	TxId    string
+	// the index entry pointing to the transaction
+	Entry IndexEntryDescription
+}
+
+// RawResult is the payload holding a key, for independent verification
+// against the transaction stored on Arweave.
+type RawResult struct {
+	ValueProvenance
+	Payload []byte
+	// JSONPath locates the key within JSON payloads, and is empty for
+	// binary ones.
+	JSONPath string
+	// ValueOffset and ValueLen delimit the value within the payload, JSON
+	// encoded in JSON payloads, or are -1 if they can't be determined.
+	ValueOffset int
+	ValueLen    int
+}
+
+// ProvenanceIterator is implemented by iterators able to tell where their
+// current value was read from.
+type ProvenanceIterator interface {
+	dbm.Iterator
+	ValueProvenance() ValueProvenance
+}
+
+var _ ProvenanceIterator = (*arweaveDBIterator)(nil)
+
+// GetRaw returns the payload holding key at the given version, fetched like
+// Get would.
+func (db *ArweaveDB) GetRaw(version uint64, key []byte) (RawResult, error) {
+	index, err := db.getIndex(version)
+	if err != nil {
+		return RawResult{}, err
+	}
+	entries := lookupIndexEntries(string(key), index)
+	for _, entry := range entries {
+		payload, err := db.fetchTxData(FetchData, entry.txId)
+		if err == nil {
+			payload, err = decompressPayload(entry, payload, db.decompressionLimit())
+		}
+		if err != nil {
+			return RawResult{}, err
+		}
+		keyvalues, err := decodePayload(payload)
+		if err != nil {
+			return RawResult{}, err
+		}
+		if _, ok := keyvalues[string(key)]; !ok {
+			continue
+		}
+		result := RawResult{
+			ValueProvenance: ValueProvenance{
+				Version: version,
+				TxId:    string(entry.txId),
+				Entry:   describeIndexEntry(entry),
+			},
+			Payload: payload,
+		}
+		if isBinaryPayload(payload) {
+			result.ValueOffset, result.ValueLen = locateBinaryValue(payload, string(key))
+			return result, nil
+		}
+		path, err := json.Marshal(string(key))
+		if err != nil {
+			return RawResult{}, err
+		}
+		result.JSONPath = "$[" + string(path) + "]"
+		result.ValueOffset, result.ValueLen = locateJSONValue(payload, string(key))
+		return result, nil
+	}
+	return RawResult{}, newKeyNotFoundInVersion(key, version, entries)
+}
+
+// locateJSONValue returns the offset and length of the value of key in the
+// JSON object payload, or -1s if not found.
+func locateJSONValue(payload []byte, key string) (int, int) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return -1, -1
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return -1, -1
+		}
+		raw := json.RawMessage{}
+		if err := dec.Decode(&raw); err != nil {
+			return -1, -1
+		}
+		if tok == key {
+			end := int(dec.InputOffset())
+			return end - len(raw), len(raw)
+		}
+	}
+	return -1, -1
+}
+
+// ValueProvenance implements ProvenanceIterator.
+func (itr *arweaveDBIterator) ValueProvenance() ValueProvenance {
+	if itr.finished {
+		return ValueProvenance{}
+	}
+	entry := itr.entries[itr.txIdx]
+	return ValueProvenance{
+		Version: itr.version,
+		TxId:    string(entry.txId),
+		Entry:   describeIndexEntry(entry),
+	}
+}
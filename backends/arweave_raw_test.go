@@ -0,0 +1,76 @@
+package backends
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRaw(t *testing.T) {
+	index := mockIndex([]string{"b", "d", "d"}, []int{0, 1, 2})
+	txData := [][]byte{
+		mockTxData([]string{"a", "b"}, []string{"va", "vb"}),
+		mockTxData([]string{"c"}, []string{"vc"}),
+		mockTxData([]string{"cc", "d"}, []string{"v\"cc", "vd"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1, 2})
+	fetches := 0
+	ApplyMiddleware(mockDB, countingMiddleware(&fetches))
+
+	for _, tc := range []struct {
+		key     string
+		tx      int
+		prefix  string
+		encoded string
+	}{
+		{"a", 0, "b", `"va"`},
+		{"b", 0, "b", `"vb"`},
+		{"c", 1, "d", `"vc"`},
+		// found in the second entry with a duplicate prefix
+		{"cc", 2, "d", `"v\"cc"`},
+	} {
+		raw, err := mockDB.GetRaw(0, []byte(tc.key))
+		require.Nil(t, err)
+		require.Equal(t, uint64(0), raw.Version)
+		require.Equal(t, intToBase64Sha256(tc.tx), raw.TxId)
+		require.Equal(t, intToBase64Sha256(tc.tx), raw.Entry.TxId)
+		require.Equal(t, padZeroes(tc.prefix), raw.Entry.KeyPrefix)
+		require.Equal(t, txData[tc.tx], raw.Payload)
+		require.Equal(t, `$["`+tc.key+`"]`, raw.JSONPath)
+		require.Equal(t, tc.encoded, string(raw.Payload[raw.ValueOffset:raw.ValueOffset+raw.ValueLen]))
+	}
+	// fetched through the getters: the version's index tx ID, the index and
+	// the payloads, two of them for "cc"
+	require.Equal(t, 4*3+1, fetches)
+
+	_, err := mockDB.GetRaw(0, []byte("ca"))
+	require.Equal(t, &ErrKeyNotFound{key: "ca", hasVersion: true, txIds: []string{intToBase64Sha256(1), intToBase64Sha256(2)}}, err)
+}
+
+func TestIteratorValueProvenance(t *testing.T) {
+	index := mockIndex([]string{"b", "d", "d"}, []int{0, 1, 2})
+	txData := [][]byte{
+		mockTxData([]string{"a", "b"}, []string{"va", "vb"}),
+		mockTxData([]string{"c"}, []string{"vc"}),
+		mockTxData([]string{"d"}, []string{"vd"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1, 2})
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	iter, err := mockDB.Iterator(append(v0Bz, []byte("a")...), append(v0Bz, []byte("e")...))
+	require.Nil(t, err)
+	txIds := map[string]string{}
+	for ; iter.Valid(); iter.Next() {
+		provenance := iter.(ProvenanceIterator).ValueProvenance()
+		txIds[string(iter.Key())] = provenance.TxId
+		require.Equal(t, provenance.TxId, provenance.Entry.TxId)
+	}
+	require.Equal(t, map[string]string{
+		"a": intToBase64Sha256(0),
+		"b": intToBase64Sha256(0),
+		"c": intToBase64Sha256(1),
+		"d": intToBase64Sha256(2),
+	}, txIds)
+	require.Equal(t, ValueProvenance{}, iter.(ProvenanceIterator).ValueProvenance())
+}
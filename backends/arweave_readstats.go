@@ -0,0 +1,171 @@
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WithReadStats makes the ArweaveDB account, per index key prefix and per
+// payload, how many times payloads are fetched and how many of their keys
+// and bytes are returned, to guide re-chunking archives. At most maxTracked
+// prefixes and payloads are tracked each, later ones being accounted
+// together.
+func WithReadStats(maxTracked int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if maxTracked < 0 {
+			db.invalidOption(fmt.Errorf("WithReadStats needs a non-negative number of tracked entries, got %d", maxTracked))
+			maxTracked = 0
+		}
+		db.readStats = &readStats{
+			maxTracked: maxTracked,
+			prefixes:   map[string]*ReadStatsEntry{},
+			txIds:      map[string]*ReadStatsEntry{},
+		}
+	}
+}
+
+// ReadStatsEntry accounts the payload fetches of an index key prefix or of a
+// payload.
+type ReadStatsEntry struct {
+	// KeyPrefix is set for index key prefixes, without padding
+	KeyPrefix []byte `json:"key_prefix,omitempty"`
+	// TxId is set for payloads
+	TxId         string  `json:"tx_id,omitempty"`
+	Fetches      uint64  `json:"fetches"`
+	KeysServed   uint64  `json:"keys_served"`
+	KeysPerFetch float64 `json:"keys_per_fetch"`
+	BytesFetched uint64  `json:"bytes_fetched"`
+	// BytesUsed counts the JSON encoded keys and values returned
+	BytesUsed uint64 `json:"bytes_used"`
+}
+
+// ReadStats is a snapshot of the read statistics of an ArweaveDB, entries
+// being sorted by decreasing bytes fetched.
+type ReadStats struct {
+	Prefixes []ReadStatsEntry `json:"prefixes"`
+	TxIds    []ReadStatsEntry `json:"tx_ids"`
+	// UntrackedPrefixes and UntrackedTxIds account what didn't fit in the
+	// tracked entries
+	UntrackedPrefixes ReadStatsEntry `json:"untracked_prefixes"`
+	UntrackedTxIds    ReadStatsEntry `json:"untracked_tx_ids"`
+}
+
+type readStats struct {
+	mtx        sync.Mutex
+	maxTracked int
+	prefixes   map[string]*ReadStatsEntry
+	txIds      map[string]*ReadStatsEntry
+	untracked  [2]ReadStatsEntry
+}
+
+func (s *readStats) entries(entry IndexEntry) [2]*ReadStatsEntry {
+	res := [2]*ReadStatsEntry{&s.untracked[0], &s.untracked[1]}
+	for i, tracked := range []map[string]*ReadStatsEntry{s.prefixes, s.txIds} {
+		name := entry.keyPrefix
+		if i == 1 {
+			name = string(entry.txId)
+		}
+		if stats, ok := tracked[name]; ok {
+			res[i] = stats
+		} else if len(tracked) < s.maxTracked {
+			stats = &ReadStatsEntry{}
+			if i == 0 {
+				stats.KeyPrefix = []byte(strings.TrimRight(name, "\x00"))
+			} else {
+				stats.TxId = name
+			}
+			tracked[name] = stats
+			res[i] = stats
+		}
+	}
+	return res
+}
+
+func (s *readStats) recordFetch(entry IndexEntry, size int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, stats := range s.entries(entry) {
+		stats.Fetches++
+		stats.BytesFetched += uint64(size)
+	}
+}
+
+func (s *readStats) recordServed(entry IndexEntry, key string, value string) {
+	used := uint64(jsonStringLen(key) + jsonStringLen(value))
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, stats := range s.entries(entry) {
+		stats.KeysServed++
+		stats.BytesUsed += used
+	}
+}
+
+func (s *readStats) snapshot() ReadStats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	res := ReadStats{
+		Prefixes:          snapshotReadStats(s.prefixes),
+		TxIds:             snapshotReadStats(s.txIds),
+		UntrackedPrefixes: s.untracked[0],
+		UntrackedTxIds:    s.untracked[1],
+	}
+	res.UntrackedPrefixes.KeysPerFetch = keysPerFetch(res.UntrackedPrefixes)
+	res.UntrackedTxIds.KeysPerFetch = keysPerFetch(res.UntrackedTxIds)
+	return res
+}
+
+func snapshotReadStats(tracked map[string]*ReadStatsEntry) []ReadStatsEntry {
+	res := make([]ReadStatsEntry, 0, len(tracked))
+	for _, stats := range tracked {
+		entry := *stats
+		entry.KeysPerFetch = keysPerFetch(entry)
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].BytesFetched != res[j].BytesFetched {
+			return res[i].BytesFetched > res[j].BytesFetched
+		}
+		return string(res[i].KeyPrefix)+res[i].TxId < string(res[j].KeyPrefix)+res[j].TxId
+	})
+	return res
+}
+
+func keysPerFetch(stats ReadStatsEntry) float64 {
+	if stats.Fetches == 0 {
+		return 0
+	}
+	return float64(stats.KeysServed) / float64(stats.Fetches)
+}
+
+// ReadStats returns a snapshot of the read statistics. It is empty unless
+// WithReadStats is used.
+func (db *ArweaveDB) ReadStats() ReadStats {
+	if db.readStats == nil {
+		return ReadStats{Prefixes: []ReadStatsEntry{}, TxIds: []ReadStatsEntry{}}
+	}
+	return db.readStats.snapshot()
+}
+
+// ReadStatsReport returns the read statistics encoded as JSON.
+func (db *ArweaveDB) ReadStatsReport() ([]byte, error) {
+	return json.Marshal(db.ReadStats())
+}
+
+func (db *ArweaveDB) recordServed(entry IndexEntry, key string, value string) {
+	if db.readStats != nil {
+		db.readStats.recordServed(entry, key, value)
+	}
+}
+
+// recordServed accounts the current key of the iterator as served, once
+// however many times its value is read.
+func (itr *arweaveDBIterator) recordServed(key string, value string) {
+	if itr.db.readStats == nil || (itr.servedTx == itr.txIdx && itr.servedKey == itr.currentKeyIdx) {
+		return
+	}
+	itr.servedTx, itr.servedKey = itr.txIdx, itr.currentKeyIdx
+	itr.db.readStats.recordServed(itr.entries[itr.txIdx], key, value)
+}
@@ -0,0 +1,97 @@
+package backends_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+func jsonLen(t *testing.T, s string) uint64 {
+	bz, err := json.Marshal(s)
+	require.Nil(t, err)
+	return uint64(len(bz))
+}
+
+func TestReadStats(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().Keys("a1", "a2", "b1", "b2").ChunkedIndex(2).Build()
+	require.Nil(t, err)
+	db := archive.NewDB(backends.WithReadStats(10))
+	desc, err := db.DescribeIndex(0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(desc.Entries))
+	first, second := desc.Entries[0], desc.Entries[1]
+	used := func(keys ...string) uint64 {
+		total := uint64(0)
+		for _, key := range keys {
+			total += jsonLen(t, key) + jsonLen(t, string(archive.Expected[0][key]))
+		}
+		return total
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := db.Get(arweavetest.Key(0, "a1"))
+		require.Nil(t, err)
+	}
+	iter, err := db.Iterator(arweavetest.Key(0, "a"), arweavetest.Key(0, "c"))
+	require.Nil(t, err)
+	for ; iter.Valid(); iter.Next() {
+		// values read several times are served once
+		iter.Value()
+		iter.Value()
+	}
+	require.Nil(t, iter.Close())
+	// keys iterated over without reading their values aren't served
+	iter, err = db.Iterator(arweavetest.Key(0, "b"), arweavetest.Key(0, "c"))
+	require.Nil(t, err)
+	for ; iter.Valid(); iter.Next() {
+	}
+	require.Nil(t, iter.Close())
+
+	report, err := db.ReadStatsReport()
+	require.Nil(t, err)
+	stats := backends.ReadStats{}
+	require.Nil(t, json.Unmarshal(report, &stats))
+	firstSize, secondSize := uint64(len(archive.TxData[first.TxId])), uint64(len(archive.TxData[second.TxId]))
+	require.Equal(t, []backends.ReadStatsEntry{
+		{TxId: first.TxId, Fetches: 3, KeysServed: 4, KeysPerFetch: 4.0 / 3, BytesFetched: 3 * firstSize, BytesUsed: used("a1", "a1", "a1", "a2")},
+		{TxId: second.TxId, Fetches: 2, KeysServed: 2, KeysPerFetch: 1, BytesFetched: 2 * secondSize, BytesUsed: used("b1", "b2")},
+	}, stats.TxIds)
+	require.Equal(t, 2, len(stats.Prefixes))
+	require.Equal(t, uint64(5), stats.Prefixes[0].Fetches+stats.Prefixes[1].Fetches)
+	require.Equal(t, backends.ReadStatsEntry{}, stats.UntrackedTxIds)
+}
+
+func TestReadStatsBounded(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().Keys("a", "b", "c").ChunkedIndex(1).Build()
+	require.Nil(t, err)
+	db := archive.NewDB(backends.WithReadStats(1))
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := db.Get(arweavetest.Key(0, key))
+		require.Nil(t, err)
+	}
+	stats := db.ReadStats()
+	require.Equal(t, 1, len(stats.TxIds))
+	require.Equal(t, uint64(1), stats.TxIds[0].Fetches)
+	require.Equal(t, uint64(2), stats.UntrackedTxIds.Fetches)
+	require.Equal(t, uint64(2), stats.UntrackedTxIds.KeysServed)
+	require.Equal(t, 1, len(stats.Prefixes))
+	require.Equal(t, uint64(2), stats.UntrackedPrefixes.Fetches)
+}
+
+func TestReadStatsDisabled(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().Keys("a").Build()
+	require.Nil(t, err)
+	db := archive.NewDB()
+	_, err = db.Get(arweavetest.Key(0, "a"))
+	require.Nil(t, err)
+	report, err := db.ReadStatsReport()
+	require.Nil(t, err)
+	stats := backends.ReadStats{}
+	require.Nil(t, json.Unmarshal(report, &stats))
+	require.Empty(t, stats.TxIds)
+	require.Empty(t, stats.Prefixes)
+}
@@ -0,0 +1,81 @@
+package backends
+
+import "strings"
+
+// Values of archives with value references are stored in an envelope: a
+// value starting with valueEnvelopeMarker is either a reference, starting
+// with valueRefPrefix, or an escaped value, starting with the marker twice,
+// so that literal values can't be mistaken for references. Other values are
+// stored as is.
+const (
+	valueEnvelopeMarker = "@"
+	// valueRefPrefix marks a value as a reference to another transaction
+	// whose data is the actual value. The version component leaves room to
+	// evolve the envelope format.
+	valueRefPrefix = "@txid:v1:"
+)
+
+// WithValueReferences makes the ArweaveDB read values in the envelope of
+// archives with value references: references to other transactions, as
+// produced by EncodeValueRef, are resolved and escaped values, as produced
+// by EscapeValue, are unescaped. Referenced transactions are fetched through
+// the tx data getter, so any middleware such as caching applies to them
+// too. ArweaveWriter produces such archives with WithValueSpill.
+func WithValueReferences() ArweaveOption {
+	return func(db *ArweaveDB) {
+		db.resolveValueRefs = true
+	}
+}
+
+// EncodeValueRef returns the value stored in place of a value that has been
+// spilled into its own transaction txId.
+func EncodeValueRef(txId string) string {
+	return valueRefPrefix + txId
+}
+
+// EscapeValue returns the value stored for value in archives with value
+// references, so that it can't be mistaken for a reference.
+func EscapeValue(value []byte) []byte {
+	if !strings.HasPrefix(string(value), valueEnvelopeMarker) {
+		return value
+	}
+	return append([]byte(valueEnvelopeMarker), value...)
+}
+
+// unescapeValue returns the value escaped as value by EscapeValue, if it is
+// an escaped value.
+func unescapeValue(value string) (string, bool) {
+	if !strings.HasPrefix(value, valueEnvelopeMarker+valueEnvelopeMarker) {
+		return "", false
+	}
+	return value[len(valueEnvelopeMarker):], true
+}
+
+func parseValueRef(value string) (string, bool) {
+	if !strings.HasPrefix(value, valueRefPrefix) || len(value) == len(valueRefPrefix) {
+		return "", false
+	}
+	return value[len(valueRefPrefix):], true
+}
+
+func (db *ArweaveDB) resolveValue(value string) ([]byte, error) {
+	if !db.resolveValueRefs {
+		return []byte(value), nil
+	}
+	if unescaped, ok := unescapeValue(value); ok {
+		return []byte(unescaped), nil
+	}
+	txId, ok := parseValueRef(value)
+	if !ok {
+		return []byte(value), nil
+	}
+	data, err := db.fetchTxData(FetchData, []byte(txId))
+	if err != nil {
+		return nil, &ErrValueRefNotResolved{txId: txId, err: err}
+	}
+	if data == nil {
+		// an empty referenced value is still present
+		return []byte{}, nil
+	}
+	return data, nil
+}
@@ -0,0 +1,115 @@
+package backends_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+var (
+	// hugeValueTxId is the transaction holding the value referenced by aa
+	// and ad of newValueRefTestDB, missingTxId one that doesn't exist
+	hugeValueTxId = arweavetest.TxId([]byte("huge value"))
+	missingTxId   = arweavetest.TxId([]byte("missing"))
+)
+
+func newValueRefTestDB(t *testing.T) *backends.ArweaveDB {
+	archive, err := arweavetest.NewArchiveBuilder().AddTx("b", map[string][]byte{
+		"aa": []byte(backends.EncodeValueRef(hugeValueTxId)),
+		"ab": []byte("plain"),
+		"ac": []byte(backends.EncodeValueRef(missingTxId)),
+		"ad": backends.EscapeValue([]byte(backends.EncodeValueRef(hugeValueTxId))),
+	}).Build()
+	require.Nil(t, err)
+	archive.TxData[hugeValueTxId] = []byte("huge value")
+	return archive.NewDB()
+}
+
+func TestValueRefs(t *testing.T) {
+	db := newValueRefTestDB(t)
+
+	// disabled by default
+	value, err := db.Get(arweavetest.Key(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, backends.EncodeValueRef(hugeValueTxId), string(value))
+
+	backends.WithValueReferences()(db)
+	value, err = db.Get(arweavetest.Key(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, "huge value", string(value))
+
+	value, err = db.Get(arweavetest.Key(0, "ab"))
+	require.Nil(t, err)
+	require.Equal(t, "plain", string(value))
+
+	// escaped values aren't references
+	value, err = db.Get(arweavetest.Key(0, "ad"))
+	require.Nil(t, err)
+	require.Equal(t, backends.EncodeValueRef(hugeValueTxId), string(value))
+
+	_, err = db.Get(arweavetest.Key(0, "ac"))
+	refErr := &backends.ErrValueRefNotResolved{}
+	require.True(t, errors.As(err, &refErr))
+	require.Contains(t, refErr.Error(), missingTxId)
+	require.True(t, errors.As(err, new(*backends.ErrKeyNotFound)))
+
+	// the referenced value isn't fetched to check existence
+	exists, err := db.Has(arweavetest.Key(0, "ac"))
+	require.Nil(t, err)
+	require.True(t, exists)
+}
+
+func TestValueRefsThroughMiddleware(t *testing.T) {
+	db := newValueRefTestDB(t)
+	backends.WithValueReferences()(db)
+	fetched := map[string]int{}
+	cache := map[string][]byte{}
+	backends.ApplyMiddleware(db, func(next backends.Getter) backends.Getter {
+		return func(key []byte) ([]byte, error) {
+			if res, ok := cache[string(key)]; ok {
+				return res, nil
+			}
+			res, err := next(key)
+			if err == nil {
+				cache[string(key)] = res
+			}
+			fetched[string(key)]++
+			return res, err
+		}
+	})
+	for i := 0; i < 2; i++ {
+		value, err := db.Get(arweavetest.Key(0, "aa"))
+		require.Nil(t, err)
+		require.Equal(t, "huge value", string(value))
+	}
+	require.Equal(t, 1, fetched[hugeValueTxId])
+}
+
+func TestValueRefsIterator(t *testing.T) {
+	db := newValueRefTestDB(t)
+	backends.WithValueReferences()(db)
+	fetched := map[string]int{}
+	backends.ApplyMiddleware(db, func(next backends.Getter) backends.Getter {
+		return func(key []byte) ([]byte, error) {
+			fetched[string(key)]++
+			return next(key)
+		}
+	})
+	iter, err := db.Iterator(arweavetest.Key(0, "a"), arweavetest.Key(0, "b"))
+	require.Nil(t, err)
+	require.Equal(t, "aa", string(iter.Key()))
+	// resolved lazily
+	require.Equal(t, 0, fetched[hugeValueTxId])
+	require.Equal(t, "huge value", string(iter.Value()))
+	require.Equal(t, 1, fetched[hugeValueTxId])
+	iter.Next()
+	require.Equal(t, "plain", string(iter.Value()))
+	iter.Next()
+	require.Equal(t, "ac", string(iter.Key()))
+	require.Nil(t, iter.Value())
+	require.True(t, errors.As(iter.Error(), new(*backends.ErrValueRefNotResolved)))
+}
@@ -0,0 +1,225 @@
+package backends
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Getters recorded in replays.
+const (
+	ReplayTxData  = "tx_data"
+	ReplayVersion = "version"
+)
+
+// ReplayEntry is a request recorded by a ReplayRecorder, along with its
+// response.
+type ReplayEntry struct {
+	Getter string `json:"getter"`
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	// message of the error the request failed with, if any
+	Err string `json:"error,omitempty"`
+	// whether the error was a not found one, which is replayed wrapping
+	// ErrKeyNotFound so that callers handle it the same way
+	NotFound bool `json:"not_found,omitempty"`
+	// how long the request took, for diagnosis
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+type replayFile struct {
+	Entries []ReplayEntry `json:"entries"`
+}
+
+// ReplayRecorder records the requests of the getters of an ArweaveDB, and
+// their responses, so that the fetches leading to a bug can be replayed
+// once the gateway responses are gone. See WithReplayRecording.
+type ReplayRecorder struct {
+	mtx     sync.Mutex
+	entries []ReplayEntry
+	now     func() time.Time
+}
+
+func NewReplayRecorder() *ReplayRecorder {
+	return &ReplayRecorder{now: time.Now}
+}
+
+// WithReplayRecording records the fetches of the ArweaveDB being
+// constructed to rec, outside of the middlewares applied so far: applied
+// after a retry middleware, only logical fetches are recorded, and before
+// it, every attempt is.
+func WithReplayRecording(rec *ReplayRecorder) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if rec == nil {
+			db.invalidOption(errors.New("WithReplayRecording is given a nil recorder"))
+			return
+		}
+		applyGetterMiddleware(db, rec.middleware(ReplayTxData), rec.middleware(ReplayVersion))
+	}
+}
+
+func (rec *ReplayRecorder) middleware(getter string) GetterMiddleware {
+	return func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			start := rec.now()
+			value, err := next(key)
+			entry := ReplayEntry{
+				Getter:  getter,
+				Key:     append([]byte{}, key...),
+				Value:   append([]byte{}, value...),
+				Elapsed: rec.now().Sub(start),
+			}
+			if err != nil {
+				entry.Value = nil
+				entry.Err = err.Error()
+				entry.NotFound = isVersionNotFound(err)
+			}
+			rec.mtx.Lock()
+			rec.entries = append(rec.entries, entry)
+			rec.mtx.Unlock()
+			return value, err
+		}
+	}
+}
+
+// Entries returns the requests recorded so far, in order.
+func (rec *ReplayRecorder) Entries() []ReplayEntry {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	return append([]ReplayEntry{}, rec.entries...)
+}
+
+// WriteTo writes the requests recorded so far, for ReadReplay to load them.
+func (rec *ReplayRecorder) WriteTo(w io.Writer) (int64, error) {
+	bz, err := json.MarshalIndent(replayFile{Entries: rec.Entries()}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(bz)
+	return int64(n), err
+}
+
+// WriteFile writes the requests recorded so far to the file at path.
+func (rec *ReplayRecorder) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := rec.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Replay answers requests from recorded entries, which must be requested
+// again in the same order. Fetches must therefore be sequential, as they
+// are without decode workers, for replays to be deterministic.
+type Replay struct {
+	mtx     sync.Mutex
+	entries []ReplayEntry
+	next    int
+	// first mismatch, after which every request fails
+	err error
+}
+
+// ReadReplay loads the entries written by ReplayRecorder.WriteTo.
+func ReadReplay(r io.Reader) (*Replay, error) {
+	bz, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	file := replayFile{}
+	if err := json.Unmarshal(bz, &file); err != nil {
+		return nil, fmt.Errorf("parsing replay: %w", err)
+	}
+	return NewReplay(file.Entries), nil
+}
+
+// ReadReplayFile loads the entries written by ReplayRecorder.WriteFile.
+func ReadReplayFile(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadReplay(f)
+}
+
+func NewReplay(entries []ReplayEntry) *Replay {
+	return &Replay{entries: entries}
+}
+
+// NewDB returns an ArweaveDB whose getters answer exclusively from the
+// replay, failing with ErrReplayMismatch on unexpected requests.
+func (r *Replay) NewDB(opts ...ArweaveOption) *ArweaveDB {
+	return NewArweaveDBWithGetters(r.getter(ReplayTxData), r.getter(ReplayVersion), opts...)
+}
+
+func (r *Replay) getter(getter string) Getter {
+	return func(key []byte) ([]byte, error) {
+		entry, err := r.take(getter, key)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case entry.NotFound:
+			return nil, &replayedError{msg: entry.Err, cause: NewErrKeyNotFound(key)}
+		case entry.Err != "":
+			return nil, &replayedError{msg: entry.Err}
+		}
+		return append([]byte{}, entry.Value...), nil
+	}
+}
+
+func (r *Replay) take(getter string, key []byte) (*ReplayEntry, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.next == len(r.entries) {
+		r.err = &ErrReplayMismatch{seq: r.next, getter: getter, key: append([]byte{}, key...)}
+		return nil, r.err
+	}
+	entry := &r.entries[r.next]
+	if entry.Getter != getter || string(entry.Key) != string(key) {
+		r.err = &ErrReplayMismatch{seq: r.next, expected: entry, getter: getter, key: append([]byte{}, key...)}
+		return nil, r.err
+	}
+	r.next++
+	return entry, nil
+}
+
+// Err returns the first mismatch of the replay, or an error if some
+// entries were never requested.
+func (r *Replay) Err() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.next < len(r.entries) {
+		return fmt.Errorf("%d of %d replayed requests were never made", len(r.entries)-r.next, len(r.entries))
+	}
+	return nil
+}
+
+// replayedError is a recorded error. Not found ones wrap ErrKeyNotFound.
+type replayedError struct {
+	msg   string
+	cause error
+}
+
+func (e *replayedError) Error() string {
+	return e.msg
+}
+
+func (e *replayedError) Unwrap() error {
+	return e.cause
+}
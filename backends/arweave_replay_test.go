@@ -0,0 +1,143 @@
+package backends
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// replayReads reads db the way the replay tests record it, returning what
+// it read.
+func replayReads(t *testing.T, db *ArweaveDB) []string {
+	reads := []string{}
+	for _, key := range []string{"aa", "bc", "zz"} {
+		value, err := db.Get(versionedKey(0, key))
+		if err != nil {
+			reads = append(reads, err.Error())
+			continue
+		}
+		reads = append(reads, key+"="+string(value))
+	}
+	_, err := db.Get(versionedKey(7, "aa"))
+	require.NotNil(t, err)
+	reads = append(reads, err.Error())
+	iter, err := db.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	for _, pair := range collectPairs(t, iter) {
+		reads = append(reads, string(pair.Key)+"="+string(pair.Value))
+	}
+	require.Nil(t, iter.Close())
+	return reads
+}
+
+func newReplayTestDB(opts ...ArweaveOption) *ArweaveDB {
+	index := mockIndex([]string{"ab", "bz"}, []int{0, 1})
+	txData := [][]byte{
+		mockTxData([]string{"aa", "ab"}, []string{"1", "2"}),
+		mockTxData([]string{"ba", "bc"}, []string{"3", "4"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	for _, opt := range opts {
+		opt(mockDB)
+	}
+	return mockDB
+}
+
+func TestReplayRoundTrip(t *testing.T) {
+	rec := NewReplayRecorder()
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	rec.now = func() time.Time {
+		clock.Advance(time.Millisecond)
+		return clock.Now()
+	}
+	recorded := replayReads(t, newReplayTestDB(WithReplayRecording(rec)))
+	entries := rec.Entries()
+	require.NotEmpty(t, entries)
+	require.Equal(t, time.Millisecond, entries[0].Elapsed)
+	notFound := 0
+	for _, entry := range entries {
+		if entry.NotFound {
+			notFound++
+			require.Equal(t, ReplayVersion, entry.Getter)
+		}
+	}
+	require.Equal(t, 1, notFound)
+
+	path := filepath.Join(t.TempDir(), "replay.json")
+	require.Nil(t, rec.WriteFile(path))
+	replay, err := ReadReplayFile(path)
+	require.Nil(t, err)
+	require.Equal(t, recorded, replayReads(t, replay.NewDB()))
+	require.Nil(t, replay.Err())
+
+	buf := &bytes.Buffer{}
+	_, err = rec.WriteTo(buf)
+	require.Nil(t, err)
+	replay, err = ReadReplay(buf)
+	require.Nil(t, err)
+	require.Equal(t, recorded, replayReads(t, replay.NewDB()))
+	require.Nil(t, replay.Err())
+}
+
+func TestReplayErrors(t *testing.T) {
+	rec := NewReplayRecorder()
+	db := newReplayTestDB()
+	getter := db.txDataByIdGetter
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		if string(txId) == intToBase64Sha256(1) {
+			return nil, errors.New("gateway timeout")
+		}
+		return getter(txId)
+	}
+	WithReplayRecording(rec)(db)
+	_, err := db.Get(versionedKey(0, "bc"))
+	require.EqualError(t, err, "gateway timeout")
+	_, err = db.Get(versionedKey(7, "aa"))
+	require.NotNil(t, err)
+
+	replayed := NewReplay(rec.Entries()).NewDB()
+	_, err = replayed.Get(versionedKey(0, "bc"))
+	require.EqualError(t, err, "gateway timeout")
+	// not found errors are still recognized as such
+	_, err = replayed.Get(versionedKey(7, "aa"))
+	require.ErrorAs(t, err, new(*ErrKeyNotFound))
+}
+
+func TestReplayMismatch(t *testing.T) {
+	rec := NewReplayRecorder()
+	replayReads(t, newReplayTestDB(WithReplayRecording(rec)))
+
+	// reads made in another order diverge from the replay
+	replay := NewReplay(rec.Entries())
+	db := replay.NewDB()
+	_, err := db.Get(versionedKey(0, "bc"))
+	mismatch := &ErrReplayMismatch{}
+	require.ErrorAs(t, err, &mismatch)
+	expected, ok := mismatch.Expected()
+	require.True(t, ok)
+	require.Equal(t, ReplayTxData, expected.Getter)
+	require.Equal(t, intToBase64Sha256(0), string(expected.Key))
+	require.Equal(t, intToBase64Sha256(1), string(mismatch.key))
+	require.Contains(t, err.Error(), "Replay mismatch at request 2")
+	// once diverged, the replay keeps failing
+	_, err = db.Get(versionedKey(0, "aa"))
+	require.ErrorAs(t, err, &mismatch)
+	require.Equal(t, mismatch, replay.Err())
+
+	// unused and exhausted replays are detected
+	replay = NewReplay(rec.Entries()[:3])
+	db = replay.NewDB()
+	require.NotNil(t, replay.Err())
+	value, err := db.Get(versionedKey(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+	require.Nil(t, replay.Err())
+	_, err = db.Get(versionedKey(0, "bc"))
+	require.ErrorAs(t, err, &mismatch)
+	_, ok = mismatch.Expected()
+	require.False(t, ok)
+}
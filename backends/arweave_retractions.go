@@ -0,0 +1,106 @@
+package backends
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// RetractionRecord maps versions whose originally published index has been
+// superseded to the transaction ID of the preferred index.
+type RetractionRecord struct {
+	Versions map[uint64]string `json:"versions"`
+}
+
+// RetractionSource fetches the latest published retraction record, along
+// with the identity of the account that published it.
+type RetractionSource func() (record []byte, owner string, err error)
+
+// OwnerIdentityHook returns an error if owner isn't trusted to publish
+// archive metadata such as retraction records.
+type OwnerIdentityHook func(owner string) error
+
+type retractions struct {
+	source      RetractionSource
+	verifyOwner OwnerIdentityHook
+
+	mtx      sync.RWMutex
+	versions map[uint64]string
+}
+
+// WithRetractions makes the ArweaveDB consult retraction records loaded from
+// source before trusting the version getter. Records are only accepted if
+// verifyOwner accepts their publisher. Records are loaded by
+// RefreshRetractions.
+func WithRetractions(source RetractionSource, verifyOwner OwnerIdentityHook) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if source == nil {
+			db.invalidOption(errors.New("WithRetractions is given a nil source"))
+			return
+		}
+		db.retractions = &retractions{
+			source:      source,
+			verifyOwner: verifyOwner,
+			versions:    map[uint64]string{},
+		}
+	}
+}
+
+// RefreshRetractions loads the latest retraction record. On failure the
+// previously loaded record stays in effect.
+func (db *ArweaveDB) RefreshRetractions() error {
+	if db.retractions == nil {
+		return errors.New("retractions are not enabled")
+	}
+	bz, owner, err := db.retractions.source()
+	if err != nil {
+		return err
+	}
+	if db.retractions.verifyOwner == nil {
+		return &ErrUntrustedRetractions{owner: owner, err: errors.New("no owner identity hook configured")}
+	}
+	if err := db.retractions.verifyOwner(owner); err != nil {
+		return &ErrUntrustedRetractions{owner: owner, err: err}
+	}
+	record := RetractionRecord{}
+	if err := json.Unmarshal(bz, &record); err != nil {
+		return err
+	}
+	if record.Versions == nil {
+		record.Versions = map[uint64]string{}
+	}
+	db.retractions.mtx.Lock()
+	previous := db.retractions.versions
+	db.retractions.versions = record.Versions
+	db.retractions.mtx.Unlock()
+	if db.indexCache != nil {
+		for version, txId := range previous {
+			if record.Versions[version] != txId {
+				db.indexCache.invalidate(version)
+			}
+		}
+		for version, txId := range record.Versions {
+			if previous[version] != txId {
+				db.indexCache.invalidate(version)
+			}
+		}
+	}
+	return nil
+}
+
+// getIndexTxId resolves the transaction ID of a version's index, preferring
+// retraction records over cached mappings and the version getter.
+func (db *ArweaveDB) getIndexTxId(version uint64) ([]byte, error) {
+	if db.pinned != nil {
+		return db.pinned.indexTxId(version)
+	}
+	if db.retractions != nil {
+		db.retractions.mtx.RLock()
+		txId, ok := db.retractions.versions[version]
+		db.retractions.mtx.RUnlock()
+		if ok {
+			return []byte(txId), nil
+		}
+	}
+	return db.resolveVersion(version)
+}
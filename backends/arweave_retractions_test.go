@@ -0,0 +1,60 @@
+package backends_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+func TestRetractions(t *testing.T) {
+	// version 0 is retracted in favor of the index of version 1
+	db, archive, err := arweavetest.NewArchiveBuilder().
+		AddVersion(0).AddTx("ab", map[string][]byte{"aa": []byte("bad")}).
+		AddVersion(1).AddTx("ab", map[string][]byte{"aa": []byte("good")}).
+		BuildDB()
+	require.Nil(t, err)
+
+	record := []byte(fmt.Sprintf(`{"versions": {"0": "%s"}}`, archive.IndexTxIds[1]))
+	owner := "publisher"
+	backends.WithRetractions(func() ([]byte, string, error) {
+		return record, owner, nil
+	}, func(o string) error {
+		if o != "publisher" {
+			return errors.New("unknown owner")
+		}
+		return nil
+	})(db)
+
+	value, err := db.Get(arweavetest.Key(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, "bad", string(value))
+
+	// spoofed records are rejected
+	owner = "attacker"
+	err = db.RefreshRetractions()
+	require.True(t, errors.As(err, new(*backends.ErrUntrustedRetractions)))
+	value, err = db.Get(arweavetest.Key(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, "bad", string(value))
+
+	owner = "publisher"
+	require.Nil(t, db.RefreshRetractions())
+	value, err = db.Get(arweavetest.Key(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, "good", string(value))
+}
+
+func TestRetractionsRequireOwnerHook(t *testing.T) {
+	db, _, err := arweavetest.NewArchiveBuilder().BuildDB()
+	require.Nil(t, err)
+	require.NotNil(t, db.RefreshRetractions())
+	backends.WithRetractions(func() ([]byte, string, error) {
+		return []byte(`{"versions": {}}`), "publisher", nil
+	}, nil)(db)
+	require.True(t, errors.As(db.RefreshRetractions(), new(*backends.ErrUntrustedRetractions)))
+}
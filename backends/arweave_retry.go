@@ -0,0 +1,142 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// TransientError is implemented by errors of getters knowing whether the
+// failed fetch may succeed if attempted again. Getters can wrap their errors
+// in such a type for FetchRetryPolicy to classify them.
+type TransientError interface {
+	error
+	Transient() bool
+}
+
+// IsTransientFetchError is the default FetchRetryPolicy.Retryable. Missing
+// keys, canceled contexts and exhausted download budgets are permanent, and
+// errors implementing TransientError tell for themselves. Any other error,
+// such as a network failure, is deemed transient.
+func IsTransientFetchError(err error) bool {
+	if isVersionNotFound(err) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, new(*ErrBudgetExhausted)) {
+		return false
+	}
+	var transient TransientError
+	if errors.As(err, &transient) {
+		return transient.Transient()
+	}
+	return true
+}
+
+// FetchRetryPolicy decides how failed fetches of an ArweaveDB are retried.
+type FetchRetryPolicy struct {
+	// MaxAttempts bounds the attempts of a fetch, the first one included.
+	// NewArweaveDBFromOptions rejects values lower than 1, which the other
+	// constructors treat as 1.
+	MaxAttempts int
+	// InitialDelay is the delay after the first failed attempt. Zero delays
+	// retry right away.
+	InitialDelay time.Duration
+	// Multiplier grows the delay after every further failed attempt.
+	// Values lower than 1 keep it constant.
+	Multiplier float64
+	// Jitter randomizes delays by up to this fraction of them, both ways,
+	// so that clients failing together don't retry together.
+	Jitter float64
+	// MaxDelay caps delays before jitter, zero meaning no cap.
+	MaxDelay time.Duration
+	// Retryable tells whether a fetch failing with err may succeed if
+	// attempted again. Defaults to IsTransientFetchError.
+	Retryable func(err error) bool
+}
+
+// DefaultFetchRetryPolicy suits public gateways, which rate limit and fail
+// transiently.
+var DefaultFetchRetryPolicy = FetchRetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 100 * time.Millisecond,
+	Multiplier:   2,
+	Jitter:       0.2,
+	MaxDelay:     5 * time.Second,
+}
+
+// Backoff returns how long to wait after the given number of failed
+// attempts, before jitter.
+func (p FetchRetryPolicy) Backoff(failures int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < failures && (p.MaxDelay <= 0 || delay < float64(p.MaxDelay)); i++ {
+		if p.Multiplier > 1 {
+			delay *= p.Multiplier
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func (p FetchRetryPolicy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return IsTransientFetchError(err)
+	}
+	return p.Retryable(err)
+}
+
+// delay returns the jittered delay after the given number of failed
+// attempts.
+func (p FetchRetryPolicy) delay(failures int, random func() float64) time.Duration {
+	delay := p.Backoff(failures)
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (2*random() - 1))
+	}
+	return delay
+}
+
+// RetryMiddleware retries failed fetches according to policy. Every attempt
+// goes through the middlewares below it again, see GetterMiddleware.
+func RetryMiddleware(policy FetchRetryPolicy) GetterMiddleware {
+	return retryMiddleware(policy, time.Sleep, rand.Float64)
+}
+
+func retryMiddleware(policy FetchRetryPolicy, sleep func(time.Duration), random func() float64) GetterMiddleware {
+	return func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			for failures := 0; ; {
+				res, err := next(key)
+				if err == nil {
+					return res, nil
+				}
+				failures++
+				if failures >= policy.MaxAttempts || !policy.retryable(err) {
+					return res, err
+				}
+				if delay := policy.delay(failures, random); delay > 0 {
+					sleep(delay)
+				}
+			}
+		}
+	}
+}
+
+// WithFetchRetries retries the failed fetches of both getters of the
+// ArweaveDB being constructed according to policy, outside of the
+// middlewares applied so far. See ApplyMiddleware.
+func WithFetchRetries(policy FetchRetryPolicy) ArweaveOption {
+	return func(db *ArweaveDB) {
+		switch {
+		case policy.MaxAttempts < 1:
+			db.invalidOption(fmt.Errorf("WithFetchRetries needs at least 1 attempt, got %d", policy.MaxAttempts))
+		case policy.InitialDelay < 0 || policy.MaxDelay < 0:
+			db.invalidOption(fmt.Errorf("WithFetchRetries needs non-negative delays, got %s and at most %s", policy.InitialDelay, policy.MaxDelay))
+		case policy.Jitter < 0 || policy.Jitter > 1:
+			db.invalidOption(fmt.Errorf("WithFetchRetries needs a jitter between 0 and 1, got %g", policy.Jitter))
+		}
+		ApplyMiddleware(db, RetryMiddleware(policy))
+	}
+}
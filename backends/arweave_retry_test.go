@@ -0,0 +1,133 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyGetter fails the first failures calls with err, and counts calls.
+func flakyGetter(getter Getter, failures int, err error, calls *int) Getter {
+	return func(key []byte) ([]byte, error) {
+		*calls++
+		if *calls <= failures {
+			return nil, err
+		}
+		return getter(key)
+	}
+}
+
+func TestFetchRetriesTransientFailures(t *testing.T) {
+	index := mockIndex([]string{"ab"}, []int{0})
+	txData := mockTxData([]string{"aa"}, []string{"v"})
+	base := NewMockArweaveDB([][]byte{index}, [][]byte{txData}, []int{0})
+	txDataCalls, versionCalls := 0, 0
+	db := NewArweaveDBWithGetters(
+		flakyGetter(base.txDataByIdGetter, 2, &ErrGatewayStatus{what: "not found chunk data", statusCode: 502}, &txDataCalls),
+		flakyGetter(base.versionTxIdGetter, 2, errors.New("connection reset"), &versionCalls),
+		WithFetchRetries(FetchRetryPolicy{MaxAttempts: 3}),
+	)
+	value, err := db.Get(versionedKey(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, "v", string(value))
+	require.Equal(t, 3, versionCalls)
+	// the index and the payload
+	require.Equal(t, 4, txDataCalls)
+}
+
+func TestFetchRetriesGiveUp(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		attempts int
+	}{
+		{NewErrKeyNotFound([]byte("k")), 1},
+		{fmt.Errorf("wrapped: %w", NewErrKeyNotFound([]byte("k"))), 1},
+		{context.Canceled, 1},
+		{&ErrGatewayStatus{what: "not found tx offset", statusCode: 404}, 1},
+		{&ErrGatewayStatus{what: "not found tx offset", statusCode: 429}, 4},
+		{errors.New("connection reset"), 4},
+	} {
+		calls := 0
+		getter := RetryMiddleware(FetchRetryPolicy{MaxAttempts: 4})(flakyGetter(nil, 100, tc.err, &calls))
+		_, err := getter([]byte("k"))
+		require.Equal(t, tc.err, err)
+		require.Equal(t, tc.attempts, calls, tc.err.Error())
+	}
+}
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "permanent" }
+func (permanentError) Transient() bool { return false }
+
+func TestFetchRetriesClassification(t *testing.T) {
+	calls := 0
+	getter := RetryMiddleware(FetchRetryPolicy{MaxAttempts: 4})(flakyGetter(nil, 100, fmt.Errorf("custom: %w", permanentError{}), &calls))
+	_, err := getter([]byte("k"))
+	require.NotNil(t, err)
+	require.Equal(t, 1, calls)
+
+	// custom classifications override the default one
+	calls = 0
+	policy := FetchRetryPolicy{MaxAttempts: 4, Retryable: func(err error) bool { return errors.As(err, new(*ErrKeyNotFound)) }}
+	getter = RetryMiddleware(policy)(flakyGetter(nil, 100, NewErrKeyNotFound([]byte("k")), &calls))
+	_, err = getter([]byte("k"))
+	require.NotNil(t, err)
+	require.Equal(t, 4, calls)
+}
+
+func TestFetchRetryBackoff(t *testing.T) {
+	policy := FetchRetryPolicy{MaxAttempts: 6, InitialDelay: 100 * time.Millisecond, Multiplier: 3, MaxDelay: time.Second}
+	delays := []time.Duration{}
+	sleep := func(d time.Duration) { delays = append(delays, d) }
+	calls := 0
+	getter := retryMiddleware(policy, sleep, func() float64 { return 0.5 })(flakyGetter(nil, 100, errors.New("reset"), &calls))
+	_, err := getter([]byte("k"))
+	require.NotNil(t, err)
+	require.Equal(t, []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second}, delays)
+
+	// jitter spreads delays both ways
+	policy.Jitter = 0.5
+	for random, expected := range map[float64]time.Duration{0: 50 * time.Millisecond, 1: 150 * time.Millisecond} {
+		random := random
+		delays = delays[:0]
+		calls = 0
+		policy.MaxAttempts = 2
+		getter = retryMiddleware(policy, sleep, func() float64 { return random })(flakyGetter(nil, 100, errors.New("reset"), &calls))
+		_, err = getter([]byte("k"))
+		require.NotNil(t, err)
+		require.Equal(t, []time.Duration{expected}, delays)
+	}
+
+	// zero delays don't sleep
+	calls = 0
+	getter = retryMiddleware(FetchRetryPolicy{MaxAttempts: 3}, func(time.Duration) { t.Fatal("slept") }, nil)(flakyGetter(nil, 100, errors.New("reset"), &calls))
+	_, err = getter([]byte("k"))
+	require.NotNil(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestFetchRetriesGatewayStatus(t *testing.T) {
+	server, requests := newGatewayServer(func(*http.Request) bool { return true }, 2)
+	defer server.Close()
+	client := NewClient(server.URL)
+	getter := RetryMiddleware(FetchRetryPolicy{MaxAttempts: 3})(func(txId []byte) ([]byte, error) {
+		return client.DownloadChunkData(string(txId))
+	})
+	data, err := getter([]byte("tx"))
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+	// 2 failures, then the offset and the chunk
+	require.Equal(t, int32(4), *requests)
+
+	_, err = getter([]byte("missing"))
+	status := &ErrGatewayStatus{}
+	require.ErrorAs(t, err, &status)
+	require.Equal(t, 404, status.StatusCode())
+	require.Equal(t, int32(5), *requests)
+}
@@ -0,0 +1,139 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// singleflightGroup holds the fetches in flight of a singleflight
+// middleware, shared by every getter it is bound for, e.g. those of views
+// and traced operations.
+type singleflightGroup struct {
+	mtx   sync.Mutex
+	calls map[singleflightKey]*singleflightCall
+}
+
+// singleflightKey tells fetches apart. Fetches asked to revalidate cached
+// data don't share those that aren't.
+type singleflightKey struct {
+	key        string
+	revalidate bool
+}
+
+// singleflightCall is a fetch in flight, shared by the callers requesting
+// the same key meanwhile.
+type singleflightCall struct {
+	// closed once fetched
+	done  chan struct{}
+	value []byte
+	err   error
+	// recovered from the fetch, if it panicked
+	panicked interface{}
+	// callers still waiting, the fetch being cancelled once none is left
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newSingleflightGroup() *singleflightGroup {
+	return &singleflightGroup{calls: map[singleflightKey]*singleflightCall{}}
+}
+
+// do returns the result of the fetch of key in flight, starting it with
+// fetch if there is none. The fetch runs with the values of the context of
+// the caller starting it but outlives it: callers give up waiting once
+// their ctx is done, the fetch only being cancelled once all of them did.
+func (g *singleflightGroup) do(ctx context.Context, key []byte, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	k := singleflightKey{key: string(key), revalidate: RevalidationRequested(ctx)}
+	g.mtx.Lock()
+	call, joined := g.calls[k]
+	if !joined {
+		fetchCtx, cancel := context.WithCancel(detachedContext{ctx})
+		call = &singleflightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[k] = call
+		go g.fetch(fetchCtx, k, call, fetch)
+	}
+	call.waiters++
+	g.mtx.Unlock()
+
+	select {
+	case <-call.done:
+		// the caller which started a panicking fetch panics, the others fail
+		if call.panicked != nil && !joined {
+			panic(call.panicked)
+		}
+		return call.value, call.err
+	case <-ctx.Done():
+		g.mtx.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// callers from now on start a new fetch
+			if g.calls[k] == call {
+				delete(g.calls, k)
+			}
+			call.cancel()
+		}
+		g.mtx.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *singleflightGroup) fetch(ctx context.Context, k singleflightKey, call *singleflightCall, fetch func(context.Context) ([]byte, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.panicked = r
+			call.err = fmt.Errorf("fetch of %X panicked: %v", k.key, r)
+		}
+		g.mtx.Lock()
+		if g.calls[k] == call {
+			delete(g.calls, k)
+		}
+		g.mtx.Unlock()
+		call.cancel()
+		close(call.done)
+	}()
+	call.value, call.err = fetch(ctx)
+}
+
+// middleware returns a bound middleware deduplicating fetches through g,
+// each fetch going through the middlewares below with a context of its
+// own, see do.
+func (g *singleflightGroup) middleware() boundMiddleware {
+	return func(ctx context.Context, _ Getter, rebind func(context.Context) Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			return g.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+				return rebind(ctx)(key)
+			})
+		}
+	}
+}
+
+// SingleflightMiddleware makes concurrent fetches of the same key share a
+// single fetch, all the callers receiving its result, whose bytes must
+// therefore not be modified. Fetches started once it completed fetch again.
+// Placed right below a cache, only misses are deduplicated. Fetches are
+// shared by every getter the middleware wraps, e.g. those of the views of
+// a DB; unlike with WithFetchDeduplication, callers wait for them whatever
+// the context of their view.
+func SingleflightMiddleware() GetterMiddleware {
+	group := newSingleflightGroup()
+	return func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			return group.do(context.Background(), key, func(context.Context) ([]byte, error) {
+				return next(key)
+			})
+		}
+	}
+}
+
+// WithFetchDeduplication makes concurrent fetches of the same transaction
+// data or version by the ArweaveDB being constructed share a single fetch,
+// outside of the middlewares applied so far, whichever view or traced
+// operation they are made by. Callers stop waiting once the context of
+// their view is done, without cancelling the fetch for the others. See
+// SingleflightMiddleware.
+func WithFetchDeduplication() ArweaveOption {
+	return func(db *ArweaveDB) {
+		applyBoundMiddleware(db, newSingleflightGroup().middleware(), newSingleflightGroup().middleware())
+	}
+}
@@ -0,0 +1,244 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowGetter counts its calls per key, and answers after latency.
+func slowGetter(getter Getter, latency time.Duration, calls *sync.Map) Getter {
+	return func(key []byte) ([]byte, error) {
+		count, _ := calls.LoadOrStore(string(key), new(int32))
+		atomic.AddInt32(count.(*int32), 1)
+		time.Sleep(latency)
+		return getter(key)
+	}
+}
+
+func callCount(calls *sync.Map, key string) int32 {
+	count, ok := calls.Load(key)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt32(count.(*int32))
+}
+
+func TestFetchDeduplication(t *testing.T) {
+	index := mockIndex([]string{"ab"}, []int{0})
+	txData := mockTxData([]string{"aa", "ab"}, []string{"v1", "v2"})
+	base := NewMockArweaveDB([][]byte{index}, [][]byte{txData}, []int{0})
+	txDataCalls, versionCalls := &sync.Map{}, &sync.Map{}
+	db := NewArweaveDBWithGetters(
+		slowGetter(base.txDataByIdGetter, 20*time.Millisecond, txDataCalls),
+		slowGetter(base.versionTxIdGetter, 20*time.Millisecond, versionCalls),
+		WithFetchDeduplication(),
+	)
+
+	wg := sync.WaitGroup{}
+	start := make(chan struct{})
+	for i := 0; i < 16; i++ {
+		key := []string{"aa", "ab"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			value, err := db.Get(versionedKey(0, key))
+			if err != nil || string(value) != map[string]string{"aa": "v1", "ab": "v2"}[key] {
+				t.Errorf("got %q, %v for %s", value, err, key)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	require.Equal(t, int32(1), callCount(versionCalls, string(versionedKey(0, ""))))
+	require.Equal(t, int32(1), callCount(txDataCalls, intToBase64Sha256(1)))
+	require.Equal(t, int32(1), callCount(txDataCalls, intToBase64Sha256(0)))
+
+	// fetches once done are made again
+	_, err := db.Get(versionedKey(0, "aa"))
+	require.Nil(t, err)
+	require.Equal(t, int32(2), callCount(txDataCalls, intToBase64Sha256(0)))
+}
+
+func TestFetchDeduplicationAcrossViews(t *testing.T) {
+	for name, opt := range map[string]func() ArweaveOption{
+		"option":     WithFetchDeduplication,
+		"middleware": func() ArweaveOption { return WithGetterMiddleware(SingleflightMiddleware()) },
+	} {
+		index := mockIndex([]string{"ab"}, []int{0})
+		txData := mockTxData([]string{"aa", "ab"}, []string{"v1", "v2"})
+		base := NewMockArweaveDB([][]byte{index}, [][]byte{txData}, []int{0})
+		txDataCalls, versionCalls := &sync.Map{}, &sync.Map{}
+		db := NewArweaveDBWithGetters(
+			slowGetter(base.txDataByIdGetter, 20*time.Millisecond, txDataCalls),
+			slowGetter(base.versionTxIdGetter, 20*time.Millisecond, versionCalls),
+			opt(),
+			WithTracing(8),
+		)
+
+		views := []func() *ArweaveDB{
+			// traced operations bind views of their own
+			func() *ArweaveDB { return db },
+			func() *ArweaveDB { return db.WithContext(context.Background()) },
+			func() *ArweaveDB { return db.WithReadOptions(ReadOptions{BudgetExempt: true}) },
+		}
+		wg := sync.WaitGroup{}
+		start := make(chan struct{})
+		for i := 0; i < 15; i++ {
+			view := views[i%len(views)]()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				value, err := view.Get(versionedKey(0, "aa"))
+				if err != nil || string(value) != "v1" {
+					t.Errorf("%s: got %q, %v", name, value, err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		require.Equal(t, int32(1), callCount(versionCalls, string(versionedKey(0, ""))), name)
+		require.Equal(t, int32(1), callCount(txDataCalls, intToBase64Sha256(1)), name)
+		require.Equal(t, int32(1), callCount(txDataCalls, intToBase64Sha256(0)), name)
+	}
+}
+
+func TestFetchDeduplicationCancelledWaiter(t *testing.T) {
+	index := mockIndex([]string{"ab"}, []int{0})
+	txData := mockTxData([]string{"aa"}, []string{"v1"})
+	base := NewMockArweaveDB([][]byte{index}, [][]byte{txData}, []int{0})
+	payloadTxId := intToBase64Sha256(0)
+	calls := int32(0)
+	started, release := make(chan struct{}), make(chan struct{})
+	fetchErrs := make(chan error, 1)
+	db := NewArweaveDBWithContextGetters(func(ctx context.Context, txId []byte) ([]byte, error) {
+		if string(txId) == payloadTxId {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				close(started)
+			}
+			select {
+			case <-release:
+			case <-ctx.Done():
+				fetchErrs <- ctx.Err()
+				return nil, ctx.Err()
+			}
+		}
+		return base.txDataByIdGetter(txId)
+	}, IgnoringContext(base.versionTxIdGetter), WithFetchDeduplication())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := db.WithContext(ctx).Get(versionedKey(0, "aa"))
+		cancelled <- err
+	}()
+	<-started
+	values := make(chan []byte, 1)
+	go func() {
+		value, err := db.Get(versionedKey(0, "aa"))
+		if err != nil {
+			t.Errorf("got %v", err)
+		}
+		values <- value
+	}()
+	// the second Get joins the fetch
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-cancelled, context.Canceled)
+	close(release)
+	require.Equal(t, []byte("v1"), <-values)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.Len(t, fetchErrs, 0)
+}
+
+func TestSingleflightSharesErrors(t *testing.T) {
+	calls := int32(0)
+	release := make(chan struct{})
+	getter := SingleflightMiddleware()(func(key []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, errors.New("unavailable")
+	})
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := getter([]byte("k"))
+			errs <- err
+		}()
+	}
+	// the first fetch waits for the others to join it
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		require.EqualError(t, <-errs, "unavailable")
+	}
+	require.Equal(t, int32(1), calls)
+}
+
+func TestSingleflightBelowCache(t *testing.T) {
+	cache := map[string][]byte{}
+	var mtx sync.Mutex
+	caching := func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			mtx.Lock()
+			value, ok := cache[string(key)]
+			mtx.Unlock()
+			if ok {
+				return value, nil
+			}
+			value, err := next(key)
+			if err == nil {
+				mtx.Lock()
+				cache[string(key)] = value
+				mtx.Unlock()
+			}
+			return value, err
+		}
+	}
+	calls := &sync.Map{}
+	getter := ChainMiddleware(caching, SingleflightMiddleware())(slowGetter(failingGetter(0), 20*time.Millisecond, calls))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := getter([]byte("k"))
+			if err != nil || string(value) != "k" {
+				t.Errorf("got %q, %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+	// hits don't fetch at all
+	_, err := getter([]byte("k"))
+	require.Nil(t, err)
+	require.Equal(t, int32(1), callCount(calls, "k"))
+}
+
+func TestSingleflightPanics(t *testing.T) {
+	release := make(chan struct{})
+	getter := SingleflightMiddleware()(func(key []byte) ([]byte, error) {
+		<-release
+		panic("boom")
+	})
+	errs := make(chan error, 1)
+	go func() {
+		defer func() { recover() }()
+		_, _ = getter([]byte("k"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		_, err := getter([]byte("k"))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	require.EqualError(t, <-errs, "fetch of 6B panicked: boom")
+}
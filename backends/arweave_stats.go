@@ -0,0 +1,108 @@
+package backends
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// opStats counts the fetches, cache lookups and failures of an ArweaveDB
+// and its views for Stats, with atomic operations so that reads don't
+// contend on them.
+type opStats struct {
+	// 64-bit fields first, for their alignment on 32-bit platforms
+	versionFetches   int64
+	indexFetches     int64
+	dataFetches      int64
+	keyFilterFetches int64
+	fetchErrors      int64
+	bytesDownloaded  int64
+	cacheHits        int64
+	cacheMisses      int64
+	inFlight         int64
+	// error of the last failed operation, a string
+	lastErr atomic.Value
+}
+
+func newOpStats() *opStats {
+	return &opStats{}
+}
+
+func (s *opStats) fetchCounter(kind FetchKind) *int64 {
+	switch kind {
+	case FetchVersion:
+		return &s.versionFetches
+	case FetchIndex:
+		return &s.indexFetches
+	case FetchKeyFilter:
+		return &s.keyFilterFetches
+	}
+	return &s.dataFetches
+}
+
+// recordFetch reports a fetch from the getters to the metrics collector and
+// the statistics of db.
+func (db *ArweaveDB) recordFetch(kind FetchKind, bytes int, duration time.Duration, err error) {
+	db.collector().OnFetch(kind, bytes, duration, err)
+	if db.opStats == nil {
+		return
+	}
+	atomic.AddInt64(db.opStats.fetchCounter(kind), 1)
+	atomic.AddInt64(&db.opStats.bytesDownloaded, int64(bytes))
+	if err != nil {
+		atomic.AddInt64(&db.opStats.fetchErrors, 1)
+	}
+}
+
+// trackInFlight counts a request to the getters as in flight until the
+// returned function is called.
+func (db *ArweaveDB) trackInFlight() func() {
+	if db.opStats == nil {
+		return func() {}
+	}
+	atomic.AddInt64(&db.opStats.inFlight, 1)
+	return func() {
+		atomic.AddInt64(&db.opStats.inFlight, -1)
+	}
+}
+
+// addOpStats adds the counters of db to stats, along with the number of
+// versions whose index is cached.
+func (db *ArweaveDB) addOpStats(stats map[string]string) {
+	if db.opStats == nil {
+		return
+	}
+	s := db.opStats
+	for _, counter := range []struct {
+		name  string
+		value *int64
+	}{
+		{"version_fetches", &s.versionFetches},
+		{"index_fetches", &s.indexFetches},
+		{"data_fetches", &s.dataFetches},
+		{"key_filter_fetches", &s.keyFilterFetches},
+		{"fetch_errors", &s.fetchErrors},
+		{"bytes_downloaded", &s.bytesDownloaded},
+		{"cache_hits", &s.cacheHits},
+		{"cache_misses", &s.cacheMisses},
+		{"inflight_requests", &s.inFlight},
+	} {
+		stats[counter.name] = strconv.FormatInt(atomic.LoadInt64(counter.value), 10)
+	}
+	cachedVersions := 0
+	if db.indexCache != nil {
+		cachedVersions = db.indexCache.len()
+	}
+	stats["cached_versions"] = strconv.Itoa(cachedVersions)
+	if lastErr, ok := s.lastErr.Load().(string); ok {
+		stats["last_error"] = lastErr
+	}
+}
+
+// recordOpError records err, if any, as the error of the last failed
+// operation.
+func (db *ArweaveDB) recordOpError(err error) {
+	if err != nil && db.opStats != nil {
+		db.opStats.lastErr.Store(err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package backends_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+func newStatsTestDB(t *testing.T) *backends.ArweaveDB {
+	db, _, err := arweavetest.NewArchiveBuilder().
+		AddTx("z", map[string][]byte{"a": []byte("1"), "b": []byte("2")}).
+		BuildDB(backends.WithIndexCache(4))
+	require.Nil(t, err)
+	return db
+}
+
+// requireStats asserts the values of the given statistics of db.
+func requireStats(t *testing.T, db *backends.ArweaveDB, expected map[string]string) {
+	t.Helper()
+	stats := db.Stats()
+	for name, value := range expected {
+		require.Equal(t, value, stats[name], name)
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	db := newStatsTestDB(t)
+	requireStats(t, db, map[string]string{
+		"version_fetches":   "0",
+		"index_fetches":     "0",
+		"data_fetches":      "0",
+		"bytes_downloaded":  "0",
+		"cache_hits":        "0",
+		"cache_misses":      "0",
+		"inflight_requests": "0",
+		"cached_versions":   "0",
+	})
+	require.NotContains(t, db.Stats(), "last_error")
+
+	value, err := db.Get(arweavetest.Key(0, "a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+	requireStats(t, db, map[string]string{
+		"version_fetches": "1",
+		"index_fetches":   "1",
+		"data_fetches":    "1",
+		"cache_misses":    "1",
+		"cached_versions": "1",
+	})
+	downloaded := db.Stats()["bytes_downloaded"]
+	require.NotEqual(t, "0", downloaded)
+
+	has, err := db.Has(arweavetest.Key(0, "b"))
+	require.NoError(t, err)
+	require.True(t, has)
+	iter, err := db.Iterator(arweavetest.Key(0, "a"), arweavetest.Key(0, "z"))
+	require.NoError(t, err)
+	for ; iter.Valid(); iter.Next() {
+	}
+	require.NoError(t, iter.Close())
+	// the index is served from the cache, payloads are fetched again
+	requireStats(t, db, map[string]string{
+		"version_fetches": "1",
+		"index_fetches":   "1",
+		"data_fetches":    "3",
+		"cache_hits":      "2",
+		"cache_misses":    "1",
+		"fetch_errors":    "0",
+	})
+	require.NotEqual(t, downloaded, db.Stats()["bytes_downloaded"])
+
+	_, err = db.Get(arweavetest.Key(1, "a"))
+	require.Error(t, err)
+	requireStats(t, db, map[string]string{
+		"version_fetches":   "2",
+		"fetch_errors":      "1",
+		"cache_misses":      "2",
+		"inflight_requests": "0",
+		"last_error":        err.Error(),
+	})
+}
+
+func TestStatsConcurrentReads(t *testing.T) {
+	db := newStatsTestDB(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := db.Get(arweavetest.Key(0, "a"))
+				require.NoError(t, err)
+				db.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+	// concurrent loads of the index are made once
+	requireStats(t, db, map[string]string{
+		"version_fetches":   "1",
+		"index_fetches":     "1",
+		"data_fetches":      "80",
+		"cache_misses":      "1",
+		"cache_hits":        "79",
+		"inflight_requests": "0",
+	})
+}
@@ -0,0 +1,225 @@
+package backends
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// WithStreamingGets makes Get and Has scan JSON and binary payloads of at least
+// minPayloadSize bytes, according to their index entry, for the key looked
+// up instead of decoding them into a map. Only the values of that key are
+// decoded, which saves most of the time and allocations of lookups in large
+// payloads. Scanned payloads aren't fully validated, so that a malformed
+// payload may still serve the keys before the malformation.
+func WithStreamingGets(minPayloadSize uint64) ArweaveOption {
+	return func(db *ArweaveDB) {
+		db.streamingMinSize = minPayloadSize
+	}
+}
+
+// streams returns whether the value of a key is looked up in the payload of
+// entry by scanning it.
+func (db *ArweaveDB) streams(entry IndexEntry) bool {
+	codec := entry.info.Codec
+	return db.streamingMinSize > 0 && (codec == CodecJSON || codec == CodecBinary) && entry.info.PayloadSize >= db.streamingMinSize
+}
+
+// getEntryValue returns the raw value of key in the payload of entry, as
+// decoded by decodePayload, and whether the payload has key.
+func (db *ArweaveDB) getEntryValue(entry IndexEntry, key string) (interface{}, bool, error) {
+	if !db.streams(entry) {
+		keyvalues, err := db.getEntryPayload(entry)
+		if err != nil {
+			return nil, false, err
+		}
+		raw, ok := keyvalues[key]
+		return raw, ok, nil
+	}
+	if keyvalues, ok := db.cachedEntryPayload(entry); ok {
+		raw, ok := keyvalues[key]
+		return raw, ok, nil
+	}
+	txData, err := db.fetchEntryTxData(entry)
+	if err != nil {
+		return nil, false, err
+	}
+	extract := extractPayloadValue
+	if isBinaryPayload(txData) {
+		extract = extractBinaryValue
+	}
+	raw, found, err := extract(txData, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if found > 1 && db.strict {
+		return nil, false, &ErrDuplicateKey{key: key, txIds: []string{string(entry.txId), string(entry.txId)}}
+	}
+	return raw, found > 0, nil
+}
+
+// extractPayloadValue scans a JSON payload for key and returns its raw
+// value along with how many times the payload has it. Like decodePayload,
+// the last occurrence of a duplicate key wins.
+func extractPayloadValue(txData []byte, key string) (interface{}, int, error) {
+	s := &payloadScanner{data: txData}
+	if err := s.expect('{'); err != nil {
+		return nil, 0, err
+	}
+	var raw interface{}
+	found := 0
+	if s.skipSpaces(); s.peek() == '}' {
+		return nil, 0, nil
+	}
+	for {
+		start := s.pos
+		if err := s.skipString(); err != nil {
+			return nil, 0, err
+		}
+		matches, err := jsonStringEquals(txData[start:s.pos], key)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := s.expect(':'); err != nil {
+			return nil, 0, err
+		}
+		s.skipSpaces()
+		start = s.pos
+		if err := s.skipValue(); err != nil {
+			return nil, 0, err
+		}
+		if matches {
+			if err := json.Unmarshal(txData[start:s.pos], &raw); err != nil {
+				return nil, 0, err
+			}
+			found++
+		}
+		s.skipSpaces()
+		switch s.next() {
+		case ',':
+			s.skipSpaces()
+		case '}':
+			return raw, found, nil
+		default:
+			return nil, 0, s.syntaxError()
+		}
+	}
+}
+
+// jsonStringEquals returns whether the JSON string quoted is s, only
+// unescaping it if needed.
+func jsonStringEquals(quoted []byte, s string) (bool, error) {
+	unquoted := quoted[1 : len(quoted)-1]
+	if bytes.IndexByte(unquoted, '\\') < 0 {
+		return string(unquoted) == s, nil
+	}
+	var decoded string
+	if err := json.Unmarshal(quoted, &decoded); err != nil {
+		return false, err
+	}
+	return decoded == s, nil
+}
+
+// payloadScanner skips over JSON values without decoding them.
+type payloadScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *payloadScanner) peek() byte {
+	if s.pos >= len(s.data) {
+		return 0
+	}
+	return s.data[s.pos]
+}
+
+func (s *payloadScanner) next() byte {
+	c := s.peek()
+	s.pos++
+	return c
+}
+
+func (s *payloadScanner) syntaxError() error {
+	if s.pos >= len(s.data) {
+		return fmt.Errorf("unexpected end of JSON payload")
+	}
+	return fmt.Errorf("invalid character %q at offset %d of JSON payload", s.data[s.pos-1], s.pos-1)
+}
+
+func (s *payloadScanner) skipSpaces() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *payloadScanner) expect(c byte) error {
+	s.skipSpaces()
+	if s.next() != c {
+		return s.syntaxError()
+	}
+	return nil
+}
+
+func (s *payloadScanner) skipString() error {
+	if s.next() != '"' {
+		return s.syntaxError()
+	}
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case '\\':
+			s.pos += 2
+		case '"':
+			s.pos++
+			return nil
+		default:
+			s.pos++
+		}
+	}
+	return s.syntaxError()
+}
+
+func (s *payloadScanner) skipValue() error {
+	switch s.peek() {
+	case '"':
+		return s.skipString()
+	case '{', '[':
+		depth := 0
+		for s.pos < len(s.data) {
+			switch s.data[s.pos] {
+			case '"':
+				if err := s.skipString(); err != nil {
+					return err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			s.pos++
+			if depth == 0 {
+				return nil
+			}
+		}
+		return s.syntaxError()
+	default:
+		// numbers, booleans and null
+		start := s.pos
+		for s.pos < len(s.data) {
+			switch s.data[s.pos] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				if s.pos == start {
+					return s.syntaxError()
+				}
+				return nil
+			}
+			s.pos++
+		}
+		return s.syntaxError()
+	}
+}
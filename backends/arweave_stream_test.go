@@ -0,0 +1,144 @@
+package backends
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractPayloadValue(t *testing.T) {
+	payloads := []string{
+		`{}`,
+		` { } `,
+		`{"a": "1", "b": "2"}`,
+		`{"a":"1","b":"2"}`,
+		"{\n\t\"a\" : \"x,}]\\\"\" ,\r\n\"b\": \"\"}",
+		`{"a": "escaped key", "b\"": "quoted"}`,
+		`{"a": {"nested": [1, "]", {"x": null}]}, "b": [], "c": 1.5e3, "d": true, "e": null}`,
+		// duplicate keys, the last one winning
+		`{"a": "1", "b": "2", "a": "3"}`,
+	}
+	for _, payload := range payloads {
+		expected, err := decodePayload([]byte(payload))
+		require.Nil(t, err, payload)
+		for _, key := range []string{"a", "b", "b\"", "c", "d", "e", "missing"} {
+			raw, found, err := extractPayloadValue([]byte(payload), key)
+			require.Nil(t, err, payload)
+			expectedRaw, ok := expected[key]
+			require.Equal(t, ok, found > 0, "%s in %s", key, payload)
+			require.Equal(t, expectedRaw, raw, "%s in %s", key, payload)
+		}
+	}
+	_, found, err := extractPayloadValue([]byte(payloads[len(payloads)-1]), "a")
+	require.Nil(t, err)
+	require.Equal(t, 2, found)
+
+	for _, payload := range []string{``, `[]`, `{"a"}`, `{"a": "1"`, `{"a": "1",}`, `{"a": }`, `{"a": "1" "b": "2"}`, `{a: "1"}`} {
+		_, _, err := extractPayloadValue([]byte(payload), "a")
+		require.NotNil(t, err, payload)
+	}
+}
+
+func TestExtractPayloadValueMatchesDecoding(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 100; i++ {
+		kvs := map[string]string{}
+		for j := r.Intn(50); j > 0; j-- {
+			key := make([]byte, 1+r.Intn(8))
+			value := make([]byte, r.Intn(16))
+			for k := range key {
+				key[k] = byte(' ' + r.Intn(95))
+			}
+			for k := range value {
+				value[k] = byte(r.Intn(128))
+			}
+			kvs[string(key)] = string(value)
+		}
+		payload, err := json.Marshal(kvs)
+		require.Nil(t, err)
+		for key, value := range kvs {
+			raw, found, err := extractPayloadValue(payload, key)
+			require.Nil(t, err)
+			require.Equal(t, 1, found)
+			require.Equal(t, value, raw)
+		}
+		_, found, err := extractPayloadValue(payload, "\x00missing")
+		require.Nil(t, err)
+		require.Equal(t, 0, found)
+	}
+}
+
+func TestStreamingGets(t *testing.T) {
+	payloads := [][]byte{
+		mockTxData([]string{"aa", "ab"}, []string{"v1", "v2"}),
+		[]byte(`{"ca": "v3", "cb": "v4", "ca": "v5"}`),
+	}
+	infos := []IndexEntryInfo{}
+	for _, payload := range payloads {
+		infos = append(infos, IndexEntryInfo{PayloadSize: uint64(len(payload)), KeyCount: 2, Codec: CodecJSON})
+	}
+	index := mockIndexV1([]string{"ab", "cb"}, []int{0, 1}, infos)
+	newDB := func(opts ...ArweaveOption) *ArweaveDB {
+		db := NewMockArweaveDB([][]byte{index}, payloads, []int{0, 1})
+		for _, opt := range opts {
+			opt(db)
+		}
+		return db
+	}
+	full, streaming := newDB(), newDB(WithStreamingGets(1))
+	for _, key := range []string{"aa", "ab", "ac", "ca", "cb", "zz"} {
+		versioned := append(make([]byte, 8), key...)
+		expected, expectedErr := full.Get(versioned)
+		value, err := streaming.Get(versioned)
+		require.Equal(t, expectedErr, err, key)
+		require.Equal(t, expected, value, key)
+		has, err := streaming.Has(versioned)
+		require.Nil(t, err)
+		require.Equal(t, expected != nil, has, key)
+	}
+	value, err := streaming.Get(append(make([]byte, 8), "ca"...))
+	require.Nil(t, err)
+	require.Equal(t, "v5", string(value))
+
+	_, err = newDB(WithStreamingGets(1), WithStrictMode()).Get(append(make([]byte, 8), "ca"...))
+	require.ErrorAs(t, err, new(*ErrDuplicateKey))
+}
+
+func newLargePayloadDB(b *testing.B, keys int, opts ...ArweaveOption) (*ArweaveDB, []byte) {
+	kvs := map[string]string{}
+	for i := 0; i < keys; i++ {
+		kvs[fmt.Sprintf("key%08d", i)] = fmt.Sprintf("value%08d", i)
+	}
+	payload, err := json.Marshal(kvs)
+	require.Nil(b, err)
+	info := IndexEntryInfo{PayloadSize: uint64(len(payload)), KeyCount: uint32(keys), Codec: CodecJSON}
+	db := NewMockArweaveDB([][]byte{mockIndexV1([]string{"key\xff"}, []int{0}, []IndexEntryInfo{info})}, [][]byte{payload}, []int{0})
+	for _, opt := range opts {
+		opt(db)
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, 0)
+	return db, append(key, fmt.Sprintf("key%08d", keys/2)...)
+}
+
+func BenchmarkGetLargePayload(b *testing.B) {
+	for name, opts := range map[string][]ArweaveOption{
+		"decoded":  nil,
+		"streamed": {WithStreamingGets(1)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			db, key := newLargePayloadDB(b, 50000, opts...)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Get(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
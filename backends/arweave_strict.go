@@ -0,0 +1,97 @@
+package backends
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// WithStrictMode makes the ArweaveDB fail with a typed error on any
+// ambiguity in the archive instead of making the best of it, for audit
+// tooling:
+//   - keys present in several payloads sharing an index key prefix fail
+//     with ErrDuplicateKey rather than resolving to the first one
+//   - values which aren't strings or malformed value references fail with
+//     ErrUndecodableValue rather than being returned as is
+//   - headerless legacy indices fail with ErrLegacyIndex
+//   - payloads whose codec isn't declared by their index entry fail with
+//     ErrUndeclaredCodec rather than being decoded as JSON
+//   - cached version mappings are checked against the version getter, and
+//     fail with ErrStaleCache if they differ
+//
+// Gets of keys sharing an index key prefix with other payloads fetch all of
+// them.
+func WithStrictMode() ArweaveOption {
+	return func(db *ArweaveDB) {
+		db.strict = true
+	}
+}
+
+// checkIndex fails in strict mode if index is a legacy one.
+func (db *ArweaveDB) checkIndex(version uint64, index []byte) error {
+	if db.strict && indexFormat(index) == LegacyIndexFormat {
+		return &ErrLegacyIndex{version: version}
+	}
+	return nil
+}
+
+// getEntryPayload returns the decoded payload of entry.
+func (db *ArweaveDB) getEntryPayload(entry IndexEntry) (map[string]interface{}, error) {
+	data, _, err := db.loadEntryPayload(entry, false)
+	return data, err
+}
+
+// fetchEntryTxData returns the payload of entry, undecoded.
+func (db *ArweaveDB) fetchEntryTxData(entry IndexEntry) ([]byte, error) {
+	if db.strict && entry.info.Codec != CodecJSON && entry.info.Codec != CodecBinary {
+		return nil, &ErrUndeclaredCodec{txId: string(entry.txId), codec: entry.info.Codec}
+	}
+	txData, err := db.fetchTxData(FetchData, entry.txId)
+	if err != nil {
+		return nil, err
+	}
+	if db.readStats != nil {
+		db.readStats.recordFetch(entry, len(txData))
+	}
+	return decompressPayload(entry, txData, db.decompressionLimit())
+}
+
+// decodeValue returns the value of key in the payload of txId. Values other
+// than strings are returned JSON encoded unless in strict mode.
+func (db *ArweaveDB) decodeValue(key string, txId []byte, raw interface{}) (string, error) {
+	value, ok := raw.(string)
+	if !ok {
+		if db.strict {
+			return "", &ErrUndecodableValue{key: key, txId: string(txId), reason: "not a string"}
+		}
+		bz, err := json.Marshal(raw)
+		return string(bz), err
+	}
+	if db.strict && strings.HasPrefix(value, valueRefPrefix) {
+		if _, isRef := parseValueRef(value); !isRef {
+			return "", &ErrUndecodableValue{key: key, txId: string(txId), reason: "malformed value reference"}
+		}
+	}
+	if db.strict && db.resolveValueRefs && strings.HasPrefix(value, valueEnvelopeMarker) {
+		_, isRef := parseValueRef(value)
+		if _, isEscaped := unescapeValue(value); !isRef && !isEscaped {
+			return "", &ErrUndecodableValue{key: key, txId: string(txId), reason: "unescaped value envelope marker"}
+		}
+	}
+	return value, nil
+}
+
+// checkDuplicateKeys fails if a key of the payload of entry is also in a
+// payload loaded earlier for the same index key prefix.
+func (itr *arweaveDBIterator) checkDuplicateKeys(entry IndexEntry, data map[string]interface{}) error {
+	if itr.groupKeys == nil || itr.groupPrefix != entry.keyPrefix {
+		itr.groupPrefix = entry.keyPrefix
+		itr.groupKeys = make(map[string]string, len(data))
+	}
+	for key := range data {
+		if txId, ok := itr.groupKeys[key]; ok {
+			return &ErrDuplicateKey{key: key, txIds: []string{txId, string(entry.txId)}}
+		}
+		itr.groupKeys[key] = string(entry.txId)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package backends_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+// iterateAll returns the keys of version 0 of db, up to the first error.
+func iterateAll(t *testing.T, db *backends.ArweaveDB) ([]string, error) {
+	iter, err := db.Iterator(arweavetest.Key(0, ""), arweavetest.Key(0, "\xff"))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	keys := []string{}
+	for {
+		batch, err := iter.(backends.BatchedIterator).NextBatch(16)
+		for _, kv := range batch {
+			keys = append(keys, string(kv.Key))
+		}
+		if err != nil || len(batch) == 0 {
+			return keys, err
+		}
+	}
+}
+
+func TestStrictDuplicateKey(t *testing.T) {
+	long := strings.Repeat("x", backends.IndexKeyPrefixLen)
+	archive, err := arweavetest.NewArchiveBuilder().
+		Prefix(long).Keys("1", "2", "3", "4").DuplicateKey("2").
+		ChunkedIndex(2).
+		Build()
+	require.Nil(t, err)
+	key := arweavetest.Key(0, long+"2")
+
+	value, err := archive.NewDB().Get(key)
+	require.Nil(t, err)
+	require.Equal(t, archive.Expected[0][long+"2"], value)
+	keys, err := iterateAll(t, archive.NewDB())
+	require.Nil(t, err)
+	require.Equal(t, []string{long + "1", long + "2", long + "2", long + "3", long + "4"}, keys)
+
+	strict := archive.NewDB(backends.WithStrictMode())
+	_, err = strict.Get(key)
+	require.ErrorAs(t, err, new(*backends.ErrDuplicateKey))
+	_, err = iterateAll(t, strict)
+	require.ErrorAs(t, err, new(*backends.ErrDuplicateKey))
+	// keys present once are fine, even if all payloads have to be checked
+	value, err = strict.Get(arweavetest.Key(0, long+"1"))
+	require.Nil(t, err)
+	require.Equal(t, archive.Expected[0][long+"1"], value)
+}
+
+func TestStrictUndecodableValue(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().
+		Keys("a").RawValue("b", `{"nested": [1, 2]}`).KV("c", "@txid:v1:").KV("d", "@d").
+		Build()
+	require.Nil(t, err)
+
+	lenient := archive.NewDB(backends.WithValueReferences())
+	for _, key := range []string{"b", "c", "d"} {
+		value, err := lenient.Get(arweavetest.Key(0, key))
+		require.Nil(t, err)
+		require.Equal(t, archive.Expected[0][key], value)
+	}
+	keys, err := iterateAll(t, lenient)
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d"}, keys)
+
+	strict := archive.NewDB(backends.WithValueReferences(), backends.WithStrictMode())
+	// unescaped values starting like references are malformed
+	for _, key := range []string{"b", "c", "d"} {
+		_, err := strict.Get(arweavetest.Key(0, key))
+		require.ErrorAs(t, err, new(*backends.ErrUndecodableValue))
+	}
+	keys, err = iterateAll(t, strict)
+	require.ErrorAs(t, err, new(*backends.ErrUndecodableValue))
+	require.Equal(t, []string{"a"}, keys)
+}
+
+func TestStrictLegacyIndex(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().Keys("a", "b").LegacyIndex().Build()
+	require.Nil(t, err)
+	value, err := archive.NewDB().Get(arweavetest.Key(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, archive.Expected[0]["a"], value)
+
+	strict := archive.NewDB(backends.WithStrictMode())
+	_, err = strict.Get(arweavetest.Key(0, "a"))
+	require.ErrorAs(t, err, new(*backends.ErrLegacyIndex))
+	_, err = iterateAll(t, strict)
+	require.ErrorAs(t, err, new(*backends.ErrLegacyIndex))
+}
+
+func TestStrictUndeclaredCodec(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().Keys("a", "b").UndeclaredCodec().Build()
+	require.Nil(t, err)
+	keys, err := iterateAll(t, archive.NewDB())
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "b"}, keys)
+
+	strict := archive.NewDB(backends.WithStrictMode())
+	_, err = strict.Get(arweavetest.Key(0, "a"))
+	require.ErrorAs(t, err, new(*backends.ErrUndeclaredCodec))
+	_, err = iterateAll(t, strict)
+	require.ErrorAs(t, err, new(*backends.ErrUndeclaredCodec))
+}
+
+func TestStrictStaleCache(t *testing.T) {
+	old, err := arweavetest.NewArchiveBuilder().KV("a", "old").Build()
+	require.Nil(t, err)
+	current, err := arweavetest.NewArchiveBuilder().KV("a", "current").Build()
+	require.Nil(t, err)
+	// the old index was cached before the version got republished
+	store := dbm.NewMemDB()
+	_, err = old.NewDB(backends.WithVersionMapCache(store)).Get(arweavetest.Key(0, "a"))
+	require.Nil(t, err)
+	for txId, data := range old.TxData {
+		current.TxData[txId] = data
+	}
+
+	value, err := current.NewDB(backends.WithVersionMapCache(store)).Get(arweavetest.Key(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("old"), value)
+
+	strict := current.NewDB(backends.WithVersionMapCache(store), backends.WithStrictMode())
+	_, err = strict.Get(arweavetest.Key(0, "a"))
+	require.ErrorAs(t, err, new(*backends.ErrStaleCache))
+}
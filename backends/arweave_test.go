@@ -5,11 +5,17 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
+// NewMockArweaveDB returns a DB whose version i has index indexList[i], and
+// whose txDataList[i] is the transaction intToBase64Sha256(txIndices[i]).
+// Tests which can run in package backends_test build their fixtures with
+// arweavetest instead, which imports this package: only the tests reaching
+// into unexported state use it.
 func NewMockArweaveDB(indexList [][]byte, txDataList [][]byte, txIndices []int) *ArweaveDB {
 	indexByVersion := map[int][]byte{}
 	txDataByTxId := map[string][]byte{}
@@ -27,7 +33,7 @@ func NewMockArweaveDB(indexList [][]byte, txDataList [][]byte, txIndices []int)
 			if txData, ok := txDataByTxId[string(txId)]; ok {
 				return txData, nil
 			} else {
-				return nil, &ErrKeyNotFound{}
+				return nil, NewErrKeyNotFound(txId)
 			}
 		},
 		versionTxIdGetter: func(version []byte) ([]byte, error) {
@@ -35,10 +41,12 @@ func NewMockArweaveDB(indexList [][]byte, txDataList [][]byte, txIndices []int)
 			if index, ok := indexByVersion[versionInt]; ok {
 				return index, nil
 			} else {
-				return nil, &ErrKeyNotFound{}
+				return nil, NewErrKeyNotFound(version)
 			}
 		},
-		closer: func() error { return nil },
+		closer:    func() error { return nil },
+		lifecycle: newLifecycle(),
+		opStats:   newOpStats(),
 	}
 }
 
@@ -63,6 +71,15 @@ func mockTxData(keys []string, values []string) []byte {
 	}
 }
 
+// mockTxDataBinary is mockTxData for binary payloads.
+func mockTxDataBinary(keys []string, values []string) []byte {
+	m := map[string]string{}
+	for i, key := range keys {
+		m[key] = values[i]
+	}
+	return encodeBinaryPayload(m)
+}
+
 func padZeroes(prefix string) []byte {
 	bz := make([]byte, IndexKeyPrefixLen)
 	copy(bz, []byte(prefix))
@@ -110,78 +127,30 @@ func TestGetIndexEntries(t *testing.T) {
 func TestGetIndexEntriesForRange(t *testing.T) {
 	// empty
 	index := mockIndex([]string{}, []int{})
-	entries := getIndexEntriesForRange("a", "b", index)
+	entries := getIndexEntriesForRange("a", []byte("b"), index)
 	require.Equal(t, 0, len(entries))
 
 	// single TX
 	index = mockIndex([]string{"ab"}, []int{0})
-	entries = getIndexEntriesForRange("aa", "ac", index)
+	entries = getIndexEntriesForRange("aa", []byte("ac"), index)
 	require.Equal(t, 1, len(entries))
-	entries = getIndexEntriesForRange("ab", "ac", index)
+	entries = getIndexEntriesForRange("ab", []byte("ac"), index)
 	require.Equal(t, 1, len(entries))
-	entries = getIndexEntriesForRange("ac", "ad", index)
+	entries = getIndexEntriesForRange("ac", []byte("ad"), index)
 	require.Equal(t, 0, len(entries))
 
 	// multiple TX
 	index = mockIndex([]string{"ab", "cd", "cd", "ce"}, []int{0, 1, 2, 3})
-	entries = getIndexEntriesForRange("ab", "cd", index)
+	entries = getIndexEntriesForRange("ab", []byte("cd"), index)
 	require.Equal(t, 3, len(entries))
-	entries = getIndexEntriesForRange("ab", "ce", index)
+	entries = getIndexEntriesForRange("ab", []byte("ce"), index)
 	require.Equal(t, 4, len(entries))
-	entries = getIndexEntriesForRange("cd", "cf", index)
+	entries = getIndexEntriesForRange("cd", []byte("cf"), index)
 	require.Equal(t, 3, len(entries))
-	entries = getIndexEntriesForRange("aa", "cf", index)
+	entries = getIndexEntriesForRange("aa", []byte("cf"), index)
 	require.Equal(t, 4, len(entries))
 }
 
-func TestGet(t *testing.T) {
-	indexV0 := mockIndex([]string{"ab", "cd", "cd", "ce"}, []int{0, 1, 2, 3})
-	indexV1 := mockIndex([]string{"ac", "cd", "ce"}, []int{4, 5, 6})
-	txData := [][]byte{
-		mockTxData([]string{"aa"}, []string{"v1"}),
-		mockTxData([]string{"cc"}, []string{"v2"}),
-		mockTxData([]string{"cd"}, []string{"v3"}),
-		mockTxData([]string{"ce"}, []string{"v4"}),
-		mockTxData([]string{"ac"}, []string{"v5"}),
-		mockTxData([]string{"cc"}, []string{"v6"}),
-		mockTxData([]string{"ce"}, []string{"v7"}),
-	}
-	mockDB := NewMockArweaveDB([][]byte{indexV0, indexV1}, txData, []int{0, 1, 2, 3, 4, 5, 6})
-	v0Bz, v1Bz := make([]byte, 8), make([]byte, 8)
-	binary.BigEndian.PutUint64(v0Bz, 0)
-	binary.BigEndian.PutUint64(v1Bz, 1)
-	value, err := mockDB.Get(append(v0Bz, []byte("aa")...))
-	require.Nil(t, err)
-	require.Equal(t, "v1", string(value))
-
-	value, err = mockDB.Get(append(v0Bz, []byte("ac")...))
-	require.Equal(t, &ErrKeyNotFound{key: "ac"}, err)
-
-	value, err = mockDB.Get(append(v0Bz, []byte("cc")...))
-	require.Nil(t, err)
-	require.Equal(t, "v2", string(value))
-
-	value, err = mockDB.Get(append(v0Bz, []byte("cd")...))
-	require.Nil(t, err)
-	require.Equal(t, "v3", string(value))
-
-	value, err = mockDB.Get(append(v0Bz, []byte("ce")...))
-	require.Nil(t, err)
-	require.Equal(t, "v4", string(value))
-
-	value, err = mockDB.Get(append(v1Bz, []byte("ac")...))
-	require.Nil(t, err)
-	require.Equal(t, "v5", string(value))
-
-	value, err = mockDB.Get(append(v1Bz, []byte("cc")...))
-	require.Nil(t, err)
-	require.Equal(t, "v6", string(value))
-
-	value, err = mockDB.Get(append(v1Bz, []byte("ce")...))
-	require.Nil(t, err)
-	require.Equal(t, "v7", string(value))
-}
-
 func TestHas(t *testing.T) {
 	indexV0 := mockIndex([]string{"ab", "cd", "cd", "ce"}, []int{0, 1, 2, 3})
 	indexV1 := mockIndex([]string{"ac", "cd", "ce"}, []int{4, 5, 6})
@@ -223,42 +192,172 @@ func TestHas(t *testing.T) {
 	require.True(t, exists)
 }
 
-func TestIterator(t *testing.T) {
-	indexV0 := mockIndex([]string{"ab", "cd", "cd", "ce"}, []int{0, 1, 2, 3})
-	indexV1 := mockIndex([]string{"ac", "cd", "ce"}, []int{4, 5, 6})
+func TestIteratorFetchesLazily(t *testing.T) {
+	index := mockIndex([]string{"ab", "cd", "ef"}, []int{0, 1, 2})
 	txData := [][]byte{
-		mockTxData([]string{"aa"}, []string{"v1"}),
-		mockTxData([]string{"cc"}, []string{"v2"}),
-		mockTxData([]string{"cd"}, []string{"v3"}),
-		mockTxData([]string{"ce"}, []string{"v4"}),
-		mockTxData([]string{"ac"}, []string{"v5"}),
-		mockTxData([]string{"cc"}, []string{"v6"}),
-		mockTxData([]string{"ce"}, []string{"v7"}),
+		mockTxData([]string{"aa", "ab"}, []string{"1", "2"}),
+		mockTxData([]string{"cc", "cd"}, []string{"3", "4"}),
+		mockTxData([]string{"ee", "ef"}, []string{"5", "6"}),
 	}
-	mockDB := NewMockArweaveDB([][]byte{indexV0, indexV1}, txData, []int{0, 1, 2, 3, 4, 5, 6})
-	v0Bz, v1Bz := make([]byte, 8), make([]byte, 8)
-	binary.BigEndian.PutUint64(v0Bz, 0)
-	binary.BigEndian.PutUint64(v1Bz, 1)
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1, 2})
+	fetched := []string{}
+	getter := mockDB.txDataByIdGetter
+	mockDB.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		fetched = append(fetched, string(txId))
+		return getter(txId)
+	}
+
+	// reading the first two keys only fetches the index and first payload
+	iter, err := mockDB.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	require.Equal(t, "aa", string(iter.Key()))
+	iter.Next()
+	require.Equal(t, "ab", string(iter.Key()))
+	require.Nil(t, iter.Close())
+	require.Equal(t, []string{intToBase64Sha256(3), intToBase64Sha256(0)}, fetched)
+
+	// payloads are fetched as they are reached
+	fetched = fetched[:0]
+	iter, err = mockDB.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	defer iter.Close()
+	for i := 0; i < 2; i++ {
+		iter.Next()
+	}
+	require.Equal(t, "cc", string(iter.Key()))
+	require.Equal(t, []string{intToBase64Sha256(3), intToBase64Sha256(0), intToBase64Sha256(1)}, fetched)
+}
+
+func TestIteratorLazyFetchErrors(t *testing.T) {
+	index := mockIndex([]string{"ab", "cd"}, []int{0, 2})
+	// the second payload is missing
+	txData := [][]byte{mockTxData([]string{"aa", "ab"}, []string{"1", "2"})}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0})
+	iter, err := mockDB.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	defer iter.Close()
+	keys := []string{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.Equal(t, []string{"aa", "ab"}, keys)
+	require.ErrorAs(t, iter.Error(), new(*ErrKeyNotFound))
+}
+
+func TestIteratorStopsOnFailures(t *testing.T) {
+	index := mockIndex([]string{"b", "d", "f", "h"}, []int{0, 1, 2, 3})
+	txData := [][]byte{
+		mockTxData([]string{"a", "b"}, []string{"1", "2"}),
+		mockTxData([]string{"c", "d"}, []string{"3", "4"}),
+		mockTxData([]string{"e", "f"}, []string{"5", "6"}),
+		mockTxData([]string{"g", "h"}, []string{"7", "8"}),
+	}
+	unavailable := errors.New("unavailable")
+	for name, fail := range map[string]func(txId []byte) ([]byte, error){
+		"fetch":  func(txId []byte) ([]byte, error) { return nil, unavailable },
+		"decode": func(txId []byte) ([]byte, error) { return []byte("{"), nil },
+	} {
+		for _, opt := range []ArweaveOption{func(*ArweaveDB) {}, WithDecodeWorkers(2), WithFetchConcurrency(4)} {
+			mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1, 2, 3})
+			opt(mockDB)
+			// the third payload of the range fails
+			ApplyMiddleware(mockDB, func(next Getter) Getter {
+				return func(txId []byte) ([]byte, error) {
+					if string(txId) == intToBase64Sha256(2) {
+						return fail(txId)
+					}
+					return next(txId)
+				}
+			})
+			iter, err := mockDB.Iterator(versionedKey(0, ""), nil)
+			require.Nil(t, err)
+			keys := []string{}
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			require.Equal(t, []string{"a", "b", "c", "d"}, keys, name)
+			require.Error(t, iter.Error(), name)
+			if name == "fetch" {
+				require.ErrorIs(t, iter.Error(), unavailable)
+			}
+			requireInvalid(t, iter)
+			require.Nil(t, iter.Close())
 
-	tester := func(start string, end string, keys []string, vals []string) {
-		iterator, err := mockDB.Iterator(append(v0Bz, []byte(start)...), append(v0Bz, []byte(end)...))
-		require.Nil(t, err)
-		reviterator, err := mockDB.ReverseIterator(append(v0Bz, []byte(start)...), append(v0Bz, []byte(end)...))
-		require.Nil(t, err)
-		require.Nil(t, err)
-		for i, key := range keys {
-			require.Equal(t, key, string(iterator.Key()))
-			require.Equal(t, vals[i], string(iterator.Value()))
-			iterator.Next()
-			require.Equal(t, keys[len(keys)-1-i], string(reviterator.Key()))
-			require.Equal(t, vals[len(keys)-1-i], string(reviterator.Value()))
-			reviterator.Next()
+			// Get keeps returning errors directly
+			_, err = mockDB.Get(versionedKey(0, "e"))
+			require.Error(t, err, name)
 		}
-		require.False(t, iterator.Valid())
-		require.False(t, reviterator.Valid())
 	}
-	tester("a", "cc", []string{"aa"}, []string{"v1"})
-	tester("aa", "cd", []string{"aa", "cc"}, []string{"v1", "v2"})
-	tester("aa", "ce", []string{"aa", "cc", "cd"}, []string{"v1", "v2", "v3"})
-	tester("aa", "cea", []string{"aa", "cc", "cd", "ce"}, []string{"v1", "v2", "v3", "v4"})
+}
+
+func TestIteratorValueFailures(t *testing.T) {
+	txData := [][]byte{
+		[]byte(`{"a":"1","b":2}`),
+		mockTxData([]string{"c", "d"}, []string{"3", "4"}),
+	}
+	// strict mode requires indices declaring codecs
+	index, err := BuildIndex([]PayloadChunk{
+		{KeyPrefix: []byte("b"), Info: IndexEntryInfo{Codec: CodecJSON}},
+		{KeyPrefix: []byte("d"), Info: IndexEntryInfo{Codec: CodecJSON}},
+	}, [][]byte{[]byte(intToBase64Sha256(0)), []byte(intToBase64Sha256(1))})
+	require.Nil(t, err)
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	WithStrictMode()(mockDB)
+	iter, err := mockDB.Iterator(versionedKey(0, ""), nil)
+	require.Nil(t, err)
+	require.Equal(t, "1", string(iter.Value()))
+	iter.Next()
+	// values which aren't strings fail in strict mode
+	require.Nil(t, iter.Value())
+	require.ErrorAs(t, iter.Error(), new(*ErrUndecodableValue))
+	requireInvalid(t, iter)
+	require.Nil(t, iter.Close())
+}
+
+func TestKeyNotFoundContext(t *testing.T) {
+	index := mockIndex([]string{"ab", "cd"}, []int{0, 2})
+	// the second payload is missing
+	txData := [][]byte{mockTxData([]string{"aa", "ab"}, []string{"1", "2"})}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0})
+
+	// the key is missing from the version
+	_, err := mockDB.Get(versionedKey(0, "a"))
+	require.ErrorIs(t, err, ErrNotFound)
+	notFound := &ErrKeyNotFound{}
+	require.ErrorAs(t, err, &notFound)
+	require.Equal(t, []byte("a"), notFound.Key())
+	version, ok := notFound.Version()
+	require.True(t, ok)
+	require.Equal(t, uint64(0), version)
+	require.Equal(t, []string{intToBase64Sha256(0)}, notFound.TxIds())
+	has, err := mockDB.Has(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.False(t, has)
+
+	// the version was never archived
+	_, err = mockDB.Get(versionedKey(1, "a"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorAs(t, err, &notFound)
+	_, ok = notFound.Version()
+	require.False(t, ok)
+
+	// the payload which may hold the key is missing
+	_, err = mockDB.Get(versionedKey(0, "cc"))
+	require.ErrorAs(t, err, &notFound)
+	version, ok = notFound.Version()
+	require.True(t, ok)
+	require.Equal(t, uint64(0), version)
+	require.Equal(t, []string{intToBase64Sha256(2)}, notFound.TxIds())
+	iter, err := mockDB.Iterator(versionedKey(0, "b"), nil)
+	if err == nil {
+		err = iter.Error()
+		iter.Close()
+	}
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorAs(t, err, &notFound)
+	require.Equal(t, []string{intToBase64Sha256(2)}, notFound.TxIds())
+	require.Contains(t, err.Error(), "not found in version 0")
+
+	// ErrNotFound matches ErrKeyNotFound only
+	require.False(t, errors.Is(errors.New("not found"), ErrNotFound))
 }
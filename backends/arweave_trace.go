@@ -0,0 +1,150 @@
+package backends
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTraceHeader is the header trace IDs are sent to gateways in.
+const DefaultTraceHeader = "X-Request-Id"
+
+type traceIDKey struct{}
+
+// ContextWithTraceID returns a context carrying traceID, which TraceHeader
+// sends along the requests made with it.
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext returns the trace ID carried by ctx, if any.
+func TraceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// TraceHeader returns a RequestDecorator setting header to the trace ID
+// carried by the context of requests, if any.
+func TraceHeader(header string) RequestDecorator {
+	return func(req *http.Request) error {
+		if traceID := TraceIDFromContext(req.Context()); traceID != "" {
+			req.Header.Set(header, traceID)
+		}
+		return nil
+	}
+}
+
+func newTraceID() string {
+	bz := make([]byte, 16)
+	if _, err := rand.Read(bz); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bz)
+}
+
+// TracedError is a failed operation of an ArweaveDB.
+type TracedError struct {
+	TraceID string
+	Op      string
+	Err     error
+	Time    time.Time
+}
+
+// tracing keeps the last failed operations in a ring buffer.
+type tracing struct {
+	mtx    sync.Mutex
+	recent []TracedError
+	next   int
+	full   bool
+}
+
+// WithTracing gives every Get, Has and iterator of the DB a trace ID, sent
+// to gateways in the DefaultTraceHeader header of all the requests made on
+// its behalf, retries included, and carried by its errors, which are then
+// ErrTraced. The last recentErrors failed operations are kept for
+// RecentErrors. Gateways of a GatewayPool send the header if their
+// requests are decorated with TraceHeader.
+//
+// The getter middlewares of the DB are applied anew to the getters of every
+// operation, so they must keep their state outside of the Getter they
+// return, as the ones composed by ChainMiddleware do.
+func WithTracing(recentErrors int) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if recentErrors < 0 {
+			db.invalidOption(fmt.Errorf("WithTracing needs a non-negative number of recent errors, got %d", recentErrors))
+			recentErrors = 0
+		}
+		db.tracing = &tracing{recent: make([]TracedError, recentErrors)}
+		for _, client := range db.httpClients() {
+			decorate := TraceHeader(DefaultTraceHeader)
+			if client.decorate != nil {
+				decorate = ChainDecorators(client.decorate, decorate)
+			}
+			client.SetRequestDecorator(decorate)
+		}
+	}
+}
+
+func (t *tracing) record(err TracedError) {
+	if len(t.recent) == 0 {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.recent[t.next] = err
+	t.next = (t.next + 1) % len(t.recent)
+	t.full = t.full || t.next == 0
+}
+
+// RecentErrors returns the last failed operations of a DB with tracing,
+// oldest first.
+func (db *ArweaveDB) RecentErrors() []TracedError {
+	if db.tracing == nil {
+		return nil
+	}
+	t := db.tracing
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if !t.full {
+		return append([]TracedError{}, t.recent[:t.next]...)
+	}
+	return append(append([]TracedError{}, t.recent[t.next:]...), t.recent[:t.next]...)
+}
+
+// startOp returns a view of db for an operation, fetching with its trace
+// ID, and a function to call with the result of the operation, which
+// reports it to the metrics collector.
+func (db *ArweaveDB) startOp(op string) (*ArweaveDB, func(error) error) {
+	start := time.Now()
+	if db.tracing == nil || db.traceID != "" {
+		return db, func(err error) error {
+			db.collector().OnOperation(op, time.Since(start), err)
+			db.recordOpError(err)
+			return err
+		}
+	}
+	view := *db
+	view.traceID = newTraceID()
+	view.bindContext(ContextWithTraceID(db.context(), view.traceID))
+	return &view, func(err error) error {
+		db.collector().OnOperation(op, time.Since(start), err)
+		db.recordOpError(err)
+		if err == nil {
+			return nil
+		}
+		db.tracing.record(TracedError{TraceID: view.traceID, Op: op, Err: err, Time: time.Now()})
+		return &ErrTraced{traceID: view.traceID, op: op, err: err}
+	}
+}
+
+// tracePrefix prefixes the log lines of traced operations.
+func (db *ArweaveDB) tracePrefix() string {
+	if db.traceID == "" {
+		return ""
+	}
+	return fmt.Sprintf("[trace %s] ", db.traceID)
+}
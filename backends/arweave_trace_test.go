@@ -0,0 +1,127 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// traceRecorder records the trace headers of the requests to a gateway.
+type traceRecorder struct {
+	mtx     sync.Mutex
+	headers []string
+}
+
+func (r *traceRecorder) authorize(req *http.Request) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.headers = append(r.headers, req.Header.Get(DefaultTraceHeader))
+	return true
+}
+
+func versionGetter(indexTxId string) Getter {
+	return func([]byte) ([]byte, error) {
+		return []byte(indexTxId), nil
+	}
+}
+
+func TestTraceIDSentOnRetries(t *testing.T) {
+	recorder := &traceRecorder{}
+	server, _ := newGatewayServer(recorder.authorize, 2)
+	defer server.Close()
+	db, err := NewArweaveDB(filepath.Join(t.TempDir(), "index"), server.URL,
+		WithTracing(4),
+		WithGetterMiddleware(retryingMiddleware(3)),
+		WithRequestDecorator(StaticHeaders(map[string]string{"X-Api-Key": "secret"})),
+	)
+	require.Nil(t, err)
+	defer db.Close()
+	// the index is the 5 byte transaction "tx", which is malformed
+	db.versionTxIdGetter = versionGetter("tx")
+	db.versionSource = IgnoringContext(db.versionTxIdGetter)
+
+	_, err = db.Get(make([]byte, 9))
+	var traced *ErrTraced
+	require.ErrorAs(t, err, &traced)
+	require.ErrorAs(t, err, new(*ErrMalformedIndex))
+	require.NotEmpty(t, traced.TraceID())
+	// two failed attempts, then the offset and chunk requests
+	require.Equal(t, []string{traced.TraceID(), traced.TraceID(), traced.TraceID(), traced.TraceID()}, recorder.headers)
+
+	// every operation has its own ID
+	_, err = db.Has(make([]byte, 9))
+	var other *ErrTraced
+	require.ErrorAs(t, err, &other)
+	require.NotEqual(t, traced.TraceID(), other.TraceID())
+	require.Equal(t, other.TraceID(), recorder.headers[len(recorder.headers)-1])
+}
+
+func TestTraceIDSentOnGatewayFallback(t *testing.T) {
+	failingRecorder, recorder := &traceRecorder{}, &traceRecorder{}
+	failing, _ := newGatewayServer(failingRecorder.authorize, 1000)
+	defer failing.Close()
+	server, _ := newGatewayServer(recorder.authorize, 0)
+	defer server.Close()
+
+	gateways := []Gateway{}
+	for _, url := range []string{failing.URL, server.URL} {
+		client, err := NewGatewayClient(GatewayConfig{URL: url, Decorate: TraceHeader(DefaultTraceHeader)})
+		require.Nil(t, err)
+		gateways = append(gateways, client)
+	}
+	db := NewArweaveDBWithGetters(nil, versionGetter("tx"), WithGatewayPool(NewGatewayPool(gateways)), WithTracing(4))
+
+	_, err := db.Get(make([]byte, 9))
+	var traced *ErrTraced
+	require.ErrorAs(t, err, &traced)
+	require.Equal(t, []string{traced.TraceID()}, failingRecorder.headers)
+	require.Equal(t, []string{traced.TraceID(), traced.TraceID()}, recorder.headers)
+}
+
+func TestRecentErrors(t *testing.T) {
+	cause := errors.New("unavailable")
+	db := NewArweaveDBWithGetters(nil, func([]byte) ([]byte, error) { return nil, cause }, WithTracing(2))
+	require.Empty(t, db.RecentErrors())
+
+	traceIDs := []string{}
+	for i := 0; i < 3; i++ {
+		_, err := db.Get(make([]byte, 9))
+		require.True(t, errors.Is(err, cause))
+		var traced *ErrTraced
+		require.ErrorAs(t, err, &traced)
+		traceIDs = append(traceIDs, traced.TraceID())
+	}
+	_, err := db.Iterator(make([]byte, 8), nil)
+	var traced *ErrTraced
+	require.ErrorAs(t, err, &traced)
+	traceIDs = append(traceIDs, traced.TraceID())
+
+	recent := db.RecentErrors()
+	require.Len(t, recent, 2)
+	require.Equal(t, traceIDs[2], recent[0].TraceID)
+	require.Equal(t, "get", recent[0].Op)
+	require.Equal(t, traceIDs[3], recent[1].TraceID)
+	require.Equal(t, "iterator", recent[1].Op)
+	require.True(t, errors.Is(recent[1].Err, cause))
+
+	// without tracing, errors are returned as is
+	_, err = NewArweaveDBWithGetters(nil, func([]byte) ([]byte, error) { return nil, cause }).Get(make([]byte, 9))
+	require.Equal(t, cause, err)
+}
+
+func TestRequestDecorationErrorTraceID(t *testing.T) {
+	client, err := NewGatewayClient(GatewayConfig{URL: "http://localhost", Decorate: func(*http.Request) error {
+		return errors.New("no credentials")
+	}})
+	require.Nil(t, err)
+	_, err = client.DownloadChunkDataContext(ContextWithTraceID(context.Background(), "trace"), "tx")
+	var decoration *ErrRequestDecoration
+	require.ErrorAs(t, err, &decoration)
+	require.Equal(t, "trace", decoration.TraceID())
+	require.Contains(t, err.Error(), "trace")
+}
@@ -0,0 +1,233 @@
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+var txCachePrefix = mustReserveSubspace("arweave_tx_cache")
+
+var (
+	// transaction data by tx ID
+	txCacheDataPrefix = Prefix(txCachePrefix.Key([]byte("data/")))
+	// size and tx ID of the cached transactions by insertion order
+	txCacheOrderPrefix = Prefix(txCachePrefix.Key([]byte("order/")))
+)
+
+// PersistentTxCache caches transaction data in a local DB, such as a
+// goleveldb one, so that restarted nodes don't download it again.
+// Transactions are immutable and never go stale, but the oldest cached
+// ones are evicted above a size. See WithPersistentCache.
+type PersistentTxCache struct {
+	db       dbm.DB
+	maxBytes int64
+
+	// serializes writes
+	mtx sync.Mutex
+	// sequence number of the next cached transaction
+	next uint64
+	// bytes of transaction data cached
+	size int64
+
+	// lookups of transactions served from the cache or not, updated
+	// atomically
+	hits, misses int64
+}
+
+// NewPersistentTxCache returns a cache storing transaction data in the
+// reserved namespace of db, evicting the oldest cached transactions once
+// they exceed maxBytes, 0 meaning no limit.
+func NewPersistentTxCache(db dbm.DB, maxBytes int64) (*PersistentTxCache, error) {
+	c := &PersistentTxCache{db: metadataDB(db), maxBytes: maxBytes}
+	iter, err := dbm.IteratePrefix(c.db, txCacheOrderPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		seq, size, _, err := parseTxCacheOrder(iter.Key(), iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		c.next = seq + 1
+		c.size += size
+	}
+	return c, iter.Error()
+}
+
+func txCacheOrderKey(seq uint64) []byte {
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, seq)
+	return txCacheOrderPrefix.Key(bz)
+}
+
+func parseTxCacheOrder(key, value []byte) (seq uint64, size int64, txId []byte, err error) {
+	key = key[len(txCacheOrderPrefix):]
+	if len(key) != 8 || len(value) < 8 {
+		return 0, 0, nil, fmt.Errorf("invalid transaction cache entry %X", key)
+	}
+	return binary.BigEndian.Uint64(key), int64(binary.BigEndian.Uint64(value)), value[8:], nil
+}
+
+// Get returns the cached data of txId, if any.
+func (c *PersistentTxCache) Get(txId []byte) ([]byte, bool, error) {
+	data, err := c.db.Get(txCacheDataPrefix.Key(txId))
+	return data, data != nil, err
+}
+
+// Put caches the data of txId, then evicts the oldest transactions above
+// the size limit.
+func (c *PersistentTxCache) Put(txId, data []byte) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if has, err := c.db.Has(txCacheDataPrefix.Key(txId)); err != nil || has {
+		return err
+	}
+	order := make([]byte, 8, 8+len(txId))
+	binary.BigEndian.PutUint64(order, uint64(len(data)))
+	order = append(order, txId...)
+	batch := c.db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set(txCacheDataPrefix.Key(txId), data); err != nil {
+		return err
+	}
+	if err := batch.Set(txCacheOrderKey(c.next), order); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	c.next++
+	c.size += int64(len(data))
+	if c.maxBytes > 0 && c.size > c.maxBytes {
+		_, err := c.prune(c.maxBytes)
+		return err
+	}
+	return nil
+}
+
+// Size returns the bytes of transaction data cached.
+func (c *PersistentTxCache) Size() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.size
+}
+
+// Stats returns how many fetches were served from the cache or not.
+func (c *PersistentTxCache) Stats() CacheStats {
+	return CacheStats{Hits: atomic.LoadInt64(&c.hits), Misses: atomic.LoadInt64(&c.misses)}
+}
+
+// record counts a lookup, reporting it to observe if set.
+func (c *PersistentTxCache) record(hit bool, observe func(hit bool)) {
+	if hit {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+	if observe != nil {
+		observe(hit)
+	}
+}
+
+// Prune evicts the oldest cached transactions until at most maxBytes of
+// them are left, returning the number of transactions evicted.
+func (c *PersistentTxCache) Prune(maxBytes int64) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.prune(maxBytes)
+}
+
+func (c *PersistentTxCache) prune(maxBytes int64) (int, error) {
+	iter, err := dbm.IteratePrefix(c.db, txCacheOrderPrefix)
+	if err != nil {
+		return 0, err
+	}
+	batch := c.db.NewBatch()
+	defer batch.Close()
+	evicted, size := 0, c.size
+	for ; iter.Valid() && size > maxBytes; iter.Next() {
+		_, entrySize, txId, err := parseTxCacheOrder(iter.Key(), iter.Value())
+		if err == nil {
+			err = batch.Delete(txCacheDataPrefix.Key(txId))
+		}
+		if err == nil {
+			err = batch.Delete(txCacheValidatorsPrefix.Key(txId))
+		}
+		if err == nil {
+			err = batch.Delete(append([]byte{}, iter.Key()...))
+		}
+		if err != nil {
+			iter.Close()
+			return 0, err
+		}
+		evicted++
+		size -= entrySize
+	}
+	err = iter.Error()
+	iter.Close()
+	if err != nil {
+		return 0, err
+	}
+	if evicted == 0 {
+		return 0, nil
+	}
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	c.size = size
+	return evicted, nil
+}
+
+// Middleware returns a middleware serving transaction data from the cache,
+// and caching what is fetched. Failures to read or write the cache are
+// logged with logf rather than failing fetches.
+func (c *PersistentTxCache) Middleware(logf func(format string, v ...interface{})) GetterMiddleware {
+	return c.middleware(logf, nil)
+}
+
+// middleware is Middleware reporting whether lookups hit the cache to
+// observe, if set.
+func (c *PersistentTxCache) middleware(logf func(format string, v ...interface{}), observe func(hit bool)) GetterMiddleware {
+	return func(next Getter) Getter {
+		return func(txId []byte) ([]byte, error) {
+			data, ok, err := c.Get(txId)
+			if err != nil {
+				logf("reading transaction %s from the cache: %v", txId, err)
+			} else if ok {
+				c.record(true, observe)
+				return data, nil
+			}
+			c.record(false, observe)
+			data, err = next(txId)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.Put(txId, data); err != nil {
+				logf("caching transaction %s: %v", txId, err)
+			}
+			return data, nil
+		}
+	}
+}
+
+// WithPersistentCache serves the transaction data of the ArweaveDB being
+// constructed from cache, outside of the middlewares applied so far, and
+// caches what is fetched. Failures of the cache are logged, see WithLogger.
+func WithPersistentCache(cache *PersistentTxCache) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if cache == nil {
+			db.invalidOption(errors.New("WithPersistentCache is given a nil cache"))
+			return
+		}
+		db.setTxCache("WithPersistentCache")
+		observe := func(hit bool) { db.recordCacheLookup(CacheRaw, hit) }
+		applyGetterMiddleware(db, cache.middleware(db.logf, observe), func(next Getter) Getter { return next })
+		db.txCache = cache
+	}
+}
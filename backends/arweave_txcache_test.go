@@ -0,0 +1,112 @@
+package backends
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// countingTxDataGetter counts the transaction data fetches of db.
+func countingTxDataGetter(db *ArweaveDB, calls *int) {
+	getter := db.txDataByIdGetter
+	var mtx sync.Mutex
+	db.txDataByIdGetter = func(txId []byte) ([]byte, error) {
+		mtx.Lock()
+		*calls++
+		mtx.Unlock()
+		return getter(txId)
+	}
+}
+
+func TestPersistentCacheSurvivesRestarts(t *testing.T) {
+	local := dbm.NewMemDB()
+	scan := func() int {
+		base := newDecodeTestDB(6, 3, 2)
+		cache, err := NewPersistentTxCache(local, 0)
+		require.Nil(t, err)
+		calls := 0
+		countingTxDataGetter(base, &calls)
+		db := NewArweaveDBWithGetters(base.txDataByIdGetter, base.versionTxIdGetter, WithPersistentCache(cache))
+		iter, err := db.Iterator(versionedKey(0, ""), nil)
+		require.Nil(t, err)
+		defer iter.Close()
+		require.Len(t, collectPairs(t, iter), 18)
+		value, err := db.Get(versionedKey(0, "0002/000001"))
+		require.Nil(t, err)
+		require.Len(t, value, 2)
+		return calls
+	}
+	// the index and payloads
+	require.Equal(t, 7, scan())
+	require.Equal(t, 0, scan())
+
+	// the cache lives in the reserved namespace
+	iter, err := Iterate(local, nil, nil)
+	require.Nil(t, err)
+	require.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestPersistentCachePruning(t *testing.T) {
+	local := dbm.NewMemDB()
+	cache, err := NewPersistentTxCache(local, 0)
+	require.Nil(t, err)
+	for _, txId := range []string{"a", "b", "c", "d"} {
+		require.Nil(t, cache.Put([]byte(txId), make([]byte, 10)))
+	}
+	// cached once
+	require.Nil(t, cache.Put([]byte("a"), make([]byte, 10)))
+	require.Equal(t, int64(40), cache.Size())
+
+	evicted, err := cache.Prune(25)
+	require.Nil(t, err)
+	require.Equal(t, 2, evicted)
+	for txId, cached := range map[string]bool{"a": false, "b": false, "c": true, "d": true} {
+		_, ok, err := cache.Get([]byte(txId))
+		require.Nil(t, err)
+		require.Equal(t, cached, ok, txId)
+	}
+
+	// sizes and order are loaded back, and the limit enforced on writes
+	cache, err = NewPersistentTxCache(local, 30)
+	require.Nil(t, err)
+	require.Equal(t, int64(20), cache.Size())
+	require.Nil(t, cache.Put([]byte("e"), make([]byte, 15)))
+	require.Equal(t, int64(25), cache.Size())
+	_, ok, err := cache.Get([]byte("c"))
+	require.Nil(t, err)
+	require.False(t, ok)
+}
+
+// failingWritesDB fails batch writes.
+type failingWritesDB struct {
+	dbm.DB
+}
+
+func (db failingWritesDB) NewBatch() dbm.Batch {
+	return failingWritesBatch{db.DB.NewBatch()}
+}
+
+type failingWritesBatch struct {
+	dbm.Batch
+}
+
+func (failingWritesBatch) Write() error {
+	return errors.New("disk full")
+}
+
+func TestPersistentCacheFailuresDontFailReads(t *testing.T) {
+	base := newDecodeTestDB(2, 3, 2)
+	cache, err := NewPersistentTxCache(failingWritesDB{dbm.NewMemDB()}, 0)
+	require.Nil(t, err)
+	logger := &recordingLogger{}
+	db := NewArweaveDBWithGetters(base.txDataByIdGetter, base.versionTxIdGetter, WithPersistentCache(cache), WithLogger(logger))
+	value, err := db.Get(versionedKey(0, "0001/000001"))
+	require.Nil(t, err)
+	require.Len(t, value, 2)
+	require.Len(t, logger.lines, 2)
+	require.Contains(t, logger.lines[0], "disk full")
+}
@@ -0,0 +1,263 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// validators of the cached transactions, by tx ID
+var txCacheValidatorsPrefix = Prefix(txCachePrefix.Key([]byte("validators/")))
+
+// HTTPValidators are the validators of a gateway response, which requests
+// conditional on them get a 304 Not Modified response to if the resource
+// didn't change.
+type HTTPValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// IsZero returns whether the response carried no validator, in which case
+// requests can't be conditional.
+func (v HTTPValidators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+func (v HTTPValidators) header() http.Header {
+	header := http.Header{}
+	if v.ETag != "" {
+		header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		header.Set("If-Modified-Since", v.LastModified)
+	}
+	return header
+}
+
+func validatorsOf(header http.Header) HTTPValidators {
+	return HTTPValidators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+}
+
+type revalidationKey struct{}
+
+// ContextWithRevalidation returns a context making the fetches of
+// conditional caches check with the gateway that cached data is still
+// current, e.g. once it failed an integrity check. See also
+// ReadOptions.Revalidate.
+func ContextWithRevalidation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revalidationKey{}, true)
+}
+
+// RevalidationRequested returns whether ctx asks for cached data to be
+// revalidated.
+func RevalidationRequested(ctx context.Context) bool {
+	revalidate, _ := ctx.Value(revalidationKey{}).(bool)
+	return revalidate
+}
+
+// GetConditional gets path from the gateway, conditional on validators if
+// any, the way mutable resources such as manifests or retraction records
+// are refreshed. It returns the body and validators of the response, or
+// notModified without a body if the gateway responded 304 Not Modified,
+// the validators being those of the response if it has any.
+func (c *Client) GetConditional(ctx context.Context, path string, validators HTTPValidators) (body []byte, fresh HTTPValidators, notModified bool, err error) {
+	body, statusCode, header, err := c.httpGetWithHeader(ctx, path, validators.header())
+	if err != nil {
+		return nil, HTTPValidators{}, false, err
+	}
+	switch statusCode {
+	case http.StatusOK:
+		return body, validatorsOf(header), false, nil
+	case http.StatusNotModified:
+		if fresh = validatorsOf(header); fresh.IsZero() {
+			fresh = validators
+		}
+		return nil, fresh, true, nil
+	}
+	return nil, HTTPValidators{}, false, &ErrGatewayStatus{what: "get " + path, statusCode: statusCode}
+}
+
+// ConditionalGateway is implemented by gateways able to download
+// transaction data conditionally. Client implements it.
+type ConditionalGateway interface {
+	DownloadChunkDataConditional(ctx context.Context, id string, validators HTTPValidators) (data []byte, fresh HTTPValidators, notModified bool, err error)
+}
+
+var _ ConditionalGateway = (*Client)(nil)
+
+// DownloadChunkDataConditional is DownloadChunkDataContext conditional on
+// validators, see GetConditional. The validators are those of the offset of
+// the transaction, whose chunks aren't downloaded if it isn't modified.
+func (c *Client) DownloadChunkDataConditional(ctx context.Context, id string, validators HTTPValidators) ([]byte, HTTPValidators, bool, error) {
+	body, fresh, notModified, err := c.GetConditional(ctx, fmt.Sprintf("tx/%s/offset", id), validators)
+	if err != nil || notModified {
+		return nil, fresh, notModified, err
+	}
+	txOffset := &TransactionOffset{}
+	if err := json.Unmarshal(body, txOffset); err != nil {
+		return nil, HTTPValidators{}, false, err
+	}
+	data, err := c.downloadChunks(ctx, txOffset)
+	return data, fresh, false, err
+}
+
+// Validators returns the validators the data of txId was cached with, if
+// any.
+func (c *PersistentTxCache) Validators(txId []byte) (HTTPValidators, bool, error) {
+	bz, err := c.db.Get(txCacheValidatorsPrefix.Key(txId))
+	if err != nil || bz == nil {
+		return HTTPValidators{}, false, err
+	}
+	validators := HTTPValidators{}
+	if err := json.Unmarshal(bz, &validators); err != nil {
+		return HTTPValidators{}, false, fmt.Errorf("invalid validators of transaction %s: %w", txId, err)
+	}
+	return validators, true, nil
+}
+
+// PutValidators sets the validators of the cached data of txId, deleting
+// them if zero.
+func (c *PersistentTxCache) PutValidators(txId []byte, validators HTTPValidators) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.putValidators(txId, validators)
+}
+
+func (c *PersistentTxCache) putValidators(txId []byte, validators HTTPValidators) error {
+	if validators.IsZero() {
+		return c.db.Delete(txCacheValidatorsPrefix.Key(txId))
+	}
+	bz, err := json.Marshal(validators)
+	if err != nil {
+		return err
+	}
+	return c.db.Set(txCacheValidatorsPrefix.Key(txId), bz)
+}
+
+// replace caches data as the data of txId with validators, in place of the
+// data cached so far if it differs.
+func (c *PersistentTxCache) replace(txId, data []byte, validators HTTPValidators) error {
+	cached, ok, err := c.Get(txId)
+	if err != nil {
+		return err
+	}
+	if ok && !bytes.Equal(cached, data) {
+		if err := c.evict(txId); err != nil {
+			return err
+		}
+	}
+	if err := c.Put(txId, data); err != nil {
+		return err
+	}
+	return c.PutValidators(txId, validators)
+}
+
+// evict evicts the data of txId, scanning for its place in the eviction
+// order.
+func (c *PersistentTxCache) evict(txId []byte) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	iter, err := dbm.IteratePrefix(c.db, txCacheOrderPrefix)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		_, size, orderTxId, err := parseTxCacheOrder(iter.Key(), iter.Value())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(orderTxId, txId) {
+			continue
+		}
+		batch := c.db.NewBatch()
+		defer batch.Close()
+		for _, key := range [][]byte{txCacheDataPrefix.Key(txId), txCacheValidatorsPrefix.Key(txId), append([]byte{}, iter.Key()...)} {
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		c.size -= size
+		return nil
+	}
+	return iter.Error()
+}
+
+// ConditionalSource returns a source of transaction data serving it from
+// the cache, and caching what it downloads from gateway along with its
+// validators. Fetches with a context asking for revalidation download
+// cached data again conditionally on its validators, a 304 Not Modified
+// response refreshing them, or unconditionally if the gateway sent none.
+// Failures to write the cache are logged with logf rather than failing
+// fetches.
+func (c *PersistentTxCache) ConditionalSource(gateway ConditionalGateway, logf func(format string, v ...interface{})) ContextGetter {
+	return c.conditionalSource(gateway, logf, nil)
+}
+
+// conditionalSource is ConditionalSource reporting whether lookups hit the
+// cache to observe, if set.
+func (c *PersistentTxCache) conditionalSource(gateway ConditionalGateway, logf func(format string, v ...interface{}), observe func(hit bool)) ContextGetter {
+	return func(ctx context.Context, txId []byte) ([]byte, error) {
+		cached, ok, err := c.Get(txId)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !RevalidationRequested(ctx) {
+			c.record(true, observe)
+			return cached, nil
+		}
+		validators := HTTPValidators{}
+		if ok {
+			if validators, _, err = c.Validators(txId); err != nil {
+				logf("reading the validators of transaction %s: %v", txId, err)
+			}
+		}
+		data, fresh, notModified, err := gateway.DownloadChunkDataConditional(ctx, string(txId), validators)
+		if err != nil {
+			return nil, err
+		}
+		if notModified {
+			if !ok {
+				return nil, errors.New("gateway responded 304 Not Modified to an unconditional request")
+			}
+			if err := c.PutValidators(txId, fresh); err != nil {
+				logf("refreshing the validators of transaction %s: %v", txId, err)
+			}
+			c.record(true, observe)
+			return cached, nil
+		}
+		c.record(false, observe)
+		if err := c.replace(txId, data, fresh); err != nil {
+			logf("caching transaction %s: %v", txId, err)
+		}
+		return data, nil
+	}
+}
+
+// WithConditionalCache makes the ArweaveDB being constructed fetch
+// transaction data from client through cache, in place of its source, e.g.
+// the gateway of NewArweaveDB, below the middlewares applied so far. Reads
+// with ReadOptions.Revalidate revalidate cached data, see
+// ConditionalSource.
+func WithConditionalCache(cache *PersistentTxCache, client *Client) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if cache == nil || client == nil {
+			db.invalidOption(errors.New("WithConditionalCache needs both a cache and a client"))
+			return
+		}
+		db.setTxDataSource("WithConditionalCache")
+		db.setTxCache("WithConditionalCache")
+		observe := func(hit bool) { db.recordCacheLookup(CacheRaw, hit) }
+		db.txDataSource = cache.conditionalSource(client, db.logf, observe)
+		db.txCache = cache
+		db.txDataByIdGetter = db.bindGetter(db.context(), db.txDataSource, db.txDataMiddleware, nil)
+	}
+}
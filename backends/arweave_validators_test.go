@@ -0,0 +1,238 @@
+package backends
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// validatingGateway serves transactions with an ETag derived from their
+// generation, answering requests with a matching If-None-Match with 304 Not
+// Modified.
+type validatingGateway struct {
+	mtx         sync.Mutex
+	txs         map[string][]byte
+	generations map[string]int
+	omitETags   bool
+	// offset requests, those conditional, and chunk requests served
+	offsets, conditional, chunks int
+}
+
+func newValidatingGateway(t *testing.T, txs map[string][]byte) (*validatingGateway, *Client) {
+	g := &validatingGateway{txs: txs, generations: map[string]int{}}
+	server := httptest.NewServer(g)
+	t.Cleanup(server.Close)
+	return g, NewClient(server.URL)
+}
+
+func (g *validatingGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/tx/") && strings.HasSuffix(r.URL.Path, "/offset"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tx/"), "/offset")
+		data, ok := g.txs[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		g.offsets++
+		etag := fmt.Sprintf(`"%d"`, g.generations[id])
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			g.conditional++
+			if match == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		if !g.omitETags {
+			w.Header().Set("ETag", etag)
+		}
+		start := 1000 * (1 + g.index(id))
+		fmt.Fprintf(w, `{"size": "%d", "offset": "%d"}`, len(data), start+len(data)-1)
+	case strings.HasPrefix(r.URL.Path, "/chunk/"):
+		offset, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/chunk/"))
+		for id, data := range g.txs {
+			if 1000*(1+g.index(id)) == offset {
+				g.chunks++
+				fmt.Fprintf(w, `{"chunk": "%s"}`, base64.RawURLEncoding.EncodeToString(data))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// index returns the rank of id among the served transactions.
+func (g *validatingGateway) index(id string) int {
+	rank := 0
+	for other := range g.txs {
+		if other < id {
+			rank++
+		}
+	}
+	return rank
+}
+
+func (g *validatingGateway) update(id string, data []byte) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.txs[id] = data
+	g.generations[id]++
+}
+
+// requests returns and resets the request counters.
+func (g *validatingGateway) requests() (offsets, conditional, chunks int) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	offsets, conditional, chunks = g.offsets, g.conditional, g.chunks
+	g.offsets, g.conditional, g.chunks = 0, 0, 0
+	return
+}
+
+// newConditionalTestDB returns a DB fetching version 0 from g through a
+// conditional cache in local.
+func newConditionalTestDB(t *testing.T, local dbm.DB, client *Client) *ArweaveDB {
+	cache, err := NewPersistentTxCache(local, 0)
+	require.Nil(t, err)
+	versions := func(version []byte) ([]byte, error) {
+		return []byte(intToBase64Sha256(1)), nil
+	}
+	unused := func([]byte) ([]byte, error) {
+		t.Fatal("fetched around the cache")
+		return nil, nil
+	}
+	return NewArweaveDBWithGetters(unused, versions, WithConditionalCache(cache, client))
+}
+
+func conditionalTestTxs() map[string][]byte {
+	return map[string][]byte{
+		intToBase64Sha256(0): mockTxData([]string{"a", "b"}, []string{"1", "2"}),
+		intToBase64Sha256(1): mockIndex([]string{"b"}, []int{0}),
+	}
+}
+
+func requireValue(t *testing.T, db *ArweaveDB, key, expected string) {
+	value, err := db.Get(versionedKey(0, key))
+	require.Nil(t, err)
+	require.Equal(t, expected, string(value))
+}
+
+func TestConditionalCacheNotModified(t *testing.T) {
+	g, client := newValidatingGateway(t, conditionalTestTxs())
+	local := dbm.NewMemDB()
+	db := newConditionalTestDB(t, local, client)
+
+	requireValue(t, db, "a", "1")
+	offsets, conditional, chunks := g.requests()
+	require.Equal(t, []int{2, 0, 2}, []int{offsets, conditional, chunks})
+	requireValue(t, db, "a", "1")
+	offsets, _, _ = g.requests()
+	require.Equal(t, 0, offsets)
+
+	// 304s refresh the cached data without downloading it again
+	revalidating := db.WithReadOptions(ReadOptions{Revalidate: true})
+	requireValue(t, revalidating, "a", "1")
+	offsets, conditional, chunks = g.requests()
+	require.Equal(t, []int{2, 2, 0}, []int{offsets, conditional, chunks})
+
+	// validators are persisted across restarts
+	db = newConditionalTestDB(t, local, client)
+	requireValue(t, db.WithReadOptions(ReadOptions{Revalidate: true}), "b", "2")
+	offsets, conditional, chunks = g.requests()
+	require.Equal(t, []int{2, 2, 0}, []int{offsets, conditional, chunks})
+	requireValue(t, db, "b", "2")
+	offsets, _, _ = g.requests()
+	require.Equal(t, 0, offsets)
+}
+
+func TestConditionalCacheModified(t *testing.T) {
+	g, client := newValidatingGateway(t, conditionalTestTxs())
+	local := dbm.NewMemDB()
+	cache, err := NewPersistentTxCache(local, 0)
+	require.Nil(t, err)
+	source := cache.ConditionalSource(client, t.Logf)
+	revalidation := ContextWithRevalidation(context.Background())
+	id := []byte(intToBase64Sha256(0))
+
+	data, err := source(context.Background(), id)
+	require.Nil(t, err)
+	require.Equal(t, int64(len(data)), cache.Size())
+
+	updated := mockTxData([]string{"a", "b", "c"}, []string{"1", "2", "3"})
+	g.update(string(id), updated)
+	data, err = source(context.Background(), id)
+	require.Nil(t, err)
+	require.NotEqual(t, updated, data)
+
+	data, err = source(revalidation, id)
+	require.Nil(t, err)
+	require.Equal(t, updated, data)
+	require.Equal(t, int64(len(updated)), cache.Size())
+	validators, ok, err := cache.Validators(id)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, `"1"`, validators.ETag)
+
+	// the replaced data is accounted for once loaded back
+	cache, err = NewPersistentTxCache(local, 0)
+	require.Nil(t, err)
+	require.Equal(t, int64(len(updated)), cache.Size())
+}
+
+func TestConditionalCacheWithoutValidators(t *testing.T) {
+	g, client := newValidatingGateway(t, conditionalTestTxs())
+	g.omitETags = true
+	local := dbm.NewMemDB()
+	db := newConditionalTestDB(t, local, client)
+	requireValue(t, db, "a", "1")
+	g.requests()
+
+	// without validators, revalidating downloads everything again
+	requireValue(t, db.WithReadOptions(ReadOptions{Revalidate: true}), "a", "1")
+	offsets, conditional, chunks := g.requests()
+	require.Equal(t, []int{2, 0, 2}, []int{offsets, conditional, chunks})
+
+	cache, err := NewPersistentTxCache(local, 0)
+	require.Nil(t, err)
+	_, ok, err := cache.Validators([]byte(intToBase64Sha256(0)))
+	require.Nil(t, err)
+	require.False(t, ok)
+}
+
+func TestGetConditional(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == "Mon, 01 Jan 2024 00:00:00 GMT" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		require.Nil(t, json.NewEncoder(w).Encode(map[string]string{"versions": "{}"}))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	body, validators, notModified, err := client.GetConditional(context.Background(), "retractions", HTTPValidators{})
+	require.Nil(t, err)
+	require.False(t, notModified)
+	require.NotEmpty(t, body)
+	require.Equal(t, HTTPValidators{LastModified: "Mon, 01 Jan 2024 00:00:00 GMT"}, validators)
+
+	body, fresh, notModified, err := client.GetConditional(context.Background(), "retractions", validators)
+	require.Nil(t, err)
+	require.True(t, notModified)
+	require.Nil(t, body)
+	require.Equal(t, validators, fresh)
+}
@@ -0,0 +1,123 @@
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// VersionCodec encodes the version prefixing the keys of an ArweaveDB. The
+// encoded version is also what the version getter is queried with.
+type VersionCodec interface {
+	// Name identifies the codec, e.g. in fixture manifests.
+	Name() string
+	// Width returns the length of encoded versions, or 0 if it varies.
+	Width() int
+	Encode(version uint64) ([]byte, error)
+	// Decode returns the version prefixing key and the length of its
+	// encoding.
+	Decode(key []byte) (version uint64, n int, err error)
+}
+
+var (
+	// BigEndian64VersionCodec encodes versions as 8 bytes, big endian. It is
+	// the default.
+	BigEndian64VersionCodec VersionCodec = bigEndianVersionCodec{width: 8}
+	// BigEndian32VersionCodec encodes versions as 4 bytes, big endian.
+	BigEndian32VersionCodec VersionCodec = bigEndianVersionCodec{width: 4}
+	// UvarintVersionCodec encodes versions as unsigned varints, whose last
+	// byte is the first one without its most significant bit set.
+	UvarintVersionCodec VersionCodec = uvarintVersionCodec{}
+)
+
+// VersionCodecByName returns the built-in codec with the given name.
+func VersionCodecByName(name string) (VersionCodec, error) {
+	for _, codec := range []VersionCodec{BigEndian64VersionCodec, BigEndian32VersionCodec, UvarintVersionCodec} {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown version codec %q", name)
+}
+
+// WithVersionCodec makes the ArweaveDB parse versions with codec instead of
+// BigEndian64VersionCodec.
+func WithVersionCodec(codec VersionCodec) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if codec == nil {
+			db.invalidOption(errors.New("WithVersionCodec is given a nil codec"))
+			return
+		}
+		db.versionCodec = codec
+	}
+}
+
+type bigEndianVersionCodec struct {
+	width int
+}
+
+func (c bigEndianVersionCodec) Name() string {
+	return fmt.Sprintf("be%d", c.width*8)
+}
+
+func (c bigEndianVersionCodec) Width() int {
+	return c.width
+}
+
+func (c bigEndianVersionCodec) Encode(version uint64) ([]byte, error) {
+	if c.width < 8 && version>>(8*c.width) != 0 {
+		return nil, fmt.Errorf("version %d doesn't fit in %d bytes", version, c.width)
+	}
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, version)
+	return bz[8-c.width:], nil
+}
+
+func (c bigEndianVersionCodec) Decode(key []byte) (uint64, int, error) {
+	if len(key) < c.width {
+		return 0, 0, fmt.Errorf("key %X is shorter than its %d bytes version", key, c.width)
+	}
+	bz := make([]byte, 8)
+	copy(bz[8-c.width:], key[:c.width])
+	return binary.BigEndian.Uint64(bz), c.width, nil
+}
+
+type uvarintVersionCodec struct{}
+
+func (uvarintVersionCodec) Name() string {
+	return "uvarint"
+}
+
+func (uvarintVersionCodec) Width() int {
+	return 0
+}
+
+func (uvarintVersionCodec) Encode(version uint64) ([]byte, error) {
+	bz := make([]byte, binary.MaxVarintLen64)
+	return bz[:binary.PutUvarint(bz, version)], nil
+}
+
+func (uvarintVersionCodec) Decode(key []byte) (uint64, int, error) {
+	version, n := binary.Uvarint(key)
+	if n <= 0 {
+		return 0, 0, fmt.Errorf("key %X doesn't start with a varint version", key)
+	}
+	return version, n, nil
+}
+
+func (db *ArweaveDB) codec() VersionCodec {
+	if db.versionCodec == nil {
+		return BigEndian64VersionCodec
+	}
+	return db.versionCodec
+}
+
+// splitKey returns the version prefixing key and the unversioned key.
+// Reads resolve versionless keys with resolveKey instead.
+func (db *ArweaveDB) splitKey(key []byte) (uint64, []byte, error) {
+	version, n, err := db.codec().Decode(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, key[n:], nil
+}
@@ -0,0 +1,56 @@
+package backends
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionCodecs(t *testing.T) {
+	for _, codec := range []VersionCodec{BigEndian64VersionCodec, BigEndian32VersionCodec, UvarintVersionCodec} {
+		found, err := VersionCodecByName(codec.Name())
+		require.Nil(t, err)
+		require.Equal(t, codec, found)
+		for _, version := range []uint64{0, 1, 300, math.MaxUint32} {
+			bz, err := codec.Encode(version)
+			require.Nil(t, err)
+			if codec.Width() != 0 {
+				require.Equal(t, codec.Width(), len(bz))
+			}
+			decoded, n, err := codec.Decode(append(bz, "key"...))
+			require.Nil(t, err)
+			require.Equal(t, version, decoded)
+			require.Equal(t, len(bz), n)
+		}
+	}
+	_, err := VersionCodecByName("be16")
+	require.NotNil(t, err)
+
+	bz, err := BigEndian32VersionCodec.Encode(1)
+	require.Nil(t, err)
+	require.Equal(t, []byte{0, 0, 0, 1}, bz)
+	_, err = BigEndian32VersionCodec.Encode(math.MaxUint32 + 1)
+	require.NotNil(t, err)
+	_, _, err = BigEndian64VersionCodec.Decode([]byte{0, 0, 0, 1})
+	require.NotNil(t, err)
+	_, _, err = UvarintVersionCodec.Decode([]byte{0x80})
+	require.NotNil(t, err)
+}
+
+func TestArweaveDBVersionCodec(t *testing.T) {
+	var queried []byte
+	db := NewArweaveDBWithGetters(
+		func(txId []byte) ([]byte, error) { return nil, NewErrKeyNotFound(txId) },
+		func(version []byte) ([]byte, error) {
+			queried = version
+			return nil, NewErrKeyNotFound(version)
+		},
+		WithVersionCodec(UvarintVersionCodec),
+	)
+	_, err := db.Get([]byte{0xac, 0x02, 'k'})
+	require.NotNil(t, err)
+	require.Equal(t, []byte{0xac, 0x02}, queried)
+	_, err = db.Get([]byte{0x80})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,215 @@
+package backends
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// versionMapPrefix is where resolved version mappings are persisted in the
+// version map store.
+var versionMapPrefix = mustReserveSubspace("arweave_version_map")
+
+// Exported version maps start with versionMapMagic, followed by one record
+// per version made of the version (8 bytes), the length of the index tx ID
+// (2 bytes) and the tx ID, all big endian.
+const versionMapMagic = "ARWVMAP\x01"
+
+type versionMap struct {
+	store dbm.DB
+
+	mtx   sync.RWMutex
+	txIds map[uint64]string
+}
+
+// WithVersionMapCache makes the ArweaveDB remember the index tx IDs resolved
+// through the version getter, persisting them in store so that they survive
+// restarts. The cache is best effort: mappings failing to load or persist
+// are resolved again.
+func WithVersionMapCache(store dbm.DB) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if store == nil {
+			db.invalidOption(errors.New("WithVersionMapCache is given a nil store"))
+			return
+		}
+		db.versionMap = &versionMap{store: metadataDB(store), txIds: map[uint64]string{}}
+		db.versionMap.load()
+	}
+}
+
+func (m *versionMap) load() {
+	iter, err := m.store.Iterator(versionMapPrefix, prefixEnd(versionMapPrefix))
+	if err != nil {
+		return
+	}
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()[len(versionMapPrefix):]
+		if len(key) != 8 {
+			continue
+		}
+		m.txIds[binary.BigEndian.Uint64(key)] = string(iter.Value())
+	}
+}
+
+func versionMapKey(version uint64) []byte {
+	key := make([]byte, len(versionMapPrefix)+8)
+	copy(key, versionMapPrefix)
+	binary.BigEndian.PutUint64(key[len(versionMapPrefix):], version)
+	return key
+}
+
+func (m *versionMap) get(version uint64) (string, bool) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	txId, ok := m.txIds[version]
+	return txId, ok
+}
+
+func (m *versionMap) put(version uint64, txId string) error {
+	m.mtx.Lock()
+	m.txIds[version] = txId
+	m.mtx.Unlock()
+	return m.store.Set(versionMapKey(version), []byte(txId))
+}
+
+func (m *versionMap) delete(version uint64) error {
+	m.mtx.Lock()
+	delete(m.txIds, version)
+	m.mtx.Unlock()
+	return m.store.Delete(versionMapKey(version))
+}
+
+// getVersionTxId queries the version getter with the encoding of version.
+func (db *ArweaveDB) getVersionTxId(version uint64) ([]byte, error) {
+	versionBz, err := db.codec().Encode(version)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	txId, err := db.callGetter(db.versionTxIdGetter, versionBz)
+	db.recordFetch(FetchVersion, len(txId), time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return decompressTxId(txId, version, db.decompressionLimit())
+}
+
+func (db *ArweaveDB) resolveVersion(version uint64) ([]byte, error) {
+	if db.versionMap == nil {
+		return db.getVersionTxId(version)
+	}
+	if cached, ok := db.versionMap.get(version); ok {
+		if !db.strict {
+			return []byte(cached), nil
+		}
+		txId, err := db.getVersionTxId(version)
+		if err != nil {
+			return nil, err
+		}
+		if string(txId) != cached {
+			return nil, &ErrStaleCache{version: version, cached: cached, current: string(txId)}
+		}
+		return txId, nil
+	}
+	txId, err := db.getVersionTxId(version)
+	if err != nil {
+		return nil, err
+	}
+	// failing to persist only costs resolving again after a restart
+	_ = db.versionMap.put(version, string(txId))
+	return txId, nil
+}
+
+var errVersionMapDisabled = errors.New("version map cache is not enabled")
+
+// InvalidateVersion forgets the cached index tx ID of version, e.g. once it
+// turns out to be stale.
+func (db *ArweaveDB) InvalidateVersion(version uint64) error {
+	if db.versionMap == nil {
+		return errVersionMapDisabled
+	}
+	if db.indexCache != nil {
+		db.indexCache.invalidate(version)
+	}
+	return db.versionMap.delete(version)
+}
+
+// ExportVersionMap writes the cached version mappings to w, in ascending
+// version order.
+func (db *ArweaveDB) ExportVersionMap(w io.Writer) error {
+	if db.versionMap == nil {
+		return errVersionMapDisabled
+	}
+	db.versionMap.mtx.RLock()
+	versions := make([]uint64, 0, len(db.versionMap.txIds))
+	txIds := make(map[uint64]string, len(db.versionMap.txIds))
+	for version, txId := range db.versionMap.txIds {
+		versions = append(versions, version)
+		txIds[version] = txId
+	}
+	db.versionMap.mtx.RUnlock()
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(versionMapMagic); err != nil {
+		return err
+	}
+	header := make([]byte, 10)
+	for _, version := range versions {
+		txId := txIds[version]
+		if len(txId) > 0xffff {
+			return fmt.Errorf("tx ID of version %d is too long", version)
+		}
+		binary.BigEndian.PutUint64(header[:8], version)
+		binary.BigEndian.PutUint16(header[8:], uint16(len(txId)))
+		if _, err := bw.Write(header); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(txId); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportVersionMap adds the version mappings exported by ExportVersionMap to
+// the cache, overriding cached ones, and returns how many were imported.
+func (db *ArweaveDB) ImportVersionMap(r io.Reader) (int, error) {
+	if db.versionMap == nil {
+		return 0, errVersionMapDisabled
+	}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(versionMapMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != versionMapMagic {
+		return 0, errors.New("not an exported version map")
+	}
+	imported := 0
+	header := make([]byte, 10)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("truncated version map record %d: %w", imported, err)
+		}
+		txId := make([]byte, binary.BigEndian.Uint16(header[8:]))
+		if _, err := io.ReadFull(br, txId); err != nil {
+			return imported, fmt.Errorf("truncated version map record %d: %w", imported, err)
+		}
+		version := binary.BigEndian.Uint64(header[:8])
+		if err := db.versionMap.put(version, string(txId)); err != nil {
+			return imported, err
+		}
+		if db.indexCache != nil {
+			db.indexCache.invalidate(version)
+		}
+		imported++
+	}
+}
@@ -0,0 +1,110 @@
+package backends
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// newVersionedMockDB returns a mock with 3 versions, each holding key "a"
+// with the version number as value, along with a counter of version getter
+// calls.
+func newVersionedMockDB(store dbm.DB) (*ArweaveDB, *int) {
+	indices := [][]byte{}
+	txData := [][]byte{}
+	for v := 0; v < 3; v++ {
+		indices = append(indices, mockIndex([]string{"b"}, []int{v}))
+		txData = append(txData, mockTxData([]string{"a"}, []string{string(rune('0' + v))}))
+	}
+	mockDB := NewMockArweaveDB(indices, txData, []int{0, 1, 2})
+	resolutions := 0
+	getter := mockDB.versionTxIdGetter
+	mockDB.versionTxIdGetter = func(version []byte) ([]byte, error) {
+		resolutions++
+		return getter(version)
+	}
+	WithVersionMapCache(store)(mockDB)
+	return mockDB, &resolutions
+}
+
+func requireVersionedValue(t *testing.T, db *ArweaveDB, version uint64) {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, version)
+	value, err := db.Get(append(key, 'a'))
+	require.Nil(t, err)
+	require.Equal(t, string(rune('0'+version)), string(value))
+}
+
+func TestVersionMapCache(t *testing.T) {
+	store := dbm.NewMemDB()
+	db, resolutions := newVersionedMockDB(store)
+	for i := 0; i < 2; i++ {
+		for v := uint64(0); v < 3; v++ {
+			requireVersionedValue(t, db, v)
+		}
+	}
+	require.Equal(t, 3, *resolutions)
+
+	// restarting with the same store
+	restarted, resolutions := newVersionedMockDB(store)
+	for v := uint64(0); v < 3; v++ {
+		requireVersionedValue(t, restarted, v)
+	}
+	require.Equal(t, 0, *resolutions)
+
+	require.Nil(t, restarted.InvalidateVersion(1))
+	requireVersionedValue(t, restarted, 1)
+	require.Equal(t, 1, *resolutions)
+}
+
+func TestVersionMapExportImport(t *testing.T) {
+	db, _ := newVersionedMockDB(dbm.NewMemDB())
+	for v := uint64(0); v < 2; v++ {
+		requireVersionedValue(t, db, v)
+	}
+	exported := bytes.Buffer{}
+	require.Nil(t, db.ExportVersionMap(&exported))
+	require.Equal(t, len(versionMapMagic)+2*(10+Sha256Base64Len), exported.Len())
+
+	// a cold start with the imported map
+	cold, resolutions := newVersionedMockDB(dbm.NewMemDB())
+	imported, err := cold.ImportVersionMap(bytes.NewReader(exported.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, 2, imported)
+	for v := uint64(0); v < 2; v++ {
+		requireVersionedValue(t, cold, v)
+	}
+	require.Equal(t, 0, *resolutions)
+	// versions not covered are resolved
+	requireVersionedValue(t, cold, 2)
+	require.Equal(t, 1, *resolutions)
+
+	reexported := bytes.Buffer{}
+	require.Nil(t, cold.ExportVersionMap(&reexported))
+	require.True(t, bytes.HasPrefix(reexported.Bytes(), exported.Bytes()))
+
+	_, err = cold.ImportVersionMap(bytes.NewReader([]byte("garbage")))
+	require.NotNil(t, err)
+	imported, err = cold.ImportVersionMap(bytes.NewReader(exported.Bytes()[:exported.Len()-1]))
+	require.NotNil(t, err)
+	require.Equal(t, 1, imported)
+
+	_, err = NewMockArweaveDB(nil, nil, nil).ImportVersionMap(bytes.NewReader(exported.Bytes()))
+	require.NotNil(t, err)
+}
+
+func TestVersionMapRetractionsTakePrecedence(t *testing.T) {
+	db, _ := newVersionedMockDB(dbm.NewMemDB())
+	requireVersionedValue(t, db, 0)
+	// version 0 now points to the index of version 1
+	WithRetractions(func() ([]byte, string, error) {
+		return []byte(`{"versions": {"0": "` + intToBase64Sha256(3+1) + `"}}`), "owner", nil
+	}, func(string) error { return nil })(db)
+	require.Nil(t, db.RefreshRetractions())
+	value, err := db.Get(append(make([]byte, 8), 'a'))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+}
@@ -0,0 +1,194 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// DefaultVersionProbeNegativeTTL is how long HasVersion remembers that a
+	// version is missing by default.
+	DefaultVersionProbeNegativeTTL = 10 * time.Second
+	// DefaultVersionProbeFutureTTL is how long HasVersion remembers that a
+	// version beyond the latest archived one is missing, unless the negative
+	// TTL is shorter. Such versions are about to be archived.
+	DefaultVersionProbeFutureTTL = time.Second
+)
+
+// versionProbes caches the results of HasVersion. Archived versions stay
+// archived, so positive results are kept for good, while missing versions
+// may get archived and are probed again once negativeTTL has elapsed, or
+// futureTTL for versions beyond the latest one.
+type versionProbes struct {
+	negativeTTL time.Duration
+	futureTTL   time.Duration
+	now         func() time.Time
+
+	mtx     sync.Mutex
+	present map[uint64]bool
+	// expiry of the missing versions
+	missing map[uint64]time.Time
+}
+
+func newVersionProbes(negativeTTL time.Duration) *versionProbes {
+	futureTTL := DefaultVersionProbeFutureTTL
+	if futureTTL > negativeTTL {
+		futureTTL = negativeTTL
+	}
+	return &versionProbes{
+		negativeTTL: negativeTTL,
+		futureTTL:   futureTTL,
+		now:         time.Now,
+		present:     map[uint64]bool{},
+		missing:     map[uint64]time.Time{},
+	}
+}
+
+// WithVersionProbeCache sets how long HasVersion remembers that a version
+// is missing, 0 disabling the caching of missing versions. Versions beyond
+// the latest archived one are remembered for at most
+// DefaultVersionProbeFutureTTL.
+func WithVersionProbeCache(negativeTTL time.Duration) ArweaveOption {
+	return func(db *ArweaveDB) {
+		if negativeTTL < 0 {
+			db.invalidOption(fmt.Errorf("WithVersionProbeCache needs a non-negative TTL, got %s", negativeTTL))
+		}
+		db.versionProbes = newVersionProbes(negativeTTL)
+	}
+}
+
+func (p *versionProbes) get(version uint64) (exists bool, ok bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.present[version] {
+		return true, true
+	}
+	if expiry, ok := p.missing[version]; ok {
+		if p.now().Before(expiry) {
+			return false, true
+		}
+		delete(p.missing, version)
+	}
+	return false, false
+}
+
+func (p *versionProbes) put(version uint64, exists bool) {
+	p.putProbe(version, exists, p.negativeTTL)
+}
+
+// putFuture is put for a version beyond the latest one.
+func (p *versionProbes) putFuture(version uint64, exists bool) {
+	p.putProbe(version, exists, p.futureTTL)
+}
+
+func (p *versionProbes) putProbe(version uint64, exists bool, negativeTTL time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if exists {
+		p.present[version] = true
+	} else if negativeTTL > 0 {
+		p.missing[version] = p.now().Add(negativeTTL)
+	}
+}
+
+// isVersionNotFound returns whether err is how the version getter reports
+// a version without index.
+func isVersionNotFound(err error) bool {
+	return errors.As(err, new(*ErrKeyNotFound)) || errors.Is(err, leveldb.ErrNotFound)
+}
+
+// HasVersion returns whether the archive has version, only resolving the
+// tx ID of its index, not fetching it. Probes go through the middlewares of
+// the version getter, rate limiters and circuit breakers included.
+func (db *ArweaveDB) HasVersion(version uint64) (bool, error) {
+	if db.versionProbes != nil {
+		if exists, ok := db.versionProbes.get(version); ok {
+			return exists, nil
+		}
+	}
+	txId, err := db.getIndexTxId(version)
+	if err != nil && !isVersionNotFound(err) {
+		return false, err
+	}
+	exists := err == nil && len(txId) > 0
+	if db.versionProbes != nil {
+		if latest, ok := db.cachedLatestVersion(); ok && version > latest {
+			db.versionProbes.putFuture(version, exists)
+		} else {
+			db.versionProbes.put(version, exists)
+		}
+	}
+	return exists, nil
+}
+
+// ValidateVersionRange probes the versions from from to to, inclusive, with
+// up to parallelism probes at once, and returns the missing ones in
+// ascending order. See ValidateVersionRangeContext.
+func (db *ArweaveDB) ValidateVersionRange(from, to uint64, parallelism int) ([]uint64, error) {
+	return db.ValidateVersionRangeContext(context.Background(), from, to, parallelism)
+}
+
+// ValidateVersionRangeContext is ValidateVersionRange stopping promptly
+// when ctx is cancelled, returning the missing versions found so far along
+// with the context error. A probe failing stops the validation too.
+func (db *ArweaveDB) ValidateVersionRangeContext(ctx context.Context, from, to uint64, parallelism int) ([]uint64, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid version range from %d to %d", from, to)
+	}
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mtx      sync.Mutex
+		missing  = []uint64{}
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, parallelism)
+	for version := from; ; version++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(version uint64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			exists, err := db.HasVersion(version)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("probing version %d: %w", version, err)
+				}
+				cancel()
+				return
+			}
+			if !exists {
+				missing = append(missing, version)
+			}
+		}(version)
+		if version == to {
+			break
+		}
+	}
+	wg.Wait()
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return missing, firstErr
+}
@@ -0,0 +1,156 @@
+package backends
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sparseVersionGetter resolves the versions from first and below versions
+// except those of gaps, counting its calls.
+type sparseVersionGetter struct {
+	first    uint64
+	versions uint64
+	gaps     map[uint64]bool
+
+	mtx   sync.Mutex
+	calls int
+}
+
+func (g *sparseVersionGetter) get(versionBz []byte) ([]byte, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.calls++
+	version := binary.BigEndian.Uint64(versionBz)
+	if version < g.first || version >= g.versions || g.gaps[version] {
+		return nil, &ErrKeyNotFound{}
+	}
+	return []byte(intToBase64Sha256(int(version))), nil
+}
+
+func (g *sparseVersionGetter) callCount() int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.calls
+}
+
+func TestHasVersion(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 10, gaps: map[uint64]bool{3: true}}
+	db := NewArweaveDBWithGetters(nil, versions.get, WithVersionProbeCache(time.Minute))
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	db.versionProbes.now = clock.Now
+
+	for _, tc := range []struct {
+		version uint64
+		exists  bool
+	}{{0, true}, {3, false}, {9, true}, {10, false}} {
+		exists, err := db.HasVersion(tc.version)
+		require.Nil(t, err)
+		require.Equal(t, tc.exists, exists, "version %d", tc.version)
+	}
+	require.Equal(t, 4, versions.callCount())
+
+	// both positive and negative results are cached
+	for _, version := range []uint64{0, 3, 9, 10} {
+		_, err := db.HasVersion(version)
+		require.Nil(t, err)
+	}
+	require.Equal(t, 4, versions.callCount())
+
+	// missing versions are probed again once the negative TTL has elapsed
+	versions.mtx.Lock()
+	versions.versions = 11
+	versions.mtx.Unlock()
+	clock.Advance(time.Minute)
+	exists, err := db.HasVersion(10)
+	require.Nil(t, err)
+	require.True(t, exists)
+	exists, err = db.HasVersion(0)
+	require.Nil(t, err)
+	require.True(t, exists)
+	require.Equal(t, 5, versions.callCount())
+}
+
+func TestHasVersionThroughMiddleware(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 10}
+	calls := 0
+	open := errors.New("circuit open")
+	breaker := func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			calls++
+			if binary.BigEndian.Uint64(key) == 5 {
+				return nil, open
+			}
+			return next(key)
+		}
+	}
+	db := NewArweaveDBWithGetters(nil, versions.get, WithGetterMiddleware(breaker))
+	exists, err := db.HasVersion(4)
+	require.Nil(t, err)
+	require.True(t, exists)
+	// failures aren't mistaken for missing versions, nor cached
+	for i := 0; i < 2; i++ {
+		_, err = db.HasVersion(5)
+		require.ErrorIs(t, err, open)
+	}
+	require.Equal(t, 3, calls)
+}
+
+func TestValidateVersionRange(t *testing.T) {
+	gaps := map[uint64]bool{0: true, 17: true, 18: true, 64: true}
+	versions := &sparseVersionGetter{versions: 100, gaps: gaps}
+	db := NewArweaveDBWithGetters(nil, versions.get)
+	for _, parallelism := range []int{0, 1, 8} {
+		missing, err := db.ValidateVersionRange(0, 101, parallelism)
+		require.Nil(t, err)
+		require.Equal(t, []uint64{0, 17, 18, 64, 100, 101}, missing)
+	}
+	missing, err := db.ValidateVersionRange(20, 20, 4)
+	require.Nil(t, err)
+	require.Empty(t, missing)
+	_, err = db.ValidateVersionRange(2, 1, 4)
+	require.NotNil(t, err)
+}
+
+func TestValidateVersionRangeProbeFailure(t *testing.T) {
+	versions := &sparseVersionGetter{versions: 1000}
+	open := errors.New("circuit open")
+	breaker := func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			if binary.BigEndian.Uint64(key) >= 10 {
+				return nil, open
+			}
+			return next(key)
+		}
+	}
+	db := NewArweaveDBWithGetters(nil, versions.get, WithGetterMiddleware(breaker))
+	missing, err := db.ValidateVersionRange(0, 999, 1)
+	require.ErrorIs(t, err, open)
+	require.Empty(t, missing)
+	require.Equal(t, 10, versions.callCount())
+}
+
+func TestValidateVersionRangeCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	versions := &sparseVersionGetter{versions: 1000, gaps: map[uint64]bool{2: true}}
+	cancelling := func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			if binary.BigEndian.Uint64(key) == 50 {
+				cancel()
+			}
+			return next(key)
+		}
+	}
+	db := NewArweaveDBWithGetters(nil, versions.get, WithGetterMiddleware(cancelling))
+	missing, err := db.ValidateVersionRangeContext(ctx, 0, 999, 4)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []uint64{2}, missing)
+	// in-flight probes complete, but no new ones are started
+	require.Less(t, versions.callCount(), 60)
+}
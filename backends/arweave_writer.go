@@ -0,0 +1,195 @@
+package backends
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Upload is a transaction of an archived version to publish.
+type Upload struct {
+	Version uint64
+	// whether Data is the index of the version rather than a payload
+	Index bool
+	// whether Data is the key filter of a payload, see
+	// ChunkPolicy.KeyFilterBitsPerKey
+	KeyFilter bool
+	// whether Data is a value spilled from a payload, see WithValueSpill
+	Value bool
+	Data  []byte
+}
+
+// Uploader publishes a transaction, returning its ID, which must be
+// Sha256Base64Len bytes long.
+type Uploader func(Upload) ([]byte, error)
+
+// ArweaveWriter archives versions in the format read by ArweaveDB. The
+// key-value pairs of a version are buffered until FlushVersion publishes
+// them as payloads along with their index, so that the archival pipeline
+// doesn't need to know the format.
+type ArweaveWriter struct {
+	upload Uploader
+	policy ChunkPolicy
+	// values larger than spillAbove bytes are spilled if positive
+	spillAbove int
+
+	mtx sync.Mutex
+	// unversioned key-value pairs of the versions not flushed yet
+	pending map[uint64]map[string][]byte
+}
+
+// ArweaveWriterOption configures an ArweaveWriter.
+type ArweaveWriterOption func(*ArweaveWriter)
+
+// WithValueSpill makes the writer publish values larger than threshold
+// bytes as their own transactions, stored in payloads as references to
+// them, and escape the other values, see EscapeValue. Readers of the
+// archive need WithValueReferences.
+func WithValueSpill(threshold int) ArweaveWriterOption {
+	return func(w *ArweaveWriter) {
+		w.spillAbove = threshold
+	}
+}
+
+// NewArweaveWriter returns a writer publishing transactions with upload,
+// splitting versions into payloads according to policy.
+func NewArweaveWriter(upload Uploader, policy ChunkPolicy, opts ...ArweaveWriterOption) *ArweaveWriter {
+	w := &ArweaveWriter{upload: upload, policy: policy, pending: map[uint64]map[string][]byte{}}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Set sets the value of the unversioned key at version. Unless the chunk
+// policy of the writer makes binary payloads, keys and values must be valid
+// UTF-8.
+func (w *ArweaveWriter) Set(version uint64, key, value []byte) error {
+	if value == nil {
+		return errValueNil
+	}
+	return w.apply(version, []batchOp{{key: key, value: value}})
+}
+
+// Delete removes the unversioned key from version. Versions are archived
+// whole, so only keys set since the version was last flushed can be
+// deleted.
+func (w *ArweaveWriter) Delete(version uint64, key []byte) error {
+	return w.apply(version, []batchOp{{key: key}})
+}
+
+// apply applies the ops on unversioned keys to version, all of them or
+// none if one is invalid.
+func (w *ArweaveWriter) apply(version uint64, ops []batchOp) error {
+	for _, op := range ops {
+		if len(op.key) == 0 {
+			return errKeyEmpty
+		}
+		if w.policy.Codec != CodecBinary && (!utf8.Valid(op.key) || !utf8.Valid(op.value)) {
+			return fmt.Errorf("key %X or its value is not valid UTF-8", op.key)
+		}
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	kvs, ok := w.pending[version]
+	if !ok {
+		kvs = map[string][]byte{}
+		w.pending[version] = kvs
+	}
+	for _, op := range ops {
+		if op.value == nil {
+			delete(kvs, string(op.key))
+		} else {
+			kvs[string(op.key)] = append([]byte{}, op.value...)
+		}
+	}
+	if len(kvs) == 0 {
+		delete(w.pending, version)
+	}
+	return nil
+}
+
+// PendingVersions returns the versions with buffered keys in ascending
+// order.
+func (w *ArweaveWriter) PendingVersions() []uint64 {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	versions := make([]uint64, 0, len(w.pending))
+	for version := range w.pending {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// spillValues returns kvs in the envelope of WithValueSpill, publishing the
+// values to spill, or kvs if values aren't spilled.
+func (w *ArweaveWriter) spillValues(version uint64, kvs map[string][]byte) (map[string][]byte, error) {
+	if w.spillAbove <= 0 {
+		return kvs, nil
+	}
+	stored := make(map[string][]byte, len(kvs))
+	for key, value := range kvs {
+		if len(value) <= w.spillAbove {
+			stored[key] = EscapeValue(value)
+			continue
+		}
+		txId, err := w.upload(Upload{Version: version, Value: true, Data: value})
+		if err != nil {
+			return nil, fmt.Errorf("uploading the value of %X at version %d: %w", key, version, err)
+		}
+		stored[key] = []byte(EncodeValueRef(string(txId)))
+	}
+	return stored, nil
+}
+
+// FlushVersion publishes the values of version to spill, if any, then its
+// payloads, along with their key filters if the chunk policy builds them,
+// then its index, returning
+// the ID of the index transaction, which the version getter of readers
+// must map version to. A version without keys gets an empty index. The
+// buffered keys are only dropped once the index is published, so that a
+// failed flush can be retried, republishing all the payloads.
+func (w *ArweaveWriter) FlushVersion(version uint64) ([]byte, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	kvs, err := w.spillValues(version, w.pending[version])
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := w.policy.Chunk(kvs)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", version, err)
+	}
+	txIds := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		if txIds[i], err = w.upload(Upload{Version: version, Data: chunk.Payload}); err != nil {
+			return nil, fmt.Errorf("uploading payload %d of version %d: %w", i, version, err)
+		}
+	}
+	var index []byte
+	if w.policy.KeyFilterBitsPerKey > 0 {
+		keyFilterTxIds := make([][]byte, len(chunks))
+		for i, chunk := range chunks {
+			if keyFilterTxIds[i], err = w.upload(Upload{Version: version, KeyFilter: true, Data: chunk.KeyFilter}); err != nil {
+				return nil, fmt.Errorf("uploading the key filter of payload %d of version %d: %w", i, version, err)
+			}
+		}
+		index, err = BuildIndexWithKeyFilters(chunks, txIds, keyFilterTxIds)
+	} else {
+		index, err = BuildIndex(chunks, txIds)
+	}
+	if err == nil {
+		index, err = CompressIndex(index, w.policy.Compression)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", version, err)
+	}
+	indexTxId, err := w.upload(Upload{Version: version, Index: true, Data: index})
+	if err != nil {
+		return nil, fmt.Errorf("uploading the index of version %d: %w", version, err)
+	}
+	delete(w.pending, version)
+	return indexTxId, nil
+}
@@ -0,0 +1,223 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mockUploads collects uploads in the layout of NewMockArweaveDB.
+type mockUploads struct {
+	indices   [][]byte
+	txData    [][]byte
+	txIndices []int
+	// uploads left before failing, if not negative
+	failIn int
+}
+
+func (u *mockUploads) upload(upload Upload) ([]byte, error) {
+	if u.failIn == 0 {
+		return nil, errors.New("upload failed")
+	}
+	u.failIn--
+	if upload.Index {
+		for len(u.indices) <= int(upload.Version) {
+			u.indices = append(u.indices, nil)
+		}
+		u.indices[upload.Version] = upload.Data
+		return []byte("index"), nil
+	}
+	u.txIndices = append(u.txIndices, len(u.txData))
+	u.txData = append(u.txData, upload.Data)
+	return []byte(intToBase64Sha256(len(u.txData) - 1)), nil
+}
+
+func (u *mockUploads) db() *ArweaveDB {
+	return NewMockArweaveDB(u.indices, u.txData, u.txIndices)
+}
+
+func TestArweaveWriterRoundTrip(t *testing.T) {
+	uploads := &mockUploads{failIn: -1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{MaxKeysPerPayload: 3})
+	expected := map[uint64][]KVPair{}
+	for version := uint64(0); version < 2; version++ {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key%02d", i)
+			if i%2 == 0 {
+				key = fmt.Sprintf("a-very-long-shared-key-prefix/%02d", i)
+			}
+			value := fmt.Sprintf("%s@%d", key, version)
+			require.Nil(t, w.Set(version, []byte(key), []byte(value)))
+		}
+		require.Nil(t, w.Set(version, []byte("deleted"), []byte("v")))
+		require.Nil(t, w.Delete(version, []byte("deleted")))
+	}
+	require.Equal(t, []uint64{0, 1}, w.PendingVersions())
+	for version := uint64(0); version < 2; version++ {
+		txId, err := w.FlushVersion(version)
+		require.Nil(t, err)
+		require.Equal(t, "index", string(txId))
+		pairs := []KVPair{}
+		for i := 0; i < 10; i += 2 {
+			key := fmt.Sprintf("a-very-long-shared-key-prefix/%02d", i)
+			pairs = append(pairs, KVPair{Key: []byte(key), Value: []byte(fmt.Sprintf("%s@%d", key, version))})
+		}
+		for i := 1; i < 10; i += 2 {
+			key := fmt.Sprintf("key%02d", i)
+			pairs = append(pairs, KVPair{Key: []byte(key), Value: []byte(fmt.Sprintf("%s@%d", key, version))})
+		}
+		expected[version] = pairs
+	}
+	require.Empty(t, w.PendingVersions())
+
+	db := uploads.db()
+	for version, pairs := range expected {
+		// 10 keys in payloads of 3 keys at most
+		require.Len(t, parseIndex(uploads.indices[version]), 4)
+		for _, pair := range pairs {
+			value, err := db.Get(versionedKey(version, string(pair.Key)))
+			require.Nil(t, err)
+			require.Equal(t, string(pair.Value), string(value))
+		}
+		has, err := db.Has(versionedKey(version, "deleted"))
+		require.Nil(t, err)
+		require.False(t, has)
+
+		iter, err := db.Iterator(versionedKey(version, "a"), versionedKey(version, "z"))
+		require.Nil(t, err)
+		require.Equal(t, pairs, collectPairs(t, iter))
+		require.Nil(t, iter.Close())
+	}
+}
+
+func TestArweaveWriterZeroSuffixedKeys(t *testing.T) {
+	// "a" and "a\x00" share the same padded index prefix, so that the
+	// payloads they are split into must not continue into "a\x00b"
+	keys := []string{"0", "a", "a\x00", "a\x00b", "a\x00\x00", "b"}
+	for _, maxKeys := range []int{1, 2, 3} {
+		uploads := &mockUploads{failIn: -1}
+		w := NewArweaveWriter(uploads.upload, ChunkPolicy{MaxKeysPerPayload: maxKeys, Codec: CodecBinary})
+		for _, key := range keys {
+			require.Nil(t, w.Set(0, []byte(key), []byte("v"+key)))
+		}
+		_, err := w.FlushVersion(0)
+		require.Nil(t, err)
+
+		db := uploads.db()
+		pairs := []KVPair{}
+		for _, key := range []string{"0", "a", "a\x00", "a\x00\x00", "a\x00b", "b"} {
+			value, err := db.Get(versionedKey(0, key))
+			require.Nil(t, err, "%q in payloads of %d keys", key, maxKeys)
+			require.Equal(t, "v"+key, string(value))
+			has, err := db.Has(versionedKey(0, key))
+			require.Nil(t, err)
+			require.True(t, has, "%q in payloads of %d keys", key, maxKeys)
+			pairs = append(pairs, KVPair{Key: []byte(key), Value: value})
+		}
+		iter, err := db.Iterator(versionedKey(0, ""), nil)
+		require.Nil(t, err)
+		require.Equal(t, pairs, collectPairs(t, iter))
+	}
+}
+
+func TestArweaveWriterPayloadBytes(t *testing.T) {
+	uploads := &mockUploads{failIn: -1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{TargetPayloadBytes: 100})
+	for i := 0; i < 20; i++ {
+		require.Nil(t, w.Set(0, []byte(fmt.Sprintf("k%02d", i)), []byte("0123456789")))
+	}
+	_, err := w.FlushVersion(0)
+	require.Nil(t, err)
+	require.Greater(t, len(uploads.txData), 1)
+	for _, payload := range uploads.txData {
+		require.LessOrEqual(t, len(payload), 100)
+	}
+	db := uploads.db()
+	for i := 0; i < 20; i++ {
+		value, err := db.Get(versionedKey(0, fmt.Sprintf("k%02d", i)))
+		require.Nil(t, err)
+		require.Equal(t, "0123456789", string(value))
+	}
+}
+
+func TestArweaveWriterValueSpill(t *testing.T) {
+	uploads := &mockUploads{failIn: -1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{}, WithValueSpill(64))
+	kvs := map[string]string{
+		"huge":    strings.Repeat("v", 100),
+		"small":   "small",
+		"literal": EncodeValueRef(intToBase64Sha256(0)),
+		"escaped": "@@",
+	}
+	for key, value := range kvs {
+		require.Nil(t, w.Set(0, []byte(key), []byte(value)))
+	}
+	_, err := w.FlushVersion(0)
+	require.Nil(t, err)
+	// the spilled value and the payload
+	require.Len(t, uploads.txData, 2)
+	require.Equal(t, kvs["huge"], string(uploads.txData[0]))
+
+	db := uploads.db()
+	WithValueReferences()(db)
+	for key, value := range kvs {
+		stored, err := db.Get(versionedKey(0, key))
+		require.Nil(t, err)
+		require.Equal(t, value, string(stored), key)
+	}
+	iter, err := db.Iterator(versionedKey(0, "a"), versionedKey(0, "z"))
+	require.Nil(t, err)
+	for _, pair := range collectPairs(t, iter) {
+		require.Equal(t, kvs[string(pair.Key)], string(pair.Value))
+	}
+	require.Nil(t, iter.Close())
+}
+
+func TestArweaveWriterCompression(t *testing.T) {
+	uploads := &mockUploads{failIn: -1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{MaxKeysPerPayload: 2, Compression: CompressionGzip})
+	for i := 0; i < 5; i++ {
+		require.Nil(t, w.Set(0, []byte(fmt.Sprintf("k%d", i)), []byte(strings.Repeat("v", 100))))
+	}
+	_, err := w.FlushVersion(0)
+	require.Nil(t, err)
+	require.Equal(t, CompressionGzip, TxCompression(uploads.indices[0][IndexHeaderLen-1]>>indexCompressionShift))
+	for _, payload := range uploads.txData {
+		require.Less(t, len(payload), 100)
+	}
+	db := uploads.db()
+	for i := 0; i < 5; i++ {
+		value, err := db.Get(versionedKey(0, fmt.Sprintf("k%d", i)))
+		require.Nil(t, err)
+		require.Equal(t, strings.Repeat("v", 100), string(value))
+	}
+}
+
+func TestArweaveWriterFailedFlush(t *testing.T) {
+	uploads := &mockUploads{failIn: 1}
+	w := NewArweaveWriter(uploads.upload, ChunkPolicy{})
+	require.Nil(t, w.Set(0, []byte("a"), []byte("1")))
+	_, err := w.FlushVersion(0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "uploading the index of version 0")
+	require.Equal(t, []uint64{0}, w.PendingVersions())
+
+	uploads.failIn = -1
+	_, err = w.FlushVersion(0)
+	require.Nil(t, err)
+	value, err := uploads.db().Get(versionedKey(0, "a"))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+}
+
+func TestArweaveWriterInvalidPairs(t *testing.T) {
+	w := NewArweaveWriter((&mockUploads{failIn: -1}).upload, ChunkPolicy{})
+	require.Equal(t, errKeyEmpty, w.Set(0, nil, []byte("v")))
+	require.Equal(t, errValueNil, w.Set(0, []byte("k"), nil))
+	require.Error(t, w.Set(0, []byte("\xff"), []byte("v")))
+	require.Error(t, w.Set(0, []byte("k"), []byte("\xff")))
+	require.Empty(t, w.PendingVersions())
+}
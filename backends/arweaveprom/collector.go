@@ -0,0 +1,127 @@
+// Package arweaveprom exports the metrics of ArweaveDBs to Prometheus,
+// keeping the Prometheus client out of the backends package.
+package arweaveprom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+// Collector is a backends.MetricsCollector maintaining Prometheus metrics,
+// labeled with the kind of fetch or cache, or the operation, and the
+// backends.ErrorClass of the outcome.
+type Collector struct {
+	fetches       *prometheus.CounterVec
+	fetchBytes    *prometheus.CounterVec
+	fetchDuration *prometheus.HistogramVec
+	cacheLookups  *prometheus.CounterVec
+	iterators     prometheus.Counter
+	operations    *prometheus.CounterVec
+	opDuration    *prometheus.HistogramVec
+}
+
+var (
+	_ backends.MetricsCollector = (*Collector)(nil)
+	_ prometheus.Collector      = (*Collector)(nil)
+)
+
+// NewCollector returns a collector of metrics named namespace_arweave_*,
+// to be registered with a prometheus.Registerer and passed to
+// backends.WithMetricsCollector.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "arweave",
+			Name:      "fetches_total",
+			Help:      "Fetches from the getters, by kind and error class.",
+		}, []string{"kind", "result"}),
+		fetchBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "arweave",
+			Name:      "fetched_bytes_total",
+			Help:      "Bytes fetched from the getters, by kind.",
+		}, []string{"kind"}),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "arweave",
+			Name:      "fetch_duration_seconds",
+			Help:      "Duration of the fetches from the getters, by kind.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"kind"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "arweave",
+			Name:      "cache_lookups_total",
+			Help:      "Cache lookups, by cache and whether they hit.",
+		}, []string{"cache", "result"}),
+		iterators: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "arweave",
+			Name:      "iterators_created_total",
+			Help:      "Iterators created.",
+		}),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "arweave",
+			Name:      "operations_total",
+			Help:      "Gets, Has and iterator creations, by error class.",
+		}, []string{"op", "result"}),
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "arweave",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of Gets, Has and iterator creations.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"op"}),
+	}
+}
+
+// OnFetch implements backends.MetricsCollector.
+func (c *Collector) OnFetch(kind backends.FetchKind, bytes int, duration time.Duration, err error) {
+	c.fetches.WithLabelValues(string(kind), backends.ErrorClass(err)).Inc()
+	c.fetchBytes.WithLabelValues(string(kind)).Add(float64(bytes))
+	c.fetchDuration.WithLabelValues(string(kind)).Observe(duration.Seconds())
+}
+
+// OnCacheHit implements backends.MetricsCollector.
+func (c *Collector) OnCacheHit(cache string) {
+	c.cacheLookups.WithLabelValues(cache, "hit").Inc()
+}
+
+// OnCacheMiss implements backends.MetricsCollector.
+func (c *Collector) OnCacheMiss(cache string) {
+	c.cacheLookups.WithLabelValues(cache, "miss").Inc()
+}
+
+// OnIteratorCreated implements backends.MetricsCollector.
+func (c *Collector) OnIteratorCreated() {
+	c.iterators.Inc()
+}
+
+// OnOperation implements backends.MetricsCollector.
+func (c *Collector) OnOperation(op string, duration time.Duration, err error) {
+	c.operations.WithLabelValues(op, backends.ErrorClass(err)).Inc()
+	c.opDuration.WithLabelValues(op).Observe(duration.Seconds())
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.fetches, c.fetchBytes, c.fetchDuration, c.cacheLookups, c.iterators, c.operations, c.opDuration}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
@@ -0,0 +1,57 @@
+package arweaveprom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+func TestCollector(t *testing.T) {
+	archive, err := arweavetest.NewArchiveBuilder().Version(1).Keys("a", "b").Build()
+	require.Nil(t, err)
+	collector := NewCollector("test")
+	registry := prometheus.NewPedanticRegistry()
+	require.Nil(t, registry.Register(collector))
+	db := archive.NewDB(backends.WithIndexCache(4), backends.WithMetricsCollector(collector))
+
+	_, err = db.Get(archive.Key(1, "a"))
+	require.Nil(t, err)
+	has, err := db.Has(archive.Key(1, "c"))
+	require.Nil(t, err)
+	require.False(t, has)
+	_, err = db.Get(archive.Key(2, "a"))
+	require.Error(t, err)
+	iter, err := db.Iterator(archive.Key(1, ""), nil)
+	require.Nil(t, err)
+	require.Nil(t, iter.Close())
+
+	require.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP test_arweave_cache_lookups_total Cache lookups, by cache and whether they hit.
+# TYPE test_arweave_cache_lookups_total counter
+test_arweave_cache_lookups_total{cache="index",result="hit"} 2
+test_arweave_cache_lookups_total{cache="index",result="miss"} 2
+# HELP test_arweave_fetches_total Fetches from the getters, by kind and error class.
+# TYPE test_arweave_fetches_total counter
+test_arweave_fetches_total{kind="data",result="ok"} 2
+test_arweave_fetches_total{kind="index",result="ok"} 1
+test_arweave_fetches_total{kind="version",result="not_found"} 1
+test_arweave_fetches_total{kind="version",result="ok"} 1
+# HELP test_arweave_iterators_created_total Iterators created.
+# TYPE test_arweave_iterators_created_total counter
+test_arweave_iterators_created_total 1
+# HELP test_arweave_operations_total Gets, Has and iterator creations, by error class.
+# TYPE test_arweave_operations_total counter
+test_arweave_operations_total{op="get",result="not_found"} 1
+test_arweave_operations_total{op="get",result="ok"} 1
+test_arweave_operations_total{op="has",result="ok"} 1
+test_arweave_operations_total{op="iterator",result="ok"} 1
+`), "test_arweave_cache_lookups_total", "test_arweave_fetches_total", "test_arweave_iterators_created_total", "test_arweave_operations_total"))
+	// series of the 3 fetch kinds and 3 operations
+	require.Equal(t, 9, testutil.CollectAndCount(collector, "test_arweave_fetch_duration_seconds", "test_arweave_fetched_bytes_total", "test_arweave_operation_duration_seconds"))
+}
@@ -0,0 +1,502 @@
+// Package arweavetest builds deterministic ArweaveDB fixtures: the payload
+// and index transactions of archived versions, generated from their
+// key-value pairs, and injects failures into the reads of an ArweaveDB.
+package arweavetest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+// ArchiveBuilder accumulates the key-value pairs of versions. Its methods
+// return the builder so that calls can be chained:
+//
+//	archive, err := NewArchiveBuilder().
+//		Version(1).Prefix("ab").Keys("c", "d").
+//		Codec(backends.CodecBinary).Compress(backends.CompressionZstd).
+//		ChunkedIndex(4).
+//		Build()
+type ArchiveBuilder struct {
+	versions map[uint64]map[string][]byte
+	// payloads given explicitly by AddTx, by version
+	txs     map[uint64][]tx
+	version uint64
+	prefix  string
+	policy  backends.ChunkPolicy
+	legacy  bool
+	codec   backends.VersionCodec
+	// applied once payloads are built, ambiguities included
+	compression backends.TxCompression
+
+	// ambiguities introduced on purpose, by version
+	rawValues       map[uint64]map[string]json.RawMessage
+	duplicates      map[uint64][]string
+	undeclaredCodec bool
+}
+
+func NewArchiveBuilder() *ArchiveBuilder {
+	return &ArchiveBuilder{
+		versions:   map[uint64]map[string][]byte{},
+		txs:        map[uint64][]tx{},
+		rawValues:  map[uint64]map[string]json.RawMessage{},
+		duplicates: map[uint64][]string{},
+	}
+}
+
+// Version makes the following keys part of the given version, which is
+// version 0 until called.
+func (b *ArchiveBuilder) Version(version uint64) *ArchiveBuilder {
+	b.version = version
+	b.prefix = ""
+	b.kvs()
+	return b
+}
+
+// AddVersion is Version, reading better before AddTx:
+//
+//	db, archive, err := NewArchiveBuilder().
+//		AddVersion(1).AddTx("b", map[string][]byte{"a": []byte("1"), "b": []byte("2")}).
+//		BuildDB()
+func (b *ArchiveBuilder) AddVersion(version uint64) *ArchiveBuilder {
+	return b.Version(version)
+}
+
+// Prefix is prepended to the following keys of the current version.
+func (b *ArchiveBuilder) Prefix(prefix string) *ArchiveBuilder {
+	b.prefix = prefix
+	return b
+}
+
+// Keys adds keys with the value returned by DefaultValue.
+func (b *ArchiveBuilder) Keys(keys ...string) *ArchiveBuilder {
+	for _, key := range keys {
+		b.KV(key, DefaultValue(b.version, b.prefix+key))
+	}
+	return b
+}
+
+// KV adds a key with the given value.
+func (b *ArchiveBuilder) KV(key string, value string) *ArchiveBuilder {
+	b.kvs()[b.prefix+key] = []byte(value)
+	return b
+}
+
+// tx is a payload given explicitly, with the index key prefix of its entry.
+type tx struct {
+	indexPrefix string
+	kvs         map[string][]byte
+}
+
+// AddTx adds a payload of the current version holding kvs, whose keys are
+// prefixed like those of KV, indexed under indexPrefix instead of the
+// prefix of its largest key. It gives full control over the payloads and
+// index entries of a version, e.g. to build ones readers must reject, so
+// a version built with AddTx can't also have keys chunked from KV or Keys.
+func (b *ArchiveBuilder) AddTx(indexPrefix string, kvs map[string][]byte) *ArchiveBuilder {
+	b.kvs()
+	prefixed := make(map[string][]byte, len(kvs))
+	for key, value := range kvs {
+		prefixed[b.prefix+key] = value
+	}
+	b.txs[b.version] = append(b.txs[b.version], tx{indexPrefix: indexPrefix, kvs: prefixed})
+	return b
+}
+
+// ChunkedIndex limits payloads to maxKeys keys, so that indices get one
+// entry per maxKeys keys. Payloads are unlimited by default.
+func (b *ArchiveBuilder) ChunkedIndex(maxKeys int) *ArchiveBuilder {
+	b.policy.MaxKeysPerPayload = maxKeys
+	return b
+}
+
+// TargetPayloadBytes limits the size of payloads, like
+// backends.ChunkPolicy.
+func (b *ArchiveBuilder) TargetPayloadBytes(bytes int) *ArchiveBuilder {
+	b.policy.TargetPayloadBytes = bytes
+	return b
+}
+
+// KeyFilters publishes key filters of the payloads with bitsPerKey bits per
+// key, referenced from backends.IndexFormatV2 indices, like
+// backends.ChunkPolicy.
+func (b *ArchiveBuilder) KeyFilters(bitsPerKey int) *ArchiveBuilder {
+	b.policy.KeyFilterBitsPerKey = bitsPerKey
+	return b
+}
+
+// Codec encodes payloads with codec, backends.CodecJSON by default, like
+// backends.ChunkPolicy.
+func (b *ArchiveBuilder) Codec(codec backends.PayloadCodec) *ArchiveBuilder {
+	b.policy.Codec = codec
+	return b
+}
+
+// Compress compresses payloads and indices with compression, as declared
+// by index entries and headers, which legacy indices don't have.
+func (b *ArchiveBuilder) Compress(compression backends.TxCompression) *ArchiveBuilder {
+	b.compression = compression
+	return b
+}
+
+// LegacyIndex builds headerless indices, without entry metadata, instead of
+// backends.IndexFormatV1 ones.
+func (b *ArchiveBuilder) LegacyIndex() *ArchiveBuilder {
+	b.legacy = true
+	return b
+}
+
+// RawValue adds a key whose value is stored in its payload as the given
+// JSON, e.g. a number, rather than as a JSON string. Its expected value is
+// the compacted JSON.
+func (b *ArchiveBuilder) RawValue(key string, rawJSON string) *ArchiveBuilder {
+	b.KV(key, rawJSON)
+	if b.rawValues[b.version] == nil {
+		b.rawValues[b.version] = map[string]json.RawMessage{}
+	}
+	b.rawValues[b.version][b.prefix+key] = json.RawMessage(rawJSON)
+	return b
+}
+
+// DuplicateKey copies an existing key of the current version into the next
+// payload, which must share its index key prefix, so that the key is
+// present in two payloads.
+func (b *ArchiveBuilder) DuplicateKey(key string) *ArchiveBuilder {
+	b.duplicates[b.version] = append(b.duplicates[b.version], b.prefix+key)
+	return b
+}
+
+// UndeclaredCodec leaves the codec of payloads out of index entries, as if
+// it had to be detected.
+func (b *ArchiveBuilder) UndeclaredCodec() *ArchiveBuilder {
+	b.undeclaredCodec = true
+	return b
+}
+
+// VersionCodec makes the archive versions be encoded with codec instead of
+// backends.BigEndian64VersionCodec, by its getters and keys.
+func (b *ArchiveBuilder) VersionCodec(codec backends.VersionCodec) *ArchiveBuilder {
+	b.codec = codec
+	return b
+}
+
+func (b *ArchiveBuilder) kvs() map[string][]byte {
+	kvs, ok := b.versions[b.version]
+	if !ok {
+		kvs = map[string][]byte{}
+		b.versions[b.version] = kvs
+	}
+	return kvs
+}
+
+// DefaultValue is the value Keys gives to key at version.
+func DefaultValue(version uint64, key string) string {
+	return fmt.Sprintf("%s@%d", key, version)
+}
+
+// Build chunks every version and returns the resulting archive. The same
+// calls always produce the same archive, transaction IDs included.
+func (b *ArchiveBuilder) Build() (*Archive, error) {
+	archive := &Archive{
+		TxData:       map[string][]byte{},
+		IndexTxIds:   map[uint64]string{},
+		Expected:     map[uint64]map[string][]byte{},
+		VersionCodec: b.codec,
+	}
+	for version, kvs := range b.versions {
+		if _, err := archive.codec().Encode(version); err != nil {
+			return nil, err
+		}
+		var chunks []backends.PayloadChunk
+		var err error
+		if txs := b.txs[version]; len(txs) > 0 {
+			chunks, kvs, err = b.explicitChunks(kvs, txs)
+		} else {
+			chunks, err = b.policy.Chunk(kvs)
+		}
+		if err == nil {
+			err = b.introduceAmbiguities(version, chunks)
+		}
+		if err == nil {
+			err = b.compress(chunks)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("version %d: %w", version, err)
+		}
+		txIds := make([][]byte, len(chunks))
+		for i, chunk := range chunks {
+			txIds[i] = archive.addTx(chunk.Payload)
+		}
+		var index []byte
+		if b.legacy {
+			index = buildLegacyIndex(chunks, txIds)
+		} else if b.policy.KeyFilterBitsPerKey > 0 {
+			keyFilterTxIds := make([][]byte, len(chunks))
+			for i, chunk := range chunks {
+				keyFilterTxIds[i] = archive.addTx(chunk.KeyFilter)
+			}
+			index, err = backends.BuildIndexWithKeyFilters(chunks, txIds, keyFilterTxIds)
+		} else {
+			index, err = backends.BuildIndex(chunks, txIds)
+		}
+		if err == nil && !b.legacy {
+			index, err = backends.CompressIndex(index, b.compression)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("version %d: %w", version, err)
+		}
+		archive.IndexTxIds[version] = string(archive.addTx(index))
+		expected := map[string][]byte{}
+		for key, value := range kvs {
+			expected[key] = append([]byte{}, value...)
+		}
+		for key, raw := range b.rawValues[version] {
+			compacted, err := json.Marshal(raw)
+			if err != nil {
+				return nil, fmt.Errorf("version %d: raw value of %s: %w", version, key, err)
+			}
+			expected[key] = compacted
+		}
+		archive.Expected[version] = expected
+	}
+	return archive, nil
+}
+
+// BuildDB is Build followed by Archive.NewDB with opts, returning both the
+// DB and the archive, e.g. for its transactions.
+func (b *ArchiveBuilder) BuildDB(opts ...backends.ArweaveOption) (*backends.ArweaveDB, *Archive, error) {
+	archive, err := b.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	return archive.NewDB(opts...), archive, nil
+}
+
+// explicitChunks returns the chunks of the payloads given by txs, in index
+// order, and the key-value pairs they hold, failing if kvs also holds pairs
+// to chunk.
+func (b *ArchiveBuilder) explicitChunks(kvs map[string][]byte, txs []tx) ([]backends.PayloadChunk, map[string][]byte, error) {
+	if len(kvs) > 0 {
+		return nil, nil, fmt.Errorf("AddTx can't be mixed with KV or Keys")
+	}
+	if b.policy.KeyFilterBitsPerKey > 0 {
+		return nil, nil, fmt.Errorf("AddTx can't be mixed with KeyFilters")
+	}
+	chunks := make([]backends.PayloadChunk, len(txs))
+	all := map[string][]byte{}
+	for i, tx := range txs {
+		for key, value := range tx.kvs {
+			if previous, ok := all[key]; ok && string(previous) != string(value) {
+				return nil, nil, fmt.Errorf("key %s has different values in two payloads", key)
+			}
+			all[key] = value
+		}
+		// an unlimited policy makes a single payload
+		payloads, err := backends.ChunkPolicy{Codec: b.policy.Codec}.Chunk(tx.kvs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(payloads) != 1 {
+			return nil, nil, fmt.Errorf("AddTx needs at least one key")
+		}
+		chunks[i] = payloads[0]
+		chunks[i].KeyPrefix = []byte(tx.indexPrefix)
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return string(chunks[i].KeyPrefix) < string(chunks[j].KeyPrefix)
+	})
+	return chunks, all, nil
+}
+
+// compress compresses the payloads of chunks as set by Compress.
+func (b *ArchiveBuilder) compress(chunks []backends.PayloadChunk) error {
+	if b.compression == backends.CompressionNone {
+		return nil
+	}
+	if b.legacy {
+		return fmt.Errorf("legacy indices can't declare compressed payloads")
+	}
+	for i := range chunks {
+		compressed, err := backends.CompressTx(chunks[i].Payload, b.compression)
+		if err != nil {
+			return err
+		}
+		chunks[i].Payload = compressed
+		chunks[i].Info.PayloadSize = uint64(len(compressed))
+		chunks[i].Info.Compression = b.compression
+	}
+	return nil
+}
+
+func (b *ArchiveBuilder) introduceAmbiguities(version uint64, chunks []backends.PayloadChunk) error {
+	for i := range chunks {
+		if b.undeclaredCodec {
+			chunks[i].Info.Codec = backends.CodecUnknown
+		}
+		raw := b.rawValues[version]
+		if len(raw) == 0 {
+			continue
+		}
+		err := rewritePayload(&chunks[i], func(payload map[string]json.RawMessage) {
+			for key := range payload {
+				if value, ok := raw[key]; ok {
+					payload[key] = value
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range b.duplicates[version] {
+		if err := duplicateKey(chunks, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func duplicateKey(chunks []backends.PayloadChunk, key string) error {
+	for i := 0; i < len(chunks)-1; i++ {
+		payload := map[string]json.RawMessage{}
+		if err := json.Unmarshal(chunks[i].Payload, &payload); err != nil {
+			return err
+		}
+		value, ok := payload[key]
+		if !ok {
+			continue
+		}
+		if string(chunks[i+1].KeyPrefix) != string(chunks[i].KeyPrefix) {
+			return fmt.Errorf("payload of %s doesn't share its index key prefix with the next one", key)
+		}
+		return rewritePayload(&chunks[i+1], func(next map[string]json.RawMessage) {
+			next[key] = value
+		})
+	}
+	return fmt.Errorf("no payload to duplicate %s into", key)
+}
+
+// rewritePayload applies rewrite to the decoded JSON payload of chunk, and
+// updates its index entry metadata.
+func rewritePayload(chunk *backends.PayloadChunk, rewrite func(map[string]json.RawMessage)) error {
+	payload := map[string]json.RawMessage{}
+	if err := json.Unmarshal(chunk.Payload, &payload); err != nil {
+		return err
+	}
+	rewrite(payload)
+	bz, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	chunk.Payload = bz
+	chunk.Info.PayloadSize = uint64(len(bz))
+	chunk.Info.KeyCount = uint32(len(payload))
+	return nil
+}
+
+func buildLegacyIndex(chunks []backends.PayloadChunk, txIds [][]byte) []byte {
+	index := make([]byte, 0, len(chunks)*backends.IndexEntryLen)
+	for i, chunk := range chunks {
+		prefix := make([]byte, backends.IndexKeyPrefixLen)
+		copy(prefix, chunk.KeyPrefix)
+		index = append(append(index, prefix...), txIds[i]...)
+	}
+	return index
+}
+
+// Archive holds the transactions of a built archive.
+type Archive struct {
+	// TxData holds payload and index transactions by ID
+	TxData map[string][]byte
+	// IndexTxIds holds the ID of the index transaction of each version
+	IndexTxIds map[uint64]string
+	// Expected holds the key-value pairs of each version, keys being
+	// unversioned
+	Expected map[uint64]map[string][]byte
+	// VersionCodec encodes versions, backends.BigEndian64VersionCodec if nil
+	VersionCodec backends.VersionCodec
+}
+
+func (a *Archive) codec() backends.VersionCodec {
+	if a.VersionCodec == nil {
+		return backends.BigEndian64VersionCodec
+	}
+	return a.VersionCodec
+}
+
+// TxId returns the ID of a transaction with the given data. IDs are
+// base64-encoded SHA-256 digests of the data, like those of Arweave.
+func TxId(data []byte) string {
+	digest := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+func (a *Archive) addTx(data []byte) []byte {
+	txId := TxId(data)
+	a.TxData[txId] = data
+	return []byte(txId)
+}
+
+// Versions returns the versions of the archive in ascending order.
+func (a *Archive) Versions() []uint64 {
+	versions := make([]uint64, 0, len(a.IndexTxIds))
+	for version := range a.IndexTxIds {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// TxDataGetter returns a getter serving the transactions of the archive.
+func (a *Archive) TxDataGetter() backends.Getter {
+	return func(txId []byte) ([]byte, error) {
+		if data, ok := a.TxData[string(txId)]; ok {
+			return data, nil
+		}
+		return nil, backends.NewErrKeyNotFound(txId)
+	}
+}
+
+// VersionGetter returns a getter mapping encoded versions to the ID of their
+// index transaction.
+func (a *Archive) VersionGetter() backends.Getter {
+	return func(version []byte) ([]byte, error) {
+		if decoded, n, err := a.codec().Decode(version); err == nil && n == len(version) {
+			if txId, ok := a.IndexTxIds[decoded]; ok {
+				return []byte(txId), nil
+			}
+		}
+		return nil, backends.NewErrKeyNotFound(version)
+	}
+}
+
+// NewDB returns an ArweaveDB reading the archive, with its version codec.
+func (a *Archive) NewDB(opts ...backends.ArweaveOption) *backends.ArweaveDB {
+	if a.VersionCodec != nil {
+		opts = append([]backends.ArweaveOption{backends.WithVersionCodec(a.VersionCodec)}, opts...)
+	}
+	return backends.NewArweaveDBWithGetters(a.TxDataGetter(), a.VersionGetter(), opts...)
+}
+
+// Key returns key prefixed with version, as ArweaveDB expects it with the
+// default version codec.
+func Key(version uint64, key string) []byte {
+	bz := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(bz, version)
+	return append(bz, key...)
+}
+
+// Key returns key prefixed with version encoded with the version codec of
+// the archive. It panics if version doesn't fit the codec.
+func (a *Archive) Key(version uint64, key string) []byte {
+	bz, err := a.codec().Encode(version)
+	if err != nil {
+		panic(err)
+	}
+	return append(bz, key...)
+}
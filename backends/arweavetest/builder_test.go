@@ -0,0 +1,187 @@
+package arweavetest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+func buildTestArchive(t *testing.T) *Archive {
+	archive, err := NewArchiveBuilder().
+		Version(1).Prefix("ab").Keys("c", "d", "e").KV("f", "custom").
+		Version(2).Keys("ab", "cd").
+		ChunkedIndex(2).
+		Build()
+	require.Nil(t, err)
+	return archive
+}
+
+func TestBuildIsDeterministic(t *testing.T) {
+	archive := buildTestArchive(t)
+	require.Equal(t, archive, buildTestArchive(t))
+	require.Equal(t, []uint64{1, 2}, archive.Versions())
+	require.Equal(t, map[string][]byte{
+		"abc": []byte("abc@1"),
+		"abd": []byte("abd@1"),
+		"abe": []byte("abe@1"),
+		"abf": []byte("custom"),
+	}, archive.Expected[1])
+	// 2 payloads and the index for version 1, 1 payload and the index for
+	// version 2
+	require.Equal(t, 5, len(archive.TxData))
+}
+
+func TestBuildRejectsInvalidKeys(t *testing.T) {
+	_, err := NewArchiveBuilder().Keys("\xff").Build()
+	require.NotNil(t, err)
+}
+
+func TestBuildAmbiguities(t *testing.T) {
+	archive, err := NewArchiveBuilder().RawValue("a", `[1, 2]`).Build()
+	require.Nil(t, err)
+	require.Equal(t, []byte("[1,2]"), archive.Expected[0]["a"])
+	payloads := 0
+	for _, data := range archive.TxData {
+		if string(data) == `{"a":[1,2]}` {
+			payloads++
+		}
+	}
+	require.Equal(t, 1, payloads)
+
+	// keys can only be duplicated into a payload sharing their index prefix
+	_, err = NewArchiveBuilder().Keys("a", "b").DuplicateKey("a").ChunkedIndex(1).Build()
+	require.NotNil(t, err)
+}
+
+func TestGetters(t *testing.T) {
+	archive := buildTestArchive(t)
+	indexTxId, err := archive.VersionGetter()(Key(2, "")[:8])
+	require.Nil(t, err)
+	require.Equal(t, archive.IndexTxIds[2], string(indexTxId))
+	index, err := archive.TxDataGetter()(indexTxId)
+	require.Nil(t, err)
+	require.Equal(t, TxId(index), string(indexTxId))
+
+	_, err = archive.VersionGetter()(Key(3, "")[:8])
+	require.NotNil(t, err)
+	_, err = archive.TxDataGetter()([]byte("missing"))
+	require.NotNil(t, err)
+}
+
+func TestWriteReadDir(t *testing.T) {
+	archive := buildTestArchive(t)
+	dir := t.TempDir()
+	require.Nil(t, archive.WriteDir(dir))
+	loaded, err := ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, archive, loaded)
+
+	// tampered transactions are detected
+	files, err := ioutil.ReadDir(filepath.Join(dir, txDir))
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, txDir, files[0].Name()), []byte("{}"), 0644))
+	_, err = ReadDir(dir)
+	require.NotNil(t, err)
+}
+
+func TestVersionCodec(t *testing.T) {
+	archive, err := NewArchiveBuilder().VersionCodec(backends.BigEndian32VersionCodec).
+		Version(2).KV("a", "b").
+		Build()
+	require.Nil(t, err)
+	require.Equal(t, append([]byte{0, 0, 0, 2}, 'a'), archive.Key(2, "a"))
+	indexTxId, err := archive.VersionGetter()(archive.Key(2, ""))
+	require.Nil(t, err)
+	require.Equal(t, archive.IndexTxIds[2], string(indexTxId))
+	_, err = archive.VersionGetter()(Key(2, "")[:8])
+	require.NotNil(t, err)
+
+	// the codec is recorded in the manifest
+	dir := t.TempDir()
+	require.Nil(t, archive.WriteDir(dir))
+	loaded, err := ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, archive, loaded)
+
+	_, err = NewArchiveBuilder().VersionCodec(backends.BigEndian32VersionCodec).
+		Version(1<<32).KV("a", "b").
+		Build()
+	require.NotNil(t, err)
+}
+
+func TestBuildKeyFilters(t *testing.T) {
+	archive, err := NewArchiveBuilder().
+		Version(1).Keys("a", "b", "c", "d").
+		ChunkedIndex(2).KeyFilters(10).
+		Build()
+	require.Nil(t, err)
+	// 2 payloads, their key filters and the index
+	require.Equal(t, 5, len(archive.TxData))
+	db := archive.NewDB()
+	desc, err := db.DescribeIndex(1)
+	require.Nil(t, err)
+	require.Equal(t, backends.IndexFormatV2, desc.Format)
+	for key := range archive.Expected[1] {
+		has, err := db.Has(archive.Key(1, key))
+		require.Nil(t, err)
+		require.True(t, has)
+	}
+	has, err := db.Has(archive.Key(1, "bb"))
+	require.Nil(t, err)
+	require.False(t, has)
+}
+
+func TestBuildTxs(t *testing.T) {
+	db, archive, err := NewArchiveBuilder().
+		AddVersion(1).AddTx("c", map[string][]byte{"c": []byte("3"), "d": []byte("4")}).
+		Prefix("a").AddTx("ab", map[string][]byte{"a": []byte("1"), "b": []byte("2")}).
+		BuildDB()
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{
+		"aa": []byte("1"),
+		"ab": []byte("2"),
+		"c":  []byte("3"),
+		"d":  []byte("4"),
+	}, archive.Expected[1])
+	// 2 payloads and the index
+	require.Equal(t, 3, len(archive.TxData))
+	payload := []byte(`{"aa":"1","ab":"2"}`)
+	require.Equal(t, payload, archive.TxData[TxId(payload)])
+
+	// d is indexed under c, so that it can't be found
+	value, err := db.Get(archive.Key(1, "ab"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("2"), value)
+	_, err = db.Get(archive.Key(1, "d"))
+	require.NotNil(t, err)
+
+	_, err = NewArchiveBuilder().AddTx("a", map[string][]byte{"a": nil}).KV("b", "2").Build()
+	require.NotNil(t, err)
+	_, err = NewArchiveBuilder().
+		AddTx("a", map[string][]byte{"a": []byte("1")}).
+		AddTx("b", map[string][]byte{"a": []byte("2")}).
+		Build()
+	require.NotNil(t, err)
+}
+
+func TestBuildCodecAndCompression(t *testing.T) {
+	archive, err := NewArchiveBuilder().Codec(backends.CodecBinary).Compress(backends.CompressionGzip).
+		KV("\x00", "\xff").Keys("a").Build()
+	require.Nil(t, err)
+	require.Equal(t, []byte("\xff"), archive.Expected[0]["\x00"])
+	desc, err := archive.NewDB().DescribeIndex(0)
+	require.Nil(t, err)
+	require.Len(t, desc.Entries, 1)
+	info := desc.Entries[0].Info
+	require.Equal(t, backends.CodecBinary, info.Codec)
+	require.Equal(t, backends.CompressionGzip, info.Compression)
+	require.Equal(t, uint64(len(archive.TxData[desc.Entries[0].TxId])), info.PayloadSize)
+
+	// legacy indices can't declare compression
+	_, err = NewArchiveBuilder().Compress(backends.CompressionZstd).Keys("a").LegacyIndex().Build()
+	require.NotNil(t, err)
+}
@@ -0,0 +1,90 @@
+package arweavetest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+// ErrInjected is the error of the failures injected by Faults when none is
+// given.
+var ErrInjected = errors.New("injected failure")
+
+// Faults injects failures into the getters of an ArweaveDB, by requested
+// key, i.e. a transaction ID or an encoded version, and counts the requests
+// of every key. It is safe for concurrent use:
+//
+//	faults := NewFaults().FailAfter(txId, 2, nil)
+//	db := archive.NewDB(backends.WithGetterMiddleware(faults.Middleware()))
+type Faults struct {
+	mu    sync.Mutex
+	calls map[string]int
+	rules map[string]faultRule
+}
+
+type faultRule struct {
+	// number of requests served before failing
+	after int
+	err   error
+}
+
+func NewFaults() *Faults {
+	return &Faults{
+		calls: map[string]int{},
+		rules: map[string]faultRule{},
+	}
+}
+
+// FailAfter makes the requests of key fail with err, or ErrInjected if nil,
+// once n of them have been served, counting those already made. A n of 0
+// fails every request.
+func (f *Faults) FailAfter(key string, n int, err error) *Faults {
+	if err == nil {
+		err = ErrInjected
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules[key] = faultRule{after: n, err: err}
+	return f
+}
+
+// Heal stops the failures of key.
+func (f *Faults) Heal(key string) *Faults {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.rules, key)
+	return f
+}
+
+// Calls returns the number of requests of key so far, failed ones included.
+func (f *Faults) Calls(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[key]
+}
+
+// Middleware returns a middleware counting the requests of the getters it
+// wraps and failing them as configured.
+func (f *Faults) Middleware() backends.GetterMiddleware {
+	return func(next backends.Getter) backends.Getter {
+		return func(key []byte) ([]byte, error) {
+			if err := f.request(string(key)); err != nil {
+				return nil, err
+			}
+			return next(key)
+		}
+	}
+}
+
+// request counts a request of key and returns the error it must fail with,
+// if any.
+func (f *Faults) request(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[key]++
+	if rule, ok := f.rules[key]; ok && f.calls[key] > rule.after {
+		return rule.err
+	}
+	return nil
+}
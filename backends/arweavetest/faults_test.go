@@ -0,0 +1,37 @@
+package arweavetest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+func TestFaults(t *testing.T) {
+	payload := []byte(`{"a":"1"}`)
+	archive, err := NewArchiveBuilder().Version(1).AddTx("a", map[string][]byte{"a": []byte("1")}).Build()
+	require.Nil(t, err)
+	faults := NewFaults().FailAfter(TxId(payload), 1, nil)
+	db := archive.NewDB(backends.WithGetterMiddleware(faults.Middleware()))
+
+	value, err := db.Get(archive.Key(1, "a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), value)
+	_, err = db.Get(archive.Key(1, "a"))
+	require.True(t, errors.Is(err, ErrInjected))
+	require.Equal(t, 2, faults.Calls(TxId(payload)))
+	require.Equal(t, 2, faults.Calls(archive.IndexTxIds[1]))
+
+	faults.Heal(TxId(payload))
+	value, err = db.Get(archive.Key(1, "a"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("1"), value)
+
+	// versions are requested encoded
+	injected := errors.New("gateway down")
+	faults.FailAfter(string(archive.Key(1, "")), 0, injected)
+	_, err = db.Get(archive.Key(1, "a"))
+	require.True(t, errors.Is(err, injected))
+}
@@ -0,0 +1,122 @@
+package arweavetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+// Fixture directories hold one file per transaction under txDir, named after
+// the URL-safe encoding of its ID, along with a manifest of the versions.
+const (
+	manifestFile = "manifest.json"
+	txDir        = "tx"
+)
+
+type manifest struct {
+	// version to index tx ID
+	Versions map[string]string `json:"versions"`
+	// version to unversioned key to value
+	Expected map[string]map[string]string `json:"expected"`
+	// name of the version codec, the default one if empty
+	VersionCodec string `json:"version_codec,omitempty"`
+}
+
+func txFileName(txId string) string {
+	digest, err := base64.StdEncoding.DecodeString(txId)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(digest)
+}
+
+// WriteDir writes the archive to dir, creating it if needed, for ReadDir to
+// load it back, e.g. from offline tests.
+func (a *Archive) WriteDir(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, txDir), 0755); err != nil {
+		return err
+	}
+	for txId, data := range a.TxData {
+		if err := ioutil.WriteFile(filepath.Join(dir, txDir, txFileName(txId)), data, 0644); err != nil {
+			return err
+		}
+	}
+	m := manifest{Versions: map[string]string{}, Expected: map[string]map[string]string{}}
+	if a.VersionCodec != nil {
+		m.VersionCodec = a.VersionCodec.Name()
+	}
+	for version, txId := range a.IndexTxIds {
+		m.Versions[strconv.FormatUint(version, 10)] = txId
+	}
+	for version, kvs := range a.Expected {
+		expected := map[string]string{}
+		for key, value := range kvs {
+			expected[key] = string(value)
+		}
+		m.Expected[strconv.FormatUint(version, 10)] = expected
+	}
+	bz, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, manifestFile), bz, 0644)
+}
+
+// ReadDir loads an archive written by WriteDir. Transaction IDs are
+// recomputed from the data, so that edited fixtures don't go unnoticed.
+func ReadDir(dir string) (*Archive, error) {
+	bz, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := manifest{}
+	if err := json.Unmarshal(bz, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", manifestFile, err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, txDir))
+	if err != nil {
+		return nil, err
+	}
+	archive := &Archive{
+		TxData:     map[string][]byte{},
+		IndexTxIds: map[uint64]string{},
+		Expected:   map[uint64]map[string][]byte{},
+	}
+	if m.VersionCodec != "" {
+		if archive.VersionCodec, err = backends.VersionCodecByName(m.VersionCodec); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", manifestFile, err)
+		}
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, txDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		txId := archive.addTx(data)
+		if txFileName(string(txId)) != file.Name() {
+			return nil, fmt.Errorf("tx file %s doesn't match its data", file.Name())
+		}
+	}
+	for versionStr, txId := range m.Versions {
+		version, err := strconv.ParseUint(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q: %w", versionStr, err)
+		}
+		if _, ok := archive.TxData[txId]; !ok {
+			return nil, fmt.Errorf("index tx %s of version %d is missing", txId, version)
+		}
+		archive.IndexTxIds[version] = txId
+		expected := map[string][]byte{}
+		for key, value := range m.Expected[versionStr] {
+			expected[key] = []byte(value)
+		}
+		archive.Expected[version] = expected
+	}
+	return archive, nil
+}
@@ -0,0 +1,25 @@
+package arweavetest
+
+import (
+	"testing"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+// ReplayDB returns an ArweaveDB answering from the replay file at path, as
+// written by backends.ReplayRecorder, so that a captured replay can be
+// turned into a regression test. The test fails if reads diverged from the
+// replay or left some of it unrequested.
+func ReplayDB(t testing.TB, path string, opts ...backends.ArweaveOption) *backends.ArweaveDB {
+	t.Helper()
+	replay, err := backends.ReadReplayFile(path)
+	if err != nil {
+		t.Fatalf("loading replay: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := replay.Err(); err != nil {
+			t.Errorf("replay %s: %v", path, err)
+		}
+	})
+	return replay.NewDB(opts...)
+}
@@ -0,0 +1,33 @@
+package arweavetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+)
+
+func TestReplayDB(t *testing.T) {
+	archive := buildTestArchive(t)
+	rec := backends.NewReplayRecorder()
+	read := func(db *backends.ArweaveDB) map[string]string {
+		kvs := map[string]string{}
+		iter, err := db.Iterator(Key(1, ""), Key(1, "\xff"))
+		require.Nil(t, err)
+		defer iter.Close()
+		for ; iter.Valid(); iter.Next() {
+			kvs[string(iter.Key())] = string(iter.Value())
+		}
+		value, err := db.Get(Key(2, "cd"))
+		require.Nil(t, err)
+		kvs["cd"] = string(value)
+		return kvs
+	}
+	recorded := read(archive.NewDB(backends.WithReplayRecording(rec)))
+	path := filepath.Join(t.TempDir(), "replay.json")
+	require.Nil(t, rec.WriteFile(path))
+
+	require.Equal(t, recorded, read(ReplayDB(t, path)))
+}
@@ -0,0 +1,200 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// DefaultBacklogPollInterval is how often WaitForCompactionBacklog samples
+// the statistics of the DB.
+const DefaultBacklogPollInterval = 100 * time.Millisecond
+
+// StatsReport holds the statistics of a goleveldb DB relevant to its
+// compaction backlog.
+type StatsReport struct {
+	Level0Tables int
+	// tables and bytes of each level, level 0 included
+	LevelTables []int
+	LevelSizes  []int64
+	// CompactionBacklogBytes is the number of bytes of levels 1 and up above
+	// their size target with the default goleveldb options, which
+	// compactions have yet to move down.
+	CompactionBacklogBytes int64
+	// whether writes are paused for level 0 to be compacted, and how many
+	// times and how long they have been delayed for so far
+	WritePaused    bool
+	WriteDelays    int32
+	WriteDelayTime time.Duration
+}
+
+// GoLevelDBStatsReport returns the statistics of db.
+func GoLevelDBStatsReport(db *dbm.GoLevelDB) (StatsReport, error) {
+	stats := leveldb.DBStats{}
+	if err := db.DB().Stats(&stats); err != nil {
+		return StatsReport{}, err
+	}
+	report := StatsReport{
+		LevelTables:    append([]int{}, stats.LevelTablesCounts...),
+		LevelSizes:     append([]int64{}, stats.LevelSizes...),
+		WritePaused:    stats.WritePaused,
+		WriteDelays:    stats.WriteDelayCount,
+		WriteDelayTime: stats.WriteDelayDuration,
+	}
+	if len(report.LevelTables) > 0 {
+		report.Level0Tables = report.LevelTables[0]
+	}
+	target := int64(opt.DefaultCompactionTotalSize)
+	for level := 1; level < len(report.LevelSizes); level++ {
+		if excess := report.LevelSizes[level] - target; excess > 0 {
+			report.CompactionBacklogBytes += excess
+		}
+		target *= int64(opt.DefaultCompactionTotalSizeMultiplier)
+	}
+	return report, nil
+}
+
+// Level0Thresholds are level-0 table counts at which a Level0Monitor
+// warns, goleveldb slowing writes down at 8 and pausing them at 12 by
+// default. Zero thresholds are disabled.
+type Level0Thresholds struct {
+	Warning  int
+	Critical int
+}
+
+type backlogState uint8
+
+const (
+	backlogOK backlogState = iota
+	backlogWarning
+	backlogCritical
+)
+
+func (t Level0Thresholds) state(level0Tables int) backlogState {
+	switch {
+	case t.Critical > 0 && level0Tables >= t.Critical:
+		return backlogCritical
+	case t.Warning > 0 && level0Tables >= t.Warning:
+		return backlogWarning
+	}
+	return backlogOK
+}
+
+// Level0Monitor samples the level-0 tables of a goleveldb DB, emitting
+// EventBacklogWarning and EventBacklogCritical events when they reach its
+// thresholds, and an EventBacklogRecovered event once they are back below
+// the warning threshold, so that operators learn about write bursts
+// before reads crawl.
+type Level0Monitor struct {
+	db           *dbm.GoLevelDB
+	thresholds   Level0Thresholds
+	pollInterval time.Duration
+
+	mtx     sync.Mutex
+	state   backlogState
+	last    StatsReport
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewLevel0Monitor returns a monitor of db. It only samples db when
+// checked or waited on until started.
+func NewLevel0Monitor(db *dbm.GoLevelDB, thresholds Level0Thresholds) *Level0Monitor {
+	return &Level0Monitor{db: db, thresholds: thresholds, pollInterval: DefaultBacklogPollInterval}
+}
+
+// Check samples the statistics of the DB, emitting an event if the
+// level-0 tables crossed a threshold since the last sample.
+func (m *Level0Monitor) Check() (StatsReport, error) {
+	report, err := GoLevelDBStatsReport(m.db)
+	if err != nil {
+		return StatsReport{}, err
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.last = report
+	state := m.thresholds.state(report.Level0Tables)
+	if state == m.state {
+		return report, nil
+	}
+	m.state = state
+	kind, threshold := EventBacklogRecovered, m.thresholds.Warning
+	switch state {
+	case backlogWarning:
+		kind = EventBacklogWarning
+	case backlogCritical:
+		kind, threshold = EventBacklogCritical, m.thresholds.Critical
+	}
+	emitEvent(kind, "goleveldb", "", fmt.Sprintf("%d level-0 tables, threshold %d", report.Level0Tables, threshold))
+	return report, nil
+}
+
+// Last returns the statistics sampled last.
+func (m *Level0Monitor) Last() StatsReport {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.last
+}
+
+// Start samples the DB every interval until Stop is called.
+func (m *Level0Monitor) Start(interval time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.stop != nil {
+		return
+	}
+	m.stop, m.stopped = make(chan struct{}), make(chan struct{})
+	go func(stop, stopped chan struct{}) {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// failures are retried on the next tick
+				_, _ = m.Check()
+			}
+		}
+	}(m.stop, m.stopped)
+}
+
+// Stop stops the periodic samples started by Start.
+func (m *Level0Monitor) Stop() {
+	m.mtx.Lock()
+	stop, stopped := m.stop, m.stopped
+	m.stop, m.stopped = nil, nil
+	m.mtx.Unlock()
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+}
+
+// WaitForCompactionBacklog blocks until the DB has at most maxLevel0
+// level-0 tables, or ctx is done, e.g. for bulk loaders to pace
+// themselves rather than have goleveldb stall them.
+func (m *Level0Monitor) WaitForCompactionBacklog(ctx context.Context, maxLevel0 int) error {
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	for {
+		report, err := m.Check()
+		if err != nil {
+			return err
+		}
+		if report.Level0Tables <= maxLevel0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
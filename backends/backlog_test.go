@@ -0,0 +1,120 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// newBacklogTestDB returns a goleveldb DB flushing tiny level-0 tables
+// which are never compacted automatically.
+func newBacklogTestDB(t *testing.T) *dbm.GoLevelDB {
+	db, err := dbm.NewGoLevelDBWithOpts("backlog", t.TempDir(), &opt.Options{
+		WriteBuffer:            4 << 10,
+		CompactionL0Trigger:    1000,
+		WriteL0SlowdownTrigger: 1000,
+		WriteL0PauseTrigger:    2000,
+	})
+	require.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// loadUntilLevel0 writes to db until it has level0 level-0 tables, checking
+// monitor along the way.
+func loadUntilLevel0(t *testing.T, db *dbm.GoLevelDB, monitor *Level0Monitor, level0 int) {
+	value := make([]byte, 1024)
+	for i := 0; ; i++ {
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("key%06d", i)), value))
+		report, err := monitor.Check()
+		require.Nil(t, err)
+		if report.Level0Tables >= level0 {
+			return
+		}
+		require.Less(t, i, 10000, "level-0 tables didn't build up")
+	}
+}
+
+func TestLevel0MonitorEvents(t *testing.T) {
+	mtx := sync.Mutex{}
+	events := []Event{}
+	sub := Subscribe(func(event Event) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		events = append(events, event)
+	})
+
+	db := newBacklogTestDB(t)
+	monitor := NewLevel0Monitor(db, Level0Thresholds{Warning: 3, Critical: 6})
+	loadUntilLevel0(t, db, monitor, 6)
+	report := monitor.Last()
+	require.GreaterOrEqual(t, report.Level0Tables, 6)
+	require.Equal(t, report.Level0Tables, report.LevelTables[0])
+	require.Greater(t, report.LevelSizes[0], int64(0))
+
+	require.Nil(t, db.DB().CompactRange(util.Range{}))
+	report, err := monitor.Check()
+	require.Nil(t, err)
+	require.Equal(t, 0, report.Level0Tables)
+	sub.Unsubscribe()
+
+	kinds := []EventKind{}
+	for _, event := range events {
+		kinds = append(kinds, event.Kind)
+		require.Equal(t, "goleveldb", event.Backend)
+	}
+	require.Equal(t, []EventKind{EventBacklogWarning, EventBacklogCritical, EventBacklogRecovered}, kinds)
+	require.Contains(t, events[1].Detail, "threshold 6")
+}
+
+func TestWaitForCompactionBacklog(t *testing.T) {
+	db := newBacklogTestDB(t)
+	monitor := NewLevel0Monitor(db, Level0Thresholds{})
+	monitor.pollInterval = time.Millisecond
+	loadUntilLevel0(t, db, monitor, 4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, monitor.WaitForCompactionBacklog(ctx, 2))
+
+	done := make(chan error)
+	go func() {
+		done <- monitor.WaitForCompactionBacklog(context.Background(), 2)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("returned before compacting: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Nil(t, db.DB().CompactRange(util.Range{}))
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting once compacted")
+	}
+}
+
+func TestLevel0MonitorStartStop(t *testing.T) {
+	db := newBacklogTestDB(t)
+	monitor := NewLevel0Monitor(db, Level0Thresholds{})
+	monitor.Stop()
+	monitor.Start(time.Millisecond)
+	monitor.Start(time.Millisecond)
+	value := make([]byte, 1024)
+	for i := 0; i < 100; i++ {
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("key%06d", i)), value))
+	}
+	require.Eventually(t, func() bool {
+		return monitor.Last().Level0Tables > 0
+	}, 5*time.Second, time.Millisecond)
+	monitor.Stop()
+	monitor.Stop()
+}
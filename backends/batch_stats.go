@@ -0,0 +1,195 @@
+package backends
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// BatchWriteStats describes what a batch write cost.
+type BatchWriteStats struct {
+	// Ops is the number of sets and deletes written.
+	Ops int
+	// LogicalBytes is the size of the keys and values set and of the keys
+	// deleted.
+	LogicalBytes uint64
+	// PhysicalBytes is what the storage layer wrote, write-ahead log
+	// included, zero for backends unable to tell.
+	PhysicalBytes uint64
+	Elapsed       time.Duration
+}
+
+// StatsBatch is implemented by batches able to tell what their writes cost.
+type StatsBatch interface {
+	dbm.Batch
+	// WriteWithStats is Write, returning the stats of the write.
+	WriteWithStats() (BatchWriteStats, error)
+	// WriteSyncWithStats is WriteSync, returning the stats of the write.
+	WriteSyncWithStats() (BatchWriteStats, error)
+}
+
+// WriteAccounter is implemented by DBs able to tell how many bytes their
+// storage layer wrote so far, write-ahead log included.
+type WriteAccounter interface {
+	BytesWritten() (uint64, error)
+}
+
+type goLevelDBWriteAccounter struct {
+	db *dbm.GoLevelDB
+}
+
+func (a goLevelDBWriteAccounter) BytesWritten() (uint64, error) {
+	stats := leveldb.DBStats{}
+	if err := a.db.DB().Stats(&stats); err != nil {
+		return 0, err
+	}
+	return stats.IOWrite, nil
+}
+
+// writeAccounterOf returns the write accounter of db, if it has one.
+func writeAccounterOf(db dbm.DB) (WriteAccounter, bool) {
+	switch db := db.(type) {
+	case WriteAccounter:
+		return db, true
+	case *dbm.GoLevelDB:
+		return goLevelDBWriteAccounter{db}, true
+	}
+	return nativeWriteAccounterOf(db)
+}
+
+// NewStatsBatch returns a new batch of db telling what its writes cost. The
+// physical bytes written are known for DBs implementing WriteAccounter and
+// goleveldb DBs, as well as rocksdb ones when built with the rocksdb tag,
+// as the difference of the bytes written by the storage layer around the
+// write, so that concurrent writes are attributed to it too. Batches of
+// other DBs, e.g. badger or bolt ones, only report logical bytes.
+func NewStatsBatch(db dbm.DB) StatsBatch {
+	batch := db.NewBatch()
+	if batch, ok := batch.(StatsBatch); ok {
+		return batch
+	}
+	accounter, _ := writeAccounterOf(db)
+	return &statsBatch{Batch: batch, accounter: accounter}
+}
+
+type statsBatch struct {
+	dbm.Batch
+	// nil if the DB can't tell
+	accounter WriteAccounter
+
+	ops          int
+	logicalBytes uint64
+}
+
+var _ StatsBatch = (*statsBatch)(nil)
+
+// Set implements Batch.
+func (b *statsBatch) Set(key, value []byte) error {
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	b.ops++
+	b.logicalBytes += uint64(len(key) + len(value))
+	return nil
+}
+
+// Delete implements Batch.
+func (b *statsBatch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.ops++
+	b.logicalBytes += uint64(len(key))
+	return nil
+}
+
+// Write implements Batch.
+func (b *statsBatch) Write() error {
+	_, err := b.WriteWithStats()
+	return err
+}
+
+// WriteSync implements Batch.
+func (b *statsBatch) WriteSync() error {
+	_, err := b.WriteSyncWithStats()
+	return err
+}
+
+// WriteWithStats implements StatsBatch.
+func (b *statsBatch) WriteWithStats() (BatchWriteStats, error) {
+	return b.write(b.Batch.Write)
+}
+
+// WriteSyncWithStats implements StatsBatch.
+func (b *statsBatch) WriteSyncWithStats() (BatchWriteStats, error) {
+	return b.write(b.Batch.WriteSync)
+}
+
+func (b *statsBatch) write(write func() error) (BatchWriteStats, error) {
+	var before uint64
+	accounted := b.accounter != nil
+	if accounted {
+		var err error
+		before, err = b.accounter.BytesWritten()
+		accounted = err == nil
+	}
+	start := time.Now()
+	if err := write(); err != nil {
+		return BatchWriteStats{}, err
+	}
+	stats := BatchWriteStats{Ops: b.ops, LogicalBytes: b.logicalBytes, Elapsed: time.Since(start)}
+	if accounted {
+		// counters estimated from file sizes may shrink, e.g. on flushes
+		if after, err := b.accounter.BytesWritten(); err == nil && after > before {
+			stats.PhysicalBytes = after - before
+		}
+	}
+	return stats, nil
+}
+
+// batchWriteTotals sums the stats of batch writes.
+type batchWriteTotals struct {
+	mtx    sync.Mutex
+	totals BatchWriteTotals
+}
+
+// BatchWriteTotals sums the stats of the batch writes of a MetricsDB.
+type BatchWriteTotals struct {
+	Writes int
+	BatchWriteStats
+}
+
+// WriteAmplification returns the physical bytes written per logical byte,
+// 0 without logical bytes.
+func (t BatchWriteTotals) WriteAmplification() float64 {
+	if t.LogicalBytes == 0 {
+		return 0
+	}
+	return float64(t.PhysicalBytes) / float64(t.LogicalBytes)
+}
+
+// record adds the stats of a successful write, returning its error.
+func (t *batchWriteTotals) record(stats BatchWriteStats, err error) error {
+	if err != nil {
+		return err
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.totals.Writes++
+	t.totals.Ops += stats.Ops
+	t.totals.LogicalBytes += stats.LogicalBytes
+	t.totals.PhysicalBytes += stats.PhysicalBytes
+	t.totals.Elapsed += stats.Elapsed
+	return nil
+}
+
+// BatchWriteTotals returns the sums of the stats of the batch writes of
+// StatsBatches. They are empty unless MetricsOptions.TrackWriteAmplification
+// is set or the wrapped DB makes StatsBatches.
+func (m *MetricsDB) BatchWriteTotals() BatchWriteTotals {
+	m.writes.mtx.Lock()
+	defer m.writes.mtx.Unlock()
+	return m.writes.totals
+}
@@ -0,0 +1,14 @@
+//go:build !rocksdb
+// +build !rocksdb
+
+package backends
+
+import (
+	dbm "github.com/tendermint/tm-db"
+)
+
+// nativeWriteAccounterOf returns the write accounter of the DBs of the
+// backends built with tags, none without the rocksdb tag.
+func nativeWriteAccounterOf(db dbm.DB) (WriteAccounter, bool) {
+	return nil, false
+}
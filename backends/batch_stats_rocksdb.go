@@ -0,0 +1,78 @@
+//go:build rocksdb
+// +build rocksdb
+
+package backends
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// the statistics tickers counting what rocksdb writes to disk
+var rocksDBWriteTickers = []string{"rocksdb.wal.bytes", "rocksdb.flush.write.bytes", "rocksdb.compact.write.bytes"}
+
+// rocksDBWriteAccounter counts the bytes written to the write-ahead log,
+// flushed and compacted from the statistics of the DB, if opened with
+// statistics enabled. Otherwise writes are estimated from the growth of the
+// write-ahead log and table files.
+type rocksDBWriteAccounter struct {
+	db *dbm.RocksDB
+}
+
+func (a rocksDBWriteAccounter) BytesWritten() (uint64, error) {
+	if stats := a.db.DB().GetProperty("rocksdb.options-statistics"); stats != "" {
+		return parseRocksDBTickers(stats, rocksDBWriteTickers)
+	}
+	tables, err := strconv.ParseUint(a.db.DB().GetProperty("rocksdb.total-sst-files-size"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	logs, err := filepath.Glob(filepath.Join(a.db.DB().Name(), "*.log"))
+	if err != nil {
+		return 0, err
+	}
+	written := tables
+	for _, log := range logs {
+		// logs may be deleted concurrently once flushed
+		if info, err := os.Stat(log); err == nil {
+			written += uint64(info.Size())
+		}
+	}
+	return written, nil
+}
+
+// parseRocksDBTickers returns the sum of the tickers in stats, as formatted
+// by rocksdb, e.g. "rocksdb.wal.bytes COUNT : 1024".
+func parseRocksDBTickers(stats string, tickers []string) (uint64, error) {
+	sum := uint64(0)
+	for _, line := range strings.Split(stats, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[1] != "COUNT" {
+			continue
+		}
+		for _, ticker := range tickers {
+			if fields[0] != ticker {
+				continue
+			}
+			count, err := strconv.ParseUint(fields[3], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			sum += count
+		}
+	}
+	return sum, nil
+}
+
+// nativeWriteAccounterOf returns the write accounter of the DBs of the
+// backends built with tags.
+func nativeWriteAccounterOf(db dbm.DB) (WriteAccounter, bool) {
+	if db, ok := db.(*dbm.RocksDB); ok {
+		return rocksDBWriteAccounter{db}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,35 @@
+//go:build rocksdb
+// +build rocksdb
+
+package backends
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestStatsBatchRocksDB(t *testing.T) {
+	db, err := dbm.NewRocksDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer db.Close()
+	batch := NewStatsBatch(db)
+	logical := fillStatsBatch(t, batch, 1000)
+	stats, err := batch.WriteWithStats()
+	require.Nil(t, err)
+	require.Equal(t, 1001, stats.Ops)
+	require.Equal(t, logical, stats.LogicalBytes)
+	require.Positive(t, stats.PhysicalBytes)
+	require.Nil(t, batch.Close())
+}
+
+func TestParseRocksDBTickers(t *testing.T) {
+	stats := "rocksdb.block.cache.miss COUNT : 7\n" +
+		"rocksdb.wal.bytes COUNT : 1024\n" +
+		"rocksdb.flush.write.bytes COUNT : 2048\n" +
+		"rocksdb.db.get.micros P50 : 1.000000 P95 : 2.000000\n"
+	written, err := parseRocksDBTickers(stats, rocksDBWriteTickers)
+	require.Nil(t, err)
+	require.Equal(t, uint64(3072), written)
+}
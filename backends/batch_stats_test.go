@@ -0,0 +1,113 @@
+package backends
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// fillStatsBatch sets n keys of 1KiB values and deletes one, returning the
+// logical bytes of the ops.
+func fillStatsBatch(t *testing.T, batch dbm.Batch, n int) uint64 {
+	logical := uint64(0)
+	value := bytes.Repeat([]byte("v"), 1024)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key-%05d", i))
+		require.Nil(t, batch.Set(key, value))
+		logical += uint64(len(key) + len(value))
+	}
+	require.Nil(t, batch.Delete([]byte("deleted")))
+	return logical + uint64(len("deleted"))
+}
+
+func TestStatsBatchLogicalBytes(t *testing.T) {
+	db := dbm.NewMemDB()
+	batch := NewStatsBatch(db)
+	logical := fillStatsBatch(t, batch, 10)
+	// failed ops aren't counted
+	require.Error(t, batch.Set(nil, []byte("v")))
+	stats, err := batch.WriteWithStats()
+	require.Nil(t, err)
+	require.Equal(t, 11, stats.Ops)
+	require.Equal(t, logical, stats.LogicalBytes)
+	require.Zero(t, stats.PhysicalBytes)
+	require.Nil(t, batch.Close())
+
+	value, err := db.Get([]byte("key-00009"))
+	require.Nil(t, err)
+	require.Len(t, value, 1024)
+
+	// the stats batches of DBs making them aren't wrapped
+	_, ok := NewStatsBatch(&statsBatchDB{DB: db}).(*customStatsBatch)
+	require.True(t, ok)
+}
+
+// statsBatchDB makes StatsBatches itself.
+type statsBatchDB struct {
+	dbm.DB
+}
+
+func (db *statsBatchDB) NewBatch() dbm.Batch {
+	return &customStatsBatch{NewStatsBatch(db.DB)}
+}
+
+type customStatsBatch struct {
+	StatsBatch
+}
+
+func TestStatsBatchGoLevelDB(t *testing.T) {
+	db, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer db.Close()
+	for _, sync := range []bool{false, true} {
+		batch := NewStatsBatch(db)
+		logical := fillStatsBatch(t, batch, 1000)
+		write := batch.WriteWithStats
+		if sync {
+			write = batch.WriteSyncWithStats
+		}
+		stats, err := write()
+		require.Nil(t, err)
+		require.Equal(t, 1001, stats.Ops)
+		require.Equal(t, logical, stats.LogicalBytes)
+		// at least the journal record of the batch
+		require.GreaterOrEqual(t, stats.PhysicalBytes, logical)
+		require.Positive(t, stats.Elapsed)
+		require.Nil(t, batch.Close())
+	}
+}
+
+func TestMetricsDBWriteAmplification(t *testing.T) {
+	db, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer db.Close()
+	metricsDB := NewMetricsDB(db, MetricsOptions{TrackWriteAmplification: true})
+	logical := uint64(0)
+	for i := 0; i < 3; i++ {
+		batch := metricsDB.NewBatch()
+		logical += fillStatsBatch(t, batch, 100)
+		require.Nil(t, batch.Write())
+		require.Nil(t, batch.Close())
+	}
+	totals := metricsDB.BatchWriteTotals()
+	require.Equal(t, 3, totals.Writes)
+	require.Equal(t, 303, totals.Ops)
+	require.Equal(t, logical, totals.LogicalBytes)
+	require.GreaterOrEqual(t, totals.PhysicalBytes, logical)
+	require.GreaterOrEqual(t, totals.WriteAmplification(), 1.0)
+	stats := metricsDB.Stats()
+	require.Equal(t, fmt.Sprint(logical), stats["metrics.bytes_written"])
+	require.Equal(t, "3", stats["metrics."+OpBatchWrite+".count"])
+
+	// without the option, batches aren't wrapped
+	metricsDB = NewMetricsDB(db, MetricsOptions{})
+	batch := metricsDB.NewBatch()
+	fillStatsBatch(t, batch, 1)
+	require.Nil(t, batch.Write())
+	require.Zero(t, metricsDB.BatchWriteTotals().Writes)
+	_, ok := metricsDB.Stats()["metrics.bytes_written"]
+	require.False(t, ok)
+}
@@ -0,0 +1,112 @@
+package backends
+
+import (
+	dbm "github.com/tendermint/tm-db"
+)
+
+type KVPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// BatchedIterator is implemented by iterators able to return several pairs
+// at once, e.g. because they are read in bulk from a remote store.
+type BatchedIterator interface {
+	dbm.Iterator
+	// NextBatch returns up to max pairs starting with the current one, and
+	// moves past them, so that it can be interleaved with Next. It returns
+	// an empty batch once the iterator is invalid. On error, the pairs read
+	// so far are returned along with it.
+	NextBatch(max int) ([]KVPair, error)
+}
+
+// NewBatchedIterator returns iter if it implements BatchedIterator, or an
+// adapter iterating over it one pair at a time otherwise.
+func NewBatchedIterator(iter dbm.Iterator) BatchedIterator {
+	if batched, ok := iter.(BatchedIterator); ok {
+		return batched
+	}
+	return batchedIterator{iter}
+}
+
+type batchedIterator struct {
+	dbm.Iterator
+}
+
+func (iter batchedIterator) NextBatch(max int) ([]KVPair, error) {
+	batch := []KVPair{}
+	for len(batch) < max && iter.Valid() {
+		batch = append(batch, KVPair{Key: iter.Key(), Value: iter.Value()})
+		iter.Next()
+	}
+	return batch, iter.Error()
+}
+
+var _ BatchedIterator = (*arweaveDBIterator)(nil)
+
+// maxBatchPrealloc caps the capacity batches are allocated with, whatever
+// the requested size.
+const (
+	maxBatchPrealloc = 1024
+	arenaChunkSize   = 4096
+)
+
+// arena copies keys and values into shared chunks, saving an allocation
+// per pair.
+type arena struct {
+	buf []byte
+}
+
+func (a *arena) copy(s string) []byte {
+	if len(a.buf)+len(s) > cap(a.buf) {
+		size := arenaChunkSize
+		if len(s) > size {
+			size = len(s)
+		}
+		a.buf = make([]byte, 0, size)
+	}
+	start := len(a.buf)
+	a.buf = append(a.buf, s...)
+	return a.buf[start:len(a.buf):len(a.buf)]
+}
+
+// NextBatch implements BatchedIterator, reading pairs straight from the
+// decoded payloads.
+func (itr *arweaveDBIterator) NextBatch(max int) ([]KVPair, error) {
+	prealloc := max
+	if prealloc > maxBatchPrealloc {
+		prealloc = maxBatchPrealloc
+	} else if prealloc < 0 {
+		prealloc = 0
+	}
+	batch := make([]KVPair, 0, prealloc)
+	bytes := arena{}
+	for len(batch) < max && itr.Valid() {
+		key := itr.currentSortedKeys[itr.currentKeyIdx]
+		if itr.keysOnly {
+			batch = append(batch, KVPair{Key: bytes.copy(key)})
+			if err := itr.next(); err != nil {
+				itr.fail(err)
+				return batch, err
+			}
+			continue
+		}
+		raw, err := itr.db.decodeValue(key, itr.entries[itr.txIdx].txId, itr.currentTxData[key])
+		if err != nil {
+			itr.err = err
+			return batch, err
+		}
+		itr.recordServed(key, raw)
+		value, err := itr.db.resolveValue(raw)
+		if err != nil {
+			itr.err = err
+			return batch, err
+		}
+		batch = append(batch, KVPair{Key: bytes.copy(key), Value: value})
+		if err := itr.next(); err != nil {
+			itr.fail(err)
+			return batch, err
+		}
+	}
+	return batch, nil
+}
@@ -0,0 +1,164 @@
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// newBulkMockDB returns an ArweaveDB whose version 0 is split into the given
+// number of payloads, of perPayload keys each.
+func newBulkMockDB(payloads, perPayload int) *ArweaveDB {
+	prefixes, indices, txData := []string{}, []int{}, [][]byte{}
+	for i := 0; i < payloads; i++ {
+		keys, values := []string{}, []string{}
+		for j := 0; j < perPayload; j++ {
+			keys = append(keys, fmt.Sprintf("k%04d-%04d", i, j))
+			values = append(values, fmt.Sprintf("v%d", j))
+		}
+		prefixes = append(prefixes, keys[len(keys)-1])
+		indices = append(indices, i)
+		txData = append(txData, mockTxData(keys, values))
+	}
+	return NewMockArweaveDB([][]byte{mockIndex(prefixes, indices)}, txData, indices)
+}
+
+func collectPairs(t *testing.T, iter dbm.Iterator) []KVPair {
+	pairs := []KVPair{}
+	for ; iter.Valid(); iter.Next() {
+		pairs = append(pairs, KVPair{Key: iter.Key(), Value: iter.Value()})
+	}
+	require.Nil(t, iter.Error())
+	return pairs
+}
+
+// collectInterleaved alternates between Next and batches of growing sizes.
+func collectInterleaved(t *testing.T, iter BatchedIterator) []KVPair {
+	pairs := []KVPair{}
+	for size := 0; iter.Valid(); size++ {
+		if size%2 == 0 {
+			pairs = append(pairs, KVPair{Key: iter.Key(), Value: iter.Value()})
+			iter.Next()
+			continue
+		}
+		batch, err := iter.NextBatch(size)
+		require.Nil(t, err)
+		require.True(t, len(batch) <= size)
+		pairs = append(pairs, batch...)
+	}
+	batch, err := iter.NextBatch(10)
+	require.Nil(t, err)
+	require.Empty(t, batch)
+	return pairs
+}
+
+func TestNextBatchInterleaving(t *testing.T) {
+	memDB := dbm.NewMemDB()
+	for i := 0; i < 50; i++ {
+		require.Nil(t, memDB.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	arweaveDB := newBulkMockDB(5, 7)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	for name, newIterator := range map[string]func(reverse bool) (dbm.Iterator, error){
+		"memdb": func(reverse bool) (dbm.Iterator, error) {
+			if reverse {
+				return memDB.ReverseIterator([]byte("k05"), []byte("k45"))
+			}
+			return memDB.Iterator([]byte("k05"), []byte("k45"))
+		},
+		"arweave": func(reverse bool) (dbm.Iterator, error) {
+			start, end := append(v0Bz, "k0000-0003"...), append(v0Bz, "k0004-0002"...)
+			if reverse {
+				return arweaveDB.ReverseIterator(start, end)
+			}
+			return arweaveDB.Iterator(start, end)
+		},
+	} {
+		for _, reverse := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s reverse=%t", name, reverse), func(t *testing.T) {
+				iter, err := newIterator(reverse)
+				require.Nil(t, err)
+				expected := collectPairs(t, iter)
+				require.NotEmpty(t, expected)
+
+				iter, err = newIterator(reverse)
+				require.Nil(t, err)
+				require.Equal(t, expected, collectInterleaved(t, NewBatchedIterator(iter)))
+			})
+		}
+	}
+}
+
+func TestNextBatchNative(t *testing.T) {
+	iter, err := newBulkMockDB(1, 1).Iterator(make([]byte, 8), append(make([]byte, 8), 'z'))
+	require.Nil(t, err)
+	_, ok := NewBatchedIterator(iter).(*arweaveDBIterator)
+	require.True(t, ok)
+}
+
+func TestNextBatchError(t *testing.T) {
+	mockDB := newBulkMockDB(3, 4)
+	ApplyMiddleware(mockDB, func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			if string(key) == intToBase64Sha256(1) {
+				return nil, errors.New("gateway unavailable")
+			}
+			return next(key)
+		}
+	})
+	iter, err := mockDB.Iterator(make([]byte, 8), append(make([]byte, 8), 'z'))
+	require.Nil(t, err)
+	batch, err := NewBatchedIterator(iter).NextBatch(10)
+	require.NotNil(t, err)
+	require.Equal(t, 4, len(batch))
+	require.Equal(t, err, iter.Error())
+}
+
+// benchmarkExport exports a version in chunks of 256 pairs, like snapshot
+// builders do.
+func benchmarkExport(b *testing.B, batched bool) {
+	mockDB := newBulkMockDB(100, 100)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	exported := 0
+	sink := func(chunk []KVPair) {
+		exported += len(chunk)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iter, err := mockDB.Iterator(v0Bz, append(v0Bz, 'z'))
+		require.Nil(b, err)
+		if batched {
+			for iter.Valid() {
+				chunk, err := NewBatchedIterator(iter).NextBatch(256)
+				require.Nil(b, err)
+				sink(chunk)
+			}
+		} else {
+			chunk := make([]KVPair, 0, 256)
+			for ; iter.Valid(); iter.Next() {
+				chunk = append(chunk, KVPair{Key: iter.Key(), Value: iter.Value()})
+				if len(chunk) == 256 {
+					sink(chunk)
+					chunk = make([]KVPair, 0, 256)
+				}
+			}
+			sink(chunk)
+		}
+		iter.Close()
+	}
+	require.Equal(b, 10000*b.N, exported)
+}
+
+func BenchmarkExportNext(b *testing.B) {
+	benchmarkExport(b, false)
+}
+
+func BenchmarkExportNextBatch(b *testing.B) {
+	benchmarkExport(b, true)
+}
@@ -0,0 +1,246 @@
+package backends
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Checksummed values are stored as value || crc32c(value) || checksumMagic ||
+// checksumVersion, with the checksum in big endian.
+const (
+	checksumMagic   byte = 0xc5
+	checksumVersion byte = 0x01
+	checksumLen          = 4
+	checksumTrailer      = checksumLen + 2
+
+	checksumRewriteBatchSize = 1000
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumDB wraps a DB, storing a CRC32C checksum alongside every value
+// and verifying it on read so that silent corruption of the underlying
+// store is detected.
+type ChecksumDB struct {
+	dbm.DB
+	allowLegacy bool
+}
+
+var (
+	_ dbm.DB        = (*ChecksumDB)(nil)
+	_ FormatWrapper = (*ChecksumDB)(nil)
+)
+
+type ChecksumOption func(*ChecksumDB)
+
+// WithLegacyValues makes the ChecksumDB return values stored without a
+// checksum as is, which is needed until Rewrite has completed over a
+// pre-existing DB. A legacy value that happens to end with a checksum
+// trailer is indistinguishable from a corrupted value.
+func WithLegacyValues() ChecksumOption {
+	return func(db *ChecksumDB) {
+		db.allowLegacy = true
+	}
+}
+
+func NewChecksumDB(db dbm.DB, opts ...ChecksumOption) *ChecksumDB {
+	checksumDB := &ChecksumDB{DB: db}
+	for _, opt := range opts {
+		opt(checksumDB)
+	}
+	return checksumDB
+}
+
+// Unwrap implements Unwrapper.
+func (db *ChecksumDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+// WrapperFormat implements FormatWrapper.
+func (db *ChecksumDB) WrapperFormat() string {
+	return fmt.Sprintf("checksum.v%d", checksumVersion)
+}
+
+func appendChecksum(value []byte) []byte {
+	res := make([]byte, len(value)+checksumTrailer)
+	copy(res, value)
+	binary.BigEndian.PutUint32(res[len(value):], crc32.Checksum(value, crc32c))
+	res[len(res)-2], res[len(res)-1] = checksumMagic, checksumVersion
+	return res
+}
+
+func hasChecksumTrailer(stored []byte) bool {
+	return len(stored) >= checksumTrailer &&
+		stored[len(stored)-2] == checksumMagic &&
+		stored[len(stored)-1] == checksumVersion
+}
+
+func (db *ChecksumDB) verify(key []byte, stored []byte) ([]byte, error) {
+	if stored == nil {
+		return nil, nil
+	}
+	if !hasChecksumTrailer(stored) {
+		if db.allowLegacy {
+			return stored, nil
+		}
+		return nil, db.corrupted(key, "missing checksum")
+	}
+	value := stored[:len(stored)-checksumTrailer]
+	checksum := binary.BigEndian.Uint32(stored[len(value):])
+	if crc32.Checksum(value, crc32c) != checksum {
+		return nil, db.corrupted(key, "checksum mismatch")
+	}
+	return value, nil
+}
+
+func (db *ChecksumDB) corrupted(key []byte, reason string) error {
+	emitEvent(EventCorruptionDetected, backendName(db.DB), "", fmt.Sprintf("%s for key %X", reason, key))
+	return &ErrValueCorrupted{key: string(key)}
+}
+
+// Get implements DB.
+func (db *ChecksumDB) Get(key []byte) ([]byte, error) {
+	stored, err := db.DB.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return db.verify(key, stored)
+}
+
+// Set implements DB.
+func (db *ChecksumDB) Set(key []byte, value []byte) error {
+	if value == nil {
+		return db.DB.Set(key, value)
+	}
+	return db.DB.Set(key, appendChecksum(value))
+}
+
+// SetSync implements DB.
+func (db *ChecksumDB) SetSync(key []byte, value []byte) error {
+	if value == nil {
+		return db.DB.SetSync(key, value)
+	}
+	return db.DB.SetSync(key, appendChecksum(value))
+}
+
+// Iterator implements DB.
+func (db *ChecksumDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	iter, err := db.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumIterator{guardedIterator: guardIterator(iter), db: db}, nil
+}
+
+// ReverseIterator implements DB.
+func (db *ChecksumDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	iter, err := db.DB.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumIterator{guardedIterator: guardIterator(iter), db: db}, nil
+}
+
+// NewBatch implements DB.
+func (db *ChecksumDB) NewBatch() dbm.Batch {
+	return &checksumBatch{Batch: db.DB.NewBatch()}
+}
+
+// Rewrite adds checksums to all values stored without one, in batches. It
+// fails on values which have a checksum that doesn't match, rather than
+// blessing the corruption.
+func (db *ChecksumDB) Rewrite(ctx context.Context) (rewritten int, err error) {
+	var start []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return rewritten, err
+		}
+		keys, values, next, err := db.legacyValues(start)
+		if err != nil {
+			return rewritten, err
+		}
+		batch := db.DB.NewBatch()
+		for i, key := range keys {
+			if err := batch.Set(key, appendChecksum(values[i])); err != nil {
+				batch.Close()
+				return rewritten, err
+			}
+		}
+		if err := batch.Write(); err != nil {
+			batch.Close()
+			return rewritten, err
+		}
+		batch.Close()
+		rewritten += len(keys)
+		if next == nil {
+			return rewritten, nil
+		}
+		start = next
+	}
+}
+
+// legacyValues scans up to checksumRewriteBatchSize keys from start and
+// returns those without checksum, along with the key to resume from, if
+// any. The iterator is closed before returning so the caller may write.
+func (db *ChecksumDB) legacyValues(start []byte) (keys [][]byte, values [][]byte, next []byte, err error) {
+	iter, err := db.DB.Iterator(start, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer iter.Close()
+	for scanned := 0; iter.Valid(); iter.Next() {
+		if scanned == checksumRewriteBatchSize {
+			return keys, values, append([]byte{}, iter.Key()...), nil
+		}
+		scanned++
+		stored := iter.Value()
+		if hasChecksumTrailer(stored) {
+			if _, err := db.verify(iter.Key(), stored); err != nil {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+		keys = append(keys, append([]byte{}, iter.Key()...))
+		values = append(values, append([]byte{}, stored...))
+	}
+	return keys, values, nil, iter.Error()
+}
+
+type checksumIterator struct {
+	guardedIterator
+	db  *ChecksumDB
+	err error
+}
+
+// Value implements Iterator. A corrupted value is reported through Error.
+func (itr *checksumIterator) Value() []byte {
+	value, err := itr.db.verify(itr.Key(), itr.guardedIterator.Value())
+	if err != nil {
+		itr.err = err
+		return nil
+	}
+	return value
+}
+
+// Error implements Iterator.
+func (itr *checksumIterator) Error() error {
+	if itr.err != nil {
+		return itr.err
+	}
+	return itr.Iterator.Error()
+}
+
+type checksumBatch struct {
+	dbm.Batch
+}
+
+func (b *checksumBatch) Set(key, value []byte) error {
+	if value == nil {
+		return b.Batch.Set(key, value)
+	}
+	return b.Batch.Set(key, appendChecksum(value))
+}
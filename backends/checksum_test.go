@@ -0,0 +1,124 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func flipStoredByte(t *testing.T, db dbm.DB, key []byte, offset int) {
+	stored, err := db.Get(key)
+	require.Nil(t, err)
+	corrupted := append([]byte{}, stored...)
+	corrupted[offset] ^= 0x01
+	require.Nil(t, db.Set(key, corrupted))
+}
+
+func TestChecksumDB(t *testing.T) {
+	underlying := dbm.NewMemDB()
+	db := NewChecksumDB(underlying)
+	require.Nil(t, db.Set([]byte("a"), []byte("v1")))
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("b"), []byte("v2")))
+	require.Nil(t, batch.Set([]byte("c"), []byte{}))
+	require.Nil(t, batch.Write())
+	require.Nil(t, batch.Close())
+
+	for key, expected := range map[string]string{"a": "v1", "b": "v2", "c": ""} {
+		value, err := db.Get([]byte(key))
+		require.Nil(t, err)
+		require.NotNil(t, value)
+		require.Equal(t, expected, string(value))
+	}
+	value, err := db.Get([]byte("missing"))
+	require.Nil(t, err)
+	require.Nil(t, value)
+
+	stored, err := underlying.Get([]byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, 2+checksumTrailer, len(stored))
+
+	// flip a byte of the value itself
+	flipStoredByte(t, underlying, []byte("b"), 0)
+	_, err = db.Get([]byte("b"))
+	require.Equal(t, &ErrValueCorrupted{key: "b"}, err)
+
+	// the other keys are unaffected
+	value, err = db.Get([]byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, "v1", string(value))
+}
+
+func TestChecksumDBIterator(t *testing.T) {
+	underlying := dbm.NewMemDB()
+	db := NewChecksumDB(underlying)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	// flip a byte of the checksum
+	flipStoredByte(t, underlying, []byte("k1"), 3)
+
+	for _, reverse := range []bool{false, true} {
+		var iter dbm.Iterator
+		var err error
+		if reverse {
+			iter, err = db.ReverseIterator(nil, nil)
+		} else {
+			iter, err = db.Iterator(nil, nil)
+		}
+		require.Nil(t, err)
+		values := []string{}
+		for ; iter.Valid(); iter.Next() {
+			values = append(values, string(iter.Value()))
+		}
+		if reverse {
+			require.Equal(t, []string{"v2", "", "v0"}, values)
+		} else {
+			require.Equal(t, []string{"v0", "", "v2"}, values)
+		}
+		require.Equal(t, &ErrValueCorrupted{key: "k1"}, iter.Error())
+		require.Nil(t, iter.Close())
+	}
+}
+
+func TestChecksumDBLegacyValues(t *testing.T) {
+	underlying := dbm.NewMemDB()
+	for i := 0; i < checksumRewriteBatchSize+10; i++ {
+		require.Nil(t, underlying.Set([]byte(fmt.Sprintf("legacy%05d", i)), []byte("old")))
+	}
+	strict := NewChecksumDB(underlying)
+	_, err := strict.Get([]byte("legacy00000"))
+	require.True(t, errors.As(err, new(*ErrValueCorrupted)))
+
+	db := NewChecksumDB(underlying, WithLegacyValues())
+	require.Nil(t, db.Set([]byte("new"), []byte("checksummed")))
+	value, err := db.Get([]byte("legacy00000"))
+	require.Nil(t, err)
+	require.Equal(t, "old", string(value))
+
+	rewritten, err := db.Rewrite(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, checksumRewriteBatchSize+10, rewritten)
+	for _, key := range []string{"legacy00000", fmt.Sprintf("legacy%05d", checksumRewriteBatchSize+9)} {
+		value, err = strict.Get([]byte(key))
+		require.Nil(t, err)
+		require.Equal(t, "old", string(value))
+	}
+	value, err = strict.Get([]byte("new"))
+	require.Nil(t, err)
+	require.Equal(t, "checksummed", string(value))
+
+	// rewriting again is a no-op
+	rewritten, err = db.Rewrite(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 0, rewritten)
+
+	// corrupted values aren't blessed
+	flipStoredByte(t, underlying, []byte("new"), 0)
+	_, err = db.Rewrite(context.Background())
+	require.Equal(t, &ErrValueCorrupted{key: "new"}, err)
+}
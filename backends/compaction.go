@@ -0,0 +1,223 @@
+package backends
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// compactionProgressKey is where a CompactionScheduler persists its progress
+// in the DB it compacts.
+var compactionProgressKey = mustReserveSubspace("compaction_scheduler").Key([]byte("progress"))
+
+var ErrCompactionUnsupported = errors.New("backend does not support manual compaction")
+
+// Compacter is implemented by backends supporting manual compaction of a key
+// range, such as goleveldb.
+type Compacter interface {
+	ForceCompact(start, limit []byte) error
+}
+
+// TimeWindow is a daily window, expressed as offsets from midnight in the
+// scheduler's clock location. A window whose End is before its Start wraps
+// around midnight.
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (w TimeWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+type CompactionStatus struct {
+	// index of the range compacted by the last run
+	RangeIdx int
+	Started  time.Time
+	Duration time.Duration
+	Err      error
+}
+
+type compactionProgress struct {
+	NextRangeIdx int `json:"next_range_idx"`
+}
+
+// CompactionScheduler compacts the configured ranges of a DB one at a time,
+// only within the allowed time windows. Progress is persisted in the DB so
+// that a restarted scheduler resumes with the range it would have compacted
+// next.
+type CompactionScheduler struct {
+	db      dbm.DB
+	windows []TimeWindow
+	ranges  [][2][]byte
+	now     func() time.Time
+
+	// held while compacting, shared with operator-triggered compactions
+	compactMtx sync.Mutex
+
+	mtx          sync.Mutex
+	paused       bool
+	nextRangeIdx int
+	lastRun      *CompactionStatus
+	stop         chan struct{}
+	stopped      chan struct{}
+}
+
+func NewCompactionScheduler(db dbm.DB, windows []TimeWindow, ranges [][2][]byte) *CompactionScheduler {
+	s := &CompactionScheduler{
+		db:      db,
+		windows: windows,
+		ranges:  ranges,
+		now:     time.Now,
+	}
+	if bz, err := db.Get(compactionProgressKey); err == nil && bz != nil {
+		progress := compactionProgress{}
+		if err := json.Unmarshal(bz, &progress); err == nil && progress.NextRangeIdx < len(ranges) {
+			s.nextRangeIdx = progress.NextRangeIdx
+		}
+	}
+	return s
+}
+
+// Start checks every interval whether a range should be compacted, until
+// Stop is called.
+func (s *CompactionScheduler) Start(interval time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop, s.stopped = make(chan struct{}), make(chan struct{})
+	go func(stop, stopped chan struct{}) {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.tick()
+			}
+		}
+	}(s.stop, s.stopped)
+}
+
+// Stop stops the background loop, waiting for an ongoing compaction.
+func (s *CompactionScheduler) Stop() {
+	s.mtx.Lock()
+	stop, stopped := s.stop, s.stopped
+	s.stop, s.stopped = nil, nil
+	s.mtx.Unlock()
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+}
+
+func (s *CompactionScheduler) Pause() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.paused = true
+}
+
+func (s *CompactionScheduler) Resume() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.paused = false
+}
+
+// LastRun returns the status of the last scheduled compaction, if any.
+func (s *CompactionScheduler) LastRun() (CompactionStatus, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.lastRun == nil {
+		return CompactionStatus{}, false
+	}
+	return *s.lastRun, true
+}
+
+// RunNow compacts the next range immediately, regardless of time windows and
+// pausing.
+func (s *CompactionScheduler) RunNow() error {
+	return s.runNext()
+}
+
+// Compact compacts the given range, never overlapping with scheduled
+// compactions. Operators should compact through it rather than directly.
+func (s *CompactionScheduler) Compact(start, limit []byte) error {
+	s.compactMtx.Lock()
+	defer s.compactMtx.Unlock()
+	return s.compact(start, limit)
+}
+
+func (s *CompactionScheduler) compact(start, limit []byte) error {
+	compacter, ok := s.db.(Compacter)
+	if !ok {
+		return ErrCompactionUnsupported
+	}
+	backend, detail := backendName(s.db), fmt.Sprintf("[%X, %X)", start, limit)
+	emitEvent(EventCompactionStarted, backend, "", detail)
+	err := compacter.ForceCompact(start, limit)
+	if err != nil {
+		detail = fmt.Sprintf("%s: %s", detail, err)
+	}
+	emitEvent(EventCompactionFinished, backend, "", detail)
+	return err
+}
+
+func (s *CompactionScheduler) tick() {
+	s.mtx.Lock()
+	paused := s.paused
+	s.mtx.Unlock()
+	if paused || !s.inWindow(s.now()) {
+		return
+	}
+	s.runNext()
+}
+
+func (s *CompactionScheduler) inWindow(t time.Time) bool {
+	for _, window := range s.windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CompactionScheduler) runNext() error {
+	if len(s.ranges) == 0 {
+		return nil
+	}
+	s.compactMtx.Lock()
+	defer s.compactMtx.Unlock()
+	s.mtx.Lock()
+	idx := s.nextRangeIdx
+	s.mtx.Unlock()
+
+	status := &CompactionStatus{RangeIdx: idx, Started: s.now()}
+	status.Err = s.compact(s.ranges[idx][0], s.ranges[idx][1])
+	status.Duration = s.now().Sub(status.Started)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.lastRun = status
+	if status.Err != nil {
+		return status.Err
+	}
+	s.nextRangeIdx = (idx + 1) % len(s.ranges)
+	bz, err := json.Marshal(compactionProgress{NextRangeIdx: s.nextRangeIdx})
+	if err != nil {
+		return err
+	}
+	return metadataDB(s.db).Set(compactionProgressKey, bz)
+}
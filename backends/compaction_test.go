@@ -0,0 +1,146 @@
+package backends
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+type compactRecordingDB struct {
+	*dbm.MemDB
+	mtx       sync.Mutex
+	compacted []string
+	running   int
+	overlaps  int
+	block     chan struct{}
+}
+
+func (db *compactRecordingDB) ForceCompact(start, limit []byte) error {
+	db.mtx.Lock()
+	db.running++
+	if db.running > 1 {
+		db.overlaps++
+	}
+	db.compacted = append(db.compacted, string(start)+"-"+string(limit))
+	block := db.block
+	db.mtx.Unlock()
+	if block != nil {
+		<-block
+	}
+	db.mtx.Lock()
+	db.running--
+	db.mtx.Unlock()
+	return nil
+}
+
+func newTestCompactionScheduler(db dbm.DB, clock *fakeClock) *CompactionScheduler {
+	s := NewCompactionScheduler(db, []TimeWindow{{Start: 2 * time.Hour, End: 4 * time.Hour}}, [][2][]byte{
+		{[]byte("a"), []byte("b")},
+		{[]byte("b"), []byte("c")},
+		{[]byte("c"), []byte("d")},
+	})
+	s.now = clock.Now
+	return s
+}
+
+func TestCompactionSchedulerWindows(t *testing.T) {
+	db := &compactRecordingDB{MemDB: dbm.NewMemDB()}
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC)}
+	s := newTestCompactionScheduler(db, clock)
+
+	s.tick()
+	require.Empty(t, db.compacted)
+	_, ok := s.LastRun()
+	require.False(t, ok)
+
+	clock.Advance(90 * time.Minute)
+	s.tick()
+	s.tick()
+	require.Equal(t, []string{"a-b", "b-c"}, db.compacted)
+	status, ok := s.LastRun()
+	require.True(t, ok)
+	require.Equal(t, 1, status.RangeIdx)
+	require.Nil(t, status.Err)
+
+	s.Pause()
+	s.tick()
+	require.Equal(t, 2, len(db.compacted))
+	s.Resume()
+
+	clock.Advance(3 * time.Hour)
+	s.tick()
+	require.Equal(t, 2, len(db.compacted))
+
+	// run-now ignores windows
+	require.Nil(t, s.RunNow())
+	require.Equal(t, []string{"a-b", "b-c", "c-d"}, db.compacted)
+	require.Nil(t, s.RunNow())
+	require.Equal(t, "a-b", db.compacted[3])
+}
+
+func TestCompactionSchedulerWrappingWindow(t *testing.T) {
+	window := TimeWindow{Start: 23 * time.Hour, End: time.Hour}
+	require.True(t, window.contains(time.Date(2022, 1, 1, 23, 30, 0, 0, time.UTC)))
+	require.True(t, window.contains(time.Date(2022, 1, 1, 0, 30, 0, 0, time.UTC)))
+	require.False(t, window.contains(time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC)))
+}
+
+func TestCompactionSchedulerResumesProgress(t *testing.T) {
+	db := &compactRecordingDB{MemDB: dbm.NewMemDB()}
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 3, 0, 0, 0, time.UTC)}
+	s := newTestCompactionScheduler(db, clock)
+	s.tick()
+	s.tick()
+
+	restarted := newTestCompactionScheduler(db, clock)
+	restarted.tick()
+	require.Equal(t, []string{"a-b", "b-c", "c-d"}, db.compacted)
+}
+
+func TestCompactionSchedulerNoOverlap(t *testing.T) {
+	db := &compactRecordingDB{MemDB: dbm.NewMemDB(), block: make(chan struct{})}
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 3, 0, 0, 0, time.UTC)}
+	s := newTestCompactionScheduler(db, clock)
+
+	done := make(chan struct{})
+	go func() {
+		require.Nil(t, s.Compact([]byte("x"), []byte("y")))
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		db.mtx.Lock()
+		defer db.mtx.Unlock()
+		return db.running == 1
+	}, time.Second, time.Millisecond)
+	scheduled := make(chan struct{})
+	go func() {
+		s.tick()
+		close(scheduled)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	db.block <- struct{}{}
+	<-done
+	db.block <- struct{}{}
+	<-scheduled
+	require.Equal(t, 0, db.overlaps)
+	require.Equal(t, []string{"x-y", "a-b"}, db.compacted)
+}
+
+func TestCompactionSchedulerUnsupported(t *testing.T) {
+	s := NewCompactionScheduler(dbm.NewMemDB(), nil, [][2][]byte{{nil, nil}})
+	require.Equal(t, ErrCompactionUnsupported, s.RunNow())
+	status, ok := s.LastRun()
+	require.True(t, ok)
+	require.Equal(t, ErrCompactionUnsupported, status.Err)
+}
+
+func TestCompactionSchedulerGoLevelDB(t *testing.T) {
+	db, err := dbm.NewGoLevelDB("compaction", t.TempDir())
+	require.Nil(t, err)
+	defer db.Close()
+	s := NewCompactionScheduler(db, nil, [][2][]byte{{nil, nil}})
+	require.Nil(t, s.RunNow())
+}
@@ -0,0 +1,291 @@
+package backends
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Values written through a CompressedDB are stored either untouched, or
+// prefixed with an envelope made of compressionMagic and a marker byte:
+// compressedFlag along with the ID of the codec in the low bits if the
+// rest is compressed, or escapedMarker for uncompressed values starting
+// with compressionMagic themselves. Values not starting with an envelope
+// are returned as is, which covers values written before the DB was
+// compressed unless they start with an envelope, which only about one in
+// 2^32 arbitrary values does. DBs which may hold such values are to be
+// migrated rather than wrapped, see ExpectFormat.
+const (
+	compressionMagic        = "\xc0\x5a\x9d\x1b"
+	compressedFlag     byte = 0x08
+	compressorIDMask   byte = 0x07
+	escapedMarker      byte = 0x00
+	compressionVersion      = 2
+)
+
+// Compressor compresses values of a CompressedDB.
+type Compressor interface {
+	// ID identifies the codec in the envelope of values, between 1 and 7.
+	ID() byte
+	Name() string
+	Compress(value []byte) []byte
+	Decompress(compressed []byte) ([]byte, error)
+}
+
+type snappyCompressor struct{}
+
+// SnappyCompressor compresses with Snappy, which is fast but compresses
+// less than zstd.
+var SnappyCompressor Compressor = snappyCompressor{}
+
+func (snappyCompressor) ID() byte {
+	return 1
+}
+
+func (snappyCompressor) Name() string {
+	return "snappy"
+}
+
+func (snappyCompressor) Compress(value []byte) []byte {
+	return snappy.Encode(nil, value)
+}
+
+func (snappyCompressor) Decompress(compressed []byte) ([]byte, error) {
+	return snappy.Decode(nil, compressed)
+}
+
+type zstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+// ZstdCompressor compresses with zstd at its default level.
+var ZstdCompressor Compressor = &zstdCompressor{}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		if c.encoder, c.err = zstd.NewWriter(nil); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil)
+	})
+	return c.err
+}
+
+func (c *zstdCompressor) ID() byte {
+	return 2
+}
+
+func (c *zstdCompressor) Name() string {
+	return "zstd"
+}
+
+func (c *zstdCompressor) Compress(value []byte) []byte {
+	if err := c.init(); err != nil {
+		panic(err)
+	}
+	return c.encoder.EncodeAll(value, nil)
+}
+
+func (c *zstdCompressor) Decompress(compressed []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeAll(compressed, nil)
+}
+
+// compressors are the codecs values can be decompressed with by ID, so that
+// the codec of a DB can be changed.
+var compressors = map[byte]Compressor{
+	SnappyCompressor.ID(): SnappyCompressor,
+	ZstdCompressor.ID():   ZstdCompressor,
+}
+
+// CompressedDB wraps a DB, compressing values of at least minSize bytes.
+type CompressedDB struct {
+	dbm.DB
+	codec   Compressor
+	minSize int
+
+	// bytes of values written, and stored for them
+	bytesIn, bytesStored int64
+}
+
+var (
+	_ dbm.DB        = (*CompressedDB)(nil)
+	_ FormatWrapper = (*CompressedDB)(nil)
+)
+
+func NewCompressedDB(db dbm.DB, codec Compressor, minSize int) *CompressedDB {
+	return &CompressedDB{DB: db, codec: codec, minSize: minSize}
+}
+
+// Unwrap implements Unwrapper.
+func (db *CompressedDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+// WrapperFormat implements FormatWrapper. The codec isn't part of the
+// format, as values of any codec can be read.
+func (db *CompressedDB) WrapperFormat() string {
+	return fmt.Sprintf("compressed.v%d", compressionVersion)
+}
+
+// envelope returns the stored form of value with the given marker.
+func envelope(marker byte, value []byte) []byte {
+	stored := make([]byte, 0, len(compressionMagic)+1+len(value))
+	stored = append(append(stored, compressionMagic...), marker)
+	return append(stored, value...)
+}
+
+// compress returns the stored form of value. Values which don't compress
+// are stored uncompressed.
+func (db *CompressedDB) compress(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	stored := value
+	if len(value) >= db.minSize {
+		compressed := db.codec.Compress(value)
+		if len(compressed)+len(compressionMagic)+1 < len(value) {
+			stored = envelope(compressedFlag|db.codec.ID(), compressed)
+		}
+	}
+	// values which could be mistaken for an envelope are escaped
+	if len(stored) == len(value) && bytes.HasPrefix(value, []byte(compressionMagic)) {
+		stored = envelope(escapedMarker, value)
+	}
+	atomic.AddInt64(&db.bytesIn, int64(len(value)))
+	atomic.AddInt64(&db.bytesStored, int64(len(stored)))
+	return stored
+}
+
+func (db *CompressedDB) decompress(key []byte, stored []byte) ([]byte, error) {
+	if len(stored) <= len(compressionMagic) || !bytes.HasPrefix(stored, []byte(compressionMagic)) {
+		return stored, nil
+	}
+	marker, payload := stored[len(compressionMagic)], stored[len(compressionMagic)+1:]
+	switch {
+	case marker == escapedMarker:
+		return payload, nil
+	case marker&^compressorIDMask != compressedFlag:
+		// not written through a CompressedDB
+		return stored, nil
+	}
+	id := marker & compressorIDMask
+	codec, ok := compressors[id]
+	if id == db.codec.ID() {
+		codec, ok = db.codec, true
+	}
+	if !ok {
+		return nil, &ErrUndecompressableValue{key: string(key), reason: fmt.Sprintf("unknown compressor %d", id)}
+	}
+	value, err := codec.Decompress(payload)
+	if err != nil {
+		return nil, &ErrUndecompressableValue{key: string(key), reason: fmt.Sprintf("%s: %s", codec.Name(), err)}
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// Get implements DB.
+func (db *CompressedDB) Get(key []byte) ([]byte, error) {
+	stored, err := db.DB.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return db.decompress(key, stored)
+}
+
+// Set implements DB.
+func (db *CompressedDB) Set(key []byte, value []byte) error {
+	return db.DB.Set(key, db.compress(value))
+}
+
+// SetSync implements DB.
+func (db *CompressedDB) SetSync(key []byte, value []byte) error {
+	return db.DB.SetSync(key, db.compress(value))
+}
+
+// Iterator implements DB.
+func (db *CompressedDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	iter, err := db.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedIterator{guardedIterator: guardIterator(iter), db: db}, nil
+}
+
+// ReverseIterator implements DB.
+func (db *CompressedDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	iter, err := db.DB.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedIterator{guardedIterator: guardIterator(iter), db: db}, nil
+}
+
+// NewBatch implements DB.
+func (db *CompressedDB) NewBatch() dbm.Batch {
+	return &compressedBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+// Stats implements DB, adding the ratio of the bytes of the values written
+// through the DB to the bytes stored for them.
+func (db *CompressedDB) Stats() map[string]string {
+	stats := db.DB.Stats()
+	if stats == nil {
+		stats = map[string]string{}
+	}
+	bytesIn, bytesStored := atomic.LoadInt64(&db.bytesIn), atomic.LoadInt64(&db.bytesStored)
+	stats["compression.codec"] = db.codec.Name()
+	stats["compression.bytes_in"] = fmt.Sprint(bytesIn)
+	stats["compression.bytes_stored"] = fmt.Sprint(bytesStored)
+	if bytesStored > 0 {
+		stats["compression.ratio"] = fmt.Sprintf("%.2f", float64(bytesIn)/float64(bytesStored))
+	}
+	return stats
+}
+
+type compressedIterator struct {
+	guardedIterator
+	db  *CompressedDB
+	err error
+}
+
+// Value implements Iterator. An undecodable value is reported through
+// Error.
+func (itr *compressedIterator) Value() []byte {
+	value, err := itr.db.decompress(itr.Key(), itr.guardedIterator.Value())
+	if err != nil {
+		itr.err = err
+		return nil
+	}
+	return value
+}
+
+// Error implements Iterator.
+func (itr *compressedIterator) Error() error {
+	if itr.err != nil {
+		return itr.err
+	}
+	return itr.Iterator.Error()
+}
+
+type compressedBatch struct {
+	dbm.Batch
+	db *CompressedDB
+}
+
+func (b *compressedBatch) Set(key, value []byte) error {
+	return b.Batch.Set(key, b.db.compress(value))
+}
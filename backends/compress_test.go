@@ -0,0 +1,177 @@
+package backends
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// compressibleValue returns a value of n bytes compressing about 4x, like
+// EVM storage values.
+func compressibleValue(r *rand.Rand, n int) []byte {
+	value := make([]byte, n)
+	for i := range value {
+		if i%4 == 0 {
+			value[i] = byte(r.Intn(256))
+		}
+	}
+	return value
+}
+
+func TestCompressedDBRoundTrip(t *testing.T) {
+	newGoLevelDB := func(t *testing.T) dbm.DB {
+		db, err := dbm.NewGoLevelDB("test", t.TempDir())
+		require.Nil(t, err)
+		return db
+	}
+	for name, newDB := range map[string]func(t *testing.T) dbm.DB{
+		"memdb":     func(*testing.T) dbm.DB { return dbm.NewMemDB() },
+		"goleveldb": newGoLevelDB,
+	} {
+		for _, codec := range []Compressor{SnappyCompressor, ZstdCompressor} {
+			t.Run(fmt.Sprintf("%s/%s", name, codec.Name()), func(t *testing.T) {
+				r := rand.New(rand.NewSource(0))
+				underlying := newDB(t)
+				defer underlying.Close()
+				expected := dbm.NewMemDB()
+				db := NewCompressedDB(underlying, codec, 64)
+				batch := db.NewBatch()
+				for i := 0; i < 200; i++ {
+					key := []byte(fmt.Sprintf("key%03d", i))
+					var value []byte
+					switch i % 4 {
+					case 0:
+						value = compressibleValue(r, 64+r.Intn(512))
+					case 1:
+						// incompressible
+						value = make([]byte, 64+r.Intn(512))
+						r.Read(value)
+					case 2:
+						value = compressibleValue(r, r.Intn(64))
+					case 3:
+						// small values looking like an envelope
+						value = append([]byte(compressionMagic+string(rune(r.Intn(16)))), compressibleValue(r, r.Intn(64))...)
+					}
+					require.Nil(t, expected.Set(key, value))
+					if i%2 == 0 {
+						require.Nil(t, db.Set(key, value))
+					} else {
+						require.Nil(t, batch.Set(key, value))
+					}
+				}
+				require.Nil(t, batch.Write())
+				require.Nil(t, batch.Close())
+				requireIdentical(t, expected, db)
+				for i := 0; i < 200; i++ {
+					key := []byte(fmt.Sprintf("key%03d", i))
+					value, err := db.Get(key)
+					require.Nil(t, err)
+					expectedValue, err := expected.Get(key)
+					require.Nil(t, err)
+					require.Equal(t, expectedValue, value)
+				}
+
+				stats := db.Stats()
+				require.Equal(t, codec.Name(), stats["compression.codec"])
+				require.NotEmpty(t, stats["compression.ratio"])
+				stored, err := underlying.Get([]byte("key000"))
+				require.Nil(t, err)
+				require.Equal(t, envelope(compressedFlag|codec.ID(), nil), stored[:len(compressionMagic)+1])
+			})
+		}
+	}
+}
+
+func TestCompressedDBMixedContent(t *testing.T) {
+	underlying := dbm.NewMemDB()
+	r := rand.New(rand.NewSource(0))
+	large := compressibleValue(r, 1000)
+	// written before compression was enabled
+	require.Nil(t, underlying.Set([]byte("legacy"), large))
+	require.Nil(t, underlying.Set([]byte("legacy-empty"), []byte{}))
+	// legacy values starting like the envelopes of other formats
+	legacyValues := map[string][]byte{}
+	for _, first := range []byte{0xc0, 0xc8, 0xc9, 0xcf} {
+		for _, value := range [][]byte{{first}, append([]byte{first}, large[:31]...), append([]byte{first, first}, large...)} {
+			key := fmt.Sprintf("legacy-%X-%d", first, len(value))
+			legacyValues[key] = value
+			require.Nil(t, underlying.Set([]byte(key), value))
+		}
+	}
+	legacyValues["legacy-prefix"] = []byte(compressionMagic[:3])
+	legacyValues["legacy-marker"] = []byte(compressionMagic + "\x01")
+	for key, value := range legacyValues {
+		require.Nil(t, underlying.Set([]byte(key), value))
+	}
+	// written with another codec
+	require.Nil(t, NewCompressedDB(underlying, ZstdCompressor, 0).Set([]byte("zstd"), large))
+	db := NewCompressedDB(underlying, SnappyCompressor, 100)
+	require.Nil(t, db.Set([]byte("snappy"), large))
+	require.Nil(t, db.Set([]byte("small"), []byte("small")))
+	stored, err := underlying.Get([]byte("small"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("small"), stored)
+
+	for _, key := range []string{"legacy", "zstd", "snappy"} {
+		value, err := db.Get([]byte(key))
+		require.Nil(t, err)
+		require.True(t, bytes.Equal(large, value), key)
+	}
+	requireEmptyValue(t, db, []byte("legacy-empty"))
+	for key, expected := range legacyValues {
+		value, err := db.Get([]byte(key))
+		require.Nil(t, err, key)
+		require.Equal(t, expected, value, key)
+	}
+	iter, err := db.Iterator([]byte("legacy-"), []byte("legacy-~"))
+	require.Nil(t, err)
+	for ; iter.Valid(); iter.Next() {
+		if expected, ok := legacyValues[string(iter.Key())]; ok {
+			require.Equal(t, expected, iter.Value(), string(iter.Key()))
+		}
+	}
+	require.Nil(t, iter.Error())
+	require.Nil(t, iter.Close())
+
+	// unknown codecs and corrupted values are reported
+	require.Nil(t, underlying.Set([]byte("corrupted"), envelope(compressedFlag|7, []byte{1, 2, 3})))
+	_, err = db.Get([]byte("corrupted"))
+	require.ErrorAs(t, err, new(*ErrUndecompressableValue))
+	require.Nil(t, underlying.Set([]byte("corrupted"), envelope(compressedFlag|SnappyCompressor.ID(), []byte{1, 2, 3})))
+	_, err = db.Get([]byte("corrupted"))
+	require.ErrorAs(t, err, new(*ErrUndecompressableValue))
+	iter, err = db.Iterator([]byte("corrupted"), nil)
+	require.Nil(t, err)
+	require.Nil(t, iter.Value())
+	require.ErrorAs(t, iter.Error(), new(*ErrUndecompressableValue))
+	require.Nil(t, iter.Close())
+}
+
+func BenchmarkCompressedDBWrite(b *testing.B) {
+	r := rand.New(rand.NewSource(0))
+	values := make([][]byte, 1000)
+	for i := range values {
+		values[i] = compressibleValue(r, 32+r.Intn(1000))
+	}
+	for name, wrap := range map[string]func(dbm.DB) dbm.DB{
+		"plain":  func(db dbm.DB) dbm.DB { return db },
+		"snappy": func(db dbm.DB) dbm.DB { return NewCompressedDB(db, SnappyCompressor, 64) },
+		"zstd":   func(db dbm.DB) dbm.DB { return NewCompressedDB(db, ZstdCompressor, 64) },
+	} {
+		b.Run(name, func(b *testing.B) {
+			db := wrap(dbm.NewMemDB())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				value := values[i%len(values)]
+				b.SetBytes(int64(len(value)))
+				if err := db.Set([]byte(fmt.Sprintf("key%d", i%10000)), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package backends
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// reader is the read side shared by DBs, snapshots and ArweaveDB.
+type reader interface {
+	Get([]byte) ([]byte, error)
+	Has([]byte) (bool, error)
+	Iterator(start, end []byte) (dbm.Iterator, error)
+}
+
+// requireEmptyValue checks that key is present with an empty, non-nil value
+// through every read path of r.
+func requireEmptyValue(t *testing.T, r reader, key []byte) {
+	value, err := r.Get(key)
+	require.Nil(t, err)
+	require.NotNil(t, value)
+	require.Empty(t, value)
+	exists, err := r.Has(key)
+	require.Nil(t, err)
+	require.True(t, exists)
+
+	end := append(append([]byte{}, key...), 0)
+	iterators := []func() (dbm.Iterator, error){func() (dbm.Iterator, error) { return r.Iterator(key, end) }}
+	if db, ok := r.(interface {
+		ReverseIterator(start, end []byte) (dbm.Iterator, error)
+	}); ok {
+		iterators = append(iterators, func() (dbm.Iterator, error) { return db.ReverseIterator(key, end) })
+	}
+	for _, newIterator := range iterators {
+		iter, err := newIterator()
+		require.Nil(t, err)
+		require.True(t, iter.Valid())
+		require.Equal(t, key, iter.Key())
+		require.NotNil(t, iter.Value())
+		require.Empty(t, iter.Value())
+		iter.Next()
+		require.False(t, iter.Valid())
+		require.Nil(t, iter.Error())
+		require.Nil(t, iter.Close())
+	}
+}
+
+func requireMissing(t *testing.T, r reader, key []byte) {
+	value, err := r.Get(key)
+	require.Nil(t, err)
+	require.Nil(t, value)
+	exists, err := r.Has(key)
+	require.Nil(t, err)
+	require.False(t, exists)
+}
+
+// writeEmptyValues writes empty values directly and through a batch.
+func writeEmptyValues(t *testing.T, db dbm.DB) {
+	require.Nil(t, db.Set([]byte("marker"), []byte{}))
+	require.Nil(t, db.SetSync([]byte("marker-sync"), []byte{}))
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("marker-batch"), []byte{}))
+	require.Nil(t, batch.Write())
+	require.Nil(t, batch.Close())
+}
+
+func requireEmptyValues(t *testing.T, r reader) {
+	for _, key := range []string{"marker", "marker-sync", "marker-batch"} {
+		requireEmptyValue(t, r, []byte(key))
+	}
+	requireMissing(t, r, []byte("missing"))
+}
+
+func TestEmptyValues(t *testing.T) {
+	newGoLevelDB := func(t *testing.T) dbm.DB {
+		db, err := dbm.NewGoLevelDB("test", t.TempDir())
+		require.Nil(t, err)
+		return db
+	}
+	for name, newDB := range map[string]func(t *testing.T) dbm.DB{
+		"memdb":     func(*testing.T) dbm.DB { return dbm.NewMemDB() },
+		"goleveldb": newGoLevelDB,
+		"checksum":  func(*testing.T) dbm.DB { return NewChecksumDB(dbm.NewMemDB()) },
+		"checksum legacy": func(*testing.T) dbm.DB {
+			return NewChecksumDB(dbm.NewMemDB(), WithLegacyValues())
+		},
+		"metrics":    func(*testing.T) dbm.DB { return NewMetricsDB(dbm.NewMemDB(), MetricsOptions{}) },
+		"compressed": func(*testing.T) dbm.DB { return NewCompressedDB(dbm.NewMemDB(), SnappyCompressor, 0) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := newDB(t)
+			defer db.Close()
+			writeEmptyValues(t, db)
+			requireEmptyValues(t, db)
+		})
+	}
+}
+
+func TestEmptyValuesSnapshots(t *testing.T) {
+	levelDB, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer levelDB.Close()
+	for name, db := range map[string]SnapshottableDB{
+		"memdb":     NewMemDBSnapshotter(dbm.NewMemDB()),
+		"goleveldb": NewGoLevelDBSnapshotter(levelDB),
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeEmptyValues(t, db)
+			snapshot, err := db.Snapshot()
+			require.Nil(t, err)
+			defer snapshot.Close()
+			requireEmptyValues(t, snapshot)
+		})
+	}
+}
+
+func TestEmptyValuesImport(t *testing.T) {
+	db := dbm.NewMemDB()
+	export := `{"app_state": {"bank": [{"key": "` + b64("marker") + `", "value": ""}]}}`
+	_, err := ImportAppStateKV(context.Background(), strings.NewReader(export), db, StoreKeyMapping{"bank": {}})
+	require.Nil(t, err)
+	requireEmptyValue(t, db, []byte("marker"))
+}
+
+func TestEmptyValuesArweave(t *testing.T) {
+	index := mockIndex([]string{"z"}, []int{0})
+	txData := [][]byte{
+		mockTxData([]string{"marker", "ref"}, []string{"", EncodeValueRef(intToBase64Sha256(1))}),
+		nil,
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	WithValueReferences()(mockDB)
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	for _, key := range []string{"marker", "ref"} {
+		value, err := mockDB.Get(append(v0Bz, []byte(key)...))
+		require.Nil(t, err)
+		require.NotNil(t, value)
+		require.Empty(t, value)
+		exists, err := mockDB.Has(append(v0Bz, []byte(key)...))
+		require.Nil(t, err)
+		require.True(t, exists)
+	}
+	iter, err := mockDB.Iterator(append(v0Bz, []byte("a")...), append(v0Bz, []byte("z")...))
+	require.Nil(t, err)
+	count := 0
+	for ; iter.Valid(); iter.Next() {
+		require.NotNil(t, iter.Value())
+		require.Empty(t, iter.Value())
+		count++
+	}
+	require.Equal(t, 2, count)
+	require.Nil(t, iter.Error())
+}
+
+// requireInvalid checks that iter behaves as an invalid iterator.
+func requireInvalid(t *testing.T, iter dbm.Iterator) {
+	require.False(t, iter.Valid())
+	require.PanicsWithValue(t, InvalidIteratorMessage, func() { iter.Key() })
+	require.PanicsWithValue(t, InvalidIteratorMessage, func() { iter.Value() })
+	require.PanicsWithValue(t, InvalidIteratorMessage, func() { iter.Next() })
+}
+
+// requireIteratorContract checks the contract of invalid iterators on
+// iterators of a range holding keys, once exhausted and once closed.
+func requireIteratorContract(t *testing.T, keys int, newIterator func() (dbm.Iterator, error)) {
+	iter, err := newIterator()
+	require.Nil(t, err)
+	count := 0
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	require.Equal(t, keys, count)
+	requireInvalid(t, iter)
+	require.Nil(t, iter.Close())
+	requireInvalid(t, iter)
+
+	iter, err = newIterator()
+	require.Nil(t, err)
+	require.True(t, iter.Valid())
+	require.Nil(t, iter.Close())
+	requireInvalid(t, iter)
+	require.Nil(t, iter.Close())
+}
+
+func TestInvalidIterators(t *testing.T) {
+	newGoLevelDB := func(t *testing.T) dbm.DB {
+		db, err := dbm.NewGoLevelDB("test", t.TempDir())
+		require.Nil(t, err)
+		return db
+	}
+	backends := map[string]func(t *testing.T) dbm.DB{
+		"memdb":      func(*testing.T) dbm.DB { return dbm.NewMemDB() },
+		"goleveldb":  newGoLevelDB,
+		"checksum":   func(*testing.T) dbm.DB { return NewChecksumDB(dbm.NewMemDB()) },
+		"metrics":    func(*testing.T) dbm.DB { return NewMetricsDB(dbm.NewMemDB(), MetricsOptions{}) },
+		"compressed": func(*testing.T) dbm.DB { return NewCompressedDB(dbm.NewMemDB(), SnappyCompressor, 0) },
+		"reserved":   func(*testing.T) dbm.DB { return NewReservedGuardDB(dbm.NewMemDB()) },
+		"staged": func(*testing.T) dbm.DB {
+			db := dbm.NewMemDB()
+			return NewStagedView(db, NewDedupingBatch(db))
+		},
+	}
+	for name, newDB := range backends {
+		newDB := newDB
+		backends["prefixdb "+name] = func(t *testing.T) dbm.DB { return dbm.NewPrefixDB(newDB(t), []byte("p/")) }
+	}
+	for name, newDB := range backends {
+		t.Run(name, func(t *testing.T) {
+			db := newDB(t)
+			defer db.Close()
+			for _, key := range []string{"a", "b", "c"} {
+				require.Nil(t, db.Set([]byte(key), []byte("value")))
+			}
+			requireIteratorContract(t, 3, func() (dbm.Iterator, error) { return db.Iterator(nil, nil) })
+			requireIteratorContract(t, 3, func() (dbm.Iterator, error) { return db.ReverseIterator(nil, nil) })
+			requireIteratorContract(t, 1, func() (dbm.Iterator, error) { return db.Iterator([]byte("b"), []byte("c")) })
+			requireIteratorContract(t, 1, func() (dbm.Iterator, error) { return db.ReverseIterator([]byte("b"), []byte("c")) })
+		})
+	}
+}
+
+func TestInvalidIteratorsSnapshots(t *testing.T) {
+	levelDB, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer levelDB.Close()
+	for name, db := range map[string]SnapshottableDB{
+		"memdb":     NewMemDBSnapshotter(dbm.NewMemDB()),
+		"goleveldb": NewGoLevelDBSnapshotter(levelDB),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"a", "b", "c"} {
+				require.Nil(t, db.Set([]byte(key), []byte("value")))
+			}
+			snapshot, err := db.Snapshot()
+			require.Nil(t, err)
+			defer snapshot.Close()
+			requireIteratorContract(t, 3, func() (dbm.Iterator, error) { return snapshot.Iterator(nil, nil) })
+		})
+	}
+}
+
+func TestInvalidIteratorsArweave(t *testing.T) {
+	index := mockIndex([]string{"b", "z"}, []int{0, 1})
+	txData := [][]byte{
+		mockTxData([]string{"a", "b"}, []string{"1", "2"}),
+		mockTxData([]string{"c"}, []string{"3"}),
+	}
+	mockDB := NewMockArweaveDB([][]byte{index}, txData, []int{0, 1})
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	start, end := append(v0Bz, []byte("a")...), append(v0Bz, []byte("z")...)
+	requireIteratorContract(t, 3, func() (dbm.Iterator, error) { return mockDB.Iterator(start, end) })
+	requireIteratorContract(t, 3, func() (dbm.Iterator, error) { return mockDB.ReverseIterator(start, end) })
+}
@@ -0,0 +1,133 @@
+package backends
+
+import (
+	"errors"
+	"sort"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// The errors of tm-db batches, which aren't exported.
+var (
+	errBatchClosed = errors.New("batch has been written or closed")
+	errKeyEmpty    = errors.New("key cannot be empty")
+	errValueNil    = errors.New("value cannot be nil")
+)
+
+type batchOp struct {
+	key []byte
+	// nil for deletes
+	value []byte
+}
+
+// writeOps writes ops through a new batch of db.
+func writeOps(db dbm.DB, ops []batchOp, write func(dbm.Batch) error) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+	for _, op := range ops {
+		var err error
+		if op.value == nil {
+			err = batch.Delete(op.key)
+		} else {
+			err = batch.Set(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return write(batch)
+}
+
+// dedupingBatch keeps the last op of each key, in the order keys were first
+// touched.
+type dedupingBatch struct {
+	db    dbm.DB
+	ops   []batchOp
+	index map[string]int
+}
+
+var _ BatchInspector = (*dedupingBatch)(nil)
+
+// NewDedupingBatch returns a batch of db which only writes the last Set or
+// Delete of each key, for workloads setting the same keys many times before
+// writing. Writing it has the same effect as writing a plain batch of the
+// same ops.
+func NewDedupingBatch(db dbm.DB) BatchInspector {
+	return &dedupingBatch{db: db, ops: []batchOp{}, index: map[string]int{}}
+}
+
+func (b *dedupingBatch) add(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	if i, ok := b.index[string(key)]; ok {
+		b.ops[i].value = value
+		return nil
+	}
+	b.index[string(key)] = len(b.ops)
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+	return nil
+}
+
+// Set implements Batch.
+func (b *dedupingBatch) Set(key, value []byte) error {
+	if len(key) != 0 && value == nil {
+		return errValueNil
+	}
+	return b.add(key, value)
+}
+
+// Delete implements Batch.
+func (b *dedupingBatch) Delete(key []byte) error {
+	return b.add(key, nil)
+}
+
+// Write implements Batch.
+func (b *dedupingBatch) Write() error {
+	return b.write(dbm.Batch.Write)
+}
+
+// WriteSync implements Batch.
+func (b *dedupingBatch) WriteSync() error {
+	return b.write(dbm.Batch.WriteSync)
+}
+
+func (b *dedupingBatch) write(write func(dbm.Batch) error) error {
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	if err := writeOps(b.db, b.ops, write); err != nil {
+		return err
+	}
+	return b.Close()
+}
+
+// Pending implements BatchInspector.
+func (b *dedupingBatch) Pending(key []byte) ([]byte, bool) {
+	i, ok := b.index[string(key)]
+	if !ok {
+		return nil, false
+	}
+	return b.ops[i].value, true
+}
+
+// ForEach implements BatchInspector.
+func (b *dedupingBatch) ForEach(fn func(key, value []byte) error) error {
+	ops := append([]batchOp{}, b.ops...)
+	sort.Slice(ops, func(i, j int) bool { return string(ops[i].key) < string(ops[j].key) })
+	for _, op := range ops {
+		if err := fn(op.key, op.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements Batch.
+func (b *dedupingBatch) Close() error {
+	b.ops, b.index = nil, nil
+	return nil
+}
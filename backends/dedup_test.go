@@ -0,0 +1,151 @@
+package backends
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// opCountingDB counts the ops added to its batches.
+type opCountingDB struct {
+	dbm.DB
+	ops int
+}
+
+func (db *opCountingDB) NewBatch() dbm.Batch {
+	return &opCountingBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type opCountingBatch struct {
+	dbm.Batch
+	db *opCountingDB
+}
+
+func (b *opCountingBatch) Set(key, value []byte) error {
+	b.db.ops++
+	return b.Batch.Set(key, value)
+}
+
+func (b *opCountingBatch) Delete(key []byte) error {
+	b.db.ops++
+	return b.Batch.Delete(key)
+}
+
+// applyRandomOps adds n random Sets and Deletes of keys keys to each batch.
+func applyRandomOps(t require.TestingT, r *rand.Rand, n, keys int, batches ...dbm.Batch) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%d", r.Intn(keys)))
+		if r.Intn(4) == 0 {
+			for _, batch := range batches {
+				require.Nil(t, batch.Delete(key))
+			}
+			continue
+		}
+		value := []byte(fmt.Sprintf("value%d", i))
+		for _, batch := range batches {
+			require.Nil(t, batch.Set(key, value))
+		}
+	}
+}
+
+func TestDedupingBatchMatchesPlainBatch(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		expected, actual := dbm.NewMemDB(), &opCountingDB{DB: dbm.NewMemDB()}
+		// keys present before the batch may be deleted by it
+		for i := 0; i < 10; i++ {
+			key, value := []byte(fmt.Sprintf("key%d", r.Intn(20))), []byte("initial")
+			require.Nil(t, expected.Set(key, value))
+			require.Nil(t, actual.Set(key, value))
+		}
+		plain, deduping := expected.NewBatch(), NewDedupingBatch(actual)
+		applyRandomOps(t, r, r.Intn(200), 1+r.Intn(20), plain, deduping)
+		require.Nil(t, plain.Write())
+		require.Nil(t, deduping.Write())
+
+		requireIdentical(t, expected, actual)
+		require.LessOrEqual(t, actual.ops, 20)
+	}
+}
+
+func TestDedupingBatchOrder(t *testing.T) {
+	db := &opCountingDB{DB: dbm.NewMemDB()}
+	var order []string
+	batch := NewDedupingBatch(recordingBatchDB{DB: db, order: &order})
+	for _, key := range []string{"b", "a", "b", "c", "a"} {
+		require.Nil(t, batch.Set([]byte(key), []byte(key)))
+	}
+	require.Nil(t, batch.Delete([]byte("c")))
+	require.Nil(t, batch.WriteSync())
+	require.Equal(t, []string{"set b", "set a", "delete c"}, order)
+	require.Equal(t, 3, db.ops)
+}
+
+type recordingBatchDB struct {
+	dbm.DB
+	order *[]string
+}
+
+func (db recordingBatchDB) NewBatch() dbm.Batch {
+	return recordingBatch{Batch: db.DB.NewBatch(), order: db.order}
+}
+
+type recordingBatch struct {
+	dbm.Batch
+	order *[]string
+}
+
+func (b recordingBatch) Set(key, value []byte) error {
+	*b.order = append(*b.order, "set "+string(key))
+	return b.Batch.Set(key, value)
+}
+
+func (b recordingBatch) Delete(key []byte) error {
+	*b.order = append(*b.order, "delete "+string(key))
+	return b.Batch.Delete(key)
+}
+
+func TestDedupingBatchErrors(t *testing.T) {
+	db := dbm.NewMemDB()
+	plain, deduping := db.NewBatch(), NewDedupingBatch(db)
+	for _, batch := range []dbm.Batch{plain, deduping} {
+		require.Equal(t, errKeyEmpty.Error(), batch.Set(nil, []byte("v")).Error())
+		require.Equal(t, errKeyEmpty.Error(), batch.Delete([]byte{}).Error())
+		require.Equal(t, errValueNil.Error(), batch.Set([]byte("k"), nil).Error())
+		require.Nil(t, batch.Set([]byte("k"), []byte("v")))
+		require.Nil(t, batch.Write())
+		require.Equal(t, errBatchClosed.Error(), batch.Set([]byte("k"), []byte("v")).Error())
+		require.Equal(t, errBatchClosed.Error(), batch.Write().Error())
+		require.Nil(t, batch.Close())
+	}
+}
+
+func benchmarkRetouchedBatch(b *testing.B, deduping bool) {
+	db := &opCountingDB{DB: dbm.NewMemDB()}
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var batch dbm.Batch
+		if deduping {
+			batch = NewDedupingBatch(db)
+		} else {
+			batch = db.NewBatch()
+		}
+		// every key is touched 10 times on average
+		applyRandomOps(b, r, 1000, 100, batch)
+		require.Nil(b, batch.Write())
+		require.Nil(b, batch.Close())
+	}
+	b.ReportMetric(float64(db.ops)/float64(b.N), "backend-ops/op")
+}
+
+func BenchmarkRetouchedPlainBatch(b *testing.B) {
+	benchmarkRetouchedBatch(b, false)
+}
+
+func BenchmarkRetouchedDedupingBatch(b *testing.B) {
+	benchmarkRetouchedBatch(b, true)
+}
@@ -0,0 +1,83 @@
+package backends
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Dump writes the content of db to w, one JSON object per line holding the
+// base64 encoded key and value of an entry, in the format of the entries
+// read by ImportAppStateKV. The reserved namespace is left out unless
+// IncludeReserved is passed.
+func Dump(db dbm.DB, w io.Writer, opts ...IterateOption) error {
+	cfg := iterateConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.parallelism > 1 {
+		return dumpShards(db, w, cfg.parallelism, opts)
+	}
+	return dumpRange(db, nil, nil, w, opts)
+}
+
+// dumpShards dumps the shards of db concurrently, writing them to w in
+// order.
+func dumpShards(db dbm.DB, w io.Writer, workers int, opts []IterateOption) error {
+	points, err := SampleSplitPoints(context.Background(), db, nil, nil, workers)
+	if err != nil {
+		return err
+	}
+	ranges := splitRanges(nil, nil, points)
+	type dumpedShard struct {
+		buf bytes.Buffer
+		err error
+	}
+	shards := make([]chan *dumpedShard, len(ranges))
+	for i, bounds := range ranges {
+		shards[i] = make(chan *dumpedShard, 1)
+		go func(bounds [2][]byte, done chan<- *dumpedShard) {
+			shard := &dumpedShard{}
+			shard.err = dumpRange(db, bounds[0], bounds[1], &shard.buf, opts)
+			done <- shard
+		}(bounds, shards[i])
+	}
+	for _, done := range shards {
+		shard := <-done
+		if shard.err != nil {
+			return shard.err
+		}
+		if _, err := shard.buf.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpRange(db dbm.DB, start, end []byte, w io.Writer, opts []IterateOption) error {
+	iter, err := Iterate(db, start, end, opts...)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for ; iter.Valid(); iter.Next() {
+		kv := exportedKV{
+			Key:   base64.StdEncoding.EncodeToString(iter.Key()),
+			Value: base64.StdEncoding.EncodeToString(iter.Value()),
+		}
+		if err := enc.Encode(kv); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
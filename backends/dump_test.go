@@ -0,0 +1,61 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestDump(t *testing.T) {
+	db := dbm.NewMemDB()
+	require.Nil(t, db.Set([]byte("a"), []byte("v1")))
+	require.Nil(t, db.Set([]byte("b"), []byte("v2")))
+	require.Nil(t, WriteFormatMarker(db, FormatMarker{Format: "app", Version: 1}))
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, Dump(db, buf))
+	expected := fmt.Sprintf("{\"key\":\"%s\",\"value\":\"%s\"}\n{\"key\":\"%s\",\"value\":\"%s\"}\n", b64("a"), b64("v1"), b64("b"), b64("v2"))
+	require.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	require.Nil(t, Dump(db, buf, IncludeReserved()))
+	require.Equal(t, 3, strings.Count(buf.String(), "\n"))
+}
+
+func TestDumpImportRoundTrip(t *testing.T) {
+	db := dbm.NewMemDB()
+	populate(t, db, 10)
+	require.Nil(t, WriteFormatMarker(db, FormatMarker{Format: "app", Version: 1}))
+	buf := &bytes.Buffer{}
+	require.Nil(t, Dump(db, buf, IncludeReserved()))
+
+	export := fmt.Sprintf(`{"app_state": {"store": [%s]}}`, strings.Join(strings.Fields(buf.String()), ","))
+	imported := dbm.NewMemDB()
+	report, err := ImportAppStateKV(context.Background(), strings.NewReader(export), imported, StoreKeyMapping{"store": nil})
+	require.Nil(t, err)
+	require.Equal(t, 10, report.KeysByStore["store"])
+	// metadata isn't imported
+	require.Equal(t, 1, report.MalformedCount)
+	require.Equal(t, "key in the reserved namespace", report.MalformedEntries[0].Reason)
+	requireIdentical(t, db, imported)
+}
+
+func TestDumpInParallel(t *testing.T) {
+	db := dbm.NewMemDB()
+	populate(t, db, 1000)
+	require.Nil(t, WriteFormatMarker(db, FormatMarker{Format: "app", Version: 1}))
+	for _, opts := range [][]IterateOption{nil, {IncludeReserved()}} {
+		expected := &bytes.Buffer{}
+		require.Nil(t, Dump(db, expected, opts...))
+		for _, workers := range []int{2, 7} {
+			actual := &bytes.Buffer{}
+			require.Nil(t, Dump(db, actual, append(opts, Parallelism(workers))...))
+			require.Equal(t, expected.String(), actual.String())
+		}
+	}
+}
@@ -1,11 +1,538 @@
 package backends
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound matches every ErrKeyNotFound with errors.Is, whatever its
+// key and context.
+var ErrNotFound = errors.New("not found")
+
+// ErrDBClosed is returned by the reads of a closed ArweaveDB, including
+// those in flight when it was closed.
+var ErrDBClosed = errors.New("DB is closed")
 
 type ErrKeyNotFound struct {
 	key string
+	// version the key was searched in, or whose payload is missing, unset
+	// if reported missing by a getter, e.g. for a version which was never
+	// archived
+	version    uint64
+	hasVersion bool
+	// payloads searched, or missing
+	txIds []string
+}
+
+// NewErrKeyNotFound returns the error getters report missing keys, such as
+// transactions or versions, with.
+func NewErrKeyNotFound(key []byte) *ErrKeyNotFound {
+	return &ErrKeyNotFound{key: string(key)}
+}
+
+// newKeyNotFoundInVersion returns the error of key missing from version,
+// after searching the payloads of entries.
+func newKeyNotFoundInVersion(key []byte, version uint64, entries []IndexEntry) *ErrKeyNotFound {
+	txIds := make([]string, len(entries))
+	for i, entry := range entries {
+		txIds[i] = string(entry.txId)
+	}
+	return &ErrKeyNotFound{key: string(key), version: version, hasVersion: true, txIds: txIds}
 }
 
 func (e *ErrKeyNotFound) Error() string {
-	return fmt.Sprintf("Key %s not found", e.key)
+	if !e.hasVersion {
+		return fmt.Sprintf("Key %s not found", e.key)
+	}
+	if len(e.txIds) == 0 {
+		return fmt.Sprintf("Key %s not found in version %d", e.key, e.version)
+	}
+	return fmt.Sprintf("Key %s not found in version %d, in payloads %s", e.key, e.version, strings.Join(e.txIds, ", "))
+}
+
+// Key returns the missing key.
+func (e *ErrKeyNotFound) Key() []byte {
+	return []byte(e.key)
+}
+
+// Version returns the version the key is missing from, or whose payload
+// the key is, and false if the key was reported missing by a getter without
+// more context, as versions never archived are.
+func (e *ErrKeyNotFound) Version() (uint64, bool) {
+	return e.version, e.hasVersion
+}
+
+// TxIds returns the IDs of the payloads which were searched for the key, or
+// the ID of the payload missing.
+func (e *ErrKeyNotFound) TxIds() []string {
+	return e.txIds
+}
+
+// Is makes ErrKeyNotFound match ErrNotFound.
+func (e *ErrKeyNotFound) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+type ErrValueRefNotResolved struct {
+	txId string
+	err  error
+}
+
+func (e *ErrValueRefNotResolved) Error() string {
+	return fmt.Sprintf("Referenced tx %s could not be fetched: %s", e.txId, e.err)
+}
+
+func (e *ErrValueRefNotResolved) Unwrap() error {
+	return e.err
+}
+
+type ErrUntrustedRetractions struct {
+	owner string
+	err   error
+}
+
+func (e *ErrUntrustedRetractions) Error() string {
+	return fmt.Sprintf("Retractions published by untrusted owner %s: %s", e.owner, e.err)
+}
+
+func (e *ErrUntrustedRetractions) Unwrap() error {
+	return e.err
+}
+
+type ErrValueCorrupted struct {
+	key string
+}
+
+func (e *ErrValueCorrupted) Error() string {
+	return fmt.Sprintf("Value of key %s is corrupted", e.key)
+}
+
+type ErrUndecompressableValue struct {
+	key    string
+	reason string
+}
+
+func (e *ErrUndecompressableValue) Error() string {
+	return fmt.Sprintf("Value of key %s cannot be decompressed: %s", e.key, e.reason)
+}
+
+type ErrRequestDecoration struct {
+	url     string
+	traceID string
+	err     error
+}
+
+func (e *ErrRequestDecoration) Error() string {
+	if e.traceID != "" {
+		return fmt.Sprintf("Request to %s (trace %s) could not be decorated: %s", e.url, e.traceID, e.err)
+	}
+	return fmt.Sprintf("Request to %s could not be decorated: %s", e.url, e.err)
+}
+
+// TraceID returns the trace ID of the request, if any.
+func (e *ErrRequestDecoration) TraceID() string {
+	return e.traceID
+}
+
+func (e *ErrRequestDecoration) Unwrap() error {
+	return e.err
+}
+
+type ErrTraced struct {
+	traceID string
+	op      string
+	err     error
+}
+
+func (e *ErrTraced) Error() string {
+	return fmt.Sprintf("Operation %s (trace %s) failed: %s", e.op, e.traceID, e.err)
+}
+
+func (e *ErrTraced) Unwrap() error {
+	return e.err
+}
+
+// TraceID returns the trace ID of the failed operation.
+func (e *ErrTraced) TraceID() string {
+	return e.traceID
+}
+
+type ErrFormatMismatch struct {
+	expected FormatMarker
+	// nil if the stored marker couldn't be read
+	found *FormatMarker
+	err   error
+}
+
+func (e *ErrFormatMismatch) Error() string {
+	if e.found == nil {
+		return fmt.Sprintf("Format marker unreadable, expected %s: %s", e.expected, e.err)
+	}
+	return fmt.Sprintf("Format mismatch: expected %s, found %s", e.expected, *e.found)
+}
+
+func (e *ErrFormatMismatch) Unwrap() error {
+	return e.err
+}
+
+type ErrBudgetExhausted struct {
+	resetAt time.Time
+}
+
+func (e *ErrBudgetExhausted) Error() string {
+	return fmt.Sprintf("Download budget exhausted until %s", e.resetAt.UTC().Format(time.RFC3339))
+}
+
+// ResetAt returns when fetches will be admitted again, barring other
+// downloads.
+func (e *ErrBudgetExhausted) ResetAt() time.Time {
+	return e.resetAt
+}
+
+type ErrSubspaceReserved struct {
+	name string
+}
+
+func (e *ErrSubspaceReserved) Error() string {
+	return fmt.Sprintf("Subspace %s of the reserved namespace is already reserved", e.name)
+}
+
+type ErrReservedKey struct {
+	key []byte
+}
+
+func (e *ErrReservedKey) Error() string {
+	return fmt.Sprintf("Key %X belongs to the reserved namespace", e.key)
+}
+
+type ErrDuplicateKey struct {
+	key   string
+	txIds []string
+}
+
+func (e *ErrDuplicateKey) Error() string {
+	return fmt.Sprintf("Key %s is present in several payloads: %s", e.key, strings.Join(e.txIds, ", "))
+}
+
+type ErrUndecodableValue struct {
+	key    string
+	txId   string
+	reason string
+}
+
+func (e *ErrUndecodableValue) Error() string {
+	return fmt.Sprintf("Value of key %s in payload %s is undecodable: %s", e.key, e.txId, e.reason)
+}
+
+type ErrLegacyIndex struct {
+	version uint64
+}
+
+func (e *ErrLegacyIndex) Error() string {
+	return fmt.Sprintf("Index of version %d is a legacy headerless one", e.version)
+}
+
+type ErrMalformedIndex struct {
+	offset int
+	reason string
+}
+
+func (e *ErrMalformedIndex) Error() string {
+	return fmt.Sprintf("Malformed index entry at offset %d: %s", e.offset, e.reason)
+}
+
+// Offset returns the byte offset of the malformed entry in the index.
+func (e *ErrMalformedIndex) Offset() int {
+	return e.offset
+}
+
+type ErrUndeclaredCodec struct {
+	txId  string
+	codec PayloadCodec
+}
+
+func (e *ErrUndeclaredCodec) Error() string {
+	return fmt.Sprintf("Codec of payload %s is undeclared or unknown: %d", e.txId, e.codec)
+}
+
+type ErrStaleCache struct {
+	version uint64
+	cached  string
+	current string
+}
+
+func (e *ErrStaleCache) Error() string {
+	return fmt.Sprintf("Cached index tx ID %s of version %d is stale, now %s", e.cached, e.version, e.current)
+}
+
+type ErrInvalidAttestation struct {
+	reason string
+}
+
+func (e *ErrInvalidAttestation) Error() string {
+	return fmt.Sprintf("Invalid attestation: %s", e.reason)
+}
+
+type ErrACLViolation struct {
+	op  string
+	key []byte
+	// prefix of the rule denying the operation, unless denied by default
+	rule    []byte
+	hasRule bool
+}
+
+func (e *ErrACLViolation) Error() string {
+	if !e.hasRule {
+		return fmt.Sprintf("ACL denies %s of key %X by default", e.op, e.key)
+	}
+	return fmt.Sprintf("ACL denies %s of key %X by rule on prefix %X", e.op, e.key, e.rule)
+}
+
+// Op returns the denied operation.
+func (e *ErrACLViolation) Op() string {
+	return e.op
+}
+
+// Rule returns the prefix of the rule denying the operation, and false if
+// it was denied by default.
+func (e *ErrACLViolation) Rule() ([]byte, bool) {
+	return e.rule, e.hasRule
+}
+
+type ErrGatewayStatus struct {
+	what       string
+	statusCode int
+}
+
+func (e *ErrGatewayStatus) Error() string {
+	return fmt.Sprintf("%s: gateway responded with status %d", e.what, e.statusCode)
+}
+
+// StatusCode returns the HTTP status the gateway responded with.
+func (e *ErrGatewayStatus) StatusCode() int {
+	return e.statusCode
+}
+
+// Transient implements TransientError. Rate limited requests and server
+// errors may succeed if sent again.
+func (e *ErrGatewayStatus) Transient() bool {
+	return e.statusCode == 429 || e.statusCode >= 500
+}
+
+type ErrReplayMismatch struct {
+	seq      int
+	expected *ReplayEntry
+	getter   string
+	key      []byte
+}
+
+func (e *ErrReplayMismatch) Error() string {
+	actual := fmt.Sprintf("%s %X", e.getter, e.key)
+	if e.expected == nil {
+		return fmt.Sprintf("Unexpected request %d past the end of the replay: got %s", e.seq, actual)
+	}
+	return fmt.Sprintf("Replay mismatch at request %d: expected %s %X, got %s", e.seq, e.expected.Getter, e.expected.Key, actual)
+}
+
+// Expected returns the recorded request, and false if the replay was
+// exhausted.
+func (e *ErrReplayMismatch) Expected() (ReplayEntry, bool) {
+	if e.expected == nil {
+		return ReplayEntry{}, false
+	}
+	return *e.expected, true
+}
+
+type ErrUntrustedCheckpoint struct {
+	reason string
+}
+
+func (e *ErrUntrustedCheckpoint) Error() string {
+	return fmt.Sprintf("Untrusted checkpoint: %s", e.reason)
+}
+
+type ErrStaleCheckpoint struct {
+	height    uint64
+	watermark uint64
+}
+
+func (e *ErrStaleCheckpoint) Error() string {
+	return fmt.Sprintf("Checkpoint at height %d is older than watermark %d", e.height, e.watermark)
+}
+
+type ErrNoVersions struct{}
+
+func (e *ErrNoVersions) Error() string {
+	return "No version is archived"
+}
+
+type ErrUndecodableStoreValue struct {
+	key []byte
+	err error
+}
+
+func (e *ErrUndecodableStoreValue) Error() string {
+	return fmt.Sprintf("Value of key %X is undecodable: %s", e.key, e.err)
+}
+
+// Key returns the key of the value within its store.
+func (e *ErrUndecodableStoreValue) Key() []byte {
+	return e.key
+}
+
+func (e *ErrUndecodableStoreValue) Unwrap() error {
+	return e.err
+}
+
+type ErrVersionNotYetArchived struct {
+	requested uint64
+	latest    uint64
+}
+
+func (e *ErrVersionNotYetArchived) Error() string {
+	return fmt.Sprintf("Version %d is not archived yet, the latest archived version is %d", e.requested, e.latest)
+}
+
+// Requested returns the version which isn't archived yet.
+func (e *ErrVersionNotYetArchived) Requested() uint64 {
+	return e.requested
+}
+
+// Latest returns the latest archived version known when the request failed.
+func (e *ErrVersionNotYetArchived) Latest() uint64 {
+	return e.latest
+}
+
+// Transient implements TransientError. The version is likely to be
+// archived shortly.
+func (e *ErrVersionNotYetArchived) Transient() bool {
+	return true
+}
+
+type ErrCorruptCompression struct {
+	what   string
+	format string
+	err    error
+}
+
+func (e *ErrCorruptCompression) Error() string {
+	return fmt.Sprintf("%s is corrupt %s data: %s", e.what, e.format, e.err)
+}
+
+// Format returns the compression format the data was detected as.
+func (e *ErrCorruptCompression) Format() string {
+	return e.format
+}
+
+func (e *ErrCorruptCompression) Unwrap() error {
+	return e.err
+}
+
+type ErrIntegrityCheckFailed struct {
+	txId     string
+	expected []byte
+	actual   []byte
+}
+
+func (e *ErrIntegrityCheckFailed) Error() string {
+	return fmt.Sprintf("Data of transaction %s has sha256 digest %X instead of %X", e.txId, e.actual, e.expected)
+}
+
+// TxId returns the ID of the transaction whose data failed the check.
+func (e *ErrIntegrityCheckFailed) TxId() string {
+	return e.txId
+}
+
+// Digests returns the expected sha256 digest of the data and that of the
+// data fetched.
+func (e *ErrIntegrityCheckFailed) Digests() (expected, actual []byte) {
+	return e.expected, e.actual
+}
+
+// Transient implements TransientError. Gateways may serve the data intact
+// when asked again.
+func (e *ErrIntegrityCheckFailed) Transient() bool {
+	return true
+}
+
+type ErrUntrustedIndex struct {
+	txId   string
+	owner  string
+	reason string
+}
+
+func (e *ErrUntrustedIndex) Error() string {
+	if e.owner == "" {
+		return fmt.Sprintf("Index %s is untrusted: %s", e.txId, e.reason)
+	}
+	return fmt.Sprintf("Index %s owned by %s is untrusted: %s", e.txId, e.owner, e.reason)
+}
+
+// TxId returns the transaction ID of the untrusted index.
+func (e *ErrUntrustedIndex) TxId() string {
+	return e.txId
+}
+
+// Owner returns the owner of the index as served by the last source of
+// transaction metadata, if any.
+func (e *ErrUntrustedIndex) Owner() string {
+	return e.owner
+}
+
+type ErrPreferenceUnsatisfiable struct {
+	op         string
+	preference ReadPreference
+	err        error
+}
+
+func (e *ErrPreferenceUnsatisfiable) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("Preference %s cannot serve %s: no tier holds the key", e.preference, e.op)
+	}
+	return fmt.Sprintf("Preference %s cannot serve %s: %s", e.preference, e.op, e.err)
+}
+
+// Preference returns the read preference which couldn't be satisfied.
+func (e *ErrPreferenceUnsatisfiable) Preference() ReadPreference {
+	return e.preference
+}
+
+// Unwrap returns the error of the last tier read, nil if the tiers read
+// missed the key.
+func (e *ErrPreferenceUnsatisfiable) Unwrap() error {
+	return e.err
+}
+
+type ErrStackShutdown struct {
+	names []string
+	errs  []error
+}
+
+func (e *ErrStackShutdown) add(name string, err error) {
+	if err != nil {
+		e.names = append(e.names, name)
+		e.errs = append(e.errs, err)
+	}
+}
+
+func (e *ErrStackShutdown) Error() string {
+	failures := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		failures[i] = fmt.Sprintf("%s: %s", e.names[i], err)
+	}
+	return fmt.Sprintf("Stack shut down with %d failures: %s", len(e.errs), strings.Join(failures, "; "))
+}
+
+// Failures returns the names of the layers and tasks which failed to shut
+// down, in shutdown order, along with their errors.
+func (e *ErrStackShutdown) Failures() (names []string, errs []error) {
+	return e.names, e.errs
+}
+
+// Unwrap returns the error of the first layer or task which failed to shut
+// down.
+func (e *ErrStackShutdown) Unwrap() error {
+	return e.errs[0]
 }
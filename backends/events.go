@@ -0,0 +1,140 @@
+package backends
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+type EventKind string
+
+const (
+	EventOpened             EventKind = "opened"
+	EventClosed             EventKind = "closed"
+	EventCompactionStarted  EventKind = "compaction_started"
+	EventCompactionFinished EventKind = "compaction_finished"
+	EventCorruptionDetected EventKind = "corruption_detected"
+	EventMigrationProgress  EventKind = "migration_progress"
+	EventThrottleChanged    EventKind = "throttle_changed"
+	EventBacklogWarning     EventKind = "backlog_warning"
+	EventBacklogCritical    EventKind = "backlog_critical"
+	EventBacklogRecovered   EventKind = "backlog_recovered"
+)
+
+// DefaultEventQueueSize is the number of events queued per subscriber of
+// the default event bus before further events are dropped.
+const DefaultEventQueueSize = 1024
+
+// Event is a storage lifecycle event.
+type Event struct {
+	Kind    EventKind
+	Backend string
+	// location of the backend on disk, if any
+	Path   string
+	Time   time.Time
+	Detail string
+}
+
+// EventBus delivers events to subscribers without ever blocking the
+// emitter: each subscriber has a bounded queue, and events emitted while it
+// is full are dropped and counted.
+type EventBus struct {
+	mtx       sync.RWMutex
+	queueSize int
+	subs      map[*Subscription]struct{}
+}
+
+// Subscription is a subscriber of an EventBus. Events are delivered to it in
+// the order they were emitted, from a dedicated goroutine.
+type Subscription struct {
+	bus     *EventBus
+	events  chan Event
+	done    chan struct{}
+	dropped uint64
+	once    sync.Once
+}
+
+var defaultEventBus = NewEventBus(DefaultEventQueueSize)
+
+func NewEventBus(queueSize int) *EventBus {
+	return &EventBus{queueSize: queueSize, subs: map[*Subscription]struct{}{}}
+}
+
+// Subscribe subscribes fn to the events emitted by the backends and
+// wrappers of this package.
+func Subscribe(fn func(Event)) *Subscription {
+	return defaultEventBus.Subscribe(fn)
+}
+
+func (b *EventBus) Subscribe(fn func(Event)) *Subscription {
+	sub := &Subscription{
+		bus:    b,
+		events: make(chan Event, b.queueSize),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(sub.done)
+		for event := range sub.events {
+			fn(event)
+		}
+	}()
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Emit queues event for delivery to every subscriber.
+func (b *EventBus) Emit(event Event) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	for sub := range b.subs {
+		select {
+		case sub.events <- event:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Unsubscribe stops the delivery of new events and waits for the queued ones
+// to be delivered. It must not be called from the subscriber itself.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.bus.mtx.Lock()
+		delete(s.bus.subs, s)
+		close(s.events)
+		s.bus.mtx.Unlock()
+	})
+	<-s.done
+}
+
+// Dropped returns the number of events dropped because the subscriber's
+// queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func emitEvent(kind EventKind, backend, path, detail string) {
+	defaultEventBus.Emit(Event{Kind: kind, Backend: backend, Path: path, Time: time.Now(), Detail: detail})
+}
+
+func backendName(db interface{}) string {
+	switch db := db.(type) {
+	case *dbm.MemDB:
+		return "memdb"
+	case *dbm.GoLevelDB:
+		return "goleveldb"
+	case *ArweaveDB:
+		return "arweave"
+	case *ChecksumDB:
+		return "checksum"
+	case *MetricsDB:
+		return db.opts.Backend
+	default:
+		return fmt.Sprintf("%T", db)
+	}
+}
@@ -0,0 +1,87 @@
+package backends
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestLifecycleEvents(t *testing.T) {
+	mtx := sync.Mutex{}
+	events := []Event{}
+	sub := Subscribe(func(event Event) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		events = append(events, event)
+	})
+
+	indexPath := filepath.Join(t.TempDir(), "index")
+	arweaveDB, err := NewArweaveDB(indexPath, "http://localhost")
+	require.Nil(t, err)
+
+	levelDB, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer levelDB.Close()
+	require.Nil(t, NewCompactionScheduler(levelDB, nil, nil).Compact(nil, []byte{0xff}))
+
+	underlying := dbm.NewMemDB()
+	checksumDB := NewChecksumDB(underlying)
+	require.Nil(t, checksumDB.Set([]byte("a"), []byte("v")))
+	flipStoredByte(t, underlying, []byte("a"), 0)
+	_, err = checksumDB.Get([]byte("a"))
+	require.NotNil(t, err)
+
+	require.Nil(t, arweaveDB.Close())
+	sub.Unsubscribe()
+
+	kinds := []EventKind{}
+	for _, event := range events {
+		kinds = append(kinds, event.Kind)
+		require.False(t, event.Time.IsZero())
+	}
+	require.Equal(t, []EventKind{
+		EventOpened, EventCompactionStarted, EventCompactionFinished, EventCorruptionDetected, EventClosed,
+	}, kinds)
+	require.Equal(t, Event{Kind: EventOpened, Backend: "arweave", Path: indexPath, Time: events[0].Time}, events[0])
+	require.Equal(t, "goleveldb", events[1].Backend)
+	require.Equal(t, "[, FF)", events[2].Detail)
+	require.Equal(t, "memdb", events[3].Backend)
+	require.Equal(t, "checksum mismatch for key 61", events[3].Detail)
+	require.Equal(t, indexPath, events[4].Path)
+	require.Equal(t, uint64(0), sub.Dropped())
+
+	// no delivery after unsubscribing
+	emitEvent(EventOpened, "test", "", "")
+	require.Equal(t, 5, len(events))
+}
+
+func TestEventBusDrops(t *testing.T) {
+	bus := NewEventBus(2)
+	entered, release := make(chan struct{}), make(chan struct{})
+	details := []string{}
+	sub := bus.Subscribe(func(event Event) {
+		if event.Detail == "0" {
+			close(entered)
+			<-release
+		}
+		details = append(details, event.Detail)
+	})
+	bus.Emit(Event{Detail: "0"})
+	<-entered
+	// two are queued, the others are dropped
+	for _, detail := range []string{"1", "2", "3", "4"} {
+		bus.Emit(Event{Detail: detail})
+	}
+	require.Equal(t, uint64(2), sub.Dropped())
+	close(release)
+	sub.Unsubscribe()
+	require.Equal(t, []string{"0", "1", "2"}, details)
+
+	// unsubscribing twice is harmless
+	sub.Unsubscribe()
+	bus.Emit(Event{Detail: "5"})
+	require.Equal(t, uint64(2), sub.Dropped())
+}
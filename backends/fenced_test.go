@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestFencedDB(t *testing.T) {
+	height := uint64(10)
+	fence := NewHeightFence(func() uint64 { return atomic.LoadUint64(&height) })
+	mem := dbm.NewMemDB()
+	db := NewFencedDB(mem, fence.Tripped)
+
+	require.Nil(t, db.Set([]byte("a"), []byte("1")))
+	fence.SetFenceHeight(10)
+	require.Equal(t, uint64(10), fence.FenceHeight())
+	// writes at the fence height still go through
+	require.Nil(t, db.SetSync([]byte("b"), []byte("2")))
+
+	atomic.StoreUint64(&height, 11)
+	require.Equal(t, ErrWriteFenced, db.Set([]byte("c"), []byte("3")))
+	require.Equal(t, ErrWriteFenced, db.SetSync([]byte("c"), []byte("3")))
+	require.Equal(t, ErrWriteFenced, db.Delete([]byte("a")))
+	require.Equal(t, ErrWriteFenced, db.DeleteSync([]byte("a")))
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("c"), []byte("3")))
+	require.Equal(t, ErrWriteFenced, batch.Write())
+	require.Equal(t, ErrWriteFenced, batch.WriteSync())
+	require.Nil(t, batch.Close())
+
+	// reads are unaffected
+	value, err := db.Get([]byte("a"))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+	iter, err := db.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []KVPair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+	}, collectPairs(t, iter))
+	require.Nil(t, iter.Close())
+
+	fence.SetFenceHeight(0)
+	require.Nil(t, db.Delete([]byte("a")))
+	has, err := mem.Has([]byte("a"))
+	require.Nil(t, err)
+	require.False(t, has)
+}
+
+func TestFencedDBTripsBeforeBatchWrite(t *testing.T) {
+	tripped := int32(0)
+	mem := dbm.NewMemDB()
+	require.Nil(t, mem.Set([]byte("a"), []byte("1")))
+	db := NewFencedDB(mem, func() bool { return atomic.LoadInt32(&tripped) == 1 })
+
+	// staging isn't fenced, writing is
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("b"), []byte("2")))
+	require.Nil(t, batch.Delete([]byte("a")))
+	atomic.StoreInt32(&tripped, 1)
+	require.Equal(t, ErrWriteFenced, batch.Write())
+	require.Nil(t, batch.Close())
+
+	iter, err := mem.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []KVPair{{Key: []byte("a"), Value: []byte("1")}}, collectPairs(t, iter))
+	require.Nil(t, iter.Close())
+
+	atomic.StoreInt32(&tripped, 0)
+	batch = db.NewBatch()
+	require.Nil(t, batch.Set([]byte("b"), []byte("2")))
+	require.Nil(t, batch.WriteSync())
+	value, err := mem.Get([]byte("b"))
+	require.Nil(t, err)
+	require.Equal(t, "2", string(value))
+}
@@ -0,0 +1,193 @@
+package backends
+
+import (
+	"bytes"
+	"errors"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// KeyPattern is a key predicate made of plain data rather than a closure,
+// so that it can be evaluated by remote stores. Its zero value matches
+// every key.
+type KeyPattern struct {
+	// Prefix restricts keys to those starting with it, narrowing the range
+	// iterated over.
+	Prefix []byte `json:"prefix,omitempty"`
+	// Mask and Value restrict keys to those whose bytes from Offset on,
+	// masked with Mask, equal Value. Keys too short to cover the mask
+	// don't match.
+	Offset int    `json:"offset,omitempty"`
+	Mask   []byte `json:"mask,omitempty"`
+	Value  []byte `json:"value,omitempty"`
+}
+
+// Validate checks that the mask and value of p are consistent.
+func (p KeyPattern) Validate() error {
+	if len(p.Mask) != len(p.Value) {
+		return errors.New("key pattern mask and value must be of the same length")
+	}
+	if p.Offset < 0 {
+		return errors.New("key pattern offset must not be negative")
+	}
+	return nil
+}
+
+// Match returns whether key matches p.
+func (p KeyPattern) Match(key []byte) bool {
+	if !bytes.HasPrefix(key, p.Prefix) {
+		return false
+	}
+	if len(p.Mask) == 0 {
+		return true
+	}
+	if len(key) < p.Offset+len(p.Mask) {
+		return false
+	}
+	for i, mask := range p.Mask {
+		if key[p.Offset+i]&mask != p.Value[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterOptions select what a filtered iterator returns.
+type FilterOptions struct {
+	// KeysOnly makes Value return nil, sparing backends the reading or
+	// decoding of values.
+	KeysOnly bool
+	Pattern  KeyPattern
+	// MaxKeys stops the iterator after that many keys, 0 meaning no limit.
+	MaxKeys int
+}
+
+// FilteringDB is implemented by DBs able to filter iterations themselves,
+// e.g. to avoid transferring or decoding what is filtered out.
+type FilteringDB interface {
+	FilteredIterator(start, end []byte, opts FilterOptions) (dbm.Iterator, error)
+}
+
+// FilteredIterator returns an iterator over the keys of db from start to
+// end matching opts, filtered by db if it is a FilteringDB, or by the
+// iterator of db otherwise.
+func FilteredIterator(db dbm.DB, start, end []byte, opts FilterOptions) (dbm.Iterator, error) {
+	if filtering, ok := db.(FilteringDB); ok {
+		return filtering.FilteredIterator(start, end, opts)
+	}
+	if err := opts.Pattern.Validate(); err != nil {
+		return nil, err
+	}
+	start, end = narrowToPrefix(start, end, opts.Pattern.Prefix)
+	iter, err := db.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return newFilteredIterator(iter, opts), nil
+}
+
+// narrowToPrefix returns the intersection of the range from start to end
+// with the range of the keys starting with prefix, which is empty, from
+// and to the same key, if they don't overlap.
+func narrowToPrefix(start, end, prefix []byte) ([]byte, []byte) {
+	if len(prefix) == 0 {
+		return start, end
+	}
+	if start == nil || bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	if prefixEnd := prefixEnd(prefix); prefixEnd != nil && (end == nil || bytes.Compare(prefixEnd, end) < 0) {
+		end = prefixEnd
+	}
+	if end != nil && bytes.Compare(start, end) > 0 {
+		end = start
+	}
+	return start, end
+}
+
+// filteredIterator skips the keys of an iterator not matching a pattern,
+// up to a number of keys.
+type filteredIterator struct {
+	guardedIterator
+	opts FilterOptions
+	// keys left to return, including the current one
+	remaining int
+}
+
+func newFilteredIterator(iter dbm.Iterator, opts FilterOptions) *filteredIterator {
+	itr := &filteredIterator{guardedIterator: guardIterator(iter), opts: opts, remaining: opts.MaxKeys}
+	itr.skip()
+	return itr
+}
+
+// skip moves the wrapped iterator to the next matching key.
+func (itr *filteredIterator) skip() {
+	for itr.Iterator.Valid() && !itr.opts.Pattern.Match(itr.Iterator.Key()) {
+		itr.Iterator.Next()
+	}
+}
+
+func (itr *filteredIterator) valid() bool {
+	return itr.Iterator.Valid() && (itr.opts.MaxKeys == 0 || itr.remaining > 0)
+}
+
+// Valid implements Iterator.
+func (itr *filteredIterator) Valid() bool {
+	return itr.guard.valid(itr.valid())
+}
+
+// Key implements Iterator.
+func (itr *filteredIterator) Key() []byte {
+	itr.guard.assertValid(itr.valid())
+	return itr.Iterator.Key()
+}
+
+// Value implements Iterator, returning nil for keys only iterators.
+func (itr *filteredIterator) Value() []byte {
+	itr.guard.assertValid(itr.valid())
+	if itr.opts.KeysOnly {
+		return nil
+	}
+	return itr.Iterator.Value()
+}
+
+// Next implements Iterator.
+func (itr *filteredIterator) Next() {
+	itr.guard.assertValid(itr.valid())
+	itr.remaining--
+	if itr.opts.MaxKeys != 0 && itr.remaining == 0 {
+		return
+	}
+	itr.Iterator.Next()
+	itr.skip()
+}
+
+var _ FilteringDB = (*ArweaveDB)(nil)
+
+// FilteredIterator implements FilteringDB. As iterators of ArweaveDB
+// return unversioned keys, the pattern applies to keys within the version
+// of start or end. Payloads out of the prefix of the pattern aren't fetched,
+// and the values of keys only iterators aren't decoded.
+func (db *ArweaveDB) FilteredIterator(start, end []byte, opts FilterOptions) (dbm.Iterator, error) {
+	if err := opts.Pattern.Validate(); err != nil {
+		return nil, err
+	}
+	version, start, end, err := db.splitRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	start, end = narrowToPrefix(start, end, opts.Pattern.Prefix)
+	encoded, err := db.codec().Encode(version)
+	if err != nil {
+		return nil, err
+	}
+	start = append(append([]byte{}, encoded...), start...)
+	if end != nil {
+		end = append(append([]byte{}, encoded...), end...)
+	}
+	iter, err := db.IteratorWithOptions(start, end, IteratorOptions{KeysOnly: opts.KeysOnly})
+	if err != nil {
+		return nil, err
+	}
+	return newFilteredIterator(iter, opts), nil
+}
@@ -0,0 +1,127 @@
+package backends_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/sei-protocol/sei-tm-db/backends"
+	"github.com/sei-protocol/sei-tm-db/backends/arweavetest"
+)
+
+// postFiltered iterates db from start to end, filtering keys the way a
+// filtered iterator would.
+func postFiltered(t *testing.T, db dbm.DB, start, end []byte, opts backends.FilterOptions) []backends.KVPair {
+	iter, err := db.Iterator(start, end)
+	require.Nil(t, err)
+	defer iter.Close()
+	pairs := []backends.KVPair{}
+	for ; iter.Valid() && (opts.MaxKeys == 0 || len(pairs) < opts.MaxKeys); iter.Next() {
+		if !opts.Pattern.Match(iter.Key()) {
+			continue
+		}
+		pair := backends.KVPair{Key: iter.Key()}
+		if !opts.KeysOnly {
+			pair.Value = iter.Value()
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+func filtered(t *testing.T, db dbm.DB, start, end []byte, opts backends.FilterOptions) []backends.KVPair {
+	iter, err := backends.FilteredIterator(db, start, end, opts)
+	require.Nil(t, err)
+	defer iter.Close()
+	pairs := []backends.KVPair{}
+	for ; iter.Valid(); iter.Next() {
+		pairs = append(pairs, backends.KVPair{Key: iter.Key(), Value: iter.Value()})
+	}
+	require.Nil(t, iter.Error())
+	return pairs
+}
+
+var filterTestCases = []backends.FilterOptions{
+	{},
+	{KeysOnly: true},
+	{MaxKeys: 3},
+	{Pattern: backends.KeyPattern{Prefix: []byte("b/")}},
+	{Pattern: backends.KeyPattern{Prefix: []byte("b/1")}, KeysOnly: true, MaxKeys: 4},
+	{Pattern: backends.KeyPattern{Prefix: []byte("z")}},
+	// keys ending in an even digit
+	{Pattern: backends.KeyPattern{Offset: 3, Mask: []byte{0x01}, Value: []byte{0x00}}},
+	{Pattern: backends.KeyPattern{Prefix: []byte("a/"), Offset: 2, Mask: []byte{0xff, 0x01}, Value: []byte{'1', 0x01}}, MaxKeys: 2},
+}
+
+func TestFilteredIteratorFallback(t *testing.T) {
+	db := dbm.NewMemDB()
+	for _, prefix := range []string{"a/", "b/", "c/"} {
+		for i := 0; i < 20; i++ {
+			require.Nil(t, db.Set([]byte(fmt.Sprintf("%s%02d", prefix, i)), []byte(fmt.Sprint(i))))
+		}
+	}
+	for _, bounds := range [][2][]byte{{nil, nil}, {[]byte("a/05"), []byte("b/15")}, {[]byte("b/12"), nil}} {
+		for i, opts := range filterTestCases {
+			require.Equal(t, postFiltered(t, db, bounds[0], bounds[1], opts), filtered(t, db, bounds[0], bounds[1], opts), "case %d in %q", i, bounds)
+		}
+	}
+}
+
+func TestFilteredIteratorArweave(t *testing.T) {
+	builder := arweavetest.NewArchiveBuilder()
+	for _, prefix := range []string{"a/", "b/", "c/"} {
+		kvs := map[string][]byte{}
+		for i := 0; i < 20; i++ {
+			kvs[fmt.Sprintf("%s%02d", prefix, i)] = []byte(fmt.Sprint(i))
+		}
+		builder.AddTx(prefix+"~", kvs)
+	}
+	archive, err := builder.Build()
+	require.Nil(t, err)
+	db := archive.NewDB()
+
+	for _, bounds := range [][2]string{{"", ""}, {"a/05", "b/15"}, {"b/12", ""}} {
+		start := arweavetest.Key(0, bounds[0])
+		var end []byte
+		if bounds[1] != "" {
+			end = arweavetest.Key(0, bounds[1])
+		}
+		for i, opts := range filterTestCases {
+			expected := postFiltered(t, db, start, end, opts)
+			require.Equal(t, expected, filtered(t, db, start, end, opts), "case %d in %q", i, bounds)
+		}
+	}
+
+	// payloads past the prefix aren't fetched, but for the one its end may
+	// fall in
+	desc, err := db.DescribeIndex(0)
+	require.Nil(t, err)
+	faults := arweavetest.NewFaults()
+	pairs := filtered(t, archive.NewDB(backends.WithGetterMiddleware(faults.Middleware())), arweavetest.Key(0, ""), nil, backends.FilterOptions{Pattern: backends.KeyPattern{Prefix: []byte("a/")}, KeysOnly: true})
+	require.Len(t, pairs, 20)
+	require.Nil(t, pairs[0].Value)
+	require.Equal(t, 1, faults.Calls(archive.IndexTxIds[0]))
+	require.Equal(t, []int{1, 1, 0}, []int{faults.Calls(desc.Entries[0].TxId), faults.Calls(desc.Entries[1].TxId), faults.Calls(desc.Entries[2].TxId)})
+
+	// keys only batches have no values
+	iter, err := db.IteratorWithOptions(arweavetest.Key(0, ""), nil, backends.IteratorOptions{KeysOnly: true})
+	require.Nil(t, err)
+	defer iter.Close()
+	batch, err := iter.(backends.BatchedIterator).NextBatch(30)
+	require.Nil(t, err)
+	require.Len(t, batch, 30)
+	for _, pair := range batch {
+		require.Nil(t, pair.Value)
+	}
+}
+
+func TestFilteredIteratorInvalidPattern(t *testing.T) {
+	_, err := backends.FilteredIterator(dbm.NewMemDB(), nil, nil, backends.FilterOptions{Pattern: backends.KeyPattern{Mask: []byte{1}}})
+	require.NotNil(t, err)
+	db, _, err := arweavetest.NewArchiveBuilder().BuildDB()
+	require.Nil(t, err)
+	_, err = backends.FilteredIterator(db, arweavetest.Key(0, ""), nil, backends.FilterOptions{Pattern: backends.KeyPattern{Offset: -1}})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,194 @@
+package backends
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+var formatMarkerKey = mustReserveSubspace("format").Key([]byte("marker"))
+
+var ErrFormatMarkerMissing = errors.New("format marker missing from a non-empty DB")
+
+// FormatMarker records the on-disk conventions of a DB, so that it isn't
+// opened with the wrong wrappers.
+type FormatMarker struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Wrappers lists the formats of the wrappers the DB is used through,
+	// outermost first
+	Wrappers  []string  `json:"wrappers"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (m FormatMarker) String() string {
+	return fmt.Sprintf("%s v%d [%s]", m.Format, m.Version, strings.Join(m.Wrappers, ", "))
+}
+
+func (m FormatMarker) matches(other FormatMarker) bool {
+	return m.Format == other.Format && m.Version == other.Version &&
+		strings.Join(m.Wrappers, "\x00") == strings.Join(other.Wrappers, "\x00")
+}
+
+// Unwrapper is implemented by DBs wrapping another DB.
+type Unwrapper interface {
+	Unwrap() dbm.DB
+}
+
+// FormatWrapper is implemented by wrappers changing how data is stored,
+// which are then recorded in format markers.
+type FormatWrapper interface {
+	WrapperFormat() string
+}
+
+// WrapperStack returns the formats of the wrappers of db, outermost first.
+func WrapperStack(db dbm.DB) []string {
+	stack := []string{}
+	for db != nil {
+		if wrapper, ok := db.(FormatWrapper); ok {
+			stack = append(stack, wrapper.WrapperFormat())
+		}
+		unwrapper, ok := db.(Unwrapper)
+		if !ok {
+			break
+		}
+		db = unwrapper.Unwrap()
+	}
+	return stack
+}
+
+func innermostDB(db dbm.DB) dbm.DB {
+	for {
+		unwrapper, ok := db.(Unwrapper)
+		if !ok {
+			return db
+		}
+		db = unwrapper.Unwrap()
+	}
+}
+
+// WriteFormatMarker stores marker in db, through its wrappers. Wrappers
+// default to those of db and CreatedAt to now.
+func WriteFormatMarker(db dbm.DB, marker FormatMarker) error {
+	if marker.Wrappers == nil {
+		marker.Wrappers = WrapperStack(db)
+	}
+	if marker.CreatedAt.IsZero() {
+		marker.CreatedAt = time.Now().UTC()
+	}
+	bz, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+	return metadataDB(db).SetSync(formatMarkerKey, bz)
+}
+
+// ReadFormatMarker returns the marker stored in db, and whether there is
+// one. It can't be read through wrappers other than those it was written
+// with.
+func ReadFormatMarker(db dbm.DB) (FormatMarker, bool, error) {
+	bz, err := db.Get(formatMarkerKey)
+	if err != nil || bz == nil {
+		return FormatMarker{}, false, err
+	}
+	marker := FormatMarker{}
+	if err := json.Unmarshal(bz, &marker); err != nil {
+		return FormatMarker{}, true, fmt.Errorf("invalid format marker: %w", err)
+	}
+	return marker, true, nil
+}
+
+// checkFormat fails unless db holds the expected marker. Empty DBs, and any
+// DB if force is set, get the expected marker written instead.
+func checkFormat(db dbm.DB, expected FormatMarker, force bool) error {
+	if expected.Wrappers == nil {
+		expected.Wrappers = WrapperStack(db)
+	}
+	found, ok, err := ReadFormatMarker(db)
+	if err != nil {
+		// likely written through different wrappers; try to tell which
+		if raw, _, rawErr := ReadFormatMarker(innermostDB(db)); rawErr == nil {
+			err = &ErrFormatMismatch{expected: expected, found: &raw}
+		} else {
+			err = &ErrFormatMismatch{expected: expected, err: err}
+		}
+	} else if !ok {
+		iter, iterErr := Iterate(db, nil, nil)
+		if iterErr != nil {
+			return iterErr
+		}
+		empty := !iter.Valid()
+		iter.Close()
+		if !empty {
+			err = ErrFormatMarkerMissing
+		}
+	} else if !found.matches(expected) {
+		err = &ErrFormatMismatch{expected: expected, found: &found}
+	} else {
+		return nil
+	}
+	if err != nil && !force {
+		return err
+	}
+	return WriteFormatMarker(db, expected)
+}
+
+type openConfig struct {
+	wrappers    []func(dbm.DB) dbm.DB
+	expected    *FormatMarker
+	forceFormat bool
+}
+
+type OpenOption func(*openConfig)
+
+// WithWrapper wraps the opened DB with wrap. Wrappers are applied in the
+// order given, the last one being the outermost.
+func WithWrapper(wrap func(dbm.DB) dbm.DB) OpenOption {
+	return func(cfg *openConfig) {
+		cfg.wrappers = append(cfg.wrappers, wrap)
+	}
+}
+
+// ExpectFormat makes opening fail unless the DB holds a marker matching
+// marker, its wrappers defaulting to those the DB is opened with. An empty
+// DB gets the marker written.
+func ExpectFormat(marker FormatMarker) OpenOption {
+	return func(cfg *openConfig) {
+		cfg.expected = &marker
+	}
+}
+
+// ForceFormat makes ExpectFormat overwrite a missing or mismatching marker
+// rather than fail, once the DB has been migrated to the expected format.
+func ForceFormat() OpenOption {
+	return func(cfg *openConfig) {
+		cfg.forceFormat = true
+	}
+}
+
+// NewDBWithOptions opens a DB like dbm.NewDB, then wraps it and checks its
+// format marker according to opts.
+func NewDBWithOptions(name string, backend dbm.BackendType, dir string, opts ...OpenOption) (dbm.DB, error) {
+	cfg := openConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	db, err := dbm.NewDB(name, backend, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, wrap := range cfg.wrappers {
+		db = wrap(db)
+	}
+	if cfg.expected != nil {
+		if err := checkFormat(db, *cfg.expected, cfg.forceFormat); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
@@ -0,0 +1,98 @@
+package backends
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func withChecksums() OpenOption {
+	return WithWrapper(func(db dbm.DB) dbm.DB { return NewChecksumDB(db) })
+}
+
+func openFormatted(dir string, opts ...OpenOption) (dbm.DB, error) {
+	return NewDBWithOptions("test", dbm.GoLevelDBBackend, dir, opts...)
+}
+
+func TestFormatMarkerRoundTrip(t *testing.T) {
+	db := NewMetricsDB(NewChecksumDB(dbm.NewMemDB()), MetricsOptions{})
+	_, ok, err := ReadFormatMarker(db)
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.Nil(t, WriteFormatMarker(db, FormatMarker{Format: "app", Version: 3, CreatedAt: createdAt}))
+	marker, ok, err := ReadFormatMarker(db)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, FormatMarker{Format: "app", Version: 3, Wrappers: []string{"checksum.v1"}, CreatedAt: createdAt}, marker)
+}
+
+func TestExpectFormatAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	expected := FormatMarker{Format: "app", Version: 1}
+	db, err := openFormatted(dir, withChecksums(), ExpectFormat(expected))
+	require.Nil(t, err)
+	require.Nil(t, db.Set([]byte("key"), []byte("value")))
+	require.Nil(t, db.Close())
+
+	// same stack
+	db, err = openFormatted(dir, withChecksums(), ExpectFormat(expected))
+	require.Nil(t, err)
+	value, err := db.Get([]byte("key"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("value"), value)
+	require.Nil(t, db.Close())
+
+	// missing wrapper
+	_, err = openFormatted(dir, ExpectFormat(expected))
+	require.ErrorAs(t, err, new(*ErrFormatMismatch))
+
+	// other version
+	_, err = openFormatted(dir, withChecksums(), ExpectFormat(FormatMarker{Format: "app", Version: 2}))
+	require.ErrorAs(t, err, new(*ErrFormatMismatch))
+	require.Contains(t, err.Error(), "found app v1 [checksum.v1]")
+
+	// forced after migrating
+	db, err = openFormatted(dir, withChecksums(), ExpectFormat(FormatMarker{Format: "app", Version: 2}), ForceFormat())
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+	db, err = openFormatted(dir, withChecksums(), ExpectFormat(FormatMarker{Format: "app", Version: 2}))
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+}
+
+func TestExpectFormatExtraWrapper(t *testing.T) {
+	dir := t.TempDir()
+	expected := FormatMarker{Format: "app", Version: 1}
+	db, err := openFormatted(dir, ExpectFormat(expected))
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+
+	// the marker is found through the wrapper, which it doesn't list
+	_, err = openFormatted(dir, withChecksums(), ExpectFormat(expected))
+	require.ErrorAs(t, err, new(*ErrFormatMismatch))
+	require.Contains(t, err.Error(), "expected app v1 [checksum.v1], found app v1 []")
+}
+
+func TestExpectFormatMissingMarker(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openFormatted(dir)
+	require.Nil(t, err)
+	require.Nil(t, db.Set([]byte("key"), []byte("value")))
+	require.Nil(t, db.Close())
+
+	expected := FormatMarker{Format: "app", Version: 1}
+	_, err = openFormatted(dir, ExpectFormat(expected))
+	require.Equal(t, ErrFormatMarkerMissing, err)
+
+	db, err = openFormatted(dir, ExpectFormat(expected), ForceFormat())
+	require.Nil(t, err)
+	marker, ok, err := ReadFormatMarker(db)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, []string{}, marker.Wrappers)
+	require.Nil(t, db.Close())
+}
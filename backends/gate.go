@@ -0,0 +1,298 @@
+package backends
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+type PauseMode int
+
+const (
+	// PauseWrites blocks Set, Delete and batch writes while reads continue.
+	PauseWrites PauseMode = iota + 1
+	// PauseAll blocks every operation.
+	PauseAll
+)
+
+var (
+	ErrDBPaused         = errors.New("DB is paused")
+	ErrPauseWaitTimeout = errors.New("timed out waiting for the DB to resume")
+	ErrAlreadyPaused    = errors.New("DB is already paused")
+	ErrInvalidPauseMode = errors.New("invalid pause mode")
+)
+
+// Pausable is implemented by DBs which can be briefly quiesced for
+// maintenance.
+type Pausable interface {
+	// Pause returns once the operations blocked by mode that were in
+	// progress have completed. Operations blocked in the meantime are
+	// resumed in the order they were issued by calling resume.
+	Pause(mode PauseMode) (resume func(), err error)
+}
+
+type GateOption func(*GateDB)
+
+// WithFailWhilePaused makes blocked operations fail with ErrDBPaused instead
+// of waiting for the DB to resume.
+func WithFailWhilePaused() GateOption {
+	return func(db *GateDB) {
+		db.failWhilePaused = true
+	}
+}
+
+// WithPauseWaitTimeout bounds how long a blocked operation waits for the DB
+// to resume before failing with ErrPauseWaitTimeout.
+func WithPauseWaitTimeout(timeout time.Duration) GateOption {
+	return func(db *GateDB) {
+		db.waitTimeout = timeout
+	}
+}
+
+// GateDB wraps a DB to implement Pausable. Only the creation of iterators
+// is gated, not their use.
+type GateDB struct {
+	dbm.DB
+	failWhilePaused bool
+	waitTimeout     time.Duration
+
+	mtx            sync.Mutex
+	idle           *sync.Cond
+	mode           PauseMode
+	inflightReads  int
+	inflightWrites int
+	// operations waiting for the DB to resume, in the order they were issued
+	queue []*gateWaiter
+	// whether a dequeued operation is in progress; the next one is only
+	// dequeued once it completes, so that queued writes apply in order
+	draining bool
+}
+
+type gateWaiter struct {
+	write    bool
+	released chan struct{}
+}
+
+var (
+	_ dbm.DB   = (*GateDB)(nil)
+	_ Pausable = (*GateDB)(nil)
+)
+
+func NewGateDB(db dbm.DB, opts ...GateOption) *GateDB {
+	gate := &GateDB{DB: db}
+	gate.idle = sync.NewCond(&gate.mtx)
+	for _, opt := range opts {
+		opt(gate)
+	}
+	return gate
+}
+
+// Unwrap implements Unwrapper.
+func (db *GateDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+// Pause implements Pausable.
+func (db *GateDB) Pause(mode PauseMode) (func(), error) {
+	if mode != PauseWrites && mode != PauseAll {
+		return nil, ErrInvalidPauseMode
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if db.mode != 0 {
+		return nil, ErrAlreadyPaused
+	}
+	db.mode = mode
+	for db.inflightWrites > 0 || (mode == PauseAll && db.inflightReads > 0) {
+		db.idle.Wait()
+	}
+	once := sync.Once{}
+	return func() {
+		once.Do(db.resume)
+	}, nil
+}
+
+func (db *GateDB) resume() {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.mode = 0
+	if !db.draining {
+		db.releaseNext()
+	}
+}
+
+func (db *GateDB) blocks(write bool) bool {
+	return db.mode == PauseAll || (db.mode == PauseWrites && write)
+}
+
+// releaseNext lets the first queued operation proceed, unless still
+// blocked. It must be called with mtx held.
+func (db *GateDB) releaseNext() {
+	db.draining = false
+	if len(db.queue) == 0 || db.blocks(db.queue[0].write) {
+		return
+	}
+	waiter := db.queue[0]
+	db.queue = db.queue[1:]
+	db.draining = true
+	db.addInflight(waiter.write, 1)
+	close(waiter.released)
+}
+
+func (db *GateDB) addInflight(write bool, delta int) {
+	if write {
+		db.inflightWrites += delta
+	} else {
+		db.inflightReads += delta
+	}
+}
+
+// enter waits until an operation may proceed. It returns whether the
+// operation was queued, in which case the next queued one is released once
+// it leaves.
+func (db *GateDB) enter(write bool) (bool, error) {
+	db.mtx.Lock()
+	// queued operations go first, except for reads which needn't be ordered
+	// with writes
+	if !db.blocks(write) && (!write || (len(db.queue) == 0 && !db.draining)) {
+		db.addInflight(write, 1)
+		db.mtx.Unlock()
+		return false, nil
+	}
+	if db.failWhilePaused && db.blocks(write) {
+		db.mtx.Unlock()
+		return false, ErrDBPaused
+	}
+	waiter := &gateWaiter{write: write, released: make(chan struct{})}
+	db.queue = append(db.queue, waiter)
+	db.mtx.Unlock()
+
+	var expired <-chan time.Time
+	if db.waitTimeout > 0 {
+		timer := time.NewTimer(db.waitTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-waiter.released:
+		return true, nil
+	case <-expired:
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	for i, queued := range db.queue {
+		if queued == waiter {
+			db.queue = append(db.queue[:i], db.queue[i+1:]...)
+			return false, ErrPauseWaitTimeout
+		}
+	}
+	// released concurrently with the timeout
+	return true, nil
+}
+
+func (db *GateDB) leave(write bool, queued bool) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.addInflight(write, -1)
+	db.idle.Broadcast()
+	if queued {
+		db.releaseNext()
+	}
+}
+
+func (db *GateDB) gate(write bool, op func() error) error {
+	queued, err := db.enter(write)
+	if err != nil {
+		return err
+	}
+	defer db.leave(write, queued)
+	return op()
+}
+
+// Get implements DB.
+func (db *GateDB) Get(key []byte) (value []byte, err error) {
+	gateErr := db.gate(false, func() error {
+		value, err = db.DB.Get(key)
+		return nil
+	})
+	if gateErr != nil {
+		return nil, gateErr
+	}
+	return value, err
+}
+
+// Has implements DB.
+func (db *GateDB) Has(key []byte) (exists bool, err error) {
+	gateErr := db.gate(false, func() error {
+		exists, err = db.DB.Has(key)
+		return nil
+	})
+	if gateErr != nil {
+		return false, gateErr
+	}
+	return exists, err
+}
+
+// Iterator implements DB.
+func (db *GateDB) Iterator(start, end []byte) (iter dbm.Iterator, err error) {
+	gateErr := db.gate(false, func() error {
+		iter, err = db.DB.Iterator(start, end)
+		return nil
+	})
+	if gateErr != nil {
+		return nil, gateErr
+	}
+	return iter, err
+}
+
+// ReverseIterator implements DB.
+func (db *GateDB) ReverseIterator(start, end []byte) (iter dbm.Iterator, err error) {
+	gateErr := db.gate(false, func() error {
+		iter, err = db.DB.ReverseIterator(start, end)
+		return nil
+	})
+	if gateErr != nil {
+		return nil, gateErr
+	}
+	return iter, err
+}
+
+// Set implements DB.
+func (db *GateDB) Set(key []byte, value []byte) error {
+	return db.gate(true, func() error { return db.DB.Set(key, value) })
+}
+
+// SetSync implements DB.
+func (db *GateDB) SetSync(key []byte, value []byte) error {
+	return db.gate(true, func() error { return db.DB.SetSync(key, value) })
+}
+
+// Delete implements DB.
+func (db *GateDB) Delete(key []byte) error {
+	return db.gate(true, func() error { return db.DB.Delete(key) })
+}
+
+// DeleteSync implements DB.
+func (db *GateDB) DeleteSync(key []byte) error {
+	return db.gate(true, func() error { return db.DB.DeleteSync(key) })
+}
+
+// NewBatch implements DB.
+func (db *GateDB) NewBatch() dbm.Batch {
+	return &gateBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type gateBatch struct {
+	dbm.Batch
+	db *GateDB
+}
+
+func (b *gateBatch) Write() error {
+	return b.db.gate(true, b.Batch.Write)
+}
+
+func (b *gateBatch) WriteSync() error {
+	return b.db.gate(true, b.Batch.WriteSync)
+}
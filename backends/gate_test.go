@@ -0,0 +1,216 @@
+package backends
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// recordingDB records the keys set, in order.
+type recordingDB struct {
+	dbm.DB
+	mtx  sync.Mutex
+	keys []string
+}
+
+func (db *recordingDB) Set(key []byte, value []byte) error {
+	db.mtx.Lock()
+	db.keys = append(db.keys, string(key))
+	db.mtx.Unlock()
+	return db.DB.Set(key, value)
+}
+
+func queueLen(db *GateDB) int {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return len(db.queue)
+}
+
+func waitForQueueLen(t *testing.T, db *GateDB, n int) {
+	require.Eventually(t, func() bool { return queueLen(db) == n }, time.Second, time.Millisecond)
+}
+
+func TestGatePauseWritesPreservesOrder(t *testing.T) {
+	recording := &recordingDB{DB: dbm.NewMemDB()}
+	db := NewGateDB(recording)
+	resume, err := db.Pause(PauseWrites)
+	require.Nil(t, err)
+	_, err = db.Pause(PauseAll)
+	require.Equal(t, ErrAlreadyPaused, err)
+
+	wg := sync.WaitGroup{}
+	expected := []string{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%02d", 19-i)
+		expected = append(expected, key)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Nil(t, db.Set([]byte(key), []byte("v")))
+		}()
+		waitForQueueLen(t, db, i+1)
+	}
+	// reads continue
+	value, err := db.Get([]byte("k00"))
+	require.Nil(t, err)
+	require.Nil(t, value)
+	iter, err := db.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.Nil(t, iter.Close())
+	require.Empty(t, recording.keys)
+
+	resume()
+	// resuming twice is harmless
+	resume()
+	wg.Wait()
+	require.Equal(t, expected, recording.keys)
+}
+
+func TestGatePauseAll(t *testing.T) {
+	db := NewGateDB(dbm.NewMemDB())
+	require.Nil(t, db.Set([]byte("k"), []byte("v")))
+	resume, err := db.Pause(PauseAll)
+	require.Nil(t, err)
+
+	done := make(chan []byte)
+	go func() {
+		value, err := db.Get([]byte("k"))
+		require.Nil(t, err)
+		done <- value
+	}()
+	waitForQueueLen(t, db, 1)
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("k"), []byte("v2")))
+	go func() {
+		require.Nil(t, batch.Write())
+		done <- nil
+	}()
+	waitForQueueLen(t, db, 2)
+	resume()
+	// the read queued before the write sees the old value
+	require.Equal(t, "v", string(<-done))
+	<-done
+	value, err := db.Get([]byte("k"))
+	require.Nil(t, err)
+	require.Equal(t, "v2", string(value))
+
+	_, err = db.Pause(PauseMode(0))
+	require.Equal(t, ErrInvalidPauseMode, err)
+}
+
+// blockingDB blocks Sets until unblocked.
+type blockingDB struct {
+	dbm.DB
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (db *blockingDB) Set(key []byte, value []byte) error {
+	close(db.entered)
+	<-db.unblock
+	return db.DB.Set(key, value)
+}
+
+func TestGatePauseWaitsForInflightWrites(t *testing.T) {
+	blocking := &blockingDB{DB: dbm.NewMemDB(), entered: make(chan struct{}), unblock: make(chan struct{})}
+	db := NewGateDB(blocking)
+	go func() {
+		require.Nil(t, db.Set([]byte("k"), []byte("v")))
+	}()
+	<-blocking.entered
+
+	paused := int32(0)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		require.Equal(t, int32(0), atomic.LoadInt32(&paused))
+		close(blocking.unblock)
+	}()
+	resume, err := db.Pause(PauseWrites)
+	require.Nil(t, err)
+	atomic.StoreInt32(&paused, 1)
+	exists, err := blocking.DB.Has([]byte("k"))
+	require.Nil(t, err)
+	require.True(t, exists)
+	resume()
+}
+
+func TestGateWaitLimits(t *testing.T) {
+	db := NewGateDB(dbm.NewMemDB(), WithPauseWaitTimeout(10*time.Millisecond))
+	resume, err := db.Pause(PauseWrites)
+	require.Nil(t, err)
+	require.Equal(t, ErrPauseWaitTimeout, db.Set([]byte("k"), []byte("v")))
+	require.Equal(t, 0, queueLen(db))
+	resume()
+	require.Nil(t, db.Set([]byte("k"), []byte("v")))
+
+	db = NewGateDB(dbm.NewMemDB(), WithFailWhilePaused())
+	resume, err = db.Pause(PauseWrites)
+	require.Nil(t, err)
+	require.Equal(t, ErrDBPaused, db.Delete([]byte("k")))
+	_, err = db.Has([]byte("k"))
+	require.Nil(t, err)
+	resume()
+	require.Nil(t, db.Delete([]byte("k")))
+}
+
+func TestGateUnderLoad(t *testing.T) {
+	db := NewGateDB(dbm.NewMemDB())
+	stop := make(chan struct{})
+	pauser := sync.WaitGroup{}
+	pauser.Add(1)
+	go func() {
+		defer pauser.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			mode := PauseWrites
+			if i%2 == 0 {
+				mode = PauseAll
+			}
+			resume, err := db.Pause(mode)
+			require.Nil(t, err)
+			time.Sleep(100 * time.Microsecond)
+			resume()
+		}
+	}()
+
+	writers := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < 200; i++ {
+				key := []byte(fmt.Sprintf("%d/%03d", w, i))
+				if i%2 == 0 {
+					require.Nil(t, db.Set(key, []byte{byte(i)}))
+				} else {
+					batch := db.NewBatch()
+					require.Nil(t, batch.Set(key, []byte{byte(i)}))
+					require.Nil(t, batch.Write())
+					require.Nil(t, batch.Close())
+				}
+				_, err := db.Get(key)
+				require.Nil(t, err)
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(stop)
+	pauser.Wait()
+
+	for w := 0; w < 8; w++ {
+		for i := 0; i < 200; i++ {
+			value, err := db.Get([]byte(fmt.Sprintf("%d/%03d", w, i)))
+			require.Nil(t, err)
+			require.Equal(t, []byte{byte(i)}, value)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package backends
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+const (
+	importBatchSize         = 1000
+	maxReportedMalformedKVs = 100
+)
+
+// StoreKeyMapping maps the store names of an app state export to the key
+// prefixes their entries are written under in the destination DB.
+type StoreKeyMapping map[string][]byte
+
+type ImportReport struct {
+	// number of keys written per store
+	KeysByStore map[string]int
+	// stores present in the export but absent from the mapping
+	SkippedStores []string
+	// total number of malformed entries, of which at most
+	// maxReportedMalformedKVs are listed in MalformedEntries
+	MalformedCount   int
+	MalformedEntries []MalformedEntry
+}
+
+type MalformedEntry struct {
+	Store  string
+	Index  int
+	Reason string
+}
+
+type exportedKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ImportAppStateKV streams a genesis-style app state export into dst. The
+// export is expected to be of the following shape, with base64 encoded keys
+// and values; all other top-level fields are ignored:
+//
+//	{
+//	  "app_state": {
+//	    "<store name>": [{"key": "...", "value": "..."}, ...],
+//	    ...
+//	  }
+//	}
+//
+// Each key is written prefixed with the mapping of its store. Stores not in
+// mapping are skipped. Malformed entries are recorded in the report rather
+// than aborting the import, but a malformed JSON document is fatal. Keys
+// falling in the reserved namespace are reported as malformed.
+func ImportAppStateKV(ctx context.Context, r io.Reader, dst dbm.DB, mapping StoreKeyMapping) (ImportReport, error) {
+	report := ImportReport{KeysByStore: map[string]int{}}
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return report, err
+	}
+	for dec.More() {
+		field, err := dec.Token()
+		if err != nil {
+			return report, err
+		}
+		if field != "app_state" {
+			if err := skipJSONValue(dec); err != nil {
+				return report, err
+			}
+			continue
+		}
+		if err := importAppState(ctx, dec, dst, mapping, &report); err != nil {
+			return report, err
+		}
+	}
+	return report, expectDelim(dec, '}')
+}
+
+func importAppState(ctx context.Context, dec *json.Decoder, dst dbm.DB, mapping StoreKeyMapping, report *ImportReport) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		store := token.(string)
+		prefix, ok := mapping[store]
+		if !ok {
+			report.SkippedStores = append(report.SkippedStores, store)
+			if err := skipJSONValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := importStore(ctx, dec, dst, store, prefix, report); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func importStore(ctx context.Context, dec *json.Decoder, dst dbm.DB, store string, prefix []byte, report *ImportReport) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	batch := dst.NewBatch()
+	defer func() { batch.Close() }()
+	pending := 0
+	for i := 0; dec.More(); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		key, value, reason := parseExportedKV(raw)
+		key = append(append([]byte{}, prefix...), key...)
+		if reason == "" && IsReserved(key) {
+			reason = "key in the reserved namespace"
+		}
+		if reason != "" {
+			report.MalformedCount++
+			if len(report.MalformedEntries) < maxReportedMalformedKVs {
+				report.MalformedEntries = append(report.MalformedEntries, MalformedEntry{Store: store, Index: i, Reason: reason})
+			}
+			continue
+		}
+		if err := batch.Set(key, value); err != nil {
+			return err
+		}
+		report.KeysByStore[store]++
+		pending++
+		if pending == importBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Close()
+			batch = dst.NewBatch()
+			pending = 0
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	return expectDelim(dec, ']')
+}
+
+func parseExportedKV(raw json.RawMessage) ([]byte, []byte, string) {
+	kv := exportedKV{}
+	if err := json.Unmarshal(raw, &kv); err != nil {
+		return nil, nil, err.Error()
+	}
+	key, err := base64.StdEncoding.DecodeString(kv.Key)
+	if err != nil {
+		return nil, nil, fmt.Sprintf("invalid key: %s", err)
+	}
+	if len(key) == 0 {
+		return nil, nil, "empty key"
+	}
+	value, err := base64.StdEncoding.DecodeString(kv.Value)
+	if err != nil {
+		return nil, nil, fmt.Sprintf("invalid value: %s", err)
+	}
+	return key, value, ""
+}
+
+func expectDelim(dec *json.Decoder, expected json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != expected {
+		return fmt.Errorf("expected %s but got %v", expected, token)
+	}
+	return nil
+}
+
+// skipJSONValue consumes the next value from dec token by token, so that
+// skipping a large value doesn't require holding it in memory.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return errors.New("unexpected end of JSON input")
+		}
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
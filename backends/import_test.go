@@ -0,0 +1,144 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestImportAppStateKV(t *testing.T) {
+	export := fmt.Sprintf(`{
+		"genesis_time": "2022-01-01T00:00:00Z",
+		"consensus_params": {"block": {"max_bytes": "1"}, "evidence": [1, 2, {"a": []}]},
+		"app_state": {
+			"bank": [
+				{"key": "%s", "value": "%s"},
+				{"key": "%s", "value": "%s"},
+				{"key": 1, "value": "%s"},
+				{"key": "%s", "value": "not base64!"}
+			],
+			"unknown": [{"key": "%s", "value": "%s"}],
+			"staking": [
+				{"key": "%s", "value": "%s"},
+				{"key": "", "value": "%s"}
+			]
+		},
+		"chain_id": "test"
+	}`,
+		b64("a"), b64("v1"), b64("b"), b64("v2"), b64("v3"), b64("c"),
+		b64("x"), b64("v4"),
+		b64("a"), b64("v5"), b64("v6"),
+	)
+	db := dbm.NewMemDB()
+	report, err := ImportAppStateKV(context.Background(), strings.NewReader(export), db, StoreKeyMapping{
+		"bank":    []byte("b/"),
+		"staking": []byte("s/"),
+	})
+	require.Nil(t, err)
+	require.Equal(t, map[string]int{"bank": 2, "staking": 1}, report.KeysByStore)
+	require.Equal(t, []string{"unknown"}, report.SkippedStores)
+	require.Equal(t, 3, report.MalformedCount)
+	require.Equal(t, "bank", report.MalformedEntries[0].Store)
+	require.Equal(t, 2, report.MalformedEntries[0].Index)
+	require.Equal(t, 3, report.MalformedEntries[1].Index)
+	require.Equal(t, "staking", report.MalformedEntries[2].Store)
+	require.Equal(t, "empty key", report.MalformedEntries[2].Reason)
+
+	for key, expected := range map[string]string{"b/a": "v1", "b/b": "v2", "s/a": "v5"} {
+		value, err := db.Get([]byte(key))
+		require.Nil(t, err)
+		require.Equal(t, expected, string(value))
+	}
+	has, err := db.Has([]byte("x"))
+	require.Nil(t, err)
+	require.False(t, has)
+}
+
+func TestImportAppStateKVMalformedDocument(t *testing.T) {
+	db := dbm.NewMemDB()
+	_, err := ImportAppStateKV(context.Background(), strings.NewReader(`{"app_state": {"bank": [`), db, StoreKeyMapping{"bank": []byte("b/")})
+	require.NotNil(t, err)
+	_, err = ImportAppStateKV(context.Background(), strings.NewReader(`[]`), db, StoreKeyMapping{})
+	require.NotNil(t, err)
+}
+
+func TestImportAppStateKVCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	export := fmt.Sprintf(`{"app_state": {"bank": [{"key": "%s", "value": "%s"}]}}`, b64("a"), b64("v"))
+	_, err := ImportAppStateKV(ctx, strings.NewReader(export), dbm.NewMemDB(), StoreKeyMapping{"bank": []byte("b/")})
+	require.Equal(t, context.Canceled, err)
+}
+
+// streamedExport lazily generates a large export with `entries` entries all
+// sharing the same key, so that the destination stays small and the memory
+// used by the import itself can be observed.
+type streamedExport struct {
+	buf      bytes.Buffer
+	entry    string
+	entries  int
+	produced int
+	finished bool
+
+	bytesSinceSample int
+	maxHeapInuse     uint64
+}
+
+func newStreamedExport(entries int, valueSize int) *streamedExport {
+	e := &streamedExport{
+		entry:   fmt.Sprintf(`{"key": "%s", "value": "%s"}`, b64("k"), b64(strings.Repeat("v", valueSize))),
+		entries: entries,
+	}
+	e.buf.WriteString(`{"app_state": {"bank": [`)
+	return e
+}
+
+func (e *streamedExport) Read(p []byte) (int, error) {
+	for e.buf.Len() < len(p) && !e.finished {
+		if e.produced == e.entries {
+			e.buf.WriteString(`]}}`)
+			e.finished = true
+			break
+		}
+		if e.produced > 0 {
+			e.buf.WriteString(",")
+		}
+		e.buf.WriteString(e.entry)
+		e.produced++
+	}
+	n, err := e.buf.Read(p)
+	e.bytesSinceSample += n
+	if e.bytesSinceSample > 8<<20 {
+		e.bytesSinceSample = 0
+		stats := runtime.MemStats{}
+		runtime.ReadMemStats(&stats)
+		if stats.HeapInuse > e.maxHeapInuse {
+			e.maxHeapInuse = stats.HeapInuse
+		}
+	}
+	return n, err
+}
+
+func TestImportAppStateKVConstantMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large streamed import in short mode")
+	}
+	// ~130MB of JSON
+	export := newStreamedExport(100000, 1000)
+	db := dbm.NewMemDB()
+	report, err := ImportAppStateKV(context.Background(), export, db, StoreKeyMapping{"bank": []byte("b/")})
+	require.Nil(t, err)
+	require.Equal(t, 100000, report.KeysByStore["bank"])
+	require.Less(t, export.maxHeapInuse, uint64(64<<20))
+}
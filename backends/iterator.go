@@ -0,0 +1,82 @@
+package backends
+
+import (
+	dbm "github.com/tendermint/tm-db"
+)
+
+// InvalidIteratorMessage is what Key, Value and Next of the iterators of
+// all the backends panic with when called on an invalid iterator, be it
+// exhausted or closed, as tm-db's own iterators do.
+const InvalidIteratorMessage = "iterator is invalid"
+
+// iteratorGuard is embedded in iterators to enforce the tm-db contract
+// uniformly: once closed an iterator is invalid, and Key, Value and Next of
+// an invalid iterator panic with InvalidIteratorMessage.
+type iteratorGuard struct {
+	closed bool
+}
+
+// valid returns whether an iterator which would otherwise be valid is.
+func (g *iteratorGuard) valid(valid bool) bool {
+	return valid && !g.closed
+}
+
+// assertValid panics with InvalidIteratorMessage unless an iterator which
+// would otherwise be valid is.
+func (g *iteratorGuard) assertValid(valid bool) {
+	if !g.valid(valid) {
+		panic(InvalidIteratorMessage)
+	}
+}
+
+// close marks the iterator closed, returning whether it wasn't already so
+// that its resources are only released once.
+func (g *iteratorGuard) close() bool {
+	if g.closed {
+		return false
+	}
+	g.closed = true
+	return true
+}
+
+// guardedIterator enforces the contract on an iterator wrapped by a DB
+// wrapper, whatever the iterator does itself.
+type guardedIterator struct {
+	dbm.Iterator
+	guard iteratorGuard
+}
+
+func guardIterator(iter dbm.Iterator) guardedIterator {
+	return guardedIterator{Iterator: iter}
+}
+
+// Valid implements Iterator.
+func (itr *guardedIterator) Valid() bool {
+	return itr.guard.valid(itr.Iterator.Valid())
+}
+
+// Key implements Iterator.
+func (itr *guardedIterator) Key() []byte {
+	itr.guard.assertValid(itr.Iterator.Valid())
+	return itr.Iterator.Key()
+}
+
+// Value implements Iterator.
+func (itr *guardedIterator) Value() []byte {
+	itr.guard.assertValid(itr.Iterator.Valid())
+	return itr.Iterator.Value()
+}
+
+// Next implements Iterator.
+func (itr *guardedIterator) Next() {
+	itr.guard.assertValid(itr.Iterator.Valid())
+	itr.Iterator.Next()
+}
+
+// Close implements Iterator.
+func (itr *guardedIterator) Close() error {
+	if !itr.guard.close() {
+		return nil
+	}
+	return itr.Iterator.Close()
+}
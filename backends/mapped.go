@@ -0,0 +1,424 @@
+package backends
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Mapped files hold, all integers being little endian:
+//   - the entries sorted by key, each being keyLen(4) || valueLen(4) || key
+//     || value, back to back
+//   - the offset(8) of every entry from the start of the file
+//   - the crc32c(4) of every mappedBlockSize bytes of entries, the last
+//     block being shorter
+//   - a footer of entriesLen(8) || count(8) || blockSize(4) || version(4) ||
+//     crc32c(4) of the offsets, block checksums and footer up to there ||
+//     reserved(4) || mappedMagic
+//
+// Integers are decoded from bytes rather than read in place, so that
+// nothing in the file needs to be aligned.
+const (
+	mappedMagic       = "SEIMAPDB"
+	mappedVersion     = 1
+	mappedFooterLen   = 40
+	mappedEntryHeader = 8
+	mappedBlockSize   = 64 << 10
+)
+
+var ErrReadOnly = errors.New("DB is read-only")
+
+var errMappedDBClosed = errors.New("mapped DB is closed")
+
+// WriteMappedDB writes the content of db to w in the format served by
+// OpenMappedDB. The reserved namespace is left out unless IncludeReserved
+// is passed.
+func WriteMappedDB(db dbm.DB, w io.Writer, opts ...IterateOption) error {
+	iter, err := Iterate(db, nil, nil, opts...)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	bw := bufio.NewWriter(w)
+	var (
+		offsets []uint64
+		sums    []uint32
+		offset  uint64
+		// checksum of the current block, holding offset%mappedBlockSize bytes
+		sum    uint32
+		header [mappedEntryHeader]byte
+	)
+	// write writes entry bytes, checksumming them by block
+	write := func(bz []byte) error {
+		if _, err := bw.Write(bz); err != nil {
+			return err
+		}
+		for len(bz) > 0 {
+			n := mappedBlockSize - int(offset%mappedBlockSize)
+			if n > len(bz) {
+				n = len(bz)
+			}
+			sum = crc32.Update(sum, crc32c, bz[:n])
+			offset += uint64(n)
+			bz = bz[n:]
+			if offset%mappedBlockSize == 0 {
+				sums = append(sums, sum)
+				sum = 0
+			}
+		}
+		return nil
+	}
+	for ; iter.Valid(); iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if uint64(len(key)) > math.MaxUint32 || uint64(len(value)) > math.MaxUint32 {
+			return fmt.Errorf("entry %X is too large to be mapped", key)
+		}
+		offsets = append(offsets, offset)
+		binary.LittleEndian.PutUint32(header[:4], uint32(len(key)))
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(value)))
+		for _, bz := range [][]byte{header[:], key, value} {
+			if err := write(bz); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if offset%mappedBlockSize != 0 {
+		sums = append(sums, sum)
+	}
+
+	meta := crc32.New(crc32c)
+	mw := io.MultiWriter(bw, meta)
+	var bz [8]byte
+	for _, off := range offsets {
+		binary.LittleEndian.PutUint64(bz[:], off)
+		if _, err := mw.Write(bz[:]); err != nil {
+			return err
+		}
+	}
+	for _, sum := range sums {
+		binary.LittleEndian.PutUint32(bz[:4], sum)
+		if _, err := mw.Write(bz[:4]); err != nil {
+			return err
+		}
+	}
+	footer := make([]byte, mappedFooterLen)
+	binary.LittleEndian.PutUint64(footer[0:], offset)
+	binary.LittleEndian.PutUint64(footer[8:], uint64(len(offsets)))
+	binary.LittleEndian.PutUint32(footer[16:], mappedBlockSize)
+	binary.LittleEndian.PutUint32(footer[20:], mappedVersion)
+	_, _ = meta.Write(footer[:24])
+	binary.LittleEndian.PutUint32(footer[24:], meta.Sum32())
+	copy(footer[32:], mappedMagic)
+	if _, err := bw.Write(footer); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// mappedDB serves a file written by WriteMappedDB read-only, straight from
+// a memory mapping of it, so that large fixtures are neither decoded nor
+// copied to the heap. Keys and values returned by Get and iterators point
+// into the mapping: they must not be modified, and are only valid until
+// the DB is closed. Close must not be called concurrently with reads.
+type mappedDB struct {
+	path  string
+	data  []byte
+	unmap func() error
+
+	// the entries and offset index within data
+	entries []byte
+	offsets []byte
+	count   int
+}
+
+var _ dbm.DB = (*mappedDB)(nil)
+
+// OpenMappedDB maps the file at path, written by WriteMappedDB, and
+// verifies its checksums and layout before serving it.
+func OpenMappedDB(path string) (dbm.DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < mappedFooterLen || info.Size() > math.MaxInt {
+		return nil, fmt.Errorf("%s: invalid mapped DB size %d", path, info.Size())
+	}
+	data, unmap, err := mmapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %w", path, err)
+	}
+	db := &mappedDB{path: path, data: data, unmap: unmap}
+	if err := db.load(); err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// load checks the layout of the mapped file, such that no offset read
+// afterwards can be out of bounds, and verifies its checksums.
+func (db *mappedDB) load() error {
+	footer := db.data[len(db.data)-mappedFooterLen:]
+	if string(footer[32:]) != mappedMagic {
+		return errors.New("not a mapped DB")
+	}
+	if version := binary.LittleEndian.Uint32(footer[20:]); version != mappedVersion {
+		return fmt.Errorf("unsupported mapped DB version %d", version)
+	}
+	entriesLen := binary.LittleEndian.Uint64(footer[0:])
+	count := binary.LittleEndian.Uint64(footer[8:])
+	blockSize := uint64(binary.LittleEndian.Uint32(footer[16:]))
+	size := uint64(len(db.data) - mappedFooterLen)
+	if blockSize == 0 || entriesLen > size || count > (size-entriesLen)/8 {
+		return errors.New("invalid mapped DB footer")
+	}
+	blocks := (entriesLen + blockSize - 1) / blockSize
+	if entriesLen+count*8+blocks*4 != size {
+		return errors.New("invalid mapped DB footer")
+	}
+	if crc32.Checksum(db.data[entriesLen:len(db.data)-16], crc32c) != binary.LittleEndian.Uint32(footer[24:]) {
+		return errors.New("mapped DB index checksum mismatch")
+	}
+	db.entries = db.data[:entriesLen]
+	db.offsets = db.data[entriesLen : entriesLen+count*8]
+	db.count = int(count)
+
+	sums := db.data[entriesLen+count*8 : size]
+	for block := uint64(0); block < blocks; block++ {
+		end := (block + 1) * blockSize
+		if end > entriesLen {
+			end = entriesLen
+		}
+		if crc32.Checksum(db.entries[block*blockSize:end], crc32c) != binary.LittleEndian.Uint32(sums[block*4:]) {
+			return fmt.Errorf("mapped DB checksum mismatch in block %d", block)
+		}
+	}
+
+	// entries must be back to back, in strictly increasing key order
+	next := uint64(0)
+	var prev []byte
+	for i := 0; i < db.count; i++ {
+		off := binary.LittleEndian.Uint64(db.offsets[i*8:])
+		if off != next || entriesLen-off < mappedEntryHeader {
+			return fmt.Errorf("invalid offset of mapped DB entry %d", i)
+		}
+		keyLen := uint64(binary.LittleEndian.Uint32(db.entries[off:]))
+		valueLen := uint64(binary.LittleEndian.Uint32(db.entries[off+4:]))
+		if entriesLen-off-mappedEntryHeader < keyLen+valueLen {
+			return fmt.Errorf("mapped DB entry %d is out of bounds", i)
+		}
+		key := db.key(i)
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			return fmt.Errorf("mapped DB entry %d is out of order", i)
+		}
+		prev = key
+		next = off + mappedEntryHeader + keyLen + valueLen
+	}
+	if next != entriesLen {
+		return errors.New("invalid mapped DB entries length")
+	}
+	return nil
+}
+
+// entry returns the key and value of the i-th entry, capped so that
+// appending to them never writes to the mapping.
+func (db *mappedDB) entry(i int) ([]byte, []byte) {
+	off := binary.LittleEndian.Uint64(db.offsets[i*8:])
+	keyLen := uint64(binary.LittleEndian.Uint32(db.entries[off:]))
+	valueLen := uint64(binary.LittleEndian.Uint32(db.entries[off+4:]))
+	keyAt := off + mappedEntryHeader
+	valueAt := keyAt + keyLen
+	return db.entries[keyAt:valueAt:valueAt], db.entries[valueAt : valueAt+valueLen : valueAt+valueLen]
+}
+
+func (db *mappedDB) key(i int) []byte {
+	key, _ := db.entry(i)
+	return key
+}
+
+// search returns the index of the first entry whose key is key or after.
+func (db *mappedDB) search(key []byte) int {
+	return sort.Search(db.count, func(i int) bool {
+		return bytes.Compare(db.key(i), key) >= 0
+	})
+}
+
+// Get implements DB.
+func (db *mappedDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	if db.data == nil {
+		return nil, errMappedDBClosed
+	}
+	i := db.search(key)
+	if i == db.count {
+		return nil, nil
+	}
+	found, value := db.entry(i)
+	if !bytes.Equal(found, key) {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// Has implements DB.
+func (db *mappedDB) Has(key []byte) (bool, error) {
+	value, err := db.Get(key)
+	return value != nil, err
+}
+
+// Iterator implements DB.
+func (db *mappedDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	return db.newIterator(start, end, false)
+}
+
+// ReverseIterator implements DB.
+func (db *mappedDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	return db.newIterator(start, end, true)
+}
+
+func (db *mappedDB) newIterator(start, end []byte, reverse bool) (dbm.Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	if db.data == nil {
+		return nil, errMappedDBClosed
+	}
+	lo, hi := 0, db.count
+	if start != nil {
+		lo = db.search(start)
+	}
+	if end != nil {
+		hi = db.search(end)
+	}
+	itr := &mappedIterator{db: db, start: start, end: end, lo: lo, hi: hi, reverse: reverse, pos: lo}
+	if reverse {
+		itr.pos = hi - 1
+	}
+	return itr, nil
+}
+
+// Set implements DB, failing with ErrReadOnly.
+func (db *mappedDB) Set([]byte, []byte) error {
+	return ErrReadOnly
+}
+
+// SetSync implements DB, failing with ErrReadOnly.
+func (db *mappedDB) SetSync([]byte, []byte) error {
+	return ErrReadOnly
+}
+
+// Delete implements DB, failing with ErrReadOnly.
+func (db *mappedDB) Delete([]byte) error {
+	return ErrReadOnly
+}
+
+// DeleteSync implements DB, failing with ErrReadOnly.
+func (db *mappedDB) DeleteSync([]byte) error {
+	return ErrReadOnly
+}
+
+// NewBatch implements DB. Writes to the batch fail with ErrReadOnly.
+func (db *mappedDB) NewBatch() dbm.Batch {
+	return readOnlyBatch{err: ErrReadOnly}
+}
+
+// Close implements DB, unmapping the file.
+func (db *mappedDB) Close() error {
+	if db.data == nil {
+		return nil
+	}
+	db.data, db.entries, db.offsets, db.count = nil, nil, nil, 0
+	return db.unmap()
+}
+
+// Print implements DB.
+func (db *mappedDB) Print() error {
+	for i := 0; i < db.count; i++ {
+		key, value := db.entry(i)
+		fmt.Printf("[%X]:\t[%X]\n", key, value)
+	}
+	return nil
+}
+
+// Stats implements DB.
+func (db *mappedDB) Stats() map[string]string {
+	return map[string]string{
+		"mapped.path":  db.path,
+		"mapped.keys":  fmt.Sprint(db.count),
+		"mapped.bytes": fmt.Sprint(len(db.data)),
+	}
+}
+
+type mappedIterator struct {
+	db         *mappedDB
+	start, end []byte
+	// the entries iterated over are [lo, hi), pos being the current one
+	lo, hi  int
+	pos     int
+	reverse bool
+	guard   iteratorGuard
+}
+
+// Domain implements Iterator.
+func (itr *mappedIterator) Domain() ([]byte, []byte) {
+	return itr.start, itr.end
+}
+
+// Valid implements Iterator.
+func (itr *mappedIterator) Valid() bool {
+	return itr.guard.valid(itr.pos >= itr.lo && itr.pos < itr.hi && itr.db.data != nil)
+}
+
+// Next implements Iterator.
+func (itr *mappedIterator) Next() {
+	itr.guard.assertValid(itr.Valid())
+	if itr.reverse {
+		itr.pos--
+	} else {
+		itr.pos++
+	}
+}
+
+// Key implements Iterator.
+func (itr *mappedIterator) Key() []byte {
+	itr.guard.assertValid(itr.Valid())
+	key, _ := itr.db.entry(itr.pos)
+	return key
+}
+
+// Value implements Iterator.
+func (itr *mappedIterator) Value() []byte {
+	itr.guard.assertValid(itr.Valid())
+	_, value := itr.db.entry(itr.pos)
+	return value
+}
+
+// Error implements Iterator.
+func (itr *mappedIterator) Error() error {
+	return nil
+}
+
+// Close implements Iterator.
+func (itr *mappedIterator) Close() error {
+	itr.guard.close()
+	return nil
+}
@@ -0,0 +1,163 @@
+package backends
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// writeMappedFixture writes a mapped DB of n keys with values of valueLen
+// bytes, returning its path and source.
+func writeMappedFixture(t *testing.T, n, valueLen int) (string, *dbm.MemDB) {
+	src := dbm.NewMemDB()
+	for i := 0; i < n; i++ {
+		value := []byte(strings.Repeat(fmt.Sprint(i%10), valueLen))
+		require.Nil(t, src.Set([]byte(fmt.Sprintf("key%05d", i)), value))
+	}
+	path := filepath.Join(t.TempDir(), "fixture.mapped")
+	f, err := os.Create(path)
+	require.Nil(t, err)
+	require.Nil(t, WriteMappedDB(src, f))
+	require.Nil(t, f.Close())
+	return path, src
+}
+
+func TestMappedDBGet(t *testing.T) {
+	path, _ := writeMappedFixture(t, 100, 10)
+	db, err := OpenMappedDB(path)
+	require.Nil(t, err)
+	defer db.Close()
+
+	for _, key := range []string{"key00000", "key00050", "key00099"} {
+		value, err := db.Get([]byte(key))
+		require.Nil(t, err)
+		require.Equal(t, strings.Repeat(key[7:], 10), string(value))
+		has, err := db.Has([]byte(key))
+		require.Nil(t, err)
+		require.True(t, has)
+	}
+	for _, key := range []string{"a", "key", "key00099a", "key00100", "z"} {
+		value, err := db.Get([]byte(key))
+		require.Nil(t, err)
+		require.Nil(t, value)
+	}
+	_, err = db.Get(nil)
+	require.Equal(t, errKeyEmpty, err)
+	require.Equal(t, "100", db.Stats()["mapped.keys"])
+}
+
+func TestMappedDBIterator(t *testing.T) {
+	// values spanning several blocks, entries straddling block boundaries
+	path, src := writeMappedFixture(t, 1000, 300)
+	fi, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Greater(t, fi.Size(), int64(4*mappedBlockSize))
+	db, err := OpenMappedDB(path)
+	require.Nil(t, err)
+	defer db.Close()
+
+	for _, tc := range []struct {
+		start, end []byte
+	}{
+		{nil, nil},
+		{[]byte("key00100"), []byte("key00900")},
+		{[]byte("key001005"), nil},
+		{nil, []byte("key00001")},
+		{[]byte("z"), nil},
+	} {
+		for _, reverse := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s-%s-%v", tc.start, tc.end, reverse), func(t *testing.T) {
+				open := dbm.DB.Iterator
+				if reverse {
+					open = dbm.DB.ReverseIterator
+				}
+				expected, err := open(src, tc.start, tc.end)
+				require.Nil(t, err)
+				defer expected.Close()
+				iter, err := open(db, tc.start, tc.end)
+				require.Nil(t, err)
+				defer iter.Close()
+				require.Equal(t, collectPairs(t, expected), collectPairs(t, iter))
+			})
+		}
+	}
+}
+
+func TestMappedDBEmpty(t *testing.T) {
+	path, _ := writeMappedFixture(t, 0, 0)
+	db, err := OpenMappedDB(path)
+	require.Nil(t, err)
+	defer db.Close()
+	value, err := db.Get([]byte("a"))
+	require.Nil(t, err)
+	require.Nil(t, value)
+	iter, err := db.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.False(t, iter.Valid())
+	require.Nil(t, iter.Close())
+}
+
+func TestMappedDBReadOnly(t *testing.T) {
+	path, _ := writeMappedFixture(t, 10, 10)
+	db, err := OpenMappedDB(path)
+	require.Nil(t, err)
+
+	require.Equal(t, ErrReadOnly, db.Set([]byte("a"), []byte("v")))
+	require.Equal(t, ErrReadOnly, db.SetSync([]byte("a"), []byte("v")))
+	require.Equal(t, ErrReadOnly, db.Delete([]byte("key00000")))
+	require.Equal(t, ErrReadOnly, db.DeleteSync([]byte("key00000")))
+	batch := db.NewBatch()
+	require.Equal(t, ErrReadOnly, batch.Set([]byte("a"), []byte("v")))
+	require.Equal(t, ErrReadOnly, batch.Write())
+	require.Nil(t, batch.Close())
+
+	iter, err := db.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.True(t, iter.Valid())
+	require.Nil(t, db.Close())
+	require.False(t, iter.Valid())
+	require.Nil(t, iter.Close())
+	_, err = db.Get([]byte("key00000"))
+	require.Equal(t, errMappedDBClosed, err)
+	require.Nil(t, db.Close())
+}
+
+func TestMappedDBCorruption(t *testing.T) {
+	path, _ := writeMappedFixture(t, 1000, 300)
+	original, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		offset int
+		err    string
+	}{
+		{"first entry", 0, "checksum mismatch in block 0"},
+		{"later block", 2*mappedBlockSize + 7, "checksum mismatch in block 2"},
+		{"offset index", len(original) - mappedFooterLen - 100, "index checksum mismatch"},
+		{"footer", len(original) - mappedFooterLen + 10, "invalid mapped DB footer"},
+		{"magic", len(original) - 1, "not a mapped DB"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			corrupted := append([]byte{}, original...)
+			corrupted[tc.offset] ^= 0x01
+			require.Nil(t, ioutil.WriteFile(path, corrupted, 0o600))
+			_, err := OpenMappedDB(path)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	require.Nil(t, ioutil.WriteFile(path, original[:len(original)-1], 0o600))
+	_, err = OpenMappedDB(path)
+	require.Error(t, err)
+	require.Nil(t, ioutil.WriteFile(path, nil, 0o600))
+	_, err = OpenMappedDB(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,257 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+var ErrReadOnlyView = errors.New("materialized view is read-only")
+
+// materialization is a loaded copy of the prefix of a MaterializedView.
+// Its DB is never written once loaded, so that readers can keep using it
+// while a newer one is swapped in.
+type materialization struct {
+	db   *dbm.MemDB
+	keys int
+	at   time.Time
+}
+
+// MaterializedView serves reads of the keys under a prefix of a DB from an
+// in-memory copy refreshed periodically, for small and slowly changing
+// prefixes read very often. Reads may be stale by up to the refresh
+// interval, plus the time a refresh takes. Keys are not stripped of the
+// prefix, and keys outside of it are absent.
+type MaterializedView struct {
+	src     dbm.DB
+	prefix  []byte
+	refresh time.Duration
+	now     func() time.Time
+
+	current atomic.Value // *materialization
+	// serializes refreshes
+	refreshMtx sync.Mutex
+	lastErr    atomic.Value // error, wrapped in refreshError
+
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+type refreshError struct {
+	err error
+}
+
+var _ dbm.DB = (*MaterializedView)(nil)
+
+// NewMaterializedView loads the keys of src under prefix, and reloads them
+// every refresh unless it is 0. Loads are made from a snapshot if src is a
+// SnapshottableDB, and from an iterator otherwise.
+func NewMaterializedView(src dbm.DB, prefix []byte, refresh time.Duration) (*MaterializedView, error) {
+	v := &MaterializedView{
+		src:     src,
+		prefix:  append([]byte{}, prefix...),
+		refresh: refresh,
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := v.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	if refresh <= 0 {
+		close(v.stopped)
+		return v, nil
+	}
+	go v.refreshLoop()
+	return v, nil
+}
+
+func (v *MaterializedView) refreshLoop() {
+	defer close(v.stopped)
+	ticker := time.NewTicker(v.refresh)
+	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-v.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			// failures are reported by Stats, and the last view is kept
+			_ = v.Refresh(ctx)
+		case <-v.stop:
+			return
+		}
+	}
+}
+
+// Refresh loads the keys under the prefix again, and swaps them in at once
+// if the load completes. On failure, the previous keys keep being served.
+func (v *MaterializedView) Refresh(ctx context.Context) error {
+	v.refreshMtx.Lock()
+	defer v.refreshMtx.Unlock()
+	loaded, err := v.load(ctx)
+	v.lastErr.Store(refreshError{err: err})
+	if err != nil {
+		return err
+	}
+	v.current.Store(loaded)
+	return nil
+}
+
+func (v *MaterializedView) load(ctx context.Context) (*materialization, error) {
+	at := v.now()
+	var source interface {
+		Iterator(start, end []byte) (dbm.Iterator, error)
+	} = v.src
+	if snapshottable, ok := v.src.(SnapshottableDB); ok {
+		snapshot, err := snapshottable.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		defer snapshot.Close()
+		source = snapshot
+	}
+	var start, end []byte
+	if len(v.prefix) > 0 {
+		start, end = v.prefix, prefixEnd(v.prefix)
+	}
+	iter, err := source.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	loaded := &materialization{db: dbm.NewMemDB(), at: at}
+	for ; iter.Valid(); iter.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := loaded.db.Set(iter.Key(), iter.Value()); err != nil {
+			return nil, err
+		}
+		loaded.keys++
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+func (v *MaterializedView) view() *materialization {
+	return v.current.Load().(*materialization)
+}
+
+// LastRefresh returns when the keys served were loaded, or rather when
+// their load started.
+func (v *MaterializedView) LastRefresh() time.Time {
+	return v.view().at
+}
+
+// Get implements DB.
+func (v *MaterializedView) Get(key []byte) ([]byte, error) {
+	return v.view().db.Get(key)
+}
+
+// Has implements DB.
+func (v *MaterializedView) Has(key []byte) (bool, error) {
+	return v.view().db.Has(key)
+}
+
+// Iterator implements DB. The iterator keeps reading the keys loaded when
+// it was created.
+func (v *MaterializedView) Iterator(start, end []byte) (dbm.Iterator, error) {
+	return v.view().db.Iterator(start, end)
+}
+
+// ReverseIterator implements DB. See Iterator.
+func (v *MaterializedView) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	return v.view().db.ReverseIterator(start, end)
+}
+
+// Set implements DB, failing with ErrReadOnlyView.
+func (v *MaterializedView) Set([]byte, []byte) error {
+	return ErrReadOnlyView
+}
+
+// SetSync implements DB, failing with ErrReadOnlyView.
+func (v *MaterializedView) SetSync([]byte, []byte) error {
+	return ErrReadOnlyView
+}
+
+// Delete implements DB, failing with ErrReadOnlyView.
+func (v *MaterializedView) Delete([]byte) error {
+	return ErrReadOnlyView
+}
+
+// DeleteSync implements DB, failing with ErrReadOnlyView.
+func (v *MaterializedView) DeleteSync([]byte) error {
+	return ErrReadOnlyView
+}
+
+// NewBatch implements DB. Writes to the batch fail with ErrReadOnlyView.
+func (v *MaterializedView) NewBatch() dbm.Batch {
+	return readOnlyBatch{err: ErrReadOnlyView}
+}
+
+// Close implements DB, stopping the refreshes. The source DB isn't closed.
+func (v *MaterializedView) Close() error {
+	v.closeOnce.Do(func() {
+		close(v.stop)
+	})
+	<-v.stopped
+	return nil
+}
+
+// Print implements DB.
+func (v *MaterializedView) Print() error {
+	return v.view().db.Print()
+}
+
+// Stats implements DB.
+func (v *MaterializedView) Stats() map[string]string {
+	current := v.view()
+	stats := map[string]string{
+		"materialized.keys":         fmt.Sprint(current.keys),
+		"materialized.last_refresh": current.at.Format(time.RFC3339Nano),
+	}
+	if last, ok := v.lastErr.Load().(refreshError); ok && last.err != nil {
+		stats["materialized.refresh_error"] = last.err.Error()
+	}
+	return stats
+}
+
+// readOnlyBatch is the batch of a read-only DB, whose writes fail with err.
+type readOnlyBatch struct {
+	err error
+}
+
+func (b readOnlyBatch) Set([]byte, []byte) error {
+	return b.err
+}
+
+func (b readOnlyBatch) Delete([]byte) error {
+	return b.err
+}
+
+func (b readOnlyBatch) Write() error {
+	return b.err
+}
+
+func (b readOnlyBatch) WriteSync() error {
+	return b.err
+}
+
+func (readOnlyBatch) Close() error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func viewPairs(t *testing.T, view *MaterializedView) []KVPair {
+	iter, err := view.Iterator(nil, nil)
+	require.Nil(t, err)
+	defer iter.Close()
+	return collectPairs(t, iter)
+}
+
+func TestMaterializedViewPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		keys   []string
+	}{
+		{"p/", []string{"p/", "p/a", "p/b"}},
+		{"\xff", []string{"\xff", "\xff\xff"}},
+		{"", []string{"o", "p", "p/", "p/a", "p/b", "p0", "\xff", "\xff\xff"}},
+	} {
+		t.Run(fmt.Sprintf("%q", tc.prefix), func(t *testing.T) {
+			src := dbm.NewMemDB()
+			for _, key := range []string{"o", "p", "p/", "p/a", "p/b", "p0", "\xff", "\xff\xff"} {
+				require.Nil(t, src.Set([]byte(key), []byte("v"+key)))
+			}
+			view, err := NewMaterializedView(src, []byte(tc.prefix), 0)
+			require.Nil(t, err)
+			defer view.Close()
+			pairs := viewPairs(t, view)
+			keys := []string{}
+			for _, pair := range pairs {
+				keys = append(keys, string(pair.Key))
+				require.Equal(t, "v"+string(pair.Key), string(pair.Value))
+			}
+			require.Equal(t, tc.keys, keys)
+			for _, key := range []string{"o", "p0", "p/a", "\xff"} {
+				value, err := view.Get([]byte(key))
+				require.Nil(t, err)
+				exists, err := view.Has([]byte(key))
+				require.Nil(t, err)
+				require.Equal(t, exists, value != nil)
+			}
+			require.Equal(t, fmt.Sprint(len(tc.keys)), view.Stats()["materialized.keys"])
+		})
+	}
+}
+
+func TestMaterializedViewManualRefresh(t *testing.T) {
+	src := dbm.NewMemDB()
+	require.Nil(t, src.Set([]byte("p/a"), []byte("1")))
+	view, err := NewMaterializedView(src, []byte("p/"), 0)
+	require.Nil(t, err)
+	defer view.Close()
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	view.now = clock.Now
+
+	require.Nil(t, src.Set([]byte("p/a"), []byte("2")))
+	value, err := view.Get([]byte("p/a"))
+	require.Nil(t, err)
+	require.Equal(t, "1", string(value))
+
+	require.Nil(t, view.Refresh(context.Background()))
+	value, err = view.Get([]byte("p/a"))
+	require.Nil(t, err)
+	require.Equal(t, "2", string(value))
+	require.Equal(t, clock.Now(), view.LastRefresh())
+
+	// failed refreshes keep serving the previous keys
+	require.Nil(t, src.Set([]byte("p/a"), []byte("3")))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	clock.Advance(time.Second)
+	require.ErrorIs(t, view.Refresh(ctx), context.Canceled)
+	value, err = view.Get([]byte("p/a"))
+	require.Nil(t, err)
+	require.Equal(t, "2", string(value))
+	require.Equal(t, time.Unix(100, 0), view.LastRefresh())
+	require.Equal(t, context.Canceled.Error(), view.Stats()["materialized.refresh_error"])
+}
+
+func TestMaterializedViewStaleness(t *testing.T) {
+	src := dbm.NewMemDB()
+	require.Nil(t, src.Set([]byte("p/a"), []byte("1")))
+	view, err := NewMaterializedView(src, []byte("p/"), 10*time.Millisecond)
+	require.Nil(t, err)
+
+	require.Nil(t, src.Set([]byte("p/a"), []byte("2")))
+	written := time.Now()
+	require.Eventually(t, func() bool {
+		value, err := view.Get([]byte("p/a"))
+		return err == nil && string(value) == "2"
+	}, time.Second, time.Millisecond)
+	require.True(t, view.LastRefresh().After(written))
+
+	// refreshes stop once closed
+	require.Nil(t, view.Close())
+	require.Nil(t, view.Close())
+	require.Nil(t, src.Set([]byte("p/a"), []byte("3")))
+	time.Sleep(50 * time.Millisecond)
+	value, err := view.Get([]byte("p/a"))
+	require.Nil(t, err)
+	require.Equal(t, "2", string(value))
+}
+
+func TestMaterializedViewAtomicSwap(t *testing.T) {
+	levelDB, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer levelDB.Close()
+	src := NewGoLevelDBSnapshotter(levelDB)
+	writeGeneration := func(generation int) {
+		batch := src.NewBatch()
+		defer batch.Close()
+		for i := 0; i < 100; i++ {
+			require.Nil(t, batch.Set([]byte(fmt.Sprintf("p/%03d", i)), []byte(fmt.Sprint(generation))))
+		}
+		require.Nil(t, batch.Write())
+	}
+	writeGeneration(0)
+	view, err := NewMaterializedView(src, []byte("p/"), time.Millisecond)
+	require.Nil(t, err)
+	defer view.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				pairs := viewPairs(t, view)
+				require.Len(t, pairs, 100)
+				for _, pair := range pairs {
+					require.Equal(t, string(pairs[0].Value), string(pair.Value))
+				}
+			}
+		}()
+	}
+	for generation := 1; generation <= 50; generation++ {
+		writeGeneration(generation)
+		require.Nil(t, view.Refresh(context.Background()))
+	}
+	close(done)
+	wg.Wait()
+	value, err := view.Get([]byte("p/042"))
+	require.Nil(t, err)
+	require.Equal(t, "50", string(value))
+}
+
+func TestMaterializedViewReadOnly(t *testing.T) {
+	view, err := NewMaterializedView(dbm.NewMemDB(), []byte("p/"), 0)
+	require.Nil(t, err)
+	defer view.Close()
+	require.ErrorIs(t, view.Set([]byte("p/a"), []byte("1")), ErrReadOnlyView)
+	require.ErrorIs(t, view.SetSync([]byte("p/a"), []byte("1")), ErrReadOnlyView)
+	require.ErrorIs(t, view.Delete([]byte("p/a")), ErrReadOnlyView)
+	require.ErrorIs(t, view.DeleteSync([]byte("p/a")), ErrReadOnlyView)
+	batch := view.NewBatch()
+	defer batch.Close()
+	require.ErrorIs(t, batch.Set([]byte("p/a"), []byte("1")), ErrReadOnlyView)
+	require.ErrorIs(t, batch.Write(), ErrReadOnlyView)
+}
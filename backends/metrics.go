@@ -0,0 +1,328 @@
+package backends
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+const (
+	OpGet             = "get"
+	OpHas             = "has"
+	OpSet             = "set"
+	OpSetSync         = "set_sync"
+	OpDelete          = "delete"
+	OpDeleteSync      = "delete_sync"
+	OpIterator        = "iterator"
+	OpReverseIterator = "reverse_iterator"
+	OpBatchWrite      = "batch_write"
+	OpBatchWriteSync  = "batch_write_sync"
+)
+
+// DefaultLatencyBuckets are exponentially growing upper bounds from 50us to
+// roughly 6.5s, which keeps the relative error of each bucket constant
+// similar to HDR histograms.
+var DefaultLatencyBuckets = exponentialBuckets(50*time.Microsecond, 2, 18)
+
+func exponentialBuckets(start time.Duration, factor float64, count int) []time.Duration {
+	buckets := make([]time.Duration, count)
+	bound := float64(start)
+	for i := range buckets {
+		buckets[i] = time.Duration(bound)
+		bound *= factor
+	}
+	return buckets
+}
+
+type MetricsOptions struct {
+	// Backend is reported alongside slow operations.
+	Backend string
+	// LatencyBuckets are the sorted upper bounds of the latency histogram
+	// buckets. Defaults to DefaultLatencyBuckets.
+	LatencyBuckets []time.Duration
+	// SlowOpThreshold is the duration above which OnSlowOp is invoked.
+	// Zero disables slow operation reporting.
+	SlowOpThreshold time.Duration
+	OnSlowOp        func(SlowOp)
+	// SlowOpMinInterval is the minimum interval between two OnSlowOp
+	// invocations. Slow operations in between are only counted.
+	SlowOpMinInterval time.Duration
+	// RedactKeys replaces keys reported to OnSlowOp with their sha256.
+	RedactKeys bool
+	// TrackReadAmplification records how many bytes the storage layer reads
+	// per Get, for backends able to tell. See ReadAmplification.
+	TrackReadAmplification bool
+	// ReadAmplificationBuckets are the sorted upper bounds of the read
+	// amplification histogram buckets. Defaults to
+	// DefaultReadAmplificationBuckets.
+	ReadAmplificationBuckets []float64
+	// TrackWriteAmplification records the logical and physical bytes
+	// written per batch write, wrapping batches into StatsBatches unless
+	// they already are. See BatchWriteTotals.
+	TrackWriteAmplification bool
+}
+
+type SlowOp struct {
+	Op       string
+	Key      []byte
+	Duration time.Duration
+	Backend  string
+}
+
+// LatencyHistogram is a snapshot of the latencies recorded for an operation.
+// Counts has one more element than Bounds, the last one counting
+// observations above the largest bound.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Quantile returns the upper bound of the bucket containing the q-th
+// quantile, or the largest bound if it falls in the overflow bucket.
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	seen := uint64(0)
+	for i, count := range h.Counts {
+		seen += count
+		if seen > rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+type latencyHistogram struct {
+	mtx sync.Mutex
+	LatencyHistogram
+}
+
+func newLatencyHistogram(bounds []time.Duration) *latencyHistogram {
+	return &latencyHistogram{LatencyHistogram: LatencyHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	idx := sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.Counts[idx]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	res := h.LatencyHistogram
+	res.Counts = append([]uint64{}, h.Counts...)
+	return res
+}
+
+// MetricsDB wraps a DB and records latency histograms for every operation,
+// optionally reporting individual slow operations.
+type MetricsDB struct {
+	dbm.DB
+	opts       MetricsOptions
+	histograms map[string]*latencyHistogram
+	now        func() time.Time
+
+	amplification *amplificationHistogram
+	writes        batchWriteTotals
+
+	slowMtx        sync.Mutex
+	lastSlowReport time.Time
+	slowOps        uint64
+	suppressedOps  uint64
+}
+
+var _ dbm.DB = (*MetricsDB)(nil)
+
+func NewMetricsDB(db dbm.DB, opts MetricsOptions) *MetricsDB {
+	if len(opts.LatencyBuckets) == 0 {
+		opts.LatencyBuckets = DefaultLatencyBuckets
+	}
+	if len(opts.ReadAmplificationBuckets) == 0 {
+		opts.ReadAmplificationBuckets = DefaultReadAmplificationBuckets
+	}
+	histograms := map[string]*latencyHistogram{}
+	for _, op := range []string{
+		OpGet, OpHas, OpSet, OpSetSync, OpDelete, OpDeleteSync,
+		OpIterator, OpReverseIterator, OpBatchWrite, OpBatchWriteSync,
+	} {
+		histograms[op] = newLatencyHistogram(opts.LatencyBuckets)
+	}
+	return &MetricsDB{
+		DB:         db,
+		opts:       opts,
+		histograms: histograms,
+		now:        time.Now,
+
+		amplification: newAmplificationHistogram(opts.ReadAmplificationBuckets),
+	}
+}
+
+// Unwrap implements Unwrapper.
+func (m *MetricsDB) Unwrap() dbm.DB {
+	return m.DB
+}
+
+// Histogram returns a snapshot of the latency histogram of op, an empty one
+// if op isn't an operation of the DB.
+func (m *MetricsDB) Histogram(op string) LatencyHistogram {
+	h, ok := m.histograms[op]
+	if !ok {
+		return LatencyHistogram{}
+	}
+	return h.snapshot()
+}
+
+func (m *MetricsDB) observe(op string, key []byte, start time.Time) {
+	elapsed := m.now().Sub(start)
+	m.histograms[op].observe(elapsed)
+	if m.opts.SlowOpThreshold == 0 || elapsed < m.opts.SlowOpThreshold {
+		return
+	}
+	m.slowMtx.Lock()
+	m.slowOps++
+	now := m.now()
+	if !m.lastSlowReport.IsZero() && now.Sub(m.lastSlowReport) < m.opts.SlowOpMinInterval {
+		m.suppressedOps++
+		m.slowMtx.Unlock()
+		return
+	}
+	m.lastSlowReport = now
+	m.slowMtx.Unlock()
+	if m.opts.OnSlowOp == nil {
+		return
+	}
+	var reportedKey []byte
+	if m.opts.RedactKeys {
+		hash := sha256.Sum256(key)
+		reportedKey = hash[:]
+	} else if key != nil {
+		reportedKey = append([]byte{}, key...)
+	}
+	m.opts.OnSlowOp(SlowOp{Op: op, Key: reportedKey, Duration: elapsed, Backend: m.opts.Backend})
+}
+
+// Get implements DB.
+func (m *MetricsDB) Get(key []byte) ([]byte, error) {
+	defer m.observe(OpGet, key, m.now())
+	if m.opts.TrackReadAmplification {
+		return m.accountedGet(key)
+	}
+	return m.DB.Get(key)
+}
+
+// Has implements DB.
+func (m *MetricsDB) Has(key []byte) (bool, error) {
+	defer m.observe(OpHas, key, m.now())
+	return m.DB.Has(key)
+}
+
+// Set implements DB.
+func (m *MetricsDB) Set(key []byte, value []byte) error {
+	defer m.observe(OpSet, key, m.now())
+	return m.DB.Set(key, value)
+}
+
+// SetSync implements DB.
+func (m *MetricsDB) SetSync(key []byte, value []byte) error {
+	defer m.observe(OpSetSync, key, m.now())
+	return m.DB.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (m *MetricsDB) Delete(key []byte) error {
+	defer m.observe(OpDelete, key, m.now())
+	return m.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (m *MetricsDB) DeleteSync(key []byte) error {
+	defer m.observe(OpDeleteSync, key, m.now())
+	return m.DB.DeleteSync(key)
+}
+
+// Iterator implements DB.
+func (m *MetricsDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	defer m.observe(OpIterator, start, m.now())
+	return m.DB.Iterator(start, end)
+}
+
+// ReverseIterator implements DB.
+func (m *MetricsDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	defer m.observe(OpReverseIterator, start, m.now())
+	return m.DB.ReverseIterator(start, end)
+}
+
+// NewBatch implements DB.
+func (m *MetricsDB) NewBatch() dbm.Batch {
+	if m.opts.TrackWriteAmplification {
+		return &metricsBatch{Batch: NewStatsBatch(m.DB), db: m}
+	}
+	return &metricsBatch{Batch: m.DB.NewBatch(), db: m}
+}
+
+// Stats implements DB.
+func (m *MetricsDB) Stats() map[string]string {
+	stats := m.DB.Stats()
+	if stats == nil {
+		stats = map[string]string{}
+	}
+	for op, h := range m.histograms {
+		snapshot := h.snapshot()
+		stats[fmt.Sprintf("metrics.%s.count", op)] = fmt.Sprint(snapshot.Count)
+		stats[fmt.Sprintf("metrics.%s.p50", op)] = snapshot.Quantile(0.5).String()
+		stats[fmt.Sprintf("metrics.%s.p99", op)] = snapshot.Quantile(0.99).String()
+	}
+	if m.opts.TrackReadAmplification {
+		amplification := m.amplification.snapshot()
+		stats["metrics.bytes_read"] = fmt.Sprint(amplification.BytesRead)
+		stats["metrics.read_amplification.p50"] = fmt.Sprint(amplification.Quantile(0.5))
+		stats["metrics.read_amplification.p99"] = fmt.Sprint(amplification.Quantile(0.99))
+	}
+	if m.opts.TrackWriteAmplification {
+		writes := m.BatchWriteTotals()
+		stats["metrics.bytes_written"] = fmt.Sprint(writes.LogicalBytes)
+		stats["metrics.physical_bytes_written"] = fmt.Sprint(writes.PhysicalBytes)
+		stats["metrics.write_amplification"] = fmt.Sprint(writes.WriteAmplification())
+	}
+	m.slowMtx.Lock()
+	defer m.slowMtx.Unlock()
+	stats["metrics.slow_ops"] = fmt.Sprint(m.slowOps)
+	stats["metrics.slow_ops_suppressed"] = fmt.Sprint(m.suppressedOps)
+	return stats
+}
+
+type metricsBatch struct {
+	dbm.Batch
+	db *MetricsDB
+}
+
+func (b *metricsBatch) Write() error {
+	defer b.db.observe(OpBatchWrite, nil, b.db.now())
+	if batch, ok := b.Batch.(StatsBatch); ok {
+		return b.db.writes.record(batch.WriteWithStats())
+	}
+	return b.Batch.Write()
+}
+
+func (b *metricsBatch) WriteSync() error {
+	defer b.db.observe(OpBatchWriteSync, nil, b.db.now())
+	if batch, ok := b.Batch.(StatsBatch); ok {
+		return b.db.writes.record(batch.WriteSyncWithStats())
+	}
+	return b.Batch.WriteSync()
+}
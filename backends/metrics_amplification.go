@@ -0,0 +1,131 @@
+package backends
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// DefaultReadAmplificationBuckets are upper bounds of the read amplification
+// histogram buckets, from 1 to 4096.
+var DefaultReadAmplificationBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096}
+
+// ReadAccounter is implemented by DBs able to tell how many bytes their
+// storage layer read to serve a Get.
+type ReadAccounter interface {
+	GetWithBytesRead(key []byte) ([]byte, uint64, error)
+}
+
+// AmplificationHistogram is a snapshot of the read amplification, bytes read
+// by the storage layer over the size of the value returned, of Gets. Counts
+// has one more element than Bounds, the last one counting observations above
+// the largest bound.
+type AmplificationHistogram struct {
+	Bounds     []float64
+	Counts     []uint64
+	Count      uint64
+	BytesRead  uint64
+	ValueBytes uint64
+}
+
+// Quantile returns the upper bound of the bucket containing the q-th
+// quantile, or the largest bound if it falls in the overflow bucket.
+func (h AmplificationHistogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	seen := uint64(0)
+	for i, count := range h.Counts {
+		seen += count
+		if seen > rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+type amplificationHistogram struct {
+	mtx sync.Mutex
+	AmplificationHistogram
+}
+
+func newAmplificationHistogram(bounds []float64) *amplificationHistogram {
+	return &amplificationHistogram{AmplificationHistogram: AmplificationHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}}
+}
+
+// observe records a Get which read bytesRead bytes to return a value of
+// valueSize bytes. Missing and empty values count as one byte.
+func (h *amplificationHistogram) observe(bytesRead uint64, valueSize int) {
+	divisor := valueSize
+	if divisor == 0 {
+		divisor = 1
+	}
+	ratio := float64(bytesRead) / float64(divisor)
+	idx := sort.Search(len(h.Bounds), func(i int) bool { return ratio <= h.Bounds[i] })
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.Counts[idx]++
+	h.Count++
+	h.BytesRead += bytesRead
+	h.ValueBytes += uint64(valueSize)
+}
+
+func (h *amplificationHistogram) snapshot() AmplificationHistogram {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	res := h.AmplificationHistogram
+	res.Counts = append([]uint64{}, h.Counts...)
+	return res
+}
+
+// ReadAmplification returns a snapshot of the read amplification histogram.
+// It is empty unless MetricsOptions.TrackReadAmplification is set.
+func (m *MetricsDB) ReadAmplification() AmplificationHistogram {
+	return m.amplification.snapshot()
+}
+
+// accountedGet performs a Get, attributing to it the bytes read by the
+// storage layer when the wrapped DB can tell:
+//   - ReadAccounters report them exactly,
+//   - goleveldb's cumulative IO read counter is sampled around the Get, so
+//     concurrent operations are attributed to it too,
+//   - memdb reads exactly the value.
+func (m *MetricsDB) accountedGet(key []byte) ([]byte, error) {
+	switch db := m.DB.(type) {
+	case ReadAccounter:
+		value, bytesRead, err := db.GetWithBytesRead(key)
+		if err == nil {
+			m.amplification.observe(bytesRead, len(value))
+		}
+		return value, err
+	case *dbm.GoLevelDB:
+		before := goLevelDBBytesRead(db)
+		value, err := db.Get(key)
+		if err == nil {
+			m.amplification.observe(goLevelDBBytesRead(db)-before, len(value))
+		}
+		return value, err
+	case *dbm.MemDB:
+		value, err := db.Get(key)
+		if err == nil {
+			m.amplification.observe(uint64(len(value)), len(value))
+		}
+		return value, err
+	default:
+		return m.DB.Get(key)
+	}
+}
+
+func goLevelDBBytesRead(db *dbm.GoLevelDB) uint64 {
+	stats := leveldb.DBStats{}
+	if err := db.DB().Stats(&stats); err != nil {
+		return 0
+	}
+	return stats.IORead
+}
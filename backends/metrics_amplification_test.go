@@ -0,0 +1,75 @@
+package backends
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestReadAmplificationArweave(t *testing.T) {
+	index := mockIndex([]string{"b"}, []int{0})
+	payload := mockTxData([]string{"a", "b"}, []string{"value", "other"})
+	mockDB := NewMockArweaveDB([][]byte{index}, [][]byte{payload}, []int{0})
+	db := NewMetricsDB(mockDB, MetricsOptions{TrackReadAmplification: true})
+
+	v0Bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(v0Bz, 0)
+	value, err := db.Get(append(v0Bz, []byte("a")...))
+	require.Nil(t, err)
+	require.Equal(t, "value", string(value))
+
+	h := db.ReadAmplification()
+	require.Equal(t, uint64(1), h.Count)
+	require.Equal(t, uint64(len(index)+len(payload)), h.BytesRead)
+	require.Equal(t, uint64(5), h.ValueBytes)
+	// (172 + 25) / 5 ~= 39
+	require.Equal(t, float64(64), h.Quantile(0.5))
+	require.Equal(t, "197", db.Stats()["metrics.bytes_read"])
+
+	// failed Gets aren't attributed
+	_, err = db.Get(append(v0Bz, []byte("c")...))
+	require.NotNil(t, err)
+	require.Equal(t, uint64(1), db.ReadAmplification().Count)
+}
+
+func TestReadAmplificationMemDB(t *testing.T) {
+	memDB := dbm.NewMemDB()
+	require.Nil(t, memDB.Set([]byte("k"), []byte("vvvv")))
+	db := NewMetricsDB(memDB, MetricsOptions{TrackReadAmplification: true})
+	_, err := db.Get([]byte("k"))
+	require.Nil(t, err)
+	_, err = db.Get([]byte("missing"))
+	require.Nil(t, err)
+
+	h := db.ReadAmplification()
+	require.Equal(t, uint64(2), h.Count)
+	require.Equal(t, uint64(4), h.BytesRead)
+	require.Equal(t, []uint64{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, h.Counts)
+
+	// disabled by default
+	db = NewMetricsDB(memDB, MetricsOptions{})
+	_, err = db.Get([]byte("k"))
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), db.ReadAmplification().Count)
+	require.NotContains(t, db.Stats(), "metrics.bytes_read")
+}
+
+func TestReadAmplificationGoLevelDB(t *testing.T) {
+	levelDB, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer levelDB.Close()
+	require.Nil(t, levelDB.Set([]byte("k"), []byte("v")))
+	db := NewMetricsDB(levelDB, MetricsOptions{TrackReadAmplification: true})
+	value, err := db.Get([]byte("k"))
+	require.Nil(t, err)
+	require.Equal(t, "v", string(value))
+	require.Equal(t, uint64(1), db.ReadAmplification().Count)
+
+	// wrappers hiding the backend aren't attributed
+	db = NewMetricsDB(NewChecksumDB(levelDB), MetricsOptions{TrackReadAmplification: true})
+	_, err = db.Get([]byte("missing"))
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), db.ReadAmplification().Count)
+}
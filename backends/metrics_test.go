@@ -0,0 +1,120 @@
+package backends
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// slowDB advances a fake clock by a fixed latency on every Get, simulating a
+// slow backend without sleeping.
+type slowDB struct {
+	dbm.DB
+	clock   *fakeClock
+	latency time.Duration
+}
+
+func (db *slowDB) Get(key []byte) ([]byte, error) {
+	db.clock.Advance(db.latency)
+	return db.DB.Get(key)
+}
+
+func newSlowMetricsDB(latency time.Duration, opts MetricsOptions) (*MetricsDB, *slowDB, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	slow := &slowDB{DB: dbm.NewMemDB(), clock: clock, latency: latency}
+	db := NewMetricsDB(slow, opts)
+	db.now = clock.Now
+	return db, slow, clock
+}
+
+func TestMetricsDBHistogram(t *testing.T) {
+	db, slow, _ := newSlowMetricsDB(3*time.Millisecond, MetricsOptions{
+		LatencyBuckets: []time.Duration{time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond},
+	})
+	require.Nil(t, db.Set([]byte("a"), []byte("1")))
+	for i := 0; i < 3; i++ {
+		_, err := db.Get([]byte("a"))
+		require.Nil(t, err)
+	}
+	slow.latency = 20 * time.Millisecond
+	_, err := db.Get([]byte("a"))
+	require.Nil(t, err)
+
+	h := db.Histogram(OpGet)
+	require.Equal(t, []uint64{0, 3, 0, 1}, h.Counts)
+	require.Equal(t, uint64(4), h.Count)
+	require.Equal(t, 29*time.Millisecond, h.Sum)
+	require.Equal(t, 5*time.Millisecond, h.Quantile(0.5))
+	require.Equal(t, 10*time.Millisecond, h.Quantile(0.99))
+	require.Equal(t, uint64(1), db.Histogram(OpSet).Count)
+	require.Equal(t, "4", db.Stats()["metrics.get.count"])
+}
+
+func TestMetricsDBHistogramUnknownOp(t *testing.T) {
+	db := NewMetricsDB(dbm.NewMemDB(), MetricsOptions{})
+	h := db.Histogram("unknown")
+	require.Equal(t, uint64(0), h.Count)
+	require.Equal(t, time.Duration(0), h.Quantile(0.5))
+}
+
+func TestMetricsDBSlowOp(t *testing.T) {
+	reported := []SlowOp{}
+	db, slow, clock := newSlowMetricsDB(time.Millisecond, MetricsOptions{
+		Backend:           "memdb",
+		SlowOpThreshold:   100 * time.Millisecond,
+		SlowOpMinInterval: time.Second,
+		OnSlowOp:          func(op SlowOp) { reported = append(reported, op) },
+	})
+	key := []byte("key")
+	_, err := db.Get(key)
+	require.Nil(t, err)
+	require.Empty(t, reported)
+
+	slow.latency = 500 * time.Millisecond
+	_, err = db.Get(key)
+	require.Nil(t, err)
+	require.Equal(t, []SlowOp{{Op: OpGet, Key: key, Duration: 500 * time.Millisecond, Backend: "memdb"}}, reported)
+	// the reported key must be a copy
+	reported[0].Key[0] = 'x'
+	require.Equal(t, "key", string(key))
+
+	// rate limited
+	_, err = db.Get(key)
+	require.Nil(t, err)
+	require.Len(t, reported, 1)
+	require.Equal(t, "2", db.Stats()["metrics.slow_ops"])
+	require.Equal(t, "1", db.Stats()["metrics.slow_ops_suppressed"])
+
+	clock.Advance(time.Second)
+	_, err = db.Get(key)
+	require.Nil(t, err)
+	require.Len(t, reported, 2)
+}
+
+func TestMetricsDBSlowOpRedacted(t *testing.T) {
+	reported := []SlowOp{}
+	db, _, _ := newSlowMetricsDB(time.Second, MetricsOptions{
+		SlowOpThreshold: time.Millisecond,
+		RedactKeys:      true,
+		OnSlowOp:        func(op SlowOp) { reported = append(reported, op) },
+	})
+	_, err := db.Get([]byte("secret"))
+	require.Nil(t, err)
+	hash := sha256.Sum256([]byte("secret"))
+	require.Equal(t, hash[:], reported[0].Key)
+}
@@ -0,0 +1,388 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+var migrationProgressKey = mustReserveSubspace("migration").Key([]byte("progress"))
+
+type MigrationPhase string
+
+const (
+	// MigrationCopy copies the source to the destination.
+	MigrationCopy MigrationPhase = "copy"
+	// MigrationMirror copies the keys written since the migration started.
+	MigrationMirror MigrationPhase = "mirror"
+	// MigrationVerify compares a sample of keys of both DBs.
+	MigrationVerify MigrationPhase = "verify"
+	// MigrationSwitchOver quiesces writes, copies the last written keys and
+	// invokes the switch-over hook.
+	MigrationSwitchOver MigrationPhase = "switch_over"
+	MigrationDone       MigrationPhase = "done"
+)
+
+const (
+	defaultMigrationCopyBatchSize  = 1000
+	defaultMigrationDrainThreshold = 100
+	defaultMigrationVerifyFraction = 0.01
+	maxMigrationVerifyAttempts     = 3
+	migrationVerifyRetryInterval   = 10 * time.Millisecond
+)
+
+// MigrationPlan describes the migration of the source of a MirrorDB to its
+// destination. The application must write to the source through Mirror
+// for the whole migration.
+type MigrationPlan struct {
+	Mirror *MirrorDB
+	// Progress persists the progress of the migration, so that an
+	// interrupted migration resumes where it stopped. It should be the
+	// journal of Mirror, if any.
+	Progress dbm.DB
+	// number of keys copied per destination batch, defaults to 1000
+	CopyBatchSize int
+	// number of workers of MigrationCopy, each copying a shard of the
+	// source as split by SampleSplitPoints, defaults to 1
+	Parallelism int
+	// number of keys left to mirror under which writes are quiesced for the
+	// switch-over, defaults to 100
+	DrainThreshold int
+	// fraction of keys compared by MigrationVerify, defaults to 1%
+	VerifyFraction float64
+	// Quiesce pauses writes to the source during the switch-over, if set.
+	// Otherwise, writes made during the switch-over might not be mirrored.
+	Quiesce QuiesceHook
+	// SwitchOver is invoked once the destination has caught up with the
+	// source, while writes are quiesced, to make the application use the
+	// destination.
+	SwitchOver func(ctx context.Context) error
+}
+
+type MigrationReport struct {
+	// phases run by this call, excluding those completed by earlier ones
+	Phases       []MigrationPhase
+	KeysCopied   int
+	KeysVerified int
+	Duration     time.Duration
+}
+
+type migrationProgress struct {
+	Phase MigrationPhase `json:"phase"`
+	// first key left to copy, during MigrationCopy
+	NextKey []byte `json:"next_key,omitempty"`
+	// shards copied concurrently from NextKey on, during MigrationCopy
+	Shards []migrationShard `json:"shards,omitempty"`
+}
+
+type migrationShard struct {
+	// first key left to copy
+	Next []byte `json:"next,omitempty"`
+	// nil for the last shard
+	End  []byte `json:"end,omitempty"`
+	Done bool   `json:"done,omitempty"`
+}
+
+// Migrate copies the source of plan.Mirror to its destination while the
+// application keeps writing to it, then switches the application over. The
+// phases are run in order, each of them emitting EventMigrationProgress
+// events, and a migration interrupted by a failure or a restart resumes
+// with the phase it stopped at. On failure, mirroring is stopped so that
+// the destination isn't written to anymore.
+func Migrate(ctx context.Context, plan MigrationPlan) (report MigrationReport, err error) {
+	start := time.Now()
+	if plan.CopyBatchSize <= 0 {
+		plan.CopyBatchSize = defaultMigrationCopyBatchSize
+	}
+	if plan.DrainThreshold <= 0 {
+		plan.DrainThreshold = defaultMigrationDrainThreshold
+	}
+	if plan.VerifyFraction <= 0 {
+		plan.VerifyFraction = defaultMigrationVerifyFraction
+	}
+	progress, err := loadMigrationProgress(plan.Progress)
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		report.Duration = time.Since(start)
+		if err != nil {
+			plan.Mirror.Stop()
+			emitMigrationEvent(progress.Phase, fmt.Sprintf("failed: %s", err))
+		}
+	}()
+
+	phases := []struct {
+		phase MigrationPhase
+		run   func(*migrationProgress) error
+	}{
+		{MigrationCopy, func(progress *migrationProgress) error {
+			return migrateCopy(ctx, plan, progress, &report)
+		}},
+		{MigrationMirror, func(*migrationProgress) error {
+			plan.Mirror.Start()
+			return plan.Mirror.WaitPending(ctx, plan.DrainThreshold)
+		}},
+		{MigrationVerify, func(*migrationProgress) error {
+			return migrateVerify(ctx, plan, &report)
+		}},
+		{MigrationSwitchOver, func(*migrationProgress) error {
+			return migrateSwitchOver(ctx, plan)
+		}},
+	}
+	started := progress.Phase == ""
+	for _, phase := range phases {
+		if !started && progress.Phase != phase.phase {
+			continue
+		}
+		if progress.Phase != phase.phase {
+			progress = migrationProgress{Phase: phase.phase}
+			if err := saveMigrationProgress(plan.Progress, progress); err != nil {
+				return report, err
+			}
+		}
+		started = true
+		emitMigrationEvent(phase.phase, "started")
+		if err := phase.run(&progress); err != nil {
+			return report, fmt.Errorf("migration %s phase: %w", phase.phase, err)
+		}
+		emitMigrationEvent(phase.phase, "finished")
+		report.Phases = append(report.Phases, phase.phase)
+	}
+	progress = migrationProgress{Phase: MigrationDone}
+	return report, saveMigrationProgress(plan.Progress, progress)
+}
+
+func emitMigrationEvent(phase MigrationPhase, detail string) {
+	emitEvent(EventMigrationProgress, "migration", "", fmt.Sprintf("%s %s", phase, detail))
+}
+
+func loadMigrationProgress(store dbm.DB) (migrationProgress, error) {
+	progress := migrationProgress{}
+	if store == nil {
+		return progress, nil
+	}
+	bz, err := store.Get(migrationProgressKey)
+	if err != nil || bz == nil {
+		return progress, err
+	}
+	err = json.Unmarshal(bz, &progress)
+	return progress, err
+}
+
+func saveMigrationProgress(store dbm.DB, progress migrationProgress) error {
+	if store == nil {
+		return nil
+	}
+	bz, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return metadataDB(store).SetSync(migrationProgressKey, bz)
+}
+
+// migrateCopy copies the source in batches, saving the next key to copy
+// after each of them. The reserved namespace is left out, as its metadata
+// describes the source. Keys written meanwhile are recorded by the mirror,
+// which copies them again later.
+func migrateCopy(ctx context.Context, plan MigrationPlan, progress *migrationProgress, report *MigrationReport) error {
+	if plan.Parallelism > 1 || len(progress.Shards) > 0 {
+		return migrateCopyShards(ctx, plan, progress, report)
+	}
+	source, dest := plan.Mirror.DB, plan.Mirror.dest
+	return copyRange(ctx, source, dest, progress.NextKey, nil, plan.CopyBatchSize, func(n int, next []byte) error {
+		report.KeysCopied += n
+		progress.NextKey = next
+		if err := saveMigrationProgress(plan.Progress, *progress); err != nil {
+			return err
+		}
+		emitMigrationEvent(MigrationCopy, fmt.Sprintf("copied %d keys", report.KeysCopied))
+		return nil
+	})
+}
+
+// migrateCopyShards copies the source with one worker per shard, as split
+// by SampleSplitPoints, saving the next key to copy of each shard after
+// each batch. The shards are kept when resuming, whatever the parallelism.
+func migrateCopyShards(ctx context.Context, plan MigrationPlan, progress *migrationProgress, report *MigrationReport) error {
+	source, dest := plan.Mirror.DB, plan.Mirror.dest
+	if len(progress.Shards) == 0 {
+		points, err := SampleSplitPoints(ctx, source, progress.NextKey, nil, plan.Parallelism)
+		if err != nil {
+			return err
+		}
+		for _, bounds := range splitRanges(progress.NextKey, nil, points) {
+			progress.Shards = append(progress.Shards, migrationShard{Next: bounds[0], End: bounds[1]})
+		}
+		if err := saveMigrationProgress(plan.Progress, *progress); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mtx sync.Mutex
+	errs := make(chan error, len(progress.Shards))
+	for i := range progress.Shards {
+		shard := progress.Shards[i]
+		if shard.Done {
+			errs <- nil
+			continue
+		}
+		i := i
+		go func() {
+			err := copyRange(ctx, source, dest, shard.Next, shard.End, plan.CopyBatchSize, func(n int, next []byte) error {
+				mtx.Lock()
+				defer mtx.Unlock()
+				report.KeysCopied += n
+				progress.Shards[i].Next, progress.Shards[i].Done = next, next == nil
+				if err := saveMigrationProgress(plan.Progress, *progress); err != nil {
+					return err
+				}
+				emitMigrationEvent(MigrationCopy, fmt.Sprintf("copied %d keys", report.KeysCopied))
+				return nil
+			})
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}()
+	}
+	var firstErr error
+	for range progress.Shards {
+		if err := <-errs; err != nil && (firstErr == nil || errors.Is(firstErr, context.Canceled)) {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	progress.NextKey, progress.Shards = nil, nil
+	return nil
+}
+
+// copyRange copies [start, end) of source to dest in batches of batchSize
+// keys, calling onBatch with the number of keys of each batch written and
+// the next key to copy, nil once done.
+func copyRange(ctx context.Context, source, dest dbm.DB, start, end []byte, batchSize int, onBatch func(n int, next []byte) error) error {
+	iter, err := Iterate(source, start, end)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for iter.Valid() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch := dest.NewBatch()
+		n := 0
+		for ; iter.Valid() && n < batchSize; iter.Next() {
+			if err := batch.Set(iter.Key(), iter.Value()); err != nil {
+				batch.Close()
+				return err
+			}
+			n++
+		}
+		err := batch.Write()
+		batch.Close()
+		if err != nil {
+			return err
+		}
+		var next []byte
+		if iter.Valid() {
+			next = append([]byte{}, iter.Key()...)
+		}
+		if err := onBatch(n, next); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// migrateVerify compares a sample of the keys of the source and the
+// destination.
+func migrateVerify(ctx context.Context, plan MigrationPlan, report *MigrationReport) error {
+	source, dest := plan.Mirror.DB, plan.Mirror.dest
+	every := 1
+	if plan.VerifyFraction < 1 {
+		every = int(1 / plan.VerifyFraction)
+	}
+	iter, err := Iterate(source, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	// keys written concurrently are only copied once mirrored
+	plan.Mirror.Start()
+	for i := 0; iter.Valid(); i++ {
+		if i%every == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := verifyMigratedKey(ctx, plan.Mirror, source, dest, iter.Key()); err != nil {
+				return err
+			}
+			report.KeysVerified++
+		}
+		iter.Next()
+	}
+	return iter.Error()
+}
+
+// verifyMigratedKey compares key in the source and the destination. Keys
+// still to be mirrored are skipped, and mismatches are checked again in
+// case the key was mirrored between both reads.
+func verifyMigratedKey(ctx context.Context, mirror *MirrorDB, source, dest dbm.DB, key []byte) error {
+	for attempt := 1; ; attempt++ {
+		expected, err := source.Get(key)
+		if err != nil {
+			return err
+		}
+		actual, err := dest.Get(key)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(expected, actual) && (expected == nil) == (actual == nil) {
+			return nil
+		}
+		if mirror.isDirty(key) {
+			return nil
+		}
+		if attempt >= maxMigrationVerifyAttempts {
+			return fmt.Errorf("key %X differs: %X in source, %X in destination", key, expected, actual)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationVerifyRetryInterval):
+		}
+	}
+}
+
+// migrateSwitchOver copies the remaining keys while writes are quiesced,
+// then hands over to the application.
+func migrateSwitchOver(ctx context.Context, plan MigrationPlan) error {
+	if plan.Quiesce != nil {
+		resume, err := plan.Quiesce()
+		if err != nil {
+			return err
+		}
+		defer resume()
+	}
+	plan.Mirror.Start()
+	if err := plan.Mirror.WaitPending(ctx, 0); err != nil {
+		return err
+	}
+	if plan.SwitchOver != nil {
+		if err := plan.SwitchOver(ctx); err != nil {
+			return err
+		}
+	}
+	plan.Mirror.Stop()
+	return nil
+}
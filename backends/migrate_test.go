@@ -0,0 +1,259 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func populate(t *testing.T, db dbm.DB, n int) {
+	for i := 0; i < n; i++ {
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+}
+
+// requireIdentical compares the content of both DBs, leaving metadata out.
+func requireIdentical(t *testing.T, expected, actual dbm.DB) {
+	expectedIter, err := Iterate(expected, nil, nil)
+	require.Nil(t, err)
+	defer expectedIter.Close()
+	actualIter, err := Iterate(actual, nil, nil)
+	require.Nil(t, err)
+	defer actualIter.Close()
+	for ; expectedIter.Valid(); expectedIter.Next() {
+		require.True(t, actualIter.Valid(), "missing %s", expectedIter.Key())
+		require.Equal(t, expectedIter.Key(), actualIter.Key())
+		require.Equal(t, expectedIter.Value(), actualIter.Value())
+		actualIter.Next()
+	}
+	require.False(t, actualIter.Valid())
+}
+
+// concurrentWriter writes to db until stopped.
+type concurrentWriter struct {
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func startWriter(t *testing.T, db dbm.DB, keys int) *concurrentWriter {
+	w := &concurrentWriter{stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(w.stopped)
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; ; i++ {
+			select {
+			case <-w.stop:
+				return
+			default:
+			}
+			key := []byte(fmt.Sprintf("key-%05d", rnd.Intn(keys*2)))
+			var err error
+			switch i % 3 {
+			case 0:
+				err = db.Set(key, []byte(fmt.Sprintf("updated-%d", i)))
+			case 1:
+				err = db.Delete(key)
+			default:
+				batch := db.NewBatch()
+				require.Nil(t, batch.Set(key, []byte(fmt.Sprintf("batched-%d", i))))
+				require.Nil(t, batch.Delete([]byte(fmt.Sprintf("key-%05d", rnd.Intn(keys*2)))))
+				err = batch.Write()
+				batch.Close()
+			}
+			require.Nil(t, err)
+		}
+	}()
+	return w
+}
+
+func (w *concurrentWriter) Stop() {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.stopped
+}
+
+// quiesce stops the writer for good, as the application switches to the
+// destination.
+func (w *concurrentWriter) quiesce() (func(), error) {
+	w.Stop()
+	return func() {}, nil
+}
+
+func TestMigrateUnderConcurrentWrites(t *testing.T) {
+	source, err := dbm.NewGoLevelDB("source", t.TempDir())
+	require.Nil(t, err)
+	defer source.Close()
+	populate(t, source, 2000)
+	dest, journal := dbm.NewMemDB(), dbm.NewMemDB()
+	mirror, err := NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+
+	events := []string{}
+	var eventsMtx sync.Mutex
+	sub := Subscribe(func(event Event) {
+		if event.Kind == EventMigrationProgress {
+			eventsMtx.Lock()
+			events = append(events, event.Detail)
+			eventsMtx.Unlock()
+		}
+	})
+
+	writer := startWriter(t, mirror, 2000)
+	defer writer.Stop()
+	switchedOver := false
+	report, err := Migrate(context.Background(), MigrationPlan{
+		Mirror:         mirror,
+		Progress:       journal,
+		CopyBatchSize:  100,
+		VerifyFraction: 0.1,
+		Quiesce:        writer.quiesce,
+		SwitchOver: func(context.Context) error {
+			switchedOver = true
+			return nil
+		},
+	})
+	require.Nil(t, err)
+	require.True(t, switchedOver)
+	require.Equal(t, []MigrationPhase{MigrationCopy, MigrationMirror, MigrationVerify, MigrationSwitchOver}, report.Phases)
+	require.True(t, report.KeysCopied >= 2000)
+	require.True(t, report.KeysVerified > 0)
+	requireIdentical(t, source, dest)
+	require.Equal(t, 0, mirror.Pending())
+
+	sub.Unsubscribe()
+	require.Contains(t, events, "copy started")
+	require.Contains(t, events, "switch_over finished")
+	copied := 0
+	for _, event := range events {
+		if strings.HasPrefix(event, "copy copied") {
+			copied++
+		}
+	}
+	require.True(t, copied >= 20)
+
+	// completed migrations aren't run again
+	report, err = Migrate(context.Background(), MigrationPlan{Mirror: mirror, Progress: journal})
+	require.Nil(t, err)
+	require.Empty(t, report.Phases)
+}
+
+// failingBatchDB fails batch writes once failAfter batches were written.
+type failingBatchDB struct {
+	dbm.DB
+	mtx       sync.Mutex
+	failAfter int
+	written   int
+}
+
+func (db *failingBatchDB) NewBatch() dbm.Batch {
+	return &failingBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type failingBatch struct {
+	dbm.Batch
+	db *failingBatchDB
+}
+
+func (b *failingBatch) Write() error {
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+	if b.db.written >= b.db.failAfter {
+		return errors.New("disk full")
+	}
+	b.db.written++
+	return b.Batch.Write()
+}
+
+func TestMigrateResumesCopy(t *testing.T) {
+	source := dbm.NewMemDB()
+	populate(t, source, 1000)
+	dest := &failingBatchDB{DB: dbm.NewMemDB(), failAfter: 3}
+	journal := dbm.NewMemDB()
+	mirror, err := NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	plan := MigrationPlan{Mirror: mirror, Progress: journal, CopyBatchSize: 100}
+
+	report, err := Migrate(context.Background(), plan)
+	require.NotNil(t, err)
+	require.Equal(t, 300, report.KeysCopied)
+	require.Nil(t, mirror.Set([]byte("key-00000"), []byte("updated")))
+
+	// restart
+	dest.failAfter = 100
+	mirror, err = NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	require.Equal(t, 1, mirror.Pending())
+	plan.Mirror = mirror
+	report, err = Migrate(context.Background(), plan)
+	require.Nil(t, err)
+	require.Equal(t, 700, report.KeysCopied)
+	requireIdentical(t, source, dest)
+}
+
+func TestMigrateCopiesShardsConcurrently(t *testing.T) {
+	source := dbm.NewMemDB()
+	populate(t, source, 1000)
+	dest := &failingBatchDB{DB: dbm.NewMemDB(), failAfter: 3}
+	journal := dbm.NewMemDB()
+	mirror, err := NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	plan := MigrationPlan{Mirror: mirror, Progress: journal, CopyBatchSize: 100, Parallelism: 4}
+
+	report, err := Migrate(context.Background(), plan)
+	require.NotNil(t, err)
+	copied := report.KeysCopied
+	progress, err := loadMigrationProgress(journal)
+	require.Nil(t, err)
+	require.Len(t, progress.Shards, 4)
+
+	// restart, the shards being resumed whatever the parallelism
+	dest.failAfter = 100
+	mirror, err = NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	plan.Mirror, plan.Parallelism = mirror, 1
+	report, err = Migrate(context.Background(), plan)
+	require.Nil(t, err)
+	require.Equal(t, 1000, copied+report.KeysCopied)
+	requireIdentical(t, source, dest)
+}
+
+func TestMigrateRollsBackMirroring(t *testing.T) {
+	source := dbm.NewMemDB()
+	populate(t, source, 100)
+	dest, journal := dbm.NewMemDB(), dbm.NewMemDB()
+	mirror, err := NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	plan := MigrationPlan{
+		Mirror:   mirror,
+		Progress: journal,
+		SwitchOver: func(context.Context) error {
+			return errors.New("application refused to switch")
+		},
+	}
+	_, err = Migrate(context.Background(), plan)
+	require.NotNil(t, err)
+
+	// the destination isn't written to anymore
+	require.Nil(t, mirror.Set([]byte("late"), []byte("value")))
+	value, err := dest.Get([]byte("late"))
+	require.Nil(t, err)
+	require.Nil(t, value)
+	require.Equal(t, 1, mirror.Pending())
+
+	// retrying resumes with the switch-over
+	plan.SwitchOver = nil
+	report, err := Migrate(context.Background(), plan)
+	require.Nil(t, err)
+	require.Equal(t, []MigrationPhase{MigrationSwitchOver}, report.Phases)
+	requireIdentical(t, source, dest)
+}
@@ -0,0 +1,305 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+var mirrorJournalPrefix = mustReserveSubspace("mirror_journal")
+
+var ErrMirrorStopped = errors.New("mirroring is stopped")
+
+// MirrorDB wraps a source DB, keeping track of the keys written to it so
+// that they can be copied to a destination DB in the background. Keys are
+// copied with their value at the time of copying rather than replayed, so
+// that the destination converges to the source whatever the interleaving of
+// writes and copies.
+type MirrorDB struct {
+	dbm.DB
+	dest dbm.DB
+	// persists the keys left to copy, if set
+	journal dbm.DB
+
+	mtx     sync.Mutex
+	changed *sync.Cond
+	// keys written and not copied yet, in the order they were first written
+	dirty   map[string]struct{}
+	pending []string
+	// key being copied
+	copying  *string
+	mirror   bool
+	stopped  chan struct{}
+	applyErr error
+}
+
+var _ dbm.DB = (*MirrorDB)(nil)
+
+// NewMirrorDB returns a MirrorDB recording the writes to source. They are
+// only copied to dest once Start is called. The keys left to copy are
+// persisted to journal if set, and loaded back from it.
+func NewMirrorDB(source, dest dbm.DB, journal dbm.DB) (*MirrorDB, error) {
+	db := &MirrorDB{DB: source, dest: dest, journal: journal, dirty: map[string]struct{}{}}
+	db.changed = sync.NewCond(&db.mtx)
+	if journal != nil {
+		db.journal = metadataDB(journal)
+		iter, err := dbm.IteratePrefix(db.journal, mirrorJournalPrefix)
+		if err != nil {
+			return nil, err
+		}
+		defer iter.Close()
+		for ; iter.Valid(); iter.Next() {
+			key := string(iter.Key()[len(mirrorJournalPrefix):])
+			db.dirty[key] = struct{}{}
+			db.pending = append(db.pending, key)
+		}
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// Unwrap implements Unwrapper.
+func (db *MirrorDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+func journalKey(key string) []byte {
+	return append(append([]byte{}, mirrorJournalPrefix...), key...)
+}
+
+// touch records keys as to be copied. Metadata isn't mirrored, as it
+// describes the source.
+func (db *MirrorDB) touch(keys ...[]byte) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	var batch dbm.Batch
+	if db.journal != nil {
+		batch = db.journal.NewBatch()
+		defer batch.Close()
+	}
+	added := false
+	for _, key := range keys {
+		if _, ok := db.dirty[string(key)]; ok || IsReserved(key) {
+			continue
+		}
+		db.dirty[string(key)] = struct{}{}
+		db.pending = append(db.pending, string(key))
+		added = true
+		if batch != nil {
+			if err := batch.Set(journalKey(string(key)), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	if !added {
+		return nil
+	}
+	db.changed.Broadcast()
+	if batch != nil {
+		return batch.Write()
+	}
+	return nil
+}
+
+// Start starts copying the written keys to the destination in the
+// background.
+func (db *MirrorDB) Start() {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if db.mirror {
+		return
+	}
+	db.mirror = true
+	db.applyErr = nil
+	db.stopped = make(chan struct{})
+	go db.run(db.stopped)
+}
+
+// Stop stops copying keys, waiting for an ongoing copy. Writes keep being
+// recorded.
+func (db *MirrorDB) Stop() {
+	db.mtx.Lock()
+	if !db.mirror {
+		db.mtx.Unlock()
+		return
+	}
+	db.mirror = false
+	stopped := db.stopped
+	db.changed.Broadcast()
+	db.mtx.Unlock()
+	<-stopped
+}
+
+func (db *MirrorDB) run(stopped chan struct{}) {
+	defer close(stopped)
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	for {
+		for db.mirror && len(db.pending) == 0 {
+			db.changed.Wait()
+		}
+		if !db.mirror {
+			return
+		}
+		key := db.pending[0]
+		db.pending = db.pending[1:]
+		// writes to key from now on must be copied again
+		delete(db.dirty, key)
+		db.copying = &key
+		db.mtx.Unlock()
+		err := db.copyKey(key)
+		db.mtx.Lock()
+		db.copying = nil
+		if err != nil {
+			// retried once mirroring is restarted
+			if _, ok := db.dirty[key]; !ok {
+				db.dirty[key] = struct{}{}
+				db.pending = append([]string{key}, db.pending...)
+			}
+			db.applyErr = err
+			db.mirror = false
+		}
+		db.changed.Broadcast()
+	}
+}
+
+func (db *MirrorDB) copyKey(key string) error {
+	value, err := db.DB.Get([]byte(key))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		err = db.dest.Delete([]byte(key))
+	} else {
+		err = db.dest.Set([]byte(key), value)
+	}
+	if err != nil || db.journal == nil {
+		return err
+	}
+	// under the lock, so as not to drop the entry of a concurrent write
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if _, dirtyAgain := db.dirty[key]; dirtyAgain {
+		return nil
+	}
+	return db.journal.Delete(journalKey(key))
+}
+
+// Pending returns the number of keys left to copy.
+func (db *MirrorDB) Pending() int {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return db.pendingLocked()
+}
+
+func (db *MirrorDB) pendingLocked() int {
+	if db.copying != nil {
+		return len(db.pending) + 1
+	}
+	return len(db.pending)
+}
+
+// WaitPending waits until at most threshold keys are left to copy. It fails
+// if mirroring stops meanwhile, with the error that stopped it if any.
+func (db *MirrorDB) WaitPending(ctx context.Context, threshold int) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			db.mtx.Lock()
+			db.changed.Broadcast()
+			db.mtx.Unlock()
+		case <-stop:
+		}
+	}()
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	for db.pendingLocked() > threshold {
+		if !db.mirror {
+			if db.applyErr != nil {
+				return db.applyErr
+			}
+			return ErrMirrorStopped
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		db.changed.Wait()
+	}
+	return nil
+}
+
+// isDirty returns whether key was written and not copied yet.
+func (db *MirrorDB) isDirty(key []byte) bool {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	_, ok := db.dirty[string(key)]
+	return ok || (db.copying != nil && *db.copying == string(key))
+}
+
+// write records keys as to be copied around the write. Recording them before
+// writing ensures that the journal doesn't miss a write, and recording them
+// again afterwards that a copy made in between doesn't miss the new values.
+func (db *MirrorDB) write(keys [][]byte, write func() error) error {
+	if err := db.touch(keys...); err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	return db.touch(keys...)
+}
+
+// Set implements DB.
+func (db *MirrorDB) Set(key []byte, value []byte) error {
+	return db.write([][]byte{key}, func() error { return db.DB.Set(key, value) })
+}
+
+// SetSync implements DB.
+func (db *MirrorDB) SetSync(key []byte, value []byte) error {
+	return db.write([][]byte{key}, func() error { return db.DB.SetSync(key, value) })
+}
+
+// Delete implements DB.
+func (db *MirrorDB) Delete(key []byte) error {
+	return db.write([][]byte{key}, func() error { return db.DB.Delete(key) })
+}
+
+// DeleteSync implements DB.
+func (db *MirrorDB) DeleteSync(key []byte) error {
+	return db.write([][]byte{key}, func() error { return db.DB.DeleteSync(key) })
+}
+
+// NewBatch implements DB.
+func (db *MirrorDB) NewBatch() dbm.Batch {
+	return &mirrorBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type mirrorBatch struct {
+	dbm.Batch
+	db   *MirrorDB
+	keys [][]byte
+}
+
+func (b *mirrorBatch) Set(key, value []byte) error {
+	b.keys = append(b.keys, append([]byte{}, key...))
+	return b.Batch.Set(key, value)
+}
+
+func (b *mirrorBatch) Delete(key []byte) error {
+	b.keys = append(b.keys, append([]byte{}, key...))
+	return b.Batch.Delete(key)
+}
+
+func (b *mirrorBatch) Write() error {
+	return b.db.write(b.keys, b.Batch.Write)
+}
+
+func (b *mirrorBatch) WriteSync() error {
+	return b.db.write(b.keys, b.Batch.WriteSync)
+}
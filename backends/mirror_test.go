@@ -0,0 +1,75 @@
+package backends
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestMirrorDB(t *testing.T) {
+	source, dest := dbm.NewMemDB(), dbm.NewMemDB()
+	require.Nil(t, dest.Set([]byte("b"), []byte("stale")))
+	mirror, err := NewMirrorDB(source, dest, nil)
+	require.Nil(t, err)
+	require.Nil(t, mirror.Set([]byte("a"), []byte("1")))
+	require.Nil(t, mirror.Delete([]byte("b")))
+	batch := mirror.NewBatch()
+	require.Nil(t, batch.Set([]byte("c"), []byte("3")))
+	require.Nil(t, batch.Write())
+	require.Nil(t, batch.Close())
+	require.Equal(t, 3, mirror.Pending())
+
+	// nothing is copied until started
+	value, err := dest.Get([]byte("a"))
+	require.Nil(t, err)
+	require.Nil(t, value)
+
+	mirror.Start()
+	defer mirror.Stop()
+	require.Nil(t, mirror.WaitPending(context.Background(), 0))
+	requireIdentical(t, source, dest)
+
+	require.Nil(t, mirror.Set([]byte("a"), []byte("updated")))
+	require.Nil(t, mirror.WaitPending(context.Background(), 0))
+	requireIdentical(t, source, dest)
+}
+
+func TestMirrorDBJournal(t *testing.T) {
+	source, dest, journal := dbm.NewMemDB(), dbm.NewMemDB(), dbm.NewMemDB()
+	mirror, err := NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	require.Nil(t, mirror.Set([]byte("a"), []byte("1")))
+	require.Nil(t, mirror.Set([]byte("b"), []byte("2")))
+
+	// restart
+	mirror, err = NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	require.Equal(t, 2, mirror.Pending())
+	mirror.Start()
+	require.Nil(t, mirror.WaitPending(context.Background(), 0))
+	mirror.Stop()
+	requireIdentical(t, source, dest)
+
+	// copied keys are dropped from the journal
+	mirror, err = NewMirrorDB(source, dest, journal)
+	require.Nil(t, err)
+	require.Equal(t, 0, mirror.Pending())
+}
+
+func TestMirrorDBWaitPending(t *testing.T) {
+	mirror, err := NewMirrorDB(dbm.NewMemDB(), dbm.NewMemDB(), nil)
+	require.Nil(t, err)
+	require.Nil(t, mirror.Set([]byte("a"), []byte("1")))
+	require.Nil(t, mirror.WaitPending(context.Background(), 1))
+	require.ErrorIs(t, mirror.WaitPending(context.Background(), 0), ErrMirrorStopped)
+
+	mirror.Start()
+	mirror.Stop()
+	require.Nil(t, mirror.Set([]byte("b"), []byte("2")))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, mirror.WaitPending(ctx, 0), ErrMirrorStopped)
+}
@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"errors"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// MultiHaser is implemented by DBs able to check the existence of many keys
+// at once more efficiently than one by one.
+type MultiHaser interface {
+	// MultiHas returns whether each key exists, in the order of keys. An
+	// error fails the whole call.
+	MultiHas(keys [][]byte) ([]bool, error)
+}
+
+// MultiHas checks the existence of keys in db, natively if db is a
+// MultiHaser and by calling Has for each key otherwise.
+func MultiHas(db dbm.DB, keys [][]byte) ([]bool, error) {
+	if haser, ok := db.(MultiHaser); ok {
+		return haser.MultiHas(keys)
+	}
+	res := make([]bool, len(keys))
+	for i, key := range keys {
+		exists, err := db.Has(key)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = exists
+	}
+	return res, nil
+}
+
+var _ MultiHaser = (*ArweaveDB)(nil)
+
+// MultiHas implements MultiHaser. Every index and payload is fetched at most
+// once per call.
+func (db *ArweaveDB) MultiHas(keys [][]byte) ([]bool, error) {
+	indices := map[uint64][]IndexEntry{}
+	payloads := map[string]map[string]interface{}{}
+	res := make([]bool, len(keys))
+	for i, key := range keys {
+		exists, err := db.multiHasKey(key, indices, payloads)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = exists
+	}
+	return res, nil
+}
+
+func (db *ArweaveDB) multiHasKey(key []byte, indices map[uint64][]IndexEntry, payloads map[string]map[string]interface{}) (bool, error) {
+	version, key, err := db.resolveKey(key)
+	if err != nil {
+		return false, err
+	}
+	index, ok := indices[version]
+	if !ok {
+		// like Has, missing versions and payloads hold no key
+		if index, err = db.getIndex(version); err != nil && !errors.As(err, new(*ErrKeyNotFound)) {
+			return false, err
+		}
+		indices[version] = index
+	}
+	for _, entry := range lookupIndexEntries(string(key), index) {
+		payload, ok := payloads[string(entry.txId)]
+		if !ok {
+			var err error
+			if payload, err = db.getEntryPayload(entry); err != nil && !errors.As(err, new(*ErrKeyNotFound)) {
+				return false, err
+			}
+			payloads[string(entry.txId)] = payload
+		}
+		if _, ok := payload[string(key)]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,111 @@
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func versionedKey(version uint64, key string) []byte {
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, version)
+	return append(bz, key...)
+}
+
+func TestMultiHasArweave(t *testing.T) {
+	indices := [][]byte{
+		mockIndex([]string{"b", "d", "d"}, []int{0, 1, 2}),
+		mockIndex([]string{"z"}, []int{3}),
+	}
+	txData := [][]byte{
+		mockTxData([]string{"a", "b"}, []string{"v", "v"}),
+		mockTxData([]string{"c"}, []string{"v"}),
+		mockTxData([]string{"cc", "d"}, []string{"v", "v"}),
+		mockTxData([]string{"a"}, []string{"v"}),
+	}
+	mockDB := NewMockArweaveDB(indices, txData, []int{0, 1, 2, 3})
+	fetched := countFetches(mockDB)
+
+	keys := [][]byte{
+		versionedKey(0, "a"), versionedKey(0, "b"), versionedKey(0, "bb"), versionedKey(0, "cc"),
+		versionedKey(0, "d"), versionedKey(0, "e"), versionedKey(1, "a"), versionedKey(1, "b"),
+		versionedKey(0, "a"), versionedKey(5, "a"),
+	}
+	res, err := MultiHas(mockDB, keys)
+	require.Nil(t, err)
+	require.Equal(t, []bool{true, true, false, true, true, false, true, false, true, false}, res)
+	for txId, count := range fetched {
+		require.Equal(t, 1, count, txId)
+	}
+
+	for i, key := range keys {
+		exists, err := mockDB.Has(key)
+		require.Nil(t, err)
+		require.Equal(t, res[i], exists)
+	}
+
+	// errors fail the whole call
+	ApplyMiddleware(mockDB, func(next Getter) Getter {
+		return func(key []byte) ([]byte, error) {
+			if string(key) == intToBase64Sha256(2) {
+				return nil, errors.New("unavailable")
+			}
+			return next(key)
+		}
+	})
+	_, err = MultiHas(mockDB, keys)
+	require.NotNil(t, err)
+}
+
+func TestMultiHasFallback(t *testing.T) {
+	db := dbm.NewMemDB()
+	require.Nil(t, db.Set([]byte("a"), []byte{}))
+	require.Nil(t, db.Set([]byte("c"), []byte("v")))
+	res, err := MultiHas(db, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	require.Nil(t, err)
+	require.Equal(t, []bool{true, false, true}, res)
+
+	_, err = MultiHas(db, [][]byte{[]byte("a"), nil})
+	require.NotNil(t, err)
+}
+
+func newMultiHasBenchmarkDB() (*ArweaveDB, [][]byte) {
+	prefixes, indices, txData := []string{}, []int{}, [][]byte{}
+	keys := [][]byte{}
+	for tx := 0; tx < 10; tx++ {
+		txKeys, values := []string{}, []string{}
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("%d/%03d", tx, i)
+			txKeys, values = append(txKeys, key), append(values, "v")
+			keys = append(keys, versionedKey(0, key))
+		}
+		prefixes = append(prefixes, txKeys[len(txKeys)-1])
+		indices = append(indices, tx)
+		txData = append(txData, mockTxData(txKeys, values))
+	}
+	return NewMockArweaveDB([][]byte{mockIndex(prefixes, indices)}, txData, indices), keys
+}
+
+func BenchmarkLoopedHasArweave(b *testing.B) {
+	db, keys := newMultiHasBenchmarkDB()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys {
+			if _, err := db.Has(key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkMultiHasArweave(b *testing.B) {
+	db, keys := newMultiHasBenchmarkDB()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.MultiHas(keys); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+var namespaceStatsPrefix = mustReserveSubspace("namespace_stats")
+
+// RangeDeleter is implemented by backends able to delete a key range at
+// once, without reading it.
+type RangeDeleter interface {
+	DeleteRange(start, end []byte) error
+}
+
+// NSStat are the statistics of a namespace of a NamespaceStatsDB.
+type NSStat struct {
+	Keys int64 `json:"keys"`
+	// Bytes is the size of the keys and values of the namespace.
+	Bytes int64 `json:"bytes"`
+	// Approximate is set once a range deletion was estimated rather than
+	// counted, until the next Rebuild.
+	Approximate bool `json:"approximate,omitempty"`
+}
+
+// persistedNSStat is an NSStat as persisted, along with the prefix it was
+// counted for.
+type persistedNSStat struct {
+	NSStat
+	Prefix []byte `json:"prefix"`
+}
+
+// NamespaceStatsDB wraps a DB, maintaining the number of keys and bytes of
+// registered namespaces as they are written. Statistics are persisted in
+// the reserved namespace along with every write, in the same batch, so that
+// they survive restarts without being counted again. Writes read the
+// values they overwrite or delete, and are serialized. A ReservedGuardDB
+// must wrap it rather than be wrapped.
+type NamespaceStatsDB struct {
+	dbm.DB
+	// names of the namespaces by prefix
+	namespaces map[string][]byte
+
+	mtx   sync.Mutex
+	stats map[string]NSStat
+}
+
+var (
+	_ dbm.DB       = (*NamespaceStatsDB)(nil)
+	_ RangeDeleter = (*NamespaceStatsDB)(nil)
+)
+
+// NewNamespaceStatsDB returns a NamespaceStatsDB maintaining statistics
+// for namespaces, mapping names to key prefixes. Persisted statistics are
+// loaded, and those of namespaces never counted or whose prefix changed are
+// counted right away.
+func NewNamespaceStatsDB(db dbm.DB, namespaces map[string][]byte) (*NamespaceStatsDB, error) {
+	if _, ok := db.(*ReservedGuardDB); ok {
+		return nil, errors.New("namespace statistics must be wrapped by the reserved guard, not wrap it")
+	}
+	statsDB := &NamespaceStatsDB{DB: db, namespaces: map[string][]byte{}, stats: map[string]NSStat{}}
+	batch := db.NewBatch()
+	defer batch.Close()
+	for name, prefix := range namespaces {
+		statsDB.namespaces[name] = append([]byte{}, prefix...)
+		bz, err := db.Get(namespaceStatsPrefix.Key([]byte(name)))
+		if err != nil {
+			return nil, err
+		}
+		if bz != nil {
+			persisted := persistedNSStat{}
+			if err := json.Unmarshal(bz, &persisted); err != nil {
+				return nil, fmt.Errorf("parsing statistics of namespace %s: %w", name, err)
+			}
+			if bytes.Equal(persisted.Prefix, prefix) {
+				statsDB.stats[name] = persisted.NSStat
+				continue
+			}
+		}
+		stat, err := statsDB.count(context.Background(), prefix)
+		if err != nil {
+			return nil, err
+		}
+		statsDB.stats[name] = stat
+		if err := statsDB.persist(batch, name, stat); err != nil {
+			return nil, err
+		}
+	}
+	if err := batch.WriteSync(); err != nil {
+		return nil, err
+	}
+	return statsDB, nil
+}
+
+// Unwrap implements Unwrapper.
+func (db *NamespaceStatsDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+// NamespaceStats returns the statistics of the registered namespaces.
+func (db *NamespaceStatsDB) NamespaceStats() map[string]NSStat {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	stats := make(map[string]NSStat, len(db.stats))
+	for name, stat := range db.stats {
+		stats[name] = stat
+	}
+	return stats
+}
+
+// Rebuild counts the keys and bytes of every namespace again, correcting
+// drift and clearing approximations. Writes wait for it to complete.
+func (db *NamespaceStatsDB) Rebuild(ctx context.Context) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	stats := map[string]NSStat{}
+	batch := db.DB.NewBatch()
+	defer batch.Close()
+	for name, prefix := range db.namespaces {
+		stat, err := db.count(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		stats[name] = stat
+		if err := db.persist(batch, name, stat); err != nil {
+			return err
+		}
+	}
+	if err := batch.WriteSync(); err != nil {
+		return err
+	}
+	db.stats = stats
+	return nil
+}
+
+// count counts the keys and bytes under prefix.
+func (db *NamespaceStatsDB) count(ctx context.Context, prefix []byte) (NSStat, error) {
+	iter, err := dbm.IteratePrefix(db.DB, prefix)
+	if err != nil {
+		return NSStat{}, err
+	}
+	defer iter.Close()
+	stat := NSStat{}
+	for ; iter.Valid(); iter.Next() {
+		if stat.Keys%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return NSStat{}, err
+			}
+		}
+		if IsReserved(iter.Key()) {
+			continue
+		}
+		stat.Keys++
+		stat.Bytes += int64(len(iter.Key()) + len(iter.Value()))
+	}
+	return stat, iter.Error()
+}
+
+func (db *NamespaceStatsDB) persist(batch dbm.Batch, name string, stat NSStat) error {
+	bz, err := json.Marshal(persistedNSStat{NSStat: stat, Prefix: db.namespaces[name]})
+	if err != nil {
+		return err
+	}
+	return batch.Set(namespaceStatsPrefix.Key([]byte(name)), bz)
+}
+
+// statsOp is a write whose statistics are yet to be accounted.
+type statsOp struct {
+	key   []byte
+	value []byte
+	// whether the op deletes key
+	delete bool
+}
+
+// commit writes ops with write, the last op of a key being the one that
+// counts, along with the statistics they update, added to batch.
+func (db *NamespaceStatsDB) commit(batch dbm.Batch, ops []statsOp, write func() error) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	final := map[string]statsOp{}
+	for _, op := range ops {
+		if !IsReserved(op.key) {
+			final[string(op.key)] = op
+		}
+	}
+	updated := map[string]NSStat{}
+	for key, op := range final {
+		names := db.namespacesOf([]byte(key))
+		if len(names) == 0 {
+			continue
+		}
+		old, err := db.DB.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		keys, size := int64(0), int64(0)
+		if old != nil {
+			keys, size = -1, -int64(len(key)+len(old))
+		}
+		if !op.delete {
+			keys, size = keys+1, size+int64(len(key)+len(op.value))
+		}
+		for _, name := range names {
+			stat, ok := updated[name]
+			if !ok {
+				stat = db.stats[name]
+			}
+			stat.Keys += keys
+			stat.Bytes += size
+			updated[name] = stat
+		}
+	}
+	for name, stat := range updated {
+		if err := db.persist(batch, name, stat); err != nil {
+			return err
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	for name, stat := range updated {
+		db.stats[name] = stat
+	}
+	return nil
+}
+
+// namespacesOf returns the names of the namespaces key belongs to.
+func (db *NamespaceStatsDB) namespacesOf(key []byte) []string {
+	names := []string{}
+	for name, prefix := range db.namespaces {
+		if bytes.HasPrefix(key, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// DeleteRange implements RangeDeleter. Ranges are deleted at once by
+// backends implementing RangeDeleter, in which case the statistics of the
+// namespaces partially covered are estimated, assuming keys spread evenly,
+// and flagged as approximate until the next Rebuild. Other backends delete
+// the keys of the range one by one, which are counted.
+func (db *NamespaceStatsDB) DeleteRange(start, end []byte) error {
+	deleter, ok := db.DB.(RangeDeleter)
+	if !ok {
+		return db.deleteRangeByKey(start, end)
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if err := deleter.DeleteRange(start, end); err != nil {
+		return err
+	}
+	batch := db.DB.NewBatch()
+	defer batch.Close()
+	for name, prefix := range db.namespaces {
+		deleted := rangeFraction(prefix, start, end)
+		if deleted == 0 {
+			continue
+		}
+		stat := db.stats[name]
+		if deleted == 1 {
+			stat = NSStat{}
+		} else {
+			stat.Keys -= int64(math.Round(float64(stat.Keys) * deleted))
+			stat.Bytes -= int64(math.Round(float64(stat.Bytes) * deleted))
+			stat.Approximate = true
+		}
+		db.stats[name] = stat
+		if err := db.persist(batch, name, stat); err != nil {
+			return err
+		}
+	}
+	return batch.WriteSync()
+}
+
+func (db *NamespaceStatsDB) deleteRangeByKey(start, end []byte) error {
+	iter, err := db.DB.Iterator(start, end)
+	if err != nil {
+		return err
+	}
+	keys := [][]byte{}
+	for ; iter.Valid(); iter.Next() {
+		if !IsReserved(iter.Key()) {
+			keys = append(keys, append([]byte{}, iter.Key()...))
+		}
+	}
+	err = iter.Error()
+	iter.Close()
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch()
+	defer batch.Close()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	return batch.WriteSync()
+}
+
+// rangeFraction estimates the fraction of the keys under prefix within the
+// range from start to end, positioning keys by the 8 bytes following the
+// prefix.
+func rangeFraction(prefix, start, end []byte) float64 {
+	from, to := 0.0, 1.0
+	if start != nil && bytes.Compare(start, prefix) > 0 {
+		from = keyPosition(prefix, start)
+	}
+	if prefixEnd := prefixEnd(prefix); end != nil && (prefixEnd == nil || bytes.Compare(end, prefixEnd) < 0) {
+		to = keyPosition(prefix, end)
+	}
+	if to <= from {
+		return 0
+	}
+	return to - from
+}
+
+// keyPosition returns the position of key relative to the keys under
+// prefix, from 0 for those sorting before them to 1 for those after.
+func keyPosition(prefix, key []byte) float64 {
+	if !bytes.HasPrefix(key, prefix) {
+		if bytes.Compare(key, prefix) < 0 {
+			return 0
+		}
+		return 1
+	}
+	bz := make([]byte, 8)
+	copy(bz, key[len(prefix):])
+	return float64(binary.BigEndian.Uint64(bz)) / math.Pow(2, 64)
+}
+
+// Set implements DB.
+func (db *NamespaceStatsDB) Set(key []byte, value []byte) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set(key, value); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// SetSync implements DB.
+func (db *NamespaceStatsDB) SetSync(key []byte, value []byte) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set(key, value); err != nil {
+		return err
+	}
+	return batch.WriteSync()
+}
+
+// Delete implements DB.
+func (db *NamespaceStatsDB) Delete(key []byte) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Delete(key); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// DeleteSync implements DB.
+func (db *NamespaceStatsDB) DeleteSync(key []byte) error {
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Delete(key); err != nil {
+		return err
+	}
+	return batch.WriteSync()
+}
+
+// NewBatch implements DB.
+func (db *NamespaceStatsDB) NewBatch() dbm.Batch {
+	return &namespaceStatsBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type namespaceStatsBatch struct {
+	dbm.Batch
+	db  *NamespaceStatsDB
+	ops []statsOp
+}
+
+func (b *namespaceStatsBatch) Set(key, value []byte) error {
+	if err := b.Batch.Set(key, value); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, statsOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+	return nil
+}
+
+func (b *namespaceStatsBatch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, statsOp{key: append([]byte{}, key...), delete: true})
+	return nil
+}
+
+func (b *namespaceStatsBatch) Write() error {
+	return b.db.commit(b.Batch, b.ops, b.Batch.Write)
+}
+
+func (b *namespaceStatsBatch) WriteSync() error {
+	return b.db.commit(b.Batch, b.ops, b.Batch.WriteSync)
+}
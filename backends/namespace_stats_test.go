@@ -0,0 +1,151 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+var testNamespaces = map[string][]byte{"bank": []byte("bank/"), "staking": []byte("staking/"), "all": {}}
+
+// rangeDeletingDB deletes ranges at once.
+type rangeDeletingDB struct {
+	dbm.DB
+}
+
+func (db rangeDeletingDB) DeleteRange(start, end []byte) error {
+	iter, err := db.Iterator(start, end)
+	if err != nil {
+		return err
+	}
+	keys := [][]byte{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	for _, key := range keys {
+		if err := db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countedStats returns the statistics a Rebuild of db would compute.
+func countedStats(t *testing.T, db dbm.DB) map[string]NSStat {
+	stats := map[string]NSStat{}
+	for name, prefix := range testNamespaces {
+		stat := NSStat{}
+		iter, err := dbm.IteratePrefix(db, prefix)
+		require.Nil(t, err)
+		for ; iter.Valid(); iter.Next() {
+			if !IsReserved(iter.Key()) {
+				stat.Keys++
+				stat.Bytes += int64(len(iter.Key()) + len(iter.Value()))
+			}
+		}
+		iter.Close()
+		stats[name] = stat
+	}
+	return stats
+}
+
+func TestNamespaceStatsMixedWorkload(t *testing.T) {
+	base := dbm.NewMemDB()
+	require.Nil(t, base.Set([]byte("bank/existing"), []byte("value")))
+	db, err := NewNamespaceStatsDB(base, testNamespaces)
+	require.Nil(t, err)
+	require.Equal(t, countedStats(t, base), db.NamespaceStats())
+
+	for round := 0; round < 5; round++ {
+		batch := db.NewBatch()
+		for i := 0; i < 20; i++ {
+			require.Nil(t, batch.Set([]byte(fmt.Sprintf("bank/%02d", i)), []byte(fmt.Sprintf("%0*d", round+i, 0))))
+			require.Nil(t, batch.Set([]byte(fmt.Sprintf("staking/%02d", (i*round)%13)), []byte("v")))
+			require.Nil(t, batch.Set([]byte(fmt.Sprintf("other/%02d", i)), []byte("v")))
+			if i%3 == round%3 {
+				require.Nil(t, batch.Delete([]byte(fmt.Sprintf("bank/%02d", i))))
+				require.Nil(t, batch.Delete([]byte(fmt.Sprintf("staking/%02d", i))))
+			}
+		}
+		require.Nil(t, batch.Write())
+		require.Nil(t, batch.Close())
+		require.Nil(t, db.Set([]byte("staking/direct"), []byte(fmt.Sprint(round))))
+		require.Nil(t, db.Delete([]byte("bank/missing")))
+		require.Nil(t, db.DeleteSync([]byte(fmt.Sprintf("bank/%02d", round))))
+		require.Equal(t, countedStats(t, base), db.NamespaceStats(), "round %d", round)
+	}
+
+	// statistics are loaded back rather than counted again
+	require.Nil(t, base.Set([]byte("bank/unaccounted"), []byte("v")))
+	stats := db.NamespaceStats()
+	db, err = NewNamespaceStatsDB(base, testNamespaces)
+	require.Nil(t, err)
+	require.Equal(t, stats, db.NamespaceStats())
+	require.Nil(t, db.Rebuild(context.Background()))
+	require.Equal(t, countedStats(t, base), db.NamespaceStats())
+
+	// as are those of namespaces whose prefix changed
+	db, err = NewNamespaceStatsDB(base, map[string][]byte{"bank": []byte("bank/0")})
+	require.Nil(t, err)
+	keys := int64(0)
+	iter, err := dbm.IteratePrefix(base, []byte("bank/0"))
+	require.Nil(t, err)
+	for ; iter.Valid(); iter.Next() {
+		keys++
+	}
+	iter.Close()
+	require.Equal(t, keys, db.NamespaceStats()["bank"].Keys)
+}
+
+func TestNamespaceStatsRangeDeletion(t *testing.T) {
+	base := dbm.NewMemDB()
+	for i := 0; i < 256; i++ {
+		require.Nil(t, base.Set(append([]byte("bank/"), byte(i)), []byte("v")))
+		require.Nil(t, base.Set(append([]byte("staking/"), byte(i)), []byte("v")))
+	}
+	db, err := NewNamespaceStatsDB(rangeDeletingDB{base}, testNamespaces)
+	require.Nil(t, err)
+
+	// the half of bank is estimated, staking is left alone
+	require.Nil(t, db.DeleteRange([]byte("bank/\x80"), []byte("bank0")))
+	stats := db.NamespaceStats()
+	require.Equal(t, NSStat{Keys: 128, Bytes: 128 * 7, Approximate: true}, stats["bank"])
+	require.Equal(t, NSStat{Keys: 256, Bytes: 256 * 10}, stats["staking"])
+	require.True(t, stats["all"].Approximate)
+
+	// the flag persists until the next rebuild
+	db, err = NewNamespaceStatsDB(rangeDeletingDB{base}, testNamespaces)
+	require.Nil(t, err)
+	require.True(t, db.NamespaceStats()["bank"].Approximate)
+	require.Nil(t, db.Rebuild(context.Background()))
+	require.Equal(t, countedStats(t, base), db.NamespaceStats())
+	require.False(t, db.NamespaceStats()["bank"].Approximate)
+
+	// namespaces deleted as a whole are exact
+	require.Nil(t, db.DeleteRange([]byte("staking/"), nil))
+	require.Equal(t, NSStat{}, db.NamespaceStats()["staking"])
+	require.True(t, db.NamespaceStats()["all"].Approximate)
+
+	// backends without range deletion delete and count keys one by one
+	plain, err := NewNamespaceStatsDB(base, testNamespaces)
+	require.Nil(t, err)
+	require.Nil(t, plain.Rebuild(context.Background()))
+	require.Nil(t, plain.DeleteRange([]byte("bank/\x10"), []byte("bank/\x20")))
+	require.Equal(t, countedStats(t, base), plain.NamespaceStats())
+	require.Equal(t, int64(112), plain.NamespaceStats()["bank"].Keys)
+}
+
+func TestNamespaceStatsRebuildCanceled(t *testing.T) {
+	db, err := NewNamespaceStatsDB(dbm.NewMemDB(), testNamespaces)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, db.Rebuild(ctx), context.Canceled)
+
+	_, err = NewNamespaceStatsDB(NewReservedGuardDB(dbm.NewMemDB()), testNamespaces)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,296 @@
+package backends
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// ReservedNamespace prefixes the keys under which metadata is stored in
+// application DBs, such as format markers or the progress of background
+// jobs. Applications must not write keys starting with it, which
+// ReservedGuardDB enforces.
+var ReservedNamespace = []byte("\x00reserved/")
+
+// Prefix is a subspace of the reserved namespace.
+type Prefix []byte
+
+// Key returns the key suffix is stored under in the subspace.
+func (p Prefix) Key(suffix []byte) []byte {
+	key := make([]byte, 0, len(p)+len(suffix))
+	return append(append(key, p...), suffix...)
+}
+
+// End returns the exclusive end of the subspace.
+func (p Prefix) End() []byte {
+	return prefixEnd(p)
+}
+
+var (
+	subspacesMtx sync.Mutex
+	subspaces    = map[string]struct{}{}
+)
+
+// ReserveSubspace returns the prefix of the subspace name of the reserved
+// namespace. Each name may only be reserved once, so that no two features
+// share keys.
+func ReserveSubspace(name string) (Prefix, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid subspace name %q", name)
+	}
+	subspacesMtx.Lock()
+	defer subspacesMtx.Unlock()
+	if _, ok := subspaces[name]; ok {
+		return nil, &ErrSubspaceReserved{name: name}
+	}
+	subspaces[name] = struct{}{}
+	return Prefix(append(append([]byte{}, ReservedNamespace...), name+"/"...)), nil
+}
+
+func mustReserveSubspace(name string) Prefix {
+	prefix, err := ReserveSubspace(name)
+	if err != nil {
+		panic(err)
+	}
+	return prefix
+}
+
+// IsReserved returns whether key belongs to the reserved namespace.
+func IsReserved(key []byte) bool {
+	return bytes.HasPrefix(key, ReservedNamespace)
+}
+
+// ValidateUserKey fails with ErrReservedKey if an application may not write
+// key.
+func ValidateUserKey(key []byte) error {
+	if IsReserved(key) {
+		return &ErrReservedKey{key: key}
+	}
+	return nil
+}
+
+type iterateConfig struct {
+	includeReserved bool
+	parallelism     int
+}
+
+type IterateOption func(*iterateConfig)
+
+// IncludeReserved makes Iterate, ReverseIterate and Dump include the
+// reserved namespace.
+func IncludeReserved() IterateOption {
+	return func(cfg *iterateConfig) {
+		cfg.includeReserved = true
+	}
+}
+
+// Parallelism makes Dump read db with workers iterators, each over a shard
+// of roughly equal size as split by SampleSplitPoints, writing the shards
+// in order. The shards read ahead of the one being written are buffered in
+// memory. Iterate and ReverseIterate ignore it.
+func Parallelism(workers int) IterateOption {
+	return func(cfg *iterateConfig) {
+		cfg.parallelism = workers
+	}
+}
+
+// Iterate returns an iterator over the domain [start, end) of db, skipping
+// the reserved namespace unless IncludeReserved is passed.
+func Iterate(db dbm.DB, start, end []byte, opts ...IterateOption) (dbm.Iterator, error) {
+	iter, err := db.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return excludeReserved(iter, opts), nil
+}
+
+// ReverseIterate is the reverse counterpart of Iterate.
+func ReverseIterate(db dbm.DB, start, end []byte, opts ...IterateOption) (dbm.Iterator, error) {
+	iter, err := db.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return excludeReserved(iter, opts), nil
+}
+
+func excludeReserved(iter dbm.Iterator, opts []IterateOption) dbm.Iterator {
+	cfg := iterateConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.includeReserved {
+		return iter
+	}
+	userIter := &userIterator{guardedIterator: guardIterator(iter)}
+	userIter.skipReserved()
+	return userIter
+}
+
+// userIterator skips the keys of the reserved namespace.
+type userIterator struct {
+	guardedIterator
+}
+
+func (itr *userIterator) skipReserved() {
+	for itr.Iterator.Valid() && IsReserved(itr.Iterator.Key()) {
+		itr.Iterator.Next()
+	}
+}
+
+// Next implements Iterator.
+func (itr *userIterator) Next() {
+	itr.guardedIterator.Next()
+	itr.skipReserved()
+}
+
+// ReservedGuardDB wraps a DB used by an application, refusing writes to the
+// reserved namespace with ErrReservedKey and hiding it from iterators.
+// Metadata is written to the wrapped DB, so the guard must be the outermost
+// wrapper.
+type ReservedGuardDB struct {
+	dbm.DB
+}
+
+var _ dbm.DB = (*ReservedGuardDB)(nil)
+
+func NewReservedGuardDB(db dbm.DB) *ReservedGuardDB {
+	return &ReservedGuardDB{DB: db}
+}
+
+// Unwrap implements Unwrapper.
+func (db *ReservedGuardDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+// metadataDB returns the DB metadata is written to when given db, that is
+// db itself unless it is guarded.
+func metadataDB(db dbm.DB) dbm.DB {
+	if guard, ok := db.(*ReservedGuardDB); ok {
+		return guard.DB
+	}
+	return db
+}
+
+// Set implements DB.
+func (db *ReservedGuardDB) Set(key []byte, value []byte) error {
+	if err := ValidateUserKey(key); err != nil {
+		return err
+	}
+	return db.DB.Set(key, value)
+}
+
+// SetSync implements DB.
+func (db *ReservedGuardDB) SetSync(key []byte, value []byte) error {
+	if err := ValidateUserKey(key); err != nil {
+		return err
+	}
+	return db.DB.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (db *ReservedGuardDB) Delete(key []byte) error {
+	if err := ValidateUserKey(key); err != nil {
+		return err
+	}
+	return db.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (db *ReservedGuardDB) DeleteSync(key []byte) error {
+	if err := ValidateUserKey(key); err != nil {
+		return err
+	}
+	return db.DB.DeleteSync(key)
+}
+
+// Iterator implements DB.
+func (db *ReservedGuardDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	return Iterate(db.DB, start, end)
+}
+
+// ReverseIterator implements DB.
+func (db *ReservedGuardDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	return ReverseIterate(db.DB, start, end)
+}
+
+// NewBatch implements DB.
+func (db *ReservedGuardDB) NewBatch() dbm.Batch {
+	return &reservedGuardBatch{Batch: db.DB.NewBatch()}
+}
+
+type reservedGuardBatch struct {
+	dbm.Batch
+}
+
+func (b *reservedGuardBatch) Set(key, value []byte) error {
+	if err := ValidateUserKey(key); err != nil {
+		return err
+	}
+	return b.Batch.Set(key, value)
+}
+
+func (b *reservedGuardBatch) Delete(key []byte) error {
+	if err := ValidateUserKey(key); err != nil {
+		return err
+	}
+	return b.Batch.Delete(key)
+}
+
+// legacyMetadataKeys maps the keys metadata was stored under before the
+// reserved namespace was introduced to their current ones. Keys ending with
+// a slash are prefixes.
+var legacyMetadataKeys = []struct {
+	legacy  []byte
+	current []byte
+}{
+	{[]byte("\x00arweave_version_map/"), versionMapPrefix},
+	{[]byte("\x00compaction_scheduler_progress"), compactionProgressKey},
+	{[]byte("\x00format_marker"), formatMarkerKey},
+	{[]byte("\x00arweave_download_budget"), downloadBudgetKey},
+	{[]byte("\x00mirror_dirty/"), mirrorJournalPrefix},
+	{[]byte("\x00migration_progress"), migrationProgressKey},
+}
+
+// MigrateLegacyMetadata moves the metadata stored in db under the keys used
+// before the reserved namespace was introduced, returning the number of keys
+// moved. It is idempotent.
+func MigrateLegacyMetadata(db dbm.DB) (int, error) {
+	db = metadataDB(db)
+	batch := db.NewBatch()
+	defer batch.Close()
+	moved := 0
+	for _, keys := range legacyMetadataKeys {
+		end := append(append([]byte{}, keys.legacy...), 0)
+		if bytes.HasSuffix(keys.legacy, []byte("/")) {
+			end = prefixEnd(keys.legacy)
+		}
+		iter, err := db.Iterator(keys.legacy, end)
+		if err != nil {
+			return moved, err
+		}
+		for ; iter.Valid(); iter.Next() {
+			current := append(append([]byte{}, keys.current...), iter.Key()[len(keys.legacy):]...)
+			if err := batch.Set(current, iter.Value()); err != nil {
+				iter.Close()
+				return moved, err
+			}
+			if err := batch.Delete(iter.Key()); err != nil {
+				iter.Close()
+				return moved, err
+			}
+			moved++
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return moved, err
+		}
+	}
+	if moved == 0 {
+		return 0, nil
+	}
+	return moved, batch.WriteSync()
+}
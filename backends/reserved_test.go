@@ -0,0 +1,126 @@
+package backends
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestReserveSubspace(t *testing.T) {
+	prefix, err := ReserveSubspace("test_subspace")
+	require.Nil(t, err)
+	require.True(t, IsReserved(prefix.Key([]byte("key"))))
+	require.Equal(t, "\x00reserved/test_subspace/key", string(prefix.Key([]byte("key"))))
+
+	_, err = ReserveSubspace("test_subspace")
+	require.ErrorAs(t, err, new(*ErrSubspaceReserved))
+	// in-repo features reserved theirs
+	_, err = ReserveSubspace("format")
+	require.ErrorAs(t, err, new(*ErrSubspaceReserved))
+
+	_, err = ReserveSubspace("")
+	require.NotNil(t, err)
+	_, err = ReserveSubspace("test_subspace/nested")
+	require.NotNil(t, err)
+}
+
+func TestIterateExcludesReserved(t *testing.T) {
+	db := dbm.NewMemDB()
+	for _, key := range []string{"\x00", "\x00reserved/a/1", "\x00reserved/b/2", "\x00z", "a"} {
+		require.Nil(t, db.Set([]byte(key), []byte("value")))
+	}
+	keys := func(iter dbm.Iterator) []string {
+		defer iter.Close()
+		keys := []string{}
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	iter, err := Iterate(db, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"\x00", "\x00z", "a"}, keys(iter))
+	iter, err = ReverseIterate(db, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "\x00z", "\x00"}, keys(iter))
+	iter, err = Iterate(db, []byte("\x00reserved/b"), []byte("\x00z"))
+	require.Nil(t, err)
+	require.Empty(t, keys(iter))
+	iter, err = Iterate(db, nil, nil, IncludeReserved())
+	require.Nil(t, err)
+	require.Len(t, keys(iter), 5)
+}
+
+func TestReservedGuardDB(t *testing.T) {
+	db := NewReservedGuardDB(dbm.NewMemDB())
+	reserved := []byte("\x00reserved/format/marker")
+	require.ErrorAs(t, db.Set(reserved, []byte("value")), new(*ErrReservedKey))
+	require.ErrorAs(t, db.DeleteSync(reserved), new(*ErrReservedKey))
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("a"), []byte("value")))
+	require.ErrorAs(t, batch.Set(reserved, []byte("value")), new(*ErrReservedKey))
+	require.Nil(t, batch.Write())
+	require.Nil(t, batch.Close())
+
+	// metadata is written past the guard, and hidden from the application
+	require.Nil(t, WriteFormatMarker(db, FormatMarker{Format: "app", Version: 1}))
+	_, ok, err := ReadFormatMarker(db)
+	require.Nil(t, err)
+	require.True(t, ok)
+	budget, err := NewDownloadBudget(1000, time.Hour, db)
+	require.Nil(t, err)
+	budget.record(10)
+	iter, err := db.Iterator(nil, nil)
+	require.Nil(t, err)
+	defer iter.Close()
+	require.Equal(t, []byte("a"), iter.Key())
+	iter.Next()
+	require.False(t, iter.Valid())
+
+	unguarded, err := Iterate(db.Unwrap(), nil, nil, IncludeReserved())
+	require.Nil(t, err)
+	defer unguarded.Close()
+	reservedKeys := 0
+	for ; unguarded.Valid(); unguarded.Next() {
+		if IsReserved(unguarded.Key()) {
+			reservedKeys++
+		}
+	}
+	require.Equal(t, 2, reservedKeys)
+}
+
+func TestMigrateLegacyMetadata(t *testing.T) {
+	db := dbm.NewMemDB()
+	require.Nil(t, db.Set([]byte("\x00format_marker"), []byte(`{"format":"app","version":1}`)))
+	require.Nil(t, db.Set([]byte("\x00arweave_version_map/\x00\x00\x00\x00\x00\x00\x00\x01"), []byte("tx1")))
+	require.Nil(t, db.Set([]byte("\x00arweave_version_map/\x00\x00\x00\x00\x00\x00\x00\x02"), []byte("tx2")))
+	require.Nil(t, db.Set([]byte("\x00format_marker_of_the_app"), []byte("unrelated")))
+
+	moved, err := MigrateLegacyMetadata(db)
+	require.Nil(t, err)
+	require.Equal(t, 3, moved)
+	marker, ok, err := ReadFormatMarker(db)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "app", marker.Format)
+	value, err := db.Get(versionMapKey(2))
+	require.Nil(t, err)
+	require.Equal(t, []byte("tx2"), value)
+	value, err = db.Get([]byte("\x00format_marker"))
+	require.Nil(t, err)
+	require.Nil(t, value)
+
+	moved, err = MigrateLegacyMetadata(db)
+	require.Nil(t, err)
+	require.Equal(t, 0, moved)
+	iter, err := Iterate(db, nil, nil)
+	require.Nil(t, err)
+	defer iter.Close()
+	require.True(t, bytes.Equal([]byte("\x00format_marker_of_the_app"), iter.Key()))
+	iter.Next()
+	require.False(t, iter.Valid())
+}
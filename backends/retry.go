@@ -0,0 +1,249 @@
+package backends
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// ErrAttemptTimeout fails attempts exceeding RetryPolicy.Timeout. It is
+// always retryable.
+var ErrAttemptTimeout = errors.New("DB operation attempt timed out")
+
+// RetryPolicy decides how a RetryDB retries failed operations.
+type RetryPolicy struct {
+	// Retryable tells whether an operation failing with err may succeed if
+	// attempted again. Nil retries every error.
+	Retryable func(err error) bool
+	// MaxAttempts bounds the attempts of an operation, the first one
+	// included. Operations are attempted once if it is lower than 2.
+	MaxAttempts int
+	// Backoff returns how long to wait after the given number of failed
+	// attempts. Defaults to ExponentialBackoff(10ms, time.Second).
+	Backoff func(failures int) time.Duration
+	// Timeout bounds each attempt, zero meaning no bound. Timed out
+	// attempts are abandoned rather than canceled, so they may still
+	// complete in the background, the iterators they create being closed.
+	Timeout time.Duration
+	// RetryWrites retries Set, Delete and batch writes too, which is only
+	// safe if applying them twice, possibly after other writes, is.
+	RetryWrites bool
+}
+
+// ExponentialBackoff returns a RetryPolicy.Backoff doubling from base up to
+// max.
+func ExponentialBackoff(base, max time.Duration) func(int) time.Duration {
+	return func(failures int) time.Duration {
+		delay := base
+		for i := 1; i < failures && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// RetryDB wraps a DB to retry its failed operations according to a
+// RetryPolicy. Reads are retried, writes only if the policy allows it, and
+// batches are written again as a whole. Only the creation of iterators is
+// retried, not their use.
+type RetryDB struct {
+	dbm.DB
+	policy RetryPolicy
+	sleep  func(time.Duration)
+}
+
+var _ dbm.DB = (*RetryDB)(nil)
+
+func NewRetryDB(db dbm.DB, policy RetryPolicy) *RetryDB {
+	if policy.Backoff == nil {
+		policy.Backoff = ExponentialBackoff(10*time.Millisecond, time.Second)
+	}
+	return &RetryDB{DB: db, policy: policy, sleep: time.Sleep}
+}
+
+// Unwrap implements Unwrapper.
+func (db *RetryDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+func (db *RetryDB) retryable(err error) bool {
+	if errors.Is(err, ErrAttemptTimeout) {
+		return true
+	}
+	return db.policy.Retryable == nil || db.policy.Retryable(err)
+}
+
+// do attempts op until it succeeds, fails with an error that isn't
+// retryable, or runs out of attempts. Writes are attempted once unless the
+// policy allows retrying them.
+func (db *RetryDB) do(write bool, op func() (interface{}, error)) (interface{}, error) {
+	for failures := 0; ; {
+		res, err := db.attempt(op)
+		if err == nil {
+			return res, nil
+		}
+		failures++
+		if (write && !db.policy.RetryWrites) || failures >= db.policy.MaxAttempts || !db.retryable(err) {
+			return res, err
+		}
+		db.sleep(db.policy.Backoff(failures))
+	}
+}
+
+func (db *RetryDB) attempt(op func() (interface{}, error)) (interface{}, error) {
+	if db.policy.Timeout <= 0 {
+		return op()
+	}
+	type result struct {
+		res interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := op()
+		done <- result{res, err}
+	}()
+	timer := time.NewTimer(db.policy.Timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-timer.C:
+		// results of abandoned attempts, such as iterators, are closed
+		// once they complete, not to hold resources of the DB
+		go func() {
+			if closer, ok := (<-done).res.(io.Closer); ok {
+				_ = closer.Close()
+			}
+		}()
+		return nil, ErrAttemptTimeout
+	}
+}
+
+// Get implements DB.
+func (db *RetryDB) Get(key []byte) ([]byte, error) {
+	res, err := db.do(false, func() (interface{}, error) {
+		return db.DB.Get(key)
+	})
+	value, _ := res.([]byte)
+	return value, err
+}
+
+// Has implements DB.
+func (db *RetryDB) Has(key []byte) (bool, error) {
+	res, err := db.do(false, func() (interface{}, error) {
+		return db.DB.Has(key)
+	})
+	has, _ := res.(bool)
+	return has, err
+}
+
+func (db *RetryDB) write(op func() error) error {
+	_, err := db.do(true, func() (interface{}, error) {
+		return nil, op()
+	})
+	return err
+}
+
+// Set implements DB.
+func (db *RetryDB) Set(key []byte, value []byte) error {
+	return db.write(func() error { return db.DB.Set(key, value) })
+}
+
+// SetSync implements DB.
+func (db *RetryDB) SetSync(key []byte, value []byte) error {
+	return db.write(func() error { return db.DB.SetSync(key, value) })
+}
+
+// Delete implements DB.
+func (db *RetryDB) Delete(key []byte) error {
+	return db.write(func() error { return db.DB.Delete(key) })
+}
+
+// DeleteSync implements DB.
+func (db *RetryDB) DeleteSync(key []byte) error {
+	return db.write(func() error { return db.DB.DeleteSync(key) })
+}
+
+// Iterator implements DB.
+func (db *RetryDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	res, err := db.do(false, func() (interface{}, error) {
+		return db.DB.Iterator(start, end)
+	})
+	iter, _ := res.(dbm.Iterator)
+	return iter, err
+}
+
+// ReverseIterator implements DB.
+func (db *RetryDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	res, err := db.do(false, func() (interface{}, error) {
+		return db.DB.ReverseIterator(start, end)
+	})
+	iter, _ := res.(dbm.Iterator)
+	return iter, err
+}
+
+// NewBatch implements DB. Ops are buffered until the batch is written, and
+// every attempt writes all of them through a new batch of the wrapped DB.
+func (db *RetryDB) NewBatch() dbm.Batch {
+	return &retryBatch{db: db, ops: []batchOp{}}
+}
+
+type retryBatch struct {
+	db  *RetryDB
+	ops []batchOp
+}
+
+func (b *retryBatch) add(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+	return nil
+}
+
+func (b *retryBatch) Set(key, value []byte) error {
+	if len(key) != 0 && value == nil {
+		return errValueNil
+	}
+	return b.add(key, value)
+}
+
+func (b *retryBatch) Delete(key []byte) error {
+	return b.add(key, nil)
+}
+
+func (b *retryBatch) Write() error {
+	return b.write(dbm.Batch.Write)
+}
+
+func (b *retryBatch) WriteSync() error {
+	return b.write(dbm.Batch.WriteSync)
+}
+
+func (b *retryBatch) write(write func(dbm.Batch) error) error {
+	if b.ops == nil {
+		return errBatchClosed
+	}
+	ops := b.ops
+	err := b.db.write(func() error {
+		return writeOps(b.db.DB, ops, write)
+	})
+	if err != nil {
+		return err
+	}
+	return b.Close()
+}
+
+func (b *retryBatch) Close() error {
+	b.ops = nil
+	return nil
+}
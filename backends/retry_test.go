@@ -0,0 +1,233 @@
+package backends
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+// faultDB fails the next operations named in faults, counting attempts by
+// operation.
+type faultDB struct {
+	dbm.DB
+	mtx      sync.Mutex
+	faults   map[string][]error
+	attempts map[string]int
+	// block makes Gets wait until it is closed
+	block chan struct{}
+	// blockIterators makes Iterator wait until it is closed once it
+	// created the iterator
+	blockIterators chan struct{}
+}
+
+func newFaultDB() *faultDB {
+	return &faultDB{DB: dbm.NewMemDB(), faults: map[string][]error{}, attempts: map[string]int{}}
+}
+
+func (db *faultDB) fail(op string, errs ...error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.faults[op] = append(db.faults[op], errs...)
+}
+
+func (db *faultDB) fault(op string) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.attempts[op]++
+	if errs := db.faults[op]; len(errs) > 0 {
+		db.faults[op] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (db *faultDB) Get(key []byte) ([]byte, error) {
+	if db.block != nil {
+		<-db.block
+	}
+	if err := db.fault("Get"); err != nil {
+		return nil, err
+	}
+	return db.DB.Get(key)
+}
+
+func (db *faultDB) Set(key, value []byte) error {
+	if err := db.fault("Set"); err != nil {
+		return err
+	}
+	return db.DB.Set(key, value)
+}
+
+func (db *faultDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	if err := db.fault("Iterator"); err != nil {
+		return nil, err
+	}
+	iter, err := db.DB.Iterator(start, end)
+	if db.blockIterators != nil {
+		<-db.blockIterators
+	}
+	return iter, err
+}
+
+func (db *faultDB) NewBatch() dbm.Batch {
+	return &faultBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type faultBatch struct {
+	dbm.Batch
+	db *faultDB
+}
+
+func (b *faultBatch) Write() error {
+	if err := b.db.fault("Batch.Write"); err != nil {
+		return err
+	}
+	return b.Batch.Write()
+}
+
+func newTestRetryDB(db dbm.DB, policy RetryPolicy) (*RetryDB, *[]time.Duration) {
+	retry := NewRetryDB(db, policy)
+	slept := &[]time.Duration{}
+	retry.sleep = func(d time.Duration) {
+		*slept = append(*slept, d)
+	}
+	return retry, slept
+}
+
+func TestRetryDBClassification(t *testing.T) {
+	faults := newFaultDB()
+	require.Nil(t, faults.DB.Set([]byte("k"), []byte("v")))
+	db, _ := newTestRetryDB(faults, RetryPolicy{
+		MaxAttempts: 3,
+		Retryable:   func(err error) bool { return errors.Is(err, errTransient) },
+	})
+
+	faults.fail("Get", errTransient, errTransient)
+	value, err := db.Get([]byte("k"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("v"), value)
+	require.Equal(t, 3, faults.attempts["Get"])
+
+	// attempts are bounded
+	faults.fail("Get", errTransient, errTransient, errTransient)
+	_, err = db.Get([]byte("k"))
+	require.Equal(t, errTransient, err)
+	require.Equal(t, 6, faults.attempts["Get"])
+
+	// errors which aren't retryable are returned right away
+	faults.fail("Get", errPermanent)
+	_, err = db.Get([]byte("k"))
+	require.Equal(t, errPermanent, err)
+	require.Equal(t, 7, faults.attempts["Get"])
+
+	// only the creation of iterators is retried
+	faults.fail("Iterator", errTransient)
+	iter, err := db.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []byte("k"), iter.Key())
+	require.Nil(t, iter.Close())
+	require.Equal(t, 2, faults.attempts["Iterator"])
+}
+
+func TestRetryDBBackoff(t *testing.T) {
+	faults := newFaultDB()
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	db := NewRetryDB(faults, RetryPolicy{MaxAttempts: 6, Backoff: ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)})
+	db.sleep = clock.Advance
+
+	faults.fail("Get", errTransient, errTransient, errTransient, errTransient, errTransient)
+	_, err := db.Get([]byte("k"))
+	require.Nil(t, err)
+	// 10ms + 20ms + 40ms + 50ms + 50ms
+	require.Equal(t, time.Unix(0, 0).Add(170*time.Millisecond), clock.Now())
+}
+
+func TestRetryDBWriteSafety(t *testing.T) {
+	faults := newFaultDB()
+	db, slept := newTestRetryDB(faults, RetryPolicy{MaxAttempts: 3})
+
+	// writes aren't retried by default
+	faults.fail("Set", errTransient)
+	require.Equal(t, errTransient, db.Set([]byte("a"), []byte("1")))
+	require.Equal(t, 1, faults.attempts["Set"])
+	faults.fail("Batch.Write", errTransient)
+	batch := db.NewBatch()
+	require.Nil(t, batch.Set([]byte("b"), []byte("2")))
+	require.Nil(t, batch.Delete([]byte("c")))
+	require.Equal(t, errTransient, batch.Write())
+	require.Equal(t, 1, faults.attempts["Batch.Write"])
+	require.Empty(t, *slept)
+	has, err := faults.DB.Has([]byte("b"))
+	require.Nil(t, err)
+	require.False(t, has)
+
+	db, _ = newTestRetryDB(faults, RetryPolicy{MaxAttempts: 3, RetryWrites: true})
+	faults.fail("Set", errTransient)
+	require.Nil(t, db.Set([]byte("a"), []byte("1")))
+	require.Equal(t, 3, faults.attempts["Set"])
+
+	// failed batch writes are attempted again with all of their ops
+	require.Nil(t, faults.DB.Set([]byte("c"), []byte("3")))
+	faults.fail("Batch.Write", errTransient)
+	batch = db.NewBatch()
+	require.Nil(t, batch.Set([]byte("b"), []byte("2")))
+	require.Nil(t, batch.Delete([]byte("c")))
+	require.Nil(t, batch.Write())
+	require.Equal(t, 3, faults.attempts["Batch.Write"])
+	value, err := faults.DB.Get([]byte("b"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("2"), value)
+	has, err = faults.DB.Has([]byte("c"))
+	require.Nil(t, err)
+	require.False(t, has)
+	require.Equal(t, errBatchClosed, batch.Write())
+}
+
+func TestRetryDBTimeout(t *testing.T) {
+	faults := newFaultDB()
+	faults.block = make(chan struct{})
+	db, slept := newTestRetryDB(faults, RetryPolicy{
+		MaxAttempts: 2,
+		Timeout:     10 * time.Millisecond,
+		// timeouts are retried regardless of the classification
+		Retryable: func(error) bool { return false },
+	})
+	_, err := db.Get([]byte("k"))
+	require.Equal(t, ErrAttemptTimeout, err)
+	require.Equal(t, 1, len(*slept))
+	close(faults.block)
+}
+
+func TestRetryDBTimeoutClosesIterators(t *testing.T) {
+	faults := newFaultDB()
+	// more keys than memdb iterators buffer, so that open ones hold the
+	// lock of the DB
+	for i := 0; i < 100; i++ {
+		require.Nil(t, faults.DB.Set([]byte{byte(i)}, []byte("v")))
+	}
+	faults.blockIterators = make(chan struct{})
+	db, _ := newTestRetryDB(faults, RetryPolicy{MaxAttempts: 1, Timeout: 10 * time.Millisecond})
+	_, err := db.Iterator(nil, nil)
+	require.Equal(t, ErrAttemptTimeout, err)
+	close(faults.blockIterators)
+
+	written := make(chan error, 1)
+	go func() {
+		written <- faults.DB.Set([]byte("k"), []byte("v"))
+	}()
+	select {
+	case err := <-written:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the iterator of the timed out attempt is still open")
+	}
+}
@@ -0,0 +1,245 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+type CheckLevel int
+
+const (
+	// CheckQuick reads the DB's properties and the first and last keys of the
+	// DB and of a few sampled prefixes.
+	CheckQuick CheckLevel = iota
+	// CheckStandard walks every key, verifying ordering, and reads a sample
+	// of the values.
+	CheckStandard
+	// CheckFull walks every key, verifying ordering, and reads every value.
+	CheckFull
+)
+
+const (
+	defaultCheckSampleFraction = 0.01
+	defaultCheckPrefixes       = 8
+	maxReportedAnomalies       = 100
+)
+
+func (l CheckLevel) String() string {
+	switch l {
+	case CheckQuick:
+		return "quick"
+	case CheckStandard:
+		return "standard"
+	case CheckFull:
+		return "full"
+	default:
+		return fmt.Sprintf("CheckLevel(%d)", int(l))
+	}
+}
+
+type CheckReport struct {
+	Level       CheckLevel
+	Duration    time.Duration
+	KeysChecked int
+	// at most maxReportedAnomalies anomalies are reported, AnomalyCount
+	// counts all of them
+	Anomalies    []Anomaly
+	AnomalyCount int
+}
+
+// Healthy returns whether no anomaly was found.
+func (r CheckReport) Healthy() bool {
+	return r.AnomalyCount == 0
+}
+
+type Anomaly struct {
+	// Key is nil for anomalies not specific to a key
+	Key    []byte
+	Reason string
+}
+
+func (r *CheckReport) addAnomaly(key []byte, reason string) {
+	r.AnomalyCount++
+	if len(r.Anomalies) < maxReportedAnomalies {
+		r.Anomalies = append(r.Anomalies, Anomaly{Key: key, Reason: reason})
+	}
+}
+
+// SelfChecker is implemented by DBs with checks of their own, which
+// SelfCheck runs in addition to the generic ones.
+type SelfChecker interface {
+	SelfCheck(ctx context.Context, level CheckLevel) ([]Anomaly, error)
+}
+
+type selfCheckConfig struct {
+	sampleFraction float64
+	prefixes       int
+}
+
+type SelfCheckOption func(*selfCheckConfig)
+
+// WithSampleFraction sets the fraction of values read by CheckStandard.
+// Defaults to 1%.
+func WithSampleFraction(fraction float64) SelfCheckOption {
+	return func(cfg *selfCheckConfig) {
+		cfg.sampleFraction = fraction
+	}
+}
+
+// WithSampledPrefixes sets the number of prefixes sampled by CheckQuick.
+// Defaults to 8.
+func WithSampledPrefixes(prefixes int) SelfCheckOption {
+	return func(cfg *selfCheckConfig) {
+		cfg.prefixes = prefixes
+	}
+}
+
+// SelfCheck checks that db is readable and consistent, to a depth given by
+// level. Problems with the data are reported as anomalies; the error is only
+// set if the check couldn't complete, e.g. because ctx was cancelled, in
+// which case the report covers what was checked so far.
+func SelfCheck(ctx context.Context, db dbm.DB, level CheckLevel, opts ...SelfCheckOption) (CheckReport, error) {
+	cfg := selfCheckConfig{sampleFraction: defaultCheckSampleFraction, prefixes: defaultCheckPrefixes}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	start := time.Now()
+	report := CheckReport{Level: level}
+	err := selfCheck(ctx, db, level, cfg, &report)
+	if err == nil {
+		if checker, ok := db.(SelfChecker); ok {
+			var anomalies []Anomaly
+			anomalies, err = checker.SelfCheck(ctx, level)
+			for _, anomaly := range anomalies {
+				report.addAnomaly(anomaly.Key, anomaly.Reason)
+			}
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, err
+}
+
+func selfCheck(ctx context.Context, db dbm.DB, level CheckLevel, cfg selfCheckConfig, report *CheckReport) error {
+	// reading the properties catches some failures to open
+	db.Stats()
+	switch level {
+	case CheckQuick:
+		return quickCheck(ctx, db, cfg, report)
+	case CheckStandard:
+		every := 1
+		if cfg.sampleFraction <= 0 {
+			every = math.MaxInt32
+		} else if cfg.sampleFraction < 1 {
+			every = int(math.Round(1 / cfg.sampleFraction))
+		}
+		return scanCheck(ctx, db, every, report)
+	case CheckFull:
+		return scanCheck(ctx, db, 1, report)
+	default:
+		return fmt.Errorf("unknown check level %s", level)
+	}
+}
+
+// quickCheck checks the first and last keys of db, then those of prefixes
+// evenly spread between the first bytes of both.
+func quickCheck(ctx context.Context, db dbm.DB, cfg selfCheckConfig, report *CheckReport) error {
+	seen := map[string]bool{}
+	first, last, err := checkBounds(db, nil, nil, seen, report)
+	if err != nil || first == nil {
+		return err
+	}
+	lo, hi := int(first[0]), int(last[0])
+	checked := map[int]bool{}
+	for i := 0; i < cfg.prefixes; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b := lo
+		if cfg.prefixes > 1 {
+			b = lo + (hi-lo)*i/(cfg.prefixes-1)
+		}
+		if checked[b] {
+			continue
+		}
+		checked[b] = true
+		var end []byte
+		if b < 0xff {
+			end = []byte{byte(b + 1)}
+		}
+		if _, _, err := checkBounds(db, []byte{byte(b)}, end, seen, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBounds checks the first and last keys of [start, end) unless already
+// seen, and returns them, or nil if the range is empty.
+func checkBounds(db dbm.DB, start, end []byte, seen map[string]bool, report *CheckReport) ([]byte, []byte, error) {
+	var bounds [2][]byte
+	for i, newIterator := range []func([]byte, []byte) (dbm.Iterator, error){db.Iterator, db.ReverseIterator} {
+		iter, err := newIterator(start, end)
+		if err != nil {
+			return nil, nil, err
+		}
+		if iter.Valid() {
+			bounds[i] = iter.Key()
+			if !seen[string(bounds[i])] {
+				seen[string(bounds[i])] = true
+				checkKey(db, bounds[i], report)
+			}
+		}
+		if err := iter.Error(); err != nil {
+			report.addAnomaly(nil, fmt.Sprintf("iterating [%X, %X): %s", start, end, err))
+		}
+		iter.Close()
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// scanCheck walks every key, checking that they are strictly increasing, and
+// reads the value of one key in every.
+func scanCheck(ctx context.Context, db dbm.DB, every int, report *CheckReport) error {
+	iter, err := db.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	var prev []byte
+	for i := 0; iter.Valid(); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := iter.Key()
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			report.addAnomaly(key, fmt.Sprintf("out of order after key %X", prev))
+		}
+		prev = key
+		if i%every == 0 {
+			checkKey(db, key, report)
+		} else {
+			report.KeysChecked++
+		}
+		iter.Next()
+	}
+	if err := iter.Error(); err != nil {
+		report.addAnomaly(nil, fmt.Sprintf("iterating: %s", err))
+	}
+	return nil
+}
+
+// checkKey reads the value of a key returned by an iterator.
+func checkKey(db dbm.DB, key []byte, report *CheckReport) {
+	report.KeysChecked++
+	value, err := db.Get(key)
+	if err != nil {
+		report.addAnomaly(key, fmt.Sprintf("unreadable: %s", err))
+	} else if value == nil {
+		report.addAnomaly(key, "iterated over but missing")
+	}
+}
@@ -0,0 +1,99 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func newCheckedDB(t *testing.T, keys int) (*ChecksumDB, dbm.DB) {
+	underlying := dbm.NewMemDB()
+	db := NewChecksumDB(underlying)
+	for i := 0; i < keys; i++ {
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("%c/%04d", 'a'+i%26, i)), []byte("value")))
+	}
+	return db, underlying
+}
+
+func TestSelfCheckHealthy(t *testing.T) {
+	db, _ := newCheckedDB(t, 1000)
+	for _, level := range []CheckLevel{CheckQuick, CheckStandard, CheckFull} {
+		report, err := SelfCheck(context.Background(), db, level)
+		require.Nil(t, err)
+		require.True(t, report.Healthy(), level.String())
+		require.Equal(t, level, report.Level)
+	}
+	report, err := SelfCheck(context.Background(), db, CheckFull)
+	require.Nil(t, err)
+	require.Equal(t, 1000, report.KeysChecked)
+
+	empty, err := SelfCheck(context.Background(), dbm.NewMemDB(), CheckQuick)
+	require.Nil(t, err)
+	require.True(t, empty.Healthy())
+	require.Equal(t, 0, empty.KeysChecked)
+}
+
+func TestSelfCheckFindsCorruption(t *testing.T) {
+	db, underlying := newCheckedDB(t, 1000)
+	// first key of the whole DB, and some key in the middle
+	flipStoredByte(t, underlying, []byte("a/0000"), 0)
+	flipStoredByte(t, underlying, []byte("m/0506"), 0)
+
+	report, err := SelfCheck(context.Background(), db, CheckQuick)
+	require.Nil(t, err)
+	require.Equal(t, 1, report.AnomalyCount)
+	require.Equal(t, []byte("a/0000"), report.Anomalies[0].Key)
+
+	report, err = SelfCheck(context.Background(), db, CheckFull)
+	require.Nil(t, err)
+	require.Equal(t, 2, report.AnomalyCount)
+	require.Equal(t, []byte("m/0506"), report.Anomalies[1].Key)
+	require.Contains(t, report.Anomalies[1].Reason, "corrupted")
+
+	// only the sampled values are read
+	report, err = SelfCheck(context.Background(), db, CheckStandard, WithSampleFraction(0.5))
+	require.Nil(t, err)
+	require.Equal(t, 1000, report.KeysChecked)
+	require.Equal(t, 1, report.AnomalyCount)
+}
+
+type nativeCheckDB struct {
+	dbm.DB
+}
+
+// SelfCheck implements SelfChecker.
+func (db nativeCheckDB) SelfCheck(ctx context.Context, level CheckLevel) ([]Anomaly, error) {
+	return []Anomaly{{Reason: "native"}}, nil
+}
+
+func TestSelfCheckNative(t *testing.T) {
+	report, err := SelfCheck(context.Background(), nativeCheckDB{dbm.NewMemDB()}, CheckQuick)
+	require.Nil(t, err)
+	require.Equal(t, []Anomaly{{Reason: "native"}}, report.Anomalies)
+}
+
+type cancelOnGetDB struct {
+	dbm.DB
+	cancel func()
+	gets   int
+}
+
+// Get implements DB.
+func (db *cancelOnGetDB) Get(key []byte) ([]byte, error) {
+	if db.gets++; db.gets == 10 {
+		db.cancel()
+	}
+	return db.DB.Get(key)
+}
+
+func TestSelfCheckCancellation(t *testing.T) {
+	underlying, _ := newCheckedDB(t, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	db := &cancelOnGetDB{DB: underlying, cancel: cancel}
+	report, err := SelfCheck(ctx, db, CheckFull)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 10, report.KeysChecked)
+}
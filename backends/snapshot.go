@@ -0,0 +1,256 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Snapshot is a read-only, point-in-time view of a DB. Callers must call
+// Close when done.
+type Snapshot interface {
+	Get([]byte) ([]byte, error)
+	Has(key []byte) (bool, error)
+	Iterator(start, end []byte) (dbm.Iterator, error)
+	Close() error
+}
+
+// SnapshottableDB is a DB that can provide point-in-time snapshots.
+type SnapshottableDB interface {
+	dbm.DB
+	Snapshot() (Snapshot, error)
+}
+
+type goLevelDBSnapshotter struct {
+	*dbm.GoLevelDB
+}
+
+// NewGoLevelDBSnapshotter makes db snapshottable using native leveldb
+// snapshots.
+func NewGoLevelDBSnapshotter(db *dbm.GoLevelDB) SnapshottableDB {
+	return goLevelDBSnapshotter{db}
+}
+
+func (db goLevelDBSnapshotter) Snapshot() (Snapshot, error) {
+	snapshot, err := db.DB().GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &goLevelDBSnapshot{snapshot: snapshot}, nil
+}
+
+type goLevelDBSnapshot struct {
+	snapshot *leveldb.Snapshot
+}
+
+func (s *goLevelDBSnapshot) Get(key []byte) ([]byte, error) {
+	res, err := s.snapshot.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return res, err
+}
+
+func (s *goLevelDBSnapshot) Has(key []byte) (bool, error) {
+	return s.snapshot.Has(key, nil)
+}
+
+func (s *goLevelDBSnapshot) Iterator(start, end []byte) (dbm.Iterator, error) {
+	source := s.snapshot.NewIterator(&util.Range{Start: start, Limit: end}, nil)
+	source.First()
+	return &goLevelDBSnapshotIterator{source: source, start: start, end: end}, nil
+}
+
+func (s *goLevelDBSnapshot) Close() error {
+	s.snapshot.Release()
+	return nil
+}
+
+type goLevelDBSnapshotIterator struct {
+	source iterator.Iterator
+	start  []byte
+	end    []byte
+	guard  iteratorGuard
+}
+
+// Domain implements Iterator.
+func (itr *goLevelDBSnapshotIterator) Domain() ([]byte, []byte) {
+	return itr.start, itr.end
+}
+
+// Valid implements Iterator.
+func (itr *goLevelDBSnapshotIterator) Valid() bool {
+	return itr.guard.valid(itr.source.Valid())
+}
+
+// Next implements Iterator.
+func (itr *goLevelDBSnapshotIterator) Next() {
+	itr.guard.assertValid(itr.source.Valid())
+	itr.source.Next()
+}
+
+// Key implements Iterator.
+func (itr *goLevelDBSnapshotIterator) Key() []byte {
+	itr.guard.assertValid(itr.source.Valid())
+	return append([]byte{}, itr.source.Key()...)
+}
+
+// Value implements Iterator.
+func (itr *goLevelDBSnapshotIterator) Value() []byte {
+	itr.guard.assertValid(itr.source.Valid())
+	return append([]byte{}, itr.source.Value()...)
+}
+
+// Error implements Iterator.
+func (itr *goLevelDBSnapshotIterator) Error() error {
+	return itr.source.Error()
+}
+
+// Close implements Iterator.
+func (itr *goLevelDBSnapshotIterator) Close() error {
+	if itr.guard.close() {
+		itr.source.Release()
+	}
+	return nil
+}
+
+type memDBSnapshotter struct {
+	*dbm.MemDB
+}
+
+// NewMemDBSnapshotter makes db snapshottable by copying its content into a
+// new MemDB, which is only suitable for small databases such as in tests.
+func NewMemDBSnapshotter(db *dbm.MemDB) SnapshottableDB {
+	return memDBSnapshotter{db}
+}
+
+func (db memDBSnapshotter) Snapshot() (Snapshot, error) {
+	snapshot := dbm.NewMemDB()
+	iter, err := db.Iterator(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		if err := snapshot.Set(iter.Key(), iter.Value()); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, iter.Error()
+}
+
+// QuiesceHook pauses commits to all members of a SnapshotGroup and returns a
+// function resuming them.
+type QuiesceHook func() (resume func(), err error)
+
+// SnapshotGroup acquires mutually consistent snapshots of several DBs by
+// snapshotting all of them while commits are paused.
+type SnapshotGroup struct {
+	members map[string]SnapshottableDB
+	quiesce QuiesceHook
+	// timeout for each member to provide its snapshot, none if not positive
+	timeout time.Duration
+}
+
+// NewSnapshotGroup returns a group snapshotting members while quiesce pauses
+// commits, giving up on members not providing their snapshot within
+// timeout. A timeout of 0 or less waits for members until the context of
+// Acquire is done.
+func NewSnapshotGroup(members map[string]SnapshottableDB, quiesce QuiesceHook, timeout time.Duration) *SnapshotGroup {
+	return &SnapshotGroup{
+		members: members,
+		quiesce: quiesce,
+		timeout: timeout,
+	}
+}
+
+// GroupSnapshot holds one snapshot per member of a SnapshotGroup.
+type GroupSnapshot struct {
+	Snapshots map[string]Snapshot
+}
+
+// Close closes all member snapshots.
+func (s *GroupSnapshot) Close() error {
+	var firstErr error
+	for _, snapshot := range s.Snapshots {
+		if err := snapshot.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Acquire snapshots all members while quiesced. If any member fails or
+// doesn't provide its snapshot in time, snapshots already acquired are
+// released and an error is returned.
+func (g *SnapshotGroup) Acquire(ctx context.Context) (*GroupSnapshot, error) {
+	resume, err := g.quiesce()
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+
+	type result struct {
+		name     string
+		snapshot Snapshot
+		err      error
+	}
+	results := make(chan result, len(g.members))
+	var wg sync.WaitGroup
+	for name, member := range g.members {
+		wg.Add(1)
+		go func(name string, member SnapshottableDB) {
+			defer wg.Done()
+			snapshot, err := member.Snapshot()
+			results <- result{name: name, snapshot: snapshot, err: err}
+		}(name, member)
+	}
+	// release snapshots of members which complete after we gave up
+	abandon := func() {
+		go func() {
+			wg.Wait()
+			close(results)
+			for res := range results {
+				if res.err == nil {
+					res.snapshot.Close()
+				}
+			}
+		}()
+	}
+
+	group := &GroupSnapshot{Snapshots: map[string]Snapshot{}}
+	// a nil channel never fires
+	var timedOut <-chan time.Time
+	if g.timeout > 0 {
+		timer := time.NewTimer(g.timeout)
+		defer timer.Stop()
+		timedOut = timer.C
+	}
+	for len(group.Snapshots) < len(g.members) {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				group.Close()
+				abandon()
+				return nil, fmt.Errorf("failed to snapshot %s: %w", res.name, res.err)
+			}
+			group.Snapshots[res.name] = res.snapshot
+		case <-timedOut:
+			group.Close()
+			abandon()
+			return nil, errors.New("timed out waiting for snapshots")
+		case <-ctx.Done():
+			group.Close()
+			abandon()
+			return nil, ctx.Err()
+		}
+	}
+	return group, nil
+}
@@ -0,0 +1,143 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func TestSnapshotGroupConsistency(t *testing.T) {
+	levelDB, err := dbm.NewGoLevelDB("snapshot", t.TempDir())
+	require.Nil(t, err)
+	defer levelDB.Close()
+	memDB := dbm.NewMemDB()
+
+	// every commit writes the same key to both DBs
+	var commitMtx sync.RWMutex
+	stop := make(chan struct{})
+	written := make(chan int)
+	go func() {
+		i := 0
+		defer func() { written <- i }()
+		for ; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := []byte(fmt.Sprintf("key%06d", i))
+			commitMtx.RLock()
+			require.Nil(t, levelDB.Set(key, []byte("v")))
+			require.Nil(t, memDB.Set(key, []byte("v")))
+			commitMtx.RUnlock()
+		}
+	}()
+
+	group := NewSnapshotGroup(map[string]SnapshottableDB{
+		"leveldb": NewGoLevelDBSnapshotter(levelDB),
+		"memdb":   NewMemDBSnapshotter(memDB),
+	}, func() (func(), error) {
+		commitMtx.Lock()
+		return commitMtx.Unlock, nil
+	}, time.Second)
+
+	time.Sleep(10 * time.Millisecond)
+	snapshot, err := group.Acquire(context.Background())
+	require.Nil(t, err)
+	defer snapshot.Close()
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	total := <-written
+
+	inSnapshot := 0
+	for i := 0; i < total; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		inLevelDB, err := snapshot.Snapshots["leveldb"].Has(key)
+		require.Nil(t, err)
+		inMemDB, err := snapshot.Snapshots["memdb"].Has(key)
+		require.Nil(t, err)
+		require.Equal(t, inLevelDB, inMemDB, "key %s", key)
+		if inLevelDB {
+			inSnapshot++
+		}
+	}
+	require.Greater(t, inSnapshot, 0)
+	require.Less(t, inSnapshot, total)
+
+	iter, err := snapshot.Snapshots["leveldb"].Iterator(nil, nil)
+	require.Nil(t, err)
+	iterated := 0
+	for ; iter.Valid(); iter.Next() {
+		iterated++
+	}
+	require.Nil(t, iter.Close())
+	require.Equal(t, inSnapshot, iterated)
+}
+
+type stubSnapshot struct {
+	*dbm.MemDB
+	closed *int32
+}
+
+func (s stubSnapshot) Close() error {
+	atomic.AddInt32(s.closed, 1)
+	return nil
+}
+
+type stubSnapshottableDB struct {
+	*dbm.MemDB
+	delay  time.Duration
+	err    error
+	closed int32
+}
+
+func (db *stubSnapshottableDB) Snapshot() (Snapshot, error) {
+	time.Sleep(db.delay)
+	if db.err != nil {
+		return nil, db.err
+	}
+	return stubSnapshot{MemDB: dbm.NewMemDB(), closed: &db.closed}, nil
+}
+
+func noopQuiesce() (func(), error) {
+	return func() {}, nil
+}
+
+func TestSnapshotGroupPartialFailure(t *testing.T) {
+	healthy := &stubSnapshottableDB{MemDB: dbm.NewMemDB()}
+	failing := &stubSnapshottableDB{MemDB: dbm.NewMemDB(), delay: 10 * time.Millisecond, err: errors.New("boom")}
+	group := NewSnapshotGroup(map[string]SnapshottableDB{"healthy": healthy, "failing": failing}, noopQuiesce, time.Second)
+	_, err := group.Acquire(context.Background())
+	require.NotNil(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&healthy.closed))
+}
+
+func TestSnapshotGroupTimeout(t *testing.T) {
+	healthy := &stubSnapshottableDB{MemDB: dbm.NewMemDB()}
+	slow := &stubSnapshottableDB{MemDB: dbm.NewMemDB(), delay: 50 * time.Millisecond}
+	resumed := false
+	group := NewSnapshotGroup(map[string]SnapshottableDB{"healthy": healthy, "slow": slow}, func() (func(), error) {
+		return func() { resumed = true }, nil
+	}, 10*time.Millisecond)
+	_, err := group.Acquire(context.Background())
+	require.NotNil(t, err)
+	require.True(t, resumed)
+	require.Equal(t, int32(1), atomic.LoadInt32(&healthy.closed))
+	// the late snapshot gets released once it's done
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&slow.closed) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestSnapshotGroupNoTimeout(t *testing.T) {
+	slow := &stubSnapshottableDB{MemDB: dbm.NewMemDB(), delay: 10 * time.Millisecond}
+	group := NewSnapshotGroup(map[string]SnapshottableDB{"slow": slow}, noopQuiesce, 0)
+	snapshot, err := group.Acquire(context.Background())
+	require.Nil(t, err)
+	require.Nil(t, snapshot.Close())
+}
@@ -0,0 +1,319 @@
+package backends
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// spilledOpOverhead approximates the memory used by a pending op besides its
+// key and value.
+const spilledOpOverhead = 64
+
+// spillingBatch keeps the last op of each key in memory until they exceed
+// memLimit bytes, then spills them to a file sorted by key. Runs are merged
+// on Write, later runs overriding earlier ones for the same key.
+type spillingBatch struct {
+	db       dbm.DB
+	memLimit int64
+	spillDir string
+
+	pending map[string]batchOp
+	memUsed int64
+	// spilled runs, oldest first
+	runs   []string
+	closed bool
+}
+
+var _ dbm.Batch = (*spillingBatch)(nil)
+
+// NewSpillingBatch returns a batch of db which holds at most about memLimit
+// bytes of pending ops in memory, spilling the others to temporary files in
+// spillDir. Writing it has the same effect as writing a plain batch of the
+// same ops, but through several batches of db of about memLimit bytes each,
+// so it isn't atomic. Its temporary files are removed once written or
+// closed.
+func NewSpillingBatch(db dbm.DB, memLimit int64, spillDir string) dbm.Batch {
+	return &spillingBatch{db: db, memLimit: memLimit, spillDir: spillDir, pending: map[string]batchOp{}}
+}
+
+func opSize(op batchOp) int64 {
+	return int64(len(op.key)+len(op.value)) + spilledOpOverhead
+}
+
+func (b *spillingBatch) add(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	op := batchOp{key: key, value: value}
+	if prev, ok := b.pending[string(key)]; ok {
+		b.memUsed -= opSize(prev)
+	}
+	b.pending[string(key)] = op
+	b.memUsed += opSize(op)
+	if b.memUsed > b.memLimit {
+		return b.spill()
+	}
+	return nil
+}
+
+// Set implements Batch.
+func (b *spillingBatch) Set(key, value []byte) error {
+	if len(key) != 0 && value == nil {
+		return errValueNil
+	}
+	return b.add(key, value)
+}
+
+// Delete implements Batch.
+func (b *spillingBatch) Delete(key []byte) error {
+	return b.add(key, nil)
+}
+
+func (b *spillingBatch) sortedPending() []batchOp {
+	ops := make([]batchOp, 0, len(b.pending))
+	for _, op := range b.pending {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return string(ops[i].key) < string(ops[j].key) })
+	return ops
+}
+
+// spill writes the pending ops to a new run.
+func (b *spillingBatch) spill() (err error) {
+	f, err := ioutil.TempFile(b.spillDir, "spill-*.run")
+	if err != nil {
+		return err
+	}
+	b.runs = append(b.runs, f.Name())
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	w := bufio.NewWriter(f)
+	for _, op := range b.sortedPending() {
+		if err := writeSpilledOp(w, op); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	b.pending, b.memUsed = map[string]batchOp{}, 0
+	return nil
+}
+
+// Spilled ops are encoded as a flag telling whether they are Sets, followed
+// by the length-prefixed key and, for Sets, the length-prefixed value.
+func writeSpilledOp(w *bufio.Writer, op batchOp) error {
+	isSet := byte(0)
+	if op.value != nil {
+		isSet = 1
+	}
+	buf := make([]byte, 1, 1+2*binary.MaxVarintLen64)
+	buf[0] = isSet
+	buf = append(buf, uvarint(uint64(len(op.key)))...)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	if _, err := w.Write(op.key); err != nil {
+		return err
+	}
+	if op.value == nil {
+		return nil
+	}
+	if _, err := w.Write(uvarint(uint64(len(op.value)))); err != nil {
+		return err
+	}
+	_, err := w.Write(op.value)
+	return err
+}
+
+func uvarint(n uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, n)]
+}
+
+func readSpilledOp(r *bufio.Reader) (batchOp, error) {
+	isSet, err := r.ReadByte()
+	if err != nil {
+		return batchOp{}, err
+	}
+	key, err := readSpilledBytes(r)
+	if err != nil {
+		return batchOp{}, err
+	}
+	op := batchOp{key: key}
+	if isSet == 1 {
+		if op.value, err = readSpilledBytes(r); err != nil {
+			return batchOp{}, err
+		}
+	}
+	return op, nil
+}
+
+func readSpilledBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	bz := make([]byte, n)
+	if _, err := io.ReadFull(r, bz); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return bz, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// spillRun reads the ops of a run in key order.
+type spillRun struct {
+	// runs spilled later have higher priorities
+	priority int
+	current  batchOp
+	next     func() (batchOp, error)
+}
+
+type spillRunHeap []*spillRun
+
+func (h spillRunHeap) Len() int { return len(h) }
+
+func (h spillRunHeap) Less(i, j int) bool {
+	if c := string(h[i].current.key); c != string(h[j].current.key) {
+		return c < string(h[j].current.key)
+	}
+	return h[i].priority > h[j].priority
+}
+
+func (h spillRunHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *spillRunHeap) Push(x interface{}) { *h = append(*h, x.(*spillRun)) }
+
+func (h *spillRunHeap) Pop() interface{} {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return run
+}
+
+// advance moves run to its next op, removing it from h once exhausted.
+func (h *spillRunHeap) advance(run *spillRun) error {
+	op, err := run.next()
+	if errors.Is(err, io.EOF) {
+		heap.Remove(h, 0)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	run.current = op
+	heap.Fix(h, 0)
+	return nil
+}
+
+// Write implements Batch.
+func (b *spillingBatch) Write() error {
+	return b.write(dbm.Batch.Write)
+}
+
+// WriteSync implements Batch.
+func (b *spillingBatch) WriteSync() error {
+	return b.write(dbm.Batch.WriteSync)
+}
+
+func (b *spillingBatch) write(write func(dbm.Batch) error) error {
+	if b.closed {
+		return errBatchClosed
+	}
+	defer b.Close()
+	runs := []*spillRun{}
+	for i, path := range b.runs {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r := bufio.NewReader(f)
+		runs = append(runs, &spillRun{priority: i, next: func() (batchOp, error) { return readSpilledOp(r) }})
+	}
+	pending := b.sortedPending()
+	runs = append(runs, &spillRun{priority: len(b.runs), next: func() (batchOp, error) {
+		if len(pending) == 0 {
+			return batchOp{}, io.EOF
+		}
+		op := pending[0]
+		pending = pending[1:]
+		return op, nil
+	}})
+	// load the first op of every run
+	h := spillRunHeap{}
+	for _, run := range runs {
+		op, err := run.next()
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading spilled ops: %w", err)
+		}
+		run.current = op
+		heap.Push(&h, run)
+	}
+	return b.merge(&h, write)
+}
+
+// merge writes the last op of every key of the runs of h, in batches of
+// about memLimit bytes.
+func (b *spillingBatch) merge(h *spillRunHeap, write func(dbm.Batch) error) error {
+	ops := []batchOp{}
+	size := int64(0)
+	for h.Len() > 0 {
+		op := (*h)[0].current
+		// the top run has the latest op of the key, skip the others
+		for h.Len() > 0 && string((*h)[0].current.key) == string(op.key) {
+			if err := h.advance((*h)[0]); err != nil {
+				return fmt.Errorf("reading spilled ops: %w", err)
+			}
+		}
+		ops = append(ops, op)
+		size += opSize(op)
+		if size >= b.memLimit {
+			if err := writeOps(b.db, ops, write); err != nil {
+				return err
+			}
+			ops, size = nil, 0
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return writeOps(b.db, ops, write)
+}
+
+// Close implements Batch. It removes the spilled runs.
+func (b *spillingBatch) Close() error {
+	var err error
+	for _, path := range b.runs {
+		if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+			err = removeErr
+		}
+	}
+	b.runs, b.pending, b.memUsed, b.closed = nil, nil, 0, true
+	return err
+}
@@ -0,0 +1,113 @@
+package backends
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func requireNoSpilledRuns(t *testing.T, dir string) {
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	require.Empty(t, files)
+}
+
+func TestSpillingBatchMatchesPlainBatch(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		dir := t.TempDir()
+		expected, actual := dbm.NewMemDB(), dbm.NewMemDB()
+		for _, db := range []dbm.DB{expected, actual} {
+			require.Nil(t, db.Set([]byte("key1"), []byte("initial")))
+		}
+		plain, spilling := expected.NewBatch(), NewSpillingBatch(actual, 512, dir)
+		applyRandomOps(t, r, 500, 20+r.Intn(100), plain, spilling)
+
+		// a tiny limit spills many runs
+		files, err := ioutil.ReadDir(dir)
+		require.Nil(t, err)
+		require.Greater(t, len(files), 1)
+
+		require.Nil(t, plain.Write())
+		require.Nil(t, spilling.Write())
+		requireIdentical(t, expected, actual)
+		requireNoSpilledRuns(t, dir)
+		require.Equal(t, errBatchClosed, spilling.Write())
+	}
+}
+
+func TestSpillingBatchWritesBoundedBatches(t *testing.T) {
+	db := &opCountingDB{DB: dbm.NewMemDB()}
+	batches := 0
+	batch := NewSpillingBatch(writeCountingDB{DB: db, writes: &batches}, 1000, t.TempDir())
+	for i := 0; i < 100; i++ {
+		require.Nil(t, batch.Set([]byte{byte(i + 1)}, []byte("value")))
+	}
+	require.Nil(t, batch.Write())
+	require.Equal(t, 100, db.ops)
+	// each op is accounted 70 bytes, so that 15 fit in a batch
+	require.Equal(t, 7, batches)
+}
+
+// writeCountingDB counts the batches written.
+type writeCountingDB struct {
+	dbm.DB
+	writes *int
+}
+
+func (db writeCountingDB) NewBatch() dbm.Batch {
+	return writeCountingBatch{Batch: db.DB.NewBatch(), writes: db.writes}
+}
+
+type writeCountingBatch struct {
+	dbm.Batch
+	writes *int
+}
+
+func (b writeCountingBatch) Write() error {
+	*b.writes++
+	return b.Batch.Write()
+}
+
+type panickingBatchDB struct {
+	dbm.DB
+}
+
+func (db panickingBatchDB) NewBatch() dbm.Batch {
+	return panickingBatch{Batch: db.DB.NewBatch()}
+}
+
+type panickingBatch struct {
+	dbm.Batch
+}
+
+func (b panickingBatch) Write() error {
+	panic("write failed")
+}
+
+func TestSpillingBatchCleanup(t *testing.T) {
+	fill := func(batch dbm.Batch) {
+		for i := 0; i < 50; i++ {
+			require.Nil(t, batch.Set([]byte{byte(i + 1)}, []byte("value")))
+		}
+	}
+
+	dir := t.TempDir()
+	batch := NewSpillingBatch(dbm.NewMemDB(), 256, dir)
+	fill(batch)
+	require.Nil(t, batch.Close())
+	requireNoSpilledRuns(t, dir)
+
+	batch = NewSpillingBatch(&failingBatchDB{DB: dbm.NewMemDB(), failAfter: 0}, 256, dir)
+	fill(batch)
+	require.NotNil(t, batch.Write())
+	requireNoSpilledRuns(t, dir)
+
+	batch = NewSpillingBatch(panickingBatchDB{DB: dbm.NewMemDB()}, 256, dir)
+	fill(batch)
+	require.Panics(t, func() { _ = batch.Write() })
+	requireNoSpilledRuns(t, dir)
+}
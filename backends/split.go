@@ -0,0 +1,283 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"math/rand"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+	dbm "github.com/tendermint/tm-db"
+)
+
+const (
+	defaultSplitTolerance     = 0.05
+	defaultSplitMaxBisections = 32
+	defaultSplitSampleBudget  = 1000000
+	defaultSplitSampleSize    = 4096
+	// how many keys the sampling walk reads between checks of its context
+	splitWalkCheckInterval = 1024
+)
+
+// SizeEstimator is implemented by DBs which can estimate the size of a key
+// range without reading it, e.g. from table metadata. SampleSplitPoints
+// bisects such estimates.
+type SizeEstimator interface {
+	// EstimateSize returns the approximate size in bytes of the keys and
+	// values in [start, end).
+	EstimateSize(start, end []byte) (uint64, error)
+}
+
+type goLevelDBEstimator struct {
+	db *dbm.GoLevelDB
+}
+
+func (e goLevelDBEstimator) EstimateSize(start, end []byte) (uint64, error) {
+	sizes, err := e.db.DB().SizeOf([]util.Range{{Start: start, Limit: end}})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(sizes.Sum()), nil
+}
+
+// sizeEstimatorOf returns the size estimator of db, if it has one.
+func sizeEstimatorOf(db dbm.DB) (SizeEstimator, bool) {
+	switch db := db.(type) {
+	case SizeEstimator:
+		return db, true
+	case *dbm.GoLevelDB:
+		return goLevelDBEstimator{db}, true
+	}
+	return nativeSizeEstimatorOf(db)
+}
+
+type SplitOption func(*splitConfig)
+
+type splitConfig struct {
+	tolerance     float64
+	maxBisections int
+	sampleBudget  int
+	sampleSize    int
+}
+
+// WithSplitAccuracy makes SampleSplitPoints stop bisecting the estimate of
+// a split point once the shard before it is within tolerance of its target
+// size, as a fraction of it, or after maxBisections. It defaults to 5% and
+// 32 bisections.
+func WithSplitAccuracy(tolerance float64, maxBisections int) SplitOption {
+	return func(cfg *splitConfig) {
+		cfg.tolerance = tolerance
+		cfg.maxBisections = maxBisections
+	}
+}
+
+// WithSplitSampleBudget makes SampleSplitPoints, when sampling, walk at
+// most maxKeys keys, keeping a sample of sampleSize of them. It defaults to
+// 1000000 keys and a sample of 4096.
+func WithSplitSampleBudget(maxKeys, sampleSize int) SplitOption {
+	return func(cfg *splitConfig) {
+		cfg.sampleBudget = maxKeys
+		cfg.sampleSize = sampleSize
+	}
+}
+
+// SampleSplitPoints returns up to shards-1 keys splitting [start, end) of db
+// into shards of roughly equal size, in ascending order, shard i spanning
+// from the key before it, or start, up to key i, or end, excluded. Fewer
+// keys are returned if the range doesn't hold enough distinct keys. Nil
+// bounds are open.
+//
+// DBs implementing SizeEstimator and goleveldb DBs, as well as rocksdb ones
+// when built with the rocksdb tag, are split by bisecting estimates of the
+// size of the range in bytes, without reading it. Other DBs, and those
+// whose estimate is empty as their data isn't flushed to tables yet, are
+// split by count of keys, sampling them with a walk through the range. A
+// walk stopped by the sample budget splits the keys walked, leaving the
+// rest of the range to the last shard.
+func SampleSplitPoints(ctx context.Context, db dbm.DB, start, end []byte, shards int, opts ...SplitOption) ([][]byte, error) {
+	if shards < 1 {
+		return nil, errors.New("a range must be split into at least one shard")
+	}
+	cfg := splitConfig{
+		tolerance:     defaultSplitTolerance,
+		maxBisections: defaultSplitMaxBisections,
+		sampleBudget:  defaultSplitSampleBudget,
+		sampleSize:    defaultSplitSampleSize,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if shards == 1 {
+		return nil, nil
+	}
+	if estimator, ok := sizeEstimatorOf(db); ok {
+		points, estimated, err := bisectSplitPoints(ctx, db, estimator, start, end, shards, cfg)
+		if err != nil || estimated {
+			return points, err
+		}
+	}
+	return sampleSplitPoints(ctx, db, start, end, shards, cfg)
+}
+
+// bisectSplitPoints splits the range by bisecting the estimates of
+// estimator, returning false if the range is estimated empty.
+func bisectSplitPoints(ctx context.Context, db dbm.DB, estimator SizeEstimator, start, end []byte, shards int, cfg splitConfig) ([][]byte, bool, error) {
+	lo, hi, ok, err := keyBounds(db, start, end)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	total, err := estimator.EstimateSize(lo, hi)
+	if err != nil || total == 0 {
+		return nil, false, err
+	}
+	shardSize := float64(total) / float64(shards)
+	points := [][]byte{}
+	prev := lo
+	for i := 1; i < shards; i++ {
+		target := shardSize * float64(i)
+		left, right := prev, hi
+		var point []byte
+		for b := 0; b < cfg.maxBisections; b++ {
+			if err := ctx.Err(); err != nil {
+				return nil, false, err
+			}
+			mid := midKey(left, right)
+			if mid == nil {
+				break
+			}
+			point = mid
+			size, err := estimator.EstimateSize(lo, mid)
+			if err != nil {
+				return nil, false, err
+			}
+			deviation := float64(size) - target
+			if deviation < 0 {
+				deviation = -deviation
+			}
+			if deviation <= cfg.tolerance*shardSize {
+				break
+			}
+			if float64(size) < target {
+				left = mid
+			} else {
+				right = mid
+			}
+		}
+		if point == nil || bytes.Compare(point, prev) <= 0 {
+			continue
+		}
+		points = append(points, point)
+		prev = point
+	}
+	return points, true, nil
+}
+
+// keyBounds returns the bounds of the keys of db within [start, end), from
+// the first one to the key following the last one, so that bisections
+// start from the keys, and false if the range holds no key.
+func keyBounds(db dbm.DB, start, end []byte) ([]byte, []byte, bool, error) {
+	first, err := db.Iterator(start, end)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer first.Close()
+	if !first.Valid() {
+		return nil, nil, false, first.Error()
+	}
+	last, err := db.ReverseIterator(start, end)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer last.Close()
+	if !last.Valid() {
+		return nil, nil, false, last.Error()
+	}
+	return append([]byte{}, first.Key()...), append(append([]byte{}, last.Key()...), 0), true, nil
+}
+
+// midKey returns a key halfway between a and b in key order, nil if there
+// is none between them.
+func midKey(a, b []byte) []byte {
+	width := len(a)
+	if len(b) > width {
+		width = len(b)
+	}
+	width++
+	padded := func(key []byte) *big.Int {
+		bz := make([]byte, width)
+		copy(bz, key)
+		return new(big.Int).SetBytes(bz)
+	}
+	sum := new(big.Int).Add(padded(a), padded(b))
+	mid := sum.Rsh(sum, 1).FillBytes(make([]byte, width))
+	// trailing zeroes are dropped as long as the key stays past a
+	trimmed := bytes.TrimRight(mid, "\x00")
+	if bytes.Compare(trimmed, a) > 0 {
+		mid = trimmed
+	}
+	if bytes.Compare(mid, a) <= 0 || bytes.Compare(mid, b) >= 0 {
+		return nil
+	}
+	return mid
+}
+
+// sampleSplitPoints splits the range by the quantiles of a reservoir sample
+// of its keys.
+func sampleSplitPoints(ctx context.Context, db dbm.DB, start, end []byte, shards int, cfg splitConfig) ([][]byte, error) {
+	iter, err := db.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	rnd := rand.New(rand.NewSource(1))
+	sample := make([][]byte, 0, cfg.sampleSize)
+	walked := 0
+	for ; iter.Valid() && walked < cfg.sampleBudget; iter.Next() {
+		if walked%splitWalkCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		walked++
+		if len(sample) < cfg.sampleSize {
+			sample = append(sample, append([]byte{}, iter.Key()...))
+		} else if i := rnd.Intn(walked); i < cfg.sampleSize {
+			sample[i] = append(sample[i][:0], iter.Key()...)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	sort.Slice(sample, func(i, j int) bool {
+		return bytes.Compare(sample[i], sample[j]) < 0
+	})
+	points := [][]byte{}
+	for i := 1; i < shards; i++ {
+		point := sample[i*len(sample)/shards:]
+		if len(point) == 0 {
+			break
+		}
+		// the first shard would be left empty by the first key
+		if bytes.Compare(point[0], sample[0]) <= 0 {
+			continue
+		}
+		if len(points) > 0 && bytes.Equal(points[len(points)-1], point[0]) {
+			continue
+		}
+		points = append(points, point[0])
+	}
+	return points, nil
+}
+
+// splitRanges returns the bounds of the shards of [start, end) delimited by
+// points.
+func splitRanges(start, end []byte, points [][]byte) [][2][]byte {
+	ranges := make([][2][]byte, 0, len(points)+1)
+	for _, point := range points {
+		ranges = append(ranges, [2][]byte{start, point})
+		start = point
+	}
+	return append(ranges, [2][]byte{start, end})
+}
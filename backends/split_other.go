@@ -0,0 +1,14 @@
+//go:build !rocksdb
+// +build !rocksdb
+
+package backends
+
+import (
+	dbm "github.com/tendermint/tm-db"
+)
+
+// nativeSizeEstimatorOf returns the size estimator of the DBs of the
+// backends built with tags, none without the rocksdb tag.
+func nativeSizeEstimatorOf(db dbm.DB) (SizeEstimator, bool) {
+	return nil, false
+}
@@ -0,0 +1,30 @@
+//go:build rocksdb
+// +build rocksdb
+
+package backends
+
+import (
+	"github.com/cosmos/gorocksdb"
+	dbm "github.com/tendermint/tm-db"
+)
+
+type rocksDBEstimator struct {
+	db *dbm.RocksDB
+}
+
+func (e rocksDBEstimator) EstimateSize(start, end []byte) (uint64, error) {
+	sizes, err := e.db.DB().GetApproximateSizes([]gorocksdb.Range{{Start: start, Limit: end}})
+	if err != nil {
+		return 0, err
+	}
+	return sizes[0], nil
+}
+
+// nativeSizeEstimatorOf returns the size estimator of the DBs of the
+// backends built with tags.
+func nativeSizeEstimatorOf(db dbm.DB) (SizeEstimator, bool) {
+	if db, ok := db.(*dbm.RocksDB); ok {
+		return rocksDBEstimator{db}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,140 @@
+package backends
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// shardSizes returns the number of keys and bytes of each shard of db
+// delimited by points.
+func shardSizes(t *testing.T, db dbm.DB, start, end []byte, points [][]byte) ([]int, []int) {
+	keys, sizes := []int{}, []int{}
+	for _, bounds := range splitRanges(start, end, points) {
+		iter, err := db.Iterator(bounds[0], bounds[1])
+		require.Nil(t, err)
+		n, size := 0, 0
+		for ; iter.Valid(); iter.Next() {
+			n++
+			size += len(iter.Key()) + len(iter.Value())
+		}
+		require.Nil(t, iter.Close())
+		keys, sizes = append(keys, n), append(sizes, size)
+	}
+	return keys, sizes
+}
+
+// requireBalanced requires each of sizes to be within tolerance of their
+// mean, as a fraction of it.
+func requireBalanced(t *testing.T, sizes []int, tolerance float64) {
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	mean := float64(total) / float64(len(sizes))
+	for i, size := range sizes {
+		require.InDelta(t, mean, float64(size), tolerance*mean, "shard %d of %v", i, sizes)
+	}
+}
+
+func TestSampleSplitPointsBySampling(t *testing.T) {
+	db := dbm.NewMemDB()
+	// most keys are packed under a single prefix
+	for i := 0; i < 18000; i++ {
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("a/%06d", i)), []byte("v")))
+	}
+	for i := 0; i < 2000; i++ {
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("%c/%06d", 'b'+i%25, i)), []byte("v")))
+	}
+
+	points, err := SampleSplitPoints(context.Background(), db, nil, nil, 8)
+	require.Nil(t, err)
+	require.Len(t, points, 7)
+	keys, _ := shardSizes(t, db, nil, nil, points)
+	requireBalanced(t, keys, 0.1)
+
+	// within bounds
+	start, end := []byte("a/009000"), []byte("m")
+	points, err = SampleSplitPoints(context.Background(), db, start, end, 4)
+	require.Nil(t, err)
+	require.Len(t, points, 3)
+	for _, point := range points {
+		require.True(t, bytes.Compare(point, start) > 0 && bytes.Compare(point, end) < 0)
+	}
+	keys, _ = shardSizes(t, db, start, end, points)
+	requireBalanced(t, keys, 0.1)
+
+	// the walk stops once the budget is spent
+	points, err = SampleSplitPoints(context.Background(), db, nil, nil, 4, WithSplitSampleBudget(1000, 100))
+	require.Nil(t, err)
+	require.Len(t, points, 3)
+	require.True(t, bytes.Compare(points[2], []byte("a/001000")) < 0)
+
+	// fewer points than shards without enough keys
+	small := dbm.NewMemDB()
+	require.Nil(t, small.Set([]byte("a"), []byte("v")))
+	require.Nil(t, small.Set([]byte("b"), []byte("v")))
+	points, err = SampleSplitPoints(context.Background(), small, nil, nil, 8)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("b")}, points)
+	points, err = SampleSplitPoints(context.Background(), dbm.NewMemDB(), nil, nil, 8)
+	require.Nil(t, err)
+	require.Empty(t, points)
+
+	_, err = SampleSplitPoints(context.Background(), db, nil, nil, 0)
+	require.NotNil(t, err)
+}
+
+func TestSampleSplitPointsByEstimates(t *testing.T) {
+	db, err := dbm.NewGoLevelDB("split", t.TempDir())
+	require.Nil(t, err)
+	defer db.Close()
+	// values of the second half of the keys are 20 times larger, so that
+	// shards balanced by count of keys would not be by size
+	for i := 0; i < 8000; i++ {
+		value := make([]byte, 100)
+		if i >= 4000 {
+			value = make([]byte, 2000)
+		}
+		_, err := rand.Read(value)
+		require.Nil(t, err)
+		require.Nil(t, db.Set([]byte(fmt.Sprintf("key-%06d", i)), value))
+	}
+	// estimates only cover the data flushed to tables
+	require.Nil(t, db.DB().CompactRange(util.Range{}))
+
+	points, err := SampleSplitPoints(context.Background(), db, nil, nil, 4)
+	require.Nil(t, err)
+	require.Len(t, points, 3)
+	keys, sizes := shardSizes(t, db, nil, nil, points)
+	requireBalanced(t, sizes, 0.15)
+	require.Greater(t, keys[0], 2*keys[3])
+
+	// accuracy can be traded for fewer estimates
+	points, err = SampleSplitPoints(context.Background(), db, []byte("key-004000"), nil, 2, WithSplitAccuracy(0.5, 4))
+	require.Nil(t, err)
+	require.Len(t, points, 1)
+	_, sizes = shardSizes(t, db, []byte("key-004000"), nil, points)
+	requireBalanced(t, sizes, 0.5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = SampleSplitPoints(ctx, db, nil, nil, 4)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMidKey(t *testing.T) {
+	for _, bounds := range [][2]string{{"a", "b"}, {"", "\x01"}, {"a", "a\x00\x01"}, {"key-1", "key-2"}, {"ab", "b"}} {
+		mid := midKey([]byte(bounds[0]), []byte(bounds[1]))
+		require.NotNil(t, mid, "%q", bounds)
+		require.True(t, bytes.Compare(mid, []byte(bounds[0])) > 0 && bytes.Compare(mid, []byte(bounds[1])) < 0, "%q: %q", bounds, mid)
+	}
+	require.Nil(t, midKey([]byte("a"), []byte("a\x00")))
+	require.Nil(t, midKey([]byte("a"), []byte("a")))
+}
@@ -0,0 +1,162 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// DefaultLayerShutdownTimeout is how long a ManagedStack waits for each of
+// its layers and tasks to shut down.
+const DefaultLayerShutdownTimeout = 10 * time.Second
+
+// Shutdowner is implemented by DBs needing more than Close to shut down,
+// e.g. to drain queues or stop background goroutines. Shutdown shuts the
+// layer itself down within the deadline of ctx, leaving the DB it wraps to
+// be shut down on its own.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+type stackLayer struct {
+	name string
+	db   dbm.DB
+	// the layer below, nil for the innermost one
+	below dbm.DB
+}
+
+type stackTask struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// ManagedStack is a stack of DB wrappers, e.g. a MetricsDB over a RetryDB
+// over a goleveldb DB, along with the background tasks using it, shut down
+// in order by a single Close.
+type ManagedStack struct {
+	// innermost first
+	layers  []stackLayer
+	timeout time.Duration
+
+	mtx    sync.Mutex
+	tasks  []stackTask
+	closed bool
+}
+
+// NewManagedStack builds a stack by calling each builder with the DB built
+// by the previous one, the first one being given nil to open the innermost
+// DB. If a builder fails, the layers already built are shut down.
+func NewManagedStack(builders ...func(dbm.DB) (dbm.DB, error)) (*ManagedStack, error) {
+	if len(builders) == 0 {
+		return nil, errors.New("a managed stack needs at least one layer")
+	}
+	s := &ManagedStack{timeout: DefaultLayerShutdownTimeout}
+	var below dbm.DB
+	for i, build := range builders {
+		db, err := build(below)
+		if err == nil && db == nil {
+			err = errors.New("no DB built")
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to build layer %d: %w", i, err)
+			if closeErr := s.Close(context.Background()); closeErr != nil {
+				err = fmt.Errorf("%w, then to shut down the layers built: %s", err, closeErr)
+			}
+			return nil, err
+		}
+		s.layers = append(s.layers, stackLayer{name: fmt.Sprintf("layer %d (%T)", i, db), db: db, below: below})
+		below = db
+	}
+	return s, nil
+}
+
+// DB returns the outermost layer of the stack.
+func (s *ManagedStack) DB() dbm.DB {
+	return s.layers[len(s.layers)-1].db
+}
+
+// SetLayerTimeout sets how long Close waits for each layer and task to shut
+// down, DefaultLayerShutdownTimeout by default.
+func (s *ManagedStack) SetLayerTimeout(timeout time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.timeout = timeout
+}
+
+// AddTask registers a background task using the stack, e.g. a stats poller
+// or a compaction scheduler, which stop stops. Tasks are stopped before any
+// layer is shut down, the last one added first.
+func (s *ManagedStack) AddTask(name string, stop func(ctx context.Context) error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.tasks = append(s.tasks, stackTask{name: name, stop: stop})
+}
+
+// Close stops the tasks, then shuts the layers down outside-in: Shutdowners
+// are shut down, wrappers unwrapping to the layer below are left for their
+// Close to be called through the innermost DB, and other DBs are closed.
+// Each of them gets the layer timeout, past which Close moves on to the
+// next one. Failures are returned as an ErrStackShutdown. Closing the stack
+// again does nothing.
+func (s *ManagedStack) Close(ctx context.Context) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+	tasks, timeout := s.tasks, s.timeout
+	s.mtx.Unlock()
+
+	failures := &ErrStackShutdown{}
+	for i := len(tasks) - 1; i >= 0; i-- {
+		failures.add("task "+tasks[i].name, shutdownWithin(ctx, timeout, tasks[i].stop))
+	}
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		layer := s.layers[i]
+		var shutdown func(context.Context) error
+		switch db := layer.db.(type) {
+		case Shutdowner:
+			shutdown = db.Shutdown
+		case Unwrapper:
+			if layer.below != nil && db.Unwrap() == layer.below {
+				continue
+			}
+			shutdown = closer(layer.db)
+		default:
+			shutdown = closer(layer.db)
+		}
+		failures.add(layer.name, shutdownWithin(ctx, timeout, shutdown))
+	}
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	return nil
+}
+
+func closer(db dbm.DB) func(context.Context) error {
+	return func(context.Context) error {
+		return db.Close()
+	}
+}
+
+// shutdownWithin runs shutdown, giving up on it past timeout or once ctx is
+// done.
+func shutdownWithin(ctx context.Context, timeout time.Duration, shutdown func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- shutdown(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("gave up shutting down: %w", ctx.Err())
+	}
+}
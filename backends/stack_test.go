@@ -0,0 +1,149 @@
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// shutdownLog records the layers of a stack as they shut down.
+type shutdownLog struct {
+	mtx   sync.Mutex
+	names []string
+}
+
+func (l *shutdownLog) record(name string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.names = append(l.names, name)
+}
+
+func (l *shutdownLog) recorded() []string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return append([]string{}, l.names...)
+}
+
+type closeRecordingDB struct {
+	*dbm.MemDB
+	log *shutdownLog
+}
+
+func (db *closeRecordingDB) Close() error {
+	db.log.record("inner")
+	return db.MemDB.Close()
+}
+
+type shutdownRecordingDB struct {
+	dbm.DB
+	name  string
+	log   *shutdownLog
+	delay time.Duration
+	err   error
+}
+
+func (db *shutdownRecordingDB) Shutdown(ctx context.Context) error {
+	select {
+	case <-time.After(db.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	db.log.record(db.name)
+	return db.err
+}
+
+func newTestStack(t *testing.T, log *shutdownLog, slowDelay time.Duration, outerErr error) *ManagedStack {
+	stack, err := NewManagedStack(
+		func(dbm.DB) (dbm.DB, error) {
+			return &closeRecordingDB{MemDB: dbm.NewMemDB(), log: log}, nil
+		},
+		func(below dbm.DB) (dbm.DB, error) {
+			return &shutdownRecordingDB{DB: below, name: "slow", log: log, delay: slowDelay}, nil
+		},
+		func(below dbm.DB) (dbm.DB, error) {
+			return &shutdownRecordingDB{DB: below, name: "outer", log: log, err: outerErr}, nil
+		},
+	)
+	require.Nil(t, err)
+	return stack
+}
+
+func TestManagedStackClose(t *testing.T) {
+	log := &shutdownLog{}
+	stack := newTestStack(t, log, 10*time.Millisecond, nil)
+	require.Nil(t, stack.DB().Set([]byte("a"), []byte("1")))
+	stack.AddTask("poller", func(context.Context) error {
+		log.record("poller")
+		return nil
+	})
+	require.Nil(t, stack.Close(context.Background()))
+	require.Equal(t, []string{"poller", "outer", "slow", "inner"}, log.recorded())
+	require.Nil(t, stack.Close(context.Background()))
+	require.Len(t, log.recorded(), 4)
+}
+
+func TestManagedStackCloseFailures(t *testing.T) {
+	log := &shutdownLog{}
+	outerErr := errors.New("queue not drained")
+	stack := newTestStack(t, log, time.Hour, outerErr)
+	stack.SetLayerTimeout(20 * time.Millisecond)
+
+	start := time.Now()
+	err := stack.Close(context.Background())
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, []string{"outer", "inner"}, log.recorded())
+
+	shutdownErr := &ErrStackShutdown{}
+	require.True(t, errors.As(err, &shutdownErr))
+	names, errs := shutdownErr.Failures()
+	require.Equal(t, []string{
+		"layer 2 (*backends.shutdownRecordingDB)",
+		"layer 1 (*backends.shutdownRecordingDB)",
+	}, names)
+	require.Equal(t, outerErr, errs[0])
+	require.True(t, errors.Is(errs[1], context.DeadlineExceeded))
+	require.True(t, errors.Is(err, outerErr))
+	require.Contains(t, err.Error(), "layer 1 (*backends.shutdownRecordingDB): gave up shutting down")
+}
+
+func TestManagedStackWrappers(t *testing.T) {
+	// wrappers are closed through the innermost DB, once
+	log := &shutdownLog{}
+	stack, err := NewManagedStack(
+		func(dbm.DB) (dbm.DB, error) {
+			return &closeRecordingDB{MemDB: dbm.NewMemDB(), log: log}, nil
+		},
+		func(below dbm.DB) (dbm.DB, error) {
+			return NewRetryDB(below, RetryPolicy{}), nil
+		},
+		func(below dbm.DB) (dbm.DB, error) {
+			return NewMetricsDB(below, MetricsOptions{}), nil
+		},
+	)
+	require.Nil(t, err)
+	require.Nil(t, stack.Close(context.Background()))
+	require.Equal(t, []string{"inner"}, log.recorded())
+}
+
+func TestManagedStackBuildFailure(t *testing.T) {
+	log := &shutdownLog{}
+	_, err := NewManagedStack(
+		func(dbm.DB) (dbm.DB, error) {
+			return &closeRecordingDB{MemDB: dbm.NewMemDB(), log: log}, nil
+		},
+		func(dbm.DB) (dbm.DB, error) {
+			return nil, errors.New("no key")
+		},
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to build layer 1: no key")
+	require.Equal(t, []string{"inner"}, log.recorded())
+
+	_, err = NewManagedStack()
+	require.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package backends
+
+import (
+	"bytes"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// BatchInspector is implemented by batches able to tell their pending ops,
+// such as those returned by NewDedupingBatch.
+type BatchInspector interface {
+	dbm.Batch
+	// Pending returns the value the last pending op of key sets it to, nil
+	// if it deletes it, and false if key has no pending op.
+	Pending(key []byte) (value []byte, ok bool)
+	// ForEach calls fn with the last pending op of every key, in ascending
+	// key order, value being nil for deletes.
+	ForEach(fn func(key, value []byte) error) error
+}
+
+// StagedView reads a DB as it will be once a batch is written, so that
+// values staged into the batch can be read back before then. It reads the
+// batch as it grows, and writes to the view are staged into it.
+type StagedView struct {
+	dbm.DB
+	batch BatchInspector
+}
+
+var _ dbm.DB = (*StagedView)(nil)
+
+func NewStagedView(db dbm.DB, batch BatchInspector) *StagedView {
+	return &StagedView{DB: db, batch: batch}
+}
+
+// Unwrap implements Unwrapper.
+func (v *StagedView) Unwrap() dbm.DB {
+	return v.DB
+}
+
+// Get implements DB.
+func (v *StagedView) Get(key []byte) ([]byte, error) {
+	if value, ok := v.batch.Pending(key); ok {
+		return value, nil
+	}
+	return v.DB.Get(key)
+}
+
+// Has implements DB.
+func (v *StagedView) Has(key []byte) (bool, error) {
+	if value, ok := v.batch.Pending(key); ok {
+		return value != nil, nil
+	}
+	return v.DB.Has(key)
+}
+
+// Set implements DB, staging the write into the batch.
+func (v *StagedView) Set(key []byte, value []byte) error {
+	return v.batch.Set(key, value)
+}
+
+// SetSync implements DB, staging the write into the batch.
+func (v *StagedView) SetSync(key []byte, value []byte) error {
+	return v.batch.Set(key, value)
+}
+
+// Delete implements DB, staging the write into the batch.
+func (v *StagedView) Delete(key []byte) error {
+	return v.batch.Delete(key)
+}
+
+// DeleteSync implements DB, staging the write into the batch.
+func (v *StagedView) DeleteSync(key []byte) error {
+	return v.batch.Delete(key)
+}
+
+// Iterator implements DB. The pending ops in the range are read when the
+// iterator is created.
+func (v *StagedView) Iterator(start, end []byte) (dbm.Iterator, error) {
+	return v.iterator(start, end, false)
+}
+
+// ReverseIterator implements DB. See Iterator.
+func (v *StagedView) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	return v.iterator(start, end, true)
+}
+
+func (v *StagedView) iterator(start, end []byte, reverse bool) (dbm.Iterator, error) {
+	var base dbm.Iterator
+	var err error
+	if reverse {
+		base, err = v.DB.ReverseIterator(start, end)
+	} else {
+		base, err = v.DB.Iterator(start, end)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pending := []batchOp{}
+	err = v.batch.ForEach(func(key, value []byte) error {
+		if bytes.Compare(key, start) >= 0 && (end == nil || bytes.Compare(key, end) < 0) {
+			pending = append(pending, batchOp{key: key, value: value})
+		}
+		return nil
+	})
+	if err != nil {
+		base.Close()
+		return nil, err
+	}
+	if reverse {
+		for i, j := 0, len(pending)-1; i < j; i, j = i+1, j-1 {
+			pending[i], pending[j] = pending[j], pending[i]
+		}
+	}
+	iter := &stagedIterator{base: base, pending: pending, reverse: reverse, start: start, end: end}
+	iter.advance()
+	return iter, nil
+}
+
+// stagedIterator merges the pending ops of a batch into an iterator of the
+// DB, pending ops overriding the DB and deletes hiding its keys.
+type stagedIterator struct {
+	base dbm.Iterator
+	// pending ops left, in iteration order
+	pending    []batchOp
+	reverse    bool
+	start, end []byte
+
+	key, value []byte
+	valid      bool
+	guard      iteratorGuard
+}
+
+var _ dbm.Iterator = (*stagedIterator)(nil)
+
+// compare compares a and b in iteration order.
+func (itr *stagedIterator) compare(a, b []byte) int {
+	if itr.reverse {
+		return bytes.Compare(b, a)
+	}
+	return bytes.Compare(a, b)
+}
+
+// advance moves to the next key which isn't deleted by a pending op.
+func (itr *stagedIterator) advance() {
+	for {
+		if len(itr.pending) == 0 || (itr.base.Valid() && itr.compare(itr.base.Key(), itr.pending[0].key) < 0) {
+			itr.valid = itr.base.Valid()
+			if itr.valid {
+				itr.key, itr.value = itr.base.Key(), itr.base.Value()
+				itr.base.Next()
+			}
+			return
+		}
+		op := itr.pending[0]
+		itr.pending = itr.pending[1:]
+		if itr.base.Valid() && bytes.Equal(itr.base.Key(), op.key) {
+			itr.base.Next()
+		}
+		if op.value != nil {
+			itr.key, itr.value, itr.valid = op.key, op.value, true
+			return
+		}
+	}
+}
+
+// Domain implements Iterator.
+func (itr *stagedIterator) Domain() ([]byte, []byte) {
+	return itr.start, itr.end
+}
+
+// Valid implements Iterator.
+func (itr *stagedIterator) Valid() bool {
+	return itr.guard.valid(itr.valid)
+}
+
+// Key implements Iterator.
+func (itr *stagedIterator) Key() []byte {
+	itr.guard.assertValid(itr.valid)
+	return itr.key
+}
+
+// Value implements Iterator.
+func (itr *stagedIterator) Value() []byte {
+	itr.guard.assertValid(itr.valid)
+	return itr.value
+}
+
+// Next implements Iterator.
+func (itr *stagedIterator) Next() {
+	itr.guard.assertValid(itr.valid)
+	itr.advance()
+}
+
+// Error implements Iterator.
+func (itr *stagedIterator) Error() error {
+	return itr.base.Error()
+}
+
+// Close implements Iterator.
+func (itr *stagedIterator) Close() error {
+	if !itr.guard.close() {
+		return nil
+	}
+	return itr.base.Close()
+}
@@ -0,0 +1,127 @@
+package backends
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// writtenTruth returns a copy of db with ops written through a plain batch.
+func writtenTruth(t *testing.T, db dbm.DB, ops []batchOp) dbm.DB {
+	truth := dbm.NewMemDB()
+	iter, err := db.Iterator(nil, nil)
+	require.Nil(t, err)
+	for ; iter.Valid(); iter.Next() {
+		require.Nil(t, truth.Set(iter.Key(), iter.Value()))
+	}
+	require.Nil(t, iter.Close())
+	batch := truth.NewBatch()
+	for _, op := range ops {
+		if op.value == nil {
+			require.Nil(t, batch.Delete(op.key))
+		} else {
+			require.Nil(t, batch.Set(op.key, op.value))
+		}
+	}
+	require.Nil(t, batch.Write())
+	return truth
+}
+
+func requireSameReads(t *testing.T, r *rand.Rand, expected, actual dbm.DB, keys int) {
+	for i := 0; i < keys; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		value, err := actual.Get(key)
+		require.Nil(t, err)
+		expectedValue, err := expected.Get(key)
+		require.Nil(t, err)
+		require.Equal(t, expectedValue, value)
+		has, err := actual.Has(key)
+		require.Nil(t, err)
+		require.Equal(t, expectedValue != nil, has)
+	}
+	for i := 0; i < 10; i++ {
+		var start, end []byte
+		if r.Intn(4) > 0 {
+			start = []byte(fmt.Sprintf("key%02d", r.Intn(keys)))
+		}
+		if r.Intn(4) > 0 {
+			end = []byte(fmt.Sprintf("key%02d", r.Intn(keys)))
+			if start != nil && string(end) < string(start) {
+				start, end = end, start
+			}
+		}
+		for _, reverse := range []bool{false, true} {
+			var expectedIter, iter dbm.Iterator
+			var err error
+			if reverse {
+				expectedIter, err = expected.ReverseIterator(start, end)
+				require.Nil(t, err)
+				iter, err = actual.ReverseIterator(start, end)
+			} else {
+				expectedIter, err = expected.Iterator(start, end)
+				require.Nil(t, err)
+				iter, err = actual.Iterator(start, end)
+			}
+			require.Nil(t, err)
+			require.Equal(t, collectPairs(t, expectedIter), collectPairs(t, iter), "[%s, %s) reverse %v", start, end, reverse)
+		}
+	}
+}
+
+func TestStagedViewMatchesWrittenBatch(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		keys := 1 + r.Intn(30)
+		db := dbm.NewMemDB()
+		for i := 0; i < keys; i++ {
+			if r.Intn(2) == 0 {
+				require.Nil(t, db.Set([]byte(fmt.Sprintf("key%02d", i)), []byte("initial")))
+			}
+		}
+		batch := NewDedupingBatch(db)
+		view := NewStagedView(db, batch)
+		ops := []batchOp{}
+		for round := 0; round < 5; round++ {
+			// the view follows the batch as it grows
+			for i := r.Intn(20); i > 0; i-- {
+				op := batchOp{key: []byte(fmt.Sprintf("key%02d", r.Intn(keys)))}
+				if r.Intn(3) > 0 {
+					op.value = []byte(fmt.Sprintf("value%d-%d", round, i))
+					require.Nil(t, batch.Set(op.key, op.value))
+				} else {
+					require.Nil(t, batch.Delete(op.key))
+				}
+				ops = append(ops, op)
+			}
+			requireSameReads(t, r, writtenTruth(t, db, ops), view, keys)
+		}
+		truth := writtenTruth(t, db, ops)
+		require.Nil(t, batch.Write())
+		requireSameReads(t, r, truth, view, keys)
+		requireSameReads(t, r, truth, db, keys)
+	}
+}
+
+func TestStagedViewWrites(t *testing.T) {
+	db := dbm.NewMemDB()
+	require.Nil(t, db.Set([]byte("a"), []byte("1")))
+	batch := NewDedupingBatch(db)
+	view := NewStagedView(db, batch)
+	require.Nil(t, view.Set([]byte("b"), []byte("2")))
+	require.Nil(t, view.Delete([]byte("a")))
+
+	has, err := db.Has([]byte("b"))
+	require.Nil(t, err)
+	require.False(t, has)
+	iter, err := view.Iterator(nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []KVPair{{Key: []byte("b"), Value: []byte("2")}}, collectPairs(t, iter))
+
+	require.Nil(t, batch.Write())
+	value, err := db.Get([]byte("b"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("2"), value)
+}
@@ -0,0 +1,197 @@
+package backends
+
+import (
+	"encoding/json"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Codec encodes the values of a Store.
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(bz []byte) (T, error)
+}
+
+// ProtoMessage is implemented by protobuf messages, gogoproto generated
+// ones included, without depending on a protobuf library.
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(bz []byte) error
+}
+
+type protoCodec[T ProtoMessage] struct {
+	new func() T
+}
+
+// ProtoCodec encodes protobuf messages, decoding them into the messages
+// returned by newMessage.
+func ProtoCodec[T ProtoMessage](newMessage func() T) Codec[T] {
+	return protoCodec[T]{new: newMessage}
+}
+
+func (c protoCodec[T]) Marshal(v T) ([]byte, error) {
+	return v.Marshal()
+}
+
+func (c protoCodec[T]) Unmarshal(bz []byte) (T, error) {
+	v := c.new()
+	return v, v.Unmarshal(bz)
+}
+
+type jsonCodec[T any] struct{}
+
+// JSONCodec encodes values as JSON.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+func (jsonCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Unmarshal(bz []byte) (T, error) {
+	var v T
+	return v, json.Unmarshal(bz, &v)
+}
+
+type bytesCodec struct{}
+
+// BytesCodec stores values as is.
+var BytesCodec Codec[[]byte] = bytesCodec{}
+
+func (bytesCodec) Marshal(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (bytesCodec) Unmarshal(bz []byte) ([]byte, error) {
+	return bz, nil
+}
+
+// Store stores values of type T under a prefix of a DB, so that consumers
+// don't encode and decode them around every Get and Set. Keys are relative
+// to the prefix.
+type Store[T any] struct {
+	db     dbm.DB
+	prefix Prefix
+	codec  Codec[T]
+}
+
+// NewStore returns a store of the values under prefix in db, encoded with
+// codec. Stores sharing a DB must not have overlapping prefixes.
+func NewStore[T any](db dbm.DB, prefix []byte, codec Codec[T]) *Store[T] {
+	return &Store[T]{db: db, prefix: append(Prefix{}, prefix...), codec: codec}
+}
+
+func (s *Store[T]) decode(key, bz []byte) (T, error) {
+	v, err := s.codec.Unmarshal(bz)
+	if err != nil {
+		return v, &ErrUndecodableStoreValue{key: append([]byte{}, key...), err: err}
+	}
+	return v, nil
+}
+
+// Get returns the value of key, and whether there is one.
+func (s *Store[T]) Get(key []byte) (T, bool, error) {
+	var v T
+	bz, err := s.db.Get(s.prefix.Key(key))
+	if err != nil || bz == nil {
+		return v, false, err
+	}
+	v, err = s.decode(key, bz)
+	return v, err == nil, err
+}
+
+// Has returns whether key has a value, without decoding it.
+func (s *Store[T]) Has(key []byte) (bool, error) {
+	return s.db.Has(s.prefix.Key(key))
+}
+
+// Set sets the value of key.
+func (s *Store[T]) Set(key []byte, v T) error {
+	bz, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Set(s.prefix.Key(key), bz)
+}
+
+// Delete deletes the value of key.
+func (s *Store[T]) Delete(key []byte) error {
+	return s.db.Delete(s.prefix.Key(key))
+}
+
+// Iterate calls fn with the keys from start to end, exclusive, and their
+// values in ascending key order, nil bounds being those of the store,
+// until fn returns stop or an error. Values are decoded one at a time as
+// fn is called, and a value failing to decode stops the iteration with an
+// ErrUndecodableStoreValue.
+func (s *Store[T]) Iterate(start, end []byte, fn func(key []byte, v T) (stop bool, err error)) error {
+	iterStart, iterEnd := []byte(s.prefix), s.prefix.End()
+	if start != nil {
+		iterStart = s.prefix.Key(start)
+	}
+	if end != nil {
+		iterEnd = s.prefix.Key(end)
+	}
+	if len(iterStart) == 0 {
+		// the empty key is not a valid start
+		iterStart = nil
+	}
+	iter, err := s.db.Iterator(iterStart, iterEnd)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()[len(s.prefix):]
+		v, err := s.decode(key, iter.Value())
+		if err != nil {
+			return err
+		}
+		if stop, err := fn(key, v); stop || err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// NewBatch returns a batch staging writes to the store, which are only
+// written once the batch is.
+func (s *Store[T]) NewBatch() *StoreBatch[T] {
+	return &StoreBatch[T]{store: s, batch: s.db.NewBatch()}
+}
+
+// StoreBatch stages writes to a Store.
+type StoreBatch[T any] struct {
+	store *Store[T]
+	batch dbm.Batch
+}
+
+// Set stages setting the value of key.
+func (b *StoreBatch[T]) Set(key []byte, v T) error {
+	bz, err := b.store.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.batch.Set(b.store.prefix.Key(key), bz)
+}
+
+// Delete stages deleting the value of key.
+func (b *StoreBatch[T]) Delete(key []byte) error {
+	return b.batch.Delete(b.store.prefix.Key(key))
+}
+
+// Write writes the staged writes, see Batch.
+func (b *StoreBatch[T]) Write() error {
+	return b.batch.Write()
+}
+
+// WriteSync writes the staged writes synchronously, see Batch.
+func (b *StoreBatch[T]) WriteSync() error {
+	return b.batch.WriteSync()
+}
+
+// Close discards the staged writes unless written, see Batch.
+func (b *StoreBatch[T]) Close() error {
+	return b.batch.Close()
+}
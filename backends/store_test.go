@@ -0,0 +1,174 @@
+package backends
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// counter is a hand-written protobuf message: a single varint field 1.
+type counter struct {
+	count uint64
+}
+
+func (c *counter) Marshal() ([]byte, error) {
+	bz := make([]byte, 1+binary.MaxVarintLen64)
+	bz[0] = 0x08
+	return bz[:1+binary.PutUvarint(bz[1:], c.count)], nil
+}
+
+func (c *counter) Unmarshal(bz []byte) error {
+	if len(bz) < 2 || bz[0] != 0x08 {
+		return errors.New("not a counter")
+	}
+	count, n := binary.Uvarint(bz[1:])
+	if n <= 0 || n != len(bz)-1 {
+		return errors.New("not a counter")
+	}
+	c.count = count
+	return nil
+}
+
+type account struct {
+	Name    string `json:"name"`
+	Balance int    `json:"balance"`
+}
+
+func collectStore[T any](t *testing.T, store *Store[T], start, end []byte) map[string]T {
+	values := map[string]T{}
+	require.Nil(t, store.Iterate(start, end, func(key []byte, v T) (bool, error) {
+		values[string(key)] = v
+		return false, nil
+	}))
+	return values
+}
+
+func TestStoreCodecs(t *testing.T) {
+	db := dbm.NewMemDB()
+
+	counters := NewStore(db, []byte("c/"), ProtoCodec(func() *counter { return &counter{} }))
+	require.Nil(t, counters.Set([]byte("a"), &counter{count: 300}))
+	v, ok, err := counters.Get([]byte("a"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, uint64(300), v.count)
+
+	accounts := NewStore(db, []byte("a/"), JSONCodec[account]())
+	require.Nil(t, accounts.Set([]byte("alice"), account{Name: "Alice", Balance: 5}))
+	acc, ok, err := accounts.Get([]byte("alice"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, account{Name: "Alice", Balance: 5}, acc)
+	raw, err := db.Get([]byte("a/alice"))
+	require.Nil(t, err)
+	require.JSONEq(t, `{"name": "Alice", "balance": 5}`, string(raw))
+
+	blobs := NewStore(db, []byte("b/"), BytesCodec)
+	require.Nil(t, blobs.Set([]byte("x"), []byte{1, 2}))
+	blob, ok, err := blobs.Get([]byte("x"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 2}, blob)
+
+	acc, ok, err = accounts.Get([]byte("bob"))
+	require.Nil(t, err)
+	require.False(t, ok)
+	require.Equal(t, account{}, acc)
+
+	require.Nil(t, accounts.Delete([]byte("alice")))
+	has, err := accounts.Has([]byte("alice"))
+	require.Nil(t, err)
+	require.False(t, has)
+}
+
+func TestStorePrefixIsolation(t *testing.T) {
+	db := dbm.NewMemDB()
+	first := NewStore(db, []byte("a"), BytesCodec)
+	second := NewStore(db, []byte("b"), BytesCodec)
+	for i := 0; i < 3; i++ {
+		key := []byte(fmt.Sprintf("%d", i))
+		require.Nil(t, first.Set(key, []byte("first")))
+		require.Nil(t, second.Set(key, []byte("second")))
+	}
+	require.Nil(t, db.Set([]byte("c0"), []byte("other")))
+
+	require.Equal(t, map[string][]byte{
+		"0": []byte("first"), "1": []byte("first"), "2": []byte("first"),
+	}, collectStore(t, first, nil, nil))
+	require.Equal(t, map[string][]byte{
+		"1": []byte("second"),
+	}, collectStore(t, second, []byte("1"), []byte("2")))
+
+	require.Nil(t, first.Delete([]byte("1")))
+	value, ok, err := second.Get([]byte("1"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "second", string(value))
+}
+
+func TestStoreIterateStop(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := NewStore(db, []byte("s/"), JSONCodec[int]())
+	for i := 0; i < 5; i++ {
+		require.Nil(t, store.Set([]byte{byte(i)}, i))
+	}
+	seen := []int{}
+	require.Nil(t, store.Iterate(nil, nil, func(key []byte, v int) (bool, error) {
+		seen = append(seen, v)
+		return v == 2, nil
+	}))
+	require.Equal(t, []int{0, 1, 2}, seen)
+
+	failure := errors.New("failure")
+	require.Equal(t, failure, store.Iterate(nil, nil, func(key []byte, v int) (bool, error) {
+		return false, failure
+	}))
+}
+
+func TestStoreDecodeErrors(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := NewStore(db, []byte("s/"), ProtoCodec(func() *counter { return &counter{} }))
+	require.Nil(t, store.Set([]byte("a"), &counter{count: 1}))
+	require.Nil(t, db.Set([]byte("s/b"), []byte("garbage")))
+	require.Nil(t, store.Set([]byte("c"), &counter{count: 3}))
+
+	_, _, err := store.Get([]byte("b"))
+	decodeErr := &ErrUndecodableStoreValue{}
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, []byte("b"), decodeErr.Key())
+
+	// values are decoded as iterated, the ones before the undecodable one
+	// being passed on
+	seen := []string{}
+	err = store.Iterate(nil, nil, func(key []byte, v *counter) (bool, error) {
+		seen = append(seen, string(key))
+		return false, nil
+	})
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, []byte("b"), decodeErr.Key())
+	require.Contains(t, err.Error(), "not a counter")
+	require.Equal(t, []string{"a"}, seen)
+}
+
+func TestStoreBatch(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := NewStore(db, []byte("s/"), JSONCodec[string]())
+	require.Nil(t, store.Set([]byte("old"), "value"))
+
+	batch := store.NewBatch()
+	require.Nil(t, batch.Set([]byte("new"), "value"))
+	require.Nil(t, batch.Delete([]byte("old")))
+	require.Equal(t, map[string]string{"old": "value"}, collectStore(t, store, nil, nil))
+	require.Nil(t, batch.Write())
+	require.Nil(t, batch.Close())
+	require.Equal(t, map[string]string{"new": "value"}, collectStore(t, store, nil, nil))
+
+	batch = store.NewBatch()
+	require.Nil(t, batch.Set([]byte("discarded"), "value"))
+	require.Nil(t, batch.Close())
+	require.Equal(t, map[string]string{"new": "value"}, collectStore(t, store, nil, nil))
+}
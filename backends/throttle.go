@@ -0,0 +1,222 @@
+package backends
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// PressureProbe samples how far behind the backend is in absorbing writes,
+// e.g. in pending compaction bytes or level-0 tables.
+type PressureProbe func() (float64, error)
+
+// GoLevelDBLevel0Probe samples the number of level-0 tables of db, which
+// goleveldb slows writes down at 8 by default and stops them at 12.
+func GoLevelDBLevel0Probe(db *dbm.GoLevelDB) PressureProbe {
+	return func() (float64, error) {
+		value, err := db.DB().GetProperty("leveldb.num-files-at-level0")
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseFloat(value, 64)
+	}
+}
+
+// ThrottlePolicy decides how a ThrottledDB throttles writes given the last
+// pressure sampled.
+type ThrottlePolicy struct {
+	// SampleInterval is the minimum time between samples of the pressure,
+	// which are taken on writes.
+	SampleInterval time.Duration
+	// Delay returns how long each write waits at pressure, if set.
+	Delay func(pressure float64) time.Duration
+	// Rate returns how many writes per second are let through at pressure,
+	// 0 meaning unlimited, if set. Up to a second of unused rate is kept
+	// for bursts.
+	Rate func(pressure float64) float64
+	// BypassSync lets SetSync, DeleteSync and WriteSync of batches through
+	// unthrottled.
+	BypassSync bool
+}
+
+// LinearDelay returns a ThrottlePolicy.Delay growing from 0 at pressure soft
+// to max at pressure hard and above.
+func LinearDelay(soft, hard float64, max time.Duration) func(float64) time.Duration {
+	return func(pressure float64) time.Duration {
+		switch {
+		case pressure <= soft:
+			return 0
+		case pressure >= hard:
+			return max
+		}
+		return time.Duration(float64(max) * (pressure - soft) / (hard - soft))
+	}
+}
+
+// ThrottledDB wraps a DB to slow its writes down while the backend is under
+// pressure, rather than letting it stall. Changes of the throttle are
+// emitted as EventThrottleChanged events.
+type ThrottledDB struct {
+	dbm.DB
+	probe  PressureProbe
+	policy ThrottlePolicy
+	now    func() time.Time
+	sleep  func(time.Duration)
+
+	mtx       sync.Mutex
+	sampledAt time.Time
+	pressure  float64
+	probeErr  error
+	delay     time.Duration
+	rate      float64
+	// token bucket of the rate, negative when writes wait for tokens
+	tokens   float64
+	refillAt time.Time
+
+	throttledWrites int64
+	throttledTime   time.Duration
+}
+
+var _ dbm.DB = (*ThrottledDB)(nil)
+
+func NewThrottledDB(db dbm.DB, probe PressureProbe, policy ThrottlePolicy) *ThrottledDB {
+	return &ThrottledDB{DB: db, probe: probe, policy: policy, now: time.Now, sleep: time.Sleep}
+}
+
+// Unwrap implements Unwrapper.
+func (db *ThrottledDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+// sample samples the pressure if due and updates the throttle. It must be
+// called with mtx held.
+func (db *ThrottledDB) sample(now time.Time) {
+	if !db.sampledAt.IsZero() && now.Sub(db.sampledAt) < db.policy.SampleInterval {
+		return
+	}
+	db.sampledAt = now
+	pressure, err := db.probe()
+	db.probeErr = err
+	if err != nil {
+		// keep throttling according to the last successful sample
+		return
+	}
+	db.pressure = pressure
+	delay, rate := time.Duration(0), float64(0)
+	if db.policy.Delay != nil {
+		delay = db.policy.Delay(pressure)
+	}
+	if db.policy.Rate != nil {
+		rate = db.policy.Rate(pressure)
+	}
+	if delay == db.delay && rate == db.rate {
+		return
+	}
+	if rate > 0 && db.rate == 0 {
+		db.tokens, db.refillAt = 0, now
+	}
+	db.delay, db.rate = delay, rate
+	emitEvent(EventThrottleChanged, backendName(db.DB), "", fmt.Sprintf("pressure %g: delay %s, rate %g/s", pressure, delay, rate))
+}
+
+// wait returns how long a write has to wait, reserving a token if writes
+// are rate limited.
+func (db *ThrottledDB) wait() time.Duration {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	now := db.now()
+	db.sample(now)
+	wait := db.delay
+	if db.rate > 0 {
+		db.tokens += now.Sub(db.refillAt).Seconds() * db.rate
+		if db.tokens > db.rate {
+			db.tokens = db.rate
+		}
+		db.refillAt = now
+		db.tokens--
+		if db.tokens < 0 {
+			wait += time.Duration(-db.tokens / db.rate * float64(time.Second))
+		}
+	}
+	if wait > 0 {
+		db.throttledWrites++
+		db.throttledTime += wait
+	}
+	return wait
+}
+
+// throttle waits before a write, unless sync writes bypass the throttle.
+func (db *ThrottledDB) throttle(sync bool) {
+	if sync && db.policy.BypassSync {
+		return
+	}
+	if wait := db.wait(); wait > 0 {
+		db.sleep(wait)
+	}
+}
+
+// Set implements DB.
+func (db *ThrottledDB) Set(key []byte, value []byte) error {
+	db.throttle(false)
+	return db.DB.Set(key, value)
+}
+
+// SetSync implements DB.
+func (db *ThrottledDB) SetSync(key []byte, value []byte) error {
+	db.throttle(true)
+	return db.DB.SetSync(key, value)
+}
+
+// Delete implements DB.
+func (db *ThrottledDB) Delete(key []byte) error {
+	db.throttle(false)
+	return db.DB.Delete(key)
+}
+
+// DeleteSync implements DB.
+func (db *ThrottledDB) DeleteSync(key []byte) error {
+	db.throttle(true)
+	return db.DB.DeleteSync(key)
+}
+
+// NewBatch implements DB. Batches are throttled as a single write.
+func (db *ThrottledDB) NewBatch() dbm.Batch {
+	return &throttledBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+// Stats implements DB, adding the state of the throttle.
+func (db *ThrottledDB) Stats() map[string]string {
+	stats := db.DB.Stats()
+	if stats == nil {
+		stats = map[string]string{}
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	stats["throttle.pressure"] = fmt.Sprint(db.pressure)
+	stats["throttle.delay"] = db.delay.String()
+	stats["throttle.rate"] = fmt.Sprint(db.rate)
+	stats["throttle.throttled_writes"] = fmt.Sprint(db.throttledWrites)
+	stats["throttle.throttled_time"] = db.throttledTime.String()
+	if db.probeErr != nil {
+		stats["throttle.probe_error"] = db.probeErr.Error()
+	}
+	return stats
+}
+
+type throttledBatch struct {
+	dbm.Batch
+	db *ThrottledDB
+}
+
+func (b *throttledBatch) Write() error {
+	b.db.throttle(false)
+	return b.Batch.Write()
+}
+
+func (b *throttledBatch) WriteSync() error {
+	b.db.throttle(true)
+	return b.Batch.WriteSync()
+}
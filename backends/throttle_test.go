@@ -0,0 +1,144 @@
+package backends
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// rampingProbe returns a pressure growing by step every time it is sampled.
+type rampingProbe struct {
+	pressure, step float64
+	err            error
+}
+
+func (p *rampingProbe) probe() (float64, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	pressure := p.pressure
+	p.pressure += p.step
+	return pressure, nil
+}
+
+func newTestThrottledDB(probe *rampingProbe, policy ThrottlePolicy) (*ThrottledDB, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	db := NewThrottledDB(dbm.NewMemDB(), probe.probe, policy)
+	db.now, db.sleep = clock.Now, clock.Advance
+	return db, clock
+}
+
+// writesPerSecond writes for a second of the fake clock and returns how
+// many writes went through.
+func writesPerSecond(t *testing.T, db *ThrottledDB, clock *fakeClock, write func() error) int {
+	writes := 0
+	for end := clock.Now().Add(time.Second); clock.Now().Before(end); writes++ {
+		require.Nil(t, write())
+		// writes take a millisecond, unthrottled
+		clock.Advance(time.Millisecond)
+	}
+	return writes
+}
+
+func TestThrottledDBDelay(t *testing.T) {
+	probe := &rampingProbe{pressure: 0, step: 1}
+	db, clock := newTestThrottledDB(probe, ThrottlePolicy{
+		SampleInterval: time.Second,
+		Delay:          LinearDelay(1, 5, 9*time.Millisecond),
+	})
+	events := []string{}
+	var eventsMtx sync.Mutex
+	sub := Subscribe(func(event Event) {
+		if event.Kind == EventThrottleChanged {
+			eventsMtx.Lock()
+			events = append(events, event.Detail)
+			eventsMtx.Unlock()
+		}
+	})
+
+	// each second samples the next pressure: 0, 1, 2, ..., with a delay
+	// growing by 2.25ms per step from pressure 1 up to 9ms at 5
+	expected := []int{1000, 1000, 308, 182, 129, 100, 100}
+	i := 0
+	for _, want := range expected {
+		writes := writesPerSecond(t, db, clock, func() error {
+			i++
+			return db.Set([]byte{byte(i)}, []byte("value"))
+		})
+		require.InDelta(t, want, writes, 2)
+	}
+	stats := db.Stats()
+	require.Equal(t, "6", stats["throttle.pressure"])
+	require.Equal(t, "9ms", stats["throttle.delay"])
+	require.NotEqual(t, "0", stats["throttle.throttled_writes"])
+
+	sub.Unsubscribe()
+	// the delay changed at pressures 2, 3, 4 and 5
+	require.Len(t, events, 4)
+	require.Contains(t, events[3], "delay 9ms")
+}
+
+func TestThrottledDBRate(t *testing.T) {
+	probe := &rampingProbe{pressure: 0, step: 1}
+	db, clock := newTestThrottledDB(probe, ThrottlePolicy{
+		SampleInterval: time.Second,
+		Rate: func(pressure float64) float64 {
+			if pressure == 0 {
+				return 0
+			}
+			return 400 / pressure
+		},
+		BypassSync: true,
+	})
+	// unlimited, then 400, 200 and 100 writes per second
+	expected := []int{1000, 400, 200, 133, 100}
+	i := 0
+	for _, want := range expected {
+		writes := writesPerSecond(t, db, clock, func() error {
+			i++
+			batch := db.NewBatch()
+			defer batch.Close()
+			require.Nil(t, batch.Set([]byte{byte(i)}, []byte("value")))
+			return batch.Write()
+		})
+		// the burst of unused rate lets a few more writes through
+		require.InDelta(t, want, writes, float64(want)/10+2)
+	}
+
+	// sync writes bypass the throttle
+	writes := writesPerSecond(t, db, clock, func() error {
+		i++
+		return db.SetSync([]byte{byte(i)}, []byte("value"))
+	})
+	require.Equal(t, 1000, writes)
+}
+
+func TestThrottledDBProbeError(t *testing.T) {
+	probe := &rampingProbe{pressure: 10}
+	db, clock := newTestThrottledDB(probe, ThrottlePolicy{
+		SampleInterval: time.Second,
+		Delay:          LinearDelay(0, 10, 10*time.Millisecond),
+	})
+	require.Nil(t, db.Delete([]byte("key")))
+	require.Equal(t, time.Unix(0, 0).Add(10*time.Millisecond), clock.Now())
+
+	// failed samples keep the last throttle
+	probe.err = errors.New("unavailable")
+	clock.Advance(time.Second)
+	require.Nil(t, db.Delete([]byte("key")))
+	require.Equal(t, time.Unix(1, 0).Add(20*time.Millisecond), clock.Now())
+	require.Equal(t, "unavailable", db.Stats()["throttle.probe_error"])
+}
+
+func TestGoLevelDBLevel0Probe(t *testing.T) {
+	db, err := dbm.NewGoLevelDB("test", t.TempDir())
+	require.Nil(t, err)
+	defer db.Close()
+	pressure, err := GoLevelDBLevel0Probe(db)()
+	require.Nil(t, err)
+	require.Equal(t, float64(0), pressure)
+}
@@ -0,0 +1,196 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// ReadPreference tells which tiers of a TieredDB serve reads, see
+// ReadOptions.Preference.
+type ReadPreference int
+
+const (
+	// PreferDefault reads with the preference of the DB.
+	PreferDefault ReadPreference = iota
+	// LocalOnly never reads the remote tier, e.g. on consensus critical
+	// paths which must not touch the network.
+	LocalOnly
+	// RemoteOnly bypasses the local tier, e.g. to validate the archive.
+	RemoteOnly
+	// LocalThenRemote reads the remote tier on local misses.
+	LocalThenRemote
+	// RemoteThenLocal reads the local tier if the remote one fails.
+	RemoteThenLocal
+)
+
+func (p ReadPreference) String() string {
+	switch p {
+	case PreferDefault:
+		return "default"
+	case LocalOnly:
+		return "local-only"
+	case RemoteOnly:
+		return "remote-only"
+	case LocalThenRemote:
+		return "local-then-remote"
+	case RemoteThenLocal:
+		return "remote-then-local"
+	}
+	return fmt.Sprintf("ReadPreference(%d)", int(p))
+}
+
+// TieredDB serves reads from a hot local tier, such as the node's own DB,
+// and a remote one, such as an ArweaveDB, routed by a ReadPreference. Misses
+// of the remote tier, including ErrNotFound errors, are authoritative,
+// while those of the local tier aren't, as it only holds part of the data.
+// Values read remotely aren't written to the local tier. Writes go to the
+// local tier.
+type TieredDB struct {
+	dbm.DB
+	remote     dbm.DB
+	preference ReadPreference
+}
+
+var _ dbm.DB = (*TieredDB)(nil)
+
+// NewTieredDB returns a DB over local and remote, reading with preference
+// unless overridden by WithReadOptions. PreferDefault reads with
+// LocalThenRemote.
+func NewTieredDB(local, remote dbm.DB, preference ReadPreference) *TieredDB {
+	if preference == PreferDefault {
+		preference = LocalThenRemote
+	}
+	return &TieredDB{DB: local, remote: remote, preference: preference}
+}
+
+// Unwrap implements Unwrapper.
+func (db *TieredDB) Unwrap() dbm.DB {
+	return db.DB
+}
+
+// Remote returns the remote tier.
+func (db *TieredDB) Remote() dbm.DB {
+	return db.remote
+}
+
+// WithReadOptions returns a view of db reading with opts.Preference, unless
+// PreferDefault. An ArweaveDB remote tier reads with opts too.
+func (db *TieredDB) WithReadOptions(opts ReadOptions) *TieredDB {
+	view := *db
+	if opts.Preference != PreferDefault {
+		view.preference = opts.Preference
+	}
+	if remote, ok := db.remote.(*ArweaveDB); ok {
+		view.remote = remote.WithReadOptions(opts)
+	}
+	return &view
+}
+
+// Get implements DB.
+func (db *TieredDB) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := db.read("get", func(tier dbm.DB, remote bool) (bool, error) {
+		var err error
+		if value, err = tier.Get(key); err != nil {
+			if remote && errors.Is(err, ErrNotFound) {
+				return true, nil
+			}
+			return false, err
+		}
+		return value != nil || remote, nil
+	})
+	return value, err
+}
+
+// Has implements DB.
+func (db *TieredDB) Has(key []byte) (bool, error) {
+	var has bool
+	err := db.read("has", func(tier dbm.DB, remote bool) (bool, error) {
+		var err error
+		if has, err = tier.Has(key); err != nil {
+			return false, err
+		}
+		return has || remote, nil
+	})
+	return has, err
+}
+
+// read reads the tiers in the order of the preference of db until one
+// serves the read, failing with ErrPreferenceUnsatisfiable if none does.
+// read reports whether a tier served it, failures of the first tier being
+// retried with the second one.
+func (db *TieredDB) read(op string, read func(tier dbm.DB, remote bool) (bool, error)) error {
+	var lastErr error
+	for _, remote := range db.tiers() {
+		tier := db.DB
+		if remote {
+			tier = db.remote
+		}
+		served, err := read(tier, remote)
+		if err == nil && served {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return &ErrPreferenceUnsatisfiable{op: op, preference: db.preference, err: lastErr}
+}
+
+// tiers returns whether each tier to read in order is the remote one.
+func (db *TieredDB) tiers() []bool {
+	switch db.preference {
+	case LocalOnly:
+		return []bool{false}
+	case RemoteOnly:
+		return []bool{true}
+	case RemoteThenLocal:
+		return []bool{true, false}
+	}
+	return []bool{false, true}
+}
+
+// Iterator implements DB. Iterators read a single tier, the first one of
+// the preference, iterating the local tier only over what it holds. The
+// second tier, if any, is only read if the iterator can't be created on the
+// first one. Keys are returned as the tier returns them, ArweaveDBs
+// returning them without their version.
+func (db *TieredDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	return db.iterator("iterator", func(tier dbm.DB) (dbm.Iterator, error) {
+		return tier.Iterator(start, end)
+	})
+}
+
+// ReverseIterator implements DB, like Iterator.
+func (db *TieredDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	return db.iterator("reverse iterator", func(tier dbm.DB) (dbm.Iterator, error) {
+		return tier.ReverseIterator(start, end)
+	})
+}
+
+func (db *TieredDB) iterator(op string, create func(tier dbm.DB) (dbm.Iterator, error)) (dbm.Iterator, error) {
+	var lastErr error
+	for _, remote := range db.tiers() {
+		tier := db.DB
+		if remote {
+			tier = db.remote
+		}
+		iter, err := create(tier)
+		if err == nil {
+			return iter, nil
+		}
+		lastErr = err
+	}
+	return nil, &ErrPreferenceUnsatisfiable{op: op, preference: db.preference, err: lastErr}
+}
+
+// Close implements DB, closing both tiers.
+func (db *TieredDB) Close() error {
+	err := db.DB.Close()
+	if remoteErr := db.remote.Close(); err == nil {
+		err = remoteErr
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package backends
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// newTieredTestDB returns a TieredDB whose local tier holds the hot key with
+// value "local", its remote one both the hot and the cold keys with value
+// "remote", and which misses the key miss.
+func newTieredTestDB(t *testing.T, preference ReadPreference) *TieredDB {
+	local := dbm.NewMemDB()
+	require.NoError(t, local.Set(versionedKey(0, "hot"), []byte("local")))
+	remote := NewMockArweaveDB(
+		[][]byte{mockIndex([]string{"z"}, []int{0})},
+		[][]byte{mockTxData([]string{"cold", "hot"}, []string{"remote", "remote"})},
+		[]int{0},
+	)
+	return NewTieredDB(local, remote, preference)
+}
+
+func TestTieredDBReadPreferences(t *testing.T) {
+	unsatisfiable := "unsatisfiable"
+	for _, tc := range []struct {
+		preference ReadPreference
+		// expected values of the hot, cold and missed keys, with the remote
+		// tier serving and failing
+		serving, failing [3]string
+	}{
+		{LocalOnly, [3]string{"local", unsatisfiable, unsatisfiable}, [3]string{"local", unsatisfiable, unsatisfiable}},
+		{RemoteOnly, [3]string{"remote", "remote", ""}, [3]string{unsatisfiable, unsatisfiable, unsatisfiable}},
+		{LocalThenRemote, [3]string{"local", "remote", ""}, [3]string{"local", unsatisfiable, unsatisfiable}},
+		{RemoteThenLocal, [3]string{"remote", "remote", ""}, [3]string{"local", unsatisfiable, unsatisfiable}},
+	} {
+		for _, failing := range []bool{false, true} {
+			expected := tc.serving
+			if failing {
+				expected = tc.failing
+			}
+			// the preference is either the default of the DB or that of the
+			// read options
+			for _, db := range []*TieredDB{
+				newTieredTestDB(t, tc.preference),
+				newTieredTestDB(t, PreferDefault).WithReadOptions(ReadOptions{Preference: tc.preference}),
+			} {
+				remoteErr := errors.New("gateway down")
+				if failing {
+					ApplyMiddleware(db.Remote().(*ArweaveDB), func(next Getter) Getter {
+						return func(key []byte) ([]byte, error) {
+							return nil, remoteErr
+						}
+					})
+				}
+				for i, key := range []string{"hot", "cold", "miss"} {
+					value, err := db.Get(versionedKey(0, key))
+					has, hasErr := db.Has(versionedKey(0, key))
+					if expected[i] == unsatisfiable {
+						var unsatisfiableErr *ErrPreferenceUnsatisfiable
+						require.ErrorAs(t, err, &unsatisfiableErr, "%s %s", tc.preference, key)
+						require.Equal(t, tc.preference, unsatisfiableErr.Preference())
+						require.ErrorAs(t, hasErr, &unsatisfiableErr, "%s %s", tc.preference, key)
+						if failing && tc.preference != LocalOnly {
+							require.ErrorIs(t, err, remoteErr)
+						}
+						continue
+					}
+					require.NoError(t, err, "%s %s", tc.preference, key)
+					require.NoError(t, hasErr, "%s %s", tc.preference, key)
+					require.Equal(t, expected[i], string(value), "%s %s", tc.preference, key)
+					require.Equal(t, expected[i] != "", has, "%s %s", tc.preference, key)
+				}
+			}
+		}
+	}
+}
+
+func TestTieredDBIteratorsReadOneTier(t *testing.T) {
+	keys := func(iter dbm.Iterator) []string {
+		defer iter.Close()
+		keys := []string{}
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Error())
+		return keys
+	}
+	for preference, expected := range map[ReadPreference][]string{
+		// iterators return the keys as the tier does, versioned for the
+		// local one
+		LocalOnly:       {string(versionedKey(0, "hot"))},
+		LocalThenRemote: {string(versionedKey(0, "hot"))},
+		RemoteOnly:      {"cold", "hot"},
+		RemoteThenLocal: {"cold", "hot"},
+	} {
+		db := newTieredTestDB(t, preference)
+		iter, err := db.Iterator(versionedKey(0, ""), versionedKey(0, "\xff"))
+		require.NoError(t, err)
+		require.Equal(t, expected, keys(iter), preference.String())
+
+		iter, err = db.ReverseIterator(versionedKey(0, ""), versionedKey(0, "\xff"))
+		require.NoError(t, err)
+		reversed := keys(iter)
+		for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+			reversed[i], reversed[j] = reversed[j], reversed[i]
+		}
+		require.Equal(t, expected, reversed, preference.String())
+	}
+}
+
+func TestTieredDBWritesToLocalTier(t *testing.T) {
+	db := newTieredTestDB(t, RemoteOnly)
+	require.NoError(t, db.Set(versionedKey(0, "new"), []byte("v")))
+	value, err := db.Unwrap().Get(versionedKey(0, "new"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v"), value)
+
+	value, err = db.WithReadOptions(ReadOptions{Preference: LocalOnly}).Get(versionedKey(0, "new"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v"), value)
+}
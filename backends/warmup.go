@@ -0,0 +1,115 @@
+package backends
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+type WarmupSpec struct {
+	Prefixes []WarmupPrefix
+	// Concurrency bounds the number of prefixes warmed up in parallel.
+	// Defaults to 1.
+	Concurrency int
+}
+
+type WarmupPrefix struct {
+	Prefix []byte
+	// MaxKeys is the maximum number of keys touched under Prefix. Zero means
+	// no limit.
+	MaxKeys int
+}
+
+type WarmupReport struct {
+	KeysTouched  int
+	BytesTouched int64
+	Elapsed      time.Duration
+}
+
+// Warmup iterates over the given prefixes of db, reading every value up to
+// the per-prefix key budget so that the backend's block caches are filled.
+// It stops promptly when ctx is cancelled and reports what has been touched
+// so far along with the context error.
+func Warmup(ctx context.Context, db dbm.DB, spec WarmupSpec) (WarmupReport, error) {
+	start := time.Now()
+	concurrency := spec.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	report := WarmupReport{}
+	var (
+		mtx      sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, prefix := range spec.Prefixes {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(prefix WarmupPrefix) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			keys, bytes, err := warmupPrefix(ctx, db, prefix)
+			mtx.Lock()
+			defer mtx.Unlock()
+			report.KeysTouched += keys
+			report.BytesTouched += bytes
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(prefix)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	report.Elapsed = time.Since(start)
+	return report, firstErr
+}
+
+func warmupPrefix(ctx context.Context, db dbm.DB, prefix WarmupPrefix) (int, int64, error) {
+	var start, end []byte
+	if len(prefix.Prefix) > 0 {
+		start, end = prefix.Prefix, prefixEnd(prefix.Prefix)
+	}
+	iter, err := db.Iterator(start, end)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer iter.Close()
+	keys, bytes := 0, int64(0)
+	for ; iter.Valid(); iter.Next() {
+		if prefix.MaxKeys > 0 && keys >= prefix.MaxKeys {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return keys, bytes, err
+		}
+		bytes += int64(len(iter.Key()) + len(iter.Value()))
+		keys++
+	}
+	return keys, bytes, iter.Error()
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func newWarmupTestDB(t *testing.T) dbm.DB {
+	db := dbm.NewMemDB()
+	for _, prefix := range []string{"a/", "b/", "c/"} {
+		for i := 0; i < 100; i++ {
+			require.Nil(t, db.Set([]byte(fmt.Sprintf("%s%03d", prefix, i)), []byte("value")))
+		}
+	}
+	return db
+}
+
+func TestWarmupBudgets(t *testing.T) {
+	db := newWarmupTestDB(t)
+	report, err := Warmup(context.Background(), db, WarmupSpec{
+		Prefixes: []WarmupPrefix{
+			{Prefix: []byte("a/"), MaxKeys: 10},
+			{Prefix: []byte("b/")},
+			{Prefix: []byte("d/"), MaxKeys: 10},
+		},
+		Concurrency: 2,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 110, report.KeysTouched)
+	require.Equal(t, int64(110*(5+5)), report.BytesTouched)
+}
+
+// cancellingDB cancels a context once a given number of values were read
+// through its iterators.
+type cancellingDB struct {
+	dbm.DB
+	cancel    context.CancelFunc
+	remaining int
+}
+
+type cancellingIterator struct {
+	dbm.Iterator
+	db *cancellingDB
+}
+
+func (db *cancellingDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	iter, err := db.DB.Iterator(start, end)
+	return &cancellingIterator{Iterator: iter, db: db}, err
+}
+
+func (itr *cancellingIterator) Value() []byte {
+	itr.db.remaining--
+	if itr.db.remaining == 0 {
+		itr.db.cancel()
+	}
+	return itr.Iterator.Value()
+}
+
+func TestWarmupCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db := &cancellingDB{DB: newWarmupTestDB(t), cancel: cancel, remaining: 5}
+	report, err := Warmup(ctx, db, WarmupSpec{
+		Prefixes: []WarmupPrefix{{Prefix: []byte("a/")}, {Prefix: []byte("b/")}},
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 5, report.KeysTouched)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	require.Equal(t, []byte("b"), prefixEnd([]byte("a")))
+	require.Equal(t, []byte{0x01}, prefixEnd([]byte{0x00, 0xff}))
+	require.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
@@ -0,0 +1,183 @@
+// Package logstore stores append-only logs of records ordered by sequence
+// number in a DB.
+package logstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Under the prefix of a log, the next sequence number is stored under
+// nextSeqTag, and records under recordTag followed by their big endian
+// sequence number.
+const (
+	nextSeqTag byte = 0x00
+	recordTag  byte = 0x01
+
+	pruneBatchSize = 1000
+)
+
+var ErrEmpty = errors.New("log is empty")
+
+// RangeDeleter is implemented by DBs able to delete a range of keys at
+// once, which PruneBelow then uses.
+type RangeDeleter interface {
+	DeleteRange(start, end []byte) error
+}
+
+// Log is an append-only log of records stored under a prefix of a DB.
+// Sequence numbers start at 1 and are never reused, even once pruned.
+type Log struct {
+	db     dbm.DB
+	prefix []byte
+
+	// mtx serializes appends so that records are written in sequence order
+	mtx     sync.Mutex
+	nextSeq uint64
+}
+
+// NewLog opens the log stored under prefix in db, continuing the sequence
+// of records appended before. No other key may start with prefix.
+func NewLog(db dbm.DB, prefix []byte) (*Log, error) {
+	l := &Log{db: db, prefix: append([]byte{}, prefix...), nextSeq: 1}
+	bz, err := db.Get(l.nextSeqKey())
+	if err != nil {
+		return nil, err
+	}
+	if bz != nil {
+		if len(bz) != 8 {
+			return nil, fmt.Errorf("next sequence number of log %X is %d bytes long", prefix, len(bz))
+		}
+		l.nextSeq = binary.BigEndian.Uint64(bz)
+	}
+	return l, nil
+}
+
+func (l *Log) nextSeqKey() []byte {
+	return append(append([]byte{}, l.prefix...), nextSeqTag)
+}
+
+func (l *Log) recordKey(seq uint64) []byte {
+	key := make([]byte, len(l.prefix)+1+8)
+	copy(key, l.prefix)
+	key[len(l.prefix)] = recordTag
+	binary.BigEndian.PutUint64(key[len(l.prefix)+1:], seq)
+	return key
+}
+
+// recordsEnd is the exclusive end of the records of the log.
+func (l *Log) recordsEnd() []byte {
+	return append(append([]byte{}, l.prefix...), recordTag+1)
+}
+
+// Append durably appends a record and returns its sequence number. The
+// record and the next sequence number are written in one batch, so that a
+// crash never leads to a sequence number being reused.
+func (l *Log) Append(value []byte) (uint64, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	seq := l.nextSeq
+	next := make([]byte, 8)
+	binary.BigEndian.PutUint64(next, seq+1)
+	batch := l.db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set(l.recordKey(seq), value); err != nil {
+		return 0, err
+	}
+	if err := batch.Set(l.nextSeqKey(), next); err != nil {
+		return 0, err
+	}
+	if err := batch.WriteSync(); err != nil {
+		return 0, err
+	}
+	l.nextSeq++
+	return seq, nil
+}
+
+// Read returns an iterator over the records from sequence number from to
+// to, excluded, in ascending order. A to of 0 reads up to the last record.
+func (l *Log) Read(from, to uint64) (*Iterator, error) {
+	end := l.recordsEnd()
+	if to != 0 {
+		end = l.recordKey(to)
+	}
+	iter, err := l.db.Iterator(l.recordKey(from), end)
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator{Iterator: iter, prefixLen: len(l.prefix) + 1}, nil
+}
+
+// Last returns the last record and its sequence number, or ErrEmpty if the
+// log has no record.
+func (l *Log) Last() (uint64, []byte, error) {
+	iter, err := l.db.ReverseIterator(l.recordKey(0), l.recordsEnd())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer iter.Close()
+	if !iter.Valid() {
+		if err := iter.Error(); err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, ErrEmpty
+	}
+	return binary.BigEndian.Uint64(iter.Key()[len(l.prefix)+1:]), append([]byte{}, iter.Value()...), nil
+}
+
+// PruneBelow deletes the records with a sequence number below seq, in one
+// call if the DB is a RangeDeleter, or in batches otherwise.
+func (l *Log) PruneBelow(seq uint64) error {
+	start, end := l.recordKey(0), l.recordKey(seq)
+	if deleter, ok := l.db.(RangeDeleter); ok {
+		return deleter.DeleteRange(start, end)
+	}
+	for {
+		keys, err := l.keys(start, end, pruneBatchSize)
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		batch := l.db.NewBatch()
+		for _, key := range keys {
+			if err := batch.Delete(key); err != nil {
+				batch.Close()
+				return err
+			}
+		}
+		if err := batch.Write(); err != nil {
+			batch.Close()
+			return err
+		}
+		batch.Close()
+	}
+}
+
+// keys returns up to limit keys from start to end. The iterator is closed
+// before returning so the caller may write.
+func (l *Log) keys(start, end []byte, limit int) ([][]byte, error) {
+	iter, err := l.db.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	keys := [][]byte{}
+	for ; iter.Valid() && len(keys) < limit; iter.Next() {
+		keys = append(keys, append([]byte{}, iter.Key()...))
+	}
+	return keys, iter.Error()
+}
+
+// Iterator iterates over the records of a log.
+type Iterator struct {
+	dbm.Iterator
+	prefixLen int
+}
+
+// Seq returns the sequence number of the current record.
+func (itr *Iterator) Seq() uint64 {
+	return binary.BigEndian.Uint64(itr.Key()[itr.prefixLen:])
+}
@@ -0,0 +1,165 @@
+package logstore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+func forEachDB(t *testing.T, fn func(t *testing.T, db dbm.DB)) {
+	for name, newDB := range map[string]func(t *testing.T) dbm.DB{
+		"memdb": func(*testing.T) dbm.DB { return dbm.NewMemDB() },
+		"goleveldb": func(t *testing.T) dbm.DB {
+			db, err := dbm.NewGoLevelDB("test", t.TempDir())
+			require.Nil(t, err)
+			return db
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := newDB(t)
+			defer db.Close()
+			fn(t, db)
+		})
+	}
+}
+
+type record struct {
+	seq   uint64
+	value string
+}
+
+func readAll(t *testing.T, l *Log, from, to uint64) []record {
+	iter, err := l.Read(from, to)
+	require.Nil(t, err)
+	defer iter.Close()
+	records := []record{}
+	for ; iter.Valid(); iter.Next() {
+		records = append(records, record{seq: iter.Seq(), value: string(iter.Value())})
+	}
+	require.Nil(t, iter.Error())
+	return records
+}
+
+func TestConcurrentAppends(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db dbm.DB) {
+		l, err := NewLog(db, []byte("log"))
+		require.Nil(t, err)
+		other, err := NewLog(db, []byte("other"))
+		require.Nil(t, err)
+		_, err = other.Append([]byte("other"))
+		require.Nil(t, err)
+
+		const appenders, appends = 8, 100
+		var wg sync.WaitGroup
+		seqs := make([][]uint64, appenders)
+		for i := 0; i < appenders; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < appends; j++ {
+					seq, err := l.Append([]byte(fmt.Sprintf("%d-%d", i, j)))
+					require.Nil(t, err)
+					seqs[i] = append(seqs[i], seq)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		values := map[uint64]string{}
+		for i, appended := range seqs {
+			for j, seq := range appended {
+				_, dup := values[seq]
+				require.False(t, dup, "sequence %d assigned twice", seq)
+				values[seq] = fmt.Sprintf("%d-%d", i, j)
+				// each appender sees increasing sequence numbers
+				if j > 0 {
+					require.Greater(t, seq, appended[j-1])
+				}
+			}
+		}
+		records := readAll(t, l, 0, 0)
+		require.Len(t, records, appenders*appends)
+		for i, r := range records {
+			require.Equal(t, uint64(i+1), r.seq)
+			require.Equal(t, values[r.seq], r.value)
+		}
+		require.Equal(t, []record{{seq: 1, value: "other"}}, readAll(t, other, 0, 0))
+	})
+}
+
+func TestReadLastPrune(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db dbm.DB) {
+		l, err := NewLog(db, []byte("log"))
+		require.Nil(t, err)
+		_, _, err = l.Last()
+		require.Equal(t, ErrEmpty, err)
+		// sequence numbers past a byte boundary sort after smaller ones
+		for i := 1; i <= 300; i++ {
+			seq, err := l.Append([]byte(fmt.Sprint(i)))
+			require.Nil(t, err)
+			require.Equal(t, uint64(i), seq)
+		}
+		require.Equal(t, []record{{255, "255"}, {256, "256"}, {257, "257"}}, readAll(t, l, 255, 258))
+		seq, value, err := l.Last()
+		require.Nil(t, err)
+		require.Equal(t, uint64(300), seq)
+		require.Equal(t, "300", string(value))
+
+		require.Nil(t, l.PruneBelow(299))
+		require.Equal(t, []record{{299, "299"}, {300, "300"}}, readAll(t, l, 0, 0))
+		require.Nil(t, l.PruneBelow(1000))
+		_, _, err = l.Last()
+		require.Equal(t, ErrEmpty, err)
+
+		// sequence numbers continue once reopened, even if all records
+		// were pruned
+		l, err = NewLog(db, []byte("log"))
+		require.Nil(t, err)
+		seq, err = l.Append([]byte("next"))
+		require.Nil(t, err)
+		require.Equal(t, uint64(301), seq)
+	})
+}
+
+// rangeDeletingDB counts range deletions, deleting keys one by one.
+type rangeDeletingDB struct {
+	dbm.DB
+	calls int
+}
+
+func (db *rangeDeletingDB) DeleteRange(start, end []byte) error {
+	db.calls++
+	iter, err := db.Iterator(start, end)
+	if err != nil {
+		return err
+	}
+	keys := [][]byte{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	for _, key := range keys {
+		if err := db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPruneBelowUsesDeleteRange(t *testing.T) {
+	db := &rangeDeletingDB{DB: dbm.NewMemDB()}
+	l, err := NewLog(db, []byte("log"))
+	require.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]byte("value"))
+		require.Nil(t, err)
+	}
+	require.Nil(t, l.PruneBelow(5))
+	require.Equal(t, 1, db.calls)
+	records := readAll(t, l, 0, 0)
+	require.Len(t, records, 6)
+	require.Equal(t, uint64(5), records[0].seq)
+}